@@ -39,6 +39,7 @@ type Actor interface {
 	SetPublicKey(interface{}) error
 	GetName() string
 	CheckPermEdit(map[string]interface{}, string) util.Gerror
+	UpdateLastAuth() error
 }
 
 // Gets the actor making the request. If use-auth is not on, always returns 
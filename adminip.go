@@ -0,0 +1,52 @@
+/*
+ * Copyright (c) 2013-2014, Jeremy Bingham (<jbingham@gmail.com>)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"net/http"
+	"github.com/ctdk/goiardi/config"
+	"git.tideland.biz/goas/logger"
+)
+
+/* Wrap the handlers for administrative endpoints so they can only be reached
+ * from the networks in admin-ip-allow, if any were given. This is on top of,
+ * not instead of, the handlers' own admin checks. */
+func adminIPCheck(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !adminIPAllowed(w, r) {
+			return
+		}
+		h(w, r)
+	}
+}
+
+/* The same check, for administrative endpoints that share a handler with
+ * ordinary ones, like cookbook imports or disabling clients. If the request
+ * isn't from an allowed network, a 403 is sent and false returned. */
+func adminIPAllowed(w http.ResponseWriter, r *http.Request) bool {
+	if len(config.Config.AdminIPAllowNets) == 0 {
+		return true
+	}
+	ip := requestClientIP(r)
+	if !config.IPInNets(ip, config.Config.AdminIPAllowNets) {
+		logger.Warningf("Rejecting %s %s from %s: not in admin-ip-allow", r.Method, r.URL.Path, ip)
+		w.Header().Set("Content-Type", "application/json")
+		JsonErrorReport(w, r, "You are not allowed to perform that action from this address.", http.StatusForbidden)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,59 @@
+/* Reporting what goiardi expected a failed signed request to look like */
+
+/*
+ * Copyright (c) 2013-2014, Jeremy Bingham (<jbingham@gmail.com>)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"github.com/ctdk/goiardi/authentication"
+	"github.com/ctdk/goiardi/config"
+	"git.tideland.biz/goas/logger"
+)
+
+/* With --auth-debug on, a request that fails because its signature didn't
+ * match gets the canonical request string goiardi computed, and what the
+ * client signed if that could be decrypted, along with the usual error. Like
+ * the administrative endpoints, this is only done for requests from the
+ * admin-ip-allow networks if any were given. Returns false if the error
+ * should be reported the usual way instead. */
+func authDebugReport(w http.ResponseWriter, r *http.Request, herr error) bool {
+	if !config.Config.AuthDebug {
+		return false
+	}
+	serr, ok := herr.(*authentication.SignatureError)
+	if !ok {
+		return false
+	}
+	if len(config.Config.AdminIPAllowNets) != 0 && !config.IPInNets(requestClientIP(r), config.Config.AdminIPAllowNets) {
+		return false
+	}
+	debug_response := map[string]interface{}{
+		"error": []string{ serr.Error() },
+		"canonical_request": serr.Canonical,
+	}
+	if serr.Signed != "" {
+		debug_response["signed_request"] = serr.Signed
+	}
+	w.WriteHeader(serr.Status())
+	enc := json.NewEncoder(w)
+	if err := enc.Encode(&debug_response); err != nil {
+		logger.Errorf(err.Error())
+	}
+	return true
+}
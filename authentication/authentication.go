@@ -23,8 +23,10 @@ package authentication
 import (
 	"github.com/ctdk/goiardi/chef_crypto"
 	"github.com/ctdk/goiardi/actor"
+	"github.com/ctdk/goiardi/client"
 	"github.com/ctdk/goiardi/util"
 	"github.com/ctdk/goiardi/config"
+	"git.tideland.biz/goas/logger"
 	"net/http"
 	"io"
 	"io/ioutil"
@@ -39,6 +41,21 @@ import (
 	"fmt"
 )
 
+// An authentication failure where the request's signature didn't check out.
+// Along with the error, it carries the canonical request string goiardi
+// computed and expected the client to have signed, and what the client
+// actually signed if its signature could be decrypted, so the two can be
+// compared when debugging. Neither contains any secrets.
+type SignatureError struct {
+	util.Gerror
+	Canonical string
+	Signed string
+}
+
+func sigErr(gerr util.Gerror, canonical string, signed string) util.Gerror {
+	return &SignatureError{ Gerror: gerr, Canonical: canonical, Signed: signed }
+}
+
 // Check the signed headers sent by the client against the expected result
 // assembled from the request headers to verify their authorization.
 func CheckHeader(user_id string, r *http.Request) util.Gerror {
@@ -61,7 +78,7 @@ func CheckHeader(user_id string, r *http.Request) util.Gerror {
 		return gerr
 	} else {
 		// check the time stamp w/ allowed slew
-		tok, terr := checkTimeStamp(authTimestamp, config.Config.TimeSlewDur)
+		tok, terr := checkTimeStamp(authTimestamp, config.TimeSlew())
 		if !tok {
 			return terr
 		}
@@ -103,31 +120,52 @@ func CheckHeader(user_id string, r *http.Request) util.Gerror {
 	if chkerr != nil {
 		return chkerr
 	}
+	headToCheck := assembleHeaderToCheck(r, chkHash, apiVer)
 	if chkHash != contentHash {
-		gerr := util.Errorf("Content hash did not match hash of request body")
+		gerr := util.Errorf("Content hash did not match hash of request body. The X-Ops-Content-Hash header must be the base64 encoded SHA1 hash of the request body as sent.")
 		gerr.SetStatus(http.StatusUnauthorized)
-		return gerr
+		gerr.SetCode("content_hash_mismatch")
+		return sigErr(gerr, headToCheck, "")
 	}
 
 	signedHeaders, sherr  := assembleSignedHeader(r)
 	if sherr != nil {
 		return sherr
 	}
-	headToCheck := assembleHeaderToCheck(r, chkHash, apiVer)
 
 	decHead, berr := chef_crypto.HeaderDecrypt(user.PublicKey(), signedHeaders)
 
+	/* The decryption error itself can include the actor's key, so it
+	 * only goes in the log. */
 	if berr != nil {
-		gerr := util.Errorf(berr.Error())
+		logger.Debugf("Error decrypting signed headers for %s: %s", user_id, berr.Error())
+		gerr := util.Errorf("Could not decrypt the request signature with the public key for '%s'. Ensure that your node_name and client key are correct.", user_id)
 		gerr.SetStatus(http.StatusUnauthorized)
-		return gerr
+		gerr.SetCode("signature_invalid")
+		return sigErr(gerr, headToCheck, "")
 	}
 	if string(decHead) != headToCheck {
-		gerr := util.Errorf("failed to verify authorization")
+		gerr := util.Errorf("Request signature did not match the request. Ensure that your node_name and client key are correct and that the request was not altered after it was signed.")
 		gerr.SetStatus(http.StatusUnauthorized)
+		gerr.SetCode("signature_mismatch")
+		return sigErr(gerr, headToCheck, string(decHead))
+	}
+
+	/* Clients that have been disabled, say because their key may have
+	 * been compromised, are turned away even with a good signature. */
+	if c, ok := user.(*client.Client); ok && c.IsDisabled() {
+		gerr := util.Errorf("Client %s is disabled", c.Name)
+		gerr.SetStatus(http.StatusForbidden)
 		return gerr
 	}
 
+	/* The request checks out, so note when this actor last
+	 * authenticated. Failing to record that shouldn't fail the request,
+	 * though. */
+	if lerr := user.UpdateLastAuth(); lerr != nil {
+		logger.Errorf("Error recording last authentication time for %s: %s", user.GetName(), lerr.Error())
+	}
+
 	return nil
 }
 
@@ -185,8 +223,9 @@ func checkTimeStamp(timestamp string, slew time.Duration) (bool, util.Gerror) {
 	timeNow := time.Now().UTC()
 	timeHeader, terr := time.Parse(time.RFC3339, timestamp)
 	if terr != nil {
-		err := util.Errorf(terr.Error())
+		err := util.Errorf("Malformed X-Ops-Timestamp header '%s': it must be an ISO 8601 UTC timestamp", timestamp)
 		err.SetStatus(http.StatusUnauthorized)
+		err.SetCode("bad_timestamp")
 		return false, err
 	}
 	tdiff := timeNow.Sub(timeHeader)
@@ -195,8 +234,9 @@ func checkTimeStamp(timestamp string, slew time.Duration) (bool, util.Gerror) {
 		tdiff = -tdiff
 	}
 	if tdiff > slew {
-		err := util.Errorf("Authentication failed. Please check your system's clock.")
+		err := util.Errorf("Authentication failed: the request timestamp is %s away from the server's time, which is more than the allowed %s. Please check your system's clock.", tdiff - tdiff % time.Second, slew)
 		err.SetStatus(http.StatusUnauthorized)
+		err.SetCode("clock_skew")
 		return false, err
 	}
 	return true, nil
@@ -17,6 +17,16 @@
 package authentication
 
 import (
+	"crypto"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"github.com/ctdk/goiardi/client"
+	"github.com/ctdk/goiardi/config"
+	"net/http"
+	"strconv"
+	"strings"
 	"testing"
 	"time"
 )
@@ -60,4 +70,105 @@ func TestTimeSlewFail(t *testing.T) {
 	if tok {
 		t.Errorf("Time %s one hour in the past should have failed, but didn't", terr)
 	}
+	if terr.Code() != "clock_skew" || terr.Status() != http.StatusUnauthorized {
+		t.Errorf("Expected a 401 clock_skew error, got %d %s", terr.Status(), terr.Code())
+	}
+	_, terr = checkTimeStamp("yesterday", dur)
+	if terr == nil || terr.Code() != "bad_timestamp" {
+		t.Errorf("Expected a bad_timestamp error for a malformed timestamp, got %v", terr)
+	}
+}
+
+func TestSignatureErrorCanonical(t *testing.T) {
+	c, _ := client.New("authdebug")
+	c.Save()
+	config.Config.UseAuth = true
+	defer func() { config.Config.UseAuth = false }()
+	config.Config.TimeSlewDur = 15 * time.Minute
+	timestamp := time.Now().UTC().Format(time.RFC3339)
+
+	req, _ := http.NewRequest("GET", "http://localhost/nodes", nil)
+	req.Header.Set("X-Ops-Content-Hash", "notthehash")
+	req.Header.Set("X-Ops-Timestamp", timestamp)
+	req.Header.Set("X-Ops-Sign", "version=1.0")
+	req.Header.Set("X-Ops-Userid", "authdebug")
+	err := CheckHeader("authdebug", req)
+	serr, ok := err.(*SignatureError)
+	if !ok {
+		t.Fatalf("Expected a SignatureError for a bad content hash, got %v", err)
+	}
+	if serr.Status() != http.StatusUnauthorized {
+		t.Errorf("Expected status %d, got %d", http.StatusUnauthorized, serr.Status())
+	}
+	if serr.Code() != "content_hash_mismatch" {
+		t.Errorf("Expected code content_hash_mismatch, got %s", serr.Code())
+	}
+	want := []string{ "Method:GET\n", "Hashed Path:" + hashStr("/nodes") + "\n", "X-Ops-Content-Hash:" + hashStr("") + "\n", "X-Ops-Timestamp:" + timestamp + "\n", "X-Ops-UserId:authdebug" }
+	for _, w := range want {
+		if !strings.Contains(serr.Canonical, w) {
+			t.Errorf("Canonical request %q is missing %q", serr.Canonical, w)
+		}
+	}
+}
+
+/* Sign a request the way chef clients do, with the given private key. */
+func signRequest(t *testing.T, req *http.Request, user_id string, priv_pem string) {
+	block, _ := pem.Decode([]byte(priv_pem))
+	if block == nil {
+		t.Fatalf("Couldn't decode the private key")
+	}
+	priv, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("X-Ops-Content-Hash", hashStr(""))
+	req.Header.Set("X-Ops-Timestamp", time.Now().UTC().Format(time.RFC3339))
+	req.Header.Set("X-Ops-Sign", "version=1.0")
+	req.Header.Set("X-Ops-Userid", user_id)
+	head := assembleHeaderToCheck(req, hashStr(""), "1.0")
+	sig, err := rsa.SignPKCS1v15(nil, priv, crypto.Hash(0), []byte(head))
+	if err != nil {
+		t.Fatal(err)
+	}
+	enc := base64.StdEncoding.EncodeToString(sig)
+	for i := 0; i * 60 < len(enc); i++ {
+		end := (i + 1) * 60
+		if end > len(enc) {
+			end = len(enc)
+		}
+		req.Header.Set("X-Ops-Authorization-" + strconv.Itoa(i + 1), enc[i * 60:end])
+	}
+}
+
+func TestDisabledClient(t *testing.T) {
+	c, _ := client.New("authdisabled")
+	priv_pem, err := c.GenerateKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Save()
+	config.Config.UseAuth = true
+	defer func() { config.Config.UseAuth = false }()
+	config.Config.TimeSlewDur = 15 * time.Minute
+
+	req, _ := http.NewRequest("GET", "http://localhost/nodes", nil)
+	signRequest(t, req, "authdisabled", priv_pem)
+	if gerr := CheckHeader("authdisabled", req); gerr != nil {
+		t.Fatalf("A correctly signed request from an enabled client failed: %s", gerr.Error())
+	}
+
+	c.SetDisabled(true)
+	req, _ = http.NewRequest("GET", "http://localhost/nodes", nil)
+	signRequest(t, req, "authdisabled", priv_pem)
+	gerr := CheckHeader("authdisabled", req)
+	if gerr == nil || gerr.Status() != http.StatusForbidden {
+		t.Errorf("A request from a disabled client should have gotten a 403, got %v", gerr)
+	}
+
+	c.SetDisabled(false)
+	req, _ = http.NewRequest("GET", "http://localhost/nodes", nil)
+	signRequest(t, req, "authdisabled", priv_pem)
+	if gerr := CheckHeader("authdisabled", req); gerr != nil {
+		t.Errorf("A request from a reenabled client failed: %s", gerr.Error())
+	}
 }
@@ -37,8 +37,16 @@ import (
 	"encoding/gob"
 	"bytes"
 	"database/sql"
+	"sync"
+	"time"
 )
 
+/* Successful authentications update the client they authenticated as in
+ * place, while other requests may be reading or deleting the same client.
+ * stateLock covers the last authentication time of in-memory clients, and is
+ * held while deleting one so an update can't put it back afterwards. */
+var stateLock sync.RWMutex
+
 // A client and a user are very similar, with some small differences - users 
 // can never be validators, while clients don't have passwords. Generally nodes 
 // and the like will be clients, while people interacting with the goiardi 
@@ -53,6 +61,8 @@ type Client struct {
 	pubKey string `json:"public_key"`
 	Admin bool `json:"admin"`
 	Certificate string `json:"certificate"`
+	LastAuth time.Time `json:"last_auth"`
+	Disabled bool `json:"disabled"`
 }
 
 // for gob encoding. Needed the json tags for flattening, but that's handled
@@ -68,6 +78,8 @@ type privClient struct {
 	PublicKey *string `json:"public_key"`
 	Admin *bool `json:"admin"`
 	Certificate *string `json:"certificate"`
+	LastAuth *time.Time `json:"last_auth"`
+	Disabled *bool `json:"disabled"`
 }
 
 // For flattening. Needs the json tags for flattening.
@@ -87,9 +99,9 @@ type flatClient struct {
 func New(clientname string) (*Client, util.Gerror){
 	var found bool
 	var err util.Gerror
-	if config.Config.UseMySQL {
+	if config.Config.UseSQL {
 		var cerr error
-		found, cerr = checkForClientMySQL(data_store.Dbh, clientname)
+		found, cerr = checkForClientSQL(data_store.Dbh, clientname)
 		if cerr != nil {
 			err := util.Errorf(err.Error())
 			err.SetStatus(http.StatusInternalServerError)
@@ -126,8 +138,8 @@ func Get(clientname string) (*Client, util.Gerror){
 	var client *Client
 	var err error
 
-	if config.Config.UseMySQL {
-		client, err = getClientMySQL(clientname)
+	if config.Config.UseSQL {
+		client, err = getClientSQL(clientname)
 		if err != nil {
 			var gerr util.Gerror
 			if err != sql.ErrNoRows {
@@ -157,8 +169,8 @@ func Get(clientname string) (*Client, util.Gerror){
 // Save the client. If a user with the same name as the client exists, returns
 // an error. Additionally, if running with MySQL it will return any DB error.
 func (c *Client) Save() error {
-	if config.Config.UseMySQL {
-		err := c.saveMySQL()
+	if config.Config.UseSQL {
+		err := c.saveSQL()
 		if err != nil {
 			return err
 		}
@@ -183,14 +195,16 @@ func (c *Client) Delete() error {
 		return err
 	}
 
-	if config.Config.UseMySQL {
-		err := c.deleteMySQL()
+	if config.Config.UseSQL {
+		err := c.deleteSQL()
 		if err != nil {
 			return err
 		}
 	} else {
+		stateLock.Lock()
 		ds := data_store.New()
 		ds.Delete("client", c.Name)
+		stateLock.Unlock()
 	}
 	indexer.DeleteItemFromCollection("client", c.Name)
 	return nil
@@ -206,6 +220,12 @@ func (c *Client) ToJson() map[string]interface{} {
 	toJson["validator"] = c.Validator
 	toJson["json_class"] = c.JsonClass
 	toJson["chef_type"] = c.ChefType
+	if la := c.lastAuth(); la.IsZero() {
+		toJson["last_auth"] = nil
+	} else {
+		toJson["last_auth"] = la.UTC().Format(time.RFC3339)
+	}
+	toJson["disabled"] = c.IsDisabled()
 
 	return toJson
 }
@@ -213,8 +233,8 @@ func (c *Client) ToJson() map[string]interface{} {
 func (c *Client) isLastAdmin() bool {
 	if c.Admin {
 		numAdmins := 0
-		if config.Config.UseMySQL {
-			numAdmins = numAdminsMySQL()
+		if config.Config.UseSQL {
+			numAdmins = numAdminsSQL()
 		} else {
 			clist := GetList()
 			for _, cc := range clist {
@@ -243,8 +263,8 @@ func (c *Client) Rename(new_name string) util.Gerror {
 		return err
 	}
 
-	if config.Config.UseMySQL {
-		err := c.renameMySQL(new_name)
+	if config.Config.UseSQL {
+		err := c.renameSQL(new_name)
 		if err != nil {
 			return err
 		}
@@ -297,7 +317,7 @@ func (c *Client)UpdateFromJson(json_actor map[string]interface{}) util.Gerror {
 
 	/* Validations. */
 	/* Invalid top level elements */
-	valid_elements := []string{ "name", "json_class", "chef_type", "validator", "org_name", "public_key", "private_key", "admin", "certificate", "password" }
+	valid_elements := []string{ "name", "json_class", "chef_type", "validator", "org_name", "public_key", "private_key", "admin", "certificate", "password", "last_auth", "disabled" }
 	ValidElem:
 	for k, _ := range json_actor {
 		for _, i := range valid_elements {
@@ -354,6 +374,22 @@ func (c *Client)UpdateFromJson(json_actor map[string]interface{}) util.Gerror {
 			}
 		}
 	}
+	/* Disabling a client goes through _disable and _enable, which can be
+	 * limited to admin-ip-allow, so it can't be changed here. Sending back
+	 * what the client already has is fine, though. */
+	if disabled_val, ok := json_actor["disabled"]; ok {
+		db, derr := util.ValidateAsBool(disabled_val)
+		if derr != nil {
+			verr = util.Errorf("Field 'disabled' invalid")
+			verr.SetStatus(http.StatusBadRequest)
+			return verr
+		}
+		if db != c.IsDisabled() {
+			verr = util.Errorf("A client can only be disabled or enabled with the _disable and _enable endpoints")
+			verr.SetStatus(http.StatusBadRequest)
+			return verr
+		}
+	}
 	if validator_val, ok := json_actor["validator"]; ok {
 		if vb, verr = util.ValidateAsBool(validator_val); verr != nil {
 			return verr
@@ -387,8 +423,8 @@ func ValidatePublicKey(publicKey interface{}) (bool, util.Gerror) {
 // Returns a list of clients.
 func GetList() []string {
 	var client_list []string
-	if config.Config.UseMySQL {
-		client_list = getListMySQL()
+	if config.Config.UseSQL {
+		client_list = getListSQL()
 	} else {
 		ds := data_store.New()
 		client_list = ds.GetList("client")
@@ -396,6 +432,96 @@ func GetList() []string {
 	return client_list
 }
 
+// Returns part of the list of clients, sorted by name, starting at offset and
+// holding at most limit of them, along with the total number of clients. A
+// negative limit returns everything after the offset.
+func GetListPage(offset int, limit int) ([]string, int) {
+	return GetSortedListPage(offset, limit, false)
+}
+
+// Like GetListPage, but the clients can be sorted by name in descending order
+// instead.
+func GetSortedListPage(offset int, limit int, desc bool) ([]string, int) {
+	if config.Config.UseSQL {
+		return getListPageSQL(offset, limit, desc)
+	}
+	client_list := data_store.SortList(GetList(), desc)
+	return data_store.PageList(client_list, offset, limit), len(client_list)
+}
+
+// Returns a list of clients that have never successfully authenticated with
+// the server.
+func GetNeverUsedList() []string {
+	var client_list []string
+	if config.Config.UseSQL {
+		client_list = getNeverUsedListSQL()
+	} else {
+		client_list = make([]string, 0)
+		for _, cn := range GetList() {
+			c, _ := Get(cn)
+			if c != nil && c.lastAuth().IsZero() {
+				client_list = append(client_list, cn)
+			}
+		}
+	}
+	return client_list
+}
+
+// Record that the client has just successfully authenticated. Unlike Save(),
+// this only updates the last authentication time and does not reindex the
+// client.
+func (c *Client) UpdateLastAuth() error {
+	now := time.Now().UTC()
+	if config.Config.UseSQL {
+		c.LastAuth = now
+		return c.updateLastAuthSQL()
+	}
+	/* Update the stored client in place rather than setting c again, so a
+	 * client deleted since it was fetched stays deleted. */
+	stateLock.Lock()
+	defer stateLock.Unlock()
+	c.LastAuth = now
+	ds := data_store.New()
+	if stored, found := ds.Get("client", c.Name); found {
+		stored.(*Client).LastAuth = now
+	}
+	return nil
+}
+
+func (c *Client) lastAuth() time.Time {
+	stateLock.RLock()
+	defer stateLock.RUnlock()
+	return c.LastAuth
+}
+
+// Disable or reenable the client. A disabled client keeps its keys and
+// everything else, but can't authenticate until it's enabled again. Like
+// UpdateLastAuth, this doesn't reindex the client.
+func (c *Client) SetDisabled(disabled bool) error {
+	if config.Config.UseSQL {
+		stateLock.Lock()
+		c.Disabled = disabled
+		stateLock.Unlock()
+		return c.setDisabledSQL()
+	}
+	/* As with UpdateLastAuth, update the stored client in place. */
+	stateLock.Lock()
+	defer stateLock.Unlock()
+	c.Disabled = disabled
+	ds := data_store.New()
+	if stored, found := ds.Get("client", c.Name); found {
+		stored.(*Client).Disabled = disabled
+	}
+	return nil
+}
+
+// Is the client disabled?
+func (c *Client) IsDisabled() bool {
+	stateLock.RLock()
+	defer stateLock.RUnlock()
+	return c.Disabled
+}
+
 // Generate a new set of RSA keys for the client. The new private key is saved
 // with the client, the public key is given to the client and not saved on the
 // server at all. 
@@ -526,7 +652,7 @@ func useAuth() bool {
 }
 
 func (c *Client) export() *privClient {
-	return &privClient{ Name: &c.Name, NodeName: &c.NodeName, JsonClass: &c.JsonClass, ChefType: &c.ChefType, Validator: &c.Validator, Orgname: &c.Orgname, PublicKey: &c.pubKey, Admin: &c.Admin, Certificate: &c.Certificate }
+	return &privClient{ Name: &c.Name, NodeName: &c.NodeName, JsonClass: &c.JsonClass, ChefType: &c.ChefType, Validator: &c.Validator, Orgname: &c.Orgname, PublicKey: &c.pubKey, Admin: &c.Admin, Certificate: &c.Certificate, LastAuth: &c.LastAuth, Disabled: &c.Disabled }
 }
 
 func (c *Client) flatExport() *flatClient {
@@ -534,6 +660,8 @@ func (c *Client) flatExport() *flatClient {
 }
 
 func (c *Client) GobEncode() ([]byte, error) {
+	stateLock.RLock()
+	defer stateLock.RUnlock()
 	prv := c.export()
 	buf := new(bytes.Buffer)
 	decoder := gob.NewEncoder(buf)
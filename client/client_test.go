@@ -19,9 +19,12 @@ package client
 
 import (
 	"testing"
+	"github.com/ctdk/goiardi/data_store"
 	"bytes"
 	"encoding/gob"
 	"fmt"
+	"net/http"
+	"sync"
 )
 
 func TestGobEncodeDecode(t *testing.T){
@@ -48,3 +51,55 @@ func TestGobEncodeDecode(t *testing.T){
 		t.Errorf("saved user doesn't seem to be equal to original: %v vs %v", c2, c)
 	}
 }
+
+func TestConcurrentAuthDelete(t *testing.T){
+	for i := 0; i < 20; i++ {
+		name := fmt.Sprintf("authdel%d", i)
+		c, _ := New(name)
+		/* Store it directly; indexing in the background isn't
+		 * what's being tested here. */
+		data_store.New().Set("client", name, c)
+		var wg sync.WaitGroup
+		for j := 0; j < 4; j++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if c2, _ := Get(name); c2 != nil {
+					c2.UpdateLastAuth()
+					c2.ToJson()
+				}
+			}()
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c.Delete()
+		}()
+		wg.Wait()
+		if c2, _ := Get(name); c2 != nil {
+			t.Errorf("Client %s came back after being deleted", name)
+		}
+	}
+	c, _ := New("authed")
+	data_store.New().Set("client", "authed", c)
+	c.UpdateLastAuth()
+	if c2, _ := Get("authed"); c2 == nil || c2.ToJson()["last_auth"] == nil {
+		t.Errorf("Last authentication time wasn't recorded")
+	}
+}
+
+func TestDisabledInJson(t *testing.T) {
+	c, _ := New("jsondisabled")
+	js := c.ToJson()
+	if err := c.UpdateFromJson(js); err != nil {
+		t.Errorf("Sending back the client's own JSON failed: %s", err.Error())
+	}
+	js["disabled"] = true
+	err := c.UpdateFromJson(js)
+	if err == nil || err.Status() != http.StatusBadRequest {
+		t.Errorf("Disabling a client through its JSON should have been a 400, got %v", err)
+	}
+	if c.IsDisabled() {
+		t.Errorf("The client was disabled anyway")
+	}
+}
@@ -25,22 +25,9 @@ import (
 	"net/http"
 )
 
-func checkForClientMySQL(dbhandle data_store.Dbhandle, name string) (bool, error) {
-	_, err := data_store.CheckForOne(dbhandle, "clients", name)
-	if err == nil {
-		return true, nil
-	} else {
-		if err != sql.ErrNoRows {
-			return false, err
-		} else {
-			return false, nil
-		}
-	}
-}
-
 func getClientMySQL(name string) (*Client, error) {
 	client := new(Client)
-	stmt, err := data_store.Dbh.Prepare("select c.name, nodename, validator, admin, o.name, public_key, certificate FROM clients c JOIN organizations o on c.organization_id = o.id WHERE c.name = ?")
+	stmt, err := data_store.Dbh.Prepare("select c.name, nodename, validator, admin, o.name, public_key, certificate, last_auth, disabled FROM clients c JOIN organizations o on c.organization_id = o.id WHERE c.name = ?")
 	if err != nil {
 		return nil, err
 	}
@@ -53,16 +40,6 @@ func getClientMySQL(name string) (*Client, error) {
 	return client, nil
 }
 
-func (c *Client) fillClientFromSQL(row *sql.Row) error {
-	err := row.Scan(&c.Name, &c.NodeName, &c.Validator, &c.Admin, &c.Orgname, &c.pubKey, &c.Certificate)
-	if err != nil {
-		return err
-	}
-	c.ChefType = "client"
-	c.JsonClass = "Chef::ApiClient"
-	return nil
-}
-
 func (c *Client) saveMySQL() error {
 	tx, err := data_store.Dbh.Begin()
 	var client_id int32
@@ -98,6 +75,16 @@ func (c *Client) saveMySQL() error {
 	return nil
 }
 
+func (c *Client) setDisabledMySQL() error {
+	_, err := data_store.Dbh.Exec("UPDATE clients SET disabled = ? WHERE name = ?", c.Disabled, c.Name)
+	return err
+}
+
+func (c *Client) updateLastAuthMySQL() error {
+	_, err := data_store.Dbh.Exec("UPDATE clients SET last_auth = ? WHERE name = ?", c.LastAuth, c.Name)
+	return err
+}
+
 func (c *Client) deleteMySQL() error {
 	tx, err := data_store.Dbh.Begin()
 	if err != nil {
@@ -123,7 +110,7 @@ func (c *Client) renameMySQL(new_name string) util.Gerror {
 		gerr := util.Errorf(err.Error())
 		return gerr
 	}
-	found, err := checkForClientMySQL(data_store.Dbh, new_name)
+	found, err := checkForClientSQL(data_store.Dbh, new_name)
 	if found || err != nil {
 		tx.Rollback()
 		if found && err == nil {
@@ -182,29 +169,3 @@ func numAdminsMySQL() int {
 	}
 	return numAdmins
 }
-
-func getListMySQL() []string {
-	var client_list []string
-	rows, err := data_store.Dbh.Query("SELECT name FROM clients")
-	if err != nil {
-		if err != sql.ErrNoRows {
-			log.Fatal(err)
-		}
-		rows.Close()
-		return client_list
-	}
-	client_list = make([]string, 0)
-	for rows.Next() {
-		var client_name string
-		err = rows.Scan(&client_name)
-		if err != nil {
-			log.Fatal(err)
-		}
-		client_list = append(client_list, client_name)
-	}
-	rows.Close()
-	if err = rows.Err(); err != nil {
-		log.Fatal(err)
-	}
-	return client_list
-}
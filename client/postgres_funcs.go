@@ -0,0 +1,149 @@
+/*
+ * Copyright (c) 2013-2014, Jeremy Bingham (<jbingham@gmail.com>)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package client
+
+import (
+	"github.com/ctdk/goiardi/data_store"
+	"github.com/ctdk/goiardi/util"
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+)
+
+func getClientPostgreSQL(name string) (*Client, error) {
+	client := new(Client)
+	stmt, err := data_store.Dbh.Prepare("select c.name, nodename, validator, admin, o.name, public_key, certificate, last_auth, disabled FROM clients c JOIN organizations o on c.organization_id = o.id WHERE c.name = $1")
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+	row := stmt.QueryRow(name)
+	err = client.fillClientFromSQL(row)
+	if err != nil {
+		return nil, err
+	}
+	return client, nil
+}
+
+func (c *Client) savePostgreSQL() error {
+	tx, err := data_store.Dbh.Begin()
+	if err != nil {
+		return err
+	}
+	// check for a user with this name first. If orgs are ever
+	// implemented, it will only need to check for a user 
+	// associated with this organization
+	err = chkForUserPostgreSQL(tx, c.Name)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	_, err = tx.Exec("INSERT INTO clients (name, nodename, validator, admin, public_key, certificate, created_at, updated_at) VALUES ($1, $2, $3, $4, $5, $6, NOW(), NOW()) ON CONFLICT (organization_id, name) DO UPDATE SET nodename = EXCLUDED.nodename, validator = EXCLUDED.validator, admin = EXCLUDED.admin, public_key = EXCLUDED.public_key, certificate = EXCLUDED.certificate, updated_at = NOW()", c.Name, c.NodeName, c.Validator, c.Admin, c.pubKey, c.Certificate)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	tx.Commit()
+	return nil
+}
+
+func (c *Client) setDisabledPostgreSQL() error {
+	_, err := data_store.Dbh.Exec("UPDATE clients SET disabled = $1 WHERE name = $2", c.Disabled, c.Name)
+	return err
+}
+
+func (c *Client) updateLastAuthPostgreSQL() error {
+	_, err := data_store.Dbh.Exec("UPDATE clients SET last_auth = $1 WHERE name = $2", c.LastAuth, c.Name)
+	return err
+}
+
+func (c *Client) deletePostgreSQL() error {
+	tx, err := data_store.Dbh.Begin()
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("DELETE FROM clients WHERE name = $1", c.Name)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	tx.Commit()
+	return nil
+}
+
+func (c *Client) renamePostgreSQL(new_name string) util.Gerror {
+	tx, err := data_store.Dbh.Begin()
+	if err != nil {
+		gerr := util.Errorf(err.Error())
+		return gerr
+	}
+	if err = chkForUserPostgreSQL(tx, new_name); err != nil {
+		tx.Rollback()
+		gerr := util.Errorf(err.Error())
+		return gerr
+	}
+	found, err := checkForClientSQL(tx, new_name)
+	if found || err != nil {
+		tx.Rollback()
+		if found && err == nil {
+			gerr := util.Errorf("Client %s already exists, cannot rename %s", new_name, c.Name)
+			gerr.SetStatus(http.StatusConflict)
+			return gerr
+		} else {
+			gerr := util.Errorf(err.Error())
+			gerr.SetStatus(http.StatusInternalServerError)
+			return gerr
+		}
+	}
+	_, err = tx.Exec("UPDATE clients SET name = $1 WHERE name = $2", new_name, c.Name)
+	if err != nil {
+		tx.Rollback()
+		gerr := util.Errorf(err.Error())
+		gerr.SetStatus(http.StatusInternalServerError)
+		return gerr
+	}
+	tx.Commit()
+	return nil
+}
+
+func chkForUserPostgreSQL(handle data_store.Dbhandle, name string) error {
+	var user_id int32
+	err := handle.QueryRow("SELECT id FROM users WHERE name = $1", name).Scan(&user_id)
+	if err != sql.ErrNoRows {
+		if err == nil {
+			err = fmt.Errorf("a user with id %d named %s was found that would conflict with this client", user_id, name)
+		}
+	} else {
+		err = nil
+	}
+	return err 
+}
+
+func numAdminsPostgreSQL() int {
+	var numAdmins int
+	stmt, err := data_store.Dbh.Prepare("SELECT count(*) FROM clients WHERE admin = TRUE")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer stmt.Close()
+	err = stmt.QueryRow().Scan(&numAdmins)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return numAdmins
+}
@@ -0,0 +1,160 @@
+/*
+ * Copyright (c) 2013-2014, Jeremy Bingham (<jbingham@gmail.com>)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package client
+
+import (
+	"github.com/ctdk/goiardi/config"
+	"github.com/ctdk/goiardi/data_store"
+	"github.com/ctdk/goiardi/util"
+	"database/sql"
+	"github.com/go-sql-driver/mysql"
+	"log"
+)
+
+func checkForClientSQL(dbhandle data_store.Dbhandle, name string) (bool, error) {
+	_, err := data_store.CheckForOne(dbhandle, "clients", name)
+	if err == nil {
+		return true, nil
+	} else {
+		if err != sql.ErrNoRows {
+			return false, err
+		} else {
+			return false, nil
+		}
+	}
+}
+
+func (c *Client) fillClientFromSQL(row *sql.Row) error {
+	var la mysql.NullTime
+	err := row.Scan(&c.Name, &c.NodeName, &c.Validator, &c.Admin, &c.Orgname, &c.pubKey, &c.Certificate, &la, &c.Disabled)
+	if err != nil {
+		return err
+	}
+	if la.Valid {
+		c.LastAuth = la.Time
+	}
+	c.ChefType = "client"
+	c.JsonClass = "Chef::ApiClient"
+	return nil
+}
+
+func getListSQL() []string {
+	var client_list []string
+	rows, err := data_store.Dbh.Query("SELECT name FROM clients")
+	if err != nil {
+		if err != sql.ErrNoRows {
+			log.Fatal(err)
+		}
+		rows.Close()
+		return client_list
+	}
+	client_list = make([]string, 0)
+	for rows.Next() {
+		var client_name string
+		err = rows.Scan(&client_name)
+		if err != nil {
+			log.Fatal(err)
+		}
+		client_list = append(client_list, client_name)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		log.Fatal(err)
+	}
+	return client_list
+}
+
+func getNeverUsedListSQL() []string {
+	client_list := make([]string, 0)
+	rows, err := data_store.Dbh.Query("SELECT name FROM clients WHERE last_auth IS NULL ORDER BY name")
+	if err != nil {
+		if err != sql.ErrNoRows {
+			log.Fatal(err)
+		}
+		return client_list
+	}
+	for rows.Next() {
+		var client_name string
+		err = rows.Scan(&client_name)
+		if err != nil {
+			log.Fatal(err)
+		}
+		client_list = append(client_list, client_name)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		log.Fatal(err)
+	}
+	return client_list
+}
+
+func getListPageSQL(offset int, limit int, desc bool) ([]string, int) {
+	client_list, total, err := data_store.GetSortedNameListPage(data_store.Dbh, "clients", offset, limit, desc)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return client_list, total
+}
+
+func getClientSQL(name string) (*Client, error) {
+	if config.Config.UseMySQL {
+		return getClientMySQL(name)
+	}
+	return getClientPostgreSQL(name)
+}
+
+func (c *Client) saveSQL() error {
+	if config.Config.UseMySQL {
+		return c.saveMySQL()
+	}
+	return c.savePostgreSQL()
+}
+
+func (c *Client) setDisabledSQL() error {
+	if config.Config.UseMySQL {
+		return c.setDisabledMySQL()
+	}
+	return c.setDisabledPostgreSQL()
+}
+
+func (c *Client) updateLastAuthSQL() error {
+	if config.Config.UseMySQL {
+		return c.updateLastAuthMySQL()
+	}
+	return c.updateLastAuthPostgreSQL()
+}
+
+func (c *Client) deleteSQL() error {
+	if config.Config.UseMySQL {
+		return c.deleteMySQL()
+	}
+	return c.deletePostgreSQL()
+}
+
+func (c *Client) renameSQL(new_name string) util.Gerror {
+	if config.Config.UseMySQL {
+		return c.renameMySQL(new_name)
+	}
+	return c.renamePostgreSQL(new_name)
+}
+
+func numAdminsSQL() int {
+	if config.Config.UseMySQL {
+		return numAdminsMySQL()
+	}
+	return numAdminsPostgreSQL()
+}
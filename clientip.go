@@ -0,0 +1,82 @@
+/*
+ * Copyright (c) 2013-2014, Jeremy Bingham (<jbingham@gmail.com>)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strings"
+	"github.com/ctdk/goiardi/config"
+)
+
+type contextKey int
+
+const clientIPKey contextKey = iota
+
+/* Work out the address of the client that made the request. If the request
+ * came through one of the trusted proxies, walk back through X-Forwarded-For
+ * until we hit an address that isn't a trusted proxy, or use X-Real-IP if the
+ * proxy only sets that. Forwarded headers from anywhere else are ignored,
+ * since they could say anything. */
+func clientIP(r *http.Request) net.IP {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+	if !config.IPInNets(ip, config.Config.TrustedProxyNets) {
+		return ip
+	}
+	fwd := r.Header.Get("X-Forwarded-For")
+	if fwd == "" {
+		if rip := net.ParseIP(strings.TrimSpace(r.Header.Get("X-Real-IP"))); rip != nil {
+			return rip
+		}
+		return ip
+	}
+	hops := strings.Split(fwd, ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hip := net.ParseIP(strings.TrimSpace(hops[i]))
+		if hip == nil {
+			/* Can't trust anything further back than a mangled
+			 * entry. */
+			break
+		}
+		ip = hip
+		if !config.IPInNets(ip, config.Config.TrustedProxyNets) {
+			break
+		}
+	}
+	return ip
+}
+
+/* Store the client's address in the request's context, so it only has to be
+ * worked out once per request. */
+func withClientIP(r *http.Request) *http.Request {
+	ctx := context.WithValue(r.Context(), clientIPKey, clientIP(r))
+	return r.WithContext(ctx)
+}
+
+/* Get the client's address for the request, from the request context if
+ * withClientIP has already been called on it. */
+func requestClientIP(r *http.Request) net.IP {
+	if ip, ok := r.Context().Value(clientIPKey).(net.IP); ok {
+		return ip
+	}
+	return clientIP(r)
+}
@@ -33,7 +33,15 @@ func client_handler(w http.ResponseWriter, r *http.Request){
 	client_name := path[1]
 	opUser, oerr := actor.GetReqUser(r.Header.Get("X-OPS-USERID"))
 	if oerr != nil {
-		JsonErrorReport(w, r, oerr.Error(), oerr.Status())
+		GerrorReport(w, r, oerr)
+		return
+	}
+
+	if len(path) == 3 && (path[2] == "_disable" || path[2] == "_enable") {
+		if !adminIPAllowed(w, r) {
+			return
+		}
+		client_disable(w, r, client_name, path[2] == "_disable", opUser)
 		return
 	}
 
@@ -41,7 +49,7 @@ func client_handler(w http.ResponseWriter, r *http.Request){
 		case "DELETE":
 			chef_client, gerr := client.Get(client_name)
 			if gerr != nil {
-				JsonErrorReport(w, r, gerr.Error(), gerr.Status())
+				GerrorReport(w, r, gerr)
 				return
 			}
 			if !opUser.IsAdmin() && !opUser.IsSelf(chef_client) {
@@ -73,7 +81,7 @@ func client_handler(w http.ResponseWriter, r *http.Request){
 			chef_client, gerr := client.Get(client_name)
 
 			if gerr != nil {
-				JsonErrorReport(w, r, gerr.Error(), gerr.Status())
+				GerrorReport(w, r, gerr)
 				return
 			}
 			if !opUser.IsAdmin() && !opUser.IsSelf(chef_client) {
@@ -107,7 +115,7 @@ func client_handler(w http.ResponseWriter, r *http.Request){
 			/* Makes chef-pedant happy. I suppose it is, after all,
 			 * pedantic. */
 			if averr := util.CheckAdminPlusValidator(client_data); averr != nil {
-				JsonErrorReport(w, r, averr.Error(), averr.Status())
+				GerrorReport(w, r, averr)
 				return
 			}
 
@@ -128,7 +136,7 @@ func client_handler(w http.ResponseWriter, r *http.Request){
 					if aerr == nil {
 						aerr = verr
 					}
-					JsonErrorReport(w, r, aerr.Error(), aerr.Status())
+					GerrorReport(w, r, aerr)
 					return
 				}
 			}
@@ -150,14 +158,14 @@ func client_handler(w http.ResponseWriter, r *http.Request){
 				}
 				err := chef_client.Rename(json_name)
 				if err != nil {
-					JsonErrorReport(w, r, err.Error(), err.Status())
+					GerrorReport(w, r, err)
 					return
 				} else {
 					w.WriteHeader(http.StatusCreated)
 				}
 			} 
 			if uerr := chef_client.UpdateFromJson(client_data); uerr != nil {
-				JsonErrorReport(w, r, uerr.Error(), uerr.Status())
+				GerrorReport(w, r, uerr)
 				return
 			}
 
@@ -213,3 +221,39 @@ func client_handler(w http.ResponseWriter, r *http.Request){
 			JsonErrorReport(w, r, "Unrecognized method for client!", http.StatusMethodNotAllowed)
 	}
 }
+
+/* Disable a client, say because its key may have been compromised, or turn it
+ * back on. Disabled clients can't authenticate, but keep their keys and
+ * everything else, so this is quicker to undo than deleting the client. */
+func client_disable(w http.ResponseWriter, r *http.Request, client_name string, disable bool, opUser actor.Actor) {
+	if r.Method != "POST" {
+		JsonErrorReport(w, r, "Unrecognized method", http.StatusMethodNotAllowed)
+		return
+	}
+	if !opUser.IsAdmin() {
+		JsonErrorReport(w, r, "You are not allowed to perform that action.", http.StatusForbidden)
+		return
+	}
+	chef_client, gerr := client.Get(client_name)
+	if gerr != nil {
+		GerrorReport(w, r, gerr)
+		return
+	}
+	if err := chef_client.SetDisabled(disable); err != nil {
+		JsonErrorReport(w, r, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	action := "enable"
+	if disable {
+		action = "disable"
+	}
+	if lerr := log_info.LogEvent(opUser, chef_client, action); lerr != nil {
+		JsonErrorReport(w, r, lerr.Error(), http.StatusInternalServerError)
+		return
+	}
+	json_client := chef_client.ToJson()
+	enc := json.NewEncoder(w)
+	if err := enc.Encode(&json_client); err != nil {
+		JsonErrorReport(w, r, err.Error(), http.StatusInternalServerError)
+	}
+}
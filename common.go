@@ -23,7 +23,10 @@ import (
 	"encoding/json"
 	"net/http"
 	"git.tideland.biz/goas/logger"
+	"github.com/ctdk/goiardi/config"
+	"github.com/ctdk/goiardi/util"
 	"strings"
+	"strconv"
 	"fmt"
 )
 
@@ -34,6 +37,12 @@ func ParseObjJson(data io.ReadCloser) (map[string]interface{}, error){
 	if err := dec.Decode(&obj_data); err != nil {
 		return nil, err
 	}
+	/* A body of "null" decodes without an error, but leaves obj_data
+	 * nil. */
+	if obj_data == nil {
+		err := fmt.Errorf("Request body must be a JSON object")
+		return nil, err
+	}
 
 	/* If this kind of object comes with a run_list, process it */
 	if _, ok := obj_data["run_list"]; ok {
@@ -83,8 +92,28 @@ func SplitPath(path string) (split_path []string){
 }
 
 func JsonErrorReport(w http.ResponseWriter, r *http.Request, error_str string, status int){
+	jsonErrorReport(w, r, error_str, status, util.StatusCode(status), "")
+}
+
+// Like JsonErrorReport, but for a Gerror, so its error code and the field it's
+// about can be sent along with the message when extended-errors is on.
+func GerrorReport(w http.ResponseWriter, r *http.Request, gerr util.Gerror){
+	jsonErrorReport(w, r, gerr.Error(), gerr.Status(), gerr.Code(), gerr.Field())
+}
+
+/* Chef's error format is a hash with an array of error messages. Extended
+ * errors keep that, so clients that only know Chef's format still work, and
+ * add the error code, HTTP status, and field if there is one. */
+func jsonErrorReport(w http.ResponseWriter, r *http.Request, error_str string, status int, code string, field string){
 	logger.Infof(error_str)
-	json_error := map[string][]string{ "error": []string{ error_str } }
+	json_error := map[string]interface{}{ "error": []string{ error_str } }
+	if config.Config.ExtendedErrors {
+		json_error["code"] = code
+		json_error["status"] = status
+		if field != "" {
+			json_error["field"] = field
+		}
+	}
 	w.WriteHeader(status)
 	enc := json.NewEncoder(w)
 	if err:= enc.Encode(&json_error); err != nil {
@@ -105,6 +134,77 @@ func CheckAccept(w http.ResponseWriter, r *http.Request, acceptType string) erro
 	return err
 }
 
+/* Get the offset and limit query parameters used to page through object lists.
+ * If neither is given, paged is false and the whole list should be sent back.
+ * If there's no limit, it's returned as -1. */
+func listPageParams(r *http.Request) (offset int, limit int, paged bool, err error) {
+	limit = -1
+	if o := r.FormValue("offset"); o != "" {
+		paged = true
+		offset, err = strconv.Atoi(o)
+		if err != nil || offset < 0 {
+			err = fmt.Errorf("invalid offset value '%s'", o)
+			return 0, 0, false, err
+		}
+	}
+	if l := r.FormValue("limit"); l != "" {
+		paged = true
+		limit, err = strconv.Atoi(l)
+		if err != nil || limit < 0 {
+			err = fmt.Errorf("invalid limit value '%s'", l)
+			return 0, 0, false, err
+		}
+	}
+	return offset, limit, paged, nil
+}
+
+/* Read the optional sort parameter for lists that can be sorted. Lists are
+ * keyed by name, so name is the only field they can be sorted by, like
+ * "name:asc" or "name:desc". The direction defaults to ascending. */
+func listSortParam(r *http.Request) (sorted bool, desc bool, err error) {
+	srt := r.FormValue("sort")
+	if srt == "" {
+		return false, false, nil
+	}
+	field, dir := srt, "asc"
+	if i := strings.Index(srt, ":"); i != -1 {
+		field, dir = srt[:i], srt[i+1:]
+	}
+	if field != "name" || (dir != "asc" && dir != "desc") {
+		err = fmt.Errorf("invalid sort value '%s'", srt)
+		return false, false, err
+	}
+	return true, dir == "desc", nil
+}
+
+/* When a list is paged, the total number of objects is sent back in a header,
+ * since the list itself is keyed by object name. */
+func setListTotal(w http.ResponseWriter, total int) {
+	w.Header().Set("X-Goiardi-Total-Count", strconv.Itoa(total))
+}
+
+/* A sorted list is sent back as an array of names and URLs, in order, since a
+ * JSON object's keys always come out in ascending order. */
+func writeSortedList(w http.ResponseWriter, r *http.Request, names []string, url_format string) {
+	sorted_list := make([]map[string]string, len(names))
+	for i, n := range names {
+		sorted_list[i] = map[string]string{ "name": n, "url": util.CustomURL(fmt.Sprintf(url_format, n)) }
+	}
+	enc := json.NewEncoder(w)
+	if err := enc.Encode(&sorted_list); err != nil {
+		JsonErrorReport(w, r, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+/* The event to log for a PUT, which creates the object instead of modifying
+ * it if it didn't exist and put-creates is on. */
+func putAction(created bool) string {
+	if created {
+		return "create"
+	}
+	return "modify"
+}
+
 func chkRunList(rl interface{}) ([]string, error) {
 	switch o := rl.(type){
 		case []interface{}:
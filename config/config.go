@@ -58,9 +58,61 @@ type Conf struct {
 	DisableWebUI bool `toml:"disable-webui"`
 	UseMySQL bool `toml:"use-mysql"`
 	MySQL MySQLdb `toml:"mysql"`
+	UsePostgreSQL bool `toml:"use-postgres"`
+	PostgreSQL PostgreSQLdb `toml:"postgresql"`
+	UseSQL bool
 	LocalFstoreDir string `toml:"local-filestore-dir"`
+	UseS3 bool `toml:"use-s3"`
+	S3 S3Conf `toml:"s3"`
 	LogEvents bool `toml:"log-events"`
 	LogEventKeep int `toml:"log-event-keep"`
+	IgnoreUnknownFields bool `toml:"ignore-unknown-fields"`
+	DepsolveMaxRunList int `toml:"depsolve-max-run-list"`
+	DepsolveMaxDepth int `toml:"depsolve-max-depth"`
+	MaxConcurrentRequests int `toml:"max-concurrent-requests"`
+	RequestQueueTimeout string `toml:"request-queue-timeout"`
+	RequestQueueTimeoutDur time.Duration
+	RecipeDepCheck string `toml:"recipe-dep-check"`
+	Notice string `toml:"notice"`
+	AutoCreateEnvironments bool `toml:"auto-create-environments"`
+	PutCreates bool `toml:"put-creates"`
+	AdminIPAllow []string `toml:"admin-ip-allow"`
+	AdminIPAllowNets []*net.IPNet
+	TrustedProxies []string `toml:"trusted-proxies"`
+	TrustedProxyNets []*net.IPNet
+	StrictRoleRunLists bool `toml:"strict-role-run-lists"`
+	VerifyDuplicateUploads bool `toml:"verify-duplicate-uploads"`
+	FreezeOnUpload bool `toml:"freeze-on-upload"`
+	DisableForceUpload bool `toml:"disable-force-upload"`
+	MaxAttributeDepth int `toml:"max-attribute-depth"`
+	Webhooks []WebhookConf `toml:"webhook"`
+	WebhookRetries int `toml:"webhook-retries"`
+	WebhookWorkers int `toml:"webhook-workers"`
+	GzipResponses bool `toml:"gzip-responses"`
+	GzipLevel int `toml:"gzip-level"`
+	SearchWhileReindexing string `toml:"search-while-reindexing"`
+	RequiredMetadata []string `toml:"required-metadata"`
+	NormalizeVersions bool `toml:"normalize-versions"`
+	NodeStaleDeleteAfter string `toml:"node-stale-delete-after"`
+	NodeStaleDeleteAfterDur time.Duration
+	NodeStaleDryRun bool `toml:"node-stale-dry-run"`
+	MaxConcurrentUploads int `toml:"max-concurrent-uploads"`
+	UploadQueueTimeout string `toml:"upload-queue-timeout"`
+	UploadQueueTimeoutDur time.Duration
+	MySQLRetries int `toml:"mysql-retries"`
+	MySQLRetryBackoff string `toml:"mysql-retry-backoff"`
+	MySQLRetryBackoffDur time.Duration
+	AdminPassword string `toml:"admin-password"`
+	DataStoreLocking string `toml:"data-store-locking"`
+	EventLogFailure string `toml:"event-log-failure"`
+	DownloadMirrorHeader string `toml:"download-mirror-header"`
+	DownloadMirrors map[string]string `toml:"download-mirrors"`
+	AuthDebug bool `toml:"auth-debug"`
+	OptimizeEventLog int `toml:"optimize-event-log"`
+	CookbookVisibility map[string][]string `toml:"cookbook-visibility"`
+	ExtendedErrors bool `toml:"extended-errors"`
+	MaxDataBagItemSize int `toml:"max-data-bag-item-size"`
+	MaxImportSize int `toml:"max-import-size"`
 }
 var LogLevelNames = map[string]int{ "debug": 4, "info": 3, "warning": 2, "error": 1, "critical": 0 }
 
@@ -75,6 +127,36 @@ type MySQLdb struct {
 	ExtraParams map[string]string `toml:"extra_params"`
 }
 
+// PostgreSQL connection options
+type PostgreSQLdb struct {
+	Username string
+	Password string
+	Host string
+	Port string
+	Dbname string
+	SSLMode string
+}
+
+// S3 filestore options
+type S3Conf struct {
+	Bucket string
+	Region string
+	Endpoint string
+	Prefix string
+	AccessKey string `toml:"access_key"`
+	SecretKey string `toml:"secret_key"`
+}
+
+// Webhook options. Every event goiardi logs is POSTed to the URL, or only the
+// events for the given types of objects (as they appear in URLs, like "nodes"
+// or "cookbooks") if any are given. If there's a secret, it's used to sign the
+// body of the request.
+type WebhookConf struct {
+	URL string `toml:"url"`
+	Secret string `toml:"secret"`
+	Types []string `toml:"types"`
+}
+
 /* Struct for command line options. */
 type Options struct {
 	Version bool `short:"v" long:"version" description:"Print version info."`
@@ -96,9 +178,52 @@ type Options struct {
 	HttpsUrls bool `long:"https-urls" description:"Use 'https://' in URLs to server resources if goiardi is not using SSL for its connections. Useful when goiardi is sitting behind a reverse proxy that uses SSL, but is communicating with the proxy over HTTP."`
 	DisableWebUI bool `long:"disable-webui" description:"If enabled, disables connections and logins to goiardi over the webui interface."`
 	UseMySQL bool `long:"use-mysql" description:"Use a MySQL database for data storage. Configure database options in the config file."`
-	LocalFstoreDir string `long:"local-filestore-dir" description:"Directory to save uploaded files in. Optional when running in in-memory mode, *mandatory* for SQL mode."`
+	UsePostgreSQL bool `long:"use-postgres" description:"Use a PostgreSQL database for data storage. Configure database options in the config file."`
+	LocalFstoreDir string `long:"local-filestore-dir" description:"Directory to save uploaded files in. Optional when running in in-memory mode, *mandatory* for SQL mode unless --use-s3 is set."`
+	UseS3 bool `long:"use-s3" description:"Store uploaded files in S3 or S3 compatible object storage. Configure S3 options in the config file."`
 	LogEvents bool `long:"log-events" description:"Log changes to chef objects."`
 	LogEventKeep int `short:"K" long:"log-event-keep" description:"Number of events to keep in the event log. If set, the event log will be checked periodically and pruned to this number of entries."`
+	IgnoreUnknownFields bool `long:"ignore-unknown-fields" description:"Ignore unknown fields in uploaded cookbooks instead of rejecting the upload. Unknown fields are logged at the debug level. Default: false."`
+	DepsolveMaxRunList int `long:"depsolve-max-run-list" description:"Maximum number of items allowed in a run list sent to the depsolver. Default: 1000."`
+	DepsolveMaxDepth int `long:"depsolve-max-depth" description:"Maximum depth the depsolver will follow cookbook dependencies. Default: 100."`
+	Webhooks []string `long:"webhook" description:"POST every event, like the ones recorded by --log-events, to this URL. May be given more than once. Use the config file to only send events for some types of objects."`
+	WebhookSecret string `long:"webhook-secret" description:"Secret used to sign the bodies of webhooks given with --webhook, sent in the X-Goiardi-Signature header."`
+	WebhookRetries int `long:"webhook-retries" description:"How many times to retry a failed webhook delivery. Default: 3."`
+	WebhookWorkers int `long:"webhook-workers" description:"How many webhook deliveries to make at once. Events waiting for a worker are queued, and dropped with an error logged if too many are waiting. Default: 4."`
+	GzipResponses bool `long:"gzip-responses" description:"Compress responses with gzip for clients that accept it."`
+	GzipLevel int `long:"gzip-level" description:"Compression level for gzipped responses, from 1 (fastest) to 9 (smallest). Default: 6."`
+	SearchWhileReindexing string `long:"search-while-reindexing" description:"What to do with searches while the search index is being rebuilt. 'error' responds with a 503 and a Retry-After header, while 'serve' searches the partly rebuilt index and sets the X-Goiardi-Search-Incomplete header. Default: error."`
+	RequiredMetadata []string `long:"required-metadata" description:"Reject cookbook uploads where this metadata field, like maintainer or license, is missing or empty. May be given more than once."`
+	NormalizeVersions bool `long:"normalize-versions" description:"Store cookbook versions with three components, so a cookbook uploaded as version 1.2 is stored and found as 1.2.0. MySQL and PostgreSQL always store versions this way."`
+	NodeStaleDeleteAfter string `long:"node-stale-delete-after" description:"Delete nodes that haven't checked in, going by their ohai_time, for this long. Formatted like 720h. Checked hourly. Default: off."`
+	NodeStaleDryRun bool `long:"node-stale-dry-run" description:"With --node-stale-delete-after, only log the nodes that would be deleted without deleting them."`
+	MaxConcurrentUploads int `long:"max-concurrent-uploads" description:"Maximum number of cookbook and file uploads goiardi will handle at once. Uploads beyond this are queued for up to --upload-queue-timeout, then rejected with a 503. Set to -1 for no limit. Default: 20."`
+	UploadQueueTimeout string `long:"upload-queue-timeout" description:"How long an upload waits for a slot when --max-concurrent-uploads is reached before being rejected. Formatted like 30s, 1m, etc. Defaults to 30s."`
+	MySQLRetries int `long:"mysql-retries" description:"How many times to retry saving cookbooks and events after a transient MySQL error like a deadlock or lock wait timeout. Set to -1 to not retry. Default: 3."`
+	MySQLRetryBackoff string `long:"mysql-retry-backoff" description:"How long to wait before the first retry after a transient MySQL error. The wait doubles with each retry. Formatted like 100ms, 1s, etc. Defaults to 100ms."`
+	AdminPassword string `long:"admin-password" description:"Password to give the admin user when it's created at startup, so it can log in to the webui right away. May also be set with the GOIARDI_ADMIN_PASSWORD environment variable. Has no effect if the admin user already exists. Default: no password."`
+	DataStoreLocking string `long:"data-store-locking" description:"How finely to lock the in-memory data store. 'type' gives each kind of object its own lock, so writing one kind doesn't hold up reading another. 'global' uses one lock for everything. Default: type."`
+	EventLogFailure string `long:"event-log-failure" description:"What to do when an event can't be written to the event log. 'strict' fails the request that caused the event, 'best-effort' logs the failure, counts it in /_counts, and carries on. Default: strict."`
+	DownloadMirrorHeader string `long:"download-mirror-header" description:"Request header, like X-Region, used to pick a mirror from --download-mirror for cookbook file download URLs. Default: off."`
+	DownloadMirrors []string `long:"download-mirror" description:"Point cookbook file download URLs at another host when the --download-mirror-header header has a particular value, given as value=host, like eu-west=files-eu.example.com:8443. May be given more than once. Requests without a matching value get this server's own host."`
+	ExtendedErrors bool `long:"extended-errors" description:"Add a machine readable error code, the HTTP status, and the field the error was about, where known, to error responses. The usual Chef style list of error messages is still sent. Default: off."`
+	OptimizeEventLog int `long:"optimize-event-log" description:"In MySQL or PostgreSQL mode, run OPTIMIZE TABLE (or VACUUM FULL) on the event log table after a purge deletes at least this percentage of its rows, to reclaim the space. It runs in the background, but the table may be locked while it's optimized. Default: 0 (off)."`
+	AuthDebug bool `long:"auth-debug" description:"When a signed request fails authentication because its signature doesn't match, send back the canonical request string goiardi expected the client to sign, and what the client did sign, to help track down the mismatch. Only sent to requests from --admin-ip-allow networks, if any are set. Default: off."`
+	MaxConcurrentRequests int `long:"max-concurrent-requests" description:"Maximum number of requests goiardi will handle at once. Requests beyond this are queued briefly, then rejected with a 503. Default: 0 (no limit)."`
+	RequestQueueTimeout string `long:"request-queue-timeout" description:"How long a request waits for a slot when --max-concurrent-requests is reached before being rejected. Formatted like 5s, 500ms, etc. Defaults to 5s."`
+	RecipeDepCheck string `long:"recipe-dep-check" description:"Check uploaded recipes for include_recipe calls on cookbooks that aren't declared as dependencies. 'warn' logs and reports them, 'strict' rejects the upload. Requires the file contents to be in the filestore. Default: off."`
+	Notice string `long:"notice" description:"An informational message, like a maintenance notice, to send in the X-Goiardi-Notice header of every response. Can be changed or cleared at runtime through the /notice endpoint."`
+	AutoCreateEnvironments bool `long:"auto-create-environments" description:"When a node is saved in an environment that doesn't exist, create an empty environment with that name. Off by default, since it can hide typos in environment names."`
+	PutCreates bool `long:"put-creates" description:"Let a PUT to a node, role, environment, or data bag item that doesn't exist create it, instead of returning a 404. Off by default, like the Chef server, where objects are created with a POST."`
+	AdminIPAllow []string `long:"admin-ip-allow" description:"Only allow administrative endpoints like /events, /search/reindex, cookbook imports and exports, and disabling clients to be used from this network, in CIDR notation. May be given more than once. Default: allow from anywhere."`
+	TrustedProxies []string `long:"trusted-proxies" description:"Trust the X-Forwarded-For and X-Real-IP headers on requests coming from this network, in CIDR notation, when working out the client's address for logging and the admin IP allowlist. May be given more than once. Default: trust no proxies."`
+	StrictRoleRunLists bool `long:"strict-role-run-lists" description:"Reject roles whose run lists refer to recipes or roles that don't exist. Off by default, since roles and cookbooks can't always be uploaded in dependency order."`
+	VerifyDuplicateUploads bool `long:"verify-duplicate-uploads" description:"When a file is uploaded with the same checksum as a file already in the filestore, check that the contents are really the same and reject the upload with a 409 if they aren't. Costs a read of the existing file."`
+	FreezeOnUpload bool `long:"freeze-on-upload" description:"Freeze every cookbook version when it's uploaded, whatever the client says, so a release can't be changed by uploading it again without the force option. Versions uploaded with draft=true are left alone. Default: off."`
+	DisableForceUpload bool `long:"disable-force-upload" description:"Don't let the force option replace a frozen cookbook version, whether uploaded or imported. A frozen version then has to be unfrozen by an admin before it can be changed. Default: off."`
+	MaxAttributeDepth int `long:"max-attribute-depth" description:"Maximum nesting depth allowed for node, role, and environment attributes. Objects with more deeply nested attributes are rejected. Default: 100."`
+	MaxDataBagItemSize int `long:"max-data-bag-item-size" description:"Maximum size in bytes of a data bag item, encoded as JSON. Larger items are rejected with a 413. Set to -1 for no limit. Default: 1000000."`
+	MaxImportSize int `long:"max-import-size" description:"Maximum size in bytes of a cookbook bundle sent to /cookbooks/_import. Larger bundles are rejected with a 413. Set to -1 for no limit. Default: 100000000."`
 }
 
 // The goiardi version.
@@ -132,6 +257,8 @@ func ParseConfigOptions() error {
 		}
 	}
 
+	cliOpts = opts
+
 	if opts.Version {
 		fmt.Printf("goiardi version %s (aiming for compatibility with Chef Server version %s).\n", Version, ChefVersion)
 		os.Exit(0)
@@ -144,6 +271,7 @@ func ParseConfigOptions() error {
 			panic(err)
 			os.Exit(1)
 		}
+		fileConf = *Config
 		Config.ConfFile = opts.ConfFile
 		Config.FreezeData = false
 	}
@@ -173,25 +301,37 @@ func ParseConfigOptions() error {
 		Config.UseMySQL = opts.UseMySQL
 	}
 
-	if Config.DataStoreFile != "" && Config.UseMySQL {
-		err := fmt.Errorf("The MySQL and data store options may not be specified together.")
+	// Or PostgreSQL?
+	if opts.UsePostgreSQL {
+		Config.UsePostgreSQL = opts.UsePostgreSQL
+	}
+
+	if Config.UseMySQL && Config.UsePostgreSQL {
+		err := fmt.Errorf("The MySQL and PostgreSQL options may not be specified together.")
+		log.Println(err)
+		os.Exit(1)
+	}
+	Config.UseSQL = Config.UseMySQL || Config.UsePostgreSQL
+
+	if Config.DataStoreFile != "" && Config.UseSQL {
+		err := fmt.Errorf("The SQL database and data store options may not be specified together.")
 		log.Println(err)
 		os.Exit(1)
 	}
 
-	if !((Config.DataStoreFile == "" && Config.IndexFile == "") || ((Config.DataStoreFile != "" || Config.UseMySQL) && Config.IndexFile != "")) {
+	if !((Config.DataStoreFile == "" && Config.IndexFile == "") || ((Config.DataStoreFile != "" || Config.UseSQL) && Config.IndexFile != "")) {
 		err := fmt.Errorf("-i and -D must either both be specified, or not specified.")
 		log.Println(err)
 		os.Exit(1)
 	}
 
-	if Config.UseMySQL && Config.IndexFile == "" {
-		err := fmt.Errorf("An index file must be specified with -i or --index-file (or the 'index-file' config file option) when running with a MySQL backend.")
+	if Config.UseSQL && Config.IndexFile == "" {
+		err := fmt.Errorf("An index file must be specified with -i or --index-file (or the 'index-file' config file option) when running with a MySQL or PostgreSQL backend.")
 		log.Println(err)
 		os.Exit(1)
 	}
 
-	if Config.IndexFile != "" && (Config.DataStoreFile != "" || Config.UseMySQL) {
+	if Config.IndexFile != "" && (Config.DataStoreFile != "" || Config.UseSQL) {
 		Config.FreezeData = true
 	}
 
@@ -232,12 +372,30 @@ func ParseConfigOptions() error {
 			Config.MySQL.Port = "3306"
 		}
 	}
+	if Config.UsePostgreSQL {
+		if Config.PostgreSQL.Port == "" {
+			Config.PostgreSQL.Port = "5432"
+		}
+	}
 
 	if opts.LocalFstoreDir != "" {
 		Config.LocalFstoreDir = opts.LocalFstoreDir
 	}
-	if Config.LocalFstoreDir == "" && Config.UseMySQL {
-		logger.Criticalf("local-filestore-dir must be set when running goiardi in SQL mode")
+	if opts.UseS3 {
+		Config.UseS3 = opts.UseS3
+	}
+	if Config.UseS3 {
+		if Config.LocalFstoreDir != "" {
+			logger.Criticalf("local-filestore-dir and use-s3 may not be specified together.")
+			os.Exit(1)
+		}
+		if Config.S3.Bucket == "" {
+			logger.Criticalf("A bucket must be set in the [s3] section of the config file when use-s3 is set.")
+			os.Exit(1)
+		}
+	}
+	if Config.LocalFstoreDir == "" && Config.UseSQL && !Config.UseS3 {
+		logger.Criticalf("local-filestore-dir or use-s3 must be set when running goiardi in SQL mode")
 		os.Exit(1)
 	}
 
@@ -337,15 +495,400 @@ func ParseConfigOptions() error {
 		Config.LogEventKeep = opts.LogEventKeep
 	}
 
+	if opts.OptimizeEventLog != 0 {
+		Config.OptimizeEventLog = opts.OptimizeEventLog
+	}
+	if Config.OptimizeEventLog < 0 || Config.OptimizeEventLog > 100 {
+		err := fmt.Errorf("optimize-event-log must be a percentage between 0 and 100, not %d.", Config.OptimizeEventLog)
+		log.Println(err)
+		os.Exit(1)
+	}
+
+	if opts.IgnoreUnknownFields {
+		Config.IgnoreUnknownFields = opts.IgnoreUnknownFields
+	}
+
+	/* Depsolver limits */
+	if opts.DepsolveMaxRunList != 0 {
+		Config.DepsolveMaxRunList = opts.DepsolveMaxRunList
+	}
+	if Config.DepsolveMaxRunList == 0 {
+		Config.DepsolveMaxRunList = 1000
+	}
+	if opts.DepsolveMaxDepth != 0 {
+		Config.DepsolveMaxDepth = opts.DepsolveMaxDepth
+	}
+	if Config.DepsolveMaxDepth == 0 {
+		Config.DepsolveMaxDepth = 100
+	}
+
+	/* Concurrent request limiting */
+	if opts.MaxConcurrentRequests != 0 {
+		Config.MaxConcurrentRequests = opts.MaxConcurrentRequests
+	}
+	if opts.RequestQueueTimeout != "" {
+		Config.RequestQueueTimeout = opts.RequestQueueTimeout
+	}
+	if Config.RequestQueueTimeout != "" {
+		d, derr := time.ParseDuration(Config.RequestQueueTimeout)
+		if derr != nil {
+			logger.Criticalf("Error parsing request-queue-timeout: %s", derr.Error())
+			os.Exit(1)
+		}
+		Config.RequestQueueTimeoutDur = d
+	} else {
+		Config.RequestQueueTimeoutDur, _ = time.ParseDuration("5s")
+	}
+
+	if opts.AdminPassword != "" {
+		Config.AdminPassword = opts.AdminPassword
+	} else if p := os.Getenv("GOIARDI_ADMIN_PASSWORD"); p != "" {
+		Config.AdminPassword = p
+	}
+
+	if opts.DataStoreLocking != "" {
+		Config.DataStoreLocking = opts.DataStoreLocking
+	}
+	if Config.DataStoreLocking == "" {
+		Config.DataStoreLocking = "type"
+	} else if Config.DataStoreLocking != "type" && Config.DataStoreLocking != "global" {
+		err := fmt.Errorf("data-store-locking must be 'type' or 'global', not '%s'.", Config.DataStoreLocking)
+		log.Println(err)
+		os.Exit(1)
+	}
+
+	if opts.EventLogFailure != "" {
+		Config.EventLogFailure = opts.EventLogFailure
+	}
+	if Config.EventLogFailure == "" {
+		Config.EventLogFailure = "strict"
+	} else if Config.EventLogFailure != "strict" && Config.EventLogFailure != "best-effort" {
+		err := fmt.Errorf("event-log-failure must be 'strict' or 'best-effort', not '%s'.", Config.EventLogFailure)
+		log.Println(err)
+		os.Exit(1)
+	}
+
+	if opts.ExtendedErrors {
+		Config.ExtendedErrors = opts.ExtendedErrors
+	}
+
+	if opts.AuthDebug {
+		Config.AuthDebug = opts.AuthDebug
+	}
+
+	/* Which cookbooks clients may see. Only settable in the config
+	 * file. */
+	for actor_pat, cb_pats := range Config.CookbookVisibility {
+		for _, pat := range append([]string{ actor_pat }, cb_pats...) {
+			if _, err := path.Match(pat, ""); err != nil {
+				err := fmt.Errorf("cookbook-visibility has an invalid pattern '%s'.", pat)
+				log.Println(err)
+				os.Exit(1)
+			}
+		}
+	}
+
+	/* Regional mirrors for cookbook file downloads */
+	if opts.DownloadMirrorHeader != "" {
+		Config.DownloadMirrorHeader = opts.DownloadMirrorHeader
+	}
+	if len(opts.DownloadMirrors) != 0 {
+		Config.DownloadMirrors = make(map[string]string, len(opts.DownloadMirrors))
+		for _, m := range opts.DownloadMirrors {
+			mirror := strings.SplitN(m, "=", 2)
+			if len(mirror) != 2 || mirror[0] == "" || mirror[1] == "" {
+				err := fmt.Errorf("download-mirror must be given as value=host, not '%s'.", m)
+				log.Println(err)
+				os.Exit(1)
+			}
+			Config.DownloadMirrors[mirror[0]] = mirror[1]
+		}
+	}
+	if len(Config.DownloadMirrors) != 0 && Config.DownloadMirrorHeader == "" {
+		err := fmt.Errorf("download mirrors were given, but download-mirror-header wasn't set.")
+		log.Println(err)
+		os.Exit(1)
+	}
+
+	/* Retrying transient MySQL errors */
+	if opts.MySQLRetries != 0 {
+		Config.MySQLRetries = opts.MySQLRetries
+	}
+	if Config.MySQLRetries == 0 {
+		Config.MySQLRetries = 3
+	} else if Config.MySQLRetries < 0 {
+		Config.MySQLRetries = 0
+	}
+	if opts.MySQLRetryBackoff != "" {
+		Config.MySQLRetryBackoff = opts.MySQLRetryBackoff
+	}
+	if Config.MySQLRetryBackoff != "" {
+		d, derr := time.ParseDuration(Config.MySQLRetryBackoff)
+		if derr != nil {
+			logger.Criticalf("Error parsing mysql-retry-backoff: %s", derr.Error())
+			os.Exit(1)
+		}
+		Config.MySQLRetryBackoffDur = d
+	} else {
+		Config.MySQLRetryBackoffDur, _ = time.ParseDuration("100ms")
+	}
+
+	/* Concurrent upload limiting */
+	if opts.MaxConcurrentUploads != 0 {
+		Config.MaxConcurrentUploads = opts.MaxConcurrentUploads
+	}
+	if Config.MaxConcurrentUploads == 0 {
+		Config.MaxConcurrentUploads = 20
+	}
+	if opts.UploadQueueTimeout != "" {
+		Config.UploadQueueTimeout = opts.UploadQueueTimeout
+	}
+	if Config.UploadQueueTimeout != "" {
+		d, derr := time.ParseDuration(Config.UploadQueueTimeout)
+		if derr != nil {
+			logger.Criticalf("Error parsing upload-queue-timeout: %s", derr.Error())
+			os.Exit(1)
+		}
+		Config.UploadQueueTimeoutDur = d
+	} else {
+		Config.UploadQueueTimeoutDur, _ = time.ParseDuration("30s")
+	}
+
+	if opts.AutoCreateEnvironments {
+		Config.AutoCreateEnvironments = opts.AutoCreateEnvironments
+	}
+
+	if opts.PutCreates {
+		Config.PutCreates = opts.PutCreates
+	}
+
+	if opts.Notice != "" {
+		Config.Notice = opts.Notice
+	}
+
+	if opts.NormalizeVersions {
+		Config.NormalizeVersions = opts.NormalizeVersions
+	}
+
+	if len(opts.RequiredMetadata) != 0 {
+		Config.RequiredMetadata = opts.RequiredMetadata
+	}
+
+	if opts.SearchWhileReindexing != "" {
+		Config.SearchWhileReindexing = opts.SearchWhileReindexing
+	}
+	if Config.SearchWhileReindexing == "" {
+		Config.SearchWhileReindexing = "error"
+	}
+	if Config.SearchWhileReindexing != "error" && Config.SearchWhileReindexing != "serve" {
+		err := fmt.Errorf("search-while-reindexing must be either 'error' or 'serve', not '%s'", Config.SearchWhileReindexing)
+		log.Println(err)
+		os.Exit(1)
+	}
+
+	if opts.GzipResponses {
+		Config.GzipResponses = opts.GzipResponses
+	}
+	if opts.GzipLevel != 0 {
+		Config.GzipLevel = opts.GzipLevel
+	}
+	if Config.GzipLevel == 0 {
+		Config.GzipLevel = 6
+	}
+	if Config.GzipLevel < 1 || Config.GzipLevel > 9 {
+		err := fmt.Errorf("gzip-level must be between 1 and 9, not %d", Config.GzipLevel)
+		log.Println(err)
+		os.Exit(1)
+	}
+
+	for _, u := range opts.Webhooks {
+		Config.Webhooks = append(Config.Webhooks, WebhookConf{ URL: u, Secret: opts.WebhookSecret })
+	}
+	for _, wh := range Config.Webhooks {
+		if wh.URL == "" {
+			err := fmt.Errorf("webhooks must have a url")
+			log.Println(err)
+			os.Exit(1)
+		}
+	}
+	if opts.WebhookRetries != 0 {
+		Config.WebhookRetries = opts.WebhookRetries
+	}
+	if Config.WebhookRetries == 0 {
+		Config.WebhookRetries = 3
+	}
+	if opts.WebhookWorkers != 0 {
+		Config.WebhookWorkers = opts.WebhookWorkers
+	}
+	if Config.WebhookWorkers == 0 {
+		Config.WebhookWorkers = 4
+	}
+
+	if opts.MaxAttributeDepth != 0 {
+		Config.MaxAttributeDepth = opts.MaxAttributeDepth
+	}
+	if Config.MaxAttributeDepth == 0 {
+		Config.MaxAttributeDepth = 100
+	}
+	if opts.MaxDataBagItemSize != 0 {
+		Config.MaxDataBagItemSize = opts.MaxDataBagItemSize
+	}
+	if Config.MaxDataBagItemSize == 0 {
+		Config.MaxDataBagItemSize = 1000000
+	}
+	if opts.MaxImportSize != 0 {
+		Config.MaxImportSize = opts.MaxImportSize
+	}
+	if Config.MaxImportSize == 0 {
+		Config.MaxImportSize = 100000000
+	}
+
+	if opts.VerifyDuplicateUploads {
+		Config.VerifyDuplicateUploads = opts.VerifyDuplicateUploads
+	}
+
+	if opts.FreezeOnUpload {
+		Config.FreezeOnUpload = opts.FreezeOnUpload
+	}
+
+	if opts.DisableForceUpload {
+		Config.DisableForceUpload = opts.DisableForceUpload
+	}
+
+	if opts.StrictRoleRunLists {
+		Config.StrictRoleRunLists = opts.StrictRoleRunLists
+	}
+
+	if len(opts.AdminIPAllow) != 0 {
+		Config.AdminIPAllow = opts.AdminIPAllow
+	}
+	if Config.AdminIPAllowNets, err = ParseCIDRs(Config.AdminIPAllow); err != nil {
+		log.Println(err)
+		os.Exit(1)
+	}
+	if len(opts.TrustedProxies) != 0 {
+		Config.TrustedProxies = opts.TrustedProxies
+	}
+	if Config.TrustedProxyNets, err = ParseCIDRs(Config.TrustedProxies); err != nil {
+		log.Println(err)
+		os.Exit(1)
+	}
+
+	if opts.RecipeDepCheck != "" {
+		Config.RecipeDepCheck = opts.RecipeDepCheck
+	}
+	if Config.RecipeDepCheck != "" && Config.RecipeDepCheck != "warn" && Config.RecipeDepCheck != "strict" {
+		err := fmt.Errorf("recipe-dep-check must be either 'warn' or 'strict', not '%s'", Config.RecipeDepCheck)
+		log.Println(err)
+		os.Exit(1)
+	}
+
+	if opts.NodeStaleDeleteAfter != "" {
+		Config.NodeStaleDeleteAfter = opts.NodeStaleDeleteAfter
+	}
+	if Config.NodeStaleDeleteAfter != "" {
+		d, derr := time.ParseDuration(Config.NodeStaleDeleteAfter)
+		if derr != nil {
+			logger.Criticalf("Error parsing node-stale-delete-after: %s", derr.Error())
+			os.Exit(1)
+		}
+		if d <= 0 {
+			err := fmt.Errorf("node-stale-delete-after must be a positive duration, not '%s'", Config.NodeStaleDeleteAfter)
+			log.Println(err)
+			os.Exit(1)
+		}
+		Config.NodeStaleDeleteAfterDur = d
+	}
+	if opts.NodeStaleDryRun {
+		Config.NodeStaleDryRun = opts.NodeStaleDryRun
+	}
+
 	return nil
 }
 
+// Parse a list of networks in CIDR notation. Bare IP addresses are accepted
+// too, and are treated as a network with just that address in it.
+func ParseCIDRs(cidrs []string) ([]*net.IPNet, error) {
+	nets := make([]*net.IPNet, 0, len(cidrs))
+	for _, c := range cidrs {
+		if !strings.Contains(c, "/") {
+			ip := net.ParseIP(c)
+			if ip == nil {
+				err := fmt.Errorf("invalid IP address '%s'", c)
+				return nil, err
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip = ip.To4()
+				bits = 8 * net.IPv4len
+			}
+			nets = append(nets, &net.IPNet{ IP: ip, Mask: net.CIDRMask(bits, bits) })
+			continue
+		}
+		_, n, err := net.ParseCIDR(c)
+		if err != nil {
+			return nil, err
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+// Reports whether the IP address is in any of the given networks.
+func IPInNets(ip net.IP, nets []*net.IPNet) bool {
+	if ip == nil {
+		return false
+	}
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
 // The address and port goiardi is configured to listen on.
 func ListenAddr() string {
 	listen_addr := net.JoinHostPort(Config.Ipaddress, strconv.Itoa(Config.Port))
 	return listen_addr
 }
 
+// Whether the client or user with the given name may see the named cookbook
+// under the cookbook-visibility policy. The policy maps patterns of client
+// names to patterns of the cookbooks they may see; an actor matching more than
+// one gets all of their cookbooks. Actors that don't match any of them can see
+// every cookbook.
+func CookbookVisible(actor_name string, cookbook_name string) bool {
+	restricted := false
+	for actor_pat, cb_pats := range Config.CookbookVisibility {
+		if m, _ := path.Match(actor_pat, actor_name); !m {
+			continue
+		}
+		restricted = true
+		for _, pat := range cb_pats {
+			if m, _ := path.Match(pat, cookbook_name); m {
+				return true
+			}
+		}
+	}
+	return !restricted
+}
+
+// Returns the host to use in cookbook file download URLs for a request whose
+// download mirror header has the given value, or the empty string if no mirror
+// is set up for that value and the server's own host should be used.
+func DownloadMirrorHost(value string) string {
+	if value == "" {
+		return ""
+	}
+	for k, host := range Config.DownloadMirrors {
+		if strings.EqualFold(k, value) {
+			return host
+		}
+	}
+	return ""
+}
+
 // The hostname and port goiardi is configured to use.
 func ServerHostname() string {
 	if !(Config.Port == 80 || Config.Port == 443) {
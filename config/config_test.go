@@ -0,0 +1,112 @@
+/*
+ * Copyright (c) 2013-2014, Jeremy Bingham (<jbingham@gmail.com>)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestReloadConfig(t *testing.T) {
+	f, err := ioutil.TempFile("", "goiardi-conf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	conf := "time-slew = \"5m\"\nlog-event-keep = 10\nmax-concurrent-requests = 7\nrequest-queue-timeout = \"2s\"\nmax-concurrent-uploads = 3\nupload-queue-timeout = \"9s\"\n"
+	if _, err := f.WriteString(conf); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	old_file := Config.ConfFile
+	old_slew := Config.TimeSlewDur
+	Config.ConfFile = f.Name()
+	Config.TimeSlewDur = 15 * time.Minute
+	defer func() {
+		reloadLock.Lock()
+		live = nil
+		reloadLock.Unlock()
+		Config.ConfFile = old_file
+		Config.TimeSlewDur = old_slew
+	}()
+
+	/* Reload while other goroutines are reading the live options and
+	 * Config itself; run with -race to check this. */
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				TimeSlew()
+				LogEventKeep()
+				RequestLimits()
+				UploadLimits()
+				_ = Config.TimeSlewDur
+				_ = Config.MaxConcurrentRequests
+			}
+		}()
+	}
+	for i := 0; i < 5; i++ {
+		if err := ReloadConfig(); err != nil {
+			t.Errorf("ReloadConfig returned an error: %s", err.Error())
+		}
+	}
+	wg.Wait()
+
+	if s := TimeSlew(); s != 5 * time.Minute {
+		t.Errorf("time-slew should have been reloaded as 5m, got %s", s)
+	}
+	if k := LogEventKeep(); k != 10 {
+		t.Errorf("log-event-keep should have been reloaded as 10, got %d", k)
+	}
+	if m, d := RequestLimits(); m != 7 || d != 2 * time.Second {
+		t.Errorf("request limits should have been reloaded as 7 and 2s, got %d and %s", m, d)
+	}
+	if m, d := UploadLimits(); m != 3 || d != 9 * time.Second {
+		t.Errorf("upload limits should have been reloaded as 3 and 9s, got %d and %s", m, d)
+	}
+	if Config.TimeSlewDur != 15 * time.Minute {
+		t.Errorf("ReloadConfig should not change Config, but TimeSlewDur is now %s", Config.TimeSlewDur)
+	}
+}
+
+func TestReloadConfigBadValue(t *testing.T) {
+	f, err := ioutil.TempFile("", "goiardi-conf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString("time-slew = \"not a duration\"\n")
+	f.Close()
+
+	old_file := Config.ConfFile
+	Config.ConfFile = f.Name()
+	defer func() { Config.ConfFile = old_file }()
+
+	before := TimeSlew()
+	if err := ReloadConfig(); err == nil {
+		t.Errorf("ReloadConfig should have failed with a bad time-slew")
+	}
+	if after := TimeSlew(); after != before {
+		t.Errorf("a failed reload should not change time-slew, but it went from %s to %s", before, after)
+	}
+}
@@ -0,0 +1,231 @@
+/* Reloading the configuration while goiardi is running. */
+
+/*
+ * Copyright (c) 2013-2014, Jeremy Bingham (<jbingham@gmail.com>)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package config
+
+import (
+	"fmt"
+	"github.com/BurntSushi/toml"
+	"git.tideland.biz/goas/logger"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+)
+
+/* Options that can be changed on a running server are read through the
+ * functions below rather than straight from Config. ReloadConfig never writes
+ * to Config; it builds a new set of live options and swaps it in under
+ * reloadLock, so Config itself is left as it was at startup and is safe to
+ * read without a lock. */
+var reloadLock sync.RWMutex
+
+type liveOptions struct {
+	timeSlew time.Duration
+	logEventKeep int
+	maxRequests int
+	requestTimeout time.Duration
+	maxUploads int
+	uploadTimeout time.Duration
+}
+
+/* The options currently in effect. Until the config is reloaded for the
+ * first time this is nil, and the values from Config are used. */
+var live *liveOptions
+
+/* The config file's contents as they were at startup, and the command line
+ * options, so a reload knows what the file used to say and which options the
+ * command line overrides. */
+var fileConf Conf
+var cliOpts = &Options{ }
+
+func liveConf() liveOptions {
+	reloadLock.RLock()
+	defer reloadLock.RUnlock()
+	return currentLive()
+}
+
+/* Must be called with reloadLock held. */
+func currentLive() liveOptions {
+	if live != nil {
+		return *live
+	}
+	return liveOptions{
+		timeSlew: Config.TimeSlewDur,
+		logEventKeep: Config.LogEventKeep,
+		maxRequests: Config.MaxConcurrentRequests,
+		requestTimeout: Config.RequestQueueTimeoutDur,
+		maxUploads: Config.MaxConcurrentUploads,
+		uploadTimeout: Config.UploadQueueTimeoutDur,
+	}
+}
+
+// The time difference allowed between the server's clock and a request's
+// X-OPS-TIMESTAMP header.
+func TimeSlew() time.Duration {
+	return liveConf().timeSlew
+}
+
+// How many events to keep in the event log. 0 means keep them all.
+func LogEventKeep() int {
+	return liveConf().logEventKeep
+}
+
+// The maximum number of requests handled at once, and how long a request will
+// wait for a slot before being rejected.
+func RequestLimits() (int, time.Duration) {
+	l := liveConf()
+	return l.maxRequests, l.requestTimeout
+}
+
+// The maximum number of uploads handled at once, and how long an upload will
+// wait for a slot before being rejected.
+func UploadLimits() (int, time.Duration) {
+	l := liveConf()
+	return l.maxUploads, l.uploadTimeout
+}
+
+// Reread the config file and apply the options that are safe to change while
+// goiardi is running: log-level and debug-level, time-slew, log-event-keep,
+// max-concurrent-requests, request-queue-timeout, max-concurrent-uploads, and
+// upload-queue-timeout. Options given on the command line still take
+// precedence over the file. Changes to options that can't be applied without a
+// restart, like the storage backend or the listen address, are logged and
+// otherwise ignored. Nothing is changed if the file can't be read or has a bad
+// value in it. The new values are only available through the functions above;
+// the fields in Config keep their startup values.
+func ReloadConfig() error {
+	if Config.ConfFile == "" {
+		err := fmt.Errorf("No config file was given at startup, so there is nothing to reload.")
+		return err
+	}
+	newConf := InitConfig()
+	if _, err := toml.DecodeFile(Config.ConfFile, newConf); err != nil {
+		return err
+	}
+
+	time_slew, err := reloadDuration("time-slew", newConf.TimeSlew, "15m")
+	if err != nil {
+		return err
+	}
+	request_timeout, err := reloadDuration("request-queue-timeout", newConf.RequestQueueTimeout, "5s")
+	if err != nil {
+		return err
+	}
+	upload_timeout, err := reloadDuration("upload-queue-timeout", newConf.UploadQueueTimeout, "30s")
+	if err != nil {
+		return err
+	}
+	if newConf.MaxConcurrentUploads == 0 {
+		newConf.MaxConcurrentUploads = 20
+	}
+
+	for _, o := range restartOnlyOptions(newConf) {
+		logger.Warningf("%s has changed in the config file, but can't be changed without restarting goiardi. Ignoring.", o)
+	}
+
+	reloadLock.Lock()
+	defer reloadLock.Unlock()
+
+	l := currentLive()
+	if len(cliOpts.Verbose) == 0 {
+		logger.SetLevel(logger.LogLevel(debugLevel(newConf.DebugLevel, newConf.LogLevel)))
+	}
+	if cliOpts.TimeSlew == "" {
+		l.timeSlew = time_slew
+	}
+	if cliOpts.LogEventKeep == 0 {
+		l.logEventKeep = newConf.LogEventKeep
+	}
+	if cliOpts.MaxConcurrentRequests == 0 {
+		l.maxRequests = newConf.MaxConcurrentRequests
+	}
+	if cliOpts.RequestQueueTimeout == "" {
+		l.requestTimeout = request_timeout
+	}
+	if cliOpts.MaxConcurrentUploads == 0 {
+		l.maxUploads = newConf.MaxConcurrentUploads
+	}
+	if cliOpts.UploadQueueTimeout == "" {
+		l.uploadTimeout = upload_timeout
+	}
+	live = &l
+
+	return nil
+}
+
+func reloadDuration(name string, value string, def string) (time.Duration, error) {
+	if value == "" {
+		value = def
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		err = fmt.Errorf("Error parsing %s: %s", name, err.Error())
+		return 0, err
+	}
+	return d, nil
+}
+
+/* Work out the logger level from the debug-level and log-level options the
+ * same way ParseConfigOptions does. */
+func debugLevel(dlev int, log_level string) int {
+	if log_level != "" {
+		if lev, ok := LogLevelNames[strings.ToLower(log_level)]; ok && dlev == 0 {
+			dlev = lev
+		}
+	}
+	if dlev > 4 {
+		dlev = 4
+	}
+	return int(logger.LevelCritical) - dlev
+}
+
+/* The names of options that have changed in the config file since startup
+ * but are only read when goiardi starts. */
+func restartOnlyOptions(newConf *Conf) []string {
+	opts := []struct{
+		name string
+		was interface{}
+		now interface{}
+	}{
+		{ "ipaddress", fileConf.Ipaddress, newConf.Ipaddress },
+		{ "port", fileConf.Port, newConf.Port },
+		{ "hostname", fileConf.Hostname, newConf.Hostname },
+		{ "data-file", fileConf.DataStoreFile, newConf.DataStoreFile },
+		{ "index-file", fileConf.IndexFile, newConf.IndexFile },
+		{ "freeze-interval", fileConf.FreezeInterval, newConf.FreezeInterval },
+		{ "log-file", fileConf.LogFile, newConf.LogFile },
+		{ "use-ssl", fileConf.UseSSL, newConf.UseSSL },
+		{ "ssl-cert", fileConf.SslCert, newConf.SslCert },
+		{ "ssl-key", fileConf.SslKey, newConf.SslKey },
+		{ "use-mysql", fileConf.UseMySQL, newConf.UseMySQL },
+		{ "mysql", fileConf.MySQL, newConf.MySQL },
+		{ "use-postgres", fileConf.UsePostgreSQL, newConf.UsePostgreSQL },
+		{ "postgresql", fileConf.PostgreSQL, newConf.PostgreSQL },
+		{ "local-filestore-dir", fileConf.LocalFstoreDir, newConf.LocalFstoreDir },
+		{ "use-s3", fileConf.UseS3, newConf.UseS3 },
+		{ "s3", fileConf.S3, newConf.S3 },
+	}
+	changed := make([]string, 0)
+	for _, o := range opts {
+		if !reflect.DeepEqual(o.was, o.now) {
+			changed = append(changed, o.name)
+		}
+	}
+	return changed
+}
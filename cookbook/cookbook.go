@@ -31,8 +31,11 @@ import (
 	"sort"
 	"git.tideland.biz/goas/logger"
 	"net/http"
+	"net/url"
 	"regexp"
 	"database/sql"
+	"sync"
+	"time"
 )
 
 // Make version strings with the format "x.y.z" sortable.
@@ -43,9 +46,14 @@ type VersionStrings []string
 type Cookbook struct {
 	Name string
 	Versions map[string]*CookbookVersion
+	Aliases map[string]string
 	latest *CookbookVersion
 	numVersions *int
 	id int32
+	/* Guards Versions, Aliases, latest, and numVersions, since simultaneous
+	 * uploads can add and remove versions while others read them. Being
+	 * unexported, it isn't in the JSON or the freeze file. */
+	mu sync.RWMutex
 }
 
 /* We... want the JSON tags for this. */
@@ -69,8 +77,10 @@ type CookbookVersion struct {
 	Files []map[string]interface{} `json:"files"`
 	IsFrozen bool `json:"frozen?"`
 	Metadata map[string]interface{} `json:"metadata"` 
+	CreatedAt time.Time `json:"-"`
 	id int32
 	cookbook_id int32
+	undeclaredDeps []string
 }
 
 /* Cookbook methods and functions */
@@ -98,9 +108,9 @@ func New(name string) (*Cookbook, util.Gerror){
 		err := util.Errorf("Invalid cookbook name '%s' using regex: 'Malformed cookbook name. Must only contain A-Z, a-z, 0-9, _ or -'.", name)
 		return nil, err
 	}
-	if config.Config.UseMySQL {
+	if config.Config.UseSQL {
 		var cerr error
-		found, cerr = checkForCookbookMySQL(data_store.Dbh, name)
+		found, cerr = checkForCookbookSQL(data_store.Dbh, name)
 		if cerr != nil {
 			err := util.CastErr(cerr)
 			err.SetStatus(http.StatusInternalServerError)
@@ -123,20 +133,24 @@ func New(name string) (*Cookbook, util.Gerror){
 
 // The number of versions this cookbook has.
 func (c *Cookbook)NumVersions() int {
-	if config.Config.UseMySQL {
+	if config.Config.UseSQL {
+		c.mu.Lock()
+		defer c.mu.Unlock()
 		if c.numVersions == nil {
-			c.numVersions = c.numVersionsMySQL()
+			c.numVersions = c.numVersionsSQL()
 		}
 		return *c.numVersions
 	} else {
+		c.mu.RLock()
+		defer c.mu.RUnlock()
 		return len(c.Versions)
 	}
 }
 
 // Return all the cookbooks that have been uploaded to this server.
 func AllCookbooks() (cookbooks []*Cookbook) {
-	if config.Config.UseMySQL {
-		cookbooks = allCookbooksMySQL()
+	if config.Config.UseSQL {
+		cookbooks = allCookbooksSQL()
 	} else {
 		cookbook_list := GetList()
 		for _, c := range cookbook_list {
@@ -151,13 +165,72 @@ func AllCookbooks() (cookbooks []*Cookbook) {
 	return cookbooks
 }
 
+/* The running count of cookbook versions in the in-memory data store. */
+var versionCount = data_store.NewCounter(countVersions)
+
+func countVersions() int {
+	n := 0
+	for _, cb := range AllCookbooks() {
+		n += cb.NumVersions()
+	}
+	return n
+}
+
+// The number of cookbook versions of every cookbook in the in-memory data
+// store, without looking through all the cookbooks.
+func TotalVersions() int {
+	return versionCount.Value()
+}
+
+// Get the latest version of every cookbook, as a map of cookbook names to
+// version strings. Cookbooks without any versions are left out. With MySQL this
+// is done in one query, without loading any of the cookbook versions.
+func LatestVersions() map[string]string {
+	if config.Config.UseSQL {
+		return latestVersionsSQL()
+	}
+	latest := make(map[string]string)
+	for _, cb := range AllCookbooks() {
+		if cbv := cb.LatestVersion(); cbv != nil {
+			latest[cb.Name] = cbv.Version
+		}
+	}
+	return latest
+}
+
+// Count the cookbook versions created in buckets of the given interval, oldest
+// bucket first, to see how quickly cookbook versions are piling up. Buckets are
+// lined up on multiples of the interval since the Unix epoch, and buckets with
+// no new versions are left out. In-memory versions uploaded before creation
+// times were recorded aren't counted.
+func VersionGrowth(interval time.Duration) ([]*util.TimeBucket, error) {
+	if interval < time.Second {
+		err := fmt.Errorf("Growth interval must be at least one second")
+		return nil, err
+	}
+	secs := int64(interval / time.Second)
+	if config.Config.UseSQL {
+		return versionGrowthSQL(secs)
+	}
+	counts := make(map[int64]int)
+	for _, cb := range AllCookbooks() {
+		for _, cbv := range cb.sortedVersions() {
+			if cbv.CreatedAt.IsZero() {
+				continue
+			}
+			counts[cbv.CreatedAt.Unix() / secs]++
+		}
+	}
+	return util.TimeBuckets(counts, secs), nil
+}
+
 // Get a cookbook.
 func Get(name string) (*Cookbook, util.Gerror){
 	var cookbook *Cookbook
 	var found bool
-	if config.Config.UseMySQL {
+	if config.Config.UseSQL {
 		var err error
-		cookbook, err = getCookbookMySQL(name)
+		cookbook, err = getCookbookSQL(name)
 		if err != nil {
 			if err == sql.ErrNoRows {
 				found = false
@@ -187,29 +260,92 @@ func Get(name string) (*Cookbook, util.Gerror){
 
 // Save a cookbook to the in-memory data store or database.
 func (c *Cookbook) Save() error {
-	if config.Config.UseMySQL {
-		return c.saveCookbookMySQL()
+	if config.Config.UseSQL {
+		return c.saveCookbookSQL()
 	} else {
 		ds := data_store.New()
 		ds.Set("cookbook", c.Name, c)
+		/* Versions may have been added to or taken out of the map
+		 * directly, so bring the index of their files up to date. */
+		hashRefs.syncCookbook(c.Name, c.sortedVersions())
 	}
 	return nil
 }
 
 func (c *Cookbook) Delete() error {
-	if config.Config.UseMySQL {
-		return c.deleteCookbookMySQL()
+	if config.Config.UseSQL {
+		return c.deleteCookbookSQL()
 	} else {
 		ds := data_store.New()
 		ds.Delete("cookbook", c.Name)
+		versionCount.Add(-c.NumVersions())
+		hashRefs.removeCookbook(c.Name)
 	}
 	return nil
 }
 
+// Delete every version of each of the named cookbooks, and the cookbooks
+// themselves, returning the names of the cookbooks that were deleted. Names
+// that aren't cookbooks on this server are skipped. Files only the deleted
+// cookbooks used are removed from the filestore, but files other cookbooks
+// still use are kept. With SQL the cookbooks are all deleted in one
+// transaction, so if one can't be deleted none of them are.
+func DeleteCookbooks(names []string) (deleted []string, err util.Gerror) {
+	cookbooks := make([]*Cookbook, 0, len(names))
+	seen := make(map[string]bool)
+	for _, name := range names {
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+		cb, gerr := Get(name)
+		if gerr != nil {
+			if gerr.Status() == http.StatusNotFound {
+				continue
+			}
+			return nil, gerr
+		}
+		cookbooks = append(cookbooks, cb)
+	}
+	deleted = make([]string, 0, len(cookbooks))
+	if len(cookbooks) == 0 {
+		return deleted, nil
+	}
+
+	file_hashes := make([]string, 0)
+	for _, cb := range cookbooks {
+		for _, cbv := range cb.sortedVersions() {
+			file_hashes = append(file_hashes, cbv.fileHashes()...)
+		}
+		deleted = append(deleted, cb.Name)
+	}
+	if config.Config.UseSQL {
+		if derr := deleteCookbooksSQL(cookbooks); derr != nil {
+			err = util.CastErr(derr)
+			err.SetStatus(http.StatusInternalServerError)
+			return nil, err
+		}
+	} else {
+		ds := data_store.New()
+		for _, cb := range cookbooks {
+			ds.Delete("cookbook", cb.Name)
+			versionCount.Add(-cb.NumVersions())
+		}
+	}
+	/* Only now that the cookbooks are gone can deleteHashes tell which
+	 * files are still used by what's left. */
+	for _, cb := range cookbooks {
+		hashRefs.removeCookbook(cb.Name)
+	}
+	sort.Strings(file_hashes)
+	cookbooks[0].deleteHashes(removeDupHashes(file_hashes))
+	return deleted, nil
+}
+
 // Get a list of all cookbooks on this server.
 func GetList() []string {
-	if config.Config.UseMySQL {
-		return getCookbookListMySQL()
+	if config.Config.UseSQL {
+		return getCookbookListSQL()
 	} 
 	ds := data_store.New()
 	cb_list := ds.GetList("cookbook")
@@ -218,8 +354,21 @@ func GetList() []string {
 
 /* Returns a sorted list of all the versions of this cookbook */
 func (c *Cookbook)sortedVersions() ([]*CookbookVersion){
-	if config.Config.UseMySQL {
-		return c.sortedCookbookVersionsMySQL()
+	/* Getting them from the database fills in c.Versions as it goes. */
+	if config.Config.UseSQL {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+	} else {
+		c.mu.RLock()
+		defer c.mu.RUnlock()
+	}
+	return c.sortedVersionsLocked()
+}
+
+/* sortedVersions, for when c.mu is already held. */
+func (c *Cookbook)sortedVersionsLocked() ([]*CookbookVersion){
+	if config.Config.UseSQL {
+		return c.sortedCookbookVersionsSQL()
 	} 
 	sorted := make([]*CookbookVersion, len(c.Versions))
 	keys := make(VersionStrings, len(c.Versions))
@@ -244,14 +393,33 @@ func (c *Cookbook)sortedVersions() ([]*CookbookVersion){
 
 // Update what the cookbook stores as the latest version available.
 func (c *Cookbook) UpdateLatestVersion() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 	c.latest = nil
-	c.LatestVersion()
+	c.latestVersionLocked()
 }
 
-// Get the latest version of this cookbook.
+// Get the latest version of this cookbook, or nil if it doesn't have any
+// versions. A cookbook without versions can be left behind for a moment by a
+// failed upload, so this does need checking.
 func (c *Cookbook) LatestVersion() *CookbookVersion {
+	c.mu.RLock()
+	latest := c.latest
+	c.mu.RUnlock()
+	if latest != nil {
+		return latest
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.latestVersionLocked()
+}
+
+func (c *Cookbook) latestVersionLocked() *CookbookVersion {
 	if c.latest == nil {
-		sorted := c.sortedVersions()
+		sorted := c.sortedVersionsLocked()
+		if len(sorted) == 0 {
+			return nil
+		}
 		c.latest = sorted[0]
 	}
 	return c.latest
@@ -260,19 +428,66 @@ func (c *Cookbook) LatestVersion() *CookbookVersion {
 // Gets num_results (or all if num_results is nil) versions of a cookbook,
 // returning a hash describing the cookbook and the versions returned.
 func (c *Cookbook)InfoHash(num_results interface{}) map[string]interface{} {
-	return c.infoHashBase(num_results, "")
+	return c.infoHashBase(num_results, "", nil)
+}
+
+// Like InfoHash, but only considers versions whose frozen status matches
+// frozen. num_results counts only the versions that match.
+func (c *Cookbook)FrozenInfoHash(num_results interface{}, frozen bool) map[string]interface{} {
+	return c.infoHashBase(num_results, "", func(cbv *CookbookVersion) bool { return cbv.IsFrozen == frozen })
 }
 
 // Gets num_results (or all if num_results is nil) versions of a cookbook that
 // match the given constraint and returns a hash describing the cookbook and the
 // versions returned.
 func (c *Cookbook)ConstrainedInfoHash(num_results interface{}, constraint string) map[string]interface{} {
-	return c.infoHashBase(num_results, constraint)
+	return c.infoHashBase(num_results, constraint, nil)
+}
+
+// The cookbook's entry in the universe, mapping each of its versions to where
+// the version can be fetched and what it depends on, the way Berkshelf
+// expects. Only the newest num_versions versions are included, or all of them
+// if num_versions is 0, and if filter isn't nil only the versions it returns
+// true for are included or counted.
+func (c *Cookbook) UniverseEntry(num_versions int, filter func(*CookbookVersion) bool) map[string]interface{} {
+	entry := make(map[string]interface{})
+	for _, cbv := range c.sortedVersions() {
+		if num_versions > 0 && len(entry) >= num_versions {
+			break
+		}
+		if filter != nil && !filter(cbv) {
+			continue
+		}
+		deps, ok := cbv.Metadata["dependencies"].(map[string]interface{})
+		if !ok || deps == nil {
+			deps = make(map[string]interface{})
+		}
+		entry[cbv.Version] = map[string]interface{}{
+			"location_type": "opscode",
+			"location_path": util.CustomObjURL(c, cbv.Version),
+			"dependencies": deps,
+		}
+	}
+	return entry
 }
 
 // For the given run list and environment constraints, return the cookbook
-// dependencies.
-func DependsCookbooks(run_list []string, env_constraints map[string]string) (map[string]interface{}, error) {
+// dependencies. Errors from resolving the dependencies have the
+// http.StatusPreconditionFailed status set, while requests that exceed the
+// configured depsolver limits are bad requests.
+func DependsCookbooks(run_list []string, env_constraints map[string]string) (map[string]interface{}, util.Gerror) {
+	return DependsCookbooksTimed(run_list, env_constraints, nil)
+}
+
+// DependsCookbooks, but recording how long each part of the depsolve took in
+// timing. A nil timing records nothing.
+func DependsCookbooksTimed(run_list []string, env_constraints map[string]string, timing *DepsolveTiming) (map[string]interface{}, util.Gerror) {
+	timing.start()
+	defer timing.finish()
+	if max := config.Config.DepsolveMaxRunList; max > 0 && len(run_list) > max {
+		err := util.Errorf("The run list has %d items, which is more than the %d allowed.", len(run_list), max)
+		return nil, err
+	}
 	cd_list := make(map[string][]string, len(run_list))
 	run_list_ref := make([]string, len(run_list))
 
@@ -306,7 +521,13 @@ func DependsCookbooks(run_list []string, env_constraints map[string]string) (map
 			_, orgver, _ := splitConstraint(cd_list[k][0])
 			newop, newver, nerr := splitConstraint(ec)
 			if nerr != nil {
-				return nil, nerr
+				return nil, depsolveErr(nerr)
+			}
+			/* Excluding a version doesn't override anything, it
+			 * just has to be satisfied as well. */
+			if newop == "!=" {
+				cd_list[k] = append(cd_list[k], ec)
+				continue
 			}
 			/* if the versions are equal, take the env one */
 			if orgver == newver {
@@ -325,34 +546,43 @@ func DependsCookbooks(run_list []string, env_constraints map[string]string) (map
 				case "=":
 					if orgver != newver {
 						err := fmt.Errorf("This run list has a constraint '%s' for %s that conflicts with '%s' in the environment's cookbook versions.", cd_list[k][0], k, ec)
-						return nil, err
+						return nil, depsolveErr(err)
 					}
 				case "~>":
 					if action := verConstraintCheck(orgver, newver, newop); action == "ok" {
 						cd_list[k] = []string{ ec }
 					} else {
 						err := fmt.Errorf("This run list has a constraint '%s' for %s that conflicts with '%s' in the environment's cookbook versions.", cd_list[k][0], k, ec)
-						return nil, err
+						return nil, depsolveErr(err)
 					}
 				default:
 					err := fmt.Errorf("An unlikely occurance, but the constraint '%s' for cookbook %s in this environment is impossible.", ec, k)
-					return nil, err
+					return nil, depsolveErr(err)
 			}
  		}
  	}
 
+	/* The cookbook versions whose dependencies have already been
+	 * resolved, so cycles and diamonds aren't walked again. */
+	seen := make(map[string]bool)
+
 	/* Build a slice holding all the needed cookbooks. */
 	for _, cbName := range run_list_ref {
+		fetch_start := time.Now()
 		c, err := Get(cbName)
 		if err != nil {
-			return nil, err
+			return nil, depsolveErr(err)
 		}
 		cbv := c.LatestConstrained(cd_list[cbName][0])
+		timing.fetched(fetch_start)
 		if cbv == nil {
-			return nil, fmt.Errorf("No cookbook found for %s that satisfies constraint '%s'", c.Name, cd_list[cbName][0])
+			return nil, depsolveErr(fmt.Errorf("No cookbook found for %s that satisfies constraint '%s'", c.Name, cd_list[cbName][0]))
+		}
+		if seen[cbv.Name] {
+			continue
 		}
-		
-		nerr := cbv.resolveDependencies(cd_list)
+		seen[cbv.Name] = true
+		nerr := cbv.resolveDependencies(cd_list, []string{ cbv.CookbookName }, seen, timing)
 		if nerr != nil {
 			return nil, nerr
 		}
@@ -360,24 +590,27 @@ func DependsCookbooks(run_list []string, env_constraints map[string]string) (map
 
 	cookbook_deps := make(map[string]interface{}, len(cd_list))
 	for cname, traints := range cd_list {
+		fetch_start := time.Now()
 		cb, err := Get(cname)
 		/* Although we would have already seen this, but being careful
 		 * rarely hurt. */
 		if err != nil {
-			return nil, err
+			return nil, depsolveErr(err)
 		}
 		var gcbv *CookbookVersion
 
-		for _, cv := range cb.sortedVersions(){
-			Vers:
+		sorted_versions := cb.sortedVersions()
+		timing.fetched(fetch_start)
+		Vers:
+		for _, cv := range sorted_versions {
 			for _, ct := range traints {
 				if ct != "" { // no constraint
 					op, ver, err := splitConstraint(ct)
 					if err != nil {
-						return nil, err
+						return nil, depsolveErr(err)
 					}
 					if action := verConstraintCheck(cv.Version, ver, op); action != "ok" {
-						// BREAK THIS LOOP, BUT CONTINUE THE cv LOOP. HMM
+						/* On to the next version. */
 						continue Vers
 					}
 				}
@@ -389,8 +622,9 @@ func DependsCookbooks(run_list []string, env_constraints map[string]string) (map
 		}
 		if gcbv == nil {
 			err := fmt.Errorf("Unfortunately no version of %s could satisfy the requested constraints: %s", cname, strings.Join(traints, ", "))
-			return nil, err
+			return nil, depsolveErr(err)
 		} else {
+			serialize_start := time.Now()
 			gcbvJson := gcbv.ToJson("POST")
 			/* Sigh. For some reason, *some* places want nothing
 			 * sent for cookbook information divisions like 
@@ -399,32 +633,95 @@ func DependsCookbooks(run_list []string, env_constraints map[string]string) (map
 			 * environment/<env>/cookbook_versions is one of them.
 			 * Go through the list of possibly guilty divisions and
 			 * set them to an empty slice of maps if they're nil. */
-			chkDiv := []string{ "definitions", "libraries", "attributes", "providers", "resources", "templates", "root_files", "files" }
+			chkDiv := []string{ "definitions", "libraries", "attributes", "providers", "resources", "templates", "root_files", "files", "recipes" }
 			for _, cd := range chkDiv {
-				if gcbvJson[cd] == nil {
+				if d, _ := gcbvJson[cd].([]map[string]interface{}); d == nil {
 					gcbvJson[cd] = make([]map[string]interface{}, 0)
 				}
 			}
 			cookbook_deps[gcbv.CookbookName] = gcbvJson
+			timing.serialized(serialize_start)
 		}
 	}
 
 	return cookbook_deps, nil
 }
 
-func (cbv *CookbookVersion)resolveDependencies(cd_list map[string][]string) error {
+// A diagnostic version of DependsCookbooks. If the whole run list resolves, the
+// results are the same as DependsCookbooks with no failures. Otherwise, each
+// item in the run list is resolved on its own, and the cookbooks that resolved
+// are returned along with a list of the run list items that failed and why.
+// Cookbooks that resolve to different versions for different run list items
+// are reported as failures too. The results may not be what a full depsolve
+// would give, so they're for finding the problem, not for converging with.
+func DependsCookbooksPartial(run_list []string, env_constraints map[string]string) (map[string]interface{}, []map[string]string, util.Gerror) {
+	resolved, err := DependsCookbooks(run_list, env_constraints)
+	if err == nil {
+		return resolved, make([]map[string]string, 0), nil
+	} else if err.Status() != http.StatusPreconditionFailed {
+		/* Not a depsolving problem, like too long a run list. */
+		return nil, nil, err
+	}
+	resolved, failures := DependsCookbooksEach(run_list, env_constraints)
+	return resolved, failures, nil
+}
+
+// The second half of DependsCookbooksPartial: resolve each item in the run list
+// on its own, returning the cookbooks that resolved and the items that failed
+// and why. For when DependsCookbooks has already failed on the whole run list
+// with a 412, so it doesn't have to be run again.
+func DependsCookbooksEach(run_list []string, env_constraints map[string]string) (map[string]interface{}, []map[string]string) {
+	failures := make([]map[string]string, 0)
+	resolved := make(map[string]interface{})
+	for _, item := range run_list {
+		deps, derr := DependsCookbooks([]string{ item }, env_constraints)
+		if derr != nil {
+			failures = append(failures, map[string]string{ "run_list_item": item, "error": derr.Error() })
+			continue
+		}
+		for name, d := range deps {
+			prev, found := resolved[name]
+			if !found {
+				resolved[name] = d
+				continue
+			}
+			pv := prev.(map[string]interface{})["version"]
+			dv := d.(map[string]interface{})["version"]
+			if pv != dv {
+				msg := fmt.Sprintf("Cookbook %s resolved to version %v here, but %v for an earlier run list item.", name, dv, pv)
+				failures = append(failures, map[string]string{ "run_list_item": item, "error": msg })
+			}
+		}
+	}
+	return resolved, failures
+}
+
+/* path holds the names of the cookbooks on the current resolution path, ending
+ * with this one, for limiting how deep dependencies can go. seen holds the
+ * names (like "foo-1.2.3") of the cookbook versions that have already been
+ * resolved. Circular dependencies are allowed, like other Chef servers allow
+ * them: a dependency on a version that's already been seen still has to agree
+ * with the constraints so far, but isn't followed again, so cycles don't
+ * recurse forever. */
+func (cbv *CookbookVersion)resolveDependencies(cd_list map[string][]string, path []string, seen map[string]bool, timing *DepsolveTiming) util.Gerror {
+	if max := config.Config.DepsolveMaxDepth; max > 0 && len(path) > max {
+		err := util.Errorf("Cookbook dependencies for %s are nested more than %d levels deep.", cbv.CookbookName, max)
+		return err
+	}
 	dep_list := cbv.Metadata["dependencies"].(map[string]interface{})
 
 	for r, c2 := range dep_list {
 		c := c2.(string)
+		fetch_start := time.Now()
 		dep_cb, err := Get(r)
 		if err != nil {
-			return err
+			return depsolveErr(err)
 		}
 		deb_cbv := dep_cb.LatestConstrained(c)
+		timing.fetched(fetch_start)
 		if deb_cbv == nil {
 			err := fmt.Errorf("No cookbook version for %s satisfies constraint '%s'.", r, c)
-			return err
+			return depsolveErr(err)
 		}
 
 		/* Do we satisfy the constraints we have? */
@@ -433,12 +730,12 @@ func (cbv *CookbookVersion)resolveDependencies(cd_list map[string][]string) erro
 				if dcon != "" {
 					op, ver, err := splitConstraint(dcon)
 					if err != nil {
-						return err
+						return depsolveErr(err)
 					}
 					stat := verConstraintCheck(deb_cbv.Version, ver, op)
 					if stat != "ok" {
 						err := fmt.Errorf("Oh no! Cookbook %s (ver %s) depends on a version of cookbook %s matching the constraint '%s', but that constraint conflicts with the previous constraint of '%s'. Bailing, sorry.", cbv.CookbookName, cbv.Version, deb_cbv.CookbookName, c, dcon)
-						return err
+						return depsolveErr(err)
 					}
 				}
 			}
@@ -446,8 +743,12 @@ func (cbv *CookbookVersion)resolveDependencies(cd_list map[string][]string) erro
 			/* Add our constraint */
 			cd_list[r] = []string{c}
 		}
-		
-		nerr := deb_cbv.resolveDependencies(cd_list)
+
+		if seen[deb_cbv.Name] {
+			continue
+		}
+		seen[deb_cbv.Name] = true
+		nerr := deb_cbv.resolveDependencies(cd_list, append(path[:len(path):len(path)], r), seen, timing)
 		if nerr != nil {
 			return nerr
 		}
@@ -455,6 +756,14 @@ func (cbv *CookbookVersion)resolveDependencies(cd_list map[string][]string) erro
 	return nil
 }
 
+// Errors from resolving dependencies are reported back as precondition
+// failures, since that's what chef-client expects.
+func depsolveErr(err error) util.Gerror {
+	gerr := util.CastErr(err)
+	gerr.SetStatus(http.StatusPreconditionFailed)
+	return gerr
+}
+
 func splitConstraint(constraint string) (string, string, error) {
 	t1 := strings.Split(constraint, " ")
 	if len(t1) != 2 {
@@ -467,7 +776,7 @@ func splitConstraint(constraint string) (string, string, error) {
 	}
 }
 
-func (c *Cookbook)infoHashBase(num_results interface{}, constraint string) map[string]interface{} {
+func (c *Cookbook)infoHashBase(num_results interface{}, constraint string, filter func(*CookbookVersion) bool) map[string]interface{} {
 	cb_hash := make(map[string]interface{})
 	cb_hash["url"] = util.ObjURL(c)
 	
@@ -511,6 +820,9 @@ func (c *Cookbook)infoHashBase(num_results interface{}, constraint string) map[s
 		if !all_versions && nr >= num_versions {
 			break
 		} 
+		if filter != nil && !filter(cv) {
+			continue
+		}
 		/* Version constraint checking. */
 		if constraint != "" {
 			con_action := verConstraintCheck(cv.Version, constraint_version, constraint_op)
@@ -566,13 +878,36 @@ func (c *Cookbook) LatestConstrained(constraint string) *CookbookVersion{
 
 /* CookbookVersion methods and functions */
 
+// Does this version satisfy the constraint, like ">= 1.2.0"? A bare version
+// like the ones environments can use pins the cookbook to exactly that
+// version. Malformed constraints aren't satisfied by anything.
+func (cbv *CookbookVersion) Satisfies(constraint string) bool {
+	if !strings.Contains(constraint, " ") {
+		constraint = fmt.Sprintf("= %s", constraint)
+	}
+	op, ver, err := splitConstraint(constraint)
+	if err != nil {
+		return false
+	}
+	return verConstraintCheck(cbv.Version, ver, op) == "ok"
+}
+
 // Create a new version of the cookbook.
 func (c *Cookbook)NewVersion(cb_version string, cbv_data map[string]interface{}) (*CookbookVersion, util.Gerror){
+	cb_version = normalizeVersion(cb_version)
 	if _, err := c.GetVersion(cb_version); err == nil {
 		err := util.Errorf("Version %s of cookbook %s already exists, and shouldn't be created like this. Use UpdateVersion instead.", cb_version, c.Name)
 		err.SetStatus(http.StatusConflict)
 		return nil, err
 	}
+	/* Versions that only differ by build metadata or trailing zeros, like
+	 * 2.1.0 and 2.1.0.0, compare as equal, so there'd be no telling which
+	 * of them was meant when resolving dependencies. */
+	if same := c.sameVersion(cb_version); same != nil {
+		err := util.Errorf("Version %s of cookbook %s is the same version as the existing %s, and can't be uploaded as well.", cb_version, c.Name, same.Version)
+		err.SetStatus(http.StatusConflict)
+		return nil, err
+	}
 	cbv := &CookbookVersion{
 		CookbookName: c.Name,
 		Version: cb_version,
@@ -580,6 +915,7 @@ func (c *Cookbook)NewVersion(cb_version string, cbv_data map[string]interface{})
 		ChefType: "cookbook_version",
 		JsonClass: "Chef::CookbookVersion",
 		IsFrozen: false,
+		CreatedAt: time.Now().UTC(),
 		cookbook_id: c.id, // should be ok even with in-mem
 	}
 	err := cbv.UpdateVersion(cbv_data, "")
@@ -587,45 +923,77 @@ func (c *Cookbook)NewVersion(cb_version string, cbv_data map[string]interface{})
 		return nil, err
 	}
 	/* And, dur, add it to the versions */
+	c.mu.Lock()
 	c.Versions[cb_version] = cbv
-	
 	c.numVersions = nil
-	c.UpdateLatestVersion()
+	c.latest = nil
+	c.latestVersionLocked()
+	c.mu.Unlock()
+	versionCount.Add(1)
+
 	c.Save()
 	return cbv, nil
 }
 
 
 
+/* Find a version of the cookbook that's written differently from the given
+ * version, but compares the same as it. */
+func (c *Cookbook) sameVersion(cb_version string) *CookbookVersion {
+	for _, cbv := range c.sortedVersions() {
+		if cbv.Version != cb_version && compareVersions(cbv.Version, cb_version) == 0 {
+			return cbv
+		}
+	}
+	return nil
+}
+
 // Get a particular version of the cookbook.
 func (c *Cookbook)GetVersion(cbVersion string) (*CookbookVersion, util.Gerror) {
 	if cbVersion == "_latest" {
-		return c.LatestVersion(), nil
+		cbv := c.LatestVersion()
+		if cbv == nil {
+			err := util.Errorf("Cookbook %s has no versions", c.Name)
+			err.SetStatus(http.StatusNotFound)
+			return nil, err
+		}
+		return cbv, nil
+	}
+	c.mu.RLock()
+	v, aliased := c.Aliases[cbVersion]
+	c.mu.RUnlock()
+	if aliased {
+		cbVersion = v
+	} else if ValidAliasName(cbVersion) {
+		err := util.Errorf("Cookbook %s has no version aliased as %s", c.Name, cbVersion)
+		err.SetStatus(http.StatusNotFound)
+		return nil, err
 	}
+	cbVersion = normalizeVersion(cbVersion)
 	var cbv *CookbookVersion
 	var found bool
 
-	if config.Config.UseMySQL {
-		// Ridiculously cacheable, but let's get it working first. This
-		// applies all over the place w/ the SQL bits.
-		if cbv, found = c.Versions[cbVersion]; !found {
-			var err error
-			cbv, err = c.getCookbookVersionMySQL(cbVersion)
-			if err != nil {
-				if err == sql.ErrNoRows {
-					found = false
-				} else {
-					gerr := util.Errorf(err.Error())
-					gerr.SetStatus(http.StatusInternalServerError)
-					return nil, gerr
-				}
-			} else {
-				found = true
-				c.Versions[cbVersion] = cbv
+	c.mu.RLock()
+	cbv, found = c.Versions[cbVersion]
+	c.mu.RUnlock()
+
+	// Ridiculously cacheable, but let's get it working first. This
+	// applies all over the place w/ the SQL bits.
+	if !found && config.Config.UseSQL {
+		var err error
+		cbv, err = c.getCookbookVersionSQL(cbVersion)
+		if err != nil {
+			if err != sql.ErrNoRows {
+				gerr := util.Errorf(err.Error())
+				gerr.SetStatus(http.StatusInternalServerError)
+				return nil, gerr
 			}
+		} else {
+			found = true
+			c.mu.Lock()
+			c.Versions[cbVersion] = cbv
+			c.mu.Unlock()
 		}
-	} else {
-		cbv, found = c.Versions[cbVersion]
 	}
 
 	if !found {
@@ -636,72 +1004,170 @@ func (c *Cookbook)GetVersion(cbVersion string) (*CookbookVersion, util.Gerror) {
 	return cbv, nil
 }
 
+var aliasName = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_-]*$`)
+
+// Checks that a name can be used as a cookbook version alias. Aliases have to
+// start with a letter, so they can't be mistaken for a version or for the
+// built-in "_latest".
+func ValidAliasName(alias string) bool {
+	return aliasName.MatchString(alias)
+}
+
+// Points an alias, like "stable", at one of the cookbook's versions, so
+// GetVersion can find that version by the alias. Setting an alias that already
+// exists moves it to the new version. The cookbook is saved.
+func (c *Cookbook) SetAlias(alias string, cbVersion string) util.Gerror {
+	if !ValidAliasName(alias) {
+		err := util.Errorf("Invalid alias name '%s'", alias)
+		err.SetStatus(http.StatusBadRequest)
+		return err
+	}
+	if _, verr := util.ValidateAsVersion(cbVersion); verr != nil {
+		err := util.Errorf("Invalid cookbook version '%s'", cbVersion)
+		err.SetStatus(http.StatusBadRequest)
+		return err
+	}
+	cbv, err := c.GetVersion(cbVersion)
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	if c.Aliases == nil {
+		c.Aliases = make(map[string]string)
+	}
+	c.Aliases[alias] = cbv.Version
+	c.mu.Unlock()
+	if serr := c.Save(); serr != nil {
+		gerr := util.CastErr(serr)
+		gerr.SetStatus(http.StatusInternalServerError)
+		return gerr
+	}
+	return nil
+}
+
+// Removes one of the cookbook's aliases and saves the cookbook.
+func (c *Cookbook) DeleteAlias(alias string) util.Gerror {
+	c.mu.Lock()
+	_, found := c.Aliases[alias]
+	delete(c.Aliases, alias)
+	c.mu.Unlock()
+	if !found {
+		err := util.Errorf("Cookbook %s has no alias %s", c.Name, alias)
+		err.SetStatus(http.StatusNotFound)
+		return err
+	}
+	if serr := c.Save(); serr != nil {
+		gerr := util.CastErr(serr)
+		gerr.SetStatus(http.StatusInternalServerError)
+		return gerr
+	}
+	return nil
+}
+
+// A copy of the cookbook's aliases, mapping each alias to the version it
+// points at. It's safe to use while aliases are being set or removed.
+func (c *Cookbook) AllAliases() map[string]string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	aliases := make(map[string]string, len(c.Aliases))
+	for k, v := range c.Aliases {
+		aliases[k] = v
+	}
+	return aliases
+}
+
+/* If configured to, canonicalize a version string to three components, so
+ * "1.2" is stored as "1.2.0", the same way versions come back from MySQL.
+ * Leading zeros are dropped too. Strings that aren't versions are left alone
+ * for the validations to deal with. */
+func normalizeVersion(v string) string {
+	if !config.Config.NormalizeVersions {
+		return v
+	}
+	if _, err := util.ValidateAsVersion(v); err != nil {
+		return v
+	}
+	/* Pre-release and build parts are kept as they are. */
+	var suffix string
+	if i := strings.IndexAny(v, "-+"); i != -1 {
+		v, suffix = v[:i], v[i:]
+	}
+	nums := strings.Split(v, ".")
+	for len(nums) < 3 {
+		nums = append(nums, "0")
+	}
+	for i, n := range nums {
+		if vt, err := strconv.ParseInt(n, 10, 64); err == nil {
+			nums[i] = strconv.FormatInt(vt, 10)
+		}
+	}
+	return strings.Join(nums, ".") + suffix
+}
+
+/* The first three numbers of a version, as they're stored in the database.
+ * Any numbers after those come from extractVerExtra. */
 func extractVerNums(cbVersion string) (maj, min, patch int64, err util.Gerror) {
 	if _, err = util.ValidateAsVersion(cbVersion); err != nil {
 		return 0, 0, 0, err
 	}
-	nums := strings.Split(cbVersion, ".")
-	if len(nums) < 2 && len(nums) > 3 {
-		err = util.Errorf("incorrect number of numbers in version string '%s'", len(nums))
-		return 0, 0, 0, err
-	}
-	var vt int64
-	var nerr error
-	vt, nerr = strconv.ParseInt(nums[0], 0, 64)
-	if nerr != nil {
-		err = util.Errorf(nerr.Error())
-		return 0, 0, 0, err
+	if i := strings.IndexAny(cbVersion, "-+"); i != -1 {
+		cbVersion = cbVersion[:i]
 	}
-	maj = vt
-	vt, nerr = strconv.ParseInt(nums[1], 0, 64)
-	if nerr != nil {
-		err = util.Errorf(nerr.Error())
+	nums := strings.Split(cbVersion, ".")
+	if len(nums) < 2 {
+		err = util.Errorf("incorrect number of numbers in version string '%s'", cbVersion)
 		return 0, 0, 0, err
 	}
-	min = vt
-	if len(nums) == 3 {
-		vt, nerr = strconv.ParseInt(nums[2], 0, 64)
+	var vt [3]int64
+	for i := 0; i < 3 && i < len(nums); i++ {
+		n, nerr := strconv.ParseInt(nums[i], 0, 64)
 		if nerr != nil {
 			err = util.Errorf(nerr.Error())
 			return 0, 0, 0, err
 		}
-		patch = vt
-	} else {
-		patch = 0
+		vt[i] = n
 	}
-	return maj, min, patch, nil
+	return vt[0], vt[1], vt[2], nil
 }
 
-func (c *Cookbook)deleteHashes(file_hashes []string) {
-	/* And remove the unused hashes. Currently, sigh, this involes checking
-	 * every cookbook. Probably will be easier with an actual database, I
-	 * imagine. */
-	all_cookbooks := AllCookbooks()
-	for _, cb := range all_cookbooks {
-		/* just move on if we don't find it somehow */
-		for _, ver := range cb.sortedVersions() {
-			ver_hash := ver.fileHashes()
-			for _, vh := range ver_hash {
-				for i, fh := range file_hashes {
-					/* If a hash in a deleted cookbook is
-					 * in another cookbook, remove it from
-					 * the hash to delete. Then we can break
-					 * out. If we find that the hash we're
-					 * comparing with is greater than this
-					 * one in file_hashes, also break out.
-					 */
-					if fh == vh {
-						file_hashes = delSliceElement(i, file_hashes)
-						break
-					} else if fh > vh {
-						break
-					}
-				}
-			}
+/* The parts of a version the database doesn't have a number column for: any
+ * numbers after the third, like the "3" in 2.1.0.3, and the SemVer
+ * pre-release and build parts without the leading "-" and "+". */
+func extractVerExtra(cbVersion string) (extra, prerelease, build string) {
+	vp := splitVersion(cbVersion)
+	if len(vp.nums) > 3 {
+		extra_nums := make([]string, len(vp.nums) - 3)
+		for i, n := range vp.nums[3:] {
+			extra_nums[i] = strconv.FormatInt(n, 10)
 		}
+		extra = strings.Join(extra_nums, ".")
+	}
+	return extra, strings.Join(vp.prerelease, "."), vp.build
+}
+
+/* Put a version string back together from its parts as they come out of the
+ * database. */
+func joinVersion(maj, min, patch int64, extra, prerelease, build string) string {
+	v := fmt.Sprintf("%d.%d.%d", maj, min, patch)
+	if extra != "" {
+		v = v + "." + extra
+	}
+	if prerelease != "" {
+		v = v + "-" + prerelease
+	}
+	if build != "" {
+		v = v + "+" + build
+	}
+	return v
+}
+
+func (c *Cookbook)deleteHashes(file_hashes []string) {
+	/* And remove the hashes no cookbook version uses anymore. */
+	if config.Config.UseSQL {
+		filestore.DeleteHashes(unreferencedSQL(file_hashes))
+	} else {
+		filestore.DeleteHashes(hashRefs.unreferenced(file_hashes))
 	}
-	/* And delete whatever file hashes we still have */
-	filestore.DeleteHashes(file_hashes)
 }
 
 // Delete a particular version of a cookbook.
@@ -716,15 +1182,30 @@ func (c *Cookbook)DeleteVersion(cb_version string) util.Gerror {
 
 	file_hashes := cbv.fileHashes()
 
-	if config.Config.UseMySQL {
-		err := cbv.deleteCookbookVersionMySQL()
+	if config.Config.UseSQL {
+		err := cbv.deleteCookbookVersionSQL()
 		if err != nil {
 			return nil
 		}
 	}
+	/* cb_version may have been an alias, so the version's own version
+	 * string is used. Aliases aren't left pointing at a version that's
+	 * gone. */
+	c.mu.Lock()
 	c.numVersions = nil
+	delete(c.Versions, cbv.Version)
+	for alias, v := range c.Aliases {
+		if v == cbv.Version {
+			delete(c.Aliases, alias)
+		}
+	}
+	c.latest = nil
+	c.mu.Unlock()
+	versionCount.Add(-1)
 
-	delete(c.Versions, cb_version)
+	/* The lock has to be let go first, since hashRefs may need to look
+	 * through this cookbook's versions to build itself. */
+	hashRefs.remove(cbv)
 	c.deleteHashes(file_hashes)
 	
 	c.Save()
@@ -739,6 +1220,12 @@ func (cbv *CookbookVersion)UpdateVersion(cbv_data map[string]interface{}, force
 		err.SetStatus(http.StatusConflict)
 		return err
 	}
+	/* ...unless the server's been told not to allow that. */
+	if cbv.IsFrozen && config.Config.DisableForceUpload {
+		err := util.Errorf("The cookbook %s at version %s is frozen, and this server doesn't allow frozen cookbooks to be forced. It must be unfrozen first.", cbv.CookbookName, cbv.Version)
+		err.SetStatus(http.StatusConflict)
+		return err
+	}
 
 	file_hashes := cbv.fileHashes()
 	
@@ -761,6 +1248,13 @@ func (cbv *CookbookVersion)UpdateVersion(cbv_data map[string]interface{}, force
 				continue ValidElem
 			}
 		}
+		/* Newer clients may send fields we don't know about yet. If
+		 * configured to, log and drop them rather than bailing. */
+		if config.Config.IgnoreUnknownFields {
+			logger.Debugf("Ignoring unknown key %s in request body for cookbook %s version %s", k, cbv.CookbookName, cbv.Version)
+			delete(cbv_data, k)
+			continue
+		}
 		err := util.Errorf("Invalid key %s in request body", k)
 		return err
 	}
@@ -807,6 +1301,18 @@ func (cbv *CookbookVersion)UpdateVersion(cbv_data map[string]interface{}, force
 			cbv_data["version"] = cbv.Version
 		}
 	}
+	if config.Config.NormalizeVersions {
+		/* The name has the version in it too. */
+		if n, ok := cbv_data["name"].(string); ok && strings.HasPrefix(n, cbv.CookbookName + "-") {
+			cbv_data["name"] = cbv.CookbookName + "-" + normalizeVersion(strings.TrimPrefix(n, cbv.CookbookName + "-"))
+		}
+		cbv_data["version"] = normalizeVersion(cbv_data["version"].(string))
+		if md, ok := cbv_data["metadata"].(map[string]interface{}); ok {
+			if mv, ok := md["version"].(string); ok {
+				md["version"] = normalizeVersion(mv)
+			}
+		}
+	}
 
 	divs := []string{ "definitions", "libraries", "attributes", "recipes", "providers", "resources", "templates", "root_files", "files" }
 	for _, d := range divs {
@@ -826,6 +1332,22 @@ func (cbv *CookbookVersion)UpdateVersion(cbv_data map[string]interface{}, force
 		return verr
 	}
 
+	/* If configured, look for recipes including recipes from cookbooks
+	 * this cookbook doesn't depend on. */
+	cbv.undeclaredDeps = nil
+	if config.Config.RecipeDepCheck != "" {
+		undeclared := undeclaredRecipeDeps(cbv.CookbookName, cbv_data["recipes"].([]map[string]interface{}), cbv_data["metadata"].(map[string]interface{}))
+		if len(undeclared) > 0 {
+			if config.Config.RecipeDepCheck == "strict" {
+				err := util.Errorf("Recipes in cookbook %s version %s include recipes from cookbooks not declared as dependencies: %s", cbv.CookbookName, cbv.Version, strings.Join(undeclared, ", "))
+				err.SetStatus(http.StatusBadRequest)
+				return err
+			}
+			logger.Warningf("Recipes in cookbook %s version %s include recipes from cookbooks not declared as dependencies: %s", cbv.CookbookName, cbv.Version, strings.Join(undeclared, ", "))
+			cbv.undeclaredDeps = undeclared
+		}
+	}
+
 	/* Basic sanity checking */
 	if cbv_data["cookbook_name"].(string) != cbv.CookbookName {
 		err := util.Errorf("Field 'cookbook_name' invalid")
@@ -859,12 +1381,16 @@ func (cbv *CookbookVersion)UpdateVersion(cbv_data map[string]interface{}, force
 	cbv.Metadata = cbv_data["metadata"].(map[string]interface{})
 
 	/* If we're using SQL, update this version in the DB. */
-	if config.Config.UseMySQL {
-		if err := cbv.updateCookbookVersionMySQL(); err != nil {
+	if config.Config.UseSQL {
+		if err := cbv.updateCookbookVersionSQL(); err != nil {
 			return err
 		}
 	}
 
+	/* Note which files this version uses now before cleaning up the ones
+	 * it used to. */
+	hashRefs.set(cbv)
+
 	/* Clean cookbook hashes */
 	if len(file_hashes) > 0 {
 		// Get our parent. Bravely assuming that if it exists we exist.
@@ -875,6 +1401,29 @@ func (cbv *CookbookVersion)UpdateVersion(cbv_data map[string]interface{}, force
 	return nil
 }
 
+// Freeze or unfreeze a cookbook version, without having to upload the whole
+// thing again with UpdateVersion.
+func (cbv *CookbookVersion) SetFrozen(frozen bool) util.Gerror {
+	if config.Config.UseSQL {
+		if err := cbv.setFrozenSQL(frozen); err != nil {
+			return err
+		}
+		cbv.IsFrozen = frozen
+		return nil
+	}
+	cb, err := Get(cbv.CookbookName)
+	if err != nil {
+		return err
+	}
+	cbv.IsFrozen = frozen
+	if serr := cb.Save(); serr != nil {
+		gerr := util.CastErr(serr)
+		gerr.SetStatus(http.StatusInternalServerError)
+		return gerr
+	}
+	return nil
+}
+
 func convertToCookbookDiv(div interface{}) []map[string]interface{} {
 	switch div := div.(type) {
 		case []map[string]interface{}:
@@ -920,7 +1469,7 @@ func (cbv *CookbookVersion)ToJson(method string) map[string]interface{} {
 	toJson["chef_type"] = cbv.ChefType
 	toJson["json_class"] = cbv.JsonClass
 	toJson["frozen?"] = cbv.IsFrozen
-	toJson["recipes"] = cbv.Recipes
+	toJson["recipes"] = fileURLs(cbv.Recipes)
 	toJson["metadata"] = cbv.Metadata
 
 	/* Only send the other fields if something exists in them */
@@ -955,19 +1504,67 @@ func (cbv *CookbookVersion)ToJson(method string) map[string]interface{} {
 }
 
 func methodize(method string, cb_thing []map[string]interface{}) []map[string]interface{} {
+	if method == "PUT" {
+		ret_hash := make([]map[string]interface{}, len(cb_thing))
+		for i, v := range cb_thing {
+			ret_hash[i] = make(map[string]interface{})
+			for k, j := range v {
+				if k == "url" {
+					continue
+				}
+				ret_hash[i][k] = j
+			}
+		}
+		return ret_hash
+	}
+	return fileURLs(cb_thing)
+}
+
+/* The file URLs saved with a cookbook version are made when it's uploaded, so
+ * they go stale if the server's hostname or --https-urls changes afterwards.
+ * Build them fresh from the checksums instead. */
+func fileURLs(cb_thing []map[string]interface{}) []map[string]interface{} {
+	if cb_thing == nil {
+		return nil
+	}
 	ret_hash := make([]map[string]interface{}, len(cb_thing))
 	for i, v := range cb_thing {
-		ret_hash[i] = make(map[string]interface{})
+		ret_hash[i] = make(map[string]interface{}, len(v))
 		for k, j := range v {
-			if method == "PUT" && k == "url" {
-				continue
-			}
 			ret_hash[i][k] = j
 		}
+		if chksum, ok := v["checksum"].(string); ok && chksum != "" {
+			ret_hash[i]["url"] = util.CustomURL(fmt.Sprintf("/file_store/%s", chksum))
+		}
 	}
 	return ret_hash
 }
 
+// Point the file download URLs in the JSON from a cookbook version's ToJson at
+// a different host, like a regional mirror, keeping the rest of each URL the
+// same. Does nothing if host is empty.
+func MirrorFileURLs(cbv_json map[string]interface{}, host string) {
+	if host == "" {
+		return
+	}
+	divs := []string{ "definitions", "libraries", "attributes", "providers", "resources", "templates", "root_files", "files", "recipes" }
+	for _, d := range divs {
+		files, _ := cbv_json[d].([]map[string]interface{})
+		for _, f := range files {
+			u, ok := f["url"].(string)
+			if !ok {
+				continue
+			}
+			file_url, err := url.Parse(u)
+			if err != nil {
+				continue
+			}
+			file_url.Host = host
+			f["url"] = file_url.String()
+		}
+	}
+}
+
 func getAttrHashes(attr []map[string]interface{}) []string {
 	hashes := make([]string, len(attr))
 	for i, v := range attr {
@@ -1032,6 +1629,72 @@ func (cbv *CookbookVersion) RecipeList() ([]string, util.Gerror) {
 	return recipes, nil
 }
 
+/* Look through the given recipes' contents for include_recipe calls on
+ * cookbooks that aren't in the metadata's dependencies. Since goiardi can't
+ * actually parse ruby this is just a heuristic, and recipes whose contents
+ * aren't in the filestore are skipped. Returns a sorted list of the undeclared
+ * cookbooks. */
+func undeclaredRecipeDeps(cookbook_name string, recipes []map[string]interface{}, metadata map[string]interface{}) []string {
+	deps, _ := metadata["dependencies"].(map[string]interface{})
+	incRe := regexp.MustCompile(`include_recipe\s*\(?\s*["']([^"']+)["']`)
+	found := make(map[string]bool)
+
+	for _, r := range recipes {
+		chksum, ok := r["checksum"].(string)
+		if !ok {
+			continue
+		}
+		fs, err := filestore.Get(chksum)
+		if err != nil || fs.Data == nil {
+			continue
+		}
+		for _, m := range incRe.FindAllStringSubmatch(string(*fs.Data), -1) {
+			/* Can't do anything useful with interpolated names */
+			if strings.Contains(m[1], "#{") {
+				continue
+			}
+			inc_cb := strings.SplitN(m[1], "::", 2)[0]
+			if inc_cb == cookbook_name {
+				continue
+			}
+			if _, ok := deps[inc_cb]; !ok {
+				found[inc_cb] = true
+			}
+		}
+	}
+
+	undeclared := make([]string, 0, len(found))
+	for k := range found {
+		undeclared = append(undeclared, k)
+	}
+	sort.Strings(undeclared)
+	return undeclared
+}
+
+// Returns the cookbooks this version's recipes include recipes from without
+// declaring them as dependencies, as found by the recipe-dep-check option when
+// the version was last uploaded.
+func (cbv *CookbookVersion) UndeclaredDeps() []string {
+	return cbv.undeclaredDeps
+}
+
+// Returns the provenance fields from this cookbook version's metadata, like
+// source_url and issues_url. Fields the cookbook didn't provide are returned as
+// empty strings.
+func (cbv *CookbookVersion) MetadataLinks() map[string]string {
+	linkFields := []string{ "source_url", "issues_url", "maintainer", "license" }
+	links := make(map[string]string, len(linkFields))
+	for _, f := range linkFields {
+		switch l := cbv.Metadata[f].(type) {
+			case string:
+				links[f] = l
+			default:
+				links[f] = ""
+		}
+	}
+	return links
+}
+
 /* Version string functions to implement sorting */
 
 func (v VersionStrings) Len() int {
@@ -1047,40 +1710,138 @@ func (v VersionStrings) Less(i, j int) bool {
 }
 
 func versionLess(ver_a, ver_b string) bool {
-	/* Chef cookbook versions are always to be in the form x.y.z (with x.y
-	 * also allowed. This simplifies things a bit. */
+	return compareVersions(ver_a, ver_b) < 0
+}
+
+/* A version string broken up into its numbers and any SemVer pre-release and
+ * build parts, like 1.2.3-rc.1+build5. */
+type versionParts struct {
+	nums []int64
+	prerelease []string
+	build string
+}
+
+/* Break up a version string. This is forgiving, since it's used for comparing
+ * as well as storing versions: anything that isn't a number where one should
+ * be counts as 0. */
+func splitVersion(v string) *versionParts {
+	vp := new(versionParts)
+	if i := strings.Index(v, "+"); i != -1 {
+		vp.build = v[i+1:]
+		v = v[:i]
+	}
+	if i := strings.Index(v, "-"); i != -1 {
+		vp.prerelease = strings.Split(v[i+1:], ".")
+		v = v[:i]
+	}
+	for _, n := range strings.Split(v, ".") {
+		vt, _ := strconv.ParseInt(n, 10, 64)
+		vp.nums = append(vp.nums, vt)
+	}
+	return vp
+}
 
-	/* Easy comparison. False if they're equal. */
+/* Returns -1, 0, or 1 if ver_a is less than, equal to, or greater than ver_b.
+ * Chef cookbook versions are usually in the form x.y.z (with x.y, or more
+ * numbers like 2.1.0.3, also allowed), but SemVer pre-release versions are
+ * allowed too. A pre-release
+ * sorts below the release it comes before, and build metadata is ignored, as
+ * SemVer says. */
+func compareVersions(ver_a, ver_b string) int {
+	/* Easy comparison. */
 	if ver_a == ver_b {
-		return false
+		return 0
 	}
+	i_ver := splitVersion(ver_a)
+	j_ver := splitVersion(ver_b)
 
-	/* Would caching the split strings ever be particularly worth it? */
-	i_ver := strings.Split(ver_a, ".")
-	j_ver := strings.Split(ver_b, ".")
+	if c := compareVersionNums(i_ver.nums, j_ver.nums); c != 0 {
+		return c
+	}
 
-	for q := 0; q < 3; q++ {
-		/* If one of them doesn't actually exist, then obviously the
-		 * other is bigger, and we're done. Of course this should only
-		 * happen with the 3rd element. */
-		if len(i_ver) < q + 1 {
-			return true
-		} else if len(j_ver) < q + 1 {
-			return false
-		}
+	/* A release beats any pre-release of the same version. */
+	switch {
+		case len(i_ver.prerelease) == 0 && len(j_ver.prerelease) == 0:
+			return 0
+		case len(i_ver.prerelease) == 0:
+			return 1
+		case len(j_ver.prerelease) == 0:
+			return -1
+	}
+	return comparePrerelease(i_ver.prerelease, j_ver.prerelease)
+}
 
-		ic := i_ver[q]
-		jc := j_ver[q]
+/* Compare version numbers position by position, however many there are. A
+ * number one version has and the other doesn't counts as 0 for the other, so
+ * 2.1.0 and 2.1.0.0 are the same, and 2.1.0.3 is bigger than both. */
+func compareVersionNums(nums_a, nums_b []int64) int {
+	for q := 0; q < len(nums_a) || q < len(nums_b); q++ {
+		var a, b int64
+		if q < len(nums_a) {
+			a = nums_a[q]
+		}
+		if q < len(nums_b) {
+			b = nums_b[q]
+		}
+		if a < b {
+			return -1
+		} else if a > b {
+			return 1
+		}
+	}
+	return 0
+}
 
-		/* Otherwise, see if they're equal. If they're not, return the
-		 * result of x < y. */
-		ici, _ := strconv.Atoi(ic)
-		jci, _ := strconv.Atoi(jc)
-		if ici != jci {
-			return ici < jci
+/* Compare pre-release identifiers the SemVer way: numeric identifiers are
+ * compared as numbers and sort below alphanumeric ones, which are compared as
+ * strings. If all the identifiers they share are equal, the one with more
+ * identifiers is bigger. */
+func comparePrerelease(pre_a, pre_b []string) int {
+	for q := 0; q < len(pre_a) && q < len(pre_b); q++ {
+		if pre_a[q] == pre_b[q] {
+			continue
+		}
+		an, aerr := strconv.ParseInt(pre_a[q], 10, 64)
+		bn, berr := strconv.ParseInt(pre_b[q], 10, 64)
+		switch {
+			case aerr == nil && berr == nil:
+				if an < bn {
+					return -1
+				} else if an > bn {
+					return 1
+				}
+			case aerr == nil:
+				return -1
+			case berr == nil:
+				return 1
+			case pre_a[q] < pre_b[q]:
+				return -1
+			default:
+				return 1
 		}
 	}
-	return false
+	switch {
+		case len(pre_a) < len(pre_b):
+			return -1
+		case len(pre_a) > len(pre_b):
+			return 1
+	}
+	return 0
+}
+
+/* Pre-release versions only satisfy a constraint on a pre-release of the same
+ * version, so ">= 1.0.0" doesn't pull in 2.0.0-beta, the way SemVer ranges
+ * usually work. */
+func prereleaseAllowed(ver_a, ver_b string) bool {
+	a := splitVersion(ver_a)
+	if len(a.prerelease) == 0 {
+		return true
+	}
+	b := splitVersion(ver_b)
+	if len(b.prerelease) == 0 {
+		return false
+	}
+	return compareVersionNums(a.nums, b.nums) == 0
 }
 
 /* Compares a version number against a constraint, like version 1.2.3 vs. 
@@ -1088,11 +1849,20 @@ func versionLess(ver_a, ver_b string) bool {
  * "< 1.0", though. */
 
 func verConstraintCheck(ver_a, ver_b, op string) string {
+	switch op {
+		case "=", "!=", ">", "<", ">=", "<=", "~>":
+			if !prereleaseAllowed(ver_a, ver_b) {
+				return "skip"
+			}
+		default:
+			return "invalid"
+	}
+	cmp := compareVersions(ver_a, ver_b)
 	switch op {
 		case "=":
-			if ver_a == ver_b {
+			if cmp == 0 {
 				return "ok"
-			} else if versionLess(ver_a, ver_b) {
+			} else if cmp < 0 {
 				/* If we want equality and ver_a is less than
 				 * version b, since the version list is sorted
 				 * in descending order we've missed our chance.
@@ -1101,8 +1871,15 @@ func verConstraintCheck(ver_a, ver_b, op string) string {
 			} else {
 				return "skip"
 			}
+		case "!=":
+			/* Exclude just the one version. */
+			if cmp == 0 {
+				return "skip"
+			} else {
+				return "ok"
+			}
 		case ">":
-			if ver_a == ver_b || versionLess(ver_a, ver_b) {
+			if cmp <= 0 {
 				return "break"
 			} else {
 				return "ok"
@@ -1110,48 +1887,47 @@ func verConstraintCheck(ver_a, ver_b, op string) string {
 		case "<":
 			/* return skip here because we might find what we want
 			 * later. */
-			if ver_a == ver_b || !versionLess(ver_a, ver_b){
+			if cmp >= 0 {
 				return "skip"
 			} else {
 				return "ok"
 			}
 		case ">=":
-			if !versionLess(ver_a, ver_b) {
+			if cmp >= 0 {
 				return "ok"
 			} else {
 				return "break"
 			}
 		case "<=":
-			if ver_a == ver_b || versionLess(ver_a, ver_b) {
+			if cmp <= 0 {
 				return "ok"
 			} else {
 				return "skip"
 			}
-		case "~>":
+		default: /* "~>" */
 			/* only check pessimistic constraints if they can
 			 * possibly be valid. */
-			if versionLess(ver_a, ver_b) {
+			if cmp < 0 {
 				return "break"
 			}
-			var upper_bound string
-			pv := strings.Split(ver_b, ".")
-			if len(pv) == 3 {
-				uver, _ := strconv.Atoi(pv[1])
-				uver++
-				upper_bound = fmt.Sprintf("%s.%d", pv[0], uver)
-			} else {
-				uver, _ := strconv.Atoi(pv[0])
-				uver++
-				upper_bound = fmt.Sprintf("%d.0", uver)
+			/* The last number given can go up, but the
+			 * one before it can't, so "~> 2.1.0.3" allows
+			 * up to 2.1.1 and "~> 1.2" up to 2.0. */
+			pv := splitVersion(ver_b).nums
+			if len(pv) < 2 {
+				return "invalid"
 			}
-			if !versionLess(ver_a, ver_b) && versionLess(ver_a, upper_bound) {
-
+			upper := make([]string, len(pv) - 1)
+			for i, n := range pv[:len(pv) - 1] {
+				upper[i] = strconv.FormatInt(n, 10)
+			}
+			upper[len(upper) - 1] = strconv.FormatInt(pv[len(pv) - 2] + 1, 10)
+			upper_bound := strings.Join(upper, ".")
+			if versionLess(ver_a, upper_bound) {
 				return "ok"
 			} else {
 				return "skip"
 			}
-		default:
-			return "invalid"
 	}
 }
 
@@ -0,0 +1,1213 @@
+/*
+ * Copyright (c) 2013-2014, Jeremy Bingham (<jbingham@gmail.com>)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cookbook
+
+import (
+	"testing"
+	"fmt"
+	"strings"
+	"net/http"
+	"crypto/md5"
+	"encoding/base64"
+	"io/ioutil"
+	"encoding/json"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+	"os"
+	"path"
+	"encoding/gob"
+	"github.com/ctdk/goiardi/config"
+	"github.com/ctdk/goiardi/data_store"
+	"github.com/ctdk/goiardi/filestore"
+	"github.com/ctdk/goiardi/util"
+)
+
+/* Make a cookbook with a single version that depends on the given cookbooks,
+ * and stick it in the data store. */
+func makeDepCookbook(name string, version string, deps map[string]interface{}) *Cookbook {
+	cb := &Cookbook{ Name: name, Versions: make(map[string]*CookbookVersion) }
+	cbv := &CookbookVersion{
+		CookbookName: name,
+		Version: version,
+		Name: fmt.Sprintf("%s-%s", name, version),
+		ChefType: "cookbook_version",
+		JsonClass: "Chef::CookbookVersion",
+		Metadata: map[string]interface{}{ "dependencies": deps },
+	}
+	cb.Versions[version] = cbv
+	cb.Save()
+	return cb
+}
+
+func TestCircularDependencies(t *testing.T){
+	makeDepCookbook("circ_a", "1.0.0", map[string]interface{}{ "circ_b": ">= 0.0.0" })
+	makeDepCookbook("circ_b", "1.0.0", map[string]interface{}{ "circ_c": ">= 0.0.0" })
+	makeDepCookbook("circ_c", "1.0.0", map[string]interface{}{ "circ_a": ">= 0.0.0" })
+
+	deps, err := DependsCookbooks([]string{ "circ_a" }, map[string]string{})
+	if err != nil {
+		t.Fatalf("DependsCookbooks should have handled a circular dependency, but failed: %s", err.Error())
+	}
+	if len(deps) != 3 {
+		t.Errorf("Expected 3 cookbooks back from DependsCookbooks, got %d", len(deps))
+	}
+
+	/* A -> B -> A */
+	makeDepCookbook("loop_a", "1.0.0", map[string]interface{}{ "loop_b": ">= 0.0.0" })
+	makeDepCookbook("loop_b", "1.0.0", map[string]interface{}{ "loop_a": ">= 1.0.0" })
+	deps, err = DependsCookbooks([]string{ "loop_b" }, map[string]string{})
+	if err != nil {
+		t.Fatalf("DependsCookbooks failed on an A -> B -> A cycle: %s", err.Error())
+	}
+	if len(deps) != 2 {
+		t.Errorf("Expected 2 cookbooks back from DependsCookbooks, got %d", len(deps))
+	}
+
+	/* A cycle that wants a different version than the one already picked
+	 * is still a conflict. */
+	ca := makeDepCookbook("conflict_a", "1.0.0", map[string]interface{}{ "conflict_b": ">= 0.0.0" })
+	ca.Versions["2.0.0"] = &CookbookVersion{ CookbookName: "conflict_a", Version: "2.0.0", Name: "conflict_a-2.0.0", Metadata: map[string]interface{}{ "dependencies": map[string]interface{}{ "conflict_b": ">= 0.0.0" } } }
+	ca.Save()
+	makeDepCookbook("conflict_b", "1.0.0", map[string]interface{}{ "conflict_a": ">= 2.0.0" })
+	_, err = DependsCookbooks([]string{ "conflict_a@1.0.0" }, map[string]string{})
+	if err == nil {
+		t.Fatalf("DependsCookbooks should have failed with a version conflict in a cycle, but didn't")
+	}
+	if !strings.Contains(err.Error(), "conflicts with the previous constraint") {
+		t.Errorf("Expected a constraint conflict error, got '%s'", err.Error())
+	}
+	if err.Status() != http.StatusPreconditionFailed {
+		t.Errorf("Expected status %d for a version conflict, got %d", http.StatusPreconditionFailed, err.Status())
+	}
+}
+
+func TestNonCircularDependencies(t *testing.T){
+	/* A diamond dependency shouldn't be mistaken for a cycle. */
+	makeDepCookbook("diamond_a", "1.0.0", map[string]interface{}{ "diamond_b": ">= 0.0.0", "diamond_c": ">= 0.0.0" })
+	makeDepCookbook("diamond_b", "1.0.0", map[string]interface{}{ "diamond_d": ">= 0.0.0" })
+	makeDepCookbook("diamond_c", "1.0.0", map[string]interface{}{ "diamond_d": ">= 0.0.0" })
+	makeDepCookbook("diamond_d", "1.0.0", map[string]interface{}{})
+
+	deps, err := DependsCookbooks([]string{ "diamond_a" }, map[string]string{})
+	if err != nil {
+		t.Fatalf("DependsCookbooks failed on a diamond dependency: %s", err.Error())
+	}
+	if len(deps) != 4 {
+		t.Errorf("Expected 4 cookbooks back from DependsCookbooks, got %d", len(deps))
+	}
+}
+
+func TestUndeclaredRecipeDeps(t *testing.T){
+	recipe := []byte(`include_recipe "apt"
+include_recipe 'build-essential::default'
+include_recipe("mysql::server")
+include_recipe "lintme::other"
+include_recipe "#{node['platform']}::stuff"
+`)
+	chksum := fmt.Sprintf("%x", md5.Sum(recipe))
+	fs, err := filestore.New(chksum, ioutil.NopCloser(strings.NewReader(string(recipe))), int64(len(recipe)))
+	if err != nil {
+		t.Fatalf("Creating filestore item failed: %s", err.Error())
+	}
+	fs.Save()
+
+	recipes := []map[string]interface{}{ { "name": "default.rb", "checksum": chksum } }
+	metadata := map[string]interface{}{ "dependencies": map[string]interface{}{ "apt": ">= 0.0.0" } }
+	undeclared := undeclaredRecipeDeps("lintme", recipes, metadata)
+	if strings.Join(undeclared, ",") != "build-essential,mysql" {
+		t.Errorf("Expected undeclared dependencies build-essential and mysql, got %v", undeclared)
+	}
+}
+
+func TestExportImport(t *testing.T){
+	recipe := []byte(`log "exported"`)
+	chksum := fmt.Sprintf("%x", md5.Sum(recipe))
+	fs, _ := filestore.New(chksum, ioutil.NopCloser(strings.NewReader(string(recipe))), int64(len(recipe)))
+	fs.Save()
+
+	cb, _ := New("exportme")
+	cb.Save()
+	cbv_data := map[string]interface{}{
+		"cookbook_name": "exportme",
+		"name": "exportme-1.0.0",
+		"version": "1.0.0",
+		"json_class": "Chef::CookbookVersion",
+		"chef_type": "cookbook_version",
+		"frozen?": true,
+		"metadata": map[string]interface{}{ "version": "1.0.0", "name": "exportme" },
+		"recipes": []interface{}{ map[string]interface{}{ "name": "default.rb", "path": "recipes/default.rb", "checksum": chksum, "specificity": "default" } },
+	}
+	if _, err := cb.NewVersion("1.0.0", cbv_data); err != nil {
+		t.Fatalf("Creating cookbook version failed: %s", err.Error())
+	}
+
+	bundle, err := cb.Export()
+	if err != nil {
+		t.Fatalf("Exporting cookbook failed: %s", err.Error())
+	}
+	/* Send it through JSON, like it would be going between servers. */
+	j, _ := json.Marshal(bundle)
+	var imp map[string]interface{}
+	json.Unmarshal(j, &imp)
+
+	if _, _, _, err := Import(imp, false); err == nil {
+		t.Errorf("Importing over an existing version without force should have failed, but didn't")
+	}
+	cb.Delete()
+	icb, created, _, err := Import(imp, false)
+	if err != nil {
+		t.Fatalf("Importing cookbook failed: %s", err.Error())
+	}
+	if len(created) != 1 || !created[0].IsFrozen {
+		t.Errorf("Expected one frozen cookbook version to be imported, got %d", len(created))
+	}
+	if _, verr := icb.GetVersion("1.0.0"); verr != nil {
+		t.Errorf("Imported cookbook is missing version 1.0.0")
+	}
+
+	imp["files"].(map[string]interface{})[chksum] = "Ym9ndXM="
+	if _, _, _, err := Import(imp, true); err == nil {
+		t.Errorf("Importing with a bad file checksum should have failed, but didn't")
+	}
+}
+
+func TestImportRollback(t *testing.T){
+	old_recipe := []byte(`log "before the import"`)
+	old_sum := fmt.Sprintf("%x", md5.Sum(old_recipe))
+	fs, _ := filestore.New(old_sum, ioutil.NopCloser(strings.NewReader(string(old_recipe))), int64(len(old_recipe)))
+	fs.Save()
+	new_recipe := []byte(`log "from the import"`)
+	new_sum := fmt.Sprintf("%x", md5.Sum(new_recipe))
+
+	verData := func(ver string, sum string) map[string]interface{} {
+		return map[string]interface{}{
+			"cookbook_name": "rollme",
+			"name": "rollme-" + ver,
+			"version": ver,
+			"json_class": "Chef::CookbookVersion",
+			"chef_type": "cookbook_version",
+			"frozen?": false,
+			"metadata": map[string]interface{}{ "version": ver, "name": "rollme" },
+			"recipes": []interface{}{ map[string]interface{}{ "name": "default.rb", "path": "recipes/default.rb", "checksum": sum, "specificity": "default" } },
+		}
+	}
+	cb, _ := New("rollme")
+	cb.Save()
+	if _, err := cb.NewVersion("1.0.0", verData("1.0.0", old_sum)); err != nil {
+		t.Fatalf("Creating cookbook version failed: %s", err.Error())
+	}
+
+	/* The first version replaces 1.0.0, and the second is bad, so the
+	 * whole import fails after 1.0.0 has been replaced. */
+	bad := verData("2.0.0", new_sum)
+	bad["metadata"] = "not metadata"
+	bundle := map[string]interface{}{
+		"name": "rollme",
+		"versions": []interface{}{ verData("1.0.0", new_sum), bad },
+		"files": map[string]interface{}{ new_sum: base64.StdEncoding.EncodeToString(new_recipe) },
+	}
+	if _, _, _, err := Import(bundle, true); err == nil {
+		t.Fatalf("Importing a bad cookbook version should have failed, but didn't")
+	}
+	cb, _ = Get("rollme")
+	if cb.NumVersions() != 1 {
+		t.Errorf("After a failed import, rollme had %d versions, expected 1", cb.NumVersions())
+	}
+	cbv, _ := cb.GetVersion("1.0.0")
+	if h := cbv.Recipes[0]["checksum"]; h != old_sum {
+		t.Errorf("After a failed import, version 1.0.0 used file %v, expected %s", h, old_sum)
+	}
+	if _, err := filestore.Get(old_sum); err != nil {
+		t.Errorf("After a failed import, the file version 1.0.0 uses was missing")
+	}
+	if _, err := filestore.Get(new_sum); err == nil {
+		t.Errorf("After a failed import, the file it added was still there")
+	}
+
+	bundle["name"] = "rollmetoo"
+	for _, v := range bundle["versions"].([]interface{}) {
+		vm := v.(map[string]interface{})
+		vm["cookbook_name"] = "rollmetoo"
+		vm["name"] = "rollmetoo-" + vm["version"].(string)
+	}
+	if _, _, _, err := Import(bundle, false); err == nil {
+		t.Fatalf("Importing a bad cookbook version should have failed, but didn't")
+	}
+	if _, err := Get("rollmetoo"); err == nil {
+		t.Errorf("A cookbook created by a failed import was left behind")
+	}
+}
+
+func TestFrozenInfoHash(t *testing.T){
+	cb := makeDepCookbook("frosty", "1.0.0", map[string]interface{}{})
+	cb.Versions["1.0.0"].IsFrozen = true
+	for _, v := range []string{ "1.1.0", "1.2.0" } {
+		cb.Versions[v] = &CookbookVersion{ CookbookName: "frosty", Version: v, Name: "frosty-" + v }
+	}
+	cb.Versions["1.2.0"].IsFrozen = true
+
+	frozen := cb.FrozenInfoHash("all", true)["versions"].([]interface{})
+	if len(frozen) != 2 || frozen[0].(map[string]string)["version"] != "1.2.0" || frozen[1].(map[string]string)["version"] != "1.0.0" {
+		t.Errorf("Expected frozen versions 1.2.0 and 1.0.0, got %v", frozen)
+	}
+	thawed := cb.FrozenInfoHash("all", false)["versions"].([]interface{})
+	if len(thawed) != 1 || thawed[0].(map[string]string)["version"] != "1.1.0" {
+		t.Errorf("Expected unfrozen version 1.1.0, got %v", thawed)
+	}
+	one := cb.FrozenInfoHash("1", true)["versions"].([]interface{})
+	if len(one) != 1 || one[0].(map[string]string)["version"] != "1.2.0" {
+		t.Errorf("Expected only frozen version 1.2.0 with num_versions 1, got %v", one)
+	}
+}
+
+func TestDependencyTree(t *testing.T){
+	top := makeDepCookbook("tree_a", "1.0.0", map[string]interface{}{ "tree_b": ">= 0.0.0", "tree_missing": ">= 0.0.0" })
+	makeDepCookbook("tree_b", "2.1.0", map[string]interface{}{ "tree_a": ">= 0.0.0" })
+
+	tree := top.Versions["1.0.0"].DependencyTree()
+	deps := tree["dependencies"].([]map[string]interface{})
+	if len(deps) != 2 {
+		t.Fatalf("Expected 2 dependencies for tree_a, got %d", len(deps))
+	}
+	if deps[0]["name"] != "tree_b" || deps[0]["version"] != "2.1.0" {
+		t.Errorf("Expected tree_b 2.1.0 as the first dependency, got %v", deps[0])
+	}
+	if _, ok := deps[1]["error"]; !ok {
+		t.Errorf("Expected an error for tree_missing, got %v", deps[1])
+	}
+	back := deps[0]["dependencies"].([]map[string]interface{})
+	if len(back) != 1 || back[0]["cycle"] != true {
+		t.Errorf("Expected tree_b's dependency on tree_a to be marked as a cycle, got %v", back)
+	}
+}
+
+func TestDependencyTreeShared(t *testing.T){
+	/* Two cookbooks in each layer, each depending on both cookbooks in
+	 * the next layer. Walking every path would visit 2^20 nodes; with
+	 * each version only walked once, the layers below share their
+	 * dependencies. */
+	layers := 20
+	for i := layers; i >= 0; i-- {
+		deps := map[string]interface{}{}
+		if i < layers {
+			deps[fmt.Sprintf("layer_%d_a", i + 1)] = ">= 0.0.0"
+			deps[fmt.Sprintf("layer_%d_b", i + 1)] = ">= 0.0.0"
+		}
+		makeDepCookbook(fmt.Sprintf("layer_%d_a", i), "1.0.0", deps)
+		makeDepCookbook(fmt.Sprintf("layer_%d_b", i), "1.0.0", deps)
+	}
+	top, _ := Get("layer_0_a")
+	tree := top.Versions["1.0.0"].DependencyTree()
+	deps := tree["dependencies"].([]map[string]interface{})
+	if len(deps) != 2 {
+		t.Fatalf("Expected 2 dependencies for layer_0_a, got %d", len(deps))
+	}
+	a_deps := deps[0]["dependencies"].([]map[string]interface{})
+	b_deps := deps[1]["dependencies"].([]map[string]interface{})
+	a_shared := a_deps[0]["dependencies"].([]map[string]interface{})
+	b_shared := b_deps[0]["dependencies"].([]map[string]interface{})
+	if len(a_shared) != 2 || &a_shared[0] != &b_shared[0] {
+		t.Errorf("Expected layer_2_a's dependencies to be shared between its two appearances")
+	}
+}
+
+func TestLatestVersions(t *testing.T){
+	cb := makeDepCookbook("newest", "1.0.0", map[string]interface{}{})
+	cb.Versions["1.10.0"] = &CookbookVersion{ CookbookName: "newest", Version: "1.10.0", Name: "newest-1.10.0" }
+	cb.Versions["1.9.0"] = &CookbookVersion{ CookbookName: "newest", Version: "1.9.0", Name: "newest-1.9.0" }
+	cb.Save()
+	empty := &Cookbook{ Name: "empty_cb", Versions: make(map[string]*CookbookVersion) }
+	empty.Save()
+	defer empty.Delete()
+
+	latest := LatestVersions()
+	if latest["newest"] != "1.10.0" {
+		t.Errorf("Expected the latest version of 'newest' to be 1.10.0, got '%s'", latest["newest"])
+	}
+	if _, found := latest["empty_cb"]; found {
+		t.Errorf("A cookbook with no versions should not have been in the latest versions")
+	}
+}
+
+func TestLockfile(t *testing.T){
+	makeDepCookbook("lock_a", "1.2.0", map[string]interface{}{ "lock_b": ">= 1.0.0" })
+	makeDepCookbook("lock_b", "1.1.0", map[string]interface{}{})
+
+	lf, err := Lockfile([]string{ "lock_a::server" }, map[string]string{})
+	if err != nil {
+		t.Fatalf("Lockfile failed: %s", err.Error())
+	}
+	graph := lf["graph"].(map[string]interface{})
+	a := graph["lock_a"].(map[string]interface{})
+	if a["version"] != "1.2.0" {
+		t.Errorf("Expected lock_a to be locked to 1.2.0, got %v", a["version"])
+	}
+	edge := a["dependencies"].(map[string]interface{})["lock_b"].(map[string]interface{})
+	if edge["constraint"] != ">= 1.0.0" || edge["version"] != "1.1.0" {
+		t.Errorf("Expected lock_a's dependency on lock_b to be '>= 1.0.0' locked to 1.1.0, got %v", edge)
+	}
+	expected := "DEPENDENCIES\n  lock_a\n\nGRAPH\n  lock_a (1.2.0)\n    lock_b (>= 1.0.0)\n  lock_b (1.1.0)\n"
+	if lf["lockfile"] != expected {
+		t.Errorf("Expected lockfile text:\n%s\ngot:\n%s", expected, lf["lockfile"])
+	}
+}
+
+func TestDependsCookbooksPartial(t *testing.T){
+	makeDepCookbook("partial_ok", "1.0.0", map[string]interface{}{})
+	makeDepCookbook("partial_bad", "1.0.0", map[string]interface{}{ "partial_nonexistent": ">= 0.0.0" })
+
+	deps, failures, err := DependsCookbooksPartial([]string{ "partial_ok", "partial_bad" }, map[string]string{})
+	if err != nil {
+		t.Fatalf("DependsCookbooksPartial failed: %s", err.Error())
+	}
+	if _, found := deps["partial_ok"]; !found || len(deps) != 1 {
+		t.Errorf("Expected only partial_ok to resolve, got %v", deps)
+	}
+	if len(failures) != 1 || failures[0]["run_list_item"] != "partial_bad" {
+		t.Errorf("Expected partial_bad to be the only failure, got %v", failures)
+	}
+}
+
+func TestReadme(t *testing.T){
+	readme := []byte("# readme_cb\n\nDoes things.\n")
+	chksum := fmt.Sprintf("%x", md5.Sum(readme))
+	fs, _ := filestore.New(chksum, ioutil.NopCloser(strings.NewReader(string(readme))), int64(len(readme)))
+	fs.Save()
+
+	cbv := &CookbookVersion{ CookbookName: "readme_cb", Version: "1.0.0", Metadata: map[string]interface{}{ "long_description": "From metadata" } }
+	cbv.RootFiles = []map[string]interface{}{ { "name": "metadata.rb", "checksum": "nope" }, { "name": "README.md", "checksum": chksum } }
+	text, ctype, err := cbv.Readme()
+	if err != nil {
+		t.Fatalf("Readme failed: %s", err.Error())
+	}
+	if text != string(readme) || ctype != "text/markdown" {
+		t.Errorf("Expected the README.md root file as text/markdown, got %s '%s'", ctype, text)
+	}
+
+	cbv.RootFiles = nil
+	if text, _, _ = cbv.Readme(); text != "From metadata" {
+		t.Errorf("Expected the long_description without a README file, got '%s'", text)
+	}
+	cbv.Metadata = map[string]interface{}{}
+	if _, _, err = cbv.Readme(); err == nil || err.Status() != http.StatusNotFound {
+		t.Errorf("Expected a 404 with no README at all")
+	}
+}
+
+func TestNormalizeVersions(t *testing.T){
+	config.Config.NormalizeVersions = true
+	defer func() { config.Config.NormalizeVersions = false }()
+
+	cb, _ := New("normal")
+	cb.Save()
+	cbv_data := map[string]interface{}{
+		"cookbook_name": "normal",
+		"name": "normal-1.2",
+		"version": "1.2",
+		"json_class": "Chef::CookbookVersion",
+		"chef_type": "cookbook_version",
+		"frozen?": false,
+		"metadata": map[string]interface{}{ "version": "1.2", "name": "normal" },
+	}
+	cbv, err := cb.NewVersion("1.2", cbv_data)
+	if err != nil {
+		t.Fatalf("Creating cookbook version 1.2 failed: %s", err.Error())
+	}
+	if cbv.Version != "1.2.0" || cbv.Name != "normal-1.2.0" || cbv.Metadata["version"] != "1.2.0" {
+		t.Errorf("Expected version 1.2 to be stored as 1.2.0, got %s (%s, metadata %v)", cbv.Version, cbv.Name, cbv.Metadata["version"])
+	}
+	for _, v := range []string{ "1.2", "1.2.0" } {
+		got, gerr := cb.GetVersion(v)
+		if gerr != nil || got != cbv {
+			t.Errorf("Expected version %s to find the stored 1.2.0", v)
+		}
+	}
+	if _, err := cb.NewVersion("1.2.0", cbv_data); err == nil || err.Status() != http.StatusConflict {
+		t.Errorf("Creating 1.2.0 after 1.2 should have been a conflict")
+	}
+}
+
+func TestRecipeDiff(t *testing.T){
+	from := &CookbookVersion{ Recipes: []map[string]interface{}{
+		{ "name": "default.rb", "path": "recipes/default.rb", "checksum": "aaa" },
+		{ "name": "old.rb", "path": "recipes/old.rb", "checksum": "bbb" },
+		{ "name": "same.rb", "path": "recipes/same.rb", "checksum": "ccc" },
+	} }
+	to := &CookbookVersion{ Recipes: []map[string]interface{}{
+		{ "name": "default.rb", "path": "recipes/default.rb", "checksum": "ddd" },
+		{ "name": "new.rb", "path": "recipes/new.rb", "checksum": "eee" },
+		{ "name": "same.rb", "path": "recipes/same.rb", "checksum": "ccc" },
+	} }
+	diff := from.RecipeDiff(to)
+	if len(diff["added"]) != 1 || diff["added"][0] != "recipes/new.rb" {
+		t.Errorf("Expected recipes/new.rb to be added, got %v", diff["added"])
+	}
+	if len(diff["removed"]) != 1 || diff["removed"][0] != "recipes/old.rb" {
+		t.Errorf("Expected recipes/old.rb to be removed, got %v", diff["removed"])
+	}
+	if len(diff["changed"]) != 1 || diff["changed"][0] != "recipes/default.rb" {
+		t.Errorf("Expected recipes/default.rb to be changed, got %v", diff["changed"])
+	}
+}
+
+func TestDiff(t *testing.T){
+	from := &CookbookVersion{
+		Version: "1.0.0",
+		Recipes: []map[string]interface{}{ { "name": "default.rb", "path": "recipes/default.rb", "checksum": "aaa" } },
+		Templates: []map[string]interface{}{ { "name": "conf.erb", "path": "templates/default/conf.erb", "checksum": "bbb" } },
+		Metadata: map[string]interface{}{ "version": "1.0.0", "license": "Apache 2.0", "maintainer": "me" },
+	}
+	to := &CookbookVersion{
+		Version: "1.1.0",
+		Recipes: []map[string]interface{}{
+			{ "name": "default.rb", "path": "recipes/default.rb", "checksum": "aaa" },
+			{ "name": "extra.rb", "path": "recipes/extra.rb", "checksum": "ccc" },
+		},
+		Templates: []map[string]interface{}{ { "name": "conf.erb", "path": "templates/default/conf.erb", "checksum": "ddd" } },
+		Metadata: map[string]interface{}{ "version": "1.1.0", "license": "Apache 2.0", "description": "new" },
+	}
+	diff := from.Diff(to)
+	files := diff["files"].(map[string]interface{})
+	if len(files) != 2 {
+		t.Errorf("Expected only recipes and templates to differ, got %v", files)
+	}
+	recipes, _ := files["recipes"].(map[string][]string)
+	if !reflect.DeepEqual(recipes["added"], []string{ "recipes/extra.rb" }) || len(recipes["changed"]) != 0 {
+		t.Errorf("Expected recipes/extra.rb to be added and nothing else, got %v", recipes)
+	}
+	templates, _ := files["templates"].(map[string][]string)
+	if !reflect.DeepEqual(templates["changed"], []string{ "templates/default/conf.erb" }) {
+		t.Errorf("Expected templates/default/conf.erb to be changed, got %v", templates)
+	}
+	meta := diff["metadata"].(map[string][]string)
+	if !reflect.DeepEqual(meta["added"], []string{ "description" }) || !reflect.DeepEqual(meta["removed"], []string{ "maintainer" }) || !reflect.DeepEqual(meta["changed"], []string{ "version" }) {
+		t.Errorf("Unexpected metadata differences: %v", meta)
+	}
+}
+
+func TestDependsCookbooksFileURLs(t *testing.T){
+	recipe := []byte("log 'urls'\n")
+	chksum := fmt.Sprintf("%x", md5.Sum(recipe))
+	fs, _ := filestore.New(chksum, ioutil.NopCloser(strings.NewReader(string(recipe))), int64(len(recipe)))
+	fs.Save()
+
+	cb := makeDepCookbook("url_cb", "1.0.0", map[string]interface{}{})
+	cbv := cb.Versions["1.0.0"]
+	cbv.Recipes = []map[string]interface{}{ { "name": "default.rb", "path": "recipes/default.rb", "checksum": chksum, "specificity": "default", "url": "http://stale.example.com/file_store/" + chksum } }
+	cbv.Templates = []map[string]interface{}{ { "name": "t.erb", "path": "templates/default/t.erb", "checksum": chksum, "specificity": "default" } }
+	cb.Save()
+
+	config.Config.HttpsUrls = true
+	defer func() { config.Config.HttpsUrls = false }()
+	deps, err := DependsCookbooks([]string{ "url_cb" }, map[string]string{})
+	if err != nil {
+		t.Fatalf("DependsCookbooks failed: %s", err.Error())
+	}
+	want := util.CustomURL("/file_store/" + chksum)
+	if !strings.HasPrefix(want, "https://") {
+		t.Fatalf("Expected an https URL with https-urls set, got %s", want)
+	}
+	resolved := deps["url_cb"].(map[string]interface{})
+	for _, div := range []string{ "recipes", "templates" } {
+		files := resolved[div].([]map[string]interface{})
+		if len(files) != 1 {
+			t.Fatalf("Expected one file in %s, got %d", div, len(files))
+		}
+		u, _ := files[0]["url"].(string)
+		if u != want {
+			t.Errorf("Expected %s url %s, got %s", div, want, u)
+		}
+		if _, ferr := filestore.Get(strings.TrimPrefix(u, util.CustomURL("/file_store/"))); ferr != nil {
+			t.Errorf("The %s url %s does not point at a file in the filestore", div, u)
+		}
+	}
+	if files := resolved["libraries"].([]map[string]interface{}); len(files) != 0 {
+		t.Errorf("Expected an empty libraries division, got %v", files)
+	}
+}
+
+func TestMirrorFileURLs(t *testing.T){
+	cb := makeDepCookbook("mirror_cb", "1.0.0", map[string]interface{}{})
+	cbv := cb.Versions["1.0.0"]
+	cbv.Recipes = []map[string]interface{}{ { "name": "default.rb", "path": "recipes/default.rb", "checksum": "abc123", "specificity": "default" } }
+	cbv_json := cbv.ToJson("GET")
+	MirrorFileURLs(cbv_json, "files-eu.example.com:8443")
+	u := cbv_json["recipes"].([]map[string]interface{})[0]["url"].(string)
+	want := strings.Replace(util.CustomURL("/file_store/abc123"), config.ServerHostname(), "files-eu.example.com:8443", 1)
+	if u != want {
+		t.Errorf("Expected mirrored url %s, got %s", want, u)
+	}
+	if cbv.Recipes[0]["url"] != nil {
+		t.Errorf("Mirroring the JSON changed the stored cookbook version")
+	}
+}
+
+func TestAliases(t *testing.T){
+	cb := makeDepCookbook("alias_cb", "1.2.3", map[string]interface{}{})
+	if err := cb.SetAlias("stable", "1.2.3"); err != nil {
+		t.Fatalf("Setting an alias failed: %s", err.Error())
+	}
+	cbv, err := cb.GetVersion("stable")
+	if err != nil || cbv.Version != "1.2.3" {
+		t.Errorf("Expected the stable alias to resolve to 1.2.3, got %v (%v)", cbv, err)
+	}
+	if _, err := cb.GetVersion("beta"); err == nil || err.Status() != http.StatusNotFound {
+		t.Errorf("Expected a 404 for an alias that doesn't exist")
+	}
+	if err := cb.SetAlias("broken", "9.9.9"); err == nil || err.Status() != http.StatusNotFound {
+		t.Errorf("Expected a 404 aliasing a version that doesn't exist")
+	}
+	if err := cb.SetAlias("_latest", "1.2.3"); err == nil {
+		t.Errorf("_latest should not be usable as an alias")
+	}
+	if err := cb.DeleteAlias("stable"); err != nil {
+		t.Errorf("Deleting the alias failed: %s", err.Error())
+	}
+	if _, err := cb.GetVersion("stable"); err == nil {
+		t.Errorf("The stable alias should have been deleted")
+	}
+}
+
+func TestDeleteAliasedVersion(t *testing.T){
+	cb := makeDepCookbook("alias_del", "1.0.0", map[string]interface{}{})
+	cb.Versions["2.0.0"] = &CookbookVersion{ CookbookName: "alias_del", Version: "2.0.0", Name: "alias_del-2.0.0", ChefType: "cookbook_version", JsonClass: "Chef::CookbookVersion", Metadata: map[string]interface{}{} }
+	cb.SetAlias("stable", "1.0.0")
+	cb.SetAlias("old", "1.0.0")
+	cb.SetAlias("beta", "2.0.0")
+
+	if err := cb.DeleteVersion("1.0.0"); err != nil {
+		t.Fatalf("Deleting version 1.0.0 failed: %s", err.Error())
+	}
+	aliases := cb.AllAliases()
+	if !reflect.DeepEqual(aliases, map[string]string{ "beta": "2.0.0" }) {
+		t.Errorf("Aliases for the deleted version should have been dropped, but the aliases are %v", aliases)
+	}
+	if _, err := cb.GetVersion("stable"); err == nil || err.Status() != http.StatusNotFound {
+		t.Errorf("Expected a 404 for an alias of a deleted version")
+	}
+
+	/* Deleting by alias deletes the version it points at. */
+	if err := cb.DeleteVersion("beta"); err != nil {
+		t.Fatalf("Deleting version 2.0.0 by its alias failed: %s", err.Error())
+	}
+	if _, found := cb.Versions["2.0.0"]; found {
+		t.Errorf("Version 2.0.0 should have been deleted through its alias")
+	}
+	if len(cb.AllAliases()) != 0 {
+		t.Errorf("Expected no aliases left, got %v", cb.AllAliases())
+	}
+}
+
+func TestInstallOrder(t *testing.T){
+	makeDepCookbook("order_app", "1.0.0", map[string]interface{}{ "order_web": ">= 0.0.0", "order_base": ">= 0.0.0" })
+	makeDepCookbook("order_web", "2.0.0", map[string]interface{}{ "order_base": ">= 0.0.0" })
+	makeDepCookbook("order_base", "3.0.0", map[string]interface{}{})
+
+	order, err := InstallOrder([]string{ "order_app" }, map[string]string{})
+	if err != nil {
+		t.Fatalf("InstallOrder failed: %s", err.Error())
+	}
+	want := []map[string]string{ { "name": "order_base", "version": "3.0.0" }, { "name": "order_web", "version": "2.0.0" }, { "name": "order_app", "version": "1.0.0" } }
+	if !reflect.DeepEqual(order, want) {
+		t.Errorf("Expected install order %v, got %v", want, order)
+	}
+
+	if _, err := topoSort(map[string][]string{ "a": { "b" }, "b": { "a" } }); err == nil || !strings.Contains(err.Error(), "circular dependency: a -> b -> a") {
+		t.Errorf("Expected a circular dependency error, got %v", err)
+	}
+}
+
+func TestFileRefs(t *testing.T){
+	cb := makeDepCookbook("refs_cb", "1.0.0", map[string]interface{}{})
+	cb.Versions["1.0.0"].Recipes = []map[string]interface{}{ { "name": "default.rb", "path": "recipes/default.rb", "checksum": "refs_sum" } }
+	cb.Versions["1.1.0"] = &CookbookVersion{ CookbookName: "refs_cb", Version: "1.1.0", Name: "refs_cb-1.1.0", Metadata: map[string]interface{}{} }
+	cb.Versions["1.1.0"].Recipes = []map[string]interface{}{ { "name": "default.rb", "path": "recipes/default.rb", "checksum": "refs_sum" } }
+	cb.Versions["1.1.0"].Files = []map[string]interface{}{ { "name": "motd", "path": "files/default/motd", "checksum": "refs_sum" } }
+	cb.Save()
+
+	refs := FileRefs("refs_sum")
+	want := []map[string]string{
+		{ "cookbook": "refs_cb", "version": "1.1.0", "segment": "recipes", "path": "recipes/default.rb" },
+		{ "cookbook": "refs_cb", "version": "1.1.0", "segment": "files", "path": "files/default/motd" },
+		{ "cookbook": "refs_cb", "version": "1.0.0", "segment": "recipes", "path": "recipes/default.rb" },
+	}
+	if !reflect.DeepEqual(refs, want) {
+		t.Errorf("Expected file references %v, got %v", want, refs)
+	}
+	if refs := FileRefs("unused_sum"); len(refs) != 0 {
+		t.Errorf("Expected no references to an unused checksum, got %v", refs)
+	}
+
+	/* The file index has to keep up with deleted versions. */
+	if err := cb.DeleteVersion("1.1.0"); err != nil {
+		t.Fatalf("Deleting version 1.1.0 failed: %s", err.Error())
+	}
+	refs = FileRefs("refs_sum")
+	if !reflect.DeepEqual(refs, want[2:]) {
+		t.Errorf("Expected file references %v after deleting 1.1.0, got %v", want[2:], refs)
+	}
+}
+
+func TestDependsCookbooksTimed(t *testing.T){
+	makeDepCookbook("timed_b", "1.0.0", map[string]interface{}{})
+	makeDepCookbook("timed_a", "1.0.0", map[string]interface{}{ "timed_b": ">= 0.0.0" })
+	timing := new(DepsolveTiming)
+	deps, err := DependsCookbooksTimed([]string{ "timed_a" }, map[string]string{}, timing)
+	if err != nil {
+		t.Fatalf("DependsCookbooksTimed failed: %s", err.Error())
+	}
+	if len(deps) != 2 {
+		t.Errorf("Expected 2 cookbooks, got %d", len(deps))
+	}
+	if timing.Fetch <= 0 || timing.Serialize <= 0 || timing.Resolve < 0 {
+		t.Errorf("Timing was not recorded: %+v", timing)
+	}
+	if st := timing.ServerTiming(); !strings.HasPrefix(st, "fetch;dur=") || !strings.Contains(st, "serialize;dur=") {
+		t.Errorf("Unexpected Server-Timing value %s", st)
+	}
+}
+
+func TestUploadFileStatus(t *testing.T){
+	old_recipe := []byte(`log "already here"`)
+	old_sum := fmt.Sprintf("%x", md5.Sum(old_recipe))
+	fs, _ := filestore.New(old_sum, ioutil.NopCloser(strings.NewReader(string(old_recipe))), int64(len(old_recipe)))
+	fs.Save()
+	new_recipe := []byte(`log "brand new"`)
+	new_sum := fmt.Sprintf("%x", md5.Sum(new_recipe))
+	fs, _ = filestore.New(new_sum, ioutil.NopCloser(strings.NewReader(string(new_recipe))), int64(len(new_recipe)))
+	fs.Save()
+
+	cb := makeDepCookbook("statusme", "1.0.0", map[string]interface{}{})
+	cb.Versions["1.0.0"].Recipes = []map[string]interface{}{ { "name": "default.rb", "path": "recipes/default.rb", "checksum": old_sum } }
+	cb.Save()
+
+	cbv_data := map[string]interface{}{
+		"recipes": []interface{}{
+			map[string]interface{}{ "name": "default.rb", "path": "recipes/default.rb", "checksum": old_sum },
+			map[string]interface{}{ "name": "new.rb", "path": "recipes/new.rb", "checksum": new_sum },
+		},
+		"templates": []interface{}{
+			map[string]interface{}{ "name": "gone.erb", "path": "templates/default/gone.erb", "checksum": "00000000000000000000000000000000" },
+		},
+	}
+	expected := map[string]string{ "recipes/default.rb": "existing", "recipes/new.rb": "uploaded", "templates/default/gone.erb": "missing" }
+	statuses := UploadFileStatus(cbv_data)
+	if len(statuses) != len(expected) {
+		t.Fatalf("Expected %d file statuses, got %d: %v", len(expected), len(statuses), statuses)
+	}
+	for _, s := range statuses {
+		if s["status"] != expected[s["path"].(string)] {
+			t.Errorf("Expected %s to be %s, got %s", s["path"], expected[s["path"].(string)], s["status"])
+		}
+	}
+}
+
+func TestPrereleaseVersions(t *testing.T){
+	versions := VersionStrings{ "1.2.3", "1.2.3-rc.1", "1.0", "1.2.3-alpha", "1.2.3-alpha.10", "1.2.3-alpha.2", "2.0.0-beta+build5", "1.2.4", "1.2.3-rc.1.1" }
+	sort.Sort(versions)
+	expected := VersionStrings{ "1.0", "1.2.3-alpha", "1.2.3-alpha.2", "1.2.3-alpha.10", "1.2.3-rc.1", "1.2.3-rc.1.1", "1.2.3", "1.2.4", "2.0.0-beta+build5" }
+	if !reflect.DeepEqual(versions, expected) {
+		t.Errorf("Expected versions sorted as %v, got %v", expected, versions)
+	}
+
+	checks := []struct{ ver, op, con, want string }{
+		{ "1.2.0-rc1", ">=", "1.2.0", "skip" },
+		{ "2.0.0-beta", ">=", "1.0.0", "skip" },
+		{ "1.2.0-rc2", ">=", "1.2.0-rc1", "ok" },
+		{ "1.2.0-rc1", "=", "1.2.0-rc1", "ok" },
+		{ "1.2.0", ">", "1.2.0-rc1", "ok" },
+		{ "1.2.0+build5", "=", "1.2.0", "ok" },
+		{ "1.2.5", "~>", "1.2.0", "ok" },
+	}
+	for _, c := range checks {
+		if got := verConstraintCheck(c.ver, c.con, c.op); got != c.want {
+			t.Errorf("Checking %s against '%s %s' gave %s, expected %s", c.ver, c.op, c.con, got, c.want)
+		}
+	}
+
+	cb := makeDepCookbook("prerel", "1.2.0-rc1", nil)
+	cb.Versions["1.1.0"] = &CookbookVersion{ CookbookName: "prerel", Version: "1.1.0", Name: "prerel-1.1.0" }
+	cb.Save()
+	if l := cb.LatestConstrained(">= 1.0.0"); l == nil || l.Version != "1.1.0" {
+		t.Errorf("Expected >= 1.0.0 to get 1.1.0 rather than the pre-release, got %v", l)
+	}
+}
+
+func TestSameVersionUpload(t *testing.T){
+	cb, _ := New("samever")
+	cb.Save()
+	newData := func(ver string) map[string]interface{} {
+		return map[string]interface{}{
+			"cookbook_name": "samever",
+			"name": "samever-" + ver,
+			"version": ver,
+			"json_class": "Chef::CookbookVersion",
+			"chef_type": "cookbook_version",
+			"frozen?": false,
+			"metadata": map[string]interface{}{ "version": ver, "name": "samever" },
+		}
+	}
+	if _, err := cb.NewVersion("1.2.3+build5", newData("1.2.3+build5")); err != nil {
+		t.Fatal(err)
+	}
+	_, err := cb.NewVersion("1.2.3+build6", newData("1.2.3+build6"))
+	if err == nil || err.Status() != http.StatusConflict {
+		t.Errorf("Uploading a version differing only by build metadata should have been a 409, got %v", err)
+	}
+	if _, err := cb.NewVersion("1.2.3-rc.1+build6", newData("1.2.3-rc.1+build6")); err != nil {
+		t.Errorf("Uploading a pre-release of an existing version failed: %s", err.Error())
+	}
+	if _, err := cb.NewVersion("2.1.0", newData("2.1.0")); err != nil {
+		t.Fatal(err)
+	}
+	for _, v := range []string{ "2.1.0.0", "2.1.0.0.0" } {
+		_, err = cb.NewVersion(v, newData(v))
+		if err == nil || err.Status() != http.StatusConflict {
+			t.Errorf("Uploading %s when 2.1.0 exists should have been a 409, got %v", v, err)
+		}
+	}
+	if _, err := cb.NewVersion("2.1.0.1", newData("2.1.0.1")); err != nil {
+		t.Errorf("Uploading 2.1.0.1 failed: %s", err.Error())
+	}
+}
+
+func TestNotEqualConstraint(t *testing.T){
+	cb := makeDepCookbook("noteq", "1.4.2", map[string]interface{}{})
+	for _, v := range []string{ "1.4.1", "1.3.0" } {
+		cb.Versions[v] = &CookbookVersion{ CookbookName: "noteq", Version: v, Name: "noteq-" + v, Metadata: map[string]interface{}{ "dependencies": map[string]interface{}{} } }
+	}
+	cb.Save()
+	makeDepCookbook("noteq_user", "1.0.0", map[string]interface{}{ "noteq": "!= 1.4.2" })
+
+	if l := cb.LatestConstrained("!= 1.4.2"); l == nil || l.Version != "1.4.1" {
+		t.Errorf("Expected != 1.4.2 to get 1.4.1, got %v", l)
+	}
+	info := cb.ConstrainedInfoHash("all", "!= 1.4.2")
+	if vers := info["versions"].([]interface{}); len(vers) != 2 || vers[0].(map[string]string)["version"] != "1.4.1" {
+		t.Errorf("Expected the info hash for != 1.4.2 to have 1.4.1 and 1.3.0, got %v", vers)
+	}
+
+	deps, err := DependsCookbooks([]string{ "noteq" }, map[string]string{ "noteq": "!= 1.4.2" })
+	if err != nil {
+		t.Fatalf("DependsCookbooks with an environment != constraint failed: %s", err.Error())
+	}
+	if v := deps["noteq"].(map[string]interface{})["version"]; v != "1.4.1" {
+		t.Errorf("Expected the environment's != 1.4.2 to give 1.4.1, got %v", v)
+	}
+	deps, err = DependsCookbooks([]string{ "noteq_user" }, map[string]string{})
+	if err != nil {
+		t.Fatalf("DependsCookbooks with a != dependency failed: %s", err.Error())
+	}
+	if v := deps["noteq"].(map[string]interface{})["version"]; v != "1.4.1" {
+		t.Errorf("Expected a dependency on != 1.4.2 to give 1.4.1, got %v", v)
+	}
+	if _, err = DependsCookbooks([]string{ "noteq@1.4.2" }, map[string]string{ "noteq": "!= 1.4.2" }); err == nil {
+		t.Errorf("Pinning a version the environment excludes should have failed")
+	}
+}
+
+func TestFourPartVersions(t *testing.T){
+	checks := []struct{ a, b string; want int }{
+		{ "2.1.0.3", "2.1.0", 1 },
+		{ "2.1.0", "2.1.0.3", -1 },
+		{ "2.1.0.3", "2.1.0.10", -1 },
+		{ "2.1.0.10", "2.1.0.3", 1 },
+		{ "2.1.0", "2.1.0.0", 0 },
+		{ "2.1", "2.1.0", 0 },
+		{ "2.1.0.3-rc.1", "2.1.0.3", -1 },
+	}
+	for _, c := range checks {
+		if got := compareVersions(c.a, c.b); got != c.want {
+			t.Errorf("Comparing %s with %s: expected %d, got %d", c.a, c.b, c.want, got)
+		}
+	}
+	if verConstraintCheck("2.1.0.9", "2.1.0.3", "~>") != "ok" {
+		t.Errorf("2.1.0.9 should have satisfied ~> 2.1.0.3")
+	}
+	if verConstraintCheck("2.1.1", "2.1.0.3", "~>") != "skip" {
+		t.Errorf("2.1.1 shouldn't have satisfied ~> 2.1.0.3")
+	}
+
+	maj, min, patch, err := extractVerNums("2.1.0.3")
+	if err != nil || maj != 2 || min != 1 || patch != 0 {
+		t.Errorf("Expected 2, 1, 0 from 2.1.0.3, got %d, %d, %d (%v)", maj, min, patch, err)
+	}
+	extra, _, _ := extractVerExtra("2.1.0.3.1-rc.1")
+	if extra != "3.1" {
+		t.Errorf("Expected extra version numbers 3.1, got '%s'", extra)
+	}
+	if v := joinVersion(maj, min, patch, extra, "rc.1", ""); v != "2.1.0.3.1-rc.1" {
+		t.Errorf("Expected 2.1.0.3.1-rc.1 put back together, got %s", v)
+	}
+	if _, _, _, err := extractVerNums("2"); err == nil {
+		t.Errorf("A version with one number should have been rejected")
+	}
+}
+
+func TestVersionGrowth(t *testing.T){
+	cb := makeDepCookbook("growing", "1.0.0", map[string]interface{}{})
+	day := time.Date(2001, 2, 3, 0, 0, 0, 0, time.UTC)
+	cb.Versions["1.0.0"].CreatedAt = day.Add(time.Hour)
+	cb.Versions["1.1.0"] = &CookbookVersion{ CookbookName: "growing", Version: "1.1.0", Name: "growing-1.1.0", CreatedAt: day.Add(5 * time.Hour) }
+	cb.Versions["1.2.0"] = &CookbookVersion{ CookbookName: "growing", Version: "1.2.0", Name: "growing-1.2.0", CreatedAt: day.Add(30 * time.Hour) }
+	cb.Save()
+	growth, err := VersionGrowth(24 * time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	counts := make(map[time.Time]int)
+	for i, g := range growth {
+		if i > 0 && !growth[i - 1].BucketStart.Before(g.BucketStart) {
+			t.Errorf("Growth buckets are out of order: %v before %v", growth[i - 1].BucketStart, g.BucketStart)
+		}
+		counts[g.BucketStart] = g.Count
+	}
+	if counts[day] != 2 || counts[day.Add(24 * time.Hour)] != 1 {
+		t.Errorf("Expected 2 versions on %v and 1 the day after, got %v", day, growth)
+	}
+	if _, err := VersionGrowth(time.Millisecond); err == nil {
+		t.Errorf("A growth interval under a second should have been rejected")
+	}
+}
+
+func TestConcurrentVersions(t *testing.T){
+	cb, _ := New("racer")
+	cb.Save()
+	newData := func(ver string) map[string]interface{} {
+		return map[string]interface{}{
+			"cookbook_name": "racer",
+			"name": "racer-" + ver,
+			"version": ver,
+			"json_class": "Chef::CookbookVersion",
+			"chef_type": "cookbook_version",
+			"frozen?": false,
+			"metadata": map[string]interface{}{ "version": ver, "name": "racer" },
+		}
+	}
+	if _, err := cb.NewVersion("0.1.0", newData("0.1.0")); err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		ver := fmt.Sprintf("1.0.%d", i)
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			if _, err := cb.NewVersion(ver, newData(ver)); err != nil {
+				t.Errorf("Uploading %s failed: %s", ver, err.Error())
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			UploadFileStatus(newData(ver))
+			FileRefs("deadbeef")
+			cb.GetVersion(ver)
+			cb.LatestVersion()
+			cb.InfoHash("all")
+			cb.NumVersions()
+			cb.SetAlias("stable", "0.1.0")
+			cb.AllAliases()
+			cb.Save()
+		}()
+	}
+	wg.Wait()
+	for i := 0; i < 10; i++ {
+		ver := fmt.Sprintf("1.0.%d", i)
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			if err := cb.DeleteVersion(ver); err != nil {
+				t.Errorf("Deleting %s failed: %s", ver, err.Error())
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			cb.UpdateLatestVersion()
+			cb.sortedVersions()
+		}()
+	}
+	wg.Wait()
+	if n := cb.NumVersions(); n != 11 {
+		t.Errorf("Expected 11 versions left, got %d", n)
+	}
+	if l := cb.LatestVersion(); l.Version != "1.0.19" {
+		t.Errorf("Expected 1.0.19 to be the latest version, got %s", l.Version)
+	}
+}
+
+func TestUniverseEntry(t *testing.T){
+	cb := makeDepCookbook("universal", "1.0.0", map[string]interface{}{ "base": ">= 1.0.0" })
+	cb.Versions["1.1.0"] = &CookbookVersion{ CookbookName: "universal", Version: "1.1.0", Name: "universal-1.1.0", IsFrozen: true, Metadata: map[string]interface{}{} }
+	cb.Versions["1.2.0"] = &CookbookVersion{ CookbookName: "universal", Version: "1.2.0", Name: "universal-1.2.0", Metadata: map[string]interface{}{} }
+	cb.Save()
+
+	entry := cb.UniverseEntry(0, nil)
+	if len(entry) != 3 {
+		t.Fatalf("Expected all 3 versions in the universe entry, got %v", entry)
+	}
+	v1 := entry["1.0.0"].(map[string]interface{})
+	if v1["location_type"] != "opscode" || v1["location_path"] != util.CustomURL("/cookbooks/universal/1.0.0") {
+		t.Errorf("Unexpected location for 1.0.0: %v", v1)
+	}
+	if deps := v1["dependencies"].(map[string]interface{}); deps["base"] != ">= 1.0.0" {
+		t.Errorf("Expected 1.0.0 to depend on base >= 1.0.0, got %v", deps)
+	}
+	if deps := entry["1.2.0"].(map[string]interface{})["dependencies"].(map[string]interface{}); len(deps) != 0 {
+		t.Errorf("Expected no dependencies for 1.2.0, got %v", deps)
+	}
+
+	entry = cb.UniverseEntry(2, nil)
+	if _, ok := entry["1.0.0"]; ok || len(entry) != 2 {
+		t.Errorf("Expected only the 2 newest versions, got %v", entry)
+	}
+	entry = cb.UniverseEntry(0, func(cbv *CookbookVersion) bool { return cbv.IsFrozen })
+	if _, ok := entry["1.1.0"]; !ok || len(entry) != 1 {
+		t.Errorf("Expected only the frozen 1.1.0, got %v", entry)
+	}
+}
+
+func TestDeleteCookbooks(t *testing.T){
+	shared := []byte(`log "shared"`)
+	shared_sum := fmt.Sprintf("%x", md5.Sum(shared))
+	only := []byte(`log "only in the doomed cookbook"`)
+	only_sum := fmt.Sprintf("%x", md5.Sum(only))
+	for _, f := range [][]byte{ shared, only } {
+		fs, _ := filestore.New(fmt.Sprintf("%x", md5.Sum(f)), ioutil.NopCloser(strings.NewReader(string(f))), int64(len(f)))
+		fs.Save()
+	}
+
+	keeper := makeDepCookbook("keeper", "1.0.0", map[string]interface{}{})
+	keeper.Versions["1.0.0"].Recipes = []map[string]interface{}{ { "name": "default.rb", "path": "recipes/default.rb", "checksum": shared_sum } }
+	keeper.Save()
+	doomed := makeDepCookbook("doomed", "1.0.0", map[string]interface{}{})
+	doomed.Versions["1.0.0"].Recipes = []map[string]interface{}{ { "name": "default.rb", "path": "recipes/default.rb", "checksum": shared_sum } }
+	doomed.Versions["1.1.0"] = &CookbookVersion{ CookbookName: "doomed", Version: "1.1.0", Name: "doomed-1.1.0", Recipes: []map[string]interface{}{ { "name": "only.rb", "path": "recipes/only.rb", "checksum": only_sum } } }
+	doomed.Save()
+	makeDepCookbook("doomed2", "0.1.0", map[string]interface{}{})
+
+	deleted, err := DeleteCookbooks([]string{ "doomed", "nonexistent", "doomed2", "doomed" })
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(deleted)
+	if !reflect.DeepEqual(deleted, []string{ "doomed", "doomed2" }) {
+		t.Errorf("Expected doomed and doomed2 to be deleted, got %v", deleted)
+	}
+	for _, name := range []string{ "doomed", "doomed2" } {
+		if _, gerr := Get(name); gerr == nil {
+			t.Errorf("Cookbook %s was still there after being deleted", name)
+		}
+	}
+	if _, ferr := filestore.Get(shared_sum); ferr != nil {
+		t.Errorf("The file shared with keeper was deleted along with doomed")
+	}
+	if _, ferr := filestore.Get(only_sum); ferr == nil {
+		t.Errorf("The file only doomed used was left in the filestore")
+	}
+}
+
+func TestSatisfies(t *testing.T){
+	cbv := &CookbookVersion{ Version: "1.2.3" }
+	checks := map[string]bool{ "1.2.3": true, "1.2.0": false, "= 1.2.3": true, "< 1.2.3": false, "~> 1.2.0": true, ">= 2.0.0": false, "!= 1.2.3": false, "bogus constraint here": false }
+	for c, want := range checks {
+		if got := cbv.Satisfies(c); got != want {
+			t.Errorf("Expected 1.2.3 satisfying '%s' to be %v, got %v", c, want, got)
+		}
+	}
+}
+
+func TestSetFrozen(t *testing.T){
+	makeDepCookbook("freezer", "1.0.0", map[string]interface{}{})
+	cb, _ := Get("freezer")
+	cbv, _ := cb.GetVersion("1.0.0")
+	if err := cbv.SetFrozen(true); err != nil {
+		t.Fatal(err)
+	}
+
+	/* Freeze the data store to disk and load it back, to make sure the
+	 * version stays frozen. */
+	gob.Register(new(Cookbook))
+	gob.Register(make(map[string]interface{}))
+	gob.Register(make([]interface{}, 0))
+	tmpdir, terr := ioutil.TempDir("", "cookbook-test")
+	if terr != nil {
+		t.Fatal(terr)
+	}
+	defer os.RemoveAll(tmpdir)
+	ds_file := path.Join(tmpdir, "ds.bin")
+	ds := data_store.New()
+	if err := ds.Save(ds_file); err != nil {
+		t.Fatal(err)
+	}
+	if err := ds.Load(ds_file); err != nil {
+		t.Fatal(err)
+	}
+	cb, _ = Get("freezer")
+	cbv, _ = cb.GetVersion("1.0.0")
+	if !cbv.IsFrozen {
+		t.Errorf("freezer 1.0.0 wasn't frozen after the data store was loaded again")
+	}
+
+	if err := cbv.SetFrozen(false); err != nil {
+		t.Fatal(err)
+	}
+	cb, _ = Get("freezer")
+	if cbv, _ = cb.GetVersion("1.0.0"); cbv.IsFrozen {
+		t.Errorf("freezer 1.0.0 was still frozen after unfreezing it")
+	}
+}
+
+func TestDisableForceUpload(t *testing.T){
+	cb := makeDepCookbook("unforceable", "1.0.0", map[string]interface{}{})
+	cbv := cb.Versions["1.0.0"]
+	cbv.IsFrozen = true
+	config.Config.DisableForceUpload = true
+	defer func() { config.Config.DisableForceUpload = false }()
+	err := cbv.UpdateVersion(map[string]interface{}{}, "true")
+	if err == nil {
+		t.Fatalf("Forcing an update to a frozen version should have failed with disable-force-upload set")
+	}
+	if err.Status() != http.StatusConflict {
+		t.Errorf("Expected a 409 forcing an update to a frozen version, got %d", err.Status())
+	}
+}
+
+func TestLatestVersionNoVersions(t *testing.T){
+	cb := &Cookbook{ Name: "versionless", Versions: make(map[string]*CookbookVersion) }
+	cb.Save()
+	if cbv := cb.LatestVersion(); cbv != nil {
+		t.Errorf("Expected no latest version for a cookbook without versions, got %s", cbv.Version)
+	}
+	if _, err := cb.GetVersion("_latest"); err == nil || err.Status() != http.StatusNotFound {
+		t.Errorf("Expected a 404 getting _latest of a cookbook without versions, got %v", err)
+	}
+	if _, ok := LatestVersions()["versionless"]; ok {
+		t.Errorf("A cookbook without versions was in LatestVersions")
+	}
+	if _, err := DependsCookbooks([]string{ "versionless" }, map[string]string{}); err == nil {
+		t.Errorf("Depsolving a cookbook without versions should have failed")
+	}
+	cb.Delete()
+}
+
+func TestIndexFileRefs(t *testing.T){
+	chksum := fmt.Sprintf("%032x", 987654321)
+	cb := &Cookbook{ Name: "refidx", Versions: make(map[string]*CookbookVersion) }
+	recipes := []map[string]interface{}{ { "name": "default.rb", "path": "recipes/default.rb", "checksum": chksum } }
+	cb.Versions["1.0.0"] = &CookbookVersion{ CookbookName: "refidx", Version: "1.0.0", Name: "refidx-1.0.0", Recipes: recipes }
+	/* Put it straight in the data store, like loading it from disk would,
+	 * so the index doesn't hear about it. */
+	data_store.New().Set("cookbook", cb.Name, cb)
+	IndexFileRefs()
+	if u := hashRefs.unreferenced([]string{ chksum }); len(u) != 0 {
+		t.Errorf("The file used by refidx 1.0.0 wasn't indexed at startup")
+	}
+	cb.Delete()
+}
+
+/* A few thousand cookbook versions, each sharing one file with the version
+ * before it, then deleting the files of one version that are all still in use
+ * elsewhere. */
+func BenchmarkDeleteHashes(b *testing.B){
+	var hashes []string
+	var cb *Cookbook
+	for i := 0; i < 200; i++ {
+		cb = &Cookbook{ Name: fmt.Sprintf("bench%d", i), Versions: make(map[string]*CookbookVersion) }
+		for j := 0; j < 15; j++ {
+			ver := fmt.Sprintf("1.0.%d", j)
+			n := i * 15 + j
+			recipes := []map[string]interface{}{
+				{ "name": "default.rb", "path": "recipes/default.rb", "checksum": fmt.Sprintf("%032x", n) },
+				{ "name": "shared.rb", "path": "recipes/shared.rb", "checksum": fmt.Sprintf("%032x", n + 1) },
+			}
+			cb.Versions[ver] = &CookbookVersion{ CookbookName: cb.Name, Version: ver, Name: cb.Name + "-" + ver, Recipes: recipes }
+		}
+		cb.Save()
+	}
+	hashes = cb.Versions["1.0.5"].fileHashes()
+	cb.deleteHashes(hashes)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		cb.deleteHashes(hashes)
+	}
+}
+
+func TestIgnoreUnknownFields(t *testing.T){
+	cb, _ := New("unknownfields")
+	cb.Save()
+	newData := func(ver string) map[string]interface{} {
+		return map[string]interface{}{
+			"cookbook_name": "unknownfields",
+			"name": "unknownfields-" + ver,
+			"version": ver,
+			"json_class": "Chef::CookbookVersion",
+			"chef_type": "cookbook_version",
+			"frozen?": false,
+			"metadata": map[string]interface{}{ "version": ver, "name": "unknownfields" },
+			"all_files": []interface{}{},
+		}
+	}
+	_, err := cb.NewVersion("1.0.0", newData("1.0.0"))
+	if err == nil || !strings.Contains(err.Error(), "all_files") {
+		t.Errorf("Uploading a version with an unknown field should have failed, got %v", err)
+	}
+	config.Config.IgnoreUnknownFields = true
+	defer func() { config.Config.IgnoreUnknownFields = false }()
+	cbv, err := cb.NewVersion("1.0.0", newData("1.0.0"))
+	if err != nil {
+		t.Fatalf("Uploading a version with an unknown field failed with ignore-unknown-fields set: %s", err.Error())
+	}
+	if cbv.Version != "1.0.0" {
+		t.Errorf("Uploaded version was %s, expected 1.0.0", cbv.Version)
+	}
+	cb.Delete()
+}
+
+func TestDepsolveLimits(t *testing.T){
+	for i := 0; i < 5; i++ {
+		deps := map[string]interface{}{}
+		if i < 4 {
+			deps[fmt.Sprintf("limitdeep%d", i + 1)] = ">= 0.0.0"
+		}
+		makeDepCookbook(fmt.Sprintf("limitdeep%d", i), "1.0.0", deps)
+	}
+	config.Config.DepsolveMaxRunList = 2
+	config.Config.DepsolveMaxDepth = 2
+	defer func() {
+		config.Config.DepsolveMaxRunList = 0
+		config.Config.DepsolveMaxDepth = 0
+	}()
+
+	_, err := DependsCookbooks([]string{ "limitdeep2", "limitdeep3", "limitdeep4" }, map[string]string{})
+	if err == nil || err.Status() != http.StatusBadRequest {
+		t.Errorf("A run list longer than depsolve-max-run-list should have been a 400, got %v", err)
+	}
+	if _, err := DependsCookbooks([]string{ "limitdeep3", "limitdeep4" }, map[string]string{}); err != nil {
+		t.Errorf("Depsolving a run list within the limits failed: %s", err.Error())
+	}
+	_, err = DependsCookbooks([]string{ "limitdeep0" }, map[string]string{})
+	if err == nil || err.Status() != http.StatusBadRequest {
+		t.Errorf("Dependencies nested deeper than depsolve-max-depth should have been a 400, got %v", err)
+	}
+	makeDepCookbook("limitmissing", "1.0.0", map[string]interface{}{ "limitnothere": ">= 0.0.0" })
+	_, err = DependsCookbooks([]string{ "limitmissing" }, map[string]string{})
+	if err == nil || err.Status() != http.StatusPreconditionFailed {
+		t.Errorf("A missing dependency should still be a 412, got %v", err)
+	}
+}
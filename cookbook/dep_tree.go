@@ -0,0 +1,99 @@
+/* Dependency trees for cookbook versions. */
+
+/*
+ * Copyright (c) 2013-2014, Jeremy Bingham (<jbingham@gmail.com>)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cookbook
+
+import (
+	"github.com/ctdk/goiardi/config"
+	"sort"
+)
+
+// Build the tree of cookbooks this cookbook version depends on, directly or
+// otherwise. Each node in the tree has the cookbook's name, the constraint it
+// was depended on with, the version chosen for it (the latest one satisfying
+// the constraint), and the nodes for its own dependencies. Unlike the
+// depsolver, problems don't stop the walk: a dependency that can't be resolved
+// gets an "error" instead of a version, and a dependency that leads back to a
+// cookbook already on the path is marked with "cycle" and not followed. A
+// cookbook version that turns up more than once in the tree is only walked the
+// first time; the other places it appears share the same dependencies.
+func (cbv *CookbookVersion) DependencyTree() map[string]interface{} {
+	tree := map[string]interface{}{
+		"name": cbv.CookbookName,
+		"version": cbv.Version,
+	}
+	seen := make(map[string][]map[string]interface{})
+	tree["dependencies"], _ = cbv.dependencyTree([]string{ cbv.CookbookName }, seen)
+	return tree
+}
+
+/* seen holds the dependencies already worked out for each cookbook version,
+ * by name (like "foo-1.2.3"), so shared dependencies aren't walked again and
+ * again. A subtree cut off by the depth limit depends on how deep it was found,
+ * so it isn't kept in seen; the second return value is false for those. */
+func (cbv *CookbookVersion) dependencyTree(path []string, seen map[string][]map[string]interface{}) ([]map[string]interface{}, bool) {
+	complete := true
+	deps := make([]map[string]interface{}, 0)
+	dep_list, _ := cbv.Metadata["dependencies"].(map[string]interface{})
+	dep_names := make([]string, 0, len(dep_list))
+	for d := range dep_list {
+		dep_names = append(dep_names, d)
+	}
+	sort.Strings(dep_names)
+
+	DepLoop:
+	for _, d := range dep_names {
+		c, _ := dep_list[d].(string)
+		node := map[string]interface{}{ "name": d, "constraint": c }
+		deps = append(deps, node)
+		for _, p := range path {
+			if p == d {
+				node["cycle"] = true
+				continue DepLoop
+			}
+		}
+		if max := config.Config.DepsolveMaxDepth; max > 0 && len(path) > max {
+			node["error"] = "too deeply nested"
+			complete = false
+			continue
+		}
+		dep_cb, err := Get(d)
+		if err != nil {
+			node["error"] = err.Error()
+			continue
+		}
+		dep_cbv := dep_cb.LatestConstrained(c)
+		if dep_cbv == nil {
+			node["error"] = "no version satisfies the constraint"
+			continue
+		}
+		node["version"] = dep_cbv.Version
+		if sub, found := seen[dep_cbv.Name]; found {
+			node["dependencies"] = sub
+			continue
+		}
+		sub, sub_complete := dep_cbv.dependencyTree(append(path[:len(path):len(path)], d), seen)
+		node["dependencies"] = sub
+		if sub_complete {
+			seen[dep_cbv.Name] = sub
+		} else {
+			complete = false
+		}
+	}
+	return deps, complete
+}
@@ -0,0 +1,71 @@
+/* Timing the parts of a depsolve. */
+
+/*
+ * Copyright (c) 2013-2014, Jeremy Bingham (<jbingham@gmail.com>)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cookbook
+
+import (
+	"fmt"
+	"time"
+)
+
+// How long the parts of a depsolve took. Fetch is the time spent loading
+// cookbooks and picking versions of them, Resolve is the time spent working
+// out the dependencies apart from that, and Serialize is the time spent
+// building the response for the cookbook versions that were settled on.
+type DepsolveTiming struct {
+	Fetch time.Duration
+	Resolve time.Duration
+	Serialize time.Duration
+	total time.Time
+}
+
+/* The methods here are all safe to call on a nil *DepsolveTiming, so the
+ * depsolver can call them whether or not anyone asked for the timing. */
+
+func (t *DepsolveTiming) start() {
+	if t != nil {
+		t.total = time.Now()
+	}
+}
+
+func (t *DepsolveTiming) fetched(since time.Time) {
+	if t != nil {
+		t.Fetch += time.Since(since)
+	}
+}
+
+func (t *DepsolveTiming) serialized(since time.Time) {
+	if t != nil {
+		t.Serialize += time.Since(since)
+	}
+}
+
+func (t *DepsolveTiming) finish() {
+	if t != nil {
+		t.Resolve = time.Since(t.total) - t.Fetch - t.Serialize
+	}
+}
+
+// Formats the timing for a Server-Timing header, with the durations in
+// milliseconds.
+func (t *DepsolveTiming) ServerTiming() string {
+	ms := func(d time.Duration) float64 {
+		return float64(d) / float64(time.Millisecond)
+	}
+	return fmt.Sprintf("fetch;dur=%.3f, resolve;dur=%.3f, serialize;dur=%.3f", ms(t.Fetch), ms(t.Resolve), ms(t.Serialize))
+}
@@ -0,0 +1,123 @@
+/* Comparing cookbook versions. */
+
+/*
+ * Copyright (c) 2013-2014, Jeremy Bingham (<jbingham@gmail.com>)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cookbook
+
+import (
+	"reflect"
+	"sort"
+)
+
+// Compare this version of a cookbook with another version, using only their
+// manifests, so the files themselves aren't needed. Under "files" are the
+// segments (recipes, templates, and so on) that have differences, each with
+// the paths of the files "added", "removed", and "changed" going from this
+// version to other, worked out the same way as RecipeDiff. Under "metadata"
+// are the top level metadata keys added, removed, and changed.
+func (cbv *CookbookVersion) Diff(other *CookbookVersion) map[string]interface{} {
+	files := make(map[string]interface{})
+	for _, seg := range fileSegments {
+		d := diffDivision(cbv.segment(seg), other.segment(seg))
+		if len(d["added"]) + len(d["removed"]) + len(d["changed"]) > 0 {
+			files[seg] = d
+		}
+	}
+	diff := map[string]interface{}{
+		"from": cbv.Version,
+		"to": other.Version,
+		"files": files,
+		"metadata": diffMetadata(cbv.Metadata, other.Metadata),
+	}
+	return diff
+}
+
+// Compare the recipes in this version of a cookbook with those in another
+// version, by name and checksum. Returns the names of recipes in other but not
+// this version under "added", those in this version but not other under
+// "removed", and those whose contents differ under "changed".
+func (cbv *CookbookVersion) RecipeDiff(other *CookbookVersion) map[string][]string {
+	return diffDivision(cbv.Recipes, other.Recipes)
+}
+
+/* Compare two cookbook divisions, like recipes or templates, matching files by
+ * name and comparing their checksums. Each list in the result is sorted. */
+func diffDivision(from, to []map[string]interface{}) map[string][]string {
+	from_sums := divisionChecksums(from)
+	to_sums := divisionChecksums(to)
+	diff := map[string][]string{
+		"added": []string{},
+		"removed": []string{},
+		"changed": []string{},
+	}
+	for name, sum := range to_sums {
+		if fsum, found := from_sums[name]; !found {
+			diff["added"] = append(diff["added"], name)
+		} else if fsum != sum {
+			diff["changed"] = append(diff["changed"], name)
+		}
+	}
+	for name := range from_sums {
+		if _, found := to_sums[name]; !found {
+			diff["removed"] = append(diff["removed"], name)
+		}
+	}
+	for _, v := range diff {
+		sort.Strings(v)
+	}
+	return diff
+}
+
+/* Compare the top level keys of two versions' metadata. */
+func diffMetadata(from, to map[string]interface{}) map[string][]string {
+	diff := map[string][]string{
+		"added": []string{},
+		"removed": []string{},
+		"changed": []string{},
+	}
+	for k, v := range to {
+		if fv, found := from[k]; !found {
+			diff["added"] = append(diff["added"], k)
+		} else if !reflect.DeepEqual(fv, v) {
+			diff["changed"] = append(diff["changed"], k)
+		}
+	}
+	for k := range from {
+		if _, found := to[k]; !found {
+			diff["removed"] = append(diff["removed"], k)
+		}
+	}
+	for _, v := range diff {
+		sort.Strings(v)
+	}
+	return diff
+}
+
+/* Map the files in a division to their checksums. Files are keyed by path if
+ * they have one, since templates and files for different platforms can share
+ * a name. */
+func divisionChecksums(div []map[string]interface{}) map[string]string {
+	sums := make(map[string]string, len(div))
+	for _, f := range div {
+		name, _ := f["path"].(string)
+		if name == "" {
+			name, _ = f["name"].(string)
+		}
+		sums[name], _ = f["checksum"].(string)
+	}
+	return sums
+}
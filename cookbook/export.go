@@ -0,0 +1,264 @@
+/* Exporting and importing single cookbooks, for copying them between goiardi
+ * servers. */
+
+/*
+ * Copyright (c) 2013-2014, Jeremy Bingham (<jbingham@gmail.com>)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cookbook
+
+import (
+	"github.com/ctdk/goiardi/config"
+	"github.com/ctdk/goiardi/filestore"
+	"github.com/ctdk/goiardi/util"
+	"git.tideland.biz/goas/logger"
+	"bytes"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+)
+
+// Export the cookbook with all of its versions, along with the contents of all
+// of their files, as a self contained bundle that can be loaded into another
+// goiardi server with Import. File contents are base64 encoded and keyed by
+// checksum.
+func (c *Cookbook) Export() (map[string]interface{}, util.Gerror) {
+	versions := c.sortedVersions()
+	exp_versions := make([]map[string]interface{}, 0, len(versions))
+	files := make(map[string]string)
+
+	for _, cbv := range versions {
+		exp_versions = append(exp_versions, cbv.ToJson("PUT"))
+		for _, h := range cbv.fileHashes() {
+			if _, found := files[h]; found {
+				continue
+			}
+			fs, err := filestore.Get(h)
+			if err != nil || fs.Data == nil {
+				gerr := util.Errorf("Could not find the file with checksum %s for cookbook %s version %s", h, c.Name, cbv.Version)
+				gerr.SetStatus(http.StatusInternalServerError)
+				return nil, gerr
+			}
+			files[h] = base64.StdEncoding.EncodeToString(*fs.Data)
+		}
+	}
+
+	bundle := map[string]interface{}{
+		"name": c.Name,
+		"versions": exp_versions,
+		"files": files,
+	}
+	return bundle, nil
+}
+
+// Load a cookbook bundle made by Export, recreating its versions and any files
+// that aren't already in the filestore. File checksums are checked before
+// anything is saved. Versions that already exist are only replaced if force is
+// true. If the import fails partway through, whatever it had done is undone.
+// Returns the cookbook, the versions that were created, and the versions that
+// were replaced.
+func Import(bundle map[string]interface{}, force bool) (*Cookbook, []*CookbookVersion, []*CookbookVersion, util.Gerror) {
+	cb_name, nerr := util.ValidateAsString(bundle["name"])
+	if nerr != nil {
+		nerr = util.Errorf("Field 'name' missing or invalid")
+		return nil, nil, nil, nerr
+	}
+
+	/* Decode and verify the files first */
+	files := make(map[string][]byte)
+	if bf, ok := bundle["files"]; ok && bf != nil {
+		bfiles, ok := bf.(map[string]interface{})
+		if !ok {
+			err := util.Errorf("Field 'files' invalid")
+			return nil, nil, nil, err
+		}
+		for chksum, enc := range bfiles {
+			enc_str, ok := enc.(string)
+			if !ok {
+				err := util.Errorf("Invalid data for file with checksum %s", chksum)
+				return nil, nil, nil, err
+			}
+			data, derr := base64.StdEncoding.DecodeString(enc_str)
+			if derr != nil {
+				err := util.Errorf("Invalid data for file with checksum %s: %s", chksum, derr.Error())
+				return nil, nil, nil, err
+			}
+			if sum := fmt.Sprintf("%x", md5.Sum(data)); sum != chksum {
+				err := util.Errorf("Checksum %s does not match the data given for it, which has a checksum of %s", chksum, sum)
+				return nil, nil, nil, err
+			}
+			files[chksum] = data
+		}
+	}
+
+	bversions, ok := bundle["versions"].([]interface{})
+	if !ok || len(bversions) == 0 {
+		err := util.Errorf("Field 'versions' missing or invalid")
+		return nil, nil, nil, err
+	}
+	versions := make([]map[string]interface{}, len(bversions))
+	for i, v := range bversions {
+		cbv_data, ok := v.(map[string]interface{})
+		if !ok {
+			err := util.Errorf("Invalid cookbook version in bundle")
+			return nil, nil, nil, err
+		}
+		if cn, _ := util.ValidateAsString(cbv_data["cookbook_name"]); cn != cb_name {
+			err := util.Errorf("Cookbook version with cookbook_name '%s' does not belong to cookbook %s", cn, cb_name)
+			return nil, nil, nil, err
+		}
+		if _, ok := cbv_data["version"].(string); !ok {
+			err := util.Errorf("Field 'version' missing")
+			return nil, nil, nil, err
+		}
+		if _, verr := util.ValidateAsVersion(cbv_data["version"]); verr != nil {
+			err := util.Errorf("Field 'version' invalid")
+			return nil, nil, nil, err
+		}
+		versions[i] = cbv_data
+	}
+
+	cb, err := Get(cb_name)
+	cb_created := false
+	if err != nil {
+		cb, err = New(cb_name)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		if serr := cb.Save(); serr != nil {
+			gerr := util.CastErr(serr)
+			gerr.SetStatus(http.StatusInternalServerError)
+			return nil, nil, nil, gerr
+		}
+		cb_created = true
+	} else {
+		for _, cbv_data := range versions {
+			ver := cbv_data["version"].(string)
+			cbv, verr := cb.GetVersion(ver)
+			if verr != nil {
+				continue
+			}
+			if !force {
+				err := util.Errorf("Version %s of cookbook %s already exists. Use the 'force' option to replace it.", ver, cb_name)
+				err.SetStatus(http.StatusConflict)
+				return nil, nil, nil, err
+			}
+			/* Better to find this out before anything's
+			 * changed. */
+			if cbv.IsFrozen && config.Config.DisableForceUpload {
+				err := util.Errorf("The cookbook %s at version %s is frozen, and this server doesn't allow frozen cookbooks to be forced. It must be unfrozen first.", cb_name, ver)
+				err.SetStatus(http.StatusConflict)
+				return nil, nil, nil, err
+			}
+		}
+	}
+
+	/* If anything fails partway through, the import is undone: versions
+	 * it created are deleted, versions it replaced are put back the way
+	 * they were, and files it added are removed. Replacing a version can
+	 * clean up files only the old version used, so those are kept
+	 * around to be put back too. */
+	new_files := make([]string, 0, len(files))
+	old_versions := make(map[string]map[string]interface{})
+	old_files := make(map[string][]byte)
+	created := make([]*CookbookVersion, 0)
+	replaced := make([]*CookbookVersion, 0)
+	rollback := func() {
+		for _, cbv := range created {
+			cb.DeleteVersion(cbv.Version)
+		}
+		for h, data := range old_files {
+			if _, ferr := filestore.Get(h); ferr == nil {
+				continue
+			}
+			if fs, ferr := filestore.New(h, ioutil.NopCloser(bytes.NewReader(data)), int64(len(data))); ferr == nil {
+				fs.Save()
+			}
+		}
+		for _, cbv := range replaced {
+			/* Let the old data decide whether it's frozen. */
+			cbv.IsFrozen = false
+			if uerr := cbv.UpdateVersion(old_versions[cbv.Version], "true"); uerr != nil {
+				logger.Errorf("Error restoring version %s of cookbook %s after a failed import: %s", cbv.Version, cb_name, uerr.Error())
+			}
+		}
+		cb.deleteHashes(new_files)
+		if cb_created && cb.NumVersions() == 0 {
+			cb.Delete()
+		}
+	}
+
+	/* Files first, so the versions will validate. Files already in the
+	 * filestore are left alone. */
+	for chksum, data := range files {
+		if _, ferr := filestore.Get(chksum); ferr == nil {
+			continue
+		}
+		fs, ferr := filestore.New(chksum, ioutil.NopCloser(bytes.NewReader(data)), int64(len(data)))
+		if ferr == nil {
+			ferr = fs.Save()
+		}
+		if ferr != nil {
+			rollback()
+			gerr := util.CastErr(ferr)
+			gerr.SetStatus(http.StatusInternalServerError)
+			return nil, nil, nil, gerr
+		}
+		new_files = append(new_files, chksum)
+	}
+
+	for _, cbv_data := range versions {
+		ver := cbv_data["version"].(string)
+		if cbv, verr := cb.GetVersion(ver); verr == nil {
+			/* Sent through JSON so it can be given back to
+			 * UpdateVersion, like an upload would be. */
+			j, _ := json.Marshal(cbv.ToJson("PUT"))
+			var old_data map[string]interface{}
+			json.Unmarshal(j, &old_data)
+			old_versions[cbv.Version] = old_data
+			for _, h := range cbv.fileHashes() {
+				if _, found := old_files[h]; found {
+					continue
+				}
+				if fs, ferr := filestore.Get(h); ferr == nil && fs.Data != nil {
+					old_files[h] = *fs.Data
+				}
+			}
+			if uerr := cbv.UpdateVersion(cbv_data, "true"); uerr != nil {
+				delete(old_versions, cbv.Version)
+				rollback()
+				return nil, nil, nil, uerr
+			}
+			replaced = append(replaced, cbv)
+		} else {
+			cbv, nerr := cb.NewVersion(ver, cbv_data)
+			if nerr != nil {
+				rollback()
+				return nil, nil, nil, nerr
+			}
+			created = append(created, cbv)
+		}
+	}
+	if serr := cb.Save(); serr != nil {
+		rollback()
+		gerr := util.CastErr(serr)
+		gerr.SetStatus(http.StatusInternalServerError)
+		return nil, nil, nil, gerr
+	}
+	return cb, created, replaced, nil
+}
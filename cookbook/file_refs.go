@@ -0,0 +1,105 @@
+/* Which cookbook versions use a file in the filestore. */
+
+/*
+ * Copyright (c) 2013-2014, Jeremy Bingham (<jbingham@gmail.com>)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cookbook
+
+import (
+	"github.com/ctdk/goiardi/config"
+	"sort"
+)
+
+// Find every file in every cookbook version with the given checksum. Each
+// reference has the "cookbook", "version", "segment", and "path" of the file,
+// and they're sorted by cookbook name, then newest version first. An empty
+// list means nothing uses the file. With the in-memory data store, only the
+// versions the file index says use the file are looked at; with a database,
+// every cookbook version is, so it isn't cheap with a lot of cookbooks.
+func FileRefs(chksum string) []map[string]string {
+	var cookbooks []*Cookbook
+	var users map[string]map[string]bool
+	if config.Config.UseSQL {
+		cookbooks = AllCookbooks()
+	} else {
+		users = hashRefs.users(chksum)
+		cookbooks = make([]*Cookbook, 0, len(users))
+		for name := range users {
+			/* Skip any cookbook that's been deleted since. */
+			if cb, err := Get(name); err == nil {
+				cookbooks = append(cookbooks, cb)
+			}
+		}
+	}
+	sort.Sort(cookbooksByName(cookbooks))
+	refs := make([]map[string]string, 0)
+	for _, cb := range cookbooks {
+		for _, cbv := range cb.sortedVersions() {
+			if users != nil && !users[cb.Name][cbv.Version] {
+				continue
+			}
+			for _, seg := range fileSegments {
+				for _, f := range cbv.segment(seg) {
+					if h, _ := f["checksum"].(string); h != chksum {
+						continue
+					}
+					p, _ := f["path"].(string)
+					refs = append(refs, map[string]string{ "cookbook": cb.Name, "version": cbv.Version, "segment": seg, "path": p })
+				}
+			}
+		}
+	}
+	return refs
+}
+
+/* The files in one of a cookbook version's segments, by name. */
+func (cbv *CookbookVersion) segment(seg string) []map[string]interface{} {
+	switch seg {
+		case "recipes":
+			return cbv.Recipes
+		case "definitions":
+			return cbv.Definitions
+		case "libraries":
+			return cbv.Libraries
+		case "attributes":
+			return cbv.Attributes
+		case "files":
+			return cbv.Files
+		case "templates":
+			return cbv.Templates
+		case "resources":
+			return cbv.Resources
+		case "providers":
+			return cbv.Providers
+		case "root_files":
+			return cbv.RootFiles
+	}
+	return nil
+}
+
+type cookbooksByName []*Cookbook
+
+func (c cookbooksByName) Len() int {
+	return len(c)
+}
+
+func (c cookbooksByName) Swap(i, j int) {
+	c[i], c[j] = c[j], c[i]
+}
+
+func (c cookbooksByName) Less(i, j int) bool {
+	return c[i].Name < c[j].Name
+}
@@ -0,0 +1,222 @@
+/* Keeping track of which cookbook versions use which files. */
+
+/*
+ * Copyright (c) 2013-2014, Jeremy Bingham (<jbingham@gmail.com>)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cookbook
+
+import (
+	"github.com/ctdk/goiardi/config"
+	"strings"
+	"sync"
+)
+
+/* Which cookbook versions use each file checksum, so deleting a version only
+ * has to look at its own files to tell which of them nothing uses anymore, and
+ * FileRefs only has to look at the versions using a file, rather than going
+ * through every version of every cookbook. It's built from
+ * all the cookbooks at startup, after the data file is loaded, and kept up to
+ * date as versions are saved and deleted after that.
+ *
+ * It's only used with the in-memory data store. With a database, other
+ * goiardi processes may be uploading and deleting cookbooks too, and an index
+ * in this process's memory wouldn't know about it, so the database is checked
+ * every time instead.
+ *
+ * Versions are keyed by cookbook name and version, since in-memory versions
+ * don't have ids. Nothing here may be called while holding a cookbook's lock,
+ * since building the index takes them. */
+type hashRefIndex struct {
+	m sync.Mutex
+	built bool
+	refs map[string]map[string]bool
+	versions map[string][]string
+	cookbooks map[string]map[string]bool
+}
+
+var hashRefs = &hashRefIndex{}
+
+// Index which cookbook versions use which files. This should be done at
+// startup, after loading the data store from disk, so the index doesn't have
+// to be built when the first cookbook version is uploaded or deleted. It does
+// nothing when using a database.
+func IndexFileRefs() {
+	if config.Config.UseSQL {
+		return
+	}
+	hashRefs.m.Lock()
+	defer hashRefs.m.Unlock()
+	hashRefs.built = false
+	hashRefs.ensureBuilt()
+}
+
+func versionKey(cookbook_name string, version string) string {
+	return cookbook_name + "/" + version
+}
+
+func (f *hashRefIndex) ensureBuilt() {
+	if f.built {
+		return
+	}
+	f.refs = make(map[string]map[string]bool)
+	f.versions = make(map[string][]string)
+	f.cookbooks = make(map[string]map[string]bool)
+	for _, cb := range AllCookbooks() {
+		for _, cbv := range cb.sortedVersions() {
+			f.setLocked(cbv.CookbookName, cbv.Version, cbv.fileHashes())
+		}
+	}
+	f.built = true
+}
+
+func (f *hashRefIndex) setLocked(cookbook_name string, version string, hashes []string) {
+	key := versionKey(cookbook_name, version)
+	f.removeLocked(cookbook_name, key)
+	for _, h := range hashes {
+		if f.refs[h] == nil {
+			f.refs[h] = make(map[string]bool)
+		}
+		f.refs[h][key] = true
+	}
+	f.versions[key] = hashes
+	if f.cookbooks[cookbook_name] == nil {
+		f.cookbooks[cookbook_name] = make(map[string]bool)
+	}
+	f.cookbooks[cookbook_name][key] = true
+}
+
+func (f *hashRefIndex) removeLocked(cookbook_name string, key string) {
+	for _, h := range f.versions[key] {
+		delete(f.refs[h], key)
+		if len(f.refs[h]) == 0 {
+			delete(f.refs, h)
+		}
+	}
+	delete(f.versions, key)
+	if vers, ok := f.cookbooks[cookbook_name]; ok {
+		delete(vers, key)
+		if len(vers) == 0 {
+			delete(f.cookbooks, cookbook_name)
+		}
+	}
+}
+
+/* Record the files a cookbook version uses now, replacing what it used
+ * before. */
+func (f *hashRefIndex) set(cbv *CookbookVersion) {
+	if config.Config.UseSQL {
+		return
+	}
+	hashes := cbv.fileHashes()
+	f.m.Lock()
+	defer f.m.Unlock()
+	f.ensureBuilt()
+	f.setLocked(cbv.CookbookName, cbv.Version, hashes)
+}
+
+/* Forget a deleted cookbook version's files. */
+func (f *hashRefIndex) remove(cbv *CookbookVersion) {
+	if config.Config.UseSQL {
+		return
+	}
+	f.m.Lock()
+	defer f.m.Unlock()
+	f.ensureBuilt()
+	f.removeLocked(cbv.CookbookName, versionKey(cbv.CookbookName, cbv.Version))
+}
+
+/* Make the index match the given versions of a cookbook, forgetting any of
+ * its versions that aren't among them. */
+func (f *hashRefIndex) syncCookbook(cookbook_name string, versions []*CookbookVersion) {
+	if config.Config.UseSQL {
+		return
+	}
+	hashes := make([][]string, len(versions))
+	for i, cbv := range versions {
+		hashes[i] = cbv.fileHashes()
+	}
+	f.m.Lock()
+	defer f.m.Unlock()
+	f.ensureBuilt()
+	current := make(map[string]bool, len(versions))
+	for i, cbv := range versions {
+		f.setLocked(cookbook_name, cbv.Version, hashes[i])
+		current[versionKey(cookbook_name, cbv.Version)] = true
+	}
+	for key := range f.cookbooks[cookbook_name] {
+		if !current[key] {
+			f.removeLocked(cookbook_name, key)
+		}
+	}
+}
+
+/* Forget every version of a deleted cookbook. */
+func (f *hashRefIndex) removeCookbook(cookbook_name string) {
+	f.syncCookbook(cookbook_name, nil)
+}
+
+/* Returns the checksums from file_hashes that no cookbook version uses, going
+ * by the index. */
+func (f *hashRefIndex) unreferenced(file_hashes []string) []string {
+	f.m.Lock()
+	defer f.m.Unlock()
+	f.ensureBuilt()
+	unused := make([]string, 0, len(file_hashes))
+	for _, h := range file_hashes {
+		if len(f.refs[h]) == 0 {
+			unused = append(unused, h)
+		}
+	}
+	return unused
+}
+
+/* The cookbook versions that use the checksum, as a map of cookbook names to
+ * the versions of each that use it. */
+func (f *hashRefIndex) users(chksum string) map[string]map[string]bool {
+	f.m.Lock()
+	defer f.m.Unlock()
+	f.ensureBuilt()
+	users := make(map[string]map[string]bool)
+	for key := range f.refs[chksum] {
+		/* Cookbook names can't have a slash in them. */
+		nv := strings.SplitN(key, "/", 2)
+		if users[nv[0]] == nil {
+			users[nv[0]] = make(map[string]bool)
+		}
+		users[nv[0]][nv[1]] = true
+	}
+	return users
+}
+
+/* Returns the checksums from file_hashes that no cookbook version in the
+ * database uses. */
+func unreferencedSQL(file_hashes []string) []string {
+	in_use := make(map[string]bool)
+	for _, cb := range AllCookbooks() {
+		for _, cbv := range cb.sortedVersions() {
+			for _, h := range cbv.fileHashes() {
+				in_use[h] = true
+			}
+		}
+	}
+	unused := make([]string, 0, len(file_hashes))
+	for _, h := range file_hashes {
+		if !in_use[h] {
+			unused = append(unused, h)
+		}
+	}
+	return unused
+}
@@ -0,0 +1,110 @@
+/* Cookbooks resolved by the depsolver, in the order to install them. */
+
+/*
+ * Copyright (c) 2013-2014, Jeremy Bingham (<jbingham@gmail.com>)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cookbook
+
+import (
+	"github.com/ctdk/goiardi/util"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Resolve the cookbooks for a run list the same way DependsCookbooks does, and
+// return them as a list of maps with the cookbook's "name" and "version",
+// ordered so every cookbook comes after the cookbooks it depends on. Cookbooks
+// that don't depend on each other are ordered by name, so the same run list
+// always gives the same order. A dependency cycle is an error.
+func InstallOrder(run_list []string, env_constraints map[string]string) ([]map[string]string, util.Gerror) {
+	deps, err := DependsCookbooks(run_list, env_constraints)
+	if err != nil {
+		return nil, err
+	}
+
+	graph := make(map[string][]string, len(deps))
+	versions := make(map[string]string, len(deps))
+	for name, d := range deps {
+		cbv_json := d.(map[string]interface{})
+		versions[name] = "0.0.0"
+		if v, ok := cbv_json["version"].(string); ok {
+			versions[name] = v
+		}
+		metadata, _ := cbv_json["metadata"].(map[string]interface{})
+		md, _ := metadata["dependencies"].(map[string]interface{})
+		edges := make([]string, 0, len(md))
+		for dn := range md {
+			/* Anything not in the depsolver's results isn't
+			 * being installed anyway. */
+			if _, ok := deps[dn]; ok {
+				edges = append(edges, dn)
+			}
+		}
+		sort.Strings(edges)
+		graph[name] = edges
+	}
+
+	order, serr := topoSort(graph)
+	if serr != nil {
+		return nil, depsolveErr(serr)
+	}
+	install := make([]map[string]string, len(order))
+	for i, name := range order {
+		install[i] = map[string]string{ "name": name, "version": versions[name] }
+	}
+	return install, nil
+}
+
+/* Depth first topological sort, visiting cookbooks and their dependencies in
+ * name order. Each cookbook is added after everything it depends on. */
+func topoSort(graph map[string][]string) ([]string, error) {
+	names := make([]string, 0, len(graph))
+	for name := range graph {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	order := make([]string, 0, len(graph))
+	done := make(map[string]bool, len(graph))
+	var visit func(name string, path []string) error
+	visit = func(name string, path []string) error {
+		if done[name] {
+			return nil
+		}
+		for _, p := range path {
+			if p == name {
+				cpath := append(path[:len(path):len(path)], name)
+				return fmt.Errorf("circular dependency: %s", strings.Join(cpath, " -> "))
+			}
+		}
+		path = append(path[:len(path):len(path)], name)
+		for _, dep := range graph[name] {
+			if err := visit(dep, path); err != nil {
+				return err
+			}
+		}
+		done[name] = true
+		order = append(order, name)
+		return nil
+	}
+	for _, name := range names {
+		if err := visit(name, nil); err != nil {
+			return nil, err
+		}
+	}
+	return order, nil
+}
@@ -0,0 +1,121 @@
+/* Berkshelf lockfile style output from the depsolver. */
+
+/*
+ * Copyright (c) 2013-2014, Jeremy Bingham (<jbingham@gmail.com>)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cookbook
+
+import (
+	"github.com/ctdk/goiardi/util"
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Resolve the cookbooks for a run list the same way DependsCookbooks does, but
+// lay the results out like a Berkshelf lockfile. The returned map has these
+// keys:
+//
+// "dependencies": the cookbooks named in the run list, sorted, each with its
+// "name" and, if the run list pinned a version, its "constraint".
+//
+// "graph": a map of every resolved cookbook's name to its "version" (the
+// locked version) and "dependencies", a map of each dependency's name to the
+// "constraint" it was depended on with and the "version" it was locked to.
+//
+// "lockfile": the same information in the text format of a Berksfile.lock,
+// with DEPENDENCIES and GRAPH sections.
+func Lockfile(run_list []string, env_constraints map[string]string) (map[string]interface{}, util.Gerror) {
+	deps, err := DependsCookbooks(run_list, env_constraints)
+	if err != nil {
+		return nil, err
+	}
+
+	locked := make(map[string]string, len(deps))
+	for name, d := range deps {
+		locked[name] = "0.0.0"
+		if v, ok := d.(map[string]interface{})["version"].(string); ok {
+			locked[name] = v
+		}
+	}
+
+	top := make([]map[string]interface{}, 0, len(run_list))
+	seen := make(map[string]bool)
+	for _, item := range run_list {
+		cx := strings.SplitN(item, "@", 2)
+		name := strings.Split(cx[0], "::")[0]
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+		t := map[string]interface{}{ "name": name }
+		if len(cx) == 2 {
+			t["constraint"] = fmt.Sprintf("= %s", cx[1])
+		}
+		top = append(top, t)
+	}
+	sort.Sort(byName(top))
+
+	names := make([]string, 0, len(deps))
+	for name := range deps {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	graph := make(map[string]interface{}, len(deps))
+	var text bytes.Buffer
+	text.WriteString("DEPENDENCIES\n")
+	for _, t := range top {
+		if c, ok := t["constraint"]; ok {
+			fmt.Fprintf(&text, "  %s (%s)\n", t["name"], c)
+		} else {
+			fmt.Fprintf(&text, "  %s\n", t["name"])
+		}
+	}
+	text.WriteString("\nGRAPH\n")
+	for _, name := range names {
+		metadata, _ := deps[name].(map[string]interface{})["metadata"].(map[string]interface{})
+		md, _ := metadata["dependencies"].(map[string]interface{})
+		dep_names := make([]string, 0, len(md))
+		for dn := range md {
+			dep_names = append(dep_names, dn)
+		}
+		sort.Strings(dep_names)
+
+		edges := make(map[string]interface{}, len(md))
+		fmt.Fprintf(&text, "  %s (%s)\n", name, locked[name])
+		for _, dn := range dep_names {
+			c, _ := md[dn].(string)
+			edges[dn] = map[string]interface{}{ "constraint": c, "version": locked[dn] }
+			fmt.Fprintf(&text, "    %s (%s)\n", dn, c)
+		}
+		graph[name] = map[string]interface{}{ "version": locked[name], "dependencies": edges }
+	}
+
+	lockfile := map[string]interface{}{
+		"dependencies": top,
+		"graph": graph,
+		"lockfile": text.String(),
+	}
+	return lockfile, nil
+}
+
+type byName []map[string]interface{}
+
+func (b byName) Len() int { return len(b) }
+func (b byName) Swap(i, j int) { b[i], b[j] = b[j], b[i] }
+func (b byName) Less(i, j int) bool { return b[i]["name"].(string) < b[j]["name"].(string) }
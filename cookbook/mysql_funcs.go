@@ -26,19 +26,6 @@ import (
 	"sort"
 )
 
-func checkForCookbookMySQL(dbhandle data_store.Dbhandle, name string) (bool, error) {
-	_, err := data_store.CheckForOne(dbhandle, "cookbooks", name)
-	if err == nil {
-		return true, nil
-	} else {
-		if err != sql.ErrNoRows {
-			return false, err
-		} else {
-			return false, nil
-		}
-	}
-}
-
 func (c *Cookbook)numVersionsMySQL() *int {
 	var cbv_count int
 	stmt, err := data_store.Dbh.Prepare("SELECT count(*) AS c FROM cookbook_versions cbv WHERE cbv.cookbook_id = ?")
@@ -57,47 +44,9 @@ func (c *Cookbook)numVersionsMySQL() *int {
 	return &cbv_count
 }
 
-func (c *Cookbook) fillCookbookFromSQL(row data_store.ResRow) error {
-	err := row.Scan(&c.id, &c.Name)
-	if err != nil {
-		return err
-	}
-	return nil
-}
-
-func allCookbooksMySQL() []*Cookbook {
-	cookbooks := make([]*Cookbook, 0)
-	stmt, err := data_store.Dbh.Prepare("SELECT id, name FROM cookbooks")
-	if err != nil {
-		log.Fatal(err)
-	}
-	defer stmt.Close()
-	rows, qerr := stmt.Query()
-	if qerr != nil {
-		if qerr == sql.ErrNoRows {
-			return cookbooks
-		}
-		log.Fatal(qerr)
-	}
-	for rows.Next() {
-		cb := new(Cookbook)
-		err = cb.fillCookbookFromSQL(rows)
-		if err != nil {
-			log.Fatal(err)
-		}
-		cb.Versions = make(map[string]*CookbookVersion)
-		cookbooks = append(cookbooks, cb)
-	}
-	rows.Close()
-	if err = rows.Err(); err != nil {
-		log.Fatal(err)
-	}
-	return cookbooks
-}
-
 func getCookbookMySQL(name string) (*Cookbook, error) {
 	cookbook := new(Cookbook)
-	stmt, err := data_store.Dbh.Prepare("SELECT id, name FROM cookbooks WHERE name = ?")
+	stmt, err := data_store.Dbh.Prepare("SELECT id, name, aliases FROM cookbooks WHERE name = ?")
 	if err != nil {
 		return nil, err
 	}
@@ -114,13 +63,18 @@ func getCookbookMySQL(name string) (*Cookbook, error) {
 }
 
 func (c *Cookbook) saveCookbookMySQL() error {
+	aliases := c.AllAliases()
+	ab, aerr := data_store.EncodeBlob(&aliases)
+	if aerr != nil {
+		return aerr
+	}
 	tx, err := data_store.Dbh.Begin()
 	if err != nil {
 		return err
 	}
 	_, err = data_store.CheckForOne(tx, "cookbooks", c.Name)
 	if err == nil {
-		_, err = tx.Exec("UPDATE cookbooks SET name = ?, updated_at = NOW() WHERE id = ?", c.Name, c.id)
+		_, err = tx.Exec("UPDATE cookbooks SET name = ?, aliases = ?, updated_at = NOW() WHERE id = ?", c.Name, ab, c.id)
 		if err != nil {
 			tx.Rollback()
 			return err
@@ -130,7 +84,7 @@ func (c *Cookbook) saveCookbookMySQL() error {
 			tx.Rollback()
 			return err
 		}
-		res, rerr := tx.Exec("INSERT INTO cookbooks (name, created_at, updated_at) VALUES (?, NOW(), NOW())", c.Name)
+		res, rerr := tx.Exec("INSERT INTO cookbooks (name, aliases, created_at, updated_at) VALUES (?, ?, NOW(), NOW())", c.Name, ab)
 		if rerr != nil {
 			tx.Rollback()
 			return rerr
@@ -142,8 +96,7 @@ func (c *Cookbook) saveCookbookMySQL() error {
 			return err
 		}
 	}
-	tx.Commit()
-	return nil
+	return tx.Commit()
 }
 
 func (c *Cookbook) deleteCookbookMySQL() error {
@@ -183,40 +136,38 @@ func (c *Cookbook) deleteCookbookMySQL() error {
 		return err
 	}
 	tx.Commit()
+	hashRefs.removeCookbook(c.Name)
 	c.deleteHashes(fileHashes)
 
 	return nil
 }
 
-func getCookbookListMySQL() []string {
-	cb_list := make([]string, 0)
-	rows, err := data_store.Dbh.Query("SELECT name FROM cookbooks")
+/* Deletes several cookbooks and all their versions in one transaction. The
+ * caller cleans up their files afterwards. */
+func deleteCookbooksMySQL(cookbooks []*Cookbook) error {
+	tx, err := data_store.Dbh.Begin()
 	if err != nil {
-		if err != sql.ErrNoRows {
-			log.Fatal(err)
-		}
-		rows.Close()
-		return cb_list
+		return err
 	}
-	for rows.Next() {
-		var cb_name string
-		err = rows.Scan(&cb_name)
-		if err != nil {
-			rows.Close()
-			log.Fatal(err)
+	for _, c := range cookbooks {
+		_, err = tx.Exec("DELETE FROM cookbook_versions WHERE cookbook_id = ?", c.id)
+		if err == nil {
+			_, err = tx.Exec("DELETE FROM cookbooks WHERE id = ?", c.id)
+		}
+		if err != nil && err != sql.ErrNoRows {
+			terr := tx.Rollback()
+			if terr != nil {
+				err = fmt.Errorf("deleting cookbook %s had an error '%s', and then rolling back the transaction gave another error '%s'", c.Name, err.Error(), terr.Error())
+			}
+			return err
 		}
-		cb_list = append(cb_list, cb_name)
-	}
-	rows.Close()
-	if err = rows.Err(); err != nil {
-		log.Fatal(err)
 	}
-	return cb_list
+	return tx.Commit()
 }
 
 func (c *Cookbook) sortedCookbookVersionsMySQL() ([]*CookbookVersion) {
 	sorted := make([]*CookbookVersion, 0)
-	stmt, err := data_store.Dbh.Prepare("SELECT cv.id, cookbook_id, definitions, libraries, attributes, recipes, providers, resources, templates, root_files, files, metadata, major_ver, minor_ver, patch_ver, frozen, c.name FROM cookbook_versions cv LEFT JOIN cookbooks c ON cv.cookbook_id = c.id WHERE cookbook_id = ? ORDER BY major_ver DESC, minor_ver DESC, patch_ver DESC")
+	stmt, err := data_store.Dbh.Prepare("SELECT cv.id, cookbook_id, definitions, libraries, attributes, recipes, providers, resources, templates, root_files, files, metadata, major_ver, minor_ver, patch_ver, extra_ver, prerelease_ver, build_ver, frozen, c.name FROM cookbook_versions cv LEFT JOIN cookbooks c ON cv.cookbook_id = c.id WHERE cookbook_id = ? ORDER BY major_ver DESC, minor_ver DESC, patch_ver DESC")
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -246,93 +197,19 @@ func (c *Cookbook) sortedCookbookVersionsMySQL() ([]*CookbookVersion) {
 	return sorted
 }
 
-func (cbv *CookbookVersion)fillCookbookVersionFromSQL(row data_store.ResRow) error {
-	var (
-		defb []byte
-		libb []byte
-		attb []byte
-		recb []byte
-		prob []byte
-		resb []byte
-		temb []byte
-		roob []byte
-		filb []byte
-		metb []byte
-		major int64
-		minor int64
-		patch int64
-	)
-	err := row.Scan(&cbv.id, &cbv.cookbook_id, &defb, &libb, &attb, &recb, &prob, &resb, &temb, &roob, &filb, &metb, &major, &minor, &patch, &cbv.IsFrozen, &cbv.CookbookName)
-	if err != nil {
-		return err
-	}
-	/* Now... populate it. :-/ */
-	// These may need to accept x.y versions with only two elements
-	// instead of x.y.0 with the added default 0 patch number.
-	cbv.Version = fmt.Sprintf("%d.%d.%d", major, minor, patch)
-	cbv.Name = fmt.Sprintf("%s-%s", cbv.CookbookName, cbv.Version)
-	cbv.ChefType = "cookbook_version"
-	cbv.JsonClass = "Chef::CookbookVersion"
-
-	/* TODO: experiment some more with getting this done with
-	 * pointers. */
-	err = data_store.DecodeBlob(metb, &cbv.Metadata)
-	if err != nil {
-		return err
-	}
-	err = data_store.DecodeBlob(defb, &cbv.Definitions)
-	if err != nil {
-		return err
-	}
-	err = data_store.DecodeBlob(libb, &cbv.Libraries)
-	if err != nil {
-		return err
-	}
-	err = data_store.DecodeBlob(attb, &cbv.Attributes)
-	if err != nil {
-		return err
-	}
-	err = data_store.DecodeBlob(recb, &cbv.Recipes)
-	if err != nil {
-		return err
-	}
-	err = data_store.DecodeBlob(prob, &cbv.Providers)
-	if err != nil {
-		return err
-	}
-	err = data_store.DecodeBlob(temb, &cbv.Templates)
-	if err != nil {
-		return err
-	}
-	err = data_store.DecodeBlob(resb, &cbv.Resources)
-	if err != nil {
-		return err
-	}
-	err = data_store.DecodeBlob(roob, &cbv.RootFiles)
-	if err != nil {
-		return err
-	}
-	err = data_store.DecodeBlob(filb, &cbv.Files)
-	if err != nil {
-		return err
-	}
-	data_store.ChkNilArray(cbv)
-
-	return nil
-}
-
 func (c *Cookbook)getCookbookVersionMySQL(cbVersion string) (*CookbookVersion, error) {
 	cbv := new(CookbookVersion)
 	maj, min, patch, cverr := extractVerNums(cbVersion)
 	if cverr != nil {
 		return nil, cverr
 	}
-	stmt, err := data_store.Dbh.Prepare("SELECT cv.id, cookbook_id, definitions, libraries, attributes, recipes, providers, resources, templates, root_files, files, metadata, major_ver, minor_ver, patch_ver, frozen, c.name FROM cookbook_versions cv LEFT JOIN cookbooks c ON cv.cookbook_id = c.id WHERE cookbook_id = ? AND major_ver = ? AND minor_ver = ? AND patch_ver = ?")
+	extra, prerelease, build := extractVerExtra(cbVersion)
+	stmt, err := data_store.Dbh.Prepare("SELECT cv.id, cookbook_id, definitions, libraries, attributes, recipes, providers, resources, templates, root_files, files, metadata, major_ver, minor_ver, patch_ver, extra_ver, prerelease_ver, build_ver, frozen, c.name FROM cookbook_versions cv LEFT JOIN cookbooks c ON cv.cookbook_id = c.id WHERE cookbook_id = ? AND major_ver = ? AND minor_ver = ? AND patch_ver = ? AND extra_ver = ? AND prerelease_ver = ? AND build_ver = ?")
 	if err != nil {
 		return nil, err
 	}
 	defer stmt.Close()
-	row := stmt.QueryRow(c.id, maj, min, patch)
+	row := stmt.QueryRow(c.id, maj, min, patch, extra, prerelease, build)
 	err = cbv.fillCookbookVersionFromSQL(row)
 	if err != nil {
 		return nil, err
@@ -362,6 +239,16 @@ func (cbv *CookbookVersion)deleteCookbookVersionMySQL() util.Gerror {
 	return nil
 }
 
+func (cbv *CookbookVersion) setFrozenMySQL(frozen bool) util.Gerror {
+	_, err := data_store.Dbh.Exec("UPDATE cookbook_versions SET frozen = ?, updated_at = NOW() WHERE id = ?", frozen, cbv.id)
+	if err != nil {
+		gerr := util.Errorf(err.Error())
+		gerr.SetStatus(http.StatusInternalServerError)
+		return gerr
+	}
+	return nil
+}
+
 func (cbv *CookbookVersion) updateCookbookVersionMySQL() util.Gerror {
 	// Preparing the complex data structures to be saved 
 	defb, deferr := data_store.EncodeBlob(cbv.Definitions)
@@ -426,46 +313,51 @@ func (cbv *CookbookVersion) updateCookbookVersionMySQL() util.Gerror {
 	}
 	/* version already validated */
 	maj, min, patch, _ := extractVerNums(cbv.Version)
-	/* Gotta look for an existing version ourselves. */
-	tx, err := data_store.Dbh.Begin()
+	extra, prerelease, build := extractVerExtra(cbv.Version)
+	/* Gotta look for an existing version ourselves. The whole transaction
+	 * is retried if MySQL hits a deadlock or the like partway through. */
+	err := data_store.RetryMySQL(func() error {
+		tx, err := data_store.Dbh.Begin()
+		if err != nil {
+			return err
+		}
+		var cbv_id int32
+		err = tx.QueryRow("SELECT id FROM cookbook_versions WHERE cookbook_id = ? AND major_ver = ? AND minor_ver = ? AND patch_ver = ? AND extra_ver = ? AND prerelease_ver = ? AND build_ver = ?", cbv.cookbook_id, maj, min, patch, extra, prerelease, build).Scan(&cbv_id)
+		if err == nil {
+			_, err := tx.Exec("UPDATE cookbook_versions SET frozen = ?, metadata = ?, definitions = ?, libraries = ?, attributes = ?, recipes = ?, providers = ?, resources = ?, templates = ?, root_files = ?, files = ?, updated_at = NOW() WHERE id = ?", cbv.IsFrozen, metb, defb, libb, attb, recb, prob, resb, temb, roob, filb, cbv_id)
+			if err != nil {
+				tx.Rollback()
+				return err
+			}
+		} else {
+			if err != sql.ErrNoRows {
+				tx.Rollback()
+				return err
+			}
+			res, err := tx.Exec("INSERT INTO cookbook_versions (cookbook_id, major_ver, minor_ver, patch_ver, extra_ver, prerelease_ver, build_ver, frozen, metadata, definitions, libraries, attributes, recipes, providers, resources, templates, root_files, files, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, NOW(), NOW())", cbv.cookbook_id, maj, min, patch, extra, prerelease, build, cbv.IsFrozen, metb, defb, libb, attb, recb, prob, resb, temb, roob, filb)
+			if err != nil {
+				tx.Rollback()
+				return err
+			}
+			c_id, err := res.LastInsertId()
+			if err != nil {
+				tx.Rollback()
+				return err
+			}
+			cbv.id = int32(c_id)
+		}
+		return tx.Commit()
+	})
 	if err != nil {
 		gerr := util.Errorf(err.Error())
 		gerr.SetStatus(http.StatusInternalServerError)
 		return gerr
 	}
-	var cbv_id int32
-	err = tx.QueryRow("SELECT id FROM cookbook_versions WHERE cookbook_id = ? AND major_ver = ? AND minor_ver = ? AND patch_ver = ?", cbv.cookbook_id, maj, min, patch).Scan(&cbv_id)
-	if err == nil {
-		_, err := tx.Exec("UPDATE cookbook_versions SET frozen = ?, metadata = ?, definitions = ?, libraries = ?, attributes = ?, recipes = ?, providers = ?, resources = ?, templates = ?, root_files = ?, files = ?, updated_at = NOW() WHERE id = ?", cbv.IsFrozen, metb, defb, libb, attb, recb, prob, resb, temb, roob, filb, cbv_id)
-		if err != nil {
-			tx.Rollback()
-			gerr := util.Errorf(err.Error())
-			gerr.SetStatus(http.StatusInternalServerError)
-			return gerr
-		}
-	} else {
-		if err != sql.ErrNoRows {
-			tx.Rollback()
-			gerr := util.Errorf(err.Error())
-			gerr.SetStatus(http.StatusInternalServerError)
-			return gerr
-		}
-		res, err := tx.Exec("INSERT INTO cookbook_versions (cookbook_id, major_ver, minor_ver, patch_ver, frozen, metadata, definitions, libraries, attributes, recipes, providers, resources, templates, root_files, files, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, NOW(), NOW())", cbv.cookbook_id, maj, min, patch, cbv.IsFrozen, metb, defb, libb, attb, recb, prob, resb, temb, roob, filb)
-		if err != nil {
-			tx.Rollback()
-			gerr := util.Errorf(err.Error())
-			gerr.SetStatus(http.StatusInternalServerError)
-			return gerr
-		}
-		c_id, err := res.LastInsertId()
-		if err != nil {
-			tx.Rollback()
-			gerr := util.Errorf(err.Error())
-			gerr.SetStatus(http.StatusInternalServerError)
-			return gerr
-		}
-		cbv.id = int32(c_id)
-	}
-	tx.Commit()
 	return nil
 }
+
+/* Buckets are worked out from created_at the same way the in-memory growth
+ * does it from the version's creation time, lined up on the epoch. */
+func versionGrowthMySQL(secs int64) ([]*util.TimeBucket, error) {
+	return versionGrowthQuery("SELECT FLOOR(TIMESTAMPDIFF(SECOND, '1970-01-01 00:00:00', created_at) / ?) AS bucket, COUNT(*) FROM cookbook_versions GROUP BY bucket", secs)
+}
@@ -0,0 +1,315 @@
+/*
+ * Copyright (c) 2013-2014, Jeremy Bingham (<jbingham@gmail.com>)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cookbook
+
+import (
+	"github.com/ctdk/goiardi/data_store"
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"github.com/ctdk/goiardi/util"
+	"sort"
+)
+
+func (c *Cookbook)numVersionsPostgreSQL() *int {
+	var cbv_count int
+	stmt, err := data_store.Dbh.Prepare("SELECT count(*) AS c FROM cookbook_versions cbv WHERE cbv.cookbook_id = $1")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer stmt.Close()
+	err = stmt.QueryRow(c.id).Scan(&cbv_count)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			cbv_count = 0
+		} else {
+			log.Fatal(err)
+		}
+	}
+	return &cbv_count
+}
+
+func getCookbookPostgreSQL(name string) (*Cookbook, error) {
+	cookbook := new(Cookbook)
+	stmt, err := data_store.Dbh.Prepare("SELECT id, name, aliases FROM cookbooks WHERE name = $1")
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+	
+	row := stmt.QueryRow(name)
+	err = cookbook.fillCookbookFromSQL(row)
+	if err != nil {
+		return nil, err
+	}
+	cookbook.Versions = make(map[string]*CookbookVersion)
+
+	return cookbook, nil
+}
+
+func (c *Cookbook) saveCookbookPostgreSQL() error {
+	aliases := c.AllAliases()
+	ab, aerr := data_store.EncodeBlob(&aliases)
+	if aerr != nil {
+		return aerr
+	}
+	tx, err := data_store.Dbh.Begin()
+	if err != nil {
+		return err
+	}
+	err = tx.QueryRow("INSERT INTO cookbooks (name, aliases, created_at, updated_at) VALUES ($1, $2, NOW(), NOW()) ON CONFLICT (name) DO UPDATE SET aliases = EXCLUDED.aliases, updated_at = NOW() RETURNING id", c.Name, ab).Scan(&c.id)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func (c *Cookbook) deleteCookbookPostgreSQL() error {
+	tx, err := data_store.Dbh.Begin()
+	if err != nil {
+		return err
+	}
+	/* Delete the versions first. */
+	/* First delete the hashes. This is a relatively unlikely 
+	 * scenario, but it's best to make sure to reap any straggling
+	 * versions and file hashes. */
+	fileHashes := make([]string, 0)
+	for _, cbv := range c.sortedVersions() {
+		fileHashes = append(fileHashes, cbv.fileHashes()...)
+	}
+	sort.Strings(fileHashes)
+	fileHashes = removeDupHashes(fileHashes)
+
+	_, err = tx.Exec("DELETE FROM cookbook_versions WHERE cookbook_id = $1", c.id)
+	if err != nil && err != sql.ErrNoRows {
+		terr := tx.Rollback()
+		if terr != nil {
+			err = fmt.Errorf("deleting cookbook versions for %s had an error '%s', and then rolling back the transaction gave another error '%s'", c.Name, err.Error(), terr.Error())
+		}
+		return err
+	}
+	_, err = tx.Exec("DELETE FROM cookbooks WHERE id = $1", c.id)
+	if err != nil {
+		terr := tx.Rollback()
+		if terr != nil {
+			err = fmt.Errorf("deleting cookbook versions for %s had an error '%s', and then rolling back the transaction gave another error '%s'", c.Name, err.Error(), terr.Error())
+		}
+		return err
+	}
+	tx.Commit()
+	hashRefs.removeCookbook(c.Name)
+	c.deleteHashes(fileHashes)
+
+	return nil
+}
+
+/* Deletes several cookbooks and all their versions in one transaction. The
+ * caller cleans up their files afterwards. */
+func deleteCookbooksPostgreSQL(cookbooks []*Cookbook) error {
+	tx, err := data_store.Dbh.Begin()
+	if err != nil {
+		return err
+	}
+	for _, c := range cookbooks {
+		_, err = tx.Exec("DELETE FROM cookbook_versions WHERE cookbook_id = $1", c.id)
+		if err == nil {
+			_, err = tx.Exec("DELETE FROM cookbooks WHERE id = $1", c.id)
+		}
+		if err != nil && err != sql.ErrNoRows {
+			terr := tx.Rollback()
+			if terr != nil {
+				err = fmt.Errorf("deleting cookbook %s had an error '%s', and then rolling back the transaction gave another error '%s'", c.Name, err.Error(), terr.Error())
+			}
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (c *Cookbook) sortedCookbookVersionsPostgreSQL() ([]*CookbookVersion) {
+	sorted := make([]*CookbookVersion, 0)
+	stmt, err := data_store.Dbh.Prepare("SELECT cv.id, cookbook_id, definitions, libraries, attributes, recipes, providers, resources, templates, root_files, files, metadata, major_ver, minor_ver, patch_ver, extra_ver, prerelease_ver, build_ver, frozen, c.name FROM cookbook_versions cv LEFT JOIN cookbooks c ON cv.cookbook_id = c.id WHERE cookbook_id = $1 ORDER BY major_ver DESC, minor_ver DESC, patch_ver DESC")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer stmt.Close()
+	
+	rows, qerr := stmt.Query(c.id)
+	if qerr != nil {
+		if qerr == sql.ErrNoRows {
+			return sorted
+		}
+		log.Fatal(qerr)
+	}
+	for rows.Next() {
+		cbv := new(CookbookVersion)
+		err = cbv.fillCookbookVersionFromSQL(rows)
+		if err != nil {
+			log.Fatal(err)
+		}
+		// may as well populate this while we have it
+		c.Versions[cbv.Version] = cbv
+		sorted = append(sorted, cbv)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		log.Fatal(err)
+	}
+	return sorted
+}
+
+func (c *Cookbook)getCookbookVersionPostgreSQL(cbVersion string) (*CookbookVersion, error) {
+	cbv := new(CookbookVersion)
+	maj, min, patch, cverr := extractVerNums(cbVersion)
+	if cverr != nil {
+		return nil, cverr
+	}
+	extra, prerelease, build := extractVerExtra(cbVersion)
+	stmt, err := data_store.Dbh.Prepare("SELECT cv.id, cookbook_id, definitions, libraries, attributes, recipes, providers, resources, templates, root_files, files, metadata, major_ver, minor_ver, patch_ver, extra_ver, prerelease_ver, build_ver, frozen, c.name FROM cookbook_versions cv LEFT JOIN cookbooks c ON cv.cookbook_id = c.id WHERE cookbook_id = $1 AND major_ver = $2 AND minor_ver = $3 AND patch_ver = $4 AND extra_ver = $5 AND prerelease_ver = $6 AND build_ver = $7")
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+	row := stmt.QueryRow(c.id, maj, min, patch, extra, prerelease, build)
+	err = cbv.fillCookbookVersionFromSQL(row)
+	if err != nil {
+		return nil, err
+	} 
+
+	return cbv, nil
+}
+
+func (cbv *CookbookVersion)deleteCookbookVersionPostgreSQL() util.Gerror {
+	tx, err := data_store.Dbh.Begin()
+	if err != nil {
+		gerr := util.Errorf(err.Error())
+		gerr.SetStatus(http.StatusInternalServerError)
+		return gerr
+	}
+	_, err = tx.Exec("DELETE FROM cookbook_versions WHERE id = $1", cbv.id)
+	if err != nil {
+		terr := tx.Rollback()
+		if terr != nil {
+			err = fmt.Errorf("deleting cookbook %s version %s had an error '%s', and then rolling back the transaction gave another error '%s'", cbv.CookbookName, cbv.Version, err.Error(), terr.Error())
+		}
+		gerr := util.Errorf(err.Error())
+		gerr.SetStatus(http.StatusInternalServerError)
+		return gerr
+	}
+	tx.Commit()
+	return nil
+}
+
+func (cbv *CookbookVersion) setFrozenPostgreSQL(frozen bool) util.Gerror {
+	_, err := data_store.Dbh.Exec("UPDATE cookbook_versions SET frozen = $1, updated_at = NOW() WHERE id = $2", frozen, cbv.id)
+	if err != nil {
+		gerr := util.Errorf(err.Error())
+		gerr.SetStatus(http.StatusInternalServerError)
+		return gerr
+	}
+	return nil
+}
+
+func (cbv *CookbookVersion) updateCookbookVersionPostgreSQL() util.Gerror {
+	// Preparing the complex data structures to be saved 
+	defb, deferr := data_store.EncodeBlob(cbv.Definitions)
+	if deferr != nil {
+		gerr := util.Errorf(deferr.Error())
+		gerr.SetStatus(http.StatusInternalServerError)
+		return gerr
+	}
+	libb, liberr := data_store.EncodeBlob(cbv.Libraries)
+	if liberr != nil {
+		gerr := util.Errorf(liberr.Error())
+		gerr.SetStatus(http.StatusInternalServerError)
+		return gerr
+	}
+	attb, atterr := data_store.EncodeBlob(cbv.Attributes)
+	if atterr != nil {
+		gerr := util.Errorf(atterr.Error())
+		gerr.SetStatus(http.StatusInternalServerError)
+		return gerr
+	}
+	recb, recerr := data_store.EncodeBlob(cbv.Recipes)
+	if recerr != nil {
+		gerr := util.Errorf(recerr.Error())
+		gerr.SetStatus(http.StatusInternalServerError)
+		return gerr
+	}
+	prob, proerr := data_store.EncodeBlob(cbv.Providers)
+	if proerr != nil {
+		gerr := util.Errorf(proerr.Error())
+		gerr.SetStatus(http.StatusInternalServerError)
+		return gerr
+	}
+	resb, reserr := data_store.EncodeBlob(cbv.Resources)
+	if reserr != nil {
+		gerr := util.Errorf(reserr.Error())
+		gerr.SetStatus(http.StatusInternalServerError)
+		return gerr
+	}
+	temb, temerr := data_store.EncodeBlob(cbv.Templates)
+	if temerr != nil {
+		gerr := util.Errorf(temerr.Error())
+		gerr.SetStatus(http.StatusInternalServerError)
+		return gerr
+	}
+	roob, rooerr := data_store.EncodeBlob(cbv.RootFiles)
+	if rooerr != nil {
+		gerr := util.Errorf(rooerr.Error())
+		gerr.SetStatus(http.StatusInternalServerError)
+		return gerr
+	}
+	filb, filerr := data_store.EncodeBlob(cbv.Files)
+	if filerr != nil {
+		gerr := util.Errorf(filerr.Error())
+		gerr.SetStatus(http.StatusInternalServerError)
+		return gerr
+	}
+	metb, meterr := data_store.EncodeBlob(cbv.Metadata)
+	if meterr != nil {
+		gerr := util.Errorf(meterr.Error())
+		gerr.SetStatus(http.StatusInternalServerError)
+		return gerr
+	}
+	/* version already validated */
+	maj, min, patch, _ := extractVerNums(cbv.Version)
+	extra, prerelease, build := extractVerExtra(cbv.Version)
+	tx, err := data_store.Dbh.Begin()
+	if err == nil {
+		err = tx.QueryRow("INSERT INTO cookbook_versions (cookbook_id, major_ver, minor_ver, patch_ver, extra_ver, prerelease_ver, build_ver, frozen, metadata, definitions, libraries, attributes, recipes, providers, resources, templates, root_files, files, created_at, updated_at) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, NOW(), NOW()) ON CONFLICT (cookbook_id, major_ver, minor_ver, patch_ver, extra_ver, prerelease_ver, build_ver) DO UPDATE SET frozen = EXCLUDED.frozen, metadata = EXCLUDED.metadata, definitions = EXCLUDED.definitions, libraries = EXCLUDED.libraries, attributes = EXCLUDED.attributes, recipes = EXCLUDED.recipes, providers = EXCLUDED.providers, resources = EXCLUDED.resources, templates = EXCLUDED.templates, root_files = EXCLUDED.root_files, files = EXCLUDED.files, updated_at = NOW() RETURNING id", cbv.cookbook_id, maj, min, patch, extra, prerelease, build, cbv.IsFrozen, metb, defb, libb, attb, recb, prob, resb, temb, roob, filb).Scan(&cbv.id)
+		if err != nil {
+			tx.Rollback()
+		} else {
+			err = tx.Commit()
+		}
+	}
+	if err != nil {
+		gerr := util.Errorf(err.Error())
+		gerr.SetStatus(http.StatusInternalServerError)
+		return gerr
+	}
+	return nil
+}
+
+/* Buckets are worked out from created_at the same way the in-memory growth
+ * does it from the version's creation time, lined up on the epoch. */
+func versionGrowthPostgreSQL(secs int64) ([]*util.TimeBucket, error) {
+	return versionGrowthQuery("SELECT FLOOR(EXTRACT(EPOCH FROM created_at) / $1)::bigint AS bucket, COUNT(*) FROM cookbook_versions GROUP BY bucket", secs)
+}
@@ -0,0 +1,72 @@
+/* READMEs for cookbook versions. */
+
+/*
+ * Copyright (c) 2013-2014, Jeremy Bingham (<jbingham@gmail.com>)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cookbook
+
+import (
+	"github.com/ctdk/goiardi/filestore"
+	"github.com/ctdk/goiardi/util"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// Get the README for this cookbook version, along with its content type. A
+// README file among the cookbook's root files is preferred, fetched from the
+// filestore; otherwise the long_description from the metadata is used. If
+// there's neither, a 404 error is returned.
+func (cbv *CookbookVersion) Readme() (string, string, util.Gerror) {
+	for _, rf := range cbv.RootFiles {
+		name, _ := rf["name"].(string)
+		ctype := readmeType(name)
+		if ctype == "" {
+			continue
+		}
+		chksum, _ := rf["checksum"].(string)
+		fs, err := filestore.Get(chksum)
+		if err != nil {
+			gerr := util.Errorf("Could not get %s for %s version %s: %s", name, cbv.CookbookName, cbv.Version, err.Error())
+			gerr.SetStatus(http.StatusInternalServerError)
+			return "", "", gerr
+		}
+		return string(*fs.Data), ctype, nil
+	}
+	if ld, _ := cbv.Metadata["long_description"].(string); ld != "" {
+		/* knife fills this in from README.md, usually. */
+		return ld, "text/markdown", nil
+	}
+	gerr := util.Errorf("No README found for %s version %s", cbv.CookbookName, cbv.Version)
+	gerr.SetStatus(http.StatusNotFound)
+	return "", "", gerr
+}
+
+/* The content type for a root file that's a README, or "" if the file isn't
+ * one. */
+func readmeType(name string) string {
+	ext := path.Ext(name)
+	if !strings.EqualFold(strings.TrimSuffix(name, ext), "README") {
+		return ""
+	}
+	switch strings.ToLower(ext) {
+		case ".md", ".markdown":
+			return "text/markdown"
+		case "", ".txt", ".rdoc":
+			return "text/plain"
+	}
+	return ""
+}
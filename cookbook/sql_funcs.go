@@ -0,0 +1,341 @@
+/*
+ * Copyright (c) 2013-2014, Jeremy Bingham (<jbingham@gmail.com>)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cookbook
+
+import (
+	"github.com/ctdk/goiardi/config"
+	"github.com/ctdk/goiardi/data_store"
+	"github.com/ctdk/goiardi/util"
+	"database/sql"
+	"fmt"
+	"log"
+	"sort"
+)
+
+func checkForCookbookSQL(dbhandle data_store.Dbhandle, name string) (bool, error) {
+	_, err := data_store.CheckForOne(dbhandle, "cookbooks", name)
+	if err == nil {
+		return true, nil
+	} else {
+		if err != sql.ErrNoRows {
+			return false, err
+		} else {
+			return false, nil
+		}
+	}
+}
+
+func (c *Cookbook) fillCookbookFromSQL(row data_store.ResRow) error {
+	var ab []byte
+	err := row.Scan(&c.id, &c.Name, &ab)
+	if err != nil {
+		return err
+	}
+	/* Cookbooks saved before aliases existed have nothing here. */
+	if len(ab) != 0 {
+		if err = data_store.DecodeBlob(ab, &c.Aliases); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func allCookbooksSQL() []*Cookbook {
+	cookbooks := make([]*Cookbook, 0)
+	stmt, err := data_store.Dbh.Prepare("SELECT id, name, aliases FROM cookbooks")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer stmt.Close()
+	rows, qerr := stmt.Query()
+	if qerr != nil {
+		if qerr == sql.ErrNoRows {
+			return cookbooks
+		}
+		log.Fatal(qerr)
+	}
+	for rows.Next() {
+		cb := new(Cookbook)
+		err = cb.fillCookbookFromSQL(rows)
+		if err != nil {
+			log.Fatal(err)
+		}
+		cb.Versions = make(map[string]*CookbookVersion)
+		cookbooks = append(cookbooks, cb)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		log.Fatal(err)
+	}
+	return cookbooks
+}
+
+func getCookbookListSQL() []string {
+	cb_list := make([]string, 0)
+	rows, err := data_store.Dbh.Query("SELECT name FROM cookbooks")
+	if err != nil {
+		if err != sql.ErrNoRows {
+			log.Fatal(err)
+		}
+		rows.Close()
+		return cb_list
+	}
+	for rows.Next() {
+		var cb_name string
+		err = rows.Scan(&cb_name)
+		if err != nil {
+			rows.Close()
+			log.Fatal(err)
+		}
+		cb_list = append(cb_list, cb_name)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		log.Fatal(err)
+	}
+	return cb_list
+}
+
+func latestVersionsSQL() map[string]string {
+	latest := make(map[string]string)
+	/* Pre-releases can't be sorted properly in the database, so go
+	 * through all the versions here. */
+	rows, err := data_store.Dbh.Query("SELECT c.name, cv.major_ver, cv.minor_ver, cv.patch_ver, cv.extra_ver, cv.prerelease_ver, cv.build_ver FROM cookbooks c JOIN cookbook_versions cv ON cv.cookbook_id = c.id")
+	if err != nil {
+		if err != sql.ErrNoRows {
+			log.Fatal(err)
+		}
+		return latest
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name, extra, prerelease, build string
+		var major, minor, patch int64
+		if err = rows.Scan(&name, &major, &minor, &patch, &extra, &prerelease, &build); err != nil {
+			log.Fatal(err)
+		}
+		v := joinVersion(major, minor, patch, extra, prerelease, build)
+		if l, ok := latest[name]; !ok || versionLess(l, v) {
+			latest[name] = v
+		}
+	}
+	if err = rows.Err(); err != nil {
+		log.Fatal(err)
+	}
+	return latest
+}
+
+func versionGrowthSQL(secs int64) ([]*util.TimeBucket, error) {
+	if config.Config.UseMySQL {
+		return versionGrowthMySQL(secs)
+	}
+	return versionGrowthPostgreSQL(secs)
+}
+
+/* Run a query that returns bucket numbers and the count of versions in each,
+ * and turn them into the growth buckets. */
+func versionGrowthQuery(query string, secs int64) ([]*util.TimeBucket, error) {
+	counts := make(map[int64]int)
+	rows, err := data_store.Dbh.Query(query, secs)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return util.TimeBuckets(counts, secs), nil
+		}
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var bucket int64
+		var count int
+		if err = rows.Scan(&bucket, &count); err != nil {
+			return nil, err
+		}
+		counts[bucket] = count
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return util.TimeBuckets(counts, secs), nil
+}
+
+func (cbv *CookbookVersion)fillCookbookVersionFromSQL(row data_store.ResRow) error {
+	var (
+		defb []byte
+		libb []byte
+		attb []byte
+		recb []byte
+		prob []byte
+		resb []byte
+		temb []byte
+		roob []byte
+		filb []byte
+		metb []byte
+		major int64
+		minor int64
+		patch int64
+		extra string
+		prerelease string
+		build string
+	)
+	err := row.Scan(&cbv.id, &cbv.cookbook_id, &defb, &libb, &attb, &recb, &prob, &resb, &temb, &roob, &filb, &metb, &major, &minor, &patch, &extra, &prerelease, &build, &cbv.IsFrozen, &cbv.CookbookName)
+	if err != nil {
+		return err
+	}
+	/* Now... populate it. :-/ */
+	// These may need to accept x.y versions with only two elements
+	// instead of x.y.0 with the added default 0 patch number.
+	cbv.Version = joinVersion(major, minor, patch, extra, prerelease, build)
+	cbv.Name = fmt.Sprintf("%s-%s", cbv.CookbookName, cbv.Version)
+	cbv.ChefType = "cookbook_version"
+	cbv.JsonClass = "Chef::CookbookVersion"
+
+	/* TODO: experiment some more with getting this done with
+	 * pointers. */
+	err = data_store.DecodeBlob(metb, &cbv.Metadata)
+	if err != nil {
+		return err
+	}
+	err = data_store.DecodeBlob(defb, &cbv.Definitions)
+	if err != nil {
+		return err
+	}
+	err = data_store.DecodeBlob(libb, &cbv.Libraries)
+	if err != nil {
+		return err
+	}
+	err = data_store.DecodeBlob(attb, &cbv.Attributes)
+	if err != nil {
+		return err
+	}
+	err = data_store.DecodeBlob(recb, &cbv.Recipes)
+	if err != nil {
+		return err
+	}
+	err = data_store.DecodeBlob(prob, &cbv.Providers)
+	if err != nil {
+		return err
+	}
+	err = data_store.DecodeBlob(temb, &cbv.Templates)
+	if err != nil {
+		return err
+	}
+	err = data_store.DecodeBlob(resb, &cbv.Resources)
+	if err != nil {
+		return err
+	}
+	err = data_store.DecodeBlob(roob, &cbv.RootFiles)
+	if err != nil {
+		return err
+	}
+	err = data_store.DecodeBlob(filb, &cbv.Files)
+	if err != nil {
+		return err
+	}
+	data_store.ChkNilArray(cbv)
+
+	return nil
+}
+
+func (c *Cookbook)numVersionsSQL() *int {
+	if config.Config.UseMySQL {
+		return c.numVersionsMySQL()
+	}
+	return c.numVersionsPostgreSQL()
+}
+
+func getCookbookSQL(name string) (*Cookbook, error) {
+	if config.Config.UseMySQL {
+		return getCookbookMySQL(name)
+	}
+	return getCookbookPostgreSQL(name)
+}
+
+func (c *Cookbook) saveCookbookSQL() error {
+	if config.Config.UseMySQL {
+		return data_store.RetryMySQL(c.saveCookbookMySQL)
+	}
+	return c.saveCookbookPostgreSQL()
+}
+
+func (c *Cookbook) deleteCookbookSQL() error {
+	if config.Config.UseMySQL {
+		return c.deleteCookbookMySQL()
+	}
+	return c.deleteCookbookPostgreSQL()
+}
+
+func deleteCookbooksSQL(cookbooks []*Cookbook) error {
+	if config.Config.UseMySQL {
+		return deleteCookbooksMySQL(cookbooks)
+	}
+	return deleteCookbooksPostgreSQL(cookbooks)
+}
+
+func (c *Cookbook) sortedCookbookVersionsSQL() ([]*CookbookVersion) {
+	var sorted []*CookbookVersion
+	if config.Config.UseMySQL {
+		sorted = c.sortedCookbookVersionsMySQL()
+	} else {
+		sorted = c.sortedCookbookVersionsPostgreSQL()
+	}
+	/* The database only sorts by the version numbers, so pre-releases
+	 * need to be put in their places afterwards. */
+	sort.Stable(sort.Reverse(cbvByVersion(sorted)))
+	return sorted
+}
+
+type cbvByVersion []*CookbookVersion
+
+func (v cbvByVersion) Len() int {
+	return len(v)
+}
+
+func (v cbvByVersion) Swap(i, j int) {
+	v[i], v[j] = v[j], v[i]
+}
+
+func (v cbvByVersion) Less(i, j int) bool {
+	return versionLess(v[i].Version, v[j].Version)
+}
+
+func (c *Cookbook)getCookbookVersionSQL(cbVersion string) (*CookbookVersion, error) {
+	if config.Config.UseMySQL {
+		return c.getCookbookVersionMySQL(cbVersion)
+	}
+	return c.getCookbookVersionPostgreSQL(cbVersion)
+}
+
+func (cbv *CookbookVersion)deleteCookbookVersionSQL() util.Gerror {
+	if config.Config.UseMySQL {
+		return cbv.deleteCookbookVersionMySQL()
+	}
+	return cbv.deleteCookbookVersionPostgreSQL()
+}
+
+func (cbv *CookbookVersion) setFrozenSQL(frozen bool) util.Gerror {
+	if config.Config.UseMySQL {
+		return cbv.setFrozenMySQL(frozen)
+	}
+	return cbv.setFrozenPostgreSQL(frozen)
+}
+
+func (cbv *CookbookVersion) updateCookbookVersionSQL() util.Gerror {
+	if config.Config.UseMySQL {
+		return cbv.updateCookbookVersionMySQL()
+	}
+	return cbv.updateCookbookVersionPostgreSQL()
+}
@@ -0,0 +1,104 @@
+/* Reporting what an upload did with each of a cookbook version's files. */
+
+/*
+ * Copyright (c) 2013-2014, Jeremy Bingham (<jbingham@gmail.com>)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cookbook
+
+import (
+	"github.com/ctdk/goiardi/filestore"
+	"sort"
+)
+
+/* The parts of a cookbook version that hold files, in the order they're
+ * reported. */
+var fileSegments = []string{ "recipes", "definitions", "libraries", "attributes", "files", "templates", "resources", "providers", "root_files" }
+
+// For each file a cookbook version upload refers to, work out whether it was
+// "existing" (already used by another cookbook version, so the sandbox
+// wouldn't have asked for it), "uploaded" (in the filestore, but used by no
+// other version, so it was uploaded for this one), or "missing" from the
+// filestore entirely. This has to be called before the version is saved, or
+// every file will look like it already existed. Each entry has the file's
+// segment, name, path, checksum, and status.
+func UploadFileStatus(cbv_data map[string]interface{}) []map[string]interface{} {
+	in_use := make(map[string]bool)
+	for _, cb := range AllCookbooks() {
+		for _, cbv := range cb.sortedVersions() {
+			for _, h := range cbv.fileHashes() {
+				in_use[h] = true
+			}
+		}
+	}
+
+	statuses := make([]map[string]interface{}, 0)
+	for _, seg := range fileSegments {
+		files := segmentFiles(cbv_data[seg])
+		for _, f := range files {
+			chksum, _ := f["checksum"].(string)
+			var status string
+			if in_use[chksum] {
+				status = "existing"
+			} else if _, err := filestore.Get(chksum); err == nil {
+				status = "uploaded"
+			} else {
+				status = "missing"
+			}
+			statuses = append(statuses, map[string]interface{}{
+				"segment": seg,
+				"name": f["name"],
+				"path": f["path"],
+				"checksum": chksum,
+				"status": status,
+			})
+		}
+	}
+	return statuses
+}
+
+/* The files in a segment of uploaded cookbook version JSON, sorted by path so
+ * the report comes out the same every time. */
+func segmentFiles(seg interface{}) []map[string]interface{} {
+	var files []map[string]interface{}
+	switch seg := seg.(type) {
+		case []map[string]interface{}:
+			files = append(files, seg...)
+		case []interface{}:
+			for _, f := range seg {
+				if fm, ok := f.(map[string]interface{}); ok {
+					files = append(files, fm)
+				}
+			}
+	}
+	sort.Sort(filesByPath(files))
+	return files
+}
+
+type filesByPath []map[string]interface{}
+
+func (f filesByPath) Len() int {
+	return len(f)
+}
+
+func (f filesByPath) Swap(i, j int) {
+	f[i], f[j] = f[j], f[i]
+}
+
+func (f filesByPath) Less(i, j int) bool {
+	pi, _ := f[i]["path"].(string)
+	pj, _ := f[j]["path"].(string)
+	return pi < pj
+}
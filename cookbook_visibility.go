@@ -0,0 +1,66 @@
+/* Limiting which cookbooks clients can see */
+
+/*
+ * Copyright (c) 2013-2014, Jeremy Bingham (<jbingham@gmail.com>)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"github.com/ctdk/goiardi/actor"
+	"github.com/ctdk/goiardi/config"
+)
+
+/* Admins can always see every cookbook; everyone else is subject to the
+ * cookbook-visibility policy, if there is one. */
+func cookbookVisible(opUser actor.Actor, cookbook_name string) bool {
+	if opUser.IsAdmin() {
+		return true
+	}
+	return config.CookbookVisible(opUser.GetName(), cookbook_name)
+}
+
+/* Returns the first cookbook, by name, in a depsolver result or cookbook list
+ * that opUser isn't allowed to see, or the empty string if they can see them
+ * all. */
+func hiddenCookbook(opUser actor.Actor, cookbooks map[string]interface{}) string {
+	names := make([]string, 0, len(cookbooks))
+	for name := range cookbooks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if !cookbookVisible(opUser, name) {
+			return name
+		}
+	}
+	return ""
+}
+
+/* Drop the cookbooks opUser isn't allowed to see from a cookbook listing. */
+func filterCookbooks(opUser actor.Actor, cookbooks map[string]interface{}) {
+	for name := range cookbooks {
+		if !cookbookVisible(opUser, name) {
+			delete(cookbooks, name)
+		}
+	}
+}
+
+func cookbookForbidden(w http.ResponseWriter, r *http.Request, cookbook_name string) {
+	JsonErrorReport(w, r, fmt.Sprintf("You are not allowed to see the cookbook %s", cookbook_name), http.StatusForbidden)
+}
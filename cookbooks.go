@@ -21,12 +21,17 @@ package main
 import (
 	"net/http"
 	"encoding/json"
+	"github.com/ctdk/goiardi/config"
 	"github.com/ctdk/goiardi/cookbook"
+	"github.com/ctdk/goiardi/environment"
 	"github.com/ctdk/goiardi/util"
 	"fmt"
 	"sort"
+	"strconv"
 	"github.com/ctdk/goiardi/actor"
 	"github.com/ctdk/goiardi/log_info"
+	"path"
+	"time"
 )
 
 func cookbook_handler(w http.ResponseWriter, r *http.Request){
@@ -36,7 +41,7 @@ func cookbook_handler(w http.ResponseWriter, r *http.Request){
 
 	opUser, oerr := actor.GetReqUser(r.Header.Get("X-OPS-USERID"))
 	if oerr != nil {
-		JsonErrorReport(w, r, oerr.Error(), oerr.Status())
+		GerrorReport(w, r, oerr)
 		return
 	}
 
@@ -51,10 +56,27 @@ func cookbook_handler(w http.ResponseWriter, r *http.Request){
 		num_results = nrs[0]
 		err := util.ValidateNumVersions(num_results)
 		if err != nil {
-			JsonErrorReport(w, r, err.Error(), err.Status())
+			GerrorReport(w, r, err)
 			return
 		}
 	}
+	/* Only show frozen or unfrozen versions in the cookbook lists, if
+	 * asked. */
+	var frozen_filter *bool
+	if fz := r.FormValue("frozen"); fz != "" {
+		fzb, err := strconv.ParseBool(fz)
+		if err != nil {
+			JsonErrorReport(w, r, "invalid value for frozen", http.StatusBadRequest)
+			return
+		}
+		frozen_filter = &fzb
+	}
+	cbInfo := func(cb *cookbook.Cookbook, num_results string) map[string]interface{} {
+		if frozen_filter != nil {
+			return cb.FrozenInfoHash(num_results, *frozen_filter)
+		}
+		return cb.InfoHash(num_results)
+	}
 	force := ""
 	if f, fok := r.Form["force"]; fok {
 		if len(f) > 0 {
@@ -64,6 +86,122 @@ func cookbook_handler(w http.ResponseWriter, r *http.Request){
 	
 	path_array_len := len(path_array)
 
+	/* Batch fetch the recipe lists for several cookbook versions at once.
+	 * The one 2 length path that takes a POST, so it's handled before the
+	 * check below. */
+	if path_array_len == 2 && path_array[1] == "_recipe_lists" {
+		if r.Method != "POST" {
+			JsonErrorReport(w, r, "Unrecognized method", http.StatusMethodNotAllowed)
+			return
+		}
+		if opUser.IsValidator() {
+			JsonErrorReport(w, r, "You are not allowed to perform this action", http.StatusForbidden)
+			return
+		}
+		cb_versions, jerr := ParseObjJson(r.Body)
+		if jerr != nil {
+			JsonErrorReport(w, r, jerr.Error(), http.StatusBadRequest)
+			return
+		}
+		for cb_name, v := range cb_versions {
+			if !cookbookVisible(opUser, cb_name) {
+				cookbook_response[cb_name] = map[string]interface{}{ "error": []string{ fmt.Sprintf("You are not allowed to see the cookbook %s", cb_name) } }
+				continue
+			}
+			cb_version, ok := v.(string)
+			if !ok {
+				cookbook_response[cb_name] = map[string]interface{}{ "error": []string{ fmt.Sprintf("Invalid version for cookbook %s", cb_name) } }
+				continue
+			}
+			cookbook_response[cb_name] = recipeListFor(cb_name, cb_version)
+		}
+		enc := json.NewEncoder(w)
+		if err := enc.Encode(&cookbook_response); err != nil {
+			JsonErrorReport(w, r, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	/* Delete several whole cookbooks at once. */
+	if path_array_len == 2 && path_array[1] == "_bulk_delete" {
+		if !adminIPAllowed(w, r) {
+			return
+		}
+		cookbook_bulk_delete(w, r, opUser)
+		return
+	}
+
+	/* Load a cookbook bundle made with _export. */
+	if path_array_len == 2 && path_array[1] == "_import" {
+		if r.Method != "POST" {
+			JsonErrorReport(w, r, "Unrecognized method", http.StatusMethodNotAllowed)
+			return
+		}
+		if !adminIPAllowed(w, r) {
+			return
+		}
+		if !opUser.IsAdmin() {
+			JsonErrorReport(w, r, "You are not allowed to perform this action", http.StatusForbidden)
+			return
+		}
+		upload_sem, ok := acquireUploadSlot(w, r)
+		if !ok {
+			return
+		}
+		defer releaseUploadSlot(upload_sem)
+		/* Only admins get this far, so only their bundles are read
+		 * in full. */
+		if !importBodyLimit(w, r) {
+			return
+		}
+		bundle, jerr := ParseObjJson(r.Body)
+		if jerr != nil {
+			if bodyTooLarge(jerr) {
+				JsonErrorReport(w, r, "Cookbook bundle is larger than the server's max-import-size", http.StatusRequestEntityTooLarge)
+				return
+			}
+			JsonErrorReport(w, r, jerr.Error(), http.StatusBadRequest)
+			return
+		}
+		cb, created, replaced, ierr := cookbook.Import(bundle, force == "true")
+		if ierr != nil {
+			GerrorReport(w, r, ierr)
+			return
+		}
+		imported := make([]string, 0, len(created) + len(replaced))
+		for _, cbv := range created {
+			if lerr := log_info.LogEvent(opUser, cbv, "create"); lerr != nil {
+				JsonErrorReport(w, r, lerr.Error(), http.StatusInternalServerError)
+				return
+			}
+			imported = append(imported, cbv.Version)
+		}
+		for _, cbv := range replaced {
+			if lerr := log_info.LogEvent(opUser, cbv, "modify"); lerr != nil {
+				JsonErrorReport(w, r, lerr.Error(), http.StatusInternalServerError)
+				return
+			}
+			imported = append(imported, cbv.Version)
+		}
+		sort.Strings(imported)
+		cookbook_response["name"] = cb.Name
+		cookbook_response["uri"] = util.ObjURL(cb)
+		cookbook_response["versions"] = imported
+		w.WriteHeader(http.StatusCreated)
+		enc := json.NewEncoder(w)
+		if err := enc.Encode(&cookbook_response); err != nil {
+			JsonErrorReport(w, r, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	/* Cookbooks hidden from this client by cookbook-visibility are off
+	 * limits entirely. */
+	if path_array_len >= 2 && path_array[1] != "_latest" && path_array[1] != "_recipes" && path_array[1] != "_growth" && !cookbookVisible(opUser, path_array[1]) {
+		cookbookForbidden(w, r, path_array[1])
+		return
+	}
+
 	/* 1 and 2 length path arrays only support GET */
 	if path_array_len < 3 && r.Method != "GET" {
 		JsonErrorReport(w, r, "Bad request.", http.StatusMethodNotAllowed)
@@ -85,7 +223,10 @@ func cookbook_handler(w http.ResponseWriter, r *http.Request){
 	if path_array_len == 1 {
 		/* list all cookbooks */
 		for _, cb := range cookbook.AllCookbooks() {
-			cookbook_response[cb.Name] = cb.InfoHash(num_results)
+			if !cookbookVisible(opUser, cb.Name) {
+				continue
+			}
+			cookbook_response[cb.Name] = cbInfo(cb, num_results)
 		}
 	} else if path_array_len == 2 {
 		/* info about a cookbook and all its versions */
@@ -94,26 +235,39 @@ func cookbook_handler(w http.ResponseWriter, r *http.Request){
 		 * list of the latest versions of all the cookbooks, and _recipe
 		 * gets the recipes of the latest cookbooks. */
 		rlist := make([]string, 0)
-		if cookbook_name == "_latest" || cookbook_name == "_recipes" {
-			for _, cb := range cookbook.AllCookbooks() {
-				if cookbook_name == "_latest" {
-					cookbook_response[cb.Name] = util.CustomObjURL(cb, cb.LatestVersion().Version)
-				} else {
-					/* Damn it, this sends back an array of
-					 * all the recipes. Fill it in, and send
-					 * back the JSON ourselves. */
-					rlist_tmp, _ := cb.LatestVersion().RecipeList()
-					rlist = append(rlist, rlist_tmp...)
+		if cookbook_name == "_latest" {
+			/* Only the versions are needed for this, so don't
+			 * load every cookbook version to get them. */
+			for cb_name, cb_ver := range cookbook.LatestVersions() {
+				if !cookbookVisible(opUser, cb_name) {
+					continue
 				}
-				sort.Strings(rlist)
+				cookbook_response[cb_name] = util.CustomURL(fmt.Sprintf("/cookbooks/%s/%s", cb_name, cb_ver))
 			}
-			if cookbook_name == "_recipes" {
-				enc := json.NewEncoder(w)
-				if err := enc.Encode(&rlist); err != nil {
-					JsonErrorReport(w, r, err.Error(), http.StatusInternalServerError)
+		} else if cookbook_name == "_recipes" {
+			for _, cb := range cookbook.AllCookbooks() {
+				if !cookbookVisible(opUser, cb.Name) {
+					continue
 				}
-				return
+				/* Damn it, this sends back an array of
+				 * all the recipes. Fill it in, and send
+				 * back the JSON ourselves. */
+				cbv := cb.LatestVersion()
+				if cbv == nil {
+					continue
+				}
+				rlist_tmp, _ := cbv.RecipeList()
+				rlist = append(rlist, rlist_tmp...)
 			}
+			sort.Strings(rlist)
+			enc := json.NewEncoder(w)
+			if err := enc.Encode(&rlist); err != nil {
+				JsonErrorReport(w, r, err.Error(), http.StatusInternalServerError)
+			}
+			return
+		} else if cookbook_name == "_growth" {
+			cookbook_growth(w, r, opUser)
+			return
 		} else {
 			cb, err := cookbook.Get(cookbook_name)
 			if err != nil {
@@ -130,8 +284,160 @@ func cookbook_handler(w http.ResponseWriter, r *http.Request){
 			if num_results == "" {
 				num_results = "all"
 			}
-			cookbook_response[cookbook_name] = cb.InfoHash(num_results)
+			cookbook_response[cookbook_name] = cbInfo(cb, num_results)
+		}
+	} else if (path_array_len == 3 || path_array_len == 4) && path_array[2] == "_aliases" {
+		cookbook_aliases(w, r, path_array, opUser)
+		return
+	} else if path_array_len == 3 && path_array[2] == "_recipe_diff" {
+		/* Which recipes changed between two versions? */
+		if r.Method != "GET" {
+			JsonErrorReport(w, r, "Unrecognized method", http.StatusMethodNotAllowed)
+			return
 		}
+		if opUser.IsValidator() {
+			JsonErrorReport(w, r, "You are not allowed to perform this action", http.StatusForbidden)
+			return
+		}
+		from, to, err := versionPair(path_array[1], r.FormValue("from"), r.FormValue("to"))
+		if err != nil {
+			GerrorReport(w, r, err)
+			return
+		}
+		diff := from.RecipeDiff(to)
+		enc := json.NewEncoder(w)
+		if err := enc.Encode(&diff); err != nil {
+			JsonErrorReport(w, r, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	} else if path_array_len == 3 && path_array[2] == "_diff" {
+		/* What changed, files and metadata, between two
+		 * versions? */
+		if r.Method != "GET" {
+			JsonErrorReport(w, r, "Unrecognized method", http.StatusMethodNotAllowed)
+			return
+		}
+		if opUser.IsValidator() {
+			JsonErrorReport(w, r, "You are not allowed to perform this action", http.StatusForbidden)
+			return
+		}
+		from, to, err := versionPair(path_array[1], r.FormValue("from"), r.FormValue("to"))
+		if err != nil {
+			GerrorReport(w, r, err)
+			return
+		}
+		diff := from.Diff(to)
+		enc := json.NewEncoder(w)
+		if err := enc.Encode(&diff); err != nil {
+			JsonErrorReport(w, r, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	} else if path_array_len == 3 && path_array[2] == "_pinned_environments" {
+		/* Which environments are holding this cookbook back from its
+		 * latest version? */
+		if r.Method != "GET" {
+			JsonErrorReport(w, r, "Unrecognized method", http.StatusMethodNotAllowed)
+			return
+		}
+		if opUser.IsValidator() {
+			JsonErrorReport(w, r, "You are not allowed to perform this action", http.StatusForbidden)
+			return
+		}
+		cb, err := cookbook.Get(path_array[1])
+		if err != nil {
+			GerrorReport(w, r, err)
+			return
+		}
+		pinned := environment.PinnedBelowLatest(cb)
+		enc := json.NewEncoder(w)
+		if err := enc.Encode(&pinned); err != nil {
+			JsonErrorReport(w, r, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	} else if path_array_len == 3 && path_array[2] == "_export" {
+		/* Bundle up the whole cookbook, files and all, to be loaded
+		 * into another server with _import. */
+		if r.Method != "GET" {
+			JsonErrorReport(w, r, "Unrecognized method", http.StatusMethodNotAllowed)
+			return
+		}
+		if !adminIPAllowed(w, r) {
+			return
+		}
+		if opUser.IsValidator() {
+			JsonErrorReport(w, r, "You are not allowed to perform this action", http.StatusForbidden)
+			return
+		}
+		cb, err := cookbook.Get(path_array[1])
+		if err != nil {
+			GerrorReport(w, r, err)
+			return
+		}
+		bundle, err := cb.Export()
+		if err != nil {
+			GerrorReport(w, r, err)
+			return
+		}
+		enc := json.NewEncoder(w)
+		if err := enc.Encode(&bundle); err != nil {
+			JsonErrorReport(w, r, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	} else if path_array_len == 4 && path_array[3] == "_readme" {
+		if r.Method != "GET" {
+			JsonErrorReport(w, r, "Unrecognized method", http.StatusMethodNotAllowed)
+			return
+		}
+		if opUser.IsValidator() {
+			JsonErrorReport(w, r, "You are not allowed to perform this action", http.StatusForbidden)
+			return
+		}
+		cb, err := cookbook.Get(path_array[1])
+		if err != nil {
+			GerrorReport(w, r, err)
+			return
+		}
+		cbv, err := cb.GetVersion(path_array[2])
+		if err != nil {
+			JsonErrorReport(w, r, err.Error(), http.StatusNotFound)
+			return
+		}
+		readme, ctype, err := cbv.Readme()
+		if err != nil {
+			GerrorReport(w, r, err)
+			return
+		}
+		w.Header().Set("Content-Type", ctype + "; charset=utf-8")
+		w.Write([]byte(readme))
+		return
+	} else if path_array_len == 4 && path_array[3] == "_frozen" {
+		cookbook_frozen(w, r, path_array, opUser)
+		return
+	} else if path_array_len == 4 && path_array[3] == "_dependency_tree" {
+		if r.Method != "GET" {
+			JsonErrorReport(w, r, "Unrecognized method", http.StatusMethodNotAllowed)
+			return
+		}
+		if opUser.IsValidator() {
+			JsonErrorReport(w, r, "You are not allowed to perform this action", http.StatusForbidden)
+			return
+		}
+		cb, err := cookbook.Get(path_array[1])
+		if err != nil {
+			GerrorReport(w, r, err)
+			return
+		}
+		cbv, err := cb.GetVersion(path_array[2])
+		if err != nil {
+			JsonErrorReport(w, r, err.Error(), http.StatusNotFound)
+			return
+		}
+		tree := cbv.DependencyTree()
+		enc := json.NewEncoder(w)
+		if err := enc.Encode(&tree); err != nil {
+			JsonErrorReport(w, r, err.Error(), http.StatusInternalServerError)
+		}
+		return
 	} else if path_array_len == 3 {
 		/* get information about or manipulate a specific cookbook
 		 * version */
@@ -140,16 +446,16 @@ func cookbook_handler(w http.ResponseWriter, r *http.Request){
 		var vererr util.Gerror
 		opUser, oerr := actor.GetReqUser(r.Header.Get("X-OPS-USERID"))
 		if oerr != nil {
-			JsonErrorReport(w, r, oerr.Error(), oerr.Status())
+			GerrorReport(w, r, oerr)
 			return
 		}
-		if r.Method == "GET" && path_array[2] == "_latest" {  // might be other special vers
+		if r.Method == "GET" && (path_array[2] == "_latest" || cookbook.ValidAliasName(path_array[2])) {  // might be other special vers
 			cookbook_version = path_array[2]
 		} else {
 			cookbook_version, vererr = util.ValidateAsVersion(path_array[2]);
 			if vererr != nil {
 				vererr := util.Errorf("Invalid cookbook version '%s'.", path_array[2])
-				JsonErrorReport(w, r, vererr.Error(), vererr.Status())
+				GerrorReport(w, r, vererr)
 				return
 			}
 		}
@@ -165,7 +471,7 @@ func cookbook_handler(w http.ResponseWriter, r *http.Request){
 						msg := fmt.Sprintf("Cannot find a cookbook named %s with version %s", cookbook_name, cookbook_version)
 						JsonErrorReport(w, r, msg, err.Status())
 					} else {
-						JsonErrorReport(w, r, err.Error(), err.Status())
+						GerrorReport(w, r, err)
 					}
 					return
 				}
@@ -181,7 +487,7 @@ func cookbook_handler(w http.ResponseWriter, r *http.Request){
 					}
 					err := cb.DeleteVersion(cookbook_version)
 					if err != nil {
-						JsonErrorReport(w, r, err.Error(), err.Status())
+						GerrorReport(w, r, err)
 						return
 					}
 					if lerr := log_info.LogEvent(opUser, cb_ver, "delete"); lerr != nil {
@@ -202,6 +508,7 @@ func cookbook_handler(w http.ResponseWriter, r *http.Request){
 					 * cookbook with some but not all of
 					 * the fields. */
 					cookbook_response = cb_ver.ToJson(r.Method)
+					mirrorCookbookURLs(r, cookbook_response)
 					/* Sometimes, but not always, chef needs
 					 * empty slices of maps for these 
 					 * values. Arrrgh. */
@@ -228,11 +535,22 @@ func cookbook_handler(w http.ResponseWriter, r *http.Request){
 					JsonErrorReport(w, r, "You are not allowed to perform this action", http.StatusForbidden)
 					return
 				}
+				upload_sem, ok := acquireUploadSlot(w, r)
+				if !ok {
+					return
+				}
+				defer releaseUploadSlot(upload_sem)
 				cbv_data, jerr := ParseObjJson(r.Body)
 				if jerr != nil {
 					JsonErrorReport(w, r, jerr.Error(), http.StatusBadRequest)
 					return
 				}
+				/* With freeze-on-upload, the server decides
+				 * that the version's frozen, unless it's being
+				 * uploaded as a draft. */
+				if draft, _ := strconv.ParseBool(r.FormValue("draft")); config.Config.FreezeOnUpload && !draft {
+					cbv_data["frozen?"] = true
+				}
 				/* First, see if the cookbook already exists, &
 				 * if not create it. Second, see if this 
 				 * specific version of the cookbook exists. If
@@ -242,7 +560,7 @@ func cookbook_handler(w http.ResponseWriter, r *http.Request){
 				if err != nil {
 					cb, err = cookbook.New(cookbook_name)
 					if err != nil {
-						JsonErrorReport(w, r, err.Error(), err.Status())
+						GerrorReport(w, r, err)
 						return
 					}
 					/* save it so we get the id with mysql
@@ -258,6 +576,14 @@ func cookbook_handler(w http.ResponseWriter, r *http.Request){
 					}
 				}
 				cbv, err := cb.GetVersion(cookbook_version)
+
+				/* Report what happened with each file, if
+				 * asked. This has to be worked out before the
+				 * version's saved. */
+				var file_status []map[string]interface{}
+				if want_status, _ := strconv.ParseBool(r.FormValue("file_status")); want_status {
+					file_status = cookbook.UploadFileStatus(cbv_data)
+				}
 				
 				/* Does the cookbook_name in the URL and what's
 				 * in the body match? */
@@ -270,7 +596,7 @@ func cookbook_handler(w http.ResponseWriter, r *http.Request){
 						 * different version later. */
 						if t != cookbook_name && cbv == nil {
 							terr := util.Errorf("Field 'name' invalid")
-							JsonErrorReport(w, r, terr.Error(), terr.Status())
+							GerrorReport(w, r, terr)
 							return 
 						}
 					default:
@@ -291,7 +617,7 @@ func cookbook_handler(w http.ResponseWriter, r *http.Request){
 						if cb.NumVersions() == 0 {
 							cb.Delete()
 						}
-						JsonErrorReport(w, r, nerr.Error(), nerr.Status())
+						GerrorReport(w, r, nerr)
 						return
 					}
 					if lerr := log_info.LogEvent(opUser, cbv, "create"); lerr != nil {
@@ -302,7 +628,7 @@ func cookbook_handler(w http.ResponseWriter, r *http.Request){
 				} else {
 					err := cbv.UpdateVersion(cbv_data, force)
 					if err != nil {
-						JsonErrorReport(w, r, err.Error(), err.Status())
+						GerrorReport(w, r, err)
 						return
 					} else {
 						err := cb.Save()
@@ -321,10 +647,51 @@ func cookbook_handler(w http.ResponseWriter, r *http.Request){
 				 * wants some (not all) of the cookbook version
 				 * data. */
 				cookbook_response = cbv.ToJson(r.Method)
+				if undeclared := cbv.UndeclaredDeps(); len(undeclared) > 0 {
+					cookbook_response["undeclared_recipe_dependencies"] = undeclared
+				}
+				if file_status != nil {
+					cookbook_response["file_status"] = file_status
+				}
 			default:
 				JsonErrorReport(w, r, "Unrecognized method", http.StatusMethodNotAllowed)
 				return
 		}
+	} else if path_array_len == 4 && path_array[3] == "_metadata_links" {
+		/* Pull the provenance links out of the cookbook version's
+		 * metadata. Read only. */
+		if r.Method != "GET" {
+			JsonErrorReport(w, r, "Unrecognized method", http.StatusMethodNotAllowed)
+			return
+		}
+		if opUser.IsValidator() {
+			JsonErrorReport(w, r, "You are not allowed to perform this action", http.StatusForbidden)
+			return
+		}
+		cookbook_name := path_array[1]
+		cookbook_version := path_array[2]
+		if cookbook_version != "_latest" {
+			var vererr util.Gerror
+			cookbook_version, vererr = util.ValidateAsVersion(path_array[2])
+			if vererr != nil {
+				vererr := util.Errorf("Invalid cookbook version '%s'.", path_array[2])
+				GerrorReport(w, r, vererr)
+				return
+			}
+		}
+		cb, err := cookbook.Get(cookbook_name)
+		if err != nil {
+			GerrorReport(w, r, err)
+			return
+		}
+		cb_ver, err := cb.GetVersion(cookbook_version)
+		if err != nil {
+			GerrorReport(w, r, err)
+			return
+		}
+		for k, v := range cb_ver.MetadataLinks() {
+			cookbook_response[k] = v
+		}
 	} else {
 		/* Say what? Bad request. */
 		JsonErrorReport(w, r, "Bad request", http.StatusBadRequest)
@@ -336,3 +703,294 @@ func cookbook_handler(w http.ResponseWriter, r *http.Request){
 		JsonErrorReport(w, r, err.Error(), http.StatusInternalServerError)
 	}
 }
+
+/* Get the recipe list for one cookbook version for _recipe_lists. Errors are
+ * returned in the result rather than failing the whole batch. */
+func recipeListFor(cb_name string, cb_version string) map[string]interface{} {
+	cb, err := cookbook.Get(cb_name)
+	if err != nil {
+		return map[string]interface{}{ "error": []string{ err.Error() } }
+	}
+	if cb_version != "_latest" {
+		if _, verr := util.ValidateAsVersion(cb_version); verr != nil {
+			return map[string]interface{}{ "error": []string{ fmt.Sprintf("Invalid cookbook version '%s'.", cb_version) } }
+		}
+	}
+	cbv, err := cb.GetVersion(cb_version)
+	if err != nil {
+		return map[string]interface{}{ "error": []string{ err.Error() } }
+	}
+	if cbv == nil {
+		return map[string]interface{}{ "error": []string{ fmt.Sprintf("Cookbook %s has no versions", cb_name) } }
+	}
+	rlist, err := cbv.RecipeList()
+	if err != nil {
+		return map[string]interface{}{ "error": []string{ err.Error() } }
+	}
+	return map[string]interface{}{ "version": cbv.Version, "recipes": rlist }
+}
+
+/* Get the two versions of a cookbook being compared. Both have to be given,
+ * and a cookbook or version that doesn't exist is a 404. */
+func versionPair(cookbook_name string, from_ver string, to_ver string) (*cookbook.CookbookVersion, *cookbook.CookbookVersion, util.Gerror) {
+	if from_ver == "" || to_ver == "" {
+		err := util.Errorf("Both 'from' and 'to' versions must be given")
+		return nil, nil, err
+	}
+	cb, err := cookbook.Get(cookbook_name)
+	if err != nil {
+		return nil, nil, err
+	}
+	from, err := cb.GetVersion(from_ver)
+	if err != nil {
+		err.SetStatus(http.StatusNotFound)
+		return nil, nil, err
+	}
+	to, err := cb.GetVersion(to_ver)
+	if err != nil {
+		err.SetStatus(http.StatusNotFound)
+		return nil, nil, err
+	}
+	return from, to, nil
+}
+
+/* List a cookbook's version aliases, or look up, set, or remove one of them.
+ * Setting an alias takes a body like {"version": "1.2.3"}. */
+func cookbook_aliases(w http.ResponseWriter, r *http.Request, path_array []string, opUser actor.Actor) {
+	var alias string
+	if len(path_array) == 4 {
+		alias = path_array[3]
+	}
+	switch {
+		case r.Method == "GET":
+			if opUser.IsValidator() {
+				JsonErrorReport(w, r, "You are not allowed to perform this action", http.StatusForbidden)
+				return
+			}
+		case (r.Method == "PUT" || r.Method == "DELETE") && alias != "":
+			if !opUser.IsAdmin() {
+				JsonErrorReport(w, r, "You are not allowed to perform this action", http.StatusForbidden)
+				return
+			}
+		default:
+			JsonErrorReport(w, r, "Unrecognized method", http.StatusMethodNotAllowed)
+			return
+	}
+	cb, err := cookbook.Get(path_array[1])
+	if err != nil {
+		GerrorReport(w, r, err)
+		return
+	}
+
+	switch r.Method {
+		case "PUT":
+			alias_req, jerr := ParseObjJson(r.Body)
+			if jerr != nil {
+				JsonErrorReport(w, r, jerr.Error(), http.StatusBadRequest)
+				return
+			}
+			cb_version, ok := alias_req["version"].(string)
+			if !ok {
+				JsonErrorReport(w, r, "Field 'version' missing or invalid", http.StatusBadRequest)
+				return
+			}
+			if aerr := cb.SetAlias(alias, cb_version); aerr != nil {
+				GerrorReport(w, r, aerr)
+				return
+			}
+			if lerr := log_info.LogEvent(opUser, cb, "modify"); lerr != nil {
+				JsonErrorReport(w, r, lerr.Error(), http.StatusInternalServerError)
+				return
+			}
+		case "DELETE":
+			if aerr := cb.DeleteAlias(alias); aerr != nil {
+				GerrorReport(w, r, aerr)
+				return
+			}
+			if lerr := log_info.LogEvent(opUser, cb, "modify"); lerr != nil {
+				JsonErrorReport(w, r, lerr.Error(), http.StatusInternalServerError)
+				return
+			}
+			alias_response := map[string]string{ "alias": alias }
+			enc := json.NewEncoder(w)
+			if err := enc.Encode(&alias_response); err != nil {
+				JsonErrorReport(w, r, err.Error(), http.StatusInternalServerError)
+			}
+			return
+	}
+
+	if alias == "" {
+		aliases := cb.AllAliases()
+		enc := json.NewEncoder(w)
+		if err := enc.Encode(&aliases); err != nil {
+			JsonErrorReport(w, r, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+	cbv, err := cb.GetVersion(alias)
+	if err != nil {
+		GerrorReport(w, r, err)
+		return
+	}
+	alias_response := map[string]string{ "alias": alias, "version": cbv.Version, "url": util.CustomObjURL(cb, cbv.Version) }
+	enc := json.NewEncoder(w)
+	if err := enc.Encode(&alias_response); err != nil {
+		JsonErrorReport(w, r, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+/* Freeze or unfreeze a cookbook version without uploading it again. Takes a
+ * body like {"frozen": true}. Anyone but the validator can freeze a version,
+ * but only admins can unfreeze one. */
+func cookbook_frozen(w http.ResponseWriter, r *http.Request, path_array []string, opUser actor.Actor) {
+	if r.Method != "PUT" && r.Method != "PATCH" {
+		JsonErrorReport(w, r, "Unrecognized method", http.StatusMethodNotAllowed)
+		return
+	}
+	if opUser.IsValidator() {
+		JsonErrorReport(w, r, "You are not allowed to perform this action", http.StatusForbidden)
+		return
+	}
+	frozen_req, jerr := ParseObjJson(r.Body)
+	if jerr != nil {
+		JsonErrorReport(w, r, jerr.Error(), http.StatusBadRequest)
+		return
+	}
+	frozen, ok := frozen_req["frozen"].(bool)
+	if !ok {
+		JsonErrorReport(w, r, "Field 'frozen' missing or invalid", http.StatusBadRequest)
+		return
+	}
+	if !frozen && !opUser.IsAdmin() {
+		JsonErrorReport(w, r, "You must be an admin to unfreeze a cookbook version", http.StatusForbidden)
+		return
+	}
+	cb, err := cookbook.Get(path_array[1])
+	if err != nil {
+		GerrorReport(w, r, err)
+		return
+	}
+	cbv, err := cb.GetVersion(path_array[2])
+	if err != nil {
+		JsonErrorReport(w, r, err.Error(), http.StatusNotFound)
+		return
+	}
+	if ferr := cbv.SetFrozen(frozen); ferr != nil {
+		GerrorReport(w, r, ferr)
+		return
+	}
+	if lerr := log_info.LogEvent(opUser, cbv, "modify"); lerr != nil {
+		JsonErrorReport(w, r, lerr.Error(), http.StatusInternalServerError)
+		return
+	}
+	frozen_response := map[string]interface{}{ "cookbook": cb.Name, "version": cbv.Version, "frozen": cbv.IsFrozen }
+	enc := json.NewEncoder(w)
+	if err := enc.Encode(&frozen_response); err != nil {
+		JsonErrorReport(w, r, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+/* Count the cookbook versions created in each time bucket, to see how fast
+ * they're accumulating. Takes an "interval" duration like "1d" or "12h"
+ * (default 1d). */
+func cookbook_growth(w http.ResponseWriter, r *http.Request, opUser actor.Actor) {
+	if !opUser.IsAdmin() {
+		JsonErrorReport(w, r, "You must be an admin to do that", http.StatusForbidden)
+		return
+	}
+	interval := 24 * time.Hour
+	if i := r.FormValue("interval"); i != "" {
+		var err error
+		interval, err = util.ParseDuration(i)
+		if err != nil {
+			JsonErrorReport(w, r, "invalid interval", http.StatusBadRequest)
+			return
+		}
+	}
+	growth, err := cookbook.VersionGrowth(interval)
+	if err != nil {
+		JsonErrorReport(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+	enc := json.NewEncoder(w)
+	if err := enc.Encode(&growth); err != nil {
+		JsonErrorReport(w, r, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+/* Delete every version of several cookbooks in one go, like for cleaning up
+ * after testing. The body has a "cookbooks" list of names, a "pattern" like
+ * "test-*" matching cookbook names, or both. Sends back which cookbooks were
+ * deleted and which of the named ones weren't found. */
+func cookbook_bulk_delete(w http.ResponseWriter, r *http.Request, opUser actor.Actor) {
+	if r.Method != "POST" {
+		JsonErrorReport(w, r, "Unrecognized method", http.StatusMethodNotAllowed)
+		return
+	}
+	if !opUser.IsAdmin() {
+		JsonErrorReport(w, r, "You are not allowed to perform this action", http.StatusForbidden)
+		return
+	}
+	var req struct {
+		Cookbooks []string `json:"cookbooks"`
+		Pattern string `json:"pattern"`
+	}
+	dec := json.NewDecoder(r.Body)
+	if err := dec.Decode(&req); err != nil {
+		JsonErrorReport(w, r, "cookbooks must be a list of cookbook names, and pattern a string", http.StatusBadRequest)
+		return
+	}
+	names := req.Cookbooks
+	if req.Pattern != "" {
+		if _, merr := path.Match(req.Pattern, ""); merr != nil {
+			JsonErrorReport(w, r, "invalid pattern", http.StatusBadRequest)
+			return
+		}
+		for _, cb_name := range cookbook.GetList() {
+			if m, _ := path.Match(req.Pattern, cb_name); m {
+				names = append(names, cb_name)
+			}
+		}
+	}
+	if len(names) == 0 {
+		JsonErrorReport(w, r, "No cookbooks given to delete", http.StatusBadRequest)
+		return
+	}
+
+	/* Hold on to the cookbooks for logging their deletion. */
+	cookbooks := make(map[string]*cookbook.Cookbook)
+	for _, cb_name := range names {
+		if cb, err := cookbook.Get(cb_name); err == nil {
+			cookbooks[cb_name] = cb
+		}
+	}
+	deleted, derr := cookbook.DeleteCookbooks(names)
+	if derr != nil {
+		GerrorReport(w, r, derr)
+		return
+	}
+	was_deleted := make(map[string]bool)
+	for _, cb_name := range deleted {
+		was_deleted[cb_name] = true
+		if cb, ok := cookbooks[cb_name]; ok {
+			if lerr := log_info.LogEvent(opUser, cb, "delete"); lerr != nil {
+				JsonErrorReport(w, r, lerr.Error(), http.StatusInternalServerError)
+				return
+			}
+		}
+	}
+	not_found := make([]string, 0)
+	for _, cb_name := range names {
+		if !was_deleted[cb_name] {
+			not_found = append(not_found, cb_name)
+			was_deleted[cb_name] = true
+		}
+	}
+	sort.Strings(deleted)
+	sort.Strings(not_found)
+	del_response := map[string]interface{}{ "deleted": deleted, "not_found": not_found }
+	enc := json.NewEncoder(w)
+	if err := enc.Encode(&del_response); err != nil {
+		JsonErrorReport(w, r, err.Error(), http.StatusInternalServerError)
+	}
+}
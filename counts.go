@@ -0,0 +1,112 @@
+/*
+ * Copyright (c) 2013-2014, Jeremy Bingham (<jbingham@gmail.com>)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"github.com/ctdk/goiardi/actor"
+	"github.com/ctdk/goiardi/config"
+	"github.com/ctdk/goiardi/cookbook"
+	"github.com/ctdk/goiardi/data_bag"
+	"github.com/ctdk/goiardi/data_store"
+	"github.com/ctdk/goiardi/log_info"
+)
+
+/* The tables counted for each type of object when using a SQL database. */
+var countTables = map[string]string{
+	"cookbooks": "cookbooks",
+	"cookbook_versions": "cookbook_versions",
+	"nodes": "nodes",
+	"roles": "roles",
+	"environments": "environments",
+	"clients": "clients",
+	"users": "users",
+	"data_bags": "data_bags",
+	"data_bag_items": "data_bag_items",
+	"events": "log_infos",
+}
+
+func counts_handler(w http.ResponseWriter, r *http.Request){
+	w.Header().Set("Content-Type", "application/json")
+	opUser, oerr := actor.GetReqUser(r.Header.Get("X-OPS-USERID"))
+	if oerr != nil {
+		GerrorReport(w, r, oerr)
+		return
+	}
+	if r.Method != "GET" {
+		JsonErrorReport(w, r, "Unrecognized method", http.StatusMethodNotAllowed)
+		return
+	}
+	if !opUser.IsAdmin() {
+		JsonErrorReport(w, r, "You are not allowed to perform that action.", http.StatusForbidden)
+		return
+	}
+	var counts map[string]int
+	if config.Config.UseSQL {
+		var err error
+		counts, err = objectCountsSQL()
+		if err != nil {
+			JsonErrorReport(w, r, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	} else {
+		counts = objectCounts()
+	}
+	/* Not an object, but it's a count operators will want to keep an eye
+	 * on. */
+	counts["failed_event_writes"] = int(log_info.FailedWrites())
+	enc := json.NewEncoder(w)
+	if err := enc.Encode(&counts); err != nil {
+		JsonErrorReport(w, r, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+/* Count up the objects in the in-memory data store. Nothing's looked through
+ * to do this: top level objects are counted by the data store's lists of them,
+ * and cookbook versions and data bag items have running counts of their own. */
+func objectCounts() map[string]int {
+	ds := data_store.New()
+	counts := make(map[string]int)
+	counts["cookbooks"] = ds.Count("cookbook")
+	counts["cookbook_versions"] = cookbook.TotalVersions()
+	counts["nodes"] = ds.Count("node")
+	counts["roles"] = ds.Count("role")
+	counts["environments"] = ds.Count("env")
+	/* The default environment isn't always in the data store. */
+	if _, found := ds.Get("env", "_default"); !found {
+		counts["environments"]++
+	}
+	counts["clients"] = ds.Count("client")
+	counts["users"] = ds.Count("user")
+	counts["data_bags"] = ds.Count("data_bag")
+	counts["data_bag_items"] = data_bag.TotalItems()
+	counts["events"] = ds.NumLogInfos()
+	return counts
+}
+
+func objectCountsSQL() (map[string]int, error) {
+	counts := make(map[string]int)
+	for k, table := range countTables {
+		c, err := data_store.Count(data_store.Dbh, table)
+		if err != nil {
+			return nil, err
+		}
+		counts[k] = c
+	}
+	return counts, nil
+}
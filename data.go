@@ -24,6 +24,7 @@ import (
 	"fmt"
 	"github.com/ctdk/goiardi/data_bag"
 	"github.com/ctdk/goiardi/util"
+	"github.com/ctdk/goiardi/config"
 	"github.com/ctdk/goiardi/actor"
 	"github.com/ctdk/goiardi/log_info"
 )
@@ -36,7 +37,7 @@ func data_handler(w http.ResponseWriter, r *http.Request){
 	db_response := make(map[string]interface{})
 	opUser, oerr := actor.GetReqUser(r.Header.Get("X-OPS-USERID"))
 	if oerr != nil {
-		JsonErrorReport(w, r, oerr.Error(), oerr.Status())
+		GerrorReport(w, r, oerr)
 		return
 	}
 
@@ -83,7 +84,7 @@ func data_handler(w http.ResponseWriter, r *http.Request){
 				}
 				chef_dbag, nerr := data_bag.New(db_data["name"].(string))
 				if nerr != nil {
-					JsonErrorReport(w, r, nerr.Error(), nerr.Status())
+					GerrorReport(w, r, nerr)
 					return
 				}
 				serr := chef_dbag.Save()
@@ -169,7 +170,7 @@ func data_handler(w http.ResponseWriter, r *http.Request){
 					raw_data := data_bag.RawDataBagJson(r.Body)
 					dbitem, nerr := chef_dbag.NewDBItem(raw_data)
 					if nerr != nil {
-						JsonErrorReport(w, r, nerr.Error(), nerr.Status())
+						GerrorReport(w, r, nerr)
 						return
 					}
 					if lerr := log_info.LogEvent(opUser, dbitem, "create"); lerr != nil {
@@ -199,18 +200,39 @@ func data_handler(w http.ResponseWriter, r *http.Request){
 		} else {
 			/* getting, editing, and deleting existing data bag items. */
 			db_item_name := path_array[2]
+			created := false
 			if _, err := chef_dbag.GetDBItem(db_item_name); err != nil {
-				var httperr string
-				if r.Method != "DELETE" {
-					httperr = fmt.Sprintf("Cannot load data bag item %s for data bag %s", db_item_name, chef_dbag.Name)
+				/* With put-creates, PUTting an item that
+				 * doesn't exist creates it, like POSTing it to
+				 * the data bag would. */
+				if config.Config.PutCreates && r.Method == "PUT" {
+					created = true
 				} else {
-					httperr = fmt.Sprintf("Cannot load data bag %s item %s", chef_dbag.Name, db_item_name)
+					var httperr string
+					if r.Method != "DELETE" {
+						httperr = fmt.Sprintf("Cannot load data bag item %s for data bag %s", db_item_name, chef_dbag.Name)
+					} else {
+						httperr = fmt.Sprintf("Cannot load data bag %s item %s", chef_dbag.Name, db_item_name)
+					}
+					JsonErrorReport(w, r, httperr, http.StatusNotFound)
+					return
 				}
-				JsonErrorReport(w, r, httperr, http.StatusNotFound)
-				return
 			}
 			switch r.Method {
 				case "GET":
+					if env_name := r.FormValue("env"); env_name != "" {
+						if !util.ValidateEnvName(env_name) {
+							JsonErrorReport(w, r, "Invalid environment name", http.StatusBadRequest)
+							return
+						}
+						merged, err := chef_dbag.GetDBItemForEnv(db_item_name, env_name)
+						if err != nil {
+							JsonErrorReport(w, r, err.Error(), http.StatusInternalServerError)
+							return
+						}
+						db_response = merged
+						break
+					}
 					dbi, err := chef_dbag.GetDBItem(db_item_name)
 					if err != nil {
 						JsonErrorReport(w, r, err.Error(), http.StatusInternalServerError)
@@ -241,6 +263,12 @@ func data_handler(w http.ResponseWriter, r *http.Request){
 					return
 				case "PUT":
 					raw_data := data_bag.RawDataBagJson(r.Body)
+					/* A body of "null" decodes to a nil
+					 * map. */
+					if raw_data == nil {
+						JsonErrorReport(w, r, "Invalid data bag item", http.StatusBadRequest)
+						return
+					}
 					if raw_id, ok := raw_data["id"]; ok {
 						switch raw_id := raw_id.(type) {
 							case string:
@@ -253,12 +281,26 @@ func data_handler(w http.ResponseWriter, r *http.Request){
 								return
 						}
 					}
-					dbitem, err := chef_dbag.UpdateDBItem(db_item_name, raw_data)
+					var dbitem *data_bag.DataBagItem
+					var err error
+					if created {
+						raw_data["id"] = db_item_name
+						dbitem, err = chef_dbag.NewDBItem(raw_data)
+					} else {
+						dbitem, err = chef_dbag.UpdateDBItem(db_item_name, raw_data)
+					}
 					if err != nil {
+						/* Items that are too big come
+						 * back as a Gerror with a
+						 * 413. */
+						if gerr, ok := err.(util.Gerror); ok {
+							GerrorReport(w, r, gerr)
+							return
+						}
 						JsonErrorReport(w, r, err.Error(), http.StatusInternalServerError)
 						return
 					}
-					if lerr := log_info.LogEvent(opUser, dbitem, "modify"); lerr != nil {
+					if lerr := log_info.LogEvent(opUser, dbitem, putAction(created)); lerr != nil {
 						JsonErrorReport(w, r, lerr.Error(), http.StatusInternalServerError)
 						return
 					}
@@ -270,6 +312,9 @@ func data_handler(w http.ResponseWriter, r *http.Request){
 					db_response["data_bag"] = dbitem.DataBagName
 					db_response["chef_type"] = dbitem.ChefType
 					db_response["id"] = db_item_name
+					if created {
+						w.WriteHeader(http.StatusCreated)
+					}
 				default:
 					JsonErrorReport(w, r, "GET, DELETE, PUT", http.StatusMethodNotAllowed)
 					return
@@ -63,9 +63,9 @@ func New(name string) (*DataBag, util.Gerror){
 		return nil, err
 	}
 
-	if config.Config.UseMySQL {
+	if config.Config.UseSQL {
 		var cerr error
-		found, cerr = checkForDataBagMySQL(data_store.Dbh, name)
+		found, cerr = checkForDataBagSQL(data_store.Dbh, name)
 		if cerr != nil {
 			err = util.Errorf(cerr.Error())
 			err.SetStatus(http.StatusInternalServerError)
@@ -93,8 +93,8 @@ func New(name string) (*DataBag, util.Gerror){
 func Get(db_name string) (*DataBag, util.Gerror){
 	var data_bag *DataBag
 	var err error
-	if config.Config.UseMySQL {
-		data_bag, err = getDataBagMySQL(db_name)
+	if config.Config.UseSQL {
+		data_bag, err = getDataBagSQL(db_name)
 		if err != nil {
 			var gerr util.Gerror
 			if err == sql.ErrNoRows {
@@ -126,8 +126,8 @@ func Get(db_name string) (*DataBag, util.Gerror){
 }
 
 func (db *DataBag) Save() error {
-	if config.Config.UseMySQL {
-		return db.saveMySQL()
+	if config.Config.UseSQL {
+		return db.saveSQL()
 	} else {
 		ds := data_store.New()
 		ds.Set("data_bag", db.Name, db)
@@ -136,8 +136,8 @@ func (db *DataBag) Save() error {
 }
 
 func (db *DataBag) Delete() error {
-	if config.Config.UseMySQL {
-		err := db.deleteMySQL()
+	if config.Config.UseSQL {
+		err := db.deleteSQL()
 		if err != nil {
 			return err
 		}
@@ -153,11 +153,30 @@ func (db *DataBag) Delete() error {
 	return nil
 }
 
+/* The running count of data bag items in the in-memory data store. */
+var itemCount = data_store.NewCounter(countItems)
+
+func countItems() int {
+	n := 0
+	for _, dbn := range GetList() {
+		if dbag, err := Get(dbn); err == nil {
+			n += dbag.NumDBItems()
+		}
+	}
+	return n
+}
+
+// The number of items in every data bag in the in-memory data store, without
+// looking through all the data bags.
+func TotalItems() int {
+	return itemCount.Value()
+}
+
 // Returns a list of data bags on the server.
 func GetList() []string {
 	var db_list []string
-	if config.Config.UseMySQL {
-		db_list = getListMySQL()
+	if config.Config.UseSQL {
+		db_list = getListSQL()
 	} else {
 		ds := data_store.New()
 		db_list = ds.GetList("data_bag")
@@ -206,10 +225,13 @@ func (db *DataBag) NewDBItem (raw_dbag_item map[string]interface{}) (*DataBagIte
 	if err := validateDataBagName(dbi_id, true); err != nil {
 		return nil, err
 	}
+	if err := checkItemSize(raw_dbag_item); err != nil {
+		return nil, err
+	}
 	dbi_full_name := fmt.Sprintf("data_bag_item_%s_%s", db.Name, dbi_id)
 
-	if config.Config.UseMySQL {
-		d, err := db.getDBItemMySQL(dbi_id)
+	if config.Config.UseSQL {
+		d, err := db.getDBItemSQL(dbi_id)
 		if d != nil || (err != nil && err != sql.ErrNoRows) {
 			if err != nil {
 				logger.Debugf("Log real SQL error in NewDBItem: %s", err.Error())
@@ -218,7 +240,7 @@ func (db *DataBag) NewDBItem (raw_dbag_item map[string]interface{}) (*DataBagIte
 			gerr.SetStatus(http.StatusConflict)
 			return nil, gerr
 		}
-		dbag_item, err = db.newDBItemMySQL(dbi_id, raw_dbag_item)
+		dbag_item, err = db.newDBItemSQL(dbi_id, raw_dbag_item)
 		if err != nil {
 			gerr := util.Errorf(err.Error())
 			gerr.SetStatus(http.StatusInternalServerError)
@@ -240,6 +262,7 @@ func (db *DataBag) NewDBItem (raw_dbag_item map[string]interface{}) (*DataBagIte
 			RawData: raw_dbag_item,
 		}
 		db.DataBagItems[dbi_id] = dbag_item
+		itemCount.Add(1)
 	}
 	err := db.Save()
 	if err != nil {
@@ -260,9 +283,12 @@ func (db *DataBag) UpdateDBItem(dbi_id string, raw_dbag_item map[string]interfac
 		}
 		return nil, err
 	}
+	if serr := checkItemSize(raw_dbag_item); serr != nil {
+		return nil, serr
+	}
 	db_item.RawData = raw_dbag_item
-	if config.Config.UseMySQL {
-		err = db_item.updateDBItemMySQL()
+	if config.Config.UseSQL {
+		err = db_item.updateDBItemSQL()
 		if err != nil {
 			return nil, err
 		}
@@ -277,18 +303,40 @@ func (db *DataBag) UpdateDBItem(dbi_id string, raw_dbag_item map[string]interfac
 	return db_item, nil
 }
 
+/* Turn away data bag items bigger than max-data-bag-item-size when encoded as
+ * JSON, before they're stored or indexed. */
+func checkItemSize(raw_dbag_item map[string]interface{}) util.Gerror {
+	max := config.Config.MaxDataBagItemSize
+	if max <= 0 {
+		return nil
+	}
+	enc, err := json.Marshal(raw_dbag_item)
+	if err != nil {
+		return util.CastErr(err)
+	}
+	if len(enc) > max {
+		gerr := util.Errorf("Data bag item is %d bytes, which is more than the %d allowed.", len(enc), max)
+		gerr.SetStatus(http.StatusRequestEntityTooLarge)
+		return gerr
+	}
+	return nil
+}
+
 func (db *DataBag) DeleteDBItem(db_item_name string) error {
-	if config.Config.UseMySQL {
+	if config.Config.UseSQL {
 		dbi, err := db.GetDBItem(db_item_name)
 		if err != nil {
 			return err
 		}
-		err = dbi.deleteDBItemMySQL()
+		err = dbi.deleteDBItemSQL()
 		if err != nil {
 			return err
 		}
 	} else {
-		delete(db.DataBagItems, db_item_name)
+		if _, ok := db.DataBagItems[db_item_name]; ok {
+			delete(db.DataBagItems, db_item_name)
+			itemCount.Add(-1)
+		}
 	}
 	err := db.Save()
 	if err != nil {
@@ -299,33 +347,62 @@ func (db *DataBag) DeleteDBItem(db_item_name string) error {
 }
 
 func (db *DataBag) GetDBItem(db_item_name string) (*DataBagItem, error) {
-	if config.Config.UseMySQL {
-		dbi, err := db.getDBItemMySQL(db_item_name)
+	if config.Config.UseSQL {
+		dbi, err := db.getDBItemSQL(db_item_name)
 		if err == sql.ErrNoRows {
-			err = fmt.Errorf("data bag item %s in %s not found", db_item_name, db.Name)
+			gerr := util.Errorf("data bag item %s in %s not found", db_item_name, db.Name)
+			gerr.SetStatus(http.StatusNotFound)
+			err = gerr
 		}
 		return dbi, err
 	} else {
 		dbi, ok := db.DataBagItems[db_item_name]
 		if !ok {
-			err := fmt.Errorf("data bag item %s in %s not found", db_item_name, db.Name)
+			err := util.Errorf("data bag item %s in %s not found", db_item_name, db.Name)
+			err.SetStatus(http.StatusNotFound)
 			return nil, err
 		}
 		return dbi, nil
 	}
 }
 
+// Gets a data bag item's data with any overlay for the given environment
+// merged on top of it. An environment's overlay is the item in the same data
+// bag named after the base item and the environment joined with two
+// underscores, so "settings__production" is the overlay for "settings" in the
+// production environment. Where both have a value the overlay wins, with
+// nested hashes merged key by key. The merged data keeps the base item's id. If
+// there's no overlay for the environment, the base item's data is returned; any
+// other error getting the overlay is returned instead.
+func (db *DataBag) GetDBItemForEnv(db_item_name string, env_name string) (map[string]interface{}, error) {
+	dbi, err := db.GetDBItem(db_item_name)
+	if err != nil {
+		return nil, err
+	}
+	overlay, err := db.GetDBItem(fmt.Sprintf("%s__%s", db_item_name, env_name))
+	if err != nil {
+		/* Only a missing overlay falls back to the base item. */
+		if gerr, ok := err.(util.Gerror); ok && gerr.Status() == http.StatusNotFound {
+			return dbi.RawData, nil
+		}
+		return nil, err
+	}
+	merged := util.MergeAttributes(dbi.RawData, overlay.RawData)
+	merged["id"] = dbi.RawData["id"]
+	return merged, nil
+}
+
 func (db *DataBag) AllDBItems() (map[string]*DataBagItem, error) {
-	if config.Config.UseMySQL {
-		return db.allDBItemsMySQL()
+	if config.Config.UseSQL {
+		return db.allDBItemsSQL()
 	} else {
 		return db.DataBagItems, nil
 	}
 }
 
 func (db *DataBag) ListDBItems() []string {
-	if config.Config.UseMySQL {
-		return db.listDBItemsMySQL()
+	if config.Config.UseSQL {
+		return db.listDBItemsSQL()
 	} else {
 		dbis := make([]string, len(db.DataBagItems))
 		n := 0
@@ -338,8 +415,8 @@ func (db *DataBag) ListDBItems() []string {
 }
 
 func (db *DataBag) NumDBItems() int {
-	if config.Config.UseMySQL {
-		return db.numDBItemsMySQL()
+	if config.Config.UseSQL {
+		return db.numDBItemsSQL()
 	} else {
 		return len(db.DataBagItems)
 	}
@@ -0,0 +1,94 @@
+/*
+ * Copyright (c) 2013-2014, Jeremy Bingham (<jbingham@gmail.com>)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package data_bag
+
+import (
+	"testing"
+	"net/http"
+	"reflect"
+	"strings"
+	"github.com/ctdk/goiardi/config"
+	"github.com/ctdk/goiardi/util"
+)
+
+func TestGetDBItemForEnv(t *testing.T){
+	db, _ := New("overlays")
+	db.Save()
+	defer db.Delete()
+	base := map[string]interface{}{ "id": "settings", "port": 80, "db": map[string]interface{}{ "host": "localhost", "pool": 5 } }
+	overlay := map[string]interface{}{ "id": "settings__production", "db": map[string]interface{}{ "host": "db.example.com" }, "ssl": true }
+	if _, err := db.NewDBItem(base); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.NewDBItem(overlay); err != nil {
+		t.Fatal(err)
+	}
+
+	merged, err := db.GetDBItemForEnv("settings", "production")
+	if err != nil {
+		t.Fatalf("Getting the item for production failed: %s", err.Error())
+	}
+	expected := map[string]interface{}{ "id": "settings", "port": 80, "db": map[string]interface{}{ "host": "db.example.com", "pool": 5 }, "ssl": true }
+	if !reflect.DeepEqual(merged, expected) {
+		t.Errorf("The item for production was %v, expected %v", merged, expected)
+	}
+
+	/* Merging mustn't change the stored base item. */
+	dbi, _ := db.GetDBItem("settings")
+	if host := dbi.RawData["db"].(map[string]interface{})["host"]; host != "localhost" {
+		t.Errorf("Getting the item for production changed the base item's db host to %v", host)
+	}
+
+	/* No overlay, just the base item. */
+	plain, err := db.GetDBItemForEnv("settings", "staging")
+	if err != nil {
+		t.Fatalf("Getting the item for staging failed: %s", err.Error())
+	}
+	if !reflect.DeepEqual(plain, dbi.RawData) {
+		t.Errorf("The item for an environment without an overlay was %v, expected %v", plain, dbi.RawData)
+	}
+
+	if _, err := db.GetDBItemForEnv("nosuchitem", "production"); err == nil {
+		t.Errorf("Getting a missing item for an environment should have failed")
+	}
+	/* Only a 404 getting the overlay falls back to the base item. */
+	_, err = db.GetDBItem("settings__staging")
+	if gerr, ok := err.(util.Gerror); !ok || gerr.Status() != http.StatusNotFound {
+		t.Errorf("Getting a missing data bag item should have been a 404, got %v", err)
+	}
+}
+
+func TestDataBagItemSize(t *testing.T){
+	old_max := config.Config.MaxDataBagItemSize
+	config.Config.MaxDataBagItemSize = 200
+	defer func() { config.Config.MaxDataBagItemSize = old_max }()
+	dbag, _ := New("sizebag")
+	dbag.Save()
+
+	small := map[string]interface{}{ "id": "small", "value": "a" }
+	if _, err := dbag.NewDBItem(small); err != nil {
+		t.Fatalf("Creating a small data bag item failed: %s", err.Error())
+	}
+	big := map[string]interface{}{ "id": "big", "value": strings.Repeat("a", 300) }
+	if _, err := dbag.NewDBItem(big); err == nil || err.Status() != http.StatusRequestEntityTooLarge {
+		t.Errorf("Expected a 413 creating an oversized data bag item, got %v", err)
+	}
+	small["value"] = strings.Repeat("a", 300)
+	if _, err := dbag.UpdateDBItem("small", small); err == nil {
+		t.Errorf("Updating a data bag item to be oversized should have failed")
+	}
+}
@@ -25,19 +25,6 @@ import (
 
 // Functions for finding, saving, etc. data bags with a MySQL database.
 
-func checkForDataBagMySQL(dbhandle data_store.Dbhandle, name string) (bool, error) {
-	_, err := data_store.CheckForOne(dbhandle, "data_bags", name)
-	if err == nil {
-		return true, nil
-	} else {
-		if err != sql.ErrNoRows {
-			return false, err
-		} else {
-			return false, nil
-		}
-	}
-}
-
 func getDataBagMySQL(name string) (*DataBag, error) {
 	data_bag := new(DataBag)
 	stmt, err := data_store.Dbh.Prepare("SELECT id, name FROM data_bags WHERE name = ?")
@@ -52,22 +39,6 @@ func getDataBagMySQL(name string) (*DataBag, error) {
 	return data_bag, nil
 }
 
-func (dbi *DataBagItem) fillDBItemFromMySQL(row data_store.ResRow) error {
-	var rawb []byte
-	err := row.Scan(&dbi.id, &dbi.data_bag_id, &dbi.Name, &dbi.origName, &dbi.DataBagName, &rawb)
-	if err != nil {
-		return err
-	}
-	dbi.ChefType = "data_bag_item"
-	dbi.JsonClass = "Chef::DataBagItem"
-	err = data_store.DecodeBlob(rawb, &dbi.RawData)
-	if err != nil {
-		return err
-	}
-	data_store.ChkNilArray(dbi)
-	return nil
-}
-
 func (db *DataBag) getDBItemMySQL(db_item_name string) (*DataBagItem, error) {
 	dbi := new(DataBagItem)
 	stmt, err := data_store.Dbh.Prepare("SELECT dbi.id, dbi.data_bag_id, dbi.name, dbi.orig_name, db.name, dbi.raw_data FROM data_bag_items dbi JOIN data_bags db on dbi.data_bag_id = db.id WHERE dbi.orig_name = ? AND dbi.data_bag_id = ?")
@@ -76,7 +47,7 @@ func (db *DataBag) getDBItemMySQL(db_item_name string) (*DataBagItem, error) {
 	}
 	defer stmt.Close()
 	row := stmt.QueryRow(db_item_name, db.id)
-	err = dbi.fillDBItemFromMySQL(row)
+	err = dbi.fillDBItemFromSQL(row)
 	if err != nil {
 		return nil, err
 	}
@@ -102,7 +73,7 @@ func (db *DataBag) newDBItemMySQL(dbi_id string, raw_dbag_item map[string]interf
 	tx, err := data_store.Dbh.Begin()
 	// make sure this data bag didn't go away while we were doing something
 	// else
-	found, ferr := checkForDataBagMySQL(tx, db.Name)
+	found, ferr := checkForDataBagSQL(tx, db.Name)
 	if ferr != nil {
 		tx.Rollback()
 		return nil, err
@@ -182,7 +153,7 @@ func (db *DataBag) allDBItemsMySQL()(map[string]*DataBagItem, error) {
 	}
 	for rows.Next() {
 		dbi := new(DataBagItem)
-		err = dbi.fillDBItemFromMySQL(rows)
+		err = dbi.fillDBItemFromSQL(rows)
 		if err != nil {
 			rows.Close()
 			return nil, err
@@ -275,7 +246,7 @@ func (db *DataBag) saveMySQL() error {
 	if err != nil {
 		return err
 	}
-	found, ferr := checkForDataBagMySQL(tx, db.Name)
+	found, ferr := checkForDataBagSQL(tx, db.Name)
 	if ferr != nil {
 		tx.Rollback()
 		return ferr
@@ -302,34 +273,3 @@ func (db *DataBag) saveMySQL() error {
 	tx.Commit()
 	return nil
 }
-
-func getListMySQL() []string {
-	db_list := make([]string, 0)
-	stmt, err := data_store.Dbh.Prepare("SELECT name FROM data_bags")
-	if err != nil {
-		log.Fatal(err)
-	}
-	defer stmt.Close()
-	rows, err := stmt.Query()
-	if err != nil {
-		if err != sql.ErrNoRows {
-			log.Fatal(err)
-		}
-		return db_list
-	}
-	for rows.Next() {
-		var db_name string
-		err = rows.Scan(&db_name)
-		if err != nil {
-			rows.Close()
-			log.Fatal(err)
-		}
-		db_list = append(db_list, db_name)
-	}
-	rows.Close()
-	if err = rows.Err(); err != nil {
-		log.Fatal(err)
-	}
-
-	return db_list
-}
@@ -0,0 +1,154 @@
+/*
+ * Copyright (c) 2013-2014, Jeremy Bingham (<jbingham@gmail.com>)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package data_bag
+
+import (
+	"github.com/ctdk/goiardi/config"
+	"github.com/ctdk/goiardi/data_store"
+	"database/sql"
+	"log"
+)
+
+func checkForDataBagSQL(dbhandle data_store.Dbhandle, name string) (bool, error) {
+	_, err := data_store.CheckForOne(dbhandle, "data_bags", name)
+	if err == nil {
+		return true, nil
+	} else {
+		if err != sql.ErrNoRows {
+			return false, err
+		} else {
+			return false, nil
+		}
+	}
+}
+
+func (dbi *DataBagItem) fillDBItemFromSQL(row data_store.ResRow) error {
+	var rawb []byte
+	err := row.Scan(&dbi.id, &dbi.data_bag_id, &dbi.Name, &dbi.origName, &dbi.DataBagName, &rawb)
+	if err != nil {
+		return err
+	}
+	dbi.ChefType = "data_bag_item"
+	dbi.JsonClass = "Chef::DataBagItem"
+	err = data_store.DecodeBlob(rawb, &dbi.RawData)
+	if err != nil {
+		return err
+	}
+	data_store.ChkNilArray(dbi)
+	return nil
+}
+
+func getListSQL() []string {
+	db_list := make([]string, 0)
+	stmt, err := data_store.Dbh.Prepare("SELECT name FROM data_bags")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer stmt.Close()
+	rows, err := stmt.Query()
+	if err != nil {
+		if err != sql.ErrNoRows {
+			log.Fatal(err)
+		}
+		return db_list
+	}
+	for rows.Next() {
+		var db_name string
+		err = rows.Scan(&db_name)
+		if err != nil {
+			rows.Close()
+			log.Fatal(err)
+		}
+		db_list = append(db_list, db_name)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		log.Fatal(err)
+	}
+
+	return db_list
+}
+
+func getDataBagSQL(name string) (*DataBag, error) {
+	if config.Config.UseMySQL {
+		return getDataBagMySQL(name)
+	}
+	return getDataBagPostgreSQL(name)
+}
+
+func (db *DataBag) getDBItemSQL(db_item_name string) (*DataBagItem, error) {
+	if config.Config.UseMySQL {
+		return db.getDBItemMySQL(db_item_name)
+	}
+	return db.getDBItemPostgreSQL(db_item_name)
+}
+
+func (db *DataBag) newDBItemSQL(dbi_id string, raw_dbag_item map[string]interface{}) (*DataBagItem, error) {
+	if config.Config.UseMySQL {
+		return db.newDBItemMySQL(dbi_id, raw_dbag_item)
+	}
+	return db.newDBItemPostgreSQL(dbi_id, raw_dbag_item)
+}
+
+func (dbi *DataBagItem) updateDBItemSQL() error {
+	if config.Config.UseMySQL {
+		return dbi.updateDBItemMySQL()
+	}
+	return dbi.updateDBItemPostgreSQL()
+}
+
+func (dbi *DataBagItem) deleteDBItemSQL() error {
+	if config.Config.UseMySQL {
+		return dbi.deleteDBItemMySQL()
+	}
+	return dbi.deleteDBItemPostgreSQL()
+}
+
+func (db *DataBag) allDBItemsSQL() (map[string]*DataBagItem, error) {
+	if config.Config.UseMySQL {
+		return db.allDBItemsMySQL()
+	}
+	return db.allDBItemsPostgreSQL()
+}
+
+func (db *DataBag) numDBItemsSQL() int {
+	if config.Config.UseMySQL {
+		return db.numDBItemsMySQL()
+	}
+	return db.numDBItemsPostgreSQL()
+}
+
+func (db *DataBag) listDBItemsSQL() []string {
+	if config.Config.UseMySQL {
+		return db.listDBItemsMySQL()
+	}
+	return db.listDBItemsPostgreSQL()
+}
+
+func (db *DataBag) deleteSQL() error {
+	if config.Config.UseMySQL {
+		return db.deleteMySQL()
+	}
+	return db.deletePostgreSQL()
+}
+
+func (db *DataBag) saveSQL() error {
+	if config.Config.UseMySQL {
+		return db.saveMySQL()
+	}
+	return db.savePostgreSQL()
+}
@@ -0,0 +1,103 @@
+/* Running counts of objects for the in-memory data store. */
+
+/*
+ * Copyright (c) 2013-2014, Jeremy Bingham (<jbingham@gmail.com>)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package data_store
+
+import (
+	"sync"
+)
+
+/* Objects stored under their own keys are counted by the data store's lists
+ * of objects, but things kept inside another object, like cookbook versions
+ * and data bag items, would otherwise need every one of those objects looked
+ * at to be counted. Counters keep a running count of those as they're created
+ * and deleted instead. */
+
+// A Counter is a running count of some kind of object, kept up to date as
+// they're created and deleted. It's safe to use from more than one goroutine.
+type Counter struct {
+	m sync.Mutex
+	n int
+	recount func() int
+}
+
+var counterLock sync.Mutex
+var counters []*Counter
+
+// Make a new Counter. recount counts the objects from scratch, and is used by
+// Recount to set the counter after the data store's been loaded.
+func NewCounter(recount func() int) *Counter {
+	c := &Counter{ recount: recount }
+	counterLock.Lock()
+	defer counterLock.Unlock()
+	counters = append(counters, c)
+	return c
+}
+
+// Add delta to the count. Use a negative delta when objects are deleted.
+func (c *Counter) Add(delta int) {
+	c.m.Lock()
+	defer c.m.Unlock()
+	c.n += delta
+}
+
+// The current count.
+func (c *Counter) Value() int {
+	c.m.Lock()
+	defer c.m.Unlock()
+	return c.n
+}
+
+// Count everything the counters count from scratch. This should be done after
+// loading the data store from disk, before anything else is saved or deleted.
+func Recount() {
+	counterLock.Lock()
+	cs := make([]*Counter, len(counters))
+	copy(cs, counters)
+	counterLock.Unlock()
+	/* The recount functions will want to read from the data store, so
+	 * don't hold any locks while they run. */
+	for _, c := range cs {
+		n := c.recount()
+		c.m.Lock()
+		c.n = n
+		c.m.Unlock()
+	}
+}
+
+// How many objects of the given type are in the data store, without making a
+// list of them.
+func (ds *DataStore) Count(key_type string) int {
+	l, lis, _ := ds.typeLock(key_type)
+	l.RLock()
+	defer l.RUnlock()
+	return len(lis)
+}
+
+// How many log infos are in the data store.
+func (ds *DataStore) NumLogInfos() int {
+	l, _, c := ds.typeLock("log_info")
+	l.RLock()
+	defer l.RUnlock()
+	ds_key := ds.make_key("log_info", "log_infos")
+	a, _ := c.Get(ds_key)
+	if a == nil {
+		return 0
+	}
+	return len(a.(map[int]interface{}))
+}
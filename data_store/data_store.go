@@ -22,6 +22,11 @@ for storing the data.
 
 The methods that set, get, and delete key/value pairs also take a `key_type`
 argument that specifies what kind of object it is.
+
+By default each kind of object has its own lock, and its own go-cache to hold
+it, so that, for instance, saving a node doesn't hold up reading a cookbook.
+SetLockGranularity can switch the data store to one lock shared by everything
+instead.
 */
 package data_store
 
@@ -43,9 +48,17 @@ import (
 
 // Main data store.
 type DataStore struct {
-	dsc *cache.Cache
+	/* Each kind of object is kept in its own cache. go-cache locks each
+	 * cache itself, so one shared cache would make every kind of object
+	 * wait on every other one whatever the type locks allowed. */
+	caches map[string]*cache.Cache
 	obj_list map[string]map[string]bool
+	/* m guards caches, type_locks, and adding new types to obj_list. Save
+	 * and Load hold it, along with every type lock, to see or replace the
+	 * whole store at once. */
 	m sync.RWMutex
+	type_locks map[string]*sync.RWMutex
+	shared_lock bool
 }
 
 type dsFileStore struct {
@@ -57,11 +70,92 @@ var dataStoreCache = initDataStore()
 
 func initDataStore() *DataStore {
 	ds := new(DataStore)
-	ds.dsc = cache.New(0, 0)
+	ds.caches = make(map[string]*cache.Cache)
 	ds.obj_list = make(map[string]map[string]bool)
+	ds.type_locks = make(map[string]*sync.RWMutex)
 	return ds
 }
 
+// Set how finely the in-memory data store is locked. With "type", the
+// default, each kind of object is locked separately. With "global", one lock
+// is shared by every kind of object, which serializes all writes. This must be
+// called before the data store is used.
+func SetLockGranularity(granularity string) error {
+	switch granularity {
+		case "type", "":
+			dataStoreCache.shared_lock = false
+		case "global":
+			dataStoreCache.shared_lock = true
+		default:
+			err := fmt.Errorf("Unknown data store lock granularity '%s'; must be 'type' or 'global'.", granularity)
+			return err
+	}
+	return nil
+}
+
+/* Get the lock for a kind of object, the list of that kind of object's keys,
+ * and the cache holding them, creating them if need be. None of them are ever
+ * replaced once they've been made (Load refills them in place), so they can
+ * be used after m is released. Changes to the list need the type lock held for
+ * writing. */
+func (ds *DataStore) typeLock(key_type string) (*sync.RWMutex, map[string]bool, *cache.Cache) {
+	lock_name := key_type
+	if ds.shared_lock {
+		lock_name = ""
+	}
+	ds.m.RLock()
+	l := ds.type_locks[lock_name]
+	lis := ds.obj_list[key_type]
+	c := ds.caches[key_type]
+	ds.m.RUnlock()
+	if l != nil && lis != nil && c != nil {
+		return l, lis, c
+	}
+
+	ds.m.Lock()
+	defer ds.m.Unlock()
+	if ds.type_locks[lock_name] == nil {
+		ds.type_locks[lock_name] = new(sync.RWMutex)
+	}
+	if ds.obj_list[key_type] == nil {
+		ds.obj_list[key_type] = make(map[string]bool)
+	}
+	return ds.type_locks[lock_name], ds.obj_list[key_type], ds.typeCache(key_type)
+}
+
+/* The cache for a kind of object, made if it isn't there yet. m must be held
+ * for writing. */
+func (ds *DataStore) typeCache(key_type string) *cache.Cache {
+	if ds.caches[key_type] == nil {
+		ds.caches[key_type] = cache.New(0, 0)
+	}
+	return ds.caches[key_type]
+}
+
+/* Lock every type for Save and Load. m must already be held, so no new types
+ * can turn up in the meantime. Only one type lock is ever held by anything
+ * else, so the order they're taken in here doesn't matter. */
+func (ds *DataStore) lockAllTypes(write bool) func() {
+	locks := make([]*sync.RWMutex, 0, len(ds.type_locks))
+	for _, l := range ds.type_locks {
+		if write {
+			l.Lock()
+		} else {
+			l.RLock()
+		}
+		locks = append(locks, l)
+	}
+	return func() {
+		for _, l := range locks {
+			if write {
+				l.Unlock()
+			} else {
+				l.RUnlock()
+			}
+		}
+	}
+}
+
 // Create a new data store instance, or return an already created one.
 func New() *DataStore {
 	return dataStoreCache
@@ -76,17 +170,19 @@ func (ds *DataStore) make_key(key_type string, key string) string {
 
 func (ds *DataStore) Set(key_type string, key string, val interface{}){
 	ds_key := ds.make_key(key_type, key)
-	ds.m.Lock()
-	defer ds.m.Unlock()
-	ds.dsc.Set(ds_key, val, -1)
-	ds.addToList(key_type, key)
+	l, lis, c := ds.typeLock(key_type)
+	l.Lock()
+	defer l.Unlock()
+	c.Set(ds_key, val, -1)
+	lis[key] = true
 }
 
 func (ds *DataStore) Get(key_type string, key string) (interface {}, bool){
 	ds_key := ds.make_key(key_type, key)
-	ds.m.RLock()
-	defer ds.m.RUnlock()
-	val, found := ds.dsc.Get(ds_key)
+	l, _, c := ds.typeLock(key_type)
+	l.RLock()
+	defer l.RUnlock()
+	val, found := c.Get(ds_key)
 	if val != nil {
 		ChkNilArray(val)
 	}
@@ -95,37 +191,26 @@ func (ds *DataStore) Get(key_type string, key string) (interface {}, bool){
 
 func (ds *DataStore) Delete(key_type string, key string){
 	ds_key := ds.make_key(key_type, key)
-	ds.m.Lock()
-	defer ds.m.Unlock()
-	ds.dsc.Delete(ds_key)
-	ds.removeFromList(key_type, key)
+	l, lis, c := ds.typeLock(key_type)
+	l.Lock()
+	defer l.Unlock()
+	c.Delete(ds_key)
+	delete(lis, key)
 }
 
 /* For the in-memory data store stuff, we need a convenient list of objects,
  * since it's not a database and we can't just pull that up. This won't be
- * useful normally. */
-
-func (ds *DataStore) addToList(key_type string, key string){
-	if ds.obj_list[key_type] == nil {
-		ds.obj_list[key_type] = make(map[string]bool)
-	}
-	ds.obj_list[key_type][key] = true
-}
-
-func (ds *DataStore) removeFromList(key_type string, key string){
-	if ds.obj_list[key_type] != nil {
-		/* If it's nil, we don't have to worry about deleting the key */
-		delete(ds.obj_list[key_type], key)
-	}
-}
+ * useful normally. The lists are kept in obj_list, and are updated by Set and
+ * Delete. */
 
 // Return a list of all objects of the given type.
 func (ds *DataStore) GetList(key_type string) []string{
-	j := make([]string, len(ds.obj_list[key_type]))
+	l, lis, _ := ds.typeLock(key_type)
+	l.RLock()
+	defer l.RUnlock()
+	j := make([]string, len(lis))
 	i := 0
-	ds.m.RLock()
-	defer ds.m.RUnlock()
-	for k, _ := range ds.obj_list[key_type] {
+	for k, _ := range lis {
 		j[i] = k
 		i++
 	}
@@ -133,42 +218,69 @@ func (ds *DataStore) GetList(key_type string) []string{
 	return j
 }
 
+// Returns a page of a list of names, starting at offset and holding at most
+// limit names. A negative limit returns everything after the offset.
+func PageList(list []string, offset int, limit int) []string {
+	if offset >= len(list) {
+		return make([]string, 0)
+	}
+	end := len(list)
+	if limit >= 0 && offset + limit < end {
+		end = offset + limit
+	}
+	return list[offset:end]
+}
+
+// Sorts a list of names in place, in descending order if desc is true, and
+// returns it.
+func SortList(list []string, desc bool) []string {
+	if desc {
+		sort.Sort(sort.Reverse(sort.StringSlice(list)))
+	} else {
+		sort.Strings(list)
+	}
+	return list
+}
+
 // Set a log_info in the data store. Unlike most of these objects, log infos
 // are stored and retrieved by id, since they have no useful names.
 func (ds *DataStore) SetLogInfo(obj interface{}) error {
-	ds.m.Lock()
-	defer ds.m.Unlock()
+	l, _, c := ds.typeLock("log_info")
+	l.Lock()
+	defer l.Unlock()
 	ds_key := ds.make_key("log_info", "log_infos")
-	a, _ := ds.dsc.Get(ds_key)
+	a, _ := c.Get(ds_key)
 	if a == nil {
 		a = make(map[int]interface{})
 	}
 	arr := a.(map[int]interface{})
 	next_id := getNextId(arr)
 	arr[next_id] = obj
-	ds.dsc.Set(ds_key, arr, -1)
+	c.Set(ds_key, arr, -1)
 	return nil
 }
 
 func (ds *DataStore) DeleteLogInfo(id int) error {
-	ds.m.Lock()
-	defer ds.m.Unlock()
+	l, _, c := ds.typeLock("log_info")
+	l.Lock()
+	defer l.Unlock()
 	ds_key := ds.make_key("log_info", "log_infos")
-	a, _ := ds.dsc.Get(ds_key)
+	a, _ := c.Get(ds_key)
 	if a == nil {
 		a = make(map[int]interface{})
 	}
 	arr := a.(map[int]interface{})
 	delete(arr, id)
-	ds.dsc.Set(ds_key, arr, -1)
+	c.Set(ds_key, arr, -1)
 	return nil
 }
 
 func (ds *DataStore) PurgeLogInfoBefore(id int) (int64, error) {
-	ds.m.Lock()
-	defer ds.m.Unlock()
+	l, _, c := ds.typeLock("log_info")
+	l.Lock()
+	defer l.Unlock()
 	ds_key := ds.make_key("log_info", "log_infos")
-	a, _ := ds.dsc.Get(ds_key)
+	a, _ := c.Get(ds_key)
 	if a == nil {
 		a = make(map[int]interface{})
 	}
@@ -182,7 +294,7 @@ func (ds *DataStore) PurgeLogInfoBefore(id int) (int64, error) {
 			purged++
 		}
 	}
-	ds.dsc.Set(ds_key, new_logs, -1)
+	c.Set(ds_key, new_logs, -1)
 	return purged, nil
 }
 
@@ -200,10 +312,11 @@ func getNextId(lis map[int]interface{}) int {
 
 // Get a log_info by id.
 func (ds *DataStore) GetLogInfo(id int) (interface{}, error) {
-	ds.m.RLock()
-	defer ds.m.RUnlock()
+	l, _, c := ds.typeLock("log_info")
+	l.RLock()
+	defer l.RUnlock()
 	ds_key := ds.make_key("log_info", "log_infos")
-	a, _ := ds.dsc.Get(ds_key)
+	a, _ := c.Get(ds_key)
 	if a == nil {
 		err := fmt.Errorf("No log events stored")
 		return nil, err
@@ -219,10 +332,11 @@ func (ds *DataStore) GetLogInfo(id int) (interface{}, error) {
 
 // Get all the log infos currently stored 
 func (ds *DataStore) GetLogInfoList() map[int]interface{} {
-	ds.m.RLock()
-	defer ds.m.RUnlock()
+	l, _, c := ds.typeLock("log_info")
+	l.RLock()
+	defer l.RUnlock()
 	ds_key := ds.make_key("log_info", "log_infos")
-	a, _ := ds.dsc.Get(ds_key)
+	a, _ := c.Get(ds_key)
 	if a == nil {
 		return nil
 	}
@@ -247,8 +361,20 @@ func (ds *DataStore) Save(dsFile string) error {
 	obj_list := new(bytes.Buffer)
 	ds.m.RLock()
 	defer ds.m.RUnlock()
+	defer ds.lockAllTypes(false)()
 
-	err = ds.dsc.Save(dscache)
+	/* The caches are put back together into one to save, so the file's
+	 * the same as it's always been. */
+	whole := cache.New(0, 0)
+	for key_type, c := range ds.caches {
+		for _, k := range ds.cacheKeys(key_type) {
+			ds_key := ds.make_key(key_type, k)
+			if v, found := c.Get(ds_key); found {
+				whole.Set(ds_key, v, -1)
+			}
+		}
+	}
+	err = whole.Save(dscache)
 	if err != nil {
 		fp.Close()
 		return err
@@ -304,6 +430,7 @@ func (ds *DataStore) Load(dsFile string) error {
 	dec := gob.NewDecoder(zfp)
 	ds.m.Lock()
 	defer ds.m.Unlock()
+	defer ds.lockAllTypes(true)()
 	fstore := new(dsFileStore)
 	err = dec.Decode(&fstore)
 	zfp.Close()
@@ -316,22 +443,69 @@ func (ds *DataStore) Load(dsFile string) error {
 	dscache := bytes.NewBuffer(fstore.Cache)
 	obj_list := bytes.NewBuffer(fstore.Obj_list)
 
-	err = ds.dsc.Load(dscache)
+	whole := cache.New(0, 0)
+	err = whole.Load(dscache)
 	if err != nil {
 		log.Println("error at dscache")
 		fp.Close()
 		return err
 	}
 	dec = gob.NewDecoder(obj_list)
-	err = dec.Decode(&ds.obj_list)
+	loaded_list := make(map[string]map[string]bool)
+	err = dec.Decode(&loaded_list)
 	if err != nil {
 		log.Println("error at obj_list")
 		fp.Close()
 		return err
 	}
+	/* Refill the lists in place, since typeLock hands them out to be
+	 * used without m held. */
+	for key_type, lis := range ds.obj_list {
+		for k := range lis {
+			delete(lis, k)
+		}
+		for k, v := range loaded_list[key_type] {
+			lis[k] = v
+		}
+	}
+	for key_type, lis := range loaded_list {
+		if ds.obj_list[key_type] == nil {
+			ds.obj_list[key_type] = lis
+		}
+	}
+	/* Then split what was loaded up into each type's cache. */
+	key_types := []string{ "log_info" }
+	for key_type := range ds.obj_list {
+		if key_type != "log_info" {
+			key_types = append(key_types, key_type)
+		}
+	}
+	for _, key_type := range key_types {
+		c := ds.typeCache(key_type)
+		for _, k := range ds.cacheKeys(key_type) {
+			ds_key := ds.make_key(key_type, k)
+			if v, found := whole.Get(ds_key); found {
+				c.Set(ds_key, v, -1)
+			}
+		}
+	}
 	return fp.Close()
 }
 
+/* The keys of everything kept in a kind of object's cache. That's the type's
+ * list, except for log infos, which are all kept together under one key that
+ * isn't listed. */
+func (ds *DataStore) cacheKeys(key_type string) []string {
+	if key_type == "log_info" {
+		return []string{ "log_infos" }
+	}
+	keys := make([]string, 0, len(ds.obj_list[key_type]))
+	for k := range ds.obj_list[key_type] {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
 // When restoring an object from either the in-memory data store after it has
 // been saved to disk, or loading an object from the database with gob encoded
 // data structures, empty slices are encoded as "null" when they're sent out as
@@ -348,10 +522,12 @@ func ChkNilArray(obj interface{}) {
 					v.Set(o)
 				}
 			case reflect.Map:
-				m := v.Interface()
-				m = WalkMapForNil(m)
-				g := reflect.ValueOf(m)
-				v.Set(g)
+				/* The map's fixed up in place, and only
+				 * written to if it has a nil slice to replace,
+				 * so it doesn't need setting again. Setting it
+				 * anyway would write on every Get to objects
+				 * other goroutines may be reading. */
+				WalkMapForNil(v.Interface())
 		}
 	}
 }
@@ -363,7 +539,14 @@ func WalkMapForNil(r interface{}) interface{} {
 	switch m := r.(type) {
 		case map[string]interface{}:
 			for k, v := range m {
-				m[k] = WalkMapForNil(v)
+				switch v := v.(type) {
+					case map[string]interface{}:
+						WalkMapForNil(v)
+					case []string, []interface{}:
+						if reflect.ValueOf(v).IsNil() {
+							m[k] = WalkMapForNil(v)
+						}
+				}
 			}
 			r = m
 			return r
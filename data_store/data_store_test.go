@@ -21,6 +21,9 @@ import (
 	"io/ioutil"
 	"fmt"
 	"os"
+	"time"
+	"github.com/go-sql-driver/mysql"
+	"github.com/ctdk/goiardi/config"
 )
 
 type dsObj struct {
@@ -88,6 +91,19 @@ func TestGetList(t *testing.T){
 	}
 }
 
+func TestPageList(t *testing.T){
+	list := []string{ "a", "b", "c", "d", "e" }
+	if p := PageList(list, 1, 2); len(p) != 2 || p[0] != "b" || p[1] != "c" {
+		t.Errorf("PageList with offset 1 and limit 2 returned %v, expected [b c]", p)
+	}
+	if p := PageList(list, 3, -1); len(p) != 2 || p[0] != "d" {
+		t.Errorf("PageList with no limit returned %v, expected [d e]", p)
+	}
+	if p := PageList(list, 10, 2); len(p) != 0 {
+		t.Errorf("PageList past the end of the list returned %v, expected nothing", p)
+	}
+}
+
 var dsTmpDir = dsTmpGen()
 
 func dsTmpGen() string {
@@ -142,8 +158,168 @@ func TestSaveAndLoadData(t *testing.T) {
 	}
 }
 
+func TestRetryMySQL(t *testing.T) {
+	config.Config.MySQLRetries = 3
+	config.Config.MySQLRetryBackoffDur = time.Millisecond
+	tries := 0
+	err := RetryMySQL(func() error {
+		tries++
+		if tries < 3 {
+			return &mysql.MySQLError{ Number: 1213, Message: "Deadlock found when trying to get lock" }
+		}
+		return nil
+	})
+	if err != nil || tries != 3 {
+		t.Errorf("Expected a deadlock to be retried until it succeeded, got %v after %d tries", err, tries)
+	}
+	tries = 0
+	err = RetryMySQL(func() error {
+		tries++
+		return &mysql.MySQLError{ Number: 1062, Message: "Duplicate entry" }
+	})
+	if err == nil || tries != 1 {
+		t.Errorf("Expected a duplicate entry error to fail right away, got %v after %d tries", err, tries)
+	}
+}
+
+func TestPostgresqlConStr(t *testing.T) {
+	params := config.PostgreSQLdb{ Username: "goiardi", Password: "it's s3kr1t", Host: "localhost", Port: "5432", Dbname: "goiardi" }
+	conStr, err := formatPostgresqlConStr(params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `dbname='goiardi' user='goiardi' password='it\'s s3kr1t' host='localhost' port='5432'`
+	if conStr != expected {
+		t.Errorf("Expected connection string %s, got %s", expected, conStr)
+	}
+	if _, err = formatPostgresqlConStr(config.PostgreSQLdb{ Username: "goiardi" }); err == nil {
+		t.Errorf("A connection string without a database name should have failed")
+	}
+}
+
+func TestTypeLocksDontBlock(t *testing.T) {
+	ds := New()
+	l, _, _ := ds.typeLock("node")
+	l.Lock()
+	defer l.Unlock()
+	done := make(chan bool)
+	go func() {
+		ds.Set("cookbook", "locktest", makeDsObj())
+		ds.Get("cookbook", "locktest")
+		done <- true
+	}()
+	select {
+		case <-done:
+		case <-time.After(time.Second):
+			t.Errorf("Using cookbooks was held up by a lock on nodes")
+	}
+}
+
+func TestTypeCaches(t *testing.T) {
+	ds := New()
+	_, _, nc := ds.typeLock("node")
+	_, _, cc := ds.typeLock("cookbook")
+	if nc == cc {
+		t.Errorf("Nodes and cookbooks share a cache, so they'll wait on each other")
+	}
+	ds.Set("node", "cachetest", makeDsObj())
+	if _, found := cc.Get(ds.make_key("node", "cachetest")); found {
+		t.Errorf("A node turned up in the cookbook cache")
+	}
+	if _, found := ds.Get("node", "cachetest"); !found {
+		t.Errorf("The node wasn't found after being set")
+	}
+}
+
+func TestCounters(t *testing.T) {
+	ds := New()
+	for i := 0; i < 3; i++ {
+		ds.Set("count_test", fmt.Sprintf("c%d", i), makeDsObj())
+	}
+	ds.Set("count_test", "c0", makeDsObj())
+	ds.Delete("count_test", "c2")
+	if n := ds.Count("count_test"); n != 2 {
+		t.Errorf("Expected 2 count_test objects, got %d", n)
+	}
+
+	counted := 5
+	c := NewCounter(func() int { return counted })
+	done := make(chan bool)
+	for i := 0; i < 10; i++ {
+		go func() {
+			c.Add(2)
+			c.Add(-1)
+			done <- true
+		}()
+	}
+	for i := 0; i < 10; i++ {
+		<-done
+	}
+	if n := c.Value(); n != 10 {
+		t.Errorf("Expected the counter to be 10, got %d", n)
+	}
+	Recount()
+	if n := c.Value(); n != 5 {
+		t.Errorf("Expected the counter to be 5 after recounting, got %d", n)
+	}
+}
+
+/* Mixed reads and writes on different types of objects, with each kind of
+ * locking. */
+func benchMixedTypes(b *testing.B, granularity string) {
+	if err := SetLockGranularity(granularity); err != nil {
+		b.Fatal(err)
+	}
+	defer SetLockGranularity("type")
+	ds := New()
+	for i := 0; i < 100; i++ {
+		ds.Set("bench_cookbook", fmt.Sprintf("cb%d", i), makeDsObj())
+	}
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			if i % 4 == 0 {
+				ds.Set("bench_node", fmt.Sprintf("n%d", i % 100), makeDsObj())
+			} else {
+				ds.Get("bench_cookbook", fmt.Sprintf("cb%d", i % 100))
+			}
+			i++
+		}
+	})
+}
+
+func BenchmarkMixedTypeLocking(b *testing.B) {
+	benchMixedTypes(b, "type")
+}
+
+func BenchmarkMixedGlobalLocking(b *testing.B) {
+	benchMixedTypes(b, "global")
+}
+
 // clean up
 
 func TestCleanup(t *testing.T) {
 	os.RemoveAll(dsTmpDir)
 }
+
+func TestChkNilArray(t *testing.T) {
+	var nilStrs []string
+	obj := &struct {
+		Runs []string
+		Attrs map[string]interface{}
+	}{ Attrs: map[string]interface{}{ "a": nilStrs, "b": map[string]interface{}{ "c": []interface{}(nil) }, "d": "e" } }
+	ChkNilArray(obj)
+	if obj.Runs == nil {
+		t.Errorf("The nil slice field wasn't filled in")
+	}
+	if a, _ := obj.Attrs["a"].([]string); a == nil {
+		t.Errorf("The nil slice in the map wasn't filled in")
+	}
+	if c, _ := obj.Attrs["b"].(map[string]interface{})["c"].([]interface{}); c == nil {
+		t.Errorf("The nil slice in the nested map wasn't filled in")
+	}
+	if obj.Attrs["d"] != "e" {
+		t.Errorf("Other values in the map were changed: %v", obj.Attrs["d"])
+	}
+}
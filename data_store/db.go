@@ -20,10 +20,14 @@ package data_store
 
 import (
 	"database/sql"
-	_ "github.com/go-sql-driver/mysql"
+	"database/sql/driver"
+	"github.com/go-sql-driver/mysql"
+	"github.com/ctdk/goiardi/config"
+	"git.tideland.biz/goas/logger"
 	"strings"
 	"fmt"
 	"bytes"
+	"time"
 	"encoding/gob"
 	"encoding/json"
 )
@@ -49,11 +53,17 @@ type ResRow interface {
 }
 
 // Connect to a database with the database name and a map of connection options.
-// Currently supports MySQL.
+// Currently supports MySQL and PostgreSQL.
 func ConnectDB(dbEngine string, params interface{}) (*sql.DB, error) {
 	switch strings.ToLower(dbEngine) {
-		case "mysql":
-			connectStr, cerr := formatMysqlConStr(params)
+		case "mysql", "postgres":
+			var connectStr string
+			var cerr error
+			if strings.ToLower(dbEngine) == "mysql" {
+				connectStr, cerr = formatMysqlConStr(params)
+			} else {
+				connectStr, cerr = formatPostgresqlConStr(params)
+			}
 			if cerr != nil {
 				return nil, cerr
 			}
@@ -71,6 +81,35 @@ func ConnectDB(dbEngine string, params interface{}) (*sql.DB, error) {
 	}
 }
 
+// MySQL error numbers for errors that will probably go away if the operation is
+// tried again: deadlocks and lock wait timeouts.
+var retryableMySQLErrors = map[uint16]bool{ 1213: true, 1205: true }
+
+// Run a database operation, and if it fails with a transient MySQL error like a
+// deadlock or a dropped connection, run it again after a pause. This repeats up
+// to mysql-retries times, doubling the pause from mysql-retry-backoff each
+// time. Other errors are returned right away. The operation needs to start its
+// own transaction so it can be safely run more than once.
+func RetryMySQL(op func() error) error {
+	delay := config.Config.MySQLRetryBackoffDur
+	for attempt := 1; ; attempt++ {
+		err := op()
+		if err == nil || !retryableMySQLErr(err) || attempt > config.Config.MySQLRetries {
+			return err
+		}
+		logger.Debugf("Transient MySQL error, retrying in %s (retry %d of %d): %s", delay, attempt, config.Config.MySQLRetries, err.Error())
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+func retryableMySQLErr(err error) bool {
+	if merr, ok := err.(*mysql.MySQLError); ok {
+		return retryableMySQLErrors[merr.Number]
+	}
+	return err == mysql.ErrInvalidConn || err == driver.ErrBadConn
+}
+
 // Encode an object to a JSON string.
 func EncodeToJSON(obj interface{}) (string, error) {
 	buf := new(bytes.Buffer)
@@ -121,12 +160,73 @@ func DecodeBlob(data []byte, obj interface{}) (error) {
 	return nil
 }
 
+// Count the rows in the given table.
+func Count(dbhandle Dbhandle, table string) (int, error) {
+	var total int
+	if err := dbhandle.QueryRow(fmt.Sprintf("SELECT COUNT(*) FROM %s", table)).Scan(&total); err != nil {
+		return 0, err
+	}
+	return total, nil
+}
+
+// Get a page of the names of objects of the given type, sorted by name,
+// starting at offset and holding at most limit names, along with the total
+// number of objects of that type. A negative limit returns everything after the
+// offset. Like CheckForOne, the table must have a "name" column.
+func GetNameListPage(dbhandle Dbhandle, kind string, offset int, limit int) ([]string, int, error) {
+	return GetSortedNameListPage(dbhandle, kind, offset, limit, false)
+}
+
+// Like GetNameListPage, but the names can be sorted in descending order
+// instead.
+func GetSortedNameListPage(dbhandle Dbhandle, kind string, offset int, limit int, desc bool) ([]string, int, error) {
+	total, err := Count(dbhandle, kind)
+	if err != nil {
+		return nil, 0, err
+	}
+	var lim int64 = (1 << 63) - 1
+	if limit >= 0 {
+		lim = int64(limit)
+	}
+	name_list := make([]string, 0)
+	order := "ASC"
+	if desc {
+		order = "DESC"
+	}
+	sqlStmt := fmt.Sprintf("SELECT name FROM %s ORDER BY name %s LIMIT ?, ?", kind, order)
+	if config.Config.UsePostgreSQL {
+		sqlStmt = fmt.Sprintf("SELECT name FROM %s ORDER BY name %s OFFSET $1 LIMIT $2", kind, order)
+	}
+	rows, err := dbhandle.Query(sqlStmt, offset, lim)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return name_list, total, nil
+		}
+		return nil, 0, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name string
+		if err = rows.Scan(&name); err != nil {
+			return nil, 0, err
+		}
+		name_list = append(name_list, name)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, 0, err
+	}
+	return name_list, total, nil
+}
+
 // Check for one object of the given type identified by the given name. For this
 // function to work, the underlying table MUST have its primary text identifier
 // be called "name".
 func CheckForOne(dbhandle Dbhandle, kind string, name string) (int32, error){
 	var obj_id int32
 	prepStatement := fmt.Sprintf("SELECT id FROM %s WHERE name = ?", kind)
+	if config.Config.UsePostgreSQL {
+		prepStatement = fmt.Sprintf("SELECT id FROM %s WHERE name = $1", kind)
+	}
 	stmt, err := dbhandle.Prepare(prepStatement)
 	if err != nil {
 		return 0, err
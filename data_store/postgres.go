@@ -0,0 +1,58 @@
+/*
+ * Copyright (c) 2013-2014, Jeremy Bingham (<jbingham@gmail.com>)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// PostgreSQL specific functions for goiardi database work.
+package data_store
+
+import (
+	"github.com/ctdk/goiardi/config"
+	_ "github.com/lib/pq"
+	"fmt"
+	"strings"
+)
+
+func formatPostgresqlConStr(p interface{}) (string, error) {
+	params := p.(config.PostgreSQLdb)
+	if params.Dbname == "" {
+		err := fmt.Errorf("no database name specified")
+		return "", err
+	}
+	conParams := []string{ fmt.Sprintf("dbname=%s", pgConValue(params.Dbname)) }
+	if params.Username != "" {
+		conParams = append(conParams, fmt.Sprintf("user=%s", pgConValue(params.Username)))
+	}
+	if params.Password != "" {
+		conParams = append(conParams, fmt.Sprintf("password=%s", pgConValue(params.Password)))
+	}
+	if params.Host != "" {
+		conParams = append(conParams, fmt.Sprintf("host=%s", pgConValue(params.Host)))
+	}
+	if params.Port != "" {
+		conParams = append(conParams, fmt.Sprintf("port=%s", pgConValue(params.Port)))
+	}
+	if params.SSLMode != "" {
+		conParams = append(conParams, fmt.Sprintf("sslmode=%s", pgConValue(params.SSLMode)))
+	}
+	return strings.Join(conParams, " "), nil
+}
+
+/* Quote a value for a lib/pq connection string, escaping backslashes and
+ * single quotes. */
+func pgConValue(v string) string {
+	v = strings.Replace(v, `\`, `\\`, -1)
+	v = strings.Replace(v, `'`, `\'`, -1)
+	return fmt.Sprintf("'%s'", v)
+}
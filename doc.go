@@ -18,7 +18,7 @@
 Goiardi is an implementation of the Chef server (http://www.opscode.com) written
 in Go. It can either run entirely in memory with the option to save and load the
 in-memory data and search indexes to and from disk, drawing inspiration from 
-chef-zero, or it can use MySQL as its storage backend.
+chef-zero, or it can use MySQL or PostgreSQL as its storage backend.
 
 It is a work in progress. At the moment normal functionality as tested with 
 knife works, and chef-client runs complete successfully. At this point, almost
@@ -127,13 +127,183 @@ Currently available command line and config file options:
                           over the webui interface.
        --use-mysql        Use a MySQL database for data storage. Configure
                           database options in the config file.
+       --use-postgres     Use a PostgreSQL database for data storage.
+                          Configure database options in the config file.
        --local-filestore-dir= Directory to save uploaded files in. Optional when
                           running in in-memory mode, *mandatory* for SQL
-                          mode.
+                          mode unless --use-s3 is set.
+       --use-s3           Store uploaded files in S3 or S3 compatible object
+                          storage. Configure S3 options in the config file.
        --log-events       Log changes to chef objects.
    -K, --log-event-keep=  Number of events to keep in the event log. If set,
                           the event log will be checked periodically and
                           pruned to this number of entries.
+       --ignore-unknown-fields Ignore unknown fields in uploaded cookbooks
+                          instead of rejecting the upload. Unknown fields are
+                          logged at the debug level. Default: false.
+       --depsolve-max-run-list= Maximum number of items allowed in a run list
+                          sent to the depsolver. Default: 1000.
+       --depsolve-max-depth= Maximum depth the depsolver will follow cookbook
+                          dependencies. Default: 100.
+       --max-concurrent-requests= Maximum number of requests goiardi will
+                          handle at once. Requests beyond this are queued
+                          briefly, then rejected with a 503. Default: 0 (no
+                          limit).
+       --request-queue-timeout= How long a request waits for a slot when
+                          --max-concurrent-requests is reached before being
+                          rejected. Formatted like 5s, 500ms, etc. Defaults to
+                          5s.
+       --recipe-dep-check=  Check uploaded recipes for include_recipe calls on
+                          cookbooks that aren't declared as dependencies.
+                          'warn' logs and reports them, 'strict' rejects the
+                          upload. Requires the file contents to be in the
+                          filestore. Default: off.
+       --notice=            An informational message, like a maintenance
+                          notice, to send in the X-Goiardi-Notice header of
+                          every response. Can be changed or cleared at runtime
+                          through the /notice endpoint.
+       --auto-create-environments When a node is saved in an environment that
+                          doesn't exist, create an empty environment with that
+                          name. Off by default, since it can hide typos in
+                          environment names.
+       --put-creates        Let a PUT to a node, role, environment, or data
+                          bag item that doesn't exist create it, instead of
+                          returning a 404. Off by default, like the Chef
+                          server, where objects are created with a POST.
+       --admin-ip-allow=    Only allow administrative endpoints like /events,
+                          /search/reindex, cookbook imports and exports, and
+                          disabling clients to be used from this network, in
+                          CIDR notation. May be given more than once. Default:
+                          allow from anywhere.
+       --trusted-proxies=   Trust the X-Forwarded-For and X-Real-IP headers on
+                          requests coming from this network, in CIDR notation,
+                          when working out the client's address for logging and
+                          the admin IP allowlist. May be given more than once.
+                          Default: trust no proxies.
+       --strict-role-run-lists Reject roles whose run lists refer to recipes
+                          or roles that don't exist. Off by default, since
+                          roles and cookbooks can't always be uploaded in
+                          dependency order.
+       --verify-duplicate-uploads When a file is uploaded with the same
+                          checksum as a file already in the filestore, check
+                          that the contents are really the same and reject the
+                          upload with a 409 if they aren't. Costs a read of the
+                          existing file.
+       --freeze-on-upload   Freeze every cookbook version when it's uploaded,
+                          whatever the client says, so a release can't be
+                          changed by uploading it again without the force
+                          option. Versions uploaded with draft=true are left
+                          alone. Default: off.
+       --disable-force-upload Don't let the force option replace a frozen
+                          cookbook version, whether uploaded or imported. A
+                          frozen version then has to be unfrozen by an admin
+                          before it can be changed. Default: off.
+       --max-attribute-depth= Maximum nesting depth allowed for node, role,
+                          and environment attributes. Objects with more deeply
+                          nested attributes are rejected. Default: 100.
+       --webhook=           POST every event, like the ones recorded by
+                          --log-events, to this URL. May be given more than
+                          once. Use the config file to only send events for
+                          some types of objects.
+       --webhook-secret=    Secret used to sign the bodies of webhooks given
+                          with --webhook, sent in the X-Goiardi-Signature
+                          header.
+       --webhook-retries=   How many times to retry a failed webhook delivery.
+                          Default: 3.
+       --webhook-workers=   How many webhook deliveries to make at once.
+                          Events waiting for a worker are queued, and dropped
+                          with an error logged if too many are waiting.
+                          Default: 4.
+       --gzip-responses     Compress responses with gzip for clients that
+                          accept it.
+       --gzip-level=        Compression level for gzipped responses, from 1
+                          (fastest) to 9 (smallest). Default: 6.
+       --search-while-reindexing= What to do with searches while the search
+                          index is being rebuilt. 'error' responds with a 503
+                          and a Retry-After header, while 'serve' searches the
+                          partly rebuilt index and sets the
+                          X-Goiardi-Search-Incomplete header. Default: error.
+       --required-metadata= Reject cookbook uploads where this metadata field,
+                          like maintainer or license, is missing or empty. May
+                          be given more than once.
+       --normalize-versions Store cookbook versions with three components, so
+                          a cookbook uploaded as version 1.2 is stored and
+                          found as 1.2.0. MySQL and PostgreSQL always store
+                          versions this way.
+       --node-stale-delete-after=
+                          Delete nodes that haven't checked in, going by their
+                          ohai_time, for this long. Formatted like 720h.
+                          Checked hourly. Default: off.
+       --node-stale-dry-run With --node-stale-delete-after, only log the nodes
+                          that would be deleted without deleting them.
+       --max-concurrent-uploads=
+                          Maximum number of cookbook and file uploads goiardi
+                          will handle at once. Uploads beyond this are queued
+                          for up to --upload-queue-timeout, then rejected with
+                          a 503. Set to -1 for no limit. Default: 20.
+       --upload-queue-timeout=
+                          How long an upload waits for a slot when
+                          --max-concurrent-uploads is reached before being
+                          rejected. Formatted like 30s, 1m, etc. Defaults to
+                          30s.
+       --mysql-retries=   How many times to retry saving cookbooks and events
+                          after a transient MySQL error like a deadlock or lock
+                          wait timeout. Set to -1 to not retry. Default: 3.
+       --mysql-retry-backoff=
+                          How long to wait before the first retry after a
+                          transient MySQL error. The wait doubles with each
+                          retry. Formatted like 100ms, 1s, etc. Defaults to
+                          100ms.
+       --admin-password=  Password to give the admin user when it's created at
+                          startup, so it can log in to the webui right away.
+                          May also be set with the GOIARDI_ADMIN_PASSWORD
+                          environment variable. Has no effect if the admin user
+                          already exists. Default: no password.
+       --data-store-locking=
+                          How finely to lock the in-memory data store. 'type'
+                          gives each kind of object its own lock, so writing one
+                          kind doesn't hold up reading another. 'global' uses
+                          one lock for everything. Default: type.
+       --event-log-failure=
+                          What to do when an event can't be written to the
+                          event log. 'strict' fails the request that caused the
+                          event, 'best-effort' logs the failure, counts it in
+                          /_counts, and carries on. Default: strict.
+       --download-mirror-header=
+                          Request header, like X-Region, used to pick a mirror
+                          from --download-mirror for cookbook file download
+                          URLs. Default: off.
+       --download-mirror= Point cookbook file download URLs at another host
+                          when the --download-mirror-header header has a
+                          particular value, given as value=host, like
+                          eu-west=files-eu.example.com:8443. May be given more
+                          than once. Requests without a matching value get this
+                          server's own host.
+       --auth-debug       When a signed request fails authentication because
+                          its signature doesn't match, send back the canonical
+                          request string goiardi expected the client to sign,
+                          and what the client did sign, to help track down the
+                          mismatch. Only sent to requests from --admin-ip-allow
+                          networks, if any are set. Default: off.
+       --optimize-event-log=
+                          In MySQL or PostgreSQL mode, run OPTIMIZE TABLE (or
+                          VACUUM FULL) on the event log table after a purge
+                          deletes at least this percentage of its rows, to
+                          reclaim the space. It runs in the background, but
+                          the table may be locked while it's optimized.
+                          Default: 0 (off).
+       --extended-errors  Add a machine readable error code, the HTTP status,
+                          and the field the error was about, where known, to
+                          error responses. The usual Chef style list of error
+                          messages is still sent. Default: off.
+       --max-data-bag-item-size=
+                          Maximum size in bytes of a data bag item, encoded as
+                          JSON. Larger items are rejected with a 413. Set to -1
+                          for no limit. Default: 1000000.
+       --max-import-size=
+                          Maximum size in bytes of a cookbook bundle sent to
+                          /cookbooks/_import. Larger bundles are rejected with
+                          a 413. Set to -1 for no limit. Default: 100000000.
 
    Options specified on the command line override options in the config file.
 
@@ -152,9 +322,11 @@ admin user, or using chef-webui if webui will run in front of goiardi.
 In auth mode, goiardi supports both versions 1.0 and 1.1 of the Chef
 authentication protocol.
 
-*Note:* The admin user, when created on startup, does not have a password. This
-prevents logging in to the webui with the admin user, so a password will have to
-be set for admin before doing so.
+*Note:* The admin user, when created on startup, does not have a password
+unless one is given with the --admin-password option, the "admin-password"
+option in the config file, or the GOIARDI_ADMIN_PASSWORD environment variable.
+Without a password the admin user can't log in to the webui, so a password will
+have to be set for admin before doing so.
 
 Log levels
 
@@ -163,6 +335,19 @@ configuration file, or with one to four -V flags on the command line. Log level
 options are "debug", "info", "warning", "error", and "critical". More -V on the
 command line means more spewing into the log.
 
+Reloading the configuration
+
+Sending goiardi a SIGHUP makes it reread its config file and apply the options
+that can be changed safely while it's running, without dropping connections or
+losing any in-memory data. These are `log-level` and `debug-level`,
+`time-slew`, `log-event-keep`, `max-concurrent-requests`,
+`request-queue-timeout`, `max-concurrent-uploads`, and `upload-queue-timeout`.
+Options given on the command line still override the config file. Other
+options, like the storage backend, the listen address and port, and the SSL
+settings, are only read at startup; if they've changed in the file, goiardi
+logs a warning and carries on with the old values. If the config file can't be
+read or has a bad value in it, the error is logged and nothing is changed.
+
 MySQL mode
 
 Goiardi can now use MySQL to store its data, instead of keeping all its data 
@@ -219,6 +404,33 @@ given below:
 		[mysql.extra_params]
 			tls = "false"
 
+PostgreSQL mode
+
+Goiardi can also use PostgreSQL to store its data. Setting it up works much
+like MySQL mode: you need a PostgreSQL server goiardi can reach, and sqitch to
+deploy the schema, which is in sql-files/postgres-bundle. Goiardi uses
+INSERT ... ON CONFLICT, so PostgreSQL 9.5 or later is needed.
+
+* Create goiardi's database: createdb goiardi
+* Optionally, create a separate PostgreSQL role for goiardi and give it
+  permissions on that database.
+* In sql-files/postgres-bundle, deploy the bundle: sqitch deploy db:pg://postgres[:<password>]@localhost/goiardi
+
+Set use-postgres = true in the configuration file, or specify
+--use-postgres on the command line. MySQL and PostgreSQL may not be used at the
+same time, and like --use-mysql it is an error to specify -D/--data-file
+with --use-postgres.
+
+The connection options go in the [postgresql] section of the config file:
+
+	[postgresql]
+		username = "foo"
+		password = "s3kr1t" # optional
+		host = "localhost" # or the directory holding the server's Unix socket
+		port = "5432" # optional, defaults to 5432
+		dbname = "goiardi"
+		sslmode = "disable" # optional; see the lib/pq documentation
+
 Event Logging
 
 Goiardi has optional event logging. When enabled with the `--log-events` command
@@ -241,6 +453,8 @@ The event API endpoints work as follows:
 
 	`DELETE /events/1234` - delete a single logged event from the event log.
 
+	`GET /events/_histogram?interval=1h&action=update` - count logged events in buckets of the given interval (default one hour), oldest first. Returns an array of `{"bucket_start": ..., "count": ...}` objects. Buckets start on multiples of the interval since the Unix epoch, and empty buckets are left out. The `action`, `object_type`, and `actor_type` query parameters limit the count to matching events.
+
 A user or client must be an administrator account to use the `/events` endpoint.
 
 The data returned by an event should look something like this:
@@ -256,6 +470,261 @@ The data returned by an event should look something like this:
 	  "id": 22
 	}
 
+Environment Inheritance
+
+An environment can inherit cookbook version constraints from another
+environment by setting "base_environment" to that environment's name. When
+goiardi works out which cookbook versions an environment may use, it merges the
+environment's own "cookbook_versions" on top of the constraints of its base
+environment, which in turn are merged on top of its own base's, and so on. Where
+the same cookbook is constrained at more than one level the closest environment
+wins, so a child's explicit constraint always overrides anything it inherits.
+Only cookbook constraints are inherited; attributes are not.
+
+The base environment must exist when the environment is saved, and an
+environment can't be saved with a "base_environment" that would lead back to
+itself. An environment that other environments inherit from can't
+be deleted until they no longer do; trying gets a 409 naming them.
+
+Data Bag Environment Overlays
+
+Data bag items can have environment specific overlays. An overlay is an
+ordinary item in the same data bag, named after the base item and the
+environment joined with two underscores; "settings__production" is the
+production overlay for the "settings" item. Fetching an item with an "env"
+parameter, like "GET /data/config/settings?env=production", returns the base
+item with the overlay merged on top of it. Where both have a value for a key the
+overlay wins, and nested hashes are merged key by key. The merged item keeps the
+base item's id. If there is no overlay for that environment the base item is
+returned unchanged, and without the "env" parameter items are returned as they
+always have been.
+
+Cookbook Visibility
+
+Cookbooks can be hidden from some clients with the "cookbook-visibility"
+section of the config file. It maps patterns of client names to patterns of the
+cookbooks those clients may see, like ""teama-*" = [ "teama_*", "base" ]". Other
+cookbooks are left out of the cookbook lists those clients get, and fetching one
+or depsolving a run list that needs one gets a 403. Clients that don't match any
+pattern, and admins, see every cookbook. The policy is only enforced when
+use-auth is on. Files in the file_store aren't covered, since downloading them
+doesn't need authentication.
+
+Pre-release Cookbook Versions
+
+Besides the usual "x.y.z" and "x.y", cookbook versions may have SemVer
+pre-release and build parts, like "1.2.3-rc.1" or "1.2.3+build5". These need
+all three version numbers. A pre-release sorts below the release it comes
+before, so "1.2.3-rc.1" is older than "1.2.3", and build metadata is ignored
+when comparing versions. Because of that, uploading "1.2.3+build6" when
+"1.2.3+build5" is already there is refused with a 409. A pre-release only
+satisfies a constraint on a pre-release of the same version: ">= 1.2.0"
+matches neither "1.2.0-rc1" nor "2.0.0-beta", but ">= 1.2.0-rc1" matches
+both "1.2.0-rc1" and "1.2.0-rc2".
+MySQL users need to deploy the "cookbook_prerelease" change from the sqitch
+bundle to store them.
+
+Cookbook versions can also have more than three numbers, like "2.1.0.3".
+Versions are compared number by number, with a missing number counting as 0,
+so "2.1.0.3" is newer than "2.1.0" and older than "2.1.0.10", and "2.1.0" and
+"2.1.0.0" are the same version. Only one of two versions like that can be
+uploaded; the second gets a 409. A pessimistic constraint lets the last number
+given go up, so "~> 2.1.0.3" allows anything from "2.1.0.3" up to, but not
+including, "2.1.1". MySQL users need to deploy the "cookbook_extra_ver" change
+from the sqitch bundle to store them.
+
+Cookbook Version Growth
+
+"GET /cookbooks/_growth?interval=1d" counts the cookbook versions created in
+each bucket of the given interval (default one day), oldest first, returning an
+array of "{"bucket_start": ..., "count": ...}" objects. The interval takes the usual
+Go durations like "12h", plus whole days like "7d". Buckets start on multiples
+of the interval since the Unix epoch, and empty buckets are left out. Only
+admins can see this. In in-memory mode, versions uploaded before goiardi started
+recording when versions were created aren't counted.
+
+Bulk Cookbook Deletion
+
+Admins can delete every version of several cookbooks at once with
+"POST /cookbooks/_bulk_delete", sending a list of names like
+"{ "cookbooks": [ "test-app", "test-db" ] }", a pattern matching cookbook names
+like "{ "pattern": "test-*" }", or both. The response lists the cookbooks that
+were deleted and any named ones that weren't found, like
+"{ "deleted": [ "test-app" ], "not_found": [ "test-db" ] }". Files that only
+the deleted cookbooks used are removed from the filestore, while files other
+cookbooks still use are kept. In MySQL and PostgreSQL mode the cookbooks are
+deleted in one transaction, so either all of them are deleted or none are.
+
+Freezing Cookbook Versions
+
+A cookbook version can be frozen or unfrozen without uploading it again with
+"PUT /cookbooks/NAME/VERSION/_frozen" (or "PATCH"), with a body like
+"{"frozen": true}". Any client or user other than the validator can freeze a
+version, but only admins can unfreeze one. The response has the cookbook,
+version, and whether it's now frozen. A frozen version can still be replaced by
+uploading it with "force", as before.
+
+With "--freeze-on-upload", every cookbook version is frozen when it's uploaded,
+whatever "frozen?" the client sent, so a release can't be changed by uploading
+it again without "force". Upload with "?draft=true" to leave a version you're
+still working on unfrozen. With "--disable-force-upload", "force" can't
+replace a frozen version either, when uploading or importing, and an admin has
+to unfreeze it first. Imported versions keep whatever frozen state they had.
+
+Comparing Cookbook Versions
+
+"GET /cookbooks/NAME/_diff?from=1.0.0&to=1.1.0" shows what changed between two
+versions of a cookbook. Under "files" are the segments (recipes, templates,
+attributes, and so on) that differ, each listing the paths of the files
+"added", "removed", and "changed" going from "from" to "to". Files are compared
+by checksum, so only the stored manifests are needed. Under "metadata" are the
+top level metadata keys added, removed, and changed.
+
+Environments Pinning Cookbooks
+
+"GET /cookbooks/NAME/_pinned_environments" lists the environments whose
+cookbook version constraints keep them off the cookbook's latest version,
+counting constraints they inherit. Each entry has the environment, its
+constraint, and the newest version the constraint allows, which is empty if
+the constraint allows none, like
+"[ { "environment": "prod", "constraint": "~> 1.2.0", "latest_allowed": "1.2.4" } ]".
+Environments that don't constrain the cookbook, or that allow its latest
+version, are left out. An environment whose inherited constraints can't be
+worked out, because its base environment is missing or the chain loops, is
+skipped and logged.
+
+Universe
+
+"GET /universe" returns every version of every cookbook in one document, the
+way Berkshelf expects, so Berkshelf can use goiardi as a source directly. Each
+cookbook maps its versions to "{ "location_type": "opscode", "location_path":
+..., "dependencies": { ... } }", where "location_path" is the version's URL
+(https if "--https-urls" is set) and "dependencies" are the constraints from
+the version's metadata. Cookbooks hidden from the client by cookbook-visibility
+are left out. "?versions=N" limits each cookbook to its N newest versions,
+which can make the universe much smaller, but constraints on older versions
+may not be satisfiable against the trimmed universe. "?frozen_only=true" only
+includes frozen versions. By default every version is included.
+
+Node Creation Times
+
+Nodes have a "created_at" field, set when the node is first created and never
+changed after that, even if a PUT sends a different one. Nodes saved before
+goiardi kept creation times get one the next time they're saved. It's indexed as
+"20060102T150405Z" in UTC so it can be used in range searches, like
+"created_at:[20140101T000000Z TO *]" for the nodes created since the start of
+2014. Only nodes have a creation time. Roles, environments, clients, and data
+bag items don't: their JSON is sent back to knife and chef the way the Chef
+server sends it, and a data bag item's JSON is just the item's own data, so
+there's nowhere to put one without changing what those objects look like.
+
+Range Searches
+
+Searches can look for a field in a range, inclusive with square brackets like
+"uptime_seconds:[3600 TO 86400]" or exclusive with curly braces like
+"name:{a TO m}". Values are compared as numbers when both the value and the end
+of the range are numbers, and as strings otherwise. A "*" for either end leaves
+it open, like "uptime_seconds:[3600 TO *]", and "[* TO *]" matches anything
+with the field.
+
+Sorting Search Results
+
+The "sort" parameter orders search results by a field, like "sort=name asc" or
+"sort=uptime_seconds:desc". Nested fields are separated by periods. A node
+attribute can be named directly, without its precedence level, and data bag
+items are sorted by their own fields. Numbers sort numerically and everything
+else as strings. Results without the field come last, and results with the same
+value are ordered by name, so the order is the same every time.
+
+Search Paging
+
+Searches take "start" and "rows" parameters to return one page of the results,
+after they've been sorted. "rows" defaults to 1000. The response's "total" is
+how many results the query found in all, not just on that page, and a "start"
+past the end gives an empty page rather than an error.
+
+Partial Search
+
+POSTing a body of keys to "/search/INDEX" returns only those keys from each
+result, as "{ "url": ..., "data": { ... } }" rows. Each key maps a name to a
+path into the object, like
+"{ "name": [ "name" ], "machine": [ "kernel", "machine" ] }". A path can also
+be given as one string with periods between the keys, like "kernel.machine".
+Node attributes are looked for at the top level first, then in each attribute
+level. Keys that aren't in an object come back as "null".
+
+Saved Searches
+
+Searches that get run over and over can be saved by name. An admin saves one
+with "PUT /search/_saved/NAME", sending the index to search, the query (which
+defaults to "*:*"), and optionally the keys for a partial search in the same
+form as a partial search's body, like
+"{ "index": "node", "query": "role:web", "partial": { "ip": [ "ipaddress" ] } }".
+"GET /search/_saved/NAME" runs the saved search just like an ordinary search,
+and takes the same "sort", "start", and "rows" parameters. "GET /search/_saved"
+lists the saved searches, and "DELETE /search/_saved/NAME" removes one. Saving
+and deleting saved searches are logged as events. SQL users need to deploy the
+"saved_searches" change from the sqitch bundle.
+
+Authentication Errors
+
+When a signed request fails authentication, the 401 response says which part
+of the check failed, and its error code tells them apart: "content_hash_mismatch"
+when the body doesn't match the "X-Ops-Content-Hash" header,
+"signature_invalid" when the signature can't be decrypted with the client's
+public key, "signature_mismatch" when it decrypts but doesn't match the
+request, and "clock_skew" or "bad_timestamp" when the "X-Ops-Timestamp" header
+is too far off from the server's clock or can't be parsed. None of these
+include any key material; "--auth-debug" can be used to see the canonical
+request itself.
+
+Creating Objects with PUT
+
+Like the Chef server, goiardi creates objects with a POST to their list, like
+"POST /nodes", and a PUT to an object that doesn't exist gets a 404. With
+"--put-creates" (or "put-creates = true" in the config file), a PUT to a node,
+role, environment, or data bag item that doesn't exist creates it instead,
+responding with a 201 and logging a create event. A PUT to an object that does
+exist updates it either way. Clients and users always have to be created with
+a POST, since creating them makes their keys. Data bags themselves have no
+PUT at all, only their items. Cookbook versions and saved searches are always
+created with a PUT, as they have no POST. A PUT whose body isn't a JSON
+object, like "null", gets a 400.
+
+Paging and Sorting Lists
+
+The node, role, environment, client, and user lists can be fetched a page at a
+time with the "offset" and "limit" query parameters, like
+"GET /nodes?offset=20&limit=10". The lists are in name order, "offset" defaults
+to 0, and leaving out "limit" returns everything after the offset. When either
+one is given, the total number of objects in the list is sent back in the
+"X-Goiardi-Total-Count" header. A negative or non-numeric value gets a 400.
+
+The client and user lists can also be sorted by name with "sort=name",
+"sort=name:asc", or "sort=name:desc". Since a JSON object's keys don't keep
+their order, a sorted list comes back as an array of objects with "name" and
+"url" keys instead of the usual object of names and URLs. Sorting can be
+combined with paging, in which case the sort is done first. Any other "sort"
+value gets a 400.
+
+Object Counts
+
+Admins can get the number of each kind of object on the server, along with the
+number of event log writes that have failed, from "GET /_counts". Getting the
+counts doesn't look through every object, so it's cheap enough to scrape for
+monitoring even with a large server. With the in-memory data store, cookbook
+versions and data bag items are counted as they're created and deleted, and
+counted again from scratch when the data store is loaded at startup. With MySQL
+or PostgreSQL, each table's rows are counted.
+
+Trailing Slashes
+
+Trailing slashes in request paths are stripped before a request is routed, so
+"/cookbooks/" and "/cookbooks", or "/nodes/foo/" and "/nodes/foo", are handled
+identically. Requests are not redirected, since that breaks POSTs. Signed
+requests were already checked against the path without the trailing slash, so
+signatures are unaffected.
+
 Reporting
 
 Goiardi now supports, on an experimental basis, Chef's reporting facilities.
@@ -266,6 +735,12 @@ enable reporting and how to use it.
 As this is an experimental feature, it may not work entirely correctly. Bug
 reports are appreciated.
 
+A summary of a node's recent runs (run id, status, start and end times, and
+resource counts), newest first, is available to admins at
+/nodes/NAME/_runs. The ten most recent runs are returned by default; use the
+offset and limit parameters to page through older runs. The total number of
+runs the node has is in the X-Goiardi-Total-Count header.
+
 Tested Platforms
 
 Goiardi has been built and run with the native 6g compiler on Mac OS X (10.7, 
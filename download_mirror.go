@@ -0,0 +1,52 @@
+/* Pointing cookbook file downloads at regional mirrors */
+
+/*
+ * Copyright (c) 2013-2014, Jeremy Bingham (<jbingham@gmail.com>)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"net/http"
+	"github.com/ctdk/goiardi/config"
+	"github.com/ctdk/goiardi/cookbook"
+)
+
+/* The mirror host for this request, going by the configured header, or the
+ * empty string to leave download URLs pointing at this server. */
+func downloadMirrorHost(r *http.Request) string {
+	if config.Config.DownloadMirrorHeader == "" {
+		return ""
+	}
+	return config.DownloadMirrorHost(r.Header.Get(config.Config.DownloadMirrorHeader))
+}
+
+/* Rewrite the file URLs in a single cookbook version's JSON. */
+func mirrorCookbookURLs(r *http.Request, cbv_json map[string]interface{}) {
+	cookbook.MirrorFileURLs(cbv_json, downloadMirrorHost(r))
+}
+
+/* Rewrite the file URLs in every cookbook version of a depsolver result. */
+func mirrorDepsURLs(r *http.Request, deps map[string]interface{}) {
+	host := downloadMirrorHost(r)
+	if host == "" {
+		return
+	}
+	for _, d := range deps {
+		if cbv_json, ok := d.(map[string]interface{}); ok {
+			cookbook.MirrorFileURLs(cbv_json, host)
+		}
+	}
+}
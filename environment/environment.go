@@ -27,8 +27,11 @@ import (
 	"github.com/ctdk/goiardi/cookbook"
 	"github.com/ctdk/goiardi/util"
 	"github.com/ctdk/goiardi/indexer"
+	"git.tideland.biz/goas/logger"
 	"fmt"
 	"sort"
+	"strings"
+	"reflect"
 	"net/http"
 	"database/sql"
 )
@@ -41,15 +44,16 @@ type ChefEnvironment struct {
 	Default map[string]interface{} `json:"default_attributes"`
 	Override map[string]interface{} `json:"override_attributes"`
 	CookbookVersions map[string]string `json:"cookbook_versions"`
+	BaseEnvironment string `json:"base_environment,omitempty"`
 }
 
 // Creates a new environment, returning an error if the environment already
 // exists or you try to create an environment named "_default".
 func New(name string) (*ChefEnvironment, util.Gerror){
 	var found bool
-	if config.Config.UseMySQL {
+	if config.Config.UseSQL {
 		var eerr error
-		found, eerr = checkForEnvironmentMySQL(data_store.Dbh, name)
+		found, eerr = checkForEnvironmentSQL(data_store.Dbh, name)
 		if eerr != nil {
 			err := util.CastErr(eerr)
 			err.SetStatus(http.StatusInternalServerError)
@@ -104,7 +108,7 @@ func (e *ChefEnvironment)UpdateFromJson(json_env map[string]interface{}) util.Ge
 	}
 
 	/* Validations */
-	valid_elements := []string{ "name", "chef_type", "json_class", "description", "default_attributes", "override_attributes", "cookbook_versions" }
+	valid_elements := []string{ "name", "chef_type", "json_class", "description", "default_attributes", "override_attributes", "cookbook_versions", "base_environment" }
 	ValidElem:
 	for k := range json_env {
 		for _, i := range valid_elements {
@@ -158,27 +162,8 @@ func (e *ChefEnvironment)UpdateFromJson(json_env map[string]interface{}) util.Ge
 	json_env["cookbook_versions"], verr = util.ValidateAttributes("cookbook_versions", json_env["cookbook_versions"])
 	if verr != nil {
 		return verr
-	} else {
-		for k, v := range json_env["cookbook_versions"].(map[string]interface{}) {
-			if !util.ValidateEnvName(k) || k == "" {
-				merr := util.Errorf("Cookbook name %s invalid", k)
-				merr.SetStatus(http.StatusBadRequest)
-				return merr
-			}
-
-			if v == nil {
-				verr = util.Errorf("Invalid version number")
-				return verr
-			}
-			_, verr = util.ValidateAsConstraint(v)
-			if verr != nil {
-				/* try validating as a version */
-				v, verr = util.ValidateAsVersion(v)
-				if verr != nil {
-					return verr
-				}
-			}
-		}
+	} else if verr = ValidateCookbookVersions(json_env["cookbook_versions"].(map[string]interface{})); verr != nil {
+		return verr
 	}
 
 	json_env["description"], verr = util.ValidateAsString(json_env["description"])
@@ -190,11 +175,26 @@ func (e *ChefEnvironment)UpdateFromJson(json_env map[string]interface{}) util.Ge
 		}
 	}
 
+	json_env["base_environment"], verr = util.ValidateAsString(json_env["base_environment"])
+	if verr != nil {
+		if verr.Error() == "Field 'name' missing" {
+			json_env["base_environment"] = ""
+		} else {
+			return verr
+		}
+	}
+	if base := json_env["base_environment"].(string); base != "" {
+		if verr = e.checkBaseEnvironment(base); verr != nil {
+			return verr
+		}
+	}
+
 	e.ChefType = json_env["chef_type"].(string)
 	e.JsonClass = json_env["json_class"].(string)
 	e.Description = json_env["description"].(string)
 	e.Default = json_env["default_attributes"].(map[string]interface{})
 	e.Override = json_env["override_attributes"].(map[string]interface{})
+	e.BaseEnvironment = json_env["base_environment"].(string)
 	/* clear out, then loop over the cookbook versions */
 	e.CookbookVersions = make(map[string]string, len(json_env["cookbook_versions"].(map[string]interface{})))
 	for c, v := range json_env["cookbook_versions"].(map[string]interface{}){
@@ -204,15 +204,82 @@ func (e *ChefEnvironment)UpdateFromJson(json_env map[string]interface{}) util.Ge
 	return nil
 }
 
+// Checks that the cookbook names and version constraints in a
+// "cookbook_versions" hash are valid.
+func ValidateCookbookVersions(cookbook_versions map[string]interface{}) util.Gerror {
+	for k, v := range cookbook_versions {
+		if !util.ValidateEnvName(k) || k == "" {
+			merr := util.Errorf("Cookbook name %s invalid", k)
+			merr.SetStatus(http.StatusBadRequest)
+			return merr
+		}
+
+		if v == nil {
+			verr := util.Errorf("Invalid version number")
+			return verr
+		}
+		if _, verr := util.ValidateAsConstraint(v); verr != nil {
+			/* try validating as a version */
+			if _, verr = util.ValidateAsVersion(v); verr != nil {
+				return verr
+			}
+		}
+	}
+	return nil
+}
+
+// Sets the given cookbook version constraints in this environment, leaving
+// any constraints on other cookbooks alone. The constraints should already
+// have been checked with ValidateCookbookVersions. The environment is not
+// saved.
+func (e *ChefEnvironment) SetConstraints(cookbook_versions map[string]interface{}) util.Gerror {
+	if e.Name == "_default" {
+		err := util.Errorf("The '_default' environment cannot be modified.")
+		err.SetStatus(http.StatusMethodNotAllowed)
+		return err
+	}
+	if e.CookbookVersions == nil {
+		e.CookbookVersions = make(map[string]string, len(cookbook_versions))
+	}
+	for c, v := range cookbook_versions {
+		e.CookbookVersions[c] = v.(string)
+	}
+	return nil
+}
+
+/* Make sure the environment we want to inherit constraints from exists, and
+ * that inheriting from it won't loop back around to this environment. */
+func (e *ChefEnvironment) checkBaseEnvironment(base string) util.Gerror {
+	seen := map[string]bool{ e.Name: true }
+	for base != "" {
+		if seen[base] {
+			err := util.Errorf("Setting base_environment %s would create an inheritance cycle", base)
+			err.SetStatus(http.StatusBadRequest)
+			return err
+		}
+		seen[base] = true
+		parent, err := Get(base)
+		if err != nil {
+			if err.Status() == http.StatusNotFound {
+				err = util.Errorf("Base environment %s not found", base)
+				err.SetStatus(http.StatusBadRequest)
+			}
+			return err
+		}
+		base = parent.BaseEnvironment
+	}
+	return nil
+}
+
 func Get(env_name string) (*ChefEnvironment, util.Gerror){
 	if env_name == "_default" {
 		return defaultEnvironment(), nil
 	}
 	var env *ChefEnvironment
 	var found bool
-	if config.Config.UseMySQL {
+	if config.Config.UseSQL {
 		var err error
-		env, err = getEnvironmentMySQL(env_name)
+		env, err = getEnvironmentSQL(env_name)
 		if err != nil {
 			var gerr util.Gerror
 			if err != sql.ErrNoRows {
@@ -245,7 +312,7 @@ func Get(env_name string) (*ChefEnvironment, util.Gerror){
 // Creates the default environment on startup.
 func MakeDefaultEnvironment() {
 	var de *ChefEnvironment
-	if config.Config.UseMySQL {
+	if config.Config.UseSQL {
 		// The default environment is pre-created in the db schema when
 		// it's loaded. Re-indexing the default environment doesn't
 		// hurt anything though, so just get the usual default env and
@@ -285,8 +352,8 @@ func (e *ChefEnvironment) Save() util.Gerror {
 		err.SetStatus(http.StatusMethodNotAllowed)
 		return err
 	}
-	if config.Config.UseMySQL {
-		err := e.saveEnvironmentMySQL()
+	if config.Config.UseSQL {
+		err := e.saveEnvironmentSQL()
 		if err != nil {
 			return err
 		}
@@ -299,14 +366,18 @@ func (e *ChefEnvironment) Save() util.Gerror {
 }
 
 // Deletes the environment, returning an error if you try to delete the 
-// "_default" environment.
+// "_default" environment, or an environment other environments inherit
+// cookbook constraints from.
 func (e *ChefEnvironment) Delete() error {
 	if e.Name == "_default" {
 		err := fmt.Errorf("The '_default' environment cannot be modified.")
 		return err
 	}
-	if config.Config.UseMySQL {
-		if err := e.deleteEnvironmentMySQL(); err != nil {
+	if err := e.checkNoChildren(); err != nil {
+		return err
+	}
+	if config.Config.UseSQL {
+		if err := e.deleteEnvironmentSQL(); err != nil {
 			return nil
 		}
 	} else {
@@ -317,19 +388,73 @@ func (e *ChefEnvironment) Delete() error {
 	return nil
 }
 
+// Returns the names of the environments that name this one as their
+// base_environment, sorted by name.
+func (e *ChefEnvironment) Children() ([]string, util.Gerror) {
+	if config.Config.UseSQL {
+		children, err := e.childrenSQL()
+		if err != nil {
+			gerr := util.CastErr(err)
+			gerr.SetStatus(http.StatusInternalServerError)
+			return nil, gerr
+		}
+		return children, nil
+	}
+	children := make([]string, 0)
+	for _, name := range GetList() {
+		if env, _ := Get(name); env != nil && env.BaseEnvironment == e.Name {
+			children = append(children, name)
+		}
+	}
+	sort.Strings(children)
+	return children, nil
+}
+
+/* Deleting an environment that others inherit from would leave them with a
+ * base_environment that doesn't exist, so that's refused with a 409 listing
+ * them. */
+func (e *ChefEnvironment) checkNoChildren() util.Gerror {
+	children, err := e.Children()
+	if err != nil {
+		return err
+	}
+	if len(children) > 0 {
+		err := util.Errorf("Environment %s is the base_environment of %s, and cannot be deleted until they no longer inherit from it.", e.Name, strings.Join(children, ", "))
+		err.SetStatus(http.StatusConflict)
+		return err
+	}
+	return nil
+}
+
 // Get a list of all environments on this server.
 func GetList() []string {
 	var env_list []string
-	if config.Config.UseMySQL {
+	if config.Config.UseSQL {
 		env_list = getEnvironmentList()
 	} else {
 		ds := data_store.New()
 		env_list = ds.GetList("env")
-		env_list = append(env_list, "_default")
+		/* The default environment is usually saved in the data store
+		 * too, but make sure it's there. */
+		if _, found := ds.Get("env", "_default"); !found {
+			env_list = append(env_list, "_default")
+		}
 	}
 	return env_list
 }
 
+// Returns part of the list of environments, sorted by name, starting at offset and
+// holding at most limit of them, along with the total number of environments. A
+// negative limit returns everything after the offset.
+func GetListPage(offset int, limit int) ([]string, int) {
+	if config.Config.UseSQL {
+		return getListPageSQL(offset, limit)
+	}
+	env_list := GetList()
+	sort.Strings(env_list)
+	return data_store.PageList(env_list, offset, limit), len(env_list)
+}
+
 func (e *ChefEnvironment) GetName() string {
 	return e.Name
 }
@@ -342,29 +467,157 @@ func (e *ChefEnvironment) cookbookList() []*cookbook.Cookbook {
 	return cookbook.AllCookbooks()
 }
 
+// Returns the cookbook version constraints this environment actually uses:
+// its own constraints merged on top of those inherited from its
+// base_environment, and that environment's base, and so on up the chain. Where
+// the same cookbook is constrained more than once, the environment closest to
+// this one wins, so a child's explicit constraint always overrides its
+// parent's. Returns an error if the chain loops back on itself or names an
+// environment that no longer exists.
+func (e *ChefEnvironment) EffectiveCookbookVersions() (map[string]string, util.Gerror) {
+	effective := make(map[string]string, len(e.CookbookVersions))
+	seen := make(map[string]bool)
+	for env := e; env != nil; {
+		seen[env.Name] = true
+		for cb, v := range env.CookbookVersions {
+			if _, found := effective[cb]; !found {
+				effective[cb] = v
+			}
+		}
+		if env.BaseEnvironment == "" {
+			break
+		}
+		if seen[env.BaseEnvironment] {
+			err := util.Errorf("Environment %s has an inheritance cycle at %s", e.Name, env.BaseEnvironment)
+			err.SetStatus(http.StatusInternalServerError)
+			return nil, err
+		}
+		parent, err := Get(env.BaseEnvironment)
+		if err != nil {
+			if err.Status() == http.StatusNotFound {
+				err = util.Errorf("Base environment %s of environment %s not found", env.BaseEnvironment, env.Name)
+				err.SetStatus(http.StatusInternalServerError)
+			}
+			return nil, err
+		}
+		env = parent
+	}
+	return effective, nil
+}
+
+// Checks that every cookbook version constraint this environment uses,
+// including the ones it inherits, can be met by a cookbook on the server.
+// Returns the constraints that can't be met, sorted by cookbook name, with the
+// reason why. An empty list means the environment can be deployed.
+func (e *ChefEnvironment) UnsatisfiableConstraints() ([]map[string]string, util.Gerror) {
+	constraints, err := e.EffectiveCookbookVersions()
+	if err != nil {
+		return nil, err
+	}
+	cb_names := make([]string, 0, len(constraints))
+	for cb := range constraints {
+		cb_names = append(cb_names, cb)
+	}
+	sort.Strings(cb_names)
+	unsatisfiable := make([]map[string]string, 0)
+	for _, cb_name := range cb_names {
+		constraint := constraints[cb_name]
+		var reason string
+		cb, cerr := cookbook.Get(cb_name)
+		if cerr != nil {
+			reason = "cookbook not found"
+		} else {
+			/* Bare versions are pinned to exactly that version. */
+			c := constraint
+			if !strings.Contains(c, " ") {
+				c = fmt.Sprintf("= %s", c)
+			}
+			if cb.LatestConstrained(c) == nil {
+				reason = "no version of the cookbook satisfies the constraint"
+			}
+		}
+		if reason != "" {
+			unsatisfiable = append(unsatisfiable, map[string]string{ "cookbook": cb_name, "constraint": constraint, "reason": reason })
+		}
+	}
+	return unsatisfiable, nil
+}
+
+// Finds the environments whose cookbook version constraints, including the
+// ones they inherit, keep them from using the latest version of the cookbook,
+// sorted by environment name. Each has the environment, its constraint on the
+// cookbook, and the newest version the constraint allows, which is empty if
+// it allows none of them. Environments with no constraint on the cookbook, or
+// with one the latest version satisfies, are left out. So are environments
+// whose inherited constraints can't be worked out, like one whose base
+// environment's been deleted; they're logged and skipped so one broken
+// environment doesn't hide the rest.
+func PinnedBelowLatest(cb *cookbook.Cookbook) []map[string]string {
+	pinned := make([]map[string]string, 0)
+	latest := cb.LatestVersion()
+	if latest == nil {
+		return pinned
+	}
+	env_names := GetList()
+	sort.Strings(env_names)
+	for _, env_name := range env_names {
+		env, err := Get(env_name)
+		if err != nil {
+			continue
+		}
+		constraints, err := env.EffectiveCookbookVersions()
+		if err != nil {
+			logger.Warningf("Skipping environment %s while looking for pins on cookbook %s: %s", env_name, cb.Name, err.Error())
+			continue
+		}
+		constraint, ok := constraints[cb.Name]
+		if !ok || latest.Satisfies(constraint) {
+			continue
+		}
+		c := constraint
+		if !strings.Contains(c, " ") {
+			c = fmt.Sprintf("= %s", c)
+		}
+		var allowed string
+		if cbv := cb.LatestConstrained(c); cbv != nil {
+			allowed = cbv.Version
+		}
+		pinned = append(pinned, map[string]string{ "environment": env_name, "constraint": constraint, "latest_allowed": allowed })
+	}
+	return pinned
+}
+
 // Gets a hash of the cookbooks and their versions available to this 
 // environment.
-func (e *ChefEnvironment) AllCookbookHash(num_versions interface{}) map[string]interface{} {
+func (e *ChefEnvironment) AllCookbookHash(num_versions interface{}) (map[string]interface{}, util.Gerror) {
+	constraints, err := e.EffectiveCookbookVersions()
+	if err != nil {
+		return nil, err
+	}
 	cb_hash := make(map[string]interface{})
 	cb_list := e.cookbookList()
 	for _, cb := range cb_list {
 		if cb == nil {
 			continue
 		}
-		cb_hash[cb.Name] = cb.ConstrainedInfoHash(num_versions, e.CookbookVersions[cb.Name])
+		cb_hash[cb.Name] = cb.ConstrainedInfoHash(num_versions, constraints[cb.Name])
 	}
-	return cb_hash
+	return cb_hash, nil
 }
 
 // Gets a list of recipes available to this environment.
-func (e *ChefEnvironment) RecipeList() []string {
+func (e *ChefEnvironment) RecipeList() ([]string, util.Gerror) {
+	constraints, err := e.EffectiveCookbookVersions()
+	if err != nil {
+		return nil, err
+	}
 	recipe_list := make(map[string]string)
 	cb_list := e.cookbookList()
 	for _, cb := range cb_list {
 		if cb == nil {
 			continue
 		}
-		cbv := cb.LatestConstrained(e.CookbookVersions[cb.Name])
+		cbv := cb.LatestConstrained(constraints[cb.Name])
 		if cbv == nil {
 			continue
 		}
@@ -381,7 +634,95 @@ func (e *ChefEnvironment) RecipeList() []string {
 		i++
 	}
 	sort.Strings(sorted_recipes)
-	return sorted_recipes
+	return sorted_recipes, nil
+}
+
+// Copies the cookbook version constraints from the source environment into
+// this environment. If any cookbooks are given, only the constraints for those
+// cookbooks are copied; a named cookbook that isn't constrained in the source
+// environment has its constraint in this environment removed. The environment
+// is not saved.
+func (e *ChefEnvironment) PromoteFrom(src *ChefEnvironment, cookbooks []string) util.Gerror {
+	if e.Name == "_default" {
+		err := util.Errorf("The '_default' environment cannot be modified.")
+		err.SetStatus(http.StatusMethodNotAllowed)
+		return err
+	}
+	if len(cookbooks) == 0 {
+		for cb, v := range src.CookbookVersions {
+			e.CookbookVersions[cb] = v
+		}
+		return nil
+	}
+	for _, cb := range cookbooks {
+		if !util.ValidateEnvName(cb) || cb == "" {
+			err := util.Errorf("Cookbook name %s invalid", cb)
+			return err
+		}
+		if v, found := src.CookbookVersions[cb]; found {
+			e.CookbookVersions[cb] = v
+		} else {
+			delete(e.CookbookVersions, cb)
+		}
+	}
+	return nil
+}
+
+// Compares two environments' cookbook version constraints and default and
+// override attributes. For each of those, returns what was added (only in b),
+// removed (only in a), and changed between a and b. Nested attributes are
+// compared all the way down and named by their path, joined with periods.
+func Diff(a *ChefEnvironment, b *ChefEnvironment) map[string]interface{} {
+	cv_a := make(map[string]interface{}, len(a.CookbookVersions))
+	for k, v := range a.CookbookVersions {
+		cv_a[k] = v
+	}
+	cv_b := make(map[string]interface{}, len(b.CookbookVersions))
+	for k, v := range b.CookbookVersions {
+		cv_b[k] = v
+	}
+	diff := map[string]interface{}{
+		"cookbook_versions": newDiffSection(),
+		"default_attributes": newDiffSection(),
+		"override_attributes": newDiffSection(),
+	}
+	diffMaps(diff["cookbook_versions"].(map[string]map[string]interface{}), "", cv_a, cv_b, false)
+	diffMaps(diff["default_attributes"].(map[string]map[string]interface{}), "", a.Default, b.Default, true)
+	diffMaps(diff["override_attributes"].(map[string]map[string]interface{}), "", a.Override, b.Override, true)
+	return diff
+}
+
+func newDiffSection() map[string]map[string]interface{} {
+	return map[string]map[string]interface{}{
+		"added": make(map[string]interface{}),
+		"removed": make(map[string]interface{}),
+		"changed": make(map[string]interface{}),
+	}
+}
+
+/* Fill in the added, removed, and changed parts of a diff section. If nested
+ * is true, values that are maps in both a and b are compared key by key. */
+func diffMaps(section map[string]map[string]interface{}, prefix string, a map[string]interface{}, b map[string]interface{}, nested bool) {
+	for k, av := range a {
+		path := prefix + k
+		bv, found := b[k]
+		if !found {
+			section["removed"][path] = av
+			continue
+		}
+		am, aok := av.(map[string]interface{})
+		bm, bok := bv.(map[string]interface{})
+		if nested && aok && bok {
+			diffMaps(section, path + ".", am, bm, nested)
+		} else if !reflect.DeepEqual(av, bv) {
+			section["changed"][path] = map[string]interface{}{ "a": av, "b": bv }
+		}
+	}
+	for k, bv := range b {
+		if _, found := a[k]; !found {
+			section["added"][prefix + k] = bv
+		}
+	}
 }
 
 /* Search indexing methods */
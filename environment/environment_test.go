@@ -0,0 +1,232 @@
+/*
+ * Copyright (c) 2013-2014, Jeremy Bingham (<jbingham@gmail.com>)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package environment
+
+import (
+	"testing"
+	"fmt"
+	"net/http"
+	"reflect"
+	"github.com/ctdk/goiardi/cookbook"
+	"github.com/ctdk/goiardi/util"
+)
+
+/* Make a cookbook with the given versions and stick it in the data store. */
+func makeCookbook(name string, versions ...string) *cookbook.Cookbook {
+	cb := &cookbook.Cookbook{ Name: name, Versions: make(map[string]*cookbook.CookbookVersion) }
+	for _, v := range versions {
+		cb.Versions[v] = &cookbook.CookbookVersion{ CookbookName: name, Version: v, Name: fmt.Sprintf("%s-%s", name, v) }
+	}
+	cb.Save()
+	return cb
+}
+
+func TestPromoteFrom(t *testing.T){
+	src, _ := New("promotesrc")
+	src.CookbookVersions = map[string]string{ "app": "= 2.0.0", "db": "~> 1.1", "web": "= 3.0.0" }
+	newDest := func() *ChefEnvironment {
+		dest, _ := New("promotedest")
+		dest.CookbookVersions = map[string]string{ "app": "= 1.0.0", "cache": "= 0.5.0" }
+		return dest
+	}
+
+	dest := newDest()
+	if err := dest.PromoteFrom(src, nil); err != nil {
+		t.Fatalf("Promoting all constraints failed: %s", err.Error())
+	}
+	expected := map[string]string{ "app": "= 2.0.0", "cache": "= 0.5.0", "db": "~> 1.1", "web": "= 3.0.0" }
+	if !reflect.DeepEqual(dest.CookbookVersions, expected) {
+		t.Errorf("Promoting all constraints gave %v, expected %v", dest.CookbookVersions, expected)
+	}
+
+	/* Only the named cookbooks are copied, and a named cookbook the
+	 * source doesn't constrain loses its constraint. */
+	dest = newDest()
+	if err := dest.PromoteFrom(src, []string{ "db", "cache" }); err != nil {
+		t.Fatalf("Promoting some constraints failed: %s", err.Error())
+	}
+	expected = map[string]string{ "app": "= 1.0.0", "db": "~> 1.1" }
+	if !reflect.DeepEqual(dest.CookbookVersions, expected) {
+		t.Errorf("Promoting some constraints gave %v, expected %v", dest.CookbookVersions, expected)
+	}
+
+	dest = newDest()
+	if err := dest.PromoteFrom(src, []string{ "bad name!" }); err == nil {
+		t.Errorf("Promoting a constraint for an invalid cookbook name should have failed")
+	}
+
+	def := &ChefEnvironment{ Name: "_default", CookbookVersions: map[string]string{} }
+	err := def.PromoteFrom(src, nil)
+	if err == nil || err.Status() != http.StatusMethodNotAllowed {
+		t.Errorf("Promoting into _default should have been a 405, got %v", err)
+	}
+	if len(def.CookbookVersions) != 0 {
+		t.Errorf("Promoting into _default changed its constraints anyway")
+	}
+}
+
+func TestDiff(t *testing.T){
+	a, _ := New("diffa")
+	a.CookbookVersions = map[string]string{ "app": "= 1.0.0", "db": "~> 1.1", "old": "= 0.1.0" }
+	a.Default = map[string]interface{}{ "port": 80, "tuning": map[string]interface{}{ "workers": 4, "keepalive": true } }
+	a.Override = map[string]interface{}{ "same": "yes" }
+	b, _ := New("diffb")
+	b.CookbookVersions = map[string]string{ "app": "= 2.0.0", "db": "~> 1.1", "new": "= 0.2.0" }
+	b.Default = map[string]interface{}{ "port": 80, "tuning": map[string]interface{}{ "workers": 8 }, "region": "us-east" }
+	b.Override = map[string]interface{}{ "same": "yes" }
+
+	diff := Diff(a, b)
+	expected := map[string]interface{}{
+		"cookbook_versions": map[string]map[string]interface{}{
+			"added": { "new": "= 0.2.0" },
+			"removed": { "old": "= 0.1.0" },
+			"changed": { "app": map[string]interface{}{ "a": "= 1.0.0", "b": "= 2.0.0" } },
+		},
+		"default_attributes": map[string]map[string]interface{}{
+			"added": { "region": "us-east" },
+			"removed": { "tuning.keepalive": true },
+			"changed": { "tuning.workers": map[string]interface{}{ "a": 4, "b": 8 } },
+		},
+		"override_attributes": newDiffSection(),
+	}
+	if !reflect.DeepEqual(diff, expected) {
+		t.Errorf("Diff gave %v, expected %v", diff, expected)
+	}
+
+	/* An environment doesn't differ from itself. */
+	for section, d := range Diff(a, a) {
+		for kind, v := range d.(map[string]map[string]interface{}) {
+			if len(v) != 0 {
+				t.Errorf("Diffing an environment with itself found %s %s: %v", section, kind, v)
+			}
+		}
+	}
+}
+
+func TestUnsatisfiableConstraints(t *testing.T){
+	makeCookbook("unsat_app", "1.0.0", "1.2.0")
+	makeCookbook("unsat_db", "2.0.0")
+	parent, _ := New("unsatparent")
+	parent.CookbookVersions = map[string]string{ "unsat_db": ">= 3.0.0", "unsat_app": "= 9.9.9" }
+	parent.Save()
+	env, _ := New("unsatenv")
+	env.BaseEnvironment = "unsatparent"
+	env.CookbookVersions = map[string]string{ "unsat_app": "~> 1.1", "unsat_gone": ">= 0.0.0" }
+	env.Save()
+
+	unsatisfiable, err := env.UnsatisfiableConstraints()
+	if err != nil {
+		t.Fatalf("Checking unsatisfiable constraints failed: %s", err.Error())
+	}
+	/* unsat_app's own constraint overrides the parent's unsatisfiable
+	 * one. */
+	expected := []map[string]string{
+		{ "cookbook": "unsat_db", "constraint": ">= 3.0.0", "reason": "no version of the cookbook satisfies the constraint" },
+		{ "cookbook": "unsat_gone", "constraint": ">= 0.0.0", "reason": "cookbook not found" },
+	}
+	if !reflect.DeepEqual(unsatisfiable, expected) {
+		t.Errorf("Unsatisfiable constraints were %v, expected %v", unsatisfiable, expected)
+	}
+
+	/* A bare version is pinned to exactly that version. */
+	pinned, _ := New("unsatpinned")
+	pinned.CookbookVersions = map[string]string{ "unsat_app": "1.2.0", "unsat_db": "2.0.1" }
+	unsatisfiable, _ = pinned.UnsatisfiableConstraints()
+	if len(unsatisfiable) != 1 || unsatisfiable[0]["cookbook"] != "unsat_db" {
+		t.Errorf("Expected only unsat_db's pin to be unsatisfiable, got %v", unsatisfiable)
+	}
+
+	orphan, _ := New("unsatorphan")
+	orphan.BaseEnvironment = "unsatmissing"
+	if _, err := orphan.UnsatisfiableConstraints(); err == nil {
+		t.Errorf("Checking the constraints of an environment with a missing base environment should have failed")
+	}
+}
+
+func TestEnvironmentSetConstraints(t *testing.T){
+	env, _ := New("bulkenv")
+	env.CookbookVersions = map[string]string{ "nginx": "= 1.0.0" }
+	env.Save()
+
+	cvs := map[string]interface{}{ "apache": "= 1.2.3" }
+	if err := ValidateCookbookVersions(cvs); err != nil {
+		t.Fatalf("Valid constraints failed validation: %s", err.Error())
+	}
+	if err := env.SetConstraints(cvs); err != nil {
+		t.Fatalf("Setting constraints failed: %s", err.Error())
+	}
+	if env.CookbookVersions["apache"] != "= 1.2.3" || env.CookbookVersions["nginx"] != "= 1.0.0" {
+		t.Errorf("Expected apache to be added alongside nginx, got %v", env.CookbookVersions)
+	}
+	if err := ValidateCookbookVersions(map[string]interface{}{ "apache": "about 1.2" }); err == nil {
+		t.Errorf("An invalid constraint passed validation")
+	}
+	def, _ := Get("_default")
+	if err := def.SetConstraints(cvs); err == nil {
+		t.Errorf("Setting constraints in the _default environment should have failed")
+	}
+}
+
+func TestEnvironmentInheritance(t *testing.T){
+	mkEnv := func(name string, base string, cvs map[string]interface{}) (*ChefEnvironment, error) {
+		env, _ := New(name)
+		if err := env.UpdateFromJson(map[string]interface{}{ "name": name, "base_environment": base, "cookbook_versions": cvs }); err != nil {
+			return nil, err
+		}
+		return env, env.Save()
+	}
+	if _, err := mkEnv("inhparent", "", map[string]interface{}{ "nginx": "= 1.0.0", "apache": "= 2.0.0" }); err != nil {
+		t.Fatalf("Making the parent environment failed: %s", err.Error())
+	}
+	child, err := mkEnv("inhchild", "inhparent", map[string]interface{}{ "nginx": "= 1.1.0" })
+	if err != nil {
+		t.Fatalf("Making the child environment failed: %s", err.Error())
+	}
+	effective, gerr := child.EffectiveCookbookVersions()
+	if gerr != nil {
+		t.Fatalf("Getting the child's effective constraints failed: %s", gerr.Error())
+	}
+	/* The child's own constraint wins over the one it inherits. */
+	if effective["nginx"] != "= 1.1.0" || effective["apache"] != "= 2.0.0" {
+		t.Errorf("Child's effective constraints were %v", effective)
+	}
+
+	parent, _ := Get("inhparent")
+	if err := parent.UpdateFromJson(map[string]interface{}{ "name": "inhparent", "base_environment": "inhchild" }); err == nil {
+		t.Errorf("Making the parent inherit from its child should have failed as a cycle, but didn't")
+	}
+	if _, err := mkEnv("inhself", "inhself", nil); err == nil {
+		t.Errorf("An environment inheriting from itself should have failed, but didn't")
+	}
+
+	if children, _ := parent.Children(); len(children) != 1 || children[0] != "inhchild" {
+		t.Errorf("Parent's children were %v, expected [inhchild]", children)
+	}
+	if err := parent.Delete(); err == nil {
+		t.Errorf("Deleting an environment another inherits from should have failed, but didn't")
+	} else if gerr, ok := err.(util.Gerror); !ok || gerr.Status() != http.StatusConflict {
+		t.Errorf("Deleting an environment another inherits from gave '%s', expected a 409", err.Error())
+	}
+	if _, err := child.EffectiveCookbookVersions(); err != nil {
+		t.Errorf("After refusing to delete its parent, the child's constraints failed: %s", err.Error())
+	}
+	child.BaseEnvironment = ""
+	child.Save()
+	if err := parent.Delete(); err != nil {
+		t.Errorf("Deleting an environment nothing inherits from failed: %s", err.Error())
+	}
+}
@@ -21,61 +21,13 @@ import (
 	"github.com/ctdk/goiardi/util"
 	"database/sql"
 	"fmt"
-	"log"
 )
 
 /* MySQL specific functions for environments */
 
-func checkForEnvironmentMySQL(dbhandle data_store.Dbhandle, name string) (bool, error) {
-	_, err := data_store.CheckForOne(dbhandle, "environments", name)
-	if err == nil {
-		return true, nil
-	} else {
-		if err != sql.ErrNoRows {
-			return false, err
-		} else {
-			return false, nil
-		}
-	}
-}
-
-// Fill an environment in from a row returned from the SQL server. See the
-// equivalent function in node/node.go for more details.
-//
-// As there, the SQL query that made the row needs to have the same number &
-// order of columns as the one in Get(), even if the WHERE clause is different
-// or omitted.
-func (e *ChefEnvironment) fillEnvFromSQL(row *sql.Row) error {
-	var (
-		da []byte
-		oa []byte
-		cv []byte
-	)
-	err := row.Scan(&e.Name, &e.Description, &da, &oa, &cv)
-	if err != nil {
-		return err
-	}
-	e.ChefType = "environment"
-	e.JsonClass = "Chef::Environment"
-	err = data_store.DecodeBlob(da, &e.Default)
-	if err != nil {
-		return err
-	}
-	err = data_store.DecodeBlob(oa, &e.Override)
-	if err != nil {
-		return err
-	}
-	err = data_store.DecodeBlob(cv, &e.CookbookVersions)
-	if err != nil {
-		return err
-	}
-	data_store.ChkNilArray(e)
-	return nil
-}
-
 func getEnvironmentMySQL(env_name string) (*ChefEnvironment, error) {
 	env := new(ChefEnvironment)
-	stmt, err := data_store.Dbh.Prepare("SELECT name, description, default_attr, override_attr, cookbook_vers FROM environments WHERE name = ?")
+	stmt, err := data_store.Dbh.Prepare("SELECT name, description, default_attr, override_attr, cookbook_vers, base_environment FROM environments WHERE name = ?")
 	if err != nil {
 		return nil, err
 	}
@@ -108,7 +60,7 @@ func (e *ChefEnvironment) saveEnvironmentMySQL() util.Gerror {
 	var env_id int32
 	env_id, err = data_store.CheckForOne(tx, "environments", e.Name)
 	if err == nil {
-		_, err := tx.Exec("UPDATE environments SET description = ?, default_attr = ?, override_attr = ?, cookbook_vers = ?, updated_at = NOW() WHERE id = ?", e.Description, dab, oab, cvb, env_id)
+		_, err := tx.Exec("UPDATE environments SET description = ?, default_attr = ?, override_attr = ?, cookbook_vers = ?, base_environment = ?, updated_at = NOW() WHERE id = ?", e.Description, dab, oab, cvb, e.BaseEnvironment, env_id)
 		if err != nil {
 			tx.Rollback()
 			return util.CastErr(err)
@@ -118,7 +70,7 @@ func (e *ChefEnvironment) saveEnvironmentMySQL() util.Gerror {
 			tx.Rollback()
 			return util.CastErr(err)
 		}
-		_, err = tx.Exec("INSERT INTO environments (name, description, default_attr, override_attr, cookbook_vers, created_at, updated_at) VALUES (?, ?, ?, ?, ?, NOW(), NOW())", e.Name, e.Description, dab, oab, cvb)
+		_, err = tx.Exec("INSERT INTO environments (name, description, default_attr, override_attr, cookbook_vers, base_environment, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?, NOW(), NOW())", e.Name, e.Description, dab, oab, cvb, e.BaseEnvironment)
 		if err != nil {
 			tx.Rollback()
 			return util.CastErr(err)
@@ -147,27 +99,22 @@ func (e *ChefEnvironment) deleteEnvironmentMySQL() error {
 	return nil
 }
 
-func getEnvironmentList() []string {
-	env_list := make([]string, 0)
-	rows, err := data_store.Dbh.Query("SELECT name FROM environments")
+func (e *ChefEnvironment) childrenMySQL() ([]string, error) {
+	children := make([]string, 0)
+	rows, err := data_store.Dbh.Query("SELECT name FROM environments WHERE base_environment = ? ORDER BY name", e.Name)
 	if err != nil {
-		if err != sql.ErrNoRows {
-			log.Fatal(err)
-		}
-		rows.Close()
-		return env_list
+		return nil, err
 	}
+	defer rows.Close()
 	for rows.Next() {
-		var env_name string
-		err = rows.Scan(&env_name)
-		if err != nil {
-			log.Fatal(err)
+		var name string
+		if err = rows.Scan(&name); err != nil {
+			return nil, err
 		}
-		env_list = append(env_list, env_name)
+		children = append(children, name)
 	}
-	rows.Close()
 	if err = rows.Err(); err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
-	return env_list
+	return children, nil
 }
@@ -0,0 +1,108 @@
+/*
+ * Copyright (c) 2013-2014, Jeremy Bingham (<jbingham@gmail.com>)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package environment
+
+import (
+	"github.com/ctdk/goiardi/data_store"
+	"github.com/ctdk/goiardi/util"
+	"fmt"
+)
+
+/* PostgreSQL specific functions for environments */
+
+func getEnvironmentPostgreSQL(env_name string) (*ChefEnvironment, error) {
+	env := new(ChefEnvironment)
+	stmt, err := data_store.Dbh.Prepare("SELECT name, description, default_attr, override_attr, cookbook_vers, base_environment FROM environments WHERE name = $1")
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+	row := stmt.QueryRow(env_name)
+	err = env.fillEnvFromSQL(row)
+	if err != nil {
+		return nil, err
+	}
+	return env, nil
+}
+
+func (e *ChefEnvironment) saveEnvironmentPostgreSQL() util.Gerror {
+	dab, daerr := data_store.EncodeBlob(&e.Default)
+	if daerr != nil {
+		return util.CastErr(daerr)
+	}
+	oab, oaerr := data_store.EncodeBlob(&e.Override)
+	if oaerr != nil {
+		return util.CastErr(oaerr)
+	}
+	cvb, cverr := data_store.EncodeBlob(&e.CookbookVersions)
+	if cverr != nil {
+		return util.CastErr(cverr)
+	}
+	tx, err := data_store.Dbh.Begin()
+	if err != nil {
+		return util.CastErr(err)
+	}
+	_, err = tx.Exec("INSERT INTO environments (name, description, default_attr, override_attr, cookbook_vers, base_environment, created_at, updated_at) VALUES ($1, $2, $3, $4, $5, $6, NOW(), NOW()) ON CONFLICT (name) DO UPDATE SET description = EXCLUDED.description, default_attr = EXCLUDED.default_attr, override_attr = EXCLUDED.override_attr, cookbook_vers = EXCLUDED.cookbook_vers, base_environment = EXCLUDED.base_environment, updated_at = NOW()", e.Name, e.Description, dab, oab, cvb, e.BaseEnvironment)
+	if err != nil {
+		tx.Rollback()
+		return util.CastErr(err)
+	}
+	tx.Commit()
+	return nil
+}
+
+func (e *ChefEnvironment) deleteEnvironmentPostgreSQL() error {
+	tx, err := data_store.Dbh.Begin()
+	if err != nil {
+		return err
+	}
+	/* There's no trigger for this here, so move the environment's nodes
+	 * back to _default in the same transaction. */
+	_, err = tx.Exec("UPDATE nodes SET chef_environment = '_default' WHERE chef_environment = $1", e.Name)
+	if err == nil {
+		_, err = tx.Exec("DELETE FROM environments WHERE name = $1", e.Name)
+	}
+	if err != nil {
+		terr := tx.Rollback()
+		if terr != nil {
+			err = fmt.Errorf("deleting environment %s had an error '%s', and then rolling back the transaction gave another error '%s'", e.Name, err.Error(), terr.Error())
+		}
+		return err
+	}
+	tx.Commit()
+	return nil
+}
+
+func (e *ChefEnvironment) childrenPostgreSQL() ([]string, error) {
+	children := make([]string, 0)
+	rows, err := data_store.Dbh.Query("SELECT name FROM environments WHERE base_environment = $1 ORDER BY name", e.Name)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name string
+		if err = rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		children = append(children, name)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return children, nil
+}
@@ -0,0 +1,133 @@
+/*
+ * Copyright (c) 2013-2014, Jeremy Bingham (<jbingham@gmail.com>)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package environment
+
+import (
+	"github.com/ctdk/goiardi/config"
+	"github.com/ctdk/goiardi/data_store"
+	"github.com/ctdk/goiardi/util"
+	"database/sql"
+	"log"
+)
+
+func checkForEnvironmentSQL(dbhandle data_store.Dbhandle, name string) (bool, error) {
+	_, err := data_store.CheckForOne(dbhandle, "environments", name)
+	if err == nil {
+		return true, nil
+	} else {
+		if err != sql.ErrNoRows {
+			return false, err
+		} else {
+			return false, nil
+		}
+	}
+}
+
+// Fill an environment in from a row returned from the SQL server. See the
+// equivalent function in node/node.go for more details.
+//
+// As there, the SQL query that made the row needs to have the same number &
+// order of columns as the one in Get(), even if the WHERE clause is different
+// or omitted.
+func (e *ChefEnvironment) fillEnvFromSQL(row *sql.Row) error {
+	var (
+		da []byte
+		oa []byte
+		cv []byte
+	)
+	err := row.Scan(&e.Name, &e.Description, &da, &oa, &cv, &e.BaseEnvironment)
+	if err != nil {
+		return err
+	}
+	e.ChefType = "environment"
+	e.JsonClass = "Chef::Environment"
+	err = data_store.DecodeBlob(da, &e.Default)
+	if err != nil {
+		return err
+	}
+	err = data_store.DecodeBlob(oa, &e.Override)
+	if err != nil {
+		return err
+	}
+	err = data_store.DecodeBlob(cv, &e.CookbookVersions)
+	if err != nil {
+		return err
+	}
+	data_store.ChkNilArray(e)
+	return nil
+}
+
+func getEnvironmentList() []string {
+	env_list := make([]string, 0)
+	rows, err := data_store.Dbh.Query("SELECT name FROM environments")
+	if err != nil {
+		if err != sql.ErrNoRows {
+			log.Fatal(err)
+		}
+		rows.Close()
+		return env_list
+	}
+	for rows.Next() {
+		var env_name string
+		err = rows.Scan(&env_name)
+		if err != nil {
+			log.Fatal(err)
+		}
+		env_list = append(env_list, env_name)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		log.Fatal(err)
+	}
+	return env_list
+}
+
+func getListPageSQL(offset int, limit int) ([]string, int) {
+	env_list, total, err := data_store.GetNameListPage(data_store.Dbh, "environments", offset, limit)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return env_list, total
+}
+
+func getEnvironmentSQL(env_name string) (*ChefEnvironment, error) {
+	if config.Config.UseMySQL {
+		return getEnvironmentMySQL(env_name)
+	}
+	return getEnvironmentPostgreSQL(env_name)
+}
+
+func (e *ChefEnvironment) saveEnvironmentSQL() util.Gerror {
+	if config.Config.UseMySQL {
+		return e.saveEnvironmentMySQL()
+	}
+	return e.saveEnvironmentPostgreSQL()
+}
+
+func (e *ChefEnvironment) deleteEnvironmentSQL() error {
+	if config.Config.UseMySQL {
+		return e.deleteEnvironmentMySQL()
+	}
+	return e.deleteEnvironmentPostgreSQL()
+}
+
+func (e *ChefEnvironment) childrenSQL() ([]string, error) {
+	if config.Config.UseMySQL {
+		return e.childrenMySQL()
+	}
+	return e.childrenPostgreSQL()
+}
@@ -23,12 +23,16 @@ import (
 	"github.com/ctdk/goiardi/cookbook"
 	"github.com/ctdk/goiardi/node"
 	"github.com/ctdk/goiardi/util"
+	"github.com/ctdk/goiardi/config"
 	"github.com/ctdk/goiardi/role"
 	"github.com/ctdk/goiardi/log_info"
 	"net/http"
 	"fmt"
 	"encoding/json"
 	"strings"
+	"strconv"
+	"bytes"
+	"time"
 	"github.com/ctdk/goiardi/actor"
 )
 
@@ -42,7 +46,7 @@ func environment_handler(w http.ResponseWriter, r *http.Request){
 
 	opUser, oerr := actor.GetReqUser(r.Header.Get("X-OPS-USERID"))
 	if oerr != nil {
-		JsonErrorReport(w, r, oerr.Error(), oerr.Status())
+		GerrorReport(w, r, oerr)
 		return
 	}
 
@@ -73,7 +77,19 @@ func environment_handler(w http.ResponseWriter, r *http.Request){
 					JsonErrorReport(w, r, "You are not allowed to perform this action", http.StatusForbidden)
 					return
 				}
-				env_list := environment.GetList()
+				offset, limit, paged, perr := listPageParams(r)
+				if perr != nil {
+					JsonErrorReport(w, r, perr.Error(), http.StatusBadRequest)
+					return
+				}
+				var env_list []string
+				if paged {
+					var total int
+					env_list, total = environment.GetListPage(offset, limit)
+					setListTotal(w, total)
+				} else {
+					env_list = environment.GetList()
+				}
 				for _, env := range env_list {
 					item_url := fmt.Sprintf("/environments/%s", env)
 					env_response[env] = util.CustomURL(item_url)
@@ -101,7 +117,7 @@ func environment_handler(w http.ResponseWriter, r *http.Request){
 				var eerr util.Gerror
 				chef_env, eerr = environment.NewFromJson(env_data)
 				if eerr != nil {
-					JsonErrorReport(w, r, eerr.Error(), eerr.Status())
+					GerrorReport(w, r, eerr)
 					return
 				}
 				if err := chef_env.Save(); err != nil {
@@ -118,6 +134,97 @@ func environment_handler(w http.ResponseWriter, r *http.Request){
 				JsonErrorReport(w, r, "Unrecognized method", http.StatusMethodNotAllowed)
 				return
 		}
+	} else if path_array_len == 2 && path_array[1] == "_bulk_constrain" {
+		/* Set the same cookbook constraints in several environments
+		 * at once. */
+		if r.Method != "POST" {
+			JsonErrorReport(w, r, "Unrecognized method", http.StatusMethodNotAllowed)
+			return
+		}
+		if !opUser.IsAdmin() {
+			JsonErrorReport(w, r, "You are not allowed to perform this action", http.StatusForbidden)
+			return
+		}
+		bulk_data, jerr := ParseObjJson(r.Body)
+		if jerr != nil {
+			JsonErrorReport(w, r, jerr.Error(), http.StatusBadRequest)
+			return
+		}
+		env_names, ok := bulk_data["environments"].([]interface{})
+		if !ok || len(env_names) == 0 {
+			JsonErrorReport(w, r, "Field 'environments' invalid", http.StatusBadRequest)
+			return
+		}
+		cookbook_versions, ok := bulk_data["cookbook_versions"].(map[string]interface{})
+		if !ok || len(cookbook_versions) == 0 {
+			JsonErrorReport(w, r, "Field 'cookbook_versions' invalid", http.StatusBadRequest)
+			return
+		}
+		if verr := environment.ValidateCookbookVersions(cookbook_versions); verr != nil {
+			JsonErrorReport(w, r, verr.Error(), http.StatusBadRequest)
+			return
+		}
+		/* Make sure every environment exists before changing any of
+		 * them. */
+		envs := make([]*environment.ChefEnvironment, len(env_names))
+		for i, en := range env_names {
+			env_name, ok := en.(string)
+			if !ok {
+				JsonErrorReport(w, r, "Field 'environments' invalid", http.StatusBadRequest)
+				return
+			}
+			env, err := environment.Get(env_name)
+			if err != nil {
+				JsonErrorReport(w, r, err.Error(), http.StatusNotFound)
+				return
+			}
+			envs[i] = env
+		}
+		for _, env := range envs {
+			if serr := env.SetConstraints(cookbook_versions); serr != nil {
+				env_response[env.Name] = map[string]interface{}{ "error": []string{ serr.Error() } }
+				continue
+			}
+			if serr := env.Save(); serr != nil {
+				env_response[env.Name] = map[string]interface{}{ "error": []string{ serr.Error() } }
+				continue
+			}
+			if lerr := log_info.LogEvent(opUser, env, "modify"); lerr != nil {
+				JsonErrorReport(w, r, lerr.Error(), http.StatusInternalServerError)
+				return
+			}
+			env_response[env.Name] = map[string]interface{}{ "cookbook_versions": env.CookbookVersions }
+		}
+	} else if path_array_len == 2 && path_array[1] == "_diff" {
+		/* Compare two environments, given as the a and b query
+		 * parameters. */
+		if r.Method != "GET" {
+			JsonErrorReport(w, r, "Unrecognized method", http.StatusMethodNotAllowed)
+			return
+		}
+		if opUser.IsValidator() {
+			JsonErrorReport(w, r, "You are not allowed to perform this action", http.StatusForbidden)
+			return
+		}
+		a_name := r.FormValue("a")
+		b_name := r.FormValue("b")
+		if a_name == "" || b_name == "" {
+			JsonErrorReport(w, r, "Both the 'a' and 'b' environments must be given", http.StatusBadRequest)
+			return
+		}
+		env_a, err := environment.Get(a_name)
+		if err != nil {
+			JsonErrorReport(w, r, err.Error(), http.StatusNotFound)
+			return
+		}
+		env_b, err := environment.Get(b_name)
+		if err != nil {
+			JsonErrorReport(w, r, err.Error(), http.StatusNotFound)
+			return
+		}
+		env_response = environment.Diff(env_a, env_b)
+		env_response["a"] = env_a.Name
+		env_response["b"] = env_b.Name
 	} else if path_array_len == 2 {
 		/* All of the 2 element operations return the environment
 		 * object, so we do the json encoding in this block and return 
@@ -126,9 +233,18 @@ func environment_handler(w http.ResponseWriter, r *http.Request){
 		env, err := environment.Get(env_name)
 		del_env := false /* Set this to delete the environment after
 				  * sending the json. */
+		created := false
 		if err != nil {
-			JsonErrorReport(w, r, err.Error(), http.StatusNotFound)
-			return
+			if !config.Config.PutCreates || r.Method != "PUT" {
+				JsonErrorReport(w, r, err.Error(), http.StatusNotFound)
+				return
+			}
+			env, err = environment.New(env_name)
+			if err != nil {
+				GerrorReport(w, r, err)
+				return
+			}
+			created = true
 		}
 		switch r.Method {
 			case "GET", "DELETE":
@@ -171,7 +287,7 @@ func environment_handler(w http.ResponseWriter, r *http.Request){
 				}
 				json_name, sterr := util.ValidateAsString(env_data["name"])
 				if sterr != nil {
-					JsonErrorReport(w, r, sterr.Error(), sterr.Status())
+					GerrorReport(w, r, sterr)
 					return
 				} else if json_name == "" {
 					JsonErrorReport(w, r, "Environment name missing", http.StatusBadRequest)
@@ -186,10 +302,13 @@ func environment_handler(w http.ResponseWriter, r *http.Request){
 						var eerr util.Gerror
 						env, eerr = environment.NewFromJson(env_data)
 						if eerr != nil {
-							JsonErrorReport(w, r, eerr.Error(), eerr.Status())
+							GerrorReport(w, r, eerr)
 							return
 						}
 						w.WriteHeader(http.StatusCreated)
+						/* That's the 201 sent, even if
+						 * the old one never existed. */
+						created = false
 						oldenv, olderr := environment.Get(env_name)
 						if olderr == nil {
 							oldenv.Delete()
@@ -200,31 +319,35 @@ func environment_handler(w http.ResponseWriter, r *http.Request){
 						env_data["name"] = env_name
 					}
 					if err := env.UpdateFromJson(env_data); err != nil {
-						JsonErrorReport(w, r, err.Error(), err.Status())
+						GerrorReport(w, r, err)
 						return
 					}
 				}
 				if err := env.Save(); err != nil {
-					JsonErrorReport(w, r, err.Error(), err.Status())
+					GerrorReport(w, r, err)
 					return
 				}
-				if lerr := log_info.LogEvent(opUser, env, "modify"); lerr != nil {
+				if lerr := log_info.LogEvent(opUser, env, putAction(created)); lerr != nil {
 					JsonErrorReport(w, r, lerr.Error(), http.StatusInternalServerError)
 					return
 				}
+				if created {
+					w.WriteHeader(http.StatusCreated)
+				}
 			default:
 				JsonErrorReport(w, r, "Unrecognized method", http.StatusMethodNotAllowed)
 				return
 		}
-		enc := json.NewEncoder(w)
-		if err := enc.Encode(&env); err != nil {
-			JsonErrorReport(w, r, err.Error(), http.StatusInternalServerError)
-			return
-		}
+		/* Delete before sending the environment back, so a refusal
+		 * can still be reported. */
 		if del_env {
 			err := env.Delete()
 			if err != nil {
-				JsonErrorReport(w, r, err.Error(), http.StatusInternalServerError)
+				if gerr, ok := err.(util.Gerror); ok {
+					GerrorReport(w, r, gerr)
+				} else {
+					JsonErrorReport(w, r, err.Error(), http.StatusInternalServerError)
+				}
 				return
 			}
 			if lerr := log_info.LogEvent(opUser, env, "delete"); lerr != nil {
@@ -232,12 +355,18 @@ func environment_handler(w http.ResponseWriter, r *http.Request){
 				return
 			}
 		}
+		enc := json.NewEncoder(w)
+		if err := enc.Encode(&env); err != nil {
+			JsonErrorReport(w, r, err.Error(), http.StatusInternalServerError)
+			return
+		}
 		return
 	} else if path_array_len == 3 {
 		env_name := path_array[1]
 		op := path_array[2]
 
-		if op == "cookbook_versions" && r.Method != "POST" || op != "cookbook_versions" && r.Method != "GET" {
+		posted := op == "cookbook_versions" || op == "_lockfile" || op == "_install_order"
+		if posted && r.Method != "POST" || !posted && r.Method != "GET" {
 			JsonErrorReport(w, r, "Unrecognized method", http.StatusMethodNotAllowed)
 			return
 		}
@@ -275,19 +404,160 @@ func environment_handler(w http.ResponseWriter, r *http.Request){
 					JsonErrorReport(w, r, "POSTed JSON badly formed.", http.StatusMethodNotAllowed)
 					return
 				}
-				deps, err := cookbook.DependsCookbooks(cb_ver["run_list"].([]string), env.CookbookVersions)
+				constraints, cerr := env.EffectiveCookbookVersions()
+				if cerr != nil {
+					GerrorReport(w, r, cerr)
+					return
+				}
+				/* For debugging, send back what did resolve
+				 * along with what didn't. */
+				if partial, _ := strconv.ParseBool(r.FormValue("partial")); partial {
+					deps, failures, err := cookbook.DependsCookbooksPartial(cb_ver["run_list"].([]string), constraints)
+					if err != nil {
+						GerrorReport(w, r, err)
+						return
+					}
+					if hidden := hiddenCookbook(opUser, deps); hidden != "" {
+						cookbookForbidden(w, r, hidden)
+						return
+					}
+					mirrorDepsURLs(r, deps)
+					partial_response := map[string]interface{}{ "cookbooks": deps, "failures": failures }
+					enc := json.NewEncoder(w)
+					if err := enc.Encode(&partial_response); err != nil {
+						JsonErrorReport(w, r, err.Error(), http.StatusInternalServerError)
+					}
+					return
+				}
+				/* Break down where the time went in a
+				 * Server-Timing header, if asked. The response
+				 * is encoded up front so that's counted too. */
+				if want_timing, _ := strconv.ParseBool(r.FormValue("timing")); want_timing {
+					timing := new(cookbook.DepsolveTiming)
+					deps, err := cookbook.DependsCookbooksTimed(cb_ver["run_list"].([]string), constraints, timing)
+					if err != nil {
+						GerrorReport(w, r, err)
+						return
+					}
+					if hidden := hiddenCookbook(opUser, deps); hidden != "" {
+						cookbookForbidden(w, r, hidden)
+						return
+					}
+					mirrorDepsURLs(r, deps)
+					encode_start := time.Now()
+					buf := new(bytes.Buffer)
+					if err := json.NewEncoder(buf).Encode(&deps); err != nil {
+						JsonErrorReport(w, r, err.Error(), http.StatusInternalServerError)
+						return
+					}
+					timing.Serialize += time.Since(encode_start)
+					w.Header().Set("Server-Timing", timing.ServerTiming())
+					w.Write(buf.Bytes())
+					return
+				}
+				deps, err := cookbook.DependsCookbooks(cb_ver["run_list"].([]string), constraints)
 				if err != nil {
-					JsonErrorReport(w, r, err.Error(), http.StatusPreconditionFailed)
+					GerrorReport(w, r, err)
+					return
+				}
+				if hidden := hiddenCookbook(opUser, deps); hidden != "" {
+					cookbookForbidden(w, r, hidden)
 					return
 				}
+				mirrorDepsURLs(r, deps)
 				/* Need our own encoding here too. */
 				enc := json.NewEncoder(w)
 				if err := enc.Encode(&deps); err != nil {
 					JsonErrorReport(w, r, err.Error(), http.StatusInternalServerError)
 				}
 				return
+			case "_lockfile":
+				/* Like cookbook_versions, but laid out like a
+				 * Berkshelf lockfile. */
+				lock_req, jerr := ParseObjJson(r.Body)
+				if jerr != nil {
+					JsonErrorReport(w, r, jerr.Error(), http.StatusBadRequest)
+					return
+				}
+				rl, ok := lock_req["run_list"].([]string)
+				if !ok {
+					JsonErrorReport(w, r, "POSTed JSON badly formed.", http.StatusBadRequest)
+					return
+				}
+				constraints, cerr := env.EffectiveCookbookVersions()
+				if cerr != nil {
+					GerrorReport(w, r, cerr)
+					return
+				}
+				lockfile, err := cookbook.Lockfile(rl, constraints)
+				if err != nil {
+					GerrorReport(w, r, err)
+					return
+				}
+				if hidden := hiddenCookbook(opUser, lockfile["graph"].(map[string]interface{})); hidden != "" {
+					cookbookForbidden(w, r, hidden)
+					return
+				}
+				enc := json.NewEncoder(w)
+				if err := enc.Encode(&lockfile); err != nil {
+					JsonErrorReport(w, r, err.Error(), http.StatusInternalServerError)
+				}
+				return
+			case "_install_order":
+				/* The resolved cookbooks, dependencies first,
+				 * for tools that install them one at a time. */
+				order_req, jerr := ParseObjJson(r.Body)
+				if jerr != nil {
+					JsonErrorReport(w, r, jerr.Error(), http.StatusBadRequest)
+					return
+				}
+				rl, ok := order_req["run_list"].([]string)
+				if !ok {
+					JsonErrorReport(w, r, "POSTed JSON badly formed.", http.StatusBadRequest)
+					return
+				}
+				constraints, cerr := env.EffectiveCookbookVersions()
+				if cerr != nil {
+					GerrorReport(w, r, cerr)
+					return
+				}
+				install_order, err := cookbook.InstallOrder(rl, constraints)
+				if err != nil {
+					GerrorReport(w, r, err)
+					return
+				}
+				for _, cb := range install_order {
+					if !cookbookVisible(opUser, cb["name"]) {
+						cookbookForbidden(w, r, cb["name"])
+						return
+					}
+				}
+				enc := json.NewEncoder(w)
+				if err := enc.Encode(&install_order); err != nil {
+					JsonErrorReport(w, r, err.Error(), http.StatusInternalServerError)
+				}
+				return
+			case "_validate":
+				unsatisfiable, verr := env.UnsatisfiableConstraints()
+				if verr != nil {
+					GerrorReport(w, r, verr)
+					return
+				}
+				/* An empty list means everything can be
+				 * satisfied. */
+				enc := json.NewEncoder(w)
+				if err := enc.Encode(&unsatisfiable); err != nil {
+					JsonErrorReport(w, r, err.Error(), http.StatusInternalServerError)
+				}
+				return
 			case "cookbooks":
-				env_response = env.AllCookbookHash(num_results)
+				var cerr util.Gerror
+				env_response, cerr = env.AllCookbookHash(num_results)
+				if cerr != nil {
+					GerrorReport(w, r, cerr)
+					return
+				}
+				filterCookbooks(opUser, env_response)
 			case "nodes":
 				node_list, err := node.GetFromEnv(env_name)
 				if err != nil {
@@ -298,7 +568,11 @@ func environment_handler(w http.ResponseWriter, r *http.Request){
 					env_response[chef_node.Name] = util.ObjURL(chef_node) 
 				}
 			case "recipes":
-				env_recipes := env.RecipeList()
+				env_recipes, rerr := env.RecipeList()
+				if rerr != nil {
+					GerrorReport(w, r, rerr)
+					return
+				}
 				/* And... we have to do our own json response
 				 * here. Hmph. */
 				/* TODO: make the JSON encoding stuff its own
@@ -321,6 +595,69 @@ func environment_handler(w http.ResponseWriter, r *http.Request){
 		op := path_array[2]
 		op_name := path_array[3]
 
+		/* Promote cookbook version constraints from another
+		 * environment into this one. */
+		if op == "_promote_from" {
+			if r.Method != "POST" {
+				JsonErrorReport(w, r, "Method not allowed", http.StatusMethodNotAllowed)
+				return
+			}
+			if !opUser.IsAdmin() {
+				JsonErrorReport(w, r, "You are not allowed to perform this action", http.StatusForbidden)
+				return
+			}
+			env, err := environment.Get(env_name)
+			if err != nil {
+				JsonErrorReport(w, r, err.Error(), http.StatusNotFound)
+				return
+			}
+			src_env, err := environment.Get(op_name)
+			if err != nil {
+				JsonErrorReport(w, r, err.Error(), http.StatusNotFound)
+				return
+			}
+			var cookbooks []string
+			if r.ContentLength != 0 {
+				promote_data, jerr := ParseObjJson(r.Body)
+				if jerr != nil {
+					JsonErrorReport(w, r, jerr.Error(), http.StatusBadRequest)
+					return
+				}
+				if cbs, ok := promote_data["cookbooks"]; ok {
+					cbl, ok := cbs.([]interface{})
+					if !ok {
+						JsonErrorReport(w, r, "Field 'cookbooks' invalid", http.StatusBadRequest)
+						return
+					}
+					for _, c := range cbl {
+						cname, ok := c.(string)
+						if !ok {
+							JsonErrorReport(w, r, "Field 'cookbooks' invalid", http.StatusBadRequest)
+							return
+						}
+						cookbooks = append(cookbooks, cname)
+					}
+				}
+			}
+			if perr := env.PromoteFrom(src_env, cookbooks); perr != nil {
+				GerrorReport(w, r, perr)
+				return
+			}
+			if serr := env.Save(); serr != nil {
+				GerrorReport(w, r, serr)
+				return
+			}
+			if lerr := log_info.LogEvent(opUser, env, "modify"); lerr != nil {
+				JsonErrorReport(w, r, lerr.Error(), http.StatusInternalServerError)
+				return
+			}
+			enc := json.NewEncoder(w)
+			if err := enc.Encode(&env.CookbookVersions); err != nil {
+				JsonErrorReport(w, r, err.Error(), http.StatusInternalServerError)
+			}
+			return
+		}
+
 		if r.Method != "GET" {
 			JsonErrorReport(w, r, "Method not allowed", http.StatusMethodNotAllowed)
 			return
@@ -353,6 +690,10 @@ func environment_handler(w http.ResponseWriter, r *http.Request){
 			}
 			env_response["run_list"] = run_list
 		} else if op == "cookbooks" {
+			if !cookbookVisible(opUser, op_name) {
+				cookbookForbidden(w, r, op_name)
+				return
+			}
 			cb, err := cookbook.Get(op_name)
 			if err != nil {
 				JsonErrorReport(w, r, err.Error(), http.StatusNotFound)
@@ -364,7 +705,12 @@ func environment_handler(w http.ResponseWriter, r *http.Request){
 			if num_results == "" {
 				num_results = "all"
 			}
-			env_response[op_name] = cb.ConstrainedInfoHash(num_results, env.CookbookVersions[op_name])
+			constraints, cerr := env.EffectiveCookbookVersions()
+			if cerr != nil {
+				GerrorReport(w, r, cerr)
+				return
+			}
+			env_response[op_name] = cb.ConstrainedInfoHash(num_results, constraints[op_name])
 		} else {
 			/* Not an op we know. */
 			JsonErrorReport(w, r, "Bad request - too many elements in path", http.StatusBadRequest)
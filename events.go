@@ -26,6 +26,7 @@ import (
 	"encoding/json"
 	"strconv"
 	"fmt"
+	"time"
 )
 
 // The whole list
@@ -33,7 +34,7 @@ func event_list_handler(w http.ResponseWriter, r *http.Request){
 	w.Header().Set("Content-Type", "application/json")
 	opUser, oerr := actor.GetReqUser(r.Header.Get("X-OPS-USERID"))
 	if oerr != nil {
-		JsonErrorReport(w, r, oerr.Error(), oerr.Status())
+		GerrorReport(w, r, oerr)
 		return
 	}
 	
@@ -145,7 +146,15 @@ func event_handler(w http.ResponseWriter, r *http.Request){
 	w.Header().Set("Content-Type", "application/json")
 	opUser, oerr := actor.GetReqUser(r.Header.Get("X-OPS-USERID"))
 	if oerr != nil {
-		JsonErrorReport(w, r, oerr.Error(), oerr.Status())
+		GerrorReport(w, r, oerr)
+		return
+	}
+	if r.URL.Path[8:] == "_bulk_get" {
+		event_bulk_get(w, r, opUser)
+		return
+	}
+	if r.URL.Path[8:] == "_histogram" {
+		event_histogram(w, r, opUser)
 		return
 	}
 	event_id, aerr := strconv.Atoi(r.URL.Path[8:])
@@ -196,3 +205,72 @@ func event_handler(w http.ResponseWriter, r *http.Request){
 	}
 	return
 }
+
+/* Fetch a batch of events by id in one go, for piecing together an audit
+ * trail. Events that don't exist are listed under "missing". */
+func event_bulk_get(w http.ResponseWriter, r *http.Request, opUser actor.Actor) {
+	if r.Method != "POST" {
+		JsonErrorReport(w, r, "Unrecognized method", http.StatusMethodNotAllowed)
+		return
+	}
+	if !opUser.IsAdmin() {
+		JsonErrorReport(w, r, "You must be an admin to do that", http.StatusForbidden)
+		return
+	}
+	var req struct {
+		Ids []int `json:"ids"`
+	}
+	dec := json.NewDecoder(r.Body)
+	if err := dec.Decode(&req); err != nil {
+		JsonErrorReport(w, r, "ids must be a list of event ids", http.StatusBadRequest)
+		return
+	}
+	les, missing, err := log_info.GetMany(req.Ids)
+	if err != nil {
+		JsonErrorReport(w, r, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	bulk_response := map[string]interface{}{ "events": les, "missing": missing }
+	enc := json.NewEncoder(w)
+	if err := enc.Encode(&bulk_response); err != nil {
+		JsonErrorReport(w, r, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+/* Count events in time buckets, for graphing how often things change. Takes
+ * an "interval" duration like "1h" or "15m" (default 1h), plus the optional
+ * filters in log_info.HistogramFilters. */
+func event_histogram(w http.ResponseWriter, r *http.Request, opUser actor.Actor) {
+	if r.Method != "GET" {
+		JsonErrorReport(w, r, "Unrecognized method", http.StatusMethodNotAllowed)
+		return
+	}
+	if !opUser.IsAdmin() {
+		JsonErrorReport(w, r, "You must be an admin to do that", http.StatusForbidden)
+		return
+	}
+	interval := time.Hour
+	if i := r.FormValue("interval"); i != "" {
+		var err error
+		interval, err = time.ParseDuration(i)
+		if err != nil {
+			JsonErrorReport(w, r, "invalid interval", http.StatusBadRequest)
+			return
+		}
+	}
+	filters := make(map[string]string)
+	for _, f := range log_info.HistogramFilters {
+		if v := r.FormValue(f); v != "" {
+			filters[f] = v
+		}
+	}
+	histogram, err := log_info.Histogram(interval, filters)
+	if err != nil {
+		JsonErrorReport(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+	enc := json.NewEncoder(w)
+	if err := enc.Encode(&histogram); err != nil {
+		JsonErrorReport(w, r, err.Error(), http.StatusInternalServerError)
+	}
+}
@@ -21,19 +21,34 @@ package main
 
 import (
 	"net/http"
+	"github.com/ctdk/goiardi/actor"
+	"github.com/ctdk/goiardi/cookbook"
 	"github.com/ctdk/goiardi/filestore"
+	"github.com/ctdk/goiardi/config"
 	"fmt"
 	"encoding/json"
+	"strings"
 )
 
 func file_store_handler(w http.ResponseWriter, r *http.Request){
 	/* We *don't* always set the the content-type to application/json here,
 	 * for obvious reasons. Still do for the PUT/POST though. */
+	if len(r.URL.Path) <= 12 {
+		JsonErrorReport(w, r, "No file checksum given.", http.StatusBadRequest)
+		return
+	}
 	chksum := r.URL.Path[12:]
+
+	/* Which cookbook versions use this file? Unlike the file itself this
+	 * needs an admin. */
+	if strings.HasSuffix(chksum, "/_refs") {
+		file_refs_handler(w, r, strings.TrimSuffix(chksum, "/_refs"))
+		return
+	}
 	
-	/* Eventually, both local storage (in-memory or on disk, depending) or
-	 * uploading to s3 or a similar cloud storage provider needs to be
-	 * supported. */
+	/* Files may be stored in memory, on disk, or in s3, depending on
+	 * configuration. The filestore takes care of that, and with s3 we
+	 * just proxy the file data through here. */
 	switch r.Method {
 		case "GET":
 			w.Header().Set("Content-Type", "application/x-binary")
@@ -46,9 +61,28 @@ func file_store_handler(w http.ResponseWriter, r *http.Request){
 		case "PUT", "POST": /* Seems like for file uploads we ought to
 				     * support POST too. */
 			w.Header().Set("Content-Type", "application/json")
+			upload_sem, ok := acquireUploadSlot(w, r)
+			if !ok {
+				return
+			}
+			defer releaseUploadSlot(upload_sem)
 			/* Need to distinguish file already existing and some
 			 * sort of error with uploading the file. */
 			if file_store, _ := filestore.Get(chksum); file_store != nil {
+				/* If asked to, make sure the upload really is
+				 * the same file, and not a different file
+				 * with the same checksum or a bad upload. */
+				if config.Config.VerifyDuplicateUploads {
+					same, err := file_store.Matches(r.Body, r.ContentLength)
+					if err != nil {
+						JsonErrorReport(w, r, err.Error(), http.StatusInternalServerError)
+						return
+					}
+					if !same {
+						JsonErrorReport(w, r, fmt.Sprintf("Uploaded file does not match the existing file with checksum %s.", chksum), http.StatusConflict)
+						return
+					}
+				}
 				file_err := fmt.Errorf("File with checksum %s already exists.", chksum)
 				/* Send status OK. It seems chef-pedant at least
 				 * tries to upload files twice for some reason.
@@ -77,3 +111,36 @@ func file_store_handler(w http.ResponseWriter, r *http.Request){
 			JsonErrorReport(w, r, "Unrecognized method!", http.StatusMethodNotAllowed)
 	}
 }
+
+func file_refs_handler(w http.ResponseWriter, r *http.Request, chksum string) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != "GET" {
+		JsonErrorReport(w, r, "Unrecognized method!", http.StatusMethodNotAllowed)
+		return
+	}
+	opUser, oerr := actor.GetReqUser(r.Header.Get("X-OPS-USERID"))
+	if oerr != nil {
+		GerrorReport(w, r, oerr)
+		return
+	}
+	if !opUser.IsAdmin() {
+		JsonErrorReport(w, r, "You are not allowed to perform this action", http.StatusForbidden)
+		return
+	}
+	refs := cookbook.FileRefs(chksum)
+	_, ferr := filestore.Get(chksum)
+	if len(refs) == 0 && ferr != nil {
+		JsonErrorReport(w, r, ferr.Error(), http.StatusNotFound)
+		return
+	}
+	refs_response := map[string]interface{}{
+		"checksum": chksum,
+		"in_filestore": ferr == nil,
+		"count": len(refs),
+		"refs": refs,
+	}
+	enc := json.NewEncoder(w)
+	if err := enc.Encode(&refs_response); err != nil {
+		JsonErrorReport(w, r, err.Error(), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,75 @@
+/* Storage backends for file data in the filestore. */
+
+/*
+ * Copyright (c) 2013-2014, Jeremy Bingham (<jbingham@gmail.com>)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package filestore
+
+import (
+	"github.com/ctdk/goiardi/config"
+	"io/ioutil"
+	"os"
+	"path"
+)
+
+// Backend is implemented by the places file data can be kept outside of the
+// in-memory data store, like a local directory or S3. The filestore still
+// keeps track of which checksums exist; the backend just holds the bytes.
+type Backend interface {
+	// Get the data for the file with the given checksum.
+	Get(chksum string) ([]byte, error)
+	// Store the data for the file with the given checksum.
+	Put(chksum string, data []byte) error
+	// Remove the file with the given checksum.
+	Delete(chksum string) error
+}
+
+type localBackend struct {
+	dir string
+}
+
+// Returns the backend file data is stored in, based on the configuration. If
+// file data is being kept in memory, returns nil.
+func GetBackend() Backend {
+	if config.Config.UseS3 {
+		return newS3Backend(&config.Config.S3)
+	}
+	if config.Config.LocalFstoreDir != "" {
+		return &localBackend{ dir: config.Config.LocalFstoreDir }
+	}
+	return nil
+}
+
+func (l *localBackend) Get(chksum string) ([]byte, error) {
+	return ioutil.ReadFile(path.Join(l.dir, chksum))
+}
+
+func (l *localBackend) Put(chksum string, data []byte) error {
+	fp, err := os.Create(path.Join(l.dir, chksum))
+	if err != nil {
+		return err
+	}
+	defer fp.Close()
+	_, err = fp.Write(data)
+	if err != nil {
+		return err
+	}
+	return fp.Close()
+}
+
+func (l *localBackend) Delete(chksum string) error {
+	return os.Remove(path.Join(l.dir, chksum))
+}
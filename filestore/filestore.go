@@ -23,18 +23,19 @@
 // rather than the file name.
 //
 // If config.Config.LocalFstoreDir is != "", the content of the files will be
-// stored in that directory.
+// stored in that directory. If config.Config.UseS3 is set, the content of the
+// files will be stored in the configured S3 bucket instead.
 package filestore
 
 import (
+	"bytes"
 	"io"
+	"io/ioutil"
 	"fmt"
 	"github.com/ctdk/goiardi/data_store"
 	"crypto/md5"
 	"github.com/ctdk/goiardi/config"
 	"database/sql"
-	"os"
-	"path"
 	"git.tideland.biz/goas/logger"
 )
 
@@ -81,12 +82,40 @@ func New(chksum string, data io.ReadCloser, data_length int64) (*FileStore, erro
 	return filestore, nil
 }
 
+// Check whether the data read from the io.ReadCloser is the same as the file's
+// data, byte for byte. Useful for catching uploads that claim to have the same
+// checksum as an existing file but don't actually have the same contents.
+func (f *FileStore) Matches(data io.ReadCloser, data_length int64) (bool, error) {
+	if f.Data == nil {
+		return false, nil
+	}
+	/* Chunked uploads don't say how long they are. Reading one byte more
+	 * than the stored file is enough to tell if they're longer, without
+	 * reading however much more there is. */
+	if data_length < 0 {
+		file_data, err := ioutil.ReadAll(io.LimitReader(data, int64(len(*f.Data)) + 1))
+		if err != nil {
+			return false, err
+		}
+		return bytes.Equal(*f.Data, file_data), nil
+	}
+	if int64(len(*f.Data)) != data_length {
+		return false, nil
+	}
+	file_data := make([]byte, data_length)
+	if n, err := io.ReadFull(data, file_data); err != nil {
+		read_err := fmt.Errorf("Only read %d bytes (out of %d, supposedly) from io.ReadCloser: %s", n, data_length, err.Error())
+		return false, read_err
+	}
+	return bytes.Equal(*f.Data, file_data), nil
+}
+
 func Get(chksum string) (*FileStore, error){
 	var filestore *FileStore
 	var found bool
-	if config.Config.UseMySQL {
+	if config.Config.UseSQL {
 		var err error
-		filestore, err = getMySQL(chksum)
+		filestore, err = getSQL(chksum)
 		if err != nil {
 			if err == sql.ErrNoRows {
 				found = false
@@ -108,60 +137,48 @@ func Get(chksum string) (*FileStore, error){
 		err := fmt.Errorf("File with checksum %s not found", chksum)
 		return nil, err
 	}
-	if config.Config.LocalFstoreDir != "" {
-		/* File data is stored on disk */
-		chkPath := path.Join(config.Config.LocalFstoreDir, chksum)
-		
-		fp, err := os.Open(chkPath)
+	if backend := GetBackend(); backend != nil {
+		/* File data is stored on disk or in S3. The data's put in a
+		 * copy, so the one in the data store stays without it. */
+		fdata, err := backend.Get(chksum)
 		if err != nil {
 			return nil, err
 		}
-		defer fp.Close()
-		stat, sterr := fp.Stat()
-		if sterr != nil {
-			return nil, sterr
-		}
-		fdata := make([]byte, stat.Size())
-		n, fperr := fp.Read(fdata)
-		if fperr != nil {
-			return nil, fperr
-		} else if int64(n) != stat.Size() {
-			err = fmt.Errorf("only %d bytes were read from the expected %d", n, stat.Size())
-			return nil, err
-		}
-		filestore.Data = &fdata
+		filestore = &FileStore{ Chksum: filestore.Chksum, Data: &fdata }
 	}
 	return filestore, nil
 }
 
 func (f *FileStore) Save() error {
-	if config.Config.UseMySQL {
-		err := f.saveMySQL()
-		if err != nil {
+	/* Store the data first, so a file whose data couldn't be stored isn't
+	 * recorded as being there. */
+	backend := GetBackend()
+	if backend != nil {
+		if err := backend.Put(f.Chksum, *f.Data); err != nil {
 			return err
 		}
-	} else {
-		ds := data_store.New()
-		ds.Set("filestore", f.Chksum, f)
 	}
-	if config.Config.LocalFstoreDir != "" {
-		fp, err := os.Create(path.Join(config.Config.LocalFstoreDir, f.Chksum))
+	if config.Config.UseSQL {
+		err := f.saveSQL()
 		if err != nil {
 			return err
 		}
-		defer fp.Close()
-		_, err = fp.Write(*f.Data)
-		if err != nil {
-			return err
+	} else {
+		ds := data_store.New()
+		if backend != nil {
+			/* The backend has the data, so the data store only
+			 * needs to know the file exists. */
+			ds.Set("filestore", f.Chksum, &FileStore{ Chksum: f.Chksum })
+		} else {
+			ds.Set("filestore", f.Chksum, f)
 		}
-		return fp.Close()
 	}
 	return nil
 }
 
 func (f *FileStore) Delete() error {
-	if config.Config.UseMySQL {
-		err := f.deleteMySQL()
+	if config.Config.UseSQL {
+		err := f.deleteSQL()
 		if err != nil {
 			return err
 		}
@@ -170,8 +187,8 @@ func (f *FileStore) Delete() error {
 		ds.Delete("filestore", f.Chksum)
 	}
 
-	if config.Config.LocalFstoreDir != "" {
-		err := os.Remove(path.Join(config.Config.LocalFstoreDir, f.Chksum))
+	if backend := GetBackend(); backend != nil {
+		err := backend.Delete(f.Chksum)
 		if err != nil {
 			return err
 		}
@@ -182,8 +199,8 @@ func (f *FileStore) Delete() error {
 // Get a list of files that have been uploaded.
 func GetList() []string {
 	var file_list []string
-	if config.Config.UseMySQL {
-		file_list = getListMySQL()
+	if config.Config.UseSQL {
+		file_list = getListSQL()
 	} else {
 		ds := data_store.New()
 		file_list = ds.GetList("filestore")
@@ -193,8 +210,18 @@ func GetList() []string {
 
 // Delete all the checksum hashes given from the filestore.
 func DeleteHashes(file_hashes []string) {
-	if config.Config.UseMySQL {
-		deleteHashesMySQL(file_hashes)
+	if config.Config.UseSQL {
+		deleteHashesSQL(file_hashes)
+		/* Delete takes care of the backend in memory mode, but
+		 * deleteHashesSQL doesn't. */
+		if backend := GetBackend(); backend != nil {
+			for _, fh := range file_hashes {
+				err := backend.Delete(fh)
+				if err != nil {
+					logger.Errorf(err.Error())
+				}
+			}
+		}
 	} else {
 		for _, ff := range file_hashes {
 		del_file, err := Get(ff)
@@ -211,12 +238,4 @@ func DeleteHashes(file_hashes []string) {
 			}
 		}
 	}
-	if config.Config.LocalFstoreDir != "" {
-		for _, fh := range file_hashes {
-			err := os.Remove(path.Join(config.Config.LocalFstoreDir, fh))
-			if err != nil {
-				logger.Errorf(err.Error())
-			}
-		}
-	}
 }
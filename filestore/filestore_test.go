@@ -0,0 +1,181 @@
+/*
+ * Copyright (c) 2013-2014, Jeremy Bingham (<jbingham@gmail.com>)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package filestore
+
+import (
+	"crypto/md5"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"testing"
+	"github.com/ctdk/goiardi/config"
+	"github.com/ctdk/goiardi/data_store"
+)
+
+func newTestFile(t *testing.T, contents string) *FileStore {
+	chksum := fmt.Sprintf("%x", md5.Sum([]byte(contents)))
+	f, err := New(chksum, ioutil.NopCloser(strings.NewReader(contents)), int64(len(contents)))
+	if err != nil {
+		t.Fatalf("Creating file failed: %s", err.Error())
+	}
+	return f
+}
+
+/* With a backend, only the checksum should be kept in the data store. */
+func chkStoredWithoutData(t *testing.T, chksum string) {
+	ds := data_store.New()
+	f, found := ds.Get("filestore", chksum)
+	if !found {
+		t.Errorf("File %s wasn't in the data store", chksum)
+		return
+	}
+	if f.(*FileStore).Data != nil {
+		t.Errorf("File %s's data was kept in the data store as well as the backend", chksum)
+	}
+}
+
+func TestLocalBackend(t *testing.T) {
+	dir, err := ioutil.TempDir("", "goiardi-filestore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	config.Config.LocalFstoreDir = dir
+	defer func() { config.Config.LocalFstoreDir = "" }()
+
+	f := newTestFile(t, "local file contents")
+	if err := f.Save(); err != nil {
+		t.Fatalf("Saving to the local backend failed: %s", err.Error())
+	}
+	if b, err := ioutil.ReadFile(path.Join(dir, f.Chksum)); err != nil || string(b) != "local file contents" {
+		t.Errorf("File wasn't written to the local filestore dir properly: '%s' %v", string(b), err)
+	}
+	chkStoredWithoutData(t, f.Chksum)
+
+	g, err := Get(f.Chksum)
+	if err != nil {
+		t.Fatalf("Getting the file back failed: %s", err.Error())
+	}
+	if string(*g.Data) != "local file contents" {
+		t.Errorf("Got '%s' back from the local backend", string(*g.Data))
+	}
+	chkStoredWithoutData(t, f.Chksum)
+
+	if err := g.Delete(); err != nil {
+		t.Errorf("Deleting from the local backend failed: %s", err.Error())
+	}
+	if _, err := os.Stat(path.Join(dir, f.Chksum)); !os.IsNotExist(err) {
+		t.Errorf("File was still in the local filestore dir after being deleted")
+	}
+	if _, err := Get(f.Chksum); err == nil {
+		t.Errorf("File could still be fetched after being deleted")
+	}
+}
+
+/* Just enough of S3 to store and fetch objects, checking that requests are
+ * signed. */
+type fakeS3 struct {
+	sync.Mutex
+	objects map[string][]byte
+	unsigned int
+}
+
+func (s *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.Lock()
+	defer s.Unlock()
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "AWS4-HMAC-SHA256 Credential=testkey/") || !strings.Contains(auth, "/us-west-2/s3/aws4_request") || r.Header.Get("X-Amz-Date") == "" {
+		s.unsigned++
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	body, _ := ioutil.ReadAll(r.Body)
+	if r.Header.Get("X-Amz-Content-Sha256") != sha256Hex(body) {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	switch r.Method {
+		case "PUT":
+			s.objects[r.URL.Path] = body
+		case "GET":
+			obj, ok := s.objects[r.URL.Path]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			w.Write(obj)
+		case "DELETE":
+			delete(s.objects, r.URL.Path)
+			w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func TestS3Backend(t *testing.T) {
+	fake := &fakeS3{ objects: make(map[string][]byte) }
+	server := httptest.NewServer(fake)
+	defer server.Close()
+	config.Config.UseS3 = true
+	config.Config.S3 = config.S3Conf{ Bucket: "goiardi", Region: "us-west-2", Endpoint: server.URL, Prefix: "files/", AccessKey: "testkey", SecretKey: "testsecret" }
+	defer func() {
+		config.Config.UseS3 = false
+		config.Config.S3 = config.S3Conf{}
+	}()
+
+	f := newTestFile(t, "s3 file contents")
+	if err := f.Save(); err != nil {
+		t.Fatalf("Saving to S3 failed: %s", err.Error())
+	}
+	key := "/goiardi/files/" + f.Chksum
+	if string(fake.objects[key]) != "s3 file contents" {
+		t.Errorf("Expected the file to be PUT to %s, but S3 has %v", key, fake.objects)
+	}
+	chkStoredWithoutData(t, f.Chksum)
+
+	g, err := Get(f.Chksum)
+	if err != nil {
+		t.Fatalf("Getting the file back from S3 failed: %s", err.Error())
+	}
+	if string(*g.Data) != "s3 file contents" {
+		t.Errorf("Got '%s' back from S3", string(*g.Data))
+	}
+
+	if err := g.Delete(); err != nil {
+		t.Errorf("Deleting from S3 failed: %s", err.Error())
+	}
+	if _, ok := fake.objects[key]; ok {
+		t.Errorf("File was still in S3 after being deleted")
+	}
+	if fake.unsigned != 0 {
+		t.Errorf("%d requests to S3 weren't signed properly", fake.unsigned)
+	}
+
+	/* Errors from S3 are passed back. */
+	config.Config.S3.AccessKey = "wrongkey"
+	h := newTestFile(t, "refused file contents")
+	if err := h.Save(); err == nil {
+		t.Errorf("Saving should have failed when S3 refused the request")
+	}
+	config.Config.S3.AccessKey = "testkey"
+	if _, err := Get(h.Chksum); err == nil {
+		t.Errorf("A file S3 refused to store was recorded as being in the filestore")
+	}
+}
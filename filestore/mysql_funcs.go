@@ -79,32 +79,6 @@ func (f *FileStore) deleteMySQL() error {
 	return nil
 }
 
-func getListMySQL() []string {
-	file_list := make([]string, 0)
-	stmt, perr := data_store.Dbh.Prepare("SELECT checksum FROM file_checksums")
-	if perr != nil {
-		if perr != sql.ErrNoRows {
-			log.Fatal(perr)
-		}
-		stmt.Close()
-		return file_list
-	}
-	rows, err := stmt.Query()
-	for rows.Next() {
-		var chksum string
-		err = rows.Scan(&chksum)
-		if err != nil {
-			log.Fatal(err)
-		}
-		file_list = append(file_list, chksum)
-	}
-	rows.Close()
-	if err = rows.Err(); err != nil {
-		log.Fatal(err)
-	}
-	return file_list
-}
-
 func deleteHashesMySQL(file_hashes []string) {
 	if len(file_hashes) == 0 {
 		return // nothing to do
@@ -0,0 +1,98 @@
+/*
+ * Copyright (c) 2013-2014, Jeremy Bingham (<jbingham@gmail.com>)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package filestore
+
+import (
+	"github.com/ctdk/goiardi/data_store"
+	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+	"git.tideland.biz/goas/logger"
+)
+
+func getPostgreSQL(chksum string) (*FileStore, error) {
+	filestore := new(FileStore)
+	stmt, err := data_store.Dbh.Prepare("SELECT checksum FROM file_checksums WHERE checksum = $1")
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+	err = stmt.QueryRow(chksum).Scan(&filestore.Chksum)
+	if err != nil {
+		return nil, err
+	}
+	return filestore, nil
+}
+
+func (f *FileStore) savePostgreSQL() error {
+	tx, err := data_store.Dbh.Begin()
+	if err != nil {
+		return err
+	}
+	// if the checksum's already there we're just updating the file, and
+	// don't need a new row
+	_, err = tx.Exec("INSERT INTO file_checksums (checksum) VALUES ($1) ON CONFLICT DO NOTHING", f.Chksum)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	tx.Commit()
+	return nil
+}
+
+func (f *FileStore) deletePostgreSQL() error {
+	tx, err := data_store.Dbh.Begin()
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("DELETE FROM file_checksums WHERE checksum = $1", f.Chksum)
+	if err != nil {
+		terr := tx.Rollback()
+		if terr != nil {
+			err = fmt.Errorf("deleting file %s had an error '%s', and then rolling back the transaction gave another error '%s'", f.Chksum, err.Error(), terr.Error())
+		}
+		return err
+	}
+	tx.Commit()
+	return nil
+}
+
+func deleteHashesPostgreSQL(file_hashes []string) {
+	if len(file_hashes) == 0 {
+		return // nothing to do
+	}
+	tx, err := data_store.Dbh.Begin()
+	if err != nil {
+		log.Fatal(err)
+	}
+	placeholders := make([]string, len(file_hashes))
+	del_args := make([]interface{}, len(file_hashes))
+	for i, v := range file_hashes {
+		placeholders[i] = fmt.Sprintf("$%d", i + 1)
+		del_args[i] = v
+	}
+	delete_query := "DELETE FROM file_checksums WHERE checksum IN(" + strings.Join(placeholders, ",") + ")"
+	_, err = tx.Exec(delete_query, del_args...)
+	if err != nil && err != sql.ErrNoRows {
+		logger.Debugf("Error %s trying to delete hashes", err.Error())
+		tx.Rollback()
+		return
+	}
+	tx.Commit()
+	return
+}
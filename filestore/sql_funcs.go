@@ -0,0 +1,79 @@
+/*
+ * Copyright (c) 2013-2014, Jeremy Bingham (<jbingham@gmail.com>)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package filestore
+
+import (
+	"github.com/ctdk/goiardi/config"
+	"github.com/ctdk/goiardi/data_store"
+	"database/sql"
+	"log"
+)
+
+func getListSQL() []string {
+	file_list := make([]string, 0)
+	stmt, perr := data_store.Dbh.Prepare("SELECT checksum FROM file_checksums")
+	if perr != nil {
+		if perr != sql.ErrNoRows {
+			log.Fatal(perr)
+		}
+		stmt.Close()
+		return file_list
+	}
+	rows, err := stmt.Query()
+	for rows.Next() {
+		var chksum string
+		err = rows.Scan(&chksum)
+		if err != nil {
+			log.Fatal(err)
+		}
+		file_list = append(file_list, chksum)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		log.Fatal(err)
+	}
+	return file_list
+}
+
+func getSQL(chksum string) (*FileStore, error) {
+	if config.Config.UseMySQL {
+		return getMySQL(chksum)
+	}
+	return getPostgreSQL(chksum)
+}
+
+func (f *FileStore) saveSQL() error {
+	if config.Config.UseMySQL {
+		return f.saveMySQL()
+	}
+	return f.savePostgreSQL()
+}
+
+func (f *FileStore) deleteSQL() error {
+	if config.Config.UseMySQL {
+		return f.deleteMySQL()
+	}
+	return f.deletePostgreSQL()
+}
+
+func deleteHashesSQL(file_hashes []string) {
+	if config.Config.UseMySQL {
+		deleteHashesMySQL(file_hashes)
+	} else {
+		deleteHashesPostgreSQL(file_hashes)
+	}
+}
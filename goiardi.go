@@ -35,6 +35,7 @@ import (
 	"github.com/ctdk/goiardi/node"
 	"github.com/ctdk/goiardi/role"
 	"github.com/ctdk/goiardi/sandbox"
+	"github.com/ctdk/goiardi/saved_search"
 	"github.com/ctdk/goiardi/log_info"
 	"fmt"
 	"os"
@@ -46,17 +47,31 @@ import (
 	"strings"
 	"git.tideland.biz/goas/logger"
 	"compress/gzip"
+	"unicode"
+	"sync"
 )
 
 type InterceptHandler struct {} // Doesn't need to do anything, just sit there.
 
+/* Counting semaphore for limiting the number of requests handled at once. Nil
+ * if there's no limit. Replaced when a config reload changes the limit, so
+ * use currentRequestSem() to get it. */
+var requestSem chan bool
+
+/* Guards requestSem and uploadSem. */
+var semLock sync.RWMutex
+
 func main(){
 	config.ParseConfigOptions()
 
 	/* Here goes nothing, db... */
-	if config.Config.UseMySQL {
+	if config.Config.UseSQL {
 		var derr error
-		data_store.Dbh, derr = data_store.ConnectDB("mysql", config.Config.MySQL)
+		if config.Config.UseMySQL {
+			data_store.Dbh, derr = data_store.ConnectDB("mysql", config.Config.MySQL)
+		} else {
+			data_store.Dbh, derr = data_store.ConnectDB("postgres", config.Config.PostgreSQL)
+		}
 		if derr != nil {
 			logger.Criticalf(derr.Error())
 			os.Exit(1)
@@ -64,6 +79,10 @@ func main(){
 	}
 
 	gobRegister()
+	if err := data_store.SetLockGranularity(config.Config.DataStoreLocking); err != nil {
+		logger.Criticalf(err.Error())
+		os.Exit(1)
+	}
 	ds := data_store.New()
 	if config.Config.FreezeData {
 		if config.Config.DataStoreFile != "" {
@@ -72,6 +91,10 @@ func main(){
 				logger.Criticalf(uerr.Error())
 				os.Exit(1)
 			}
+			/* The running counts of things like cookbook
+			 * versions aren't saved with the data store, so
+			 * count them up again now that it's loaded. */
+			data_store.Recount()
 		}
 		ierr := indexer.LoadIndex(config.Config.IndexFile)
 		if ierr != nil {
@@ -79,43 +102,20 @@ func main(){
 			os.Exit(1)
 		}
 	}
+	cookbook.IndexFileRefs()
 	setSaveTicker()
 	setLogEventPurgeTicker()
+	setStaleNodeTicker()
+	setLimitSems()
+
+	notice.set(config.Config.Notice)
 
 	/* Create default clients and users. Currently chef-validator,
 	 * chef-webui, and admin. */
 	createDefaultActors()
 	handleSignals()
 
-	/* Register the various handlers, found in their own source files. */
-	http.HandleFunc("/authenticate_user", authenticate_user_handler)
-	http.HandleFunc("/clients", list_handler)
-	http.HandleFunc("/clients/", client_handler)
-	http.HandleFunc("/cookbooks", cookbook_handler)
-	http.HandleFunc("/cookbooks/", cookbook_handler)
-	http.HandleFunc("/data", data_handler)
-	http.HandleFunc("/data/", data_handler)
-	http.HandleFunc("/environments", environment_handler)
-	http.HandleFunc("/environments/", environment_handler)
-	http.HandleFunc("/nodes", list_handler)
-	http.HandleFunc("/nodes/", node_handler)
-	http.HandleFunc("/principals/", principal_handler)
-	http.HandleFunc("/roles", list_handler)
-	http.HandleFunc("/roles/", role_handler)
-	http.HandleFunc("/sandboxes", sandbox_handler)
-	http.HandleFunc("/sandboxes/", sandbox_handler)
-	http.HandleFunc("/search", search_handler)
-	http.HandleFunc("/search/", search_handler)
-	http.HandleFunc("/search/reindex", reindexHandler)
-	http.HandleFunc("/users", list_handler)
-	http.HandleFunc("/users/", user_handler)
-	http.HandleFunc("/file_store/", file_store_handler)
-	http.HandleFunc("/events", event_list_handler)
-	http.HandleFunc("/events/", event_handler)
-	http.HandleFunc("/reports/", report_handler)
-
-	/* TODO: figure out how to handle the root & not found pages */
-	http.HandleFunc("/", root_handler)
+	registerHandlers()
 
 	listen_addr := config.ListenAddr()
 	var err error
@@ -140,9 +140,11 @@ func (h *InterceptHandler) ServeHTTP(w http.ResponseWriter, r *http.Request){
 	 * worked for GETs, but since it was breaking POSTs and screwing with 
 	 * GETs with query params, we just clean up the path and move on. */
 
+	r = withClientIP(r)
+
 	/* log the URL */
 	// TODO: set this to verbosity level 4 or so
-	logger.Debugf("Serving %s -- %s\n", r.URL.Path, r.Method)
+	logger.Debugf("Serving %s -- %s from %s\n", r.URL.Path, r.Method, requestClientIP(r))
 
 	if r.Method != "CONNECT" { 
 		if p := cleanPath(r.URL.Path); p != r.URL.Path{
@@ -150,8 +152,31 @@ func (h *InterceptHandler) ServeHTTP(w http.ResponseWriter, r *http.Request){
 		}
 	}
 
-	/* Make configurable, I guess, but Chef wants it to be 1000000 */
-	if r.ContentLength > 1000000 {
+	/* Limit how many requests are in flight at once, if configured to.
+	 * The root path is left alone so it can be used as a health check. */
+	if sem := currentRequestSem(); sem != nil && r.URL.Path != "/" {
+		_, timeout := config.RequestLimits()
+		select {
+			case sem <- true:
+				defer func() { <-sem }()
+			case <-time.After(timeout):
+				w.Header().Set("Content-Type", "application/json")
+				logger.Warningf("Too many concurrent requests, rejecting %s %s from %s", r.Method, r.URL.Path, requestClientIP(r))
+				JsonErrorReport(w, r, "Too many requests in progress, try again later", http.StatusServiceUnavailable)
+				return
+		}
+	}
+
+	/* Make configurable, I guess, but Chef wants it to be 1000000. Cookbook
+	 * imports carry all of the cookbook's files, though, so they have
+	 * their own limit. Authentication reads the whole body to check its
+	 * hash, so the import limit has to be enforced here, before anyone's
+	 * known to be an admin, too. */
+	if r.URL.Path == "/cookbooks/_import" {
+		if !importBodyLimit(w, r) {
+			return
+		}
+	} else if r.ContentLength > 1000000 {
 		http.Error(w, "Content-length too long!", http.StatusRequestEntityTooLarge)
 		return
 	}
@@ -161,6 +186,9 @@ func (h *InterceptHandler) ServeHTTP(w http.ResponseWriter, r *http.Request){
 	w.Header().Set("X-Chef-Version", config.ChefVersion)
 	api_info := fmt.Sprintf("flavor=osc;version:%s;goiardi=%s", config.ChefVersion, config.Version)
 	w.Header().Set("X-Ops-API-Info", api_info)
+	if n := notice.get(); n != "" {
+		w.Header().Set("X-Goiardi-Notice", n)
+	}
 
 	user_id := r.Header.Get("X-OPS-USERID")
 	if rs := r.Header.Get("X-Ops-Request-Source"); rs == "web" {
@@ -186,13 +214,19 @@ func (h *InterceptHandler) ServeHTTP(w http.ResponseWriter, r *http.Request){
 	/* Only perform the authorization check if that's configured. Bomb with
 	 * an error if the check of the headers, timestamps, etc. fails. */
 	/* No clue why /principals doesn't require authorization. Hrmph. */
-	if config.Config.UseAuth && !strings.HasPrefix(r.URL.Path, "/file_store") && !(strings.HasPrefix(r.URL.Path, "/principals") && r.Method == "GET") {
+	/* File downloads don't need it either, but the _refs report on a
+	 * file does. */
+	unsigned_file := strings.HasPrefix(r.URL.Path, "/file_store") && !strings.HasSuffix(r.URL.Path, "/_refs")
+	if config.Config.UseAuth && !unsigned_file && !(strings.HasPrefix(r.URL.Path, "/principals") && r.Method == "GET") {
 		herr := authentication.CheckHeader(user_id, r)
 		if herr != nil {
 			w.Header().Set("Content-Type", "application/json")
-			logger.Errorf("Authorization failure: %s\n", herr.Error())
+			logger.Errorf("Authorization failure from %s: %s\n", requestClientIP(r), herr.Error())
 			//http.Error(w, herr.Error(), herr.Status())
-			JsonErrorReport(w, r, herr.Error(), herr.Status())
+			if authDebugReport(w, r, herr) {
+				return
+			}
+			GerrorReport(w, r, herr)
 			return
 		}
 	}
@@ -209,24 +243,71 @@ func (h *InterceptHandler) ServeHTTP(w http.ResponseWriter, r *http.Request){
 		r.Body = reader
 	}
 
+	/* HEAD responses have no body to compress. */
+	if config.Config.GzipResponses && r.Method != "HEAD" && acceptsGzip(r) {
+		w.Header().Add("Vary", "Accept-Encoding")
+		gw := newGzipResponseWriter(w, config.Config.GzipLevel)
+		defer gw.close()
+		w = gw
+	}
+
 	http.DefaultServeMux.ServeHTTP(w, r)
 }
 
+/* Register the various handlers, found in their own source files. Since
+ * cleanPath strips trailing slashes, anything registered with one has to be
+ * registered without one too, or requests for it would be redirected to the
+ * path with the slash, which would be cleaned right back. */
+func registerHandlers() {
+	http.HandleFunc("/_counts", adminIPCheck(counts_handler))
+	http.HandleFunc("/authenticate_user", authenticate_user_handler)
+	http.HandleFunc("/clients", list_handler)
+	http.HandleFunc("/clients/", client_handler)
+	http.HandleFunc("/cookbooks", cookbook_handler)
+	http.HandleFunc("/cookbooks/", cookbook_handler)
+	http.HandleFunc("/data", data_handler)
+	http.HandleFunc("/data/", data_handler)
+	http.HandleFunc("/environments", environment_handler)
+	http.HandleFunc("/environments/", environment_handler)
+	http.HandleFunc("/nodes", list_handler)
+	http.HandleFunc("/nodes/", node_handler)
+	http.HandleFunc("/notice", notice_handler)
+	http.HandleFunc("/principals", principal_handler)
+	http.HandleFunc("/principals/", principal_handler)
+	http.HandleFunc("/roles", list_handler)
+	http.HandleFunc("/roles/", role_handler)
+	http.HandleFunc("/sandboxes", sandbox_handler)
+	http.HandleFunc("/sandboxes/", sandbox_handler)
+	http.HandleFunc("/search", search_handler)
+	http.HandleFunc("/search/", search_handler)
+	http.HandleFunc("/search/reindex", adminIPCheck(reindexHandler))
+	http.HandleFunc("/universe", universe_handler)
+	http.HandleFunc("/users", list_handler)
+	http.HandleFunc("/users/", user_handler)
+	http.HandleFunc("/file_store", file_store_handler)
+	http.HandleFunc("/file_store/", file_store_handler)
+	http.HandleFunc("/events", adminIPCheck(event_list_handler))
+	http.HandleFunc("/events/", adminIPCheck(event_handler))
+	http.HandleFunc("/reports", report_handler)
+	http.HandleFunc("/reports/", report_handler)
+
+	/* TODO: figure out how to handle the root & not found pages */
+	http.HandleFunc("/", root_handler)
+}
+
+/* Borrowed from net/http's cleanPath, except that trailing slashes are always
+ * stripped (apart from the root path) instead of kept. That way /cookbooks/
+ * and /cookbooks, or /nodes/foo/ and /nodes/foo, reach the same handler with
+ * the same path, which also matches the path.Clean'd path that signed
+ * requests are checked against. Redirecting instead would break POSTs. */
 func cleanPath(p string) string {
-	/* Borrowing cleanPath from net/http */
 	if p == "" {
 		return "/"
 	}
 	if p[0] != '/' {
 		p = "/" + p
 	}
-        np := path.Clean(p)
-	// path.Clean removes trailing slash except for root;
-	// put the trailing slash back if necessary.
-	if p[len(p)-1] == '/' && np != "/" {
-		np += "/"
-	}
-	return np
+	return path.Clean(p)
 }
 
 func createDefaultActors() {
@@ -287,6 +368,15 @@ func createDefaultActors() {
 			os.Exit(1)
 		} else {
 			admin.Admin = true
+			if config.Config.AdminPassword != "" {
+				if perr := admin.SetPasswd(config.Config.AdminPassword); perr != nil {
+					logger.Criticalf("Could not set the admin user's password: %s", perr.Error())
+					os.Exit(1)
+				}
+				if weakPasswd(config.Config.AdminPassword) {
+					logger.Warningf("The admin user's password is weak. Consider changing it to something longer, mixing letters, numbers, and symbols.")
+				}
+			}
 			pem, err := admin.GenerateKeys()
 			if err != nil {
 				logger.Criticalf(err.Error())
@@ -311,6 +401,26 @@ func createDefaultActors() {
 	return
 }
 
+/* A rough check for passwords that are easy to guess: short ones, and ones made
+ * only of letters or only of digits. */
+func weakPasswd(password string) bool {
+	if len(password) < 10 {
+		return true
+	}
+	var letters, digits, others bool
+	for _, c := range password {
+		switch {
+			case unicode.IsLetter(c):
+				letters = true
+			case unicode.IsDigit(c):
+				digits = true
+			default:
+				others = true
+		}
+	}
+	return !(letters && (digits || others))
+}
+
 func handleSignals() {
 	c := make(chan os.Signal, 1)
 	// SIGTERM is not exactly portable, but Go has a fake signal for it
@@ -334,13 +444,18 @@ func handleSignals() {
 						logger.Errorf(err.Error())
 					}
 				}
-				if config.Config.UseMySQL {
+				if config.Config.UseSQL {
 					data_store.Dbh.Close()
 				}
 				os.Exit(0)
 			} else if sig == syscall.SIGHUP {
 				logger.Infof("Reloading configuration...")
-				config.ParseConfigOptions()
+				if err := config.ReloadConfig(); err != nil {
+					logger.Errorf("Error reloading configuration, keeping the current settings: %s", err.Error())
+					continue
+				}
+				setLimitSems()
+				logger.Infof("Configuration reloaded")
 			}
 		}
 	}()
@@ -361,6 +476,8 @@ func gobRegister() {
 	gob.Register(r)
 	s := new(sandbox.Sandbox)
 	gob.Register(s)
+	sv := new(saved_search.SavedSearch)
+	gob.Register(sv)
 	m := make(map[string]interface{})
 	gob.Register(m)
 	si := make([]interface{},0)
@@ -416,20 +533,53 @@ func setSaveTicker() {
 	}
 }
 
+/* The ticker always runs, since log-event-keep can be set by reloading the
+ * config. */
 func setLogEventPurgeTicker() {
-	if config.Config.LogEventKeep != 0 {
-		ticker := time.NewTicker(time.Second * time.Duration(60))
-		go func() {
-			for _ = range ticker.C {
-				les := log_info.GetLogInfos(0, 1)
-				if len(les) != 0 {
-					p, err := log_info.PurgeLogInfos(les[0].Id - config.Config.LogEventKeep)
-					if err != nil {
-						logger.Errorf(err.Error())
-					}
-					logger.Debugf("Purged %d events automatically", p)
+	ticker := time.NewTicker(time.Second * time.Duration(60))
+	go func() {
+		for _ = range ticker.C {
+			keep := config.LogEventKeep()
+			if keep == 0 {
+				continue
+			}
+			les := log_info.GetLogInfos(0, 1)
+			if len(les) != 0 {
+				p, err := log_info.PurgeLogInfos(les[0].Id - keep)
+				if err != nil {
+					logger.Errorf(err.Error())
 				}
+				logger.Debugf("Purged %d events automatically", p)
 			}
-		}()
+		}
+	}()
+}
+
+/* Set up the request and upload semaphores to match the configured limits. A
+ * semaphore is only replaced if its limit has changed; requests holding a slot
+ * in the old one release it there, so for a little while after the limit is
+ * changed a few more requests than the new limit may be in progress. */
+func setLimitSems() {
+	max_requests, _ := config.RequestLimits()
+	max_uploads, _ := config.UploadLimits()
+	semLock.Lock()
+	defer semLock.Unlock()
+	requestSem = resizeSem(requestSem, max_requests)
+	uploadSem = resizeSem(uploadSem, max_uploads)
+}
+
+func resizeSem(sem chan bool, size int) chan bool {
+	if size <= 0 {
+		return nil
+	}
+	if sem != nil && cap(sem) == size {
+		return sem
 	}
+	return make(chan bool, size)
+}
+
+func currentRequestSem() chan bool {
+	semLock.RLock()
+	defer semLock.RUnlock()
+	return requestSem
 }
@@ -0,0 +1,1194 @@
+/*
+ * Copyright (c) 2013-2014, Jeremy Bingham (<jbingham@gmail.com>)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/md5"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"github.com/ctdk/goiardi/client"
+	"github.com/ctdk/goiardi/config"
+	"github.com/ctdk/goiardi/cookbook"
+	"github.com/ctdk/goiardi/data_bag"
+	"github.com/ctdk/goiardi/environment"
+	"github.com/ctdk/goiardi/filestore"
+	"github.com/ctdk/goiardi/indexer"
+	"github.com/ctdk/goiardi/node"
+	"github.com/ctdk/goiardi/role"
+	"github.com/ctdk/goiardi/user"
+)
+
+func TestCleanPathTrailingSlash(t *testing.T) {
+	paths := map[string]string{
+		"/": "/",
+		"": "/",
+		"/cookbooks": "/cookbooks",
+		"/cookbooks/": "/cookbooks",
+		"//cookbooks//": "/cookbooks",
+		"/nodes/foo": "/nodes/foo",
+		"/nodes/foo/": "/nodes/foo",
+		"/environments/_default/cookbooks/apache2/": "/environments/_default/cookbooks/apache2",
+		"/data/bag/item/": "/data/bag/item",
+	}
+	for p, want := range paths {
+		if got := cleanPath(p); got != want {
+			t.Errorf("cleanPath(%q) was %q, expected %q", p, got, want)
+		}
+	}
+
+	/* Both forms need to give handlers the same path parameters. */
+	for _, p := range []string{ "/nodes/foo", "/environments/_default/cookbooks/apache2", "/data/bag/item" } {
+		with := SplitPath(cleanPath(p + "/"))
+		without := SplitPath(cleanPath(p))
+		if !reflect.DeepEqual(with, without) {
+			t.Errorf("Path %s split as %v with a trailing slash, but %v without", p, with, without)
+		}
+	}
+}
+
+func TestCookbookVisible(t *testing.T) {
+	config.Config.CookbookVisibility = map[string][]string{
+		"teama-*": { "teama_*", "base" },
+		"teama-web*": { "nginx" },
+	}
+	config.Config.UseAuth = true
+	defer func() {
+		config.Config.CookbookVisibility = nil
+		config.Config.UseAuth = false
+	}()
+	web, _ := client.New("teama-web1")
+	other, _ := client.New("teamb-web1")
+	admin, _ := client.New("teama-admin")
+	admin.Admin = true
+
+	checks := []struct{
+		c *client.Client
+		cookbook string
+		visible bool
+	}{
+		{ web, "teama_app", true },
+		{ web, "base", true },
+		{ web, "nginx", true },
+		{ web, "teamb_app", false },
+		{ other, "teamb_app", true },
+		{ admin, "teamb_app", true },
+	}
+	for _, c := range checks {
+		if v := cookbookVisible(c.c, c.cookbook); v != c.visible {
+			t.Errorf("Expected visibility of %s to %s to be %v, got %v", c.cookbook, c.c.Name, c.visible, v)
+		}
+	}
+}
+
+func TestListSortParam(t *testing.T) {
+	tests := []struct{ q string; sorted, desc, bad bool }{
+		{ "", false, false, false },
+		{ "name", true, false, false },
+		{ "name:asc", true, false, false },
+		{ "name:desc", true, true, false },
+		{ "name:sideways", false, false, true },
+		{ "created_at:asc", false, false, true },
+	}
+	for _, tc := range tests {
+		r, _ := http.NewRequest("GET", "/clients?sort=" + tc.q, nil)
+		sorted, desc, err := listSortParam(r)
+		if (err != nil) != tc.bad || sorted != tc.sorted || desc != tc.desc {
+			t.Errorf("sort=%q gave sorted %v, desc %v, error %v", tc.q, sorted, desc, err)
+		}
+	}
+}
+
+func TestPutCreates(t *testing.T) {
+	/* Without auth, requests are made as the admin client. */
+	admin, _ := client.New("admin")
+	admin.Admin = true
+	admin.Save()
+	dbag, _ := data_bag.New("putbag")
+	dbag.Save()
+
+	puts := []struct{
+		handler http.HandlerFunc
+		path string
+		body string
+	}{
+		{ node_handler, "/nodes/putnode", `{"name": "putnode", "json_class": "Chef::Node", "chef_type": "node", "chef_environment": "_default", "run_list": []}` },
+		{ role_handler, "/roles/putrole", `{"name": "putrole", "json_class": "Chef::Role", "chef_type": "role", "run_list": []}` },
+		{ environment_handler, "/environments/putenv", `{"name": "putenv", "json_class": "Chef::Environment", "chef_type": "environment"}` },
+		{ data_handler, "/data/putbag/putitem", `{"id": "putitem", "foo": "bar"}` },
+	}
+	send := func(h http.HandlerFunc, method string, path string, body string) int {
+		r, _ := http.NewRequest(method, path, strings.NewReader(body))
+		r.Header.Set("Accept", "application/json")
+		w := httptest.NewRecorder()
+		h(w, r)
+		return w.Code
+	}
+	for _, p := range puts {
+		if code := send(p.handler, "PUT", p.path, p.body); code != http.StatusNotFound {
+			t.Errorf("PUT to missing %s without put-creates gave %d, expected 404", p.path, code)
+		}
+	}
+	config.Config.PutCreates = true
+	defer func() { config.Config.PutCreates = false }()
+	for _, p := range puts {
+		if code := send(p.handler, "PUT", p.path, p.body); code != http.StatusCreated {
+			t.Errorf("PUT to missing %s with put-creates gave %d, expected 201", p.path, code)
+		}
+		if code := send(p.handler, "GET", p.path, ""); code != http.StatusOK {
+			t.Errorf("GET of %s after creating it with a PUT gave %d", p.path, code)
+		}
+		if code := send(p.handler, "PUT", p.path, p.body); code != http.StatusOK {
+			t.Errorf("PUT to existing %s gave %d, expected 200", p.path, code)
+		}
+	}
+
+	/* A body of null to something that doesn't exist yet shouldn't
+	 * create anything, or crash. */
+	nulls := []struct{
+		handler http.HandlerFunc
+		path string
+	}{
+		{ node_handler, "/nodes/nullnode" },
+		{ role_handler, "/roles/nullrole" },
+		{ environment_handler, "/environments/nullenv" },
+		{ data_handler, "/data/putbag/nullitem" },
+	}
+	for _, n := range nulls {
+		if code := send(n.handler, "PUT", n.path, "null"); code != http.StatusBadRequest {
+			t.Errorf("PUT of null to missing %s with put-creates gave %d, expected 400", n.path, code)
+		}
+		if code := send(n.handler, "GET", n.path, ""); code != http.StatusNotFound {
+			t.Errorf("GET of %s after a PUT of null gave %d, expected 404", n.path, code)
+		}
+	}
+}
+
+func TestSortedLists(t *testing.T) {
+	if admin, err := client.New("admin"); err == nil {
+		admin.Admin = true
+		admin.Save()
+	}
+	for _, n := range []string{ "sortclient_b", "sortclient_a", "sortclient_c" } {
+		c, _ := client.New(n)
+		c.Save()
+		u, _ := user.New(n)
+		u.Save()
+	}
+	get := func(path string) ([]string, []string, *httptest.ResponseRecorder) {
+		r, _ := http.NewRequest("GET", path, nil)
+		r.Header.Set("Accept", "application/json")
+		w := httptest.NewRecorder()
+		list_handler(w, r)
+		if w.Code != http.StatusOK {
+			t.Fatalf("GET %s gave %d: %s", path, w.Code, w.Body.String())
+		}
+		var items []map[string]string
+		if err := json.Unmarshal(w.Body.Bytes(), &items); err != nil {
+			t.Fatalf("GET %s didn't return an array: %s", path, err.Error())
+		}
+		names := make([]string, 0, len(items))
+		urls := make([]string, 0, len(items))
+		for _, i := range items {
+			if strings.HasPrefix(i["name"], "sortclient_") {
+				names = append(names, i["name"])
+				urls = append(urls, i["url"])
+			}
+		}
+		return names, urls, w
+	}
+	for _, kind := range []string{ "clients", "users" } {
+		names, urls, _ := get("/" + kind + "?sort=name:desc")
+		expected := []string{ "sortclient_c", "sortclient_b", "sortclient_a" }
+		if !reflect.DeepEqual(names, expected) {
+			t.Errorf("/%s sorted descending gave %v, expected %v", kind, names, expected)
+		}
+		if len(urls) > 0 && !strings.HasSuffix(urls[0], "/" + kind + "/sortclient_c") {
+			t.Errorf("/%s sorted descending had url %s for sortclient_c", kind, urls[0])
+		}
+		names, _, _ = get("/" + kind + "?sort=name:asc")
+		expected = []string{ "sortclient_a", "sortclient_b", "sortclient_c" }
+		if !reflect.DeepEqual(names, expected) {
+			t.Errorf("/%s sorted ascending gave %v, expected %v", kind, names, expected)
+		}
+		_, _, w := get("/" + kind + "?sort=name:desc&limit=1")
+		var page []map[string]string
+		json.Unmarshal(w.Body.Bytes(), &page)
+		if len(page) != 1 || w.Header().Get("X-Goiardi-Total-Count") == "" {
+			t.Errorf("A sorted page of /%s had %d items and total count '%s'", kind, len(page), w.Header().Get("X-Goiardi-Total-Count"))
+		}
+	}
+}
+
+func TestPartialSearch(t *testing.T) {
+	if admin, err := client.New("admin"); err == nil {
+		admin.Admin = true
+		admin.Save()
+	}
+	n, _ := node.New("partialnode")
+	n.Automatic["ipaddress"] = "10.0.0.5"
+	n.Automatic["kernel"] = map[string]interface{}{ "machine": "x86_64", "name": "Linux" }
+	n.Save()
+	indexer.ReIndex([]indexer.Indexable{ n })
+
+	body := `{"name": ["name"], "ip": ["ipaddress"], "machine": ["kernel", "machine"], "dotted": "kernel.name", "missing": ["kernel", "nope"]}`
+	r, _ := http.NewRequest("POST", "/search/node?q=name:partialnode", strings.NewReader(body))
+	w := httptest.NewRecorder()
+	search_handler(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Partial search gave %d: %s", w.Code, w.Body.String())
+	}
+	var resp struct{
+		Rows []struct{
+			Url string `json:"url"`
+			Data map[string]interface{} `json:"data"`
+		} `json:"rows"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Rows) != 1 {
+		t.Fatalf("Partial search found %d rows, expected 1", len(resp.Rows))
+	}
+	row := resp.Rows[0]
+	if !strings.HasSuffix(row.Url, "/nodes/partialnode") {
+		t.Errorf("Partial search row had url %s", row.Url)
+	}
+	expected := map[string]interface{}{ "name": "partialnode", "ip": "10.0.0.5", "machine": "x86_64", "dotted": "Linux", "missing": nil }
+	if !reflect.DeepEqual(row.Data, expected) {
+		t.Errorf("Partial search row had data %v, expected %v", row.Data, expected)
+	}
+}
+
+func TestSearchPagination(t *testing.T) {
+	if admin, err := client.New("admin"); err == nil {
+		admin.Admin = true
+		admin.Save()
+	}
+	objs := make([]indexer.Indexable, 50)
+	for i := range objs {
+		n, _ := node.New(fmt.Sprintf("pagenode%02d", i))
+		n.Normal["pagetest"] = "yes"
+		n.Save()
+		objs[i] = n
+	}
+	indexer.ReIndex(objs)
+
+	page := func(start int) (int, int, []string) {
+		r, _ := http.NewRequest("GET", fmt.Sprintf("/search/node?q=pagetest:yes&sort=name+asc&rows=10&start=%d", start), nil)
+		w := httptest.NewRecorder()
+		search_handler(w, r)
+		if w.Code != http.StatusOK {
+			t.Fatalf("Search with start %d gave %d: %s", start, w.Code, w.Body.String())
+		}
+		var resp struct{
+			Total int `json:"total"`
+			Start int `json:"start"`
+			Rows []map[string]interface{} `json:"rows"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatal(err)
+		}
+		names := make([]string, len(resp.Rows))
+		for i, n := range resp.Rows {
+			names[i], _ = n["name"].(string)
+		}
+		return resp.Total, resp.Start, names
+	}
+	seen := make(map[string]bool)
+	for start := 0; start < 50; start += 10 {
+		total, st, names := page(start)
+		if total != 50 || st != start || len(names) != 10 {
+			t.Errorf("Page at %d had total %d, start %d, and %d rows; expected 50, %d, and 10", start, total, st, len(names), start)
+		}
+		if len(names) > 0 && names[0] != fmt.Sprintf("pagenode%02d", start) {
+			t.Errorf("Page at %d began with %s", start, names[0])
+		}
+		for _, n := range names {
+			seen[n] = true
+		}
+	}
+	if len(seen) != 50 {
+		t.Errorf("Paging through the results found %d different nodes, expected 50", len(seen))
+	}
+	if total, st, names := page(60); total != 50 || st != 60 || len(names) != 0 {
+		t.Errorf("Starting past the end had total %d, start %d, and %d rows, expected 50, 60, and 0", total, st, len(names))
+	}
+
+	/* Partial searches are paged the same way, and only the page's
+	 * results are formatted. */
+	body := strings.NewReader(`{"name":["name"],"pt":["pagetest"]}`)
+	r, _ := http.NewRequest("POST", "/search/node?q=pagetest:yes&sort=name+desc&rows=5&start=5", body)
+	w := httptest.NewRecorder()
+	search_handler(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Partial search gave %d: %s", w.Code, w.Body.String())
+	}
+	var presp struct{
+		Total int `json:"total"`
+		Start int `json:"start"`
+		Rows []struct{
+			URL string `json:"url"`
+			Data map[string]interface{} `json:"data"`
+		} `json:"rows"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &presp); err != nil {
+		t.Fatal(err)
+	}
+	if presp.Total != 50 || presp.Start != 5 || len(presp.Rows) != 5 {
+		t.Fatalf("Partial search page had total %d, start %d, and %d rows; expected 50, 5, and 5", presp.Total, presp.Start, len(presp.Rows))
+	}
+	for i, row := range presp.Rows {
+		name := fmt.Sprintf("pagenode%02d", 44 - i)
+		if row.Data["name"] != name || row.Data["pt"] != "yes" {
+			t.Errorf("Partial search row %d had data %v, expected name %s", i, row.Data, name)
+		}
+		if !strings.HasSuffix(row.URL, "/nodes/" + name) {
+			t.Errorf("Partial search row %d had url %s, expected one for %s", i, row.URL, name)
+		}
+	}
+}
+
+/* A request body that notes whether it's been read. */
+type watchedBody struct {
+	io.Reader
+	read bool
+}
+
+func (b *watchedBody) Read(p []byte) (int, error) {
+	b.read = true
+	return b.Reader.Read(p)
+}
+
+func (b *watchedBody) Close() error {
+	return nil
+}
+
+func TestImportBodyLimit(t *testing.T) {
+	if admin, err := client.New("admin"); err == nil {
+		admin.Admin = true
+		admin.Save()
+	}
+	if c, err := client.New("importer"); err == nil {
+		c.Save()
+	}
+	config.Config.MaxImportSize = 100
+	defer func() { config.Config.MaxImportSize = 0 }()
+	big := `{"name": "bigimport", "versions": [], "padding": "` + strings.Repeat("x", 200) + `"}`
+
+	send := func(user string, body *watchedBody, length int64) int {
+		r, _ := http.NewRequest("POST", "/cookbooks/_import", nil)
+		r.Body = body
+		r.ContentLength = length
+		r.Header.Set("X-OPS-USERID", user)
+		w := httptest.NewRecorder()
+		cookbook_handler(w, r)
+		return w.Code
+	}
+
+	/* A chunked body doesn't say how long it is, so it has to be cut off
+	 * while it's being read. */
+	body := &watchedBody{ Reader: strings.NewReader(big) }
+	if code := send("admin", body, -1); code != http.StatusRequestEntityTooLarge {
+		t.Errorf("Importing a chunked bundle over max-import-size gave %d, expected 413", code)
+	}
+	body = &watchedBody{ Reader: strings.NewReader(big) }
+	if code := send("admin", body, int64(len(big))); code != http.StatusRequestEntityTooLarge {
+		t.Errorf("Importing a bundle over max-import-size gave %d, expected 413", code)
+	}
+
+	config.Config.UseAuth = true
+	defer func() { config.Config.UseAuth = false }()
+	body = &watchedBody{ Reader: strings.NewReader(big) }
+	if code := send("importer", body, -1); code != http.StatusForbidden {
+		t.Errorf("Importing as a non-admin gave %d, expected 403", code)
+	}
+	if body.read {
+		t.Errorf("The body of a non-admin's import was read")
+	}
+}
+
+func TestNodeEffectivePrecedence(t *testing.T) {
+	if admin, err := client.New("admin"); err == nil {
+		admin.Admin = true
+		admin.Save()
+	}
+	rl, _ := role.New("effrole")
+	rl.Default["shared"] = "role default"
+	rl.Override["shared_override"] = "role override"
+	rl.Save()
+	env, _ := environment.New("effenv")
+	env.Default["shared"] = "environment default"
+	env.Override["shared_override"] = "environment override"
+	env.Save()
+	n, _ := node.New("effnode")
+	n.ChefEnvironment = "effenv"
+	n.RunList = []string{ "role[effrole]" }
+	n.Save()
+
+	r, _ := http.NewRequest("GET", "/nodes/effnode/_effective", nil)
+	w := httptest.NewRecorder()
+	node_handler(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Getting effective attributes gave %d: %s", w.Code, w.Body.String())
+	}
+	var effective struct{
+		Attributes map[string]interface{} `json:"attributes"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &effective); err != nil {
+		t.Fatal(err)
+	}
+	if a := effective.Attributes["shared"]; a != "role default" {
+		t.Errorf("Attribute set at role and environment default was '%v', expected the role's", a)
+	}
+	if a := effective.Attributes["shared_override"]; a != "environment override" {
+		t.Errorf("Attribute set at role and environment override was '%v', expected the environment's", a)
+	}
+}
+
+func TestDeleteBaseEnvironment(t *testing.T) {
+	if admin, err := client.New("admin"); err == nil {
+		admin.Admin = true
+		admin.Save()
+	}
+	parent, _ := environment.New("delparent")
+	parent.Save()
+	child, _ := environment.New("delchild")
+	child.BaseEnvironment = "delparent"
+	child.Save()
+
+	r, _ := http.NewRequest("DELETE", "/environments/delparent", nil)
+	r.Header.Set("Accept", "application/json")
+	w := httptest.NewRecorder()
+	environment_handler(w, r)
+	if w.Code != http.StatusConflict {
+		t.Errorf("Deleting an environment another inherits from gave %d, expected 409", w.Code)
+	}
+	if !strings.Contains(w.Body.String(), "delchild") {
+		t.Errorf("The refusal to delete didn't name the environment inheriting from it: %s", w.Body.String())
+	}
+	if _, err := environment.Get("delparent"); err != nil {
+		t.Errorf("The environment was deleted anyway")
+	}
+}
+
+func TestPinnedEnvironments(t *testing.T) {
+	if admin, err := client.New("admin"); err == nil {
+		admin.Admin = true
+		admin.Save()
+	}
+	makeTestCookbook(t, "pinapp", "1.0.0", map[string]interface{}{})
+	makeTestCookbook(t, "pinapp", "2.0.0", map[string]interface{}{})
+	pinned, _ := environment.New("pinned")
+	pinned.CookbookVersions = map[string]string{ "pinapp": "< 2.0.0" }
+	pinned.Save()
+	inherits, _ := environment.New("pininherits")
+	inherits.BaseEnvironment = "pinned"
+	inherits.Save()
+	unpinned, _ := environment.New("pinunpinned")
+	unpinned.CookbookVersions = map[string]string{ "pinapp": ">= 1.0.0" }
+	unpinned.Save()
+	/* This one's base environment doesn't exist, so its constraints
+	 * can't be worked out. It shouldn't keep the others from being
+	 * found. */
+	broken, _ := environment.New("pinbroken")
+	broken.BaseEnvironment = "pinmissing"
+	broken.Save()
+
+	r, _ := http.NewRequest("GET", "/cookbooks/pinapp/_pinned_environments", nil)
+	r.Header.Set("Accept", "application/json")
+	w := httptest.NewRecorder()
+	cookbook_handler(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Getting the pinned environments gave %d: %s", w.Code, w.Body.String())
+	}
+	var found []map[string]string
+	if err := json.Unmarshal(w.Body.Bytes(), &found); err != nil {
+		t.Fatal(err)
+	}
+	expected := []map[string]string{
+		{ "environment": "pininherits", "constraint": "< 2.0.0", "latest_allowed": "1.0.0" },
+		{ "environment": "pinned", "constraint": "< 2.0.0", "latest_allowed": "1.0.0" },
+	}
+	if !reflect.DeepEqual(found, expected) {
+		t.Errorf("Pinned environments were %v, expected %v", found, expected)
+	}
+}
+
+func TestAdminIPAllowEndpoints(t *testing.T) {
+	if admin, err := client.New("admin"); err == nil {
+		admin.Admin = true
+		admin.Save()
+	}
+	nets, err := config.ParseCIDRs([]string{"10.0.0.0/8"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.Config.AdminIPAllowNets = nets
+	defer func() { config.Config.AdminIPAllowNets = nil }()
+
+	reqs := []struct {
+		method  string
+		path    string
+		handler http.HandlerFunc
+	}{
+		{"PUT", "/notice", notice_handler},
+		{"DELETE", "/notice", notice_handler},
+		{"POST", "/cookbooks/_import", cookbook_handler},
+		{"POST", "/cookbooks/_bulk_delete", cookbook_handler},
+		{"GET", "/cookbooks/foo/_export", cookbook_handler},
+		{"POST", "/clients/admin/_disable", client_handler},
+		{"POST", "/clients/admin/_enable", client_handler},
+		{"GET", "/nodes/_stale", node_handler},
+	}
+	for _, q := range reqs {
+		r, _ := http.NewRequest(q.method, q.path, strings.NewReader("{}"))
+		r.Header.Set("Accept", "application/json")
+		r.RemoteAddr = "192.0.2.10:40000"
+		w := httptest.NewRecorder()
+		q.handler(w, r)
+		if w.Code != http.StatusForbidden {
+			t.Errorf("%s %s from outside admin-ip-allow gave %d, expected 403", q.method, q.path, w.Code)
+		}
+	}
+
+	r, _ := http.NewRequest("GET", "/nodes/_stale", nil)
+	r.Header.Set("Accept", "application/json")
+	r.RemoteAddr = "10.1.2.3:40000"
+	w := httptest.NewRecorder()
+	node_handler(w, r)
+	if w.Code == http.StatusForbidden {
+		t.Errorf("GET /nodes/_stale from inside admin-ip-allow was refused: %s", w.Body.String())
+	}
+}
+
+func TestVerifyChunkedDuplicateUpload(t *testing.T) {
+	config.Config.VerifyDuplicateUploads = true
+	defer func() { config.Config.VerifyDuplicateUploads = false }()
+	data := "chunked duplicate upload\n"
+	chksum := fmt.Sprintf("%x", md5.Sum([]byte(data)))
+	fs, err := filestore.New(chksum, ioutil.NopCloser(strings.NewReader(data)), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fs.Save()
+
+	bodies := map[string]int{ data: http.StatusOK, "not the same file\n": http.StatusConflict, data + "and then some": http.StatusConflict }
+	for body, want := range bodies {
+		r, _ := http.NewRequest("PUT", "/file_store/" + chksum, ioutil.NopCloser(strings.NewReader(body)))
+		r.ContentLength = -1
+		r.TransferEncoding = []string{ "chunked" }
+		w := httptest.NewRecorder()
+		file_store_handler(w, r)
+		if w.Code != want {
+			t.Errorf("A chunked upload of %q gave %d, expected %d: %s", body, w.Code, want, w.Body.String())
+		}
+	}
+}
+
+var registerOnce sync.Once
+
+func TestTrailingSlashRouting(t *testing.T) {
+	registerOnce.Do(registerHandlers)
+	if admin, err := client.New("admin"); err == nil {
+		admin.Admin = true
+		admin.Save()
+	}
+	reqs := map[string]int{
+		"/principals/admin": http.StatusOK,
+		"/principals/admin/": http.StatusOK,
+		"/principals": http.StatusBadRequest,
+		"/principals/": http.StatusBadRequest,
+		"/reports": http.StatusBadRequest,
+		"/reports/": http.StatusBadRequest,
+		"/file_store": http.StatusBadRequest,
+		"/file_store/": http.StatusBadRequest,
+	}
+	h := &InterceptHandler{}
+	for p, want := range reqs {
+		r, _ := http.NewRequest("GET", p, nil)
+		r.Header.Set("Accept", "application/json")
+		r.Header.Set("X-Ops-Userid", "admin")
+		r.Header.Set("X-Ops-Reporting-Protocol-Version", "0.1.0")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != want {
+			t.Errorf("GET %s gave %d, expected %d: %s", p, w.Code, want, w.Body.String())
+		}
+	}
+}
+
+/* Upload a cookbook version with the given dependencies, for the depsolving
+ * handler tests. */
+func makeTestCookbook(t *testing.T, name string, version string, deps map[string]interface{}) {
+	cb, err := cookbook.Get(name)
+	if err != nil {
+		cb, _ = cookbook.New(name)
+		cb.Save()
+	}
+	cbv_data := map[string]interface{}{
+		"cookbook_name": name,
+		"name": name + "-" + version,
+		"version": version,
+		"json_class": "Chef::CookbookVersion",
+		"chef_type": "cookbook_version",
+		"frozen?": false,
+		"metadata": map[string]interface{}{ "version": version, "name": name, "dependencies": deps },
+	}
+	if _, err := cb.NewVersion(version, cbv_data); err != nil {
+		t.Fatalf("Creating %s %s failed: %s", name, version, err.Error())
+	}
+}
+
+func TestNodeResolvedCookbooksVisibility(t *testing.T) {
+	makeTestCookbook(t, "visapp", "1.0.0", map[string]interface{}{ "visbase": ">= 0.0.0" })
+	makeTestCookbook(t, "visbase", "1.0.0", map[string]interface{}{})
+	env, _ := environment.New("visenv")
+	env.Save()
+	n, _ := node.New("visnode")
+	n.ChefEnvironment = "visenv"
+	n.RunList = []string{ "recipe[visapp]" }
+	n.Save()
+
+	config.Config.CookbookVisibility = map[string][]string{
+		"visclient": { "visapp" },
+	}
+	config.Config.UseAuth = true
+	defer func() {
+		config.Config.CookbookVisibility = nil
+		config.Config.UseAuth = false
+	}()
+	visclient, _ := client.New("visclient")
+	other, _ := client.New("visother")
+
+	get := func(c *client.Client) *httptest.ResponseRecorder {
+		r, _ := http.NewRequest("GET", "/nodes/visnode/_resolved_cookbooks", nil)
+		r.Header.Set("Accept", "application/json")
+		w := httptest.NewRecorder()
+		node_resolved_cookbooks(w, r, "visnode", c)
+		return w
+	}
+	if w := get(visclient); w.Code != http.StatusForbidden {
+		t.Errorf("Resolving a run list that needs a hidden cookbook gave %d, expected 403", w.Code)
+	}
+	if w := get(other); w.Code != http.StatusOK {
+		t.Errorf("Resolving a run list for a client without restrictions gave %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestNodeResolvedCookbooks(t *testing.T) {
+	if admin, err := client.New("admin"); err == nil {
+		admin.Admin = true
+		admin.Save()
+	}
+	makeTestCookbook(t, "resapp", "1.0.0", map[string]interface{}{ "resbase": ">= 1.0.0" })
+	makeTestCookbook(t, "resbase", "1.0.0", map[string]interface{}{})
+	makeTestCookbook(t, "resbase", "1.1.0", map[string]interface{}{})
+	makeTestCookbook(t, "resbroken", "1.0.0", map[string]interface{}{ "resmissing": ">= 0.0.0" })
+	env, _ := environment.New("resenv")
+	env.Save()
+	good, _ := node.New("resgood")
+	good.ChefEnvironment = "resenv"
+	good.RunList = []string{ "recipe[resapp]" }
+	good.Save()
+	bad, _ := node.New("resbad")
+	bad.ChefEnvironment = "resenv"
+	bad.RunList = []string{ "recipe[resapp]", "recipe[resbroken]" }
+	bad.Save()
+
+	get := func(method string, path string) *httptest.ResponseRecorder {
+		r, _ := http.NewRequest(method, path, nil)
+		r.Header.Set("Accept", "application/json")
+		w := httptest.NewRecorder()
+		node_handler(w, r)
+		return w
+	}
+
+	w := get("GET", "/nodes/resgood/_resolved_cookbooks")
+	if w.Code != http.StatusOK {
+		t.Fatalf("Resolving resgood's cookbooks gave %d: %s", w.Code, w.Body.String())
+	}
+	var resolved map[string]string
+	if err := json.Unmarshal(w.Body.Bytes(), &resolved); err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]string{ "resapp": "1.0.0", "resbase": "1.1.0" }
+	if !reflect.DeepEqual(resolved, expected) {
+		t.Errorf("resgood's cookbooks resolved to %v, expected %v", resolved, expected)
+	}
+
+	w = get("GET", "/nodes/resbad/_resolved_cookbooks")
+	if w.Code != http.StatusPreconditionFailed {
+		t.Fatalf("Resolving resbad's cookbooks gave %d, expected 412: %s", w.Code, w.Body.String())
+	}
+	var unsatisfiable struct{
+		Error []string `json:"error"`
+		Failures []map[string]string `json:"failures"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &unsatisfiable); err != nil {
+		t.Fatal(err)
+	}
+	if len(unsatisfiable.Error) != 1 {
+		t.Errorf("Expected one error for resbad, got %v", unsatisfiable.Error)
+	}
+	if len(unsatisfiable.Failures) != 1 || unsatisfiable.Failures[0]["run_list_item"] != "resbroken" {
+		t.Errorf("Expected only resbroken to fail for resbad, got %v", unsatisfiable.Failures)
+	}
+
+	if w = get("GET", "/nodes/resnonexistent/_resolved_cookbooks"); w.Code != http.StatusNotFound {
+		t.Errorf("Resolving a missing node's cookbooks gave %d, expected 404", w.Code)
+	}
+	if w = get("POST", "/nodes/resgood/_resolved_cookbooks"); w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("POSTing to _resolved_cookbooks gave %d, expected 405", w.Code)
+	}
+}
+
+func TestGzipResponses(t *testing.T) {
+	/* A response with a body gets compressed. */
+	w := httptest.NewRecorder()
+	gw := newGzipResponseWriter(w, gzip.DefaultCompression)
+	gw.Header().Set("Content-Length", "11")
+	gw.Write([]byte("hello there"))
+	gw.close()
+	if ce := w.Header().Get("Content-Encoding"); ce != "gzip" {
+		t.Errorf("Expected a 200 response to be gzipped, but Content-Encoding was '%s'", ce)
+	}
+	if cl := w.Header().Get("Content-Length"); cl != "" {
+		t.Errorf("The uncompressed Content-Length should have been dropped, got %s", cl)
+	}
+	gr, err := gzip.NewReader(bytes.NewReader(w.Body.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body, _ := ioutil.ReadAll(gr); string(body) != "hello there" {
+		t.Errorf("Compressed body decompressed to '%s'", string(body))
+	}
+
+	/* 204 and 304 responses can't have a body, so they shouldn't get
+	 * a gzip stream either. */
+	for _, status := range []int{ http.StatusNoContent, http.StatusNotModified } {
+		w := httptest.NewRecorder()
+		gw := newGzipResponseWriter(w, gzip.DefaultCompression)
+		gw.WriteHeader(status)
+		gw.close()
+		if ce := w.Header().Get("Content-Encoding"); ce != "" {
+			t.Errorf("A %d response shouldn't be gzipped, but Content-Encoding was '%s'", status, ce)
+		}
+		if w.Body.Len() != 0 {
+			t.Errorf("A %d response should have no body, but got %d bytes", status, w.Body.Len())
+		}
+	}
+
+	/* Nor should HEAD requests, or responses with no body at all. */
+	registerOnce.Do(registerHandlers)
+	if admin, err := client.New("admin"); err == nil {
+		admin.Admin = true
+		admin.Save()
+	}
+	config.Config.GzipResponses = true
+	config.Config.GzipLevel = gzip.DefaultCompression
+	defer func() { config.Config.GzipResponses = false }()
+	h := &InterceptHandler{}
+	reqs := []struct{
+		method string
+		path string
+		gzipped bool
+	}{
+		{ "HEAD", "/principals/admin", false },
+		{ "GET", "/", false },
+		{ "GET", "/principals/admin", true },
+	}
+	for _, req := range reqs {
+		r, _ := http.NewRequest(req.method, req.path, nil)
+		r.Header.Set("Accept", "application/json")
+		r.Header.Set("Accept-Encoding", "gzip")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if ce := w.Header().Get("Content-Encoding"); (ce == "gzip") != req.gzipped {
+			t.Errorf("%s %s: expected gzipped to be %v, but Content-Encoding was '%s'", req.method, req.path, req.gzipped, ce)
+		}
+	}
+}
+
+func TestStaleNodes(t *testing.T) {
+	if admin, err := client.New("admin"); err == nil {
+		admin.Admin = true
+		admin.Save()
+	}
+	long_ago := float64(time.Now().Add(-48 * time.Hour).Unix())
+	stale, _ := node.New("stalenode")
+	stale.Automatic["ohai_time"] = long_ago
+	stale.Save()
+	fresh, _ := node.New("freshnode")
+	fresh.Automatic["ohai_time"] = float64(time.Now().Unix())
+	fresh.Save()
+
+	get := func(method string, path string, remote string) *httptest.ResponseRecorder {
+		r, _ := http.NewRequest(method, path, nil)
+		r.Header.Set("Accept", "application/json")
+		r.RemoteAddr = remote
+		w := httptest.NewRecorder()
+		node_handler(w, r)
+		return w
+	}
+	w := get("GET", "/nodes/_stale?age=24h", "10.1.2.3:40000")
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET /nodes/_stale gave %d: %s", w.Code, w.Body.String())
+	}
+	var listed map[string]interface{}
+	if err := json.Unmarshal(w.Body.Bytes(), &listed); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := listed["stalenode"]; !ok {
+		t.Errorf("stalenode should have been listed as stale: %v", listed)
+	}
+	if _, ok := listed["freshnode"]; ok {
+		t.Errorf("freshnode shouldn't have been listed as stale: %v", listed)
+	}
+	if w = get("GET", "/nodes/_stale?age=nonsense", "10.1.2.3:40000"); w.Code != http.StatusBadRequest {
+		t.Errorf("GET /nodes/_stale with a bad age gave %d, expected 400", w.Code)
+	}
+	if w = get("DELETE", "/nodes/_stale", "10.1.2.3:40000"); w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("DELETE /nodes/_stale gave %d, expected 405", w.Code)
+	}
+
+	nets, _ := config.ParseCIDRs([]string{"10.0.0.0/8"})
+	config.Config.AdminIPAllowNets = nets
+	if w = get("GET", "/nodes/_stale?age=24h", "192.0.2.10:40000"); w.Code != http.StatusForbidden {
+		t.Errorf("GET /nodes/_stale from outside admin-ip-allow gave %d, expected 403", w.Code)
+	}
+	config.Config.AdminIPAllowNets = nil
+
+	/* Without use-auth, everyone's an admin. */
+	nonadmin, _ := client.New("stalereader")
+	config.Config.UseAuth = true
+	r, _ := http.NewRequest("GET", "/nodes/_stale?age=24h", nil)
+	w = httptest.NewRecorder()
+	node_stale(w, r, nonadmin)
+	config.Config.UseAuth = false
+	if w.Code != http.StatusForbidden {
+		t.Errorf("GET /nodes/_stale as a non-admin gave %d, expected 403", w.Code)
+	}
+
+	/* A node found to be stale that checks in before it's deleted has to
+	 * be left alone. */
+	webui, _ := client.New("stalewebui")
+	found := node.StaleNodes(24 * time.Hour)
+	checked_in, _ := node.Get("stalenode")
+	checked_in.Automatic["ohai_time"] = float64(time.Now().Unix())
+	checked_in.Save()
+	for _, n := range found {
+		if n.Name == "stalenode" && deleteStaleNode(n.Name, 24 * time.Hour, webui) {
+			t.Errorf("stalenode was deleted even though it checked in after it was found to be stale")
+		}
+	}
+	if _, err := node.Get("stalenode"); err != nil {
+		t.Errorf("stalenode should still exist: %s", err.Error())
+	}
+
+	old, _ := node.New("oldnode")
+	old.Automatic["ohai_time"] = long_ago
+	old.Save()
+	if !deleteStaleNode("oldnode", 24 * time.Hour, webui) {
+		t.Errorf("oldnode should have been deleted as stale")
+	}
+	if _, err := node.Get("oldnode"); err == nil {
+		t.Errorf("oldnode still exists after being deleted as stale")
+	}
+}
+
+func TestNodeExpandedRunList(t *testing.T) {
+	if admin, err := client.New("admin"); err == nil {
+		admin.Admin = true
+		admin.Save()
+	}
+	rl, _ := role.New("expwebrole")
+	rl.RunList = []string{ "recipe[nginx]" }
+	rl.EnvRunLists = map[string][]string{ "expprod": { "recipe[nginx::ssl]" } }
+	rl.Save()
+	for _, e := range []string{ "expdev", "expprod" } {
+		env, _ := environment.New(e)
+		env.Save()
+	}
+	n, _ := node.New("expnode")
+	n.ChefEnvironment = "expdev"
+	n.RunList = []string{ "recipe[base]", "role[expwebrole]" }
+	n.Save()
+
+	get := func(path string) *httptest.ResponseRecorder {
+		r, _ := http.NewRequest("GET", path, nil)
+		r.Header.Set("Accept", "application/json")
+		w := httptest.NewRecorder()
+		node_handler(w, r)
+		return w
+	}
+	var expanded struct{
+		Environment string `json:"environment"`
+		Roles []string `json:"roles"`
+		Recipes []string `json:"recipes"`
+	}
+	w := get("/nodes/expnode/_expanded_run_list")
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expanding expnode's run list gave %d: %s", w.Code, w.Body.String())
+	}
+	json.Unmarshal(w.Body.Bytes(), &expanded)
+	if expanded.Environment != "expdev" || !reflect.DeepEqual(expanded.Recipes, []string{ "base", "nginx" }) || !reflect.DeepEqual(expanded.Roles, []string{ "expwebrole" }) {
+		t.Errorf("expnode's run list expanded to %+v", expanded)
+	}
+	w = get("/nodes/expnode/_expanded_run_list?env=expprod")
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expanding expnode's run list for expprod gave %d: %s", w.Code, w.Body.String())
+	}
+	json.Unmarshal(w.Body.Bytes(), &expanded)
+	if expanded.Environment != "expprod" || !reflect.DeepEqual(expanded.Recipes, []string{ "base", "nginx::ssl" }) {
+		t.Errorf("expnode's run list for expprod expanded to %+v", expanded)
+	}
+	if w = get("/nodes/expnode/_expanded_run_list?env=expnowhere"); w.Code != http.StatusNotFound {
+		t.Errorf("Expanding a run list for a missing environment gave %d, expected 404", w.Code)
+	}
+}
+
+func TestRequestLimit(t *testing.T) {
+	config.Config.RequestQueueTimeoutDur = 10 * time.Millisecond
+	requestSem = make(chan bool, 1)
+	defer func() {
+		config.Config.RequestQueueTimeoutDur = 0
+		requestSem = nil
+	}()
+	h := &InterceptHandler{}
+	get := func(path string) int {
+		r, _ := http.NewRequest("GET", path, nil)
+		r.Header.Set("Accept", "application/json")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	/* Take the only slot, like a slow request would. */
+	requestSem <- true
+	if code := get("/principals/admin"); code != http.StatusServiceUnavailable {
+		t.Errorf("A request with no free slots gave %d, expected 503", code)
+	}
+	if code := get("/"); code == http.StatusServiceUnavailable {
+		t.Errorf("The root path should skip the limit, but gave a 503")
+	}
+	<-requestSem
+	if code := get("/principals/admin"); code == http.StatusServiceUnavailable {
+		t.Errorf("A request with a free slot was rejected")
+	}
+	if len(requestSem) != 0 {
+		t.Errorf("A finished request didn't give its slot back")
+	}
+}
+
+func TestNotice(t *testing.T) {
+	if admin, err := client.New("admin"); err == nil {
+		admin.Admin = true
+		admin.Save()
+	}
+	defer notice.set("")
+	set := func(method string, body string) *httptest.ResponseRecorder {
+		r, _ := http.NewRequest(method, "/notice", strings.NewReader(body))
+		r.Header.Set("Accept", "application/json")
+		r.Header.Set("X-Ops-Userid", "admin")
+		w := httptest.NewRecorder()
+		notice_handler(w, r)
+		return w
+	}
+	/* Every response gets the notice, whatever it's for. */
+	other := func() http.Header {
+		r, _ := http.NewRequest("GET", "/principals/admin", nil)
+		r.Header.Set("Accept", "application/json")
+		w := httptest.NewRecorder()
+		(&InterceptHandler{}).ServeHTTP(w, r)
+		return w.Header()
+	}
+
+	w := set("PUT", `{"notice":"Down for maintenance at 22:00"}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Setting the notice gave %d: %s", w.Code, w.Body.String())
+	}
+	if n := w.Header().Get("X-Goiardi-Notice"); n != "Down for maintenance at 22:00" {
+		t.Errorf("The response setting the notice had notice header '%s'", n)
+	}
+	if n := other().Get("X-Goiardi-Notice"); n != "Down for maintenance at 22:00" {
+		t.Errorf("Other responses had notice header '%s' after the notice was set", n)
+	}
+	if w := set("PUT", `{"notice":5}`); w.Code != http.StatusBadRequest {
+		t.Errorf("Setting an invalid notice gave %d, expected 400", w.Code)
+	}
+
+	/* Only admins can change it. */
+	config.Config.UseAuth = true
+	nonadmin, _ := client.New("noticeuser")
+	nonadmin.Save()
+	r, _ := http.NewRequest("DELETE", "/notice", nil)
+	r.Header.Set("X-Ops-Userid", "noticeuser")
+	w = httptest.NewRecorder()
+	notice_handler(w, r)
+	config.Config.UseAuth = false
+	if w.Code != http.StatusForbidden {
+		t.Errorf("A non-admin clearing the notice gave %d, expected 403", w.Code)
+	}
+
+	w = set("DELETE", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("Clearing the notice gave %d: %s", w.Code, w.Body.String())
+	}
+	if _, found := w.Header()["X-Goiardi-Notice"]; found {
+		t.Errorf("The response clearing the notice still had the notice header")
+	}
+	if _, found := other()["X-Goiardi-Notice"]; found {
+		t.Errorf("Responses still had the notice header after it was cleared")
+	}
+}
+
+func TestAutoCreateEnvironments(t *testing.T) {
+	if admin, err := client.New("admin"); err == nil {
+		admin.Admin = true
+		admin.Save()
+	}
+	save := func(method string, path string, name string, env string) {
+		body := fmt.Sprintf(`{"name":"%s","chef_environment":"%s","json_class":"Chef::Node","chef_type":"node","run_list":[]}`, name, env)
+		r, _ := http.NewRequest(method, path, strings.NewReader(body))
+		r.Header.Set("Accept", "application/json")
+		w := httptest.NewRecorder()
+		if method == "POST" {
+			list_handler(w, r)
+		} else {
+			node_handler(w, r)
+		}
+		if w.Code != http.StatusOK && w.Code != http.StatusCreated {
+			t.Fatalf("%s %s gave %d: %s", method, path, w.Code, w.Body.String())
+		}
+	}
+
+	save("POST", "/nodes", "autoenvnode1", "autoenvoff")
+	if _, err := environment.Get("autoenvoff"); err == nil {
+		t.Errorf("An environment was created for a node without auto-create-environments set")
+	}
+
+	config.Config.AutoCreateEnvironments = true
+	defer func() { config.Config.AutoCreateEnvironments = false }()
+	save("POST", "/nodes", "autoenvnode2", "autoenvcreated")
+	if _, err := environment.Get("autoenvcreated"); err != nil {
+		t.Errorf("Creating a node in a missing environment didn't create it: %s", err.Error())
+	}
+	save("PUT", "/nodes/autoenvnode1", "autoenvnode1", "autoenvupdated")
+	if _, err := environment.Get("autoenvupdated"); err != nil {
+		t.Errorf("Moving a node to a missing environment didn't create it: %s", err.Error())
+	}
+	/* An environment that's already there is left alone. */
+	env, _ := environment.Get("autoenvcreated")
+	env.Description = "leave me be"
+	env.Save()
+	save("PUT", "/nodes/autoenvnode2", "autoenvnode2", "autoenvcreated")
+	if env, _ := environment.Get("autoenvcreated"); env.Description != "leave me be" {
+		t.Errorf("Saving a node in an existing environment replaced the environment")
+	}
+}
+
+func TestSearchWhileReindexing(t *testing.T) {
+	if admin, err := client.New("admin"); err == nil {
+		admin.Admin = true
+		admin.Save()
+	}
+	search := func() *httptest.ResponseRecorder {
+		r, _ := http.NewRequest("GET", "/search/node?q=*:*", nil)
+		r.Header.Set("Accept", "application/json")
+		w := httptest.NewRecorder()
+		search_handler(w, r)
+		return w
+	}
+	indexer.SetRebuilding(true)
+	defer func() {
+		indexer.SetRebuilding(false)
+		config.Config.SearchWhileReindexing = ""
+	}()
+
+	config.Config.SearchWhileReindexing = "error"
+	w := search()
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("Searching during a reindex gave %d, expected 503", w.Code)
+	}
+	if w.Header().Get("Retry-After") == "" {
+		t.Errorf("Searching during a reindex didn't say when to retry")
+	}
+
+	config.Config.SearchWhileReindexing = "serve"
+	w = search()
+	if w.Code != http.StatusOK {
+		t.Errorf("Searching during a reindex with 'serve' gave %d: %s", w.Code, w.Body.String())
+	}
+	if w.Header().Get("X-Goiardi-Search-Incomplete") != "true" {
+		t.Errorf("Searching during a reindex with 'serve' didn't mark the results incomplete")
+	}
+
+	indexer.SetRebuilding(false)
+	config.Config.SearchWhileReindexing = "error"
+	w = search()
+	if w.Code != http.StatusOK {
+		t.Errorf("Searching after the reindex gave %d: %s", w.Code, w.Body.String())
+	}
+	if _, found := w.Header()["X-Goiardi-Search-Incomplete"]; found {
+		t.Errorf("Results after the reindex were marked incomplete")
+	}
+}
+
+func TestDataBagItemEnvOverlay(t *testing.T) {
+	if admin, err := client.New("admin"); err == nil {
+		admin.Admin = true
+		admin.Save()
+	}
+	db, _ := data_bag.New("overlaybag")
+	db.Save()
+	db.NewDBItem(map[string]interface{}{ "id": "conf", "level": "base", "port": 80 })
+	db.NewDBItem(map[string]interface{}{ "id": "conf__prod", "level": "prod" })
+
+	get := func(path string) *httptest.ResponseRecorder {
+		r, _ := http.NewRequest("GET", path, nil)
+		r.Header.Set("Accept", "application/json")
+		w := httptest.NewRecorder()
+		data_handler(w, r)
+		return w
+	}
+	w := get("/data/overlaybag/conf?env=prod")
+	if w.Code != http.StatusOK {
+		t.Fatalf("Getting the item for prod gave %d: %s", w.Code, w.Body.String())
+	}
+	var item map[string]interface{}
+	json.Unmarshal(w.Body.Bytes(), &item)
+	if item["id"] != "conf" || item["level"] != "prod" || item["port"] != float64(80) {
+		t.Errorf("The item for prod was %v", item)
+	}
+	if w = get("/data/overlaybag/conf?env=bad+env"); w.Code != http.StatusBadRequest {
+		t.Errorf("Getting an item for an invalid environment name gave %d, expected 400", w.Code)
+	}
+	if w = get("/data/overlaybag/nothere?env=prod"); w.Code != http.StatusNotFound {
+		t.Errorf("Getting a missing item for an environment gave %d, expected 404", w.Code)
+	}
+}
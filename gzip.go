@@ -0,0 +1,104 @@
+/*
+ * Copyright (c) 2013-2014, Jeremy Bingham (<jbingham@gmail.com>)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"compress/gzip"
+	"net/http"
+	"strings"
+)
+
+/* Compresses responses for clients that ask for it. Whether to compress isn't
+ * known until the status is, since 204 and 304 responses can't have a body:
+ * gzipping them would send the gzip header and trailer where no body is
+ * allowed. */
+type gzipResponseWriter struct {
+	http.ResponseWriter
+	gz *gzip.Writer
+	level int
+	wroteHeader bool
+	compress bool
+}
+
+func newGzipResponseWriter(w http.ResponseWriter, level int) *gzipResponseWriter {
+	return &gzipResponseWriter{ ResponseWriter: w, level: level }
+}
+
+func (g *gzipResponseWriter) Write(b []byte) (int, error) {
+	if !g.wroteHeader {
+		g.WriteHeader(http.StatusOK)
+	}
+	if !g.compress {
+		return g.ResponseWriter.Write(b)
+	}
+	if g.gz == nil {
+		/* The level was checked when the config was read. */
+		g.gz, _ = gzip.NewWriterLevel(g.ResponseWriter, g.level)
+	}
+	return g.gz.Write(b)
+}
+
+func (g *gzipResponseWriter) WriteHeader(status int) {
+	if !g.wroteHeader {
+		g.wroteHeader = true
+		if bodyAllowed(status) {
+			g.compress = true
+			g.Header().Set("Content-Encoding", "gzip")
+			/* Any length set by the handler is for the
+			 * uncompressed body. */
+			g.Header().Del("Content-Length")
+		}
+	}
+	g.ResponseWriter.WriteHeader(status)
+}
+
+/* Let streaming responses, like newline-delimited search results, still get
+ * flushed out to the client as they go. */
+func (g *gzipResponseWriter) Flush() {
+	if g.gz != nil {
+		g.gz.Flush()
+	}
+	if f, ok := g.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+/* Finish off the gzip stream, if anything was written to it. */
+func (g *gzipResponseWriter) close() {
+	if g.gz != nil {
+		g.gz.Close()
+	}
+}
+
+func bodyAllowed(status int) bool {
+	switch {
+		case status >= 100 && status <= 199:
+			return false
+		case status == http.StatusNoContent, status == http.StatusNotModified:
+			return false
+	}
+	return true
+}
+
+func acceptsGzip(r *http.Request) bool {
+	for _, ae := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		if strings.TrimSpace(strings.Split(ae, ";")[0]) == "gzip" {
+			return true
+		}
+	}
+	return false
+}
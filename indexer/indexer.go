@@ -24,6 +24,7 @@ import (
 	"git.tideland.biz/goas/logger"
 	"sync"
 	"strings"
+	"strconv"
 	"sort"
 	"fmt"
 	"regexp"
@@ -299,64 +300,63 @@ func (idoc *IdxDoc) TextSearch(term string) (bool, error) {
 	return m, nil
 }
 
-// Searches for a range of values.
+// Searches for a range of values. Values are compared as numbers if both they
+// and the end of the range they're compared to are numbers, and as strings
+// otherwise. "*" for either end of the range leaves that end open.
 func (idoc *IdxDoc) RangeSearch(field string, start string, end string, inclusive bool) (bool, error) {
 	// The parser should catch a lot of possible errors, happily
 
 	// "*" is permitted as a range that indicates anything bigger or smaller
-	// than the other range, depending
-	wildStart := false
-	wildEnd := false
-	if start == "*" {
-		wildStart = true
-	}
-	if end == "*" {
-		wildEnd = true
-	}
-	if wildStart && wildEnd {
-		err := fmt.Errorf("you can't have both start and end be wild in a range search, sadly")
-		return false, err
-	}
+	// than the other range, depending. With both ends wild, anything with
+	// the field at all matches.
+	wildStart := start == "*"
+	wildEnd := end == "*"
 	idoc.m.RLock()
 	defer idoc.m.RUnlock()
 	key := fmt.Sprintf("%s:", field)
 	if n, _ := idoc.trie.HasPrefix(key); n != nil {
 		kids := n.ChildKeys()
 		for _, child := range kids {
-			if inclusive {
-				if wildStart {
-					if child <= end {
-						return true, nil
-					}
-				} else if wildEnd {
-					if child >= start {
-						return true, nil
-					}
-				} else {
-					if child >= start && child <= end {
-						return true, nil
-					}
+			if !wildStart {
+				c := compareRangeValues(child, start)
+				if c < 0 || (c == 0 && !inclusive) {
+					continue
 				}
-			} else {
-				if wildStart {
-					if child < end {
-						return true, nil
-					}
-				} else if wildEnd {
-					if child > start {
-						return true, nil
-					}
-				} else {
-					if child > start && child < end {
-						return true, nil
-					}
+			}
+			if !wildEnd {
+				c := compareRangeValues(child, end)
+				if c > 0 || (c == 0 && !inclusive) {
+					continue
 				}
 			}
+			return true, nil
 		}
 	}
 	return false, nil
 }
 
+/* Compare a value with one end of a range, returning -1, 0, or 1. */
+func compareRangeValues(a string, b string) int {
+	fa, aerr := strconv.ParseFloat(a, 64)
+	fb, berr := strconv.ParseFloat(b, 64)
+	if aerr == nil && berr == nil {
+		switch {
+			case fa < fb:
+				return -1
+			case fa > fb:
+				return 1
+		}
+		return 0
+	}
+	switch {
+		case a < b:
+			return -1
+		case a > b:
+			return 1
+	}
+	return 0
+}
+
 func (idoc *IdxDoc) exactSearch(term string) bool {
 	return idoc.trie.Accepts(term)
 }
@@ -564,6 +564,27 @@ func (i *Index) load(idxFile string) error {
 	return fp.Close()
 }
 
+/* Set while the index is being rebuilt, when searches would only see some of
+ * the objects. */
+var rebuilding struct {
+	sync.RWMutex
+	on bool
+}
+
+// Mark the index as being rebuilt, or not.
+func SetRebuilding(on bool) {
+	rebuilding.Lock()
+	defer rebuilding.Unlock()
+	rebuilding.on = on
+}
+
+// Is the index being rebuilt right now?
+func Rebuilding() bool {
+	rebuilding.RLock()
+	defer rebuilding.RUnlock()
+	return rebuilding.on
+}
+
 // Clear index of all collections and documents
 func ClearIndex() {
 	indexMap.makeDefaultCollections()
@@ -29,6 +29,7 @@ import (
 	"github.com/ctdk/goiardi/client"
 	"github.com/ctdk/goiardi/user"
 	"github.com/ctdk/goiardi/log_info"
+	"github.com/ctdk/goiardi/data_store"
 )
 
 func list_handler(w http.ResponseWriter, r *http.Request){
@@ -63,7 +64,7 @@ func node_handling(w http.ResponseWriter, r *http.Request) map[string]string {
 	node_response := make(map[string]string)
 	opUser, oerr := actor.GetReqUser(r.Header.Get("X-OPS-USERID"))
 	if oerr != nil {
-		JsonErrorReport(w, r, oerr.Error(), oerr.Status())
+		GerrorReport(w, r, oerr)
 		return nil
 	}
 	switch r.Method {
@@ -72,7 +73,19 @@ func node_handling(w http.ResponseWriter, r *http.Request) map[string]string {
 				JsonErrorReport(w, r, "You are not allowed to take this action.", http.StatusForbidden)
 				return nil
 			}
-			node_list := node.GetList()
+			offset, limit, paged, perr := listPageParams(r)
+			if perr != nil {
+				JsonErrorReport(w, r, perr.Error(), http.StatusBadRequest)
+				return nil
+			}
+			var node_list []string
+			if paged {
+				var total int
+				node_list, total = node.GetListPage(offset, limit)
+				setListTotal(w, total)
+			} else {
+				node_list = node.GetList()
+			}
 			for _, k := range node_list {
 				item_url := fmt.Sprintf("/nodes/%s", k)
 				node_response[k] = util.CustomURL(item_url)
@@ -101,7 +114,11 @@ func node_handling(w http.ResponseWriter, r *http.Request) map[string]string {
 			var nerr util.Gerror
 			chef_node, nerr = node.NewFromJson(node_data)
 			if nerr != nil {
-				JsonErrorReport(w, r, nerr.Error(), nerr.Status())
+				GerrorReport(w, r, nerr)
+				return nil
+			}
+			if eerr := autoCreateNodeEnv(opUser, chef_node.ChefEnvironment); eerr != nil {
+				GerrorReport(w, r, eerr)
 				return nil
 			}
 			err := chef_node.Save()
@@ -126,13 +143,51 @@ func client_handling(w http.ResponseWriter, r *http.Request) map[string]string {
 	client_response := make(map[string]string)
 	opUser, oerr := actor.GetReqUser(r.Header.Get("X-OPS-USERID"))
 	if oerr != nil {
-		JsonErrorReport(w, r, oerr.Error(), oerr.Status())
+		GerrorReport(w, r, oerr)
 		return nil
 	}
 
 	switch r.Method {
 		case "GET":
-			client_list := client.GetList()
+			offset, limit, paged, perr := listPageParams(r)
+			if perr != nil {
+				JsonErrorReport(w, r, perr.Error(), http.StatusBadRequest)
+				return nil
+			}
+			sorted, desc, serr := listSortParam(r)
+			if serr != nil {
+				JsonErrorReport(w, r, serr.Error(), http.StatusBadRequest)
+				return nil
+			}
+			var client_list []string
+			/* Optionally only list clients that have never
+			 * authenticated, to help find stale keys. */
+			if r.FormValue("never_used") == "true" {
+				if !opUser.IsAdmin() {
+					JsonErrorReport(w, r, "You are not allowed to perform that action.", http.StatusForbidden)
+					return nil
+				}
+				client_list = client.GetNeverUsedList()
+				if sorted {
+					client_list = data_store.SortList(client_list, desc)
+				}
+				if paged {
+					setListTotal(w, len(client_list))
+					client_list = data_store.PageList(client_list, offset, limit)
+				}
+			} else if paged || sorted {
+				var total int
+				client_list, total = client.GetSortedListPage(offset, limit, desc)
+				setListTotal(w, total)
+			} else {
+				client_list = client.GetList()
+			}
+			if sorted {
+				/* Already sent, there's nothing left for
+				 * list_handler to do. */
+				writeSortedList(w, r, client_list, "/clients/%s")
+				return nil
+			}
 			for _, k := range client_list {
 				/* Make sure it's a client and not a user. */
 				item_url := fmt.Sprintf("/clients/%s", k)
@@ -145,7 +200,7 @@ func client_handling(w http.ResponseWriter, r *http.Request) map[string]string {
 				return nil
 			}
 			if averr := util.CheckAdminPlusValidator(client_data); averr != nil {
-				JsonErrorReport(w, r, averr.Error(), averr.Status())
+				GerrorReport(w, r, averr)
 				return nil
 			}
 			if !opUser.IsAdmin() && !opUser.IsValidator() {
@@ -153,11 +208,11 @@ func client_handling(w http.ResponseWriter, r *http.Request) map[string]string {
 				return nil
 			} else if !opUser.IsAdmin() && opUser.IsValidator() {
 				if aerr := opUser.CheckPermEdit(client_data, "admin"); aerr != nil {
-					JsonErrorReport(w, r, aerr.Error(), aerr.Status())
+					GerrorReport(w, r, aerr)
 					return nil
 				}
 				if verr := opUser.CheckPermEdit(client_data, "validator"); verr != nil {
-					JsonErrorReport(w, r, verr.Error(), verr.Status())
+					GerrorReport(w, r, verr)
 					return nil
 				}
 
@@ -171,7 +226,7 @@ func client_handling(w http.ResponseWriter, r *http.Request) map[string]string {
 
 			chef_client, err := client.NewFromJson(client_data)
 			if err != nil {
-				JsonErrorReport(w, r, err.Error(), err.Status())
+				GerrorReport(w, r, err)
 				return nil
 			}
 
@@ -185,7 +240,7 @@ func client_handling(w http.ResponseWriter, r *http.Request) map[string]string {
 				switch public_key := public_key.(type) {
 					case string:
 						if pkok, pkerr := client.ValidatePublicKey(public_key); !pkok {
-							JsonErrorReport(w, r, pkerr.Error(), pkerr.Status())
+							GerrorReport(w, r, pkerr)
 							return nil
 						}
 						chef_client.SetPublicKey(public_key)
@@ -224,13 +279,49 @@ func user_handling(w http.ResponseWriter, r *http.Request) map[string]string {
 	user_response := make(map[string]string)
 	opUser, oerr := actor.GetReqUser(r.Header.Get("X-OPS-USERID"))
 	if oerr != nil {
-		JsonErrorReport(w, r, oerr.Error(), oerr.Status())
+		GerrorReport(w, r, oerr)
 		return nil
 	}
 
 	switch r.Method {
 		case "GET":
-			user_list := user.GetList()
+			offset, limit, paged, perr := listPageParams(r)
+			if perr != nil {
+				JsonErrorReport(w, r, perr.Error(), http.StatusBadRequest)
+				return nil
+			}
+			sorted, desc, serr := listSortParam(r)
+			if serr != nil {
+				JsonErrorReport(w, r, serr.Error(), http.StatusBadRequest)
+				return nil
+			}
+			var user_list []string
+			if r.FormValue("never_used") == "true" {
+				if !opUser.IsAdmin() {
+					JsonErrorReport(w, r, "You are not allowed to perform that action.", http.StatusForbidden)
+					return nil
+				}
+				user_list = user.GetNeverUsedList()
+				if sorted {
+					user_list = data_store.SortList(user_list, desc)
+				}
+				if paged {
+					setListTotal(w, len(user_list))
+					user_list = data_store.PageList(user_list, offset, limit)
+				}
+			} else if paged || sorted {
+				var total int
+				user_list, total = user.GetSortedListPage(offset, limit, desc)
+				setListTotal(w, total)
+			} else {
+				user_list = user.GetList()
+			}
+			if sorted {
+				/* Already sent, there's nothing left for
+				 * list_handler to do. */
+				writeSortedList(w, r, user_list, "/users/%s")
+				return nil
+			}
 			for _, k := range user_list {
 				/* Make sure it's a client and not a user. */
 				item_url := fmt.Sprintf("/users/%s", k)
@@ -243,7 +334,7 @@ func user_handling(w http.ResponseWriter, r *http.Request) map[string]string {
 				return nil
 			}
 			if averr := util.CheckAdminPlusValidator(user_data); averr != nil {
-				JsonErrorReport(w, r, averr.Error(), averr.Status())
+				GerrorReport(w, r, averr)
 				return nil
 			}
 			if !opUser.IsAdmin() && !opUser.IsValidator() {
@@ -251,11 +342,11 @@ func user_handling(w http.ResponseWriter, r *http.Request) map[string]string {
 				return nil
 			} else if !opUser.IsAdmin() && opUser.IsValidator() {
 				if aerr := opUser.CheckPermEdit(user_data, "admin"); aerr != nil {
-					JsonErrorReport(w, r, aerr.Error(), aerr.Status())
+					GerrorReport(w, r, aerr)
 					return nil
 				}
 				if verr := opUser.CheckPermEdit(user_data, "validator"); verr != nil {
-					JsonErrorReport(w, r, verr.Error(), verr.Status())
+					GerrorReport(w, r, verr)
 					return nil
 				}
 
@@ -269,7 +360,7 @@ func user_handling(w http.ResponseWriter, r *http.Request) map[string]string {
 
 			chef_user, err := user.NewFromJson(user_data)
 			if err != nil {
-				JsonErrorReport(w, r, err.Error(), err.Status())
+				GerrorReport(w, r, err)
 				return nil
 			}
 
@@ -283,7 +374,7 @@ func user_handling(w http.ResponseWriter, r *http.Request) map[string]string {
 				switch public_key := public_key.(type) {
 					case string:
 						if pkok, pkerr := user.ValidatePublicKey(public_key); !pkok {
-							JsonErrorReport(w, r, pkerr.Error(), pkerr.Status())
+							GerrorReport(w, r, pkerr)
 							return nil
 						}
 						chef_user.SetPublicKey(public_key)
@@ -321,7 +412,7 @@ func role_handling(w http.ResponseWriter, r *http.Request) map[string]string {
 	role_response := make(map[string]string)
 	opUser, oerr := actor.GetReqUser(r.Header.Get("X-OPS-USERID"))
 	if oerr != nil {
-		JsonErrorReport(w, r, oerr.Error(), oerr.Status())
+		GerrorReport(w, r, oerr)
 		return nil
 	}
 	switch r.Method {
@@ -330,7 +421,19 @@ func role_handling(w http.ResponseWriter, r *http.Request) map[string]string {
 				JsonErrorReport(w, r, "You are not allowed to take this action.", http.StatusForbidden)
 				return nil
 			}
-			role_list := role.GetList()
+			offset, limit, paged, perr := listPageParams(r)
+			if perr != nil {
+				JsonErrorReport(w, r, perr.Error(), http.StatusBadRequest)
+				return nil
+			}
+			var role_list []string
+			if paged {
+				var total int
+				role_list, total = role.GetListPage(offset, limit)
+				setListTotal(w, total)
+			} else {
+				role_list = role.GetList()
+			}
 			for _, k := range role_list {
 				item_url := fmt.Sprintf("/roles/%s", k)
 				role_response[k] = util.CustomURL(item_url)
@@ -358,7 +461,7 @@ func role_handling(w http.ResponseWriter, r *http.Request) map[string]string {
 			var nerr util.Gerror
 			chef_role, nerr = role.NewFromJson(role_data)
 			if nerr != nil {
-				JsonErrorReport(w, r, nerr.Error(), nerr.Status())
+				GerrorReport(w, r, nerr)
 				return nil
 			}
 			err := chef_role.Save()
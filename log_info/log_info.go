@@ -27,6 +27,7 @@ import (
 	"reflect"
 	"database/sql"
 	"sort"
+	"sync/atomic"
 	"git.tideland.biz/goas/logger"
 )
 
@@ -42,10 +43,33 @@ type LogInfo struct {
 	Id int `json:"id"`
 }
 
+/* How many events couldn't be logged while event-log-failure was set to
+ * best-effort. */
+var failedWrites int64
+
 // Write an event of the action type, performed by the given actor, against the
-// given object.
+// given object. If the event can't be written, the error is returned so the
+// operation that caused it can fail, unless event-log-failure is set to
+// "best-effort", in which case the failure is logged and counted and nil is
+// returned.
 func LogEvent(doer actor.Actor, obj util.GoiardiObj, action string) error {
-	if !config.Config.LogEvents {
+	err := logEvent(doer, obj, action)
+	if err != nil && config.Config.EventLogFailure == "best-effort" {
+		atomic.AddInt64(&failedWrites, 1)
+		logger.Errorf("Could not log %s event for %s %s, carrying on anyway: %s", action, obj.URLType(), obj.GetName(), err.Error())
+		return nil
+	}
+	return err
+}
+
+// The number of events that have failed to be logged since goiardi started
+// while event-log-failure was set to "best-effort".
+func FailedWrites() int64 {
+	return atomic.LoadInt64(&failedWrites)
+}
+
+func logEvent(doer actor.Actor, obj util.GoiardiObj, action string) error {
+	if !config.Config.LogEvents && len(config.Config.Webhooks) == 0 {
 		logger.Debugf("Not logging this event")
 		return nil
 	} else {
@@ -75,11 +99,19 @@ func LogEvent(doer actor.Actor, obj util.GoiardiObj, action string) error {
 	}
 	le.ActorInfo = actor_info
 
-	if config.Config.UseMySQL {
-		return le.writeEventMySQL()
-	} else {
-		return le.writeEventInMem()
+	/* Events may only be going out to webhooks and not kept. */
+	if config.Config.LogEvents {
+		if config.Config.UseSQL {
+			err = le.writeEventSQL()
+		} else {
+			err = le.writeEventInMem()
+		}
+		if err != nil {
+			return err
+		}
 	}
+	sendWebhooks(le, obj.URLType())
+	return nil
 }
 
 func (le *LogInfo)writeEventInMem() error {
@@ -91,9 +123,9 @@ func (le *LogInfo)writeEventInMem() error {
 func Get(id int) (*LogInfo, error) {
 	var le *LogInfo
 
-	if config.Config.UseMySQL {
+	if config.Config.UseSQL {
 		var err error
-		le, err = getLogEventMySQL(id)
+		le, err = getLogEventSQL(id)
 		if err != nil {
 			if err == sql.ErrNoRows {
 				err = fmt.Errorf("Couldn't find log event with id %d", id)
@@ -114,9 +146,90 @@ func Get(id int) (*LogInfo, error) {
 	return le, nil
 }
 
+// Get several events at once by their ids. The events that were found are
+// returned in the same order as their ids, along with the ids that weren't
+// found.
+func GetMany(ids []int) ([]*LogInfo, []int, error) {
+	var found map[int]*LogInfo
+	if config.Config.UseSQL {
+		var err error
+		found, err = getLogEventsSQL(ids)
+		if err != nil {
+			return nil, nil, err
+		}
+	} else {
+		found = make(map[int]*LogInfo, len(ids))
+		for _, id := range ids {
+			if le, err := Get(id); err == nil && le != nil {
+				found[id] = le
+			}
+		}
+	}
+	les := make([]*LogInfo, 0, len(found))
+	missing := make([]int, 0)
+	for _, id := range ids {
+		if le, ok := found[id]; ok {
+			les = append(les, le)
+		} else {
+			missing = append(missing, id)
+		}
+	}
+	return les, missing, nil
+}
+
+// The fields logged events can be filtered on when building a histogram.
+var HistogramFilters = []string{ "action", "object_type", "actor_type" }
+
+// Count the logged events in buckets of the given interval, oldest bucket
+// first. Buckets are lined up on multiples of the interval since the Unix
+// epoch, so an interval of an hour gives buckets starting on the hour, and
+// buckets with no events in them are left out. The filters map may hold any of
+// the fields in HistogramFilters; only events whose fields match all the given
+// values are counted.
+func Histogram(interval time.Duration, filters map[string]string) ([]*util.TimeBucket, error) {
+	if interval < time.Second {
+		err := fmt.Errorf("Histogram interval must be at least one second")
+		return nil, err
+	}
+	secs := int64(interval / time.Second)
+	if config.Config.UseSQL {
+		return histogramSQL(secs, filters)
+	}
+	counts := make(map[int64]int)
+	ds := data_store.New()
+	for _, v := range ds.GetLogInfoList() {
+		le := v.(*LogInfo)
+		if !le.matchesFilters(filters) {
+			continue
+		}
+		counts[le.Time.Unix() / secs]++
+	}
+	return util.TimeBuckets(counts, secs), nil
+}
+
+func (le *LogInfo) matchesFilters(filters map[string]string) bool {
+	for k, v := range filters {
+		var field string
+		switch k {
+			case "action":
+				field = le.Action
+			case "object_type":
+				field = le.ObjectType
+			case "actor_type":
+				field = le.ActorType
+			default:
+				return false
+		}
+		if field != v {
+			return false
+		}
+	}
+	return true
+}
+
 func (le *LogInfo)Delete() error {
-	if config.Config.UseMySQL {
-		return le.deleteMySQL()
+	if config.Config.UseSQL {
+		return le.deleteSQL()
 	} else {
 		ds := data_store.New()
 		ds.DeleteLogInfo(le.Id)
@@ -125,8 +238,8 @@ func (le *LogInfo)Delete() error {
 }
 
 func PurgeLogInfos(id int) (int64, error) {
-	if config.Config.UseMySQL {
-		return purgeMySQL(id)
+	if config.Config.UseSQL {
+		return purgeSQL(id)
 	} else {
 		ds := data_store.New()
 		return ds.PurgeLogInfoBefore(id)
@@ -138,8 +251,8 @@ func PurgeLogInfos(id int) (int64, error) {
 // (in that order) but that is not required. The offset can be specified without
 // a limit, but a limit requires an offset (which can be 0).
 func GetLogInfos(limits ...int) []*LogInfo {
-	if config.Config.UseMySQL {
-		return getLogInfoListMySQL(limits...)
+	if config.Config.UseSQL {
+		return getLogInfoListSQL(limits...)
 	} else {
 		var offset, limit int
 		if len(limits) > 0 {
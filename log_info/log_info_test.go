@@ -23,6 +23,10 @@ import (
 	"github.com/ctdk/goiardi/data_store"
 	"github.com/ctdk/goiardi/config"
 	"time"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
 )
 
 func TestLogEvent(t *testing.T) {
@@ -88,3 +92,215 @@ func TestLogEvent(t *testing.T) {
 		t.Errorf("Should have been 5 events after purging, got %d", len(arr7))
 	}
 }
+
+func TestGetMany(t *testing.T) {
+	config.Config.LogEvents = true
+	doer, _ := client.New("bulkdoer")
+	obj, _ := client.New("bulkobj")
+	LogEvent(doer, obj, "create")
+	ids := make([]int, 0)
+	for id := range data_store.New().GetLogInfoList() {
+		ids = append(ids, id)
+	}
+	ids = append(ids, 99999)
+	les, missing, err := GetMany(ids)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if len(les) != len(ids) - 1 {
+		t.Errorf("Expected %d events, got %d", len(ids) - 1, len(les))
+	}
+	for i, le := range les {
+		if le.Id != ids[i] {
+			t.Errorf("Events came back out of order: expected id %d, got %d", ids[i], le.Id)
+		}
+	}
+	if len(missing) != 1 || missing[0] != 99999 {
+		t.Errorf("Expected 99999 to be missing, got %v", missing)
+	}
+}
+
+func TestHistogram(t *testing.T) {
+	config.Config.LogEvents = true
+	doer, _ := client.New("histdoer")
+	obj, _ := client.New("histobj")
+	LogEvent(doer, obj, "histogrammed")
+	LogEvent(doer, obj, "histogrammed")
+	histogram, err := Histogram(time.Hour, map[string]string{ "action": "histogrammed" })
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	total := 0
+	for _, b := range histogram {
+		if b.BucketStart.Minute() != 0 || b.BucketStart.Second() != 0 {
+			t.Errorf("Bucket %s does not start on the hour", b.BucketStart)
+		}
+		total += b.Count
+	}
+	if total != 2 {
+		t.Errorf("Expected 2 events in the histogram, got %d", total)
+	}
+	if _, err := Histogram(time.Millisecond, nil); err == nil {
+		t.Errorf("An interval under a second should have been rejected")
+	}
+}
+
+func TestWebhook(t *testing.T) {
+	got := make(chan *http.Request, 1)
+	bodies := make(chan []byte, 1)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+		got <- r
+		bodies <- b
+	}))
+	defer ts.Close()
+	config.Config.LogEvents = false
+	config.Config.Webhooks = []config.WebhookConf{ { URL: ts.URL, Secret: "s3kr1t", Types: []string{ "clients" } } }
+	defer func() { config.Config.Webhooks = nil }()
+
+	doer, _ := client.New("hookdoer")
+	obj, _ := client.New("hookobj")
+	if err := LogEvent(doer, obj, "create"); err != nil {
+		t.Fatalf(err.Error())
+	}
+	select {
+		case r := <-got:
+			body := <-bodies
+			if sig := r.Header.Get("X-Goiardi-Signature"); sig != "sha256=" + webhookSignature("s3kr1t", body) {
+				t.Errorf("Webhook signature %s did not match the body", sig)
+			}
+		case <-time.After(5 * time.Second):
+			t.Errorf("Webhook was never delivered")
+	}
+	if webhookWants(config.Config.Webhooks[0], "nodes") {
+		t.Errorf("Webhook limited to clients should not want node events")
+	}
+}
+
+func TestWebhookWorkers(t *testing.T) {
+	release := make(chan bool)
+	var mu sync.Mutex
+	in_flight, most := 0, 0
+	delivered := make(chan bool, 6)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		in_flight++
+		if in_flight > most {
+			most = in_flight
+		}
+		mu.Unlock()
+		<-release
+		mu.Lock()
+		in_flight--
+		mu.Unlock()
+		delivered <- true
+	}))
+	defer ts.Close()
+
+	/* Start over with two workers. */
+	config.Config.WebhookWorkers = 2
+	defer func() { config.Config.WebhookWorkers = 0 }()
+	webhookWorkersOnce = sync.Once{}
+	wh := config.WebhookConf{ URL: ts.URL }
+	for i := 0; i < 6; i++ {
+		if !queueWebhook(&webhookDelivery{ wh: wh, body: []byte("{}") }) {
+			t.Fatalf("Delivery %d wasn't queued", i)
+		}
+	}
+	time.Sleep(100 * time.Millisecond)
+	for i := 0; i < 6; i++ {
+		release <- true
+	}
+	for i := 0; i < 6; i++ {
+		select {
+			case <-delivered:
+			case <-time.After(5 * time.Second):
+				t.Fatalf("Only %d of 6 webhooks were delivered", i)
+		}
+	}
+	if most != 2 {
+		t.Errorf("Expected 2 webhook deliveries at once with 2 workers, got %d", most)
+	}
+
+	/* With no room in the queue, deliveries are dropped instead of
+	 * waiting. */
+	webhookWorkersOnce = sync.Once{}
+	webhookWorkersOnce.Do(func() { webhookQueue = make(chan *webhookDelivery, 1) })
+	if !queueWebhook(&webhookDelivery{ wh: wh, body: []byte("{}") }) {
+		t.Errorf("The first delivery should have fit in the queue")
+	}
+	if queueWebhook(&webhookDelivery{ wh: wh, body: []byte("{}") }) {
+		t.Errorf("A delivery was queued even though the queue was full")
+	}
+	webhookWorkersOnce = sync.Once{}
+}
+
+type unloggable struct {
+	Name string
+	C chan bool
+}
+
+func (u *unloggable) GetName() string {
+	return u.Name
+}
+
+func (u *unloggable) URLType() string {
+	return "unloggables"
+}
+
+func TestLogEventBestEffort(t *testing.T) {
+	config.Config.LogEvents = true
+	doer, _ := client.New("doer")
+	obj := &unloggable{ Name: "nope", C: make(chan bool) }
+	config.Config.EventLogFailure = "strict"
+	if err := LogEvent(doer, obj, "create"); err == nil {
+		t.Errorf("Logging an event that couldn't be encoded should have failed in strict mode, but didn't")
+	}
+	config.Config.EventLogFailure = "best-effort"
+	defer func() { config.Config.EventLogFailure = "strict" }()
+	failed := FailedWrites()
+	if err := LogEvent(doer, obj, "create"); err != nil {
+		t.Errorf("Logging an event that couldn't be encoded should have been let go in best-effort mode, but got %s", err.Error())
+	}
+	if FailedWrites() != failed + 1 {
+		t.Errorf("Expected %d failed event writes, got %d", failed + 1, FailedWrites())
+	}
+}
+
+func TestShouldOptimize(t *testing.T) {
+	checks := []struct{ purged, total int64; pct int; want bool }{
+		{ 50, 100, 50, true },
+		{ 49, 100, 50, false },
+		{ 100, 100, 0, false },
+		{ 0, 0, 10, false },
+		{ 1, 1, 100, true },
+	}
+	for _, c := range checks {
+		if got := shouldOptimize(c.purged, c.total, c.pct); got != c.want {
+			t.Errorf("Purging %d of %d rows with optimize-event-log %d: expected %v, got %v", c.purged, c.total, c.pct, c.want, got)
+		}
+	}
+}
+
+func TestOptimizeInBackground(t *testing.T) {
+	release := make(chan bool)
+	done := make(chan bool)
+	if !optimizeInBackground(func() { <-release; done <- true }) {
+		t.Fatalf("The first optimize didn't start")
+	}
+	if optimizeInBackground(func() {}) {
+		t.Errorf("A second optimize started while the first was still running")
+	}
+	release <- true
+	<-done
+	/* The first lets go of its slot just after it's done. */
+	started := false
+	for i := 0; i < 100 && !started; i++ {
+		if started = optimizeInBackground(func() {}); !started {
+			time.Sleep(time.Millisecond)
+		}
+	}
+	if !started {
+		t.Errorf("Couldn't start another optimize after the first finished")
+	}
+}
@@ -17,11 +17,15 @@
 package log_info
 
 import (
+	"github.com/ctdk/goiardi/config"
 	"github.com/ctdk/goiardi/data_store"
+	"github.com/ctdk/goiardi/util"
+	"git.tideland.biz/goas/logger"
 	"database/sql"
 	"time"
 	"log"
 	"fmt"
+	"strings"
 )
 
 func (le *LogInfo)writeEventMySQL() error {
@@ -40,8 +44,7 @@ func (le *LogInfo)writeEventMySQL() error {
 		tx.Rollback()
 		return err
 	}
-	tx.Commit()
-	return nil
+	return tx.Commit()
 }
 
 func getLogEventMySQL(id int) (*LogInfo, error) {
@@ -52,7 +55,7 @@ func getLogEventMySQL(id int) (*LogInfo, error) {
 	}
 	defer stmt.Close()
 	row := stmt.QueryRow(id)
-	err = le.fillLogEventFromMySQL(row)
+	err = le.fillLogEventFromSQL(row)
 	if err != nil {
 		return nil, err
 	}
@@ -61,17 +64,76 @@ func getLogEventMySQL(id int) (*LogInfo, error) {
 	return le, nil
 }
 
-func (le *LogInfo)fillLogEventFromMySQL(row data_store.ResRow) error {
-	var tb []byte
-	err := row.Scan(&le.Id, &le.ActorType, &le.ActorInfo, &tb, &le.Action, &le.ObjectType, &le.ObjectName, &le.ExtendedInfo)
+func getLogEventsMySQL(ids []int) (map[int]*LogInfo, error) {
+	found := make(map[int]*LogInfo, len(ids))
+	if len(ids) == 0 {
+		return found, nil
+	}
+	placeholders := make([]string, len(ids))
+	args := make([]interface{}, len(ids))
+	for i, id := range ids {
+		placeholders[i] = "?"
+		args[i] = id
+	}
+	rows, err := data_store.Dbh.Query(fmt.Sprintf("SELECT id, actor_type, actor_info, time, action, object_type, object_name, extended_info FROM log_infos WHERE id IN (%s)", strings.Join(placeholders, ", ")), args...)
 	if err != nil {
-		return err
+		if err == sql.ErrNoRows {
+			return found, nil
+		}
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		le := new(LogInfo)
+		if err = le.fillLogEventFromSQL(rows); err != nil {
+			return nil, err
+		}
+		found[le.Id] = le
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return found, nil
+}
+
+/* The bucket number is worked out from the stored time the same way the
+ * in-memory histogram does it from the event's time, so both line buckets up
+ * on the epoch. */
+func histogramMySQL(secs int64, filters map[string]string) ([]*util.TimeBucket, error) {
+	where := make([]string, 0, len(filters))
+	args := []interface{}{ secs }
+	for _, f := range HistogramFilters {
+		if v, ok := filters[f]; ok {
+			where = append(where, fmt.Sprintf("%s = ?", f))
+			args = append(args, v)
+		}
 	}
-	le.Time, err = time.Parse(data_store.MySQLTimeFormat, string(tb))
+	query := "SELECT FLOOR(TIMESTAMPDIFF(SECOND, '1970-01-01 00:00:00', time) / ?) AS bucket, COUNT(*) FROM log_infos"
+	if len(where) > 0 {
+		query = fmt.Sprintf("%s WHERE %s", query, strings.Join(where, " AND "))
+	}
+	query = query + " GROUP BY bucket"
+	counts := make(map[int64]int)
+	rows, err := data_store.Dbh.Query(query, args...)
 	if err != nil {
-		return err
+		if err == sql.ErrNoRows {
+			return util.TimeBuckets(counts, secs), nil
+		}
+		return nil, err
 	}
-	return nil
+	defer rows.Close()
+	for rows.Next() {
+		var bucket int64
+		var count int
+		if err = rows.Scan(&bucket, &count); err != nil {
+			return nil, err
+		}
+		counts[bucket] = count
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return util.TimeBuckets(counts, secs), nil
 }
 
 func (le *LogInfo)deleteMySQL() error {
@@ -93,6 +155,17 @@ func purgeMySQL(id int) (int64, error) {
 	if err != nil {
 		return 0, err
 	}
+	/* The table's size is only needed to decide whether to optimize it
+	 * afterwards. */
+	var total int64
+	pct := config.Config.OptimizeEventLog
+	if pct > 0 {
+		err = tx.QueryRow("SELECT COUNT(*) FROM log_infos").Scan(&total)
+		if err != nil {
+			tx.Rollback()
+			return 0, err
+		}
+	}
 	res, err := tx.Exec("DELETE FROM log_infos WHERE id <= ?", id)
 	if err != nil {
 		tx.Rollback()
@@ -100,9 +173,30 @@ func purgeMySQL(id int) (int64, error) {
 	}
 	rows_affected, _ := res.RowsAffected()
 	tx.Commit()
+	if shouldOptimize(rows_affected, total, pct) {
+		if !optimizeInBackground(func() { optimizeMySQL(rows_affected, total) }) {
+			logger.Infof("Purged %d of %d events, but the log_infos table is already being optimized", rows_affected, total)
+		}
+	}
 	return rows_affected, nil
 }
 
+/* After a big purge the table's left with a lot of free space it won't give
+ * back on its own. OPTIMIZE TABLE rebuilds it, but can lock it while it does,
+ * which is why it's optional. A failure here doesn't fail the purge, which has
+ * already happened. */
+func optimizeMySQL(purged int64, total int64) {
+	logger.Infof("Purged %d of %d events, optimizing the log_infos table", purged, total)
+	start := time.Now()
+	rows, err := data_store.Dbh.Query("OPTIMIZE TABLE log_infos")
+	if err != nil {
+		logger.Errorf("Optimizing the log_infos table failed: %s", err.Error())
+		return
+	}
+	rows.Close()
+	logger.Infof("Optimized the log_infos table in %s", time.Since(start))
+}
+
 func getLogInfoListMySQL(limits ...int) []*LogInfo {
 	var offset int
 	var limit int64 = (1 << 63) - 1
@@ -129,7 +223,7 @@ func getLogInfoListMySQL(limits ...int) []*LogInfo {
 	}
 	for rows.Next() {
 		le := new(LogInfo)
-		err = le.fillLogEventFromMySQL(rows)
+		err = le.fillLogEventFromSQL(rows)
 		if err != nil {
 			log.Fatal(err)
 		}
@@ -0,0 +1,240 @@
+/*
+ * Copyright (c) 2013-2014, Jeremy Bingham (<jbingham@gmail.com>)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package log_info
+
+import (
+	"github.com/ctdk/goiardi/config"
+	"github.com/ctdk/goiardi/data_store"
+	"github.com/ctdk/goiardi/util"
+	"git.tideland.biz/goas/logger"
+	"database/sql"
+	"time"
+	"log"
+	"fmt"
+	"strings"
+)
+
+/* PostgreSQL hands timestamps back as time.Time values, but
+ * fillLogEventFromSQL parses the time as MySQL formats it, so the time is
+ * formatted to match. */
+const logTimeColumn = "to_char(time AT TIME ZONE 'UTC', 'YYYY-MM-DD HH24:MI:SS')"
+
+func (le *LogInfo)writeEventPostgreSQL() error {
+	tx, err := data_store.Dbh.Begin()
+	if err != nil {
+		return err
+	}
+	type_table := fmt.Sprintf("%ss", le.ActorType)
+	actor_id, err := data_store.CheckForOne(tx, type_table, le.Actor.GetName())
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	_, err = tx.Exec("INSERT INTO log_infos (actor_id, actor_type, actor_info, time, action, object_type, object_name, extended_info) VALUES ($1, $2, $3, $4, $5, $6, $7, $8)", actor_id, le.ActorType, le.ActorInfo, le.Time, le.Action, le.ObjectType, le.ObjectName, le.ExtendedInfo)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func getLogEventPostgreSQL(id int) (*LogInfo, error) {
+	le := new(LogInfo)
+	stmt, err := data_store.Dbh.Prepare("SELECT id, actor_type, actor_info, " + logTimeColumn + ", action, object_type, object_name, extended_info FROM log_infos WHERE id = $1")
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+	row := stmt.QueryRow(id)
+	err = le.fillLogEventFromSQL(row)
+	if err != nil {
+		return nil, err
+	}
+	// conveniently, le.Actor does not seem to need to be populated after
+	// it's been saved.
+	return le, nil
+}
+
+func getLogEventsPostgreSQL(ids []int) (map[int]*LogInfo, error) {
+	found := make(map[int]*LogInfo, len(ids))
+	if len(ids) == 0 {
+		return found, nil
+	}
+	placeholders := make([]string, len(ids))
+	args := make([]interface{}, len(ids))
+	for i, id := range ids {
+		placeholders[i] = fmt.Sprintf("$%d", i + 1)
+		args[i] = id
+	}
+	rows, err := data_store.Dbh.Query(fmt.Sprintf("SELECT id, actor_type, actor_info, " + logTimeColumn + ", action, object_type, object_name, extended_info FROM log_infos WHERE id IN (%s)", strings.Join(placeholders, ", ")), args...)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return found, nil
+		}
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		le := new(LogInfo)
+		if err = le.fillLogEventFromSQL(rows); err != nil {
+			return nil, err
+		}
+		found[le.Id] = le
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return found, nil
+}
+
+/* The bucket number is worked out from the stored time the same way the
+ * in-memory histogram does it from the event's time, so both line buckets up
+ * on the epoch. */
+func histogramPostgreSQL(secs int64, filters map[string]string) ([]*util.TimeBucket, error) {
+	where := make([]string, 0, len(filters))
+	args := []interface{}{ secs }
+	for _, f := range HistogramFilters {
+		if v, ok := filters[f]; ok {
+			where = append(where, fmt.Sprintf("%s = $%d", f, len(args) + 1))
+			args = append(args, v)
+		}
+	}
+	query := "SELECT FLOOR(EXTRACT(EPOCH FROM time) / $1)::bigint AS bucket, COUNT(*) FROM log_infos"
+	if len(where) > 0 {
+		query = fmt.Sprintf("%s WHERE %s", query, strings.Join(where, " AND "))
+	}
+	query = query + " GROUP BY bucket"
+	counts := make(map[int64]int)
+	rows, err := data_store.Dbh.Query(query, args...)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return util.TimeBuckets(counts, secs), nil
+		}
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var bucket int64
+		var count int
+		if err = rows.Scan(&bucket, &count); err != nil {
+			return nil, err
+		}
+		counts[bucket] = count
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return util.TimeBuckets(counts, secs), nil
+}
+
+func (le *LogInfo)deletePostgreSQL() error {
+	tx, err := data_store.Dbh.Begin()
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("DELETE FROM log_infos WHERE id = $1", le.Id)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	tx.Commit()
+	return nil
+}
+
+func purgePostgreSQL(id int) (int64, error) {
+	tx, err := data_store.Dbh.Begin()
+	if err != nil {
+		return 0, err
+	}
+	/* As with MySQL, the table's size is only needed to decide whether to
+	 * vacuum it afterwards. */
+	var total int64
+	pct := config.Config.OptimizeEventLog
+	if pct > 0 {
+		err = tx.QueryRow("SELECT COUNT(*) FROM log_infos").Scan(&total)
+		if err != nil {
+			tx.Rollback()
+			return 0, err
+		}
+	}
+	res, err := tx.Exec("DELETE FROM log_infos WHERE id <= $1", id)
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+	rows_affected, _ := res.RowsAffected()
+	tx.Commit()
+	if shouldOptimize(rows_affected, total, pct) {
+		if !optimizeInBackground(func() { vacuumPostgreSQL(rows_affected, total) }) {
+			logger.Infof("Purged %d of %d events, but the log_infos table is already being vacuumed", rows_affected, total)
+		}
+	}
+	return rows_affected, nil
+}
+
+/* Like optimizeMySQL, give back the space left after a big purge. VACUUM FULL
+ * rewrites the table, locking it while it does, and can't run inside a
+ * transaction. */
+func vacuumPostgreSQL(purged int64, total int64) {
+	logger.Infof("Purged %d of %d events, vacuuming the log_infos table", purged, total)
+	start := time.Now()
+	_, err := data_store.Dbh.Exec("VACUUM FULL log_infos")
+	if err != nil {
+		logger.Errorf("Vacuuming the log_infos table failed: %s", err.Error())
+		return
+	}
+	logger.Infof("Vacuumed the log_infos table in %s", time.Since(start))
+}
+
+func getLogInfoListPostgreSQL(limits ...int) []*LogInfo {
+	var offset int
+	var limit int64 = (1 << 63) - 1
+	if len(limits) > 0 {
+		offset = limits[0]
+		if len(limits) > 1 {
+			limit = int64(limits[1])
+		}
+	} else {
+		offset = 0
+	} 
+	logged_events := make([]*LogInfo, 0)
+	stmt, err := data_store.Dbh.Prepare("SELECT id, actor_type, actor_info, " + logTimeColumn + ", action, object_type, object_name, extended_info FROM log_infos ORDER BY id DESC OFFSET $1 LIMIT $2")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer stmt.Close()
+	rows, qerr := stmt.Query(offset, limit)
+	if qerr != nil {
+		if qerr == sql.ErrNoRows {
+			return logged_events
+		}
+		log.Fatal(qerr)
+	}
+	for rows.Next() {
+		le := new(LogInfo)
+		err = le.fillLogEventFromSQL(rows)
+		if err != nil {
+			log.Fatal(err)
+		}
+		logged_events = append(logged_events, le)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		log.Fatal(err)
+	}
+	return logged_events
+}
@@ -0,0 +1,112 @@
+/*
+ * Copyright (c) 2013-2014, Jeremy Bingham (<jbingham@gmail.com>)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package log_info
+
+import (
+	"github.com/ctdk/goiardi/config"
+	"github.com/ctdk/goiardi/data_store"
+	"github.com/ctdk/goiardi/util"
+	"time"
+)
+
+func (le *LogInfo)writeEventSQL() error {
+	if config.Config.UseMySQL {
+		return data_store.RetryMySQL(le.writeEventMySQL)
+	}
+	return le.writeEventPostgreSQL()
+}
+
+func getLogEventSQL(id int) (*LogInfo, error) {
+	if config.Config.UseMySQL {
+		return getLogEventMySQL(id)
+	}
+	return getLogEventPostgreSQL(id)
+}
+
+func getLogEventsSQL(ids []int) (map[int]*LogInfo, error) {
+	if config.Config.UseMySQL {
+		return getLogEventsMySQL(ids)
+	}
+	return getLogEventsPostgreSQL(ids)
+}
+
+func histogramSQL(secs int64, filters map[string]string) ([]*util.TimeBucket, error) {
+	if config.Config.UseMySQL {
+		return histogramMySQL(secs, filters)
+	}
+	return histogramPostgreSQL(secs, filters)
+}
+
+func (le *LogInfo)deleteSQL() error {
+	if config.Config.UseMySQL {
+		return le.deleteMySQL()
+	}
+	return le.deletePostgreSQL()
+}
+
+func purgeSQL(id int) (int64, error) {
+	if config.Config.UseMySQL {
+		return purgeMySQL(id)
+	}
+	return purgePostgreSQL(id)
+}
+
+func getLogInfoListSQL(limits ...int) []*LogInfo {
+	if config.Config.UseMySQL {
+		return getLogInfoListMySQL(limits...)
+	}
+	return getLogInfoListPostgreSQL(limits...)
+}
+
+func (le *LogInfo)fillLogEventFromSQL(row data_store.ResRow) error {
+	var tb []byte
+	err := row.Scan(&le.Id, &le.ActorType, &le.ActorInfo, &tb, &le.Action, &le.ObjectType, &le.ObjectName, &le.ExtendedInfo)
+	if err != nil {
+		return err
+	}
+	le.Time, err = time.Parse(data_store.MySQLTimeFormat, string(tb))
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+/* Whether a purge that deleted purged of the table's total rows deleted
+ * enough of them, at least pct percent, to be worth optimizing the table
+ * afterwards. A pct of 0 never optimizes. */
+func shouldOptimize(purged int64, total int64, pct int) bool {
+	return pct > 0 && purged > 0 && purged * 100 >= total * int64(pct)
+}
+
+/* Only one OPTIMIZE TABLE or VACUUM FULL runs at a time. */
+var optimizeSem = make(chan bool, 1)
+
+/* Run optimize in the background, so the purge, which may be a request to
+ * /events, doesn't wait for the table to be rebuilt. If an optimize is
+ * already running, another isn't started and false is returned. */
+func optimizeInBackground(optimize func()) bool {
+	select {
+		case optimizeSem <- true:
+		default:
+			return false
+	}
+	go func() {
+		defer func() { <-optimizeSem }()
+		optimize()
+	}()
+	return true
+}
@@ -0,0 +1,150 @@
+/*
+ * Copyright (c) 2013-2014, Jeremy Bingham (<jbingham@gmail.com>)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package log_info
+
+import (
+	"github.com/ctdk/goiardi/config"
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+	"git.tideland.biz/goas/logger"
+)
+
+var webhookClient = &http.Client{ Timeout: 10 * time.Second }
+
+/* Deliveries are handed to a fixed number of workers, set with
+ * webhook-workers, through a queue. If the queue fills up because the
+ * webhooks can't keep up, new deliveries are dropped rather than piling up
+ * goroutines or holding up requests. */
+const webhookQueueSize = 1000
+
+type webhookDelivery struct {
+	wh config.WebhookConf
+	body []byte
+}
+
+var webhookQueue chan *webhookDelivery
+var webhookWorkersOnce sync.Once
+
+func startWebhookWorkers() {
+	workers := config.Config.WebhookWorkers
+	if workers < 1 {
+		workers = 1
+	}
+	webhookQueue = make(chan *webhookDelivery, webhookQueueSize)
+	for i := 0; i < workers; i++ {
+		go func() {
+			for d := range webhookQueue {
+				deliverWebhook(d.wh, d.body)
+			}
+		}()
+	}
+}
+
+/* Send the event to any configured webhooks that want events for this type of
+ * object. Delivery happens in the background so the request that caused the
+ * event isn't held up; failures are retried with a growing delay between
+ * attempts, and logged if they never get through. */
+func sendWebhooks(le *LogInfo, url_type string) {
+	if len(config.Config.Webhooks) == 0 {
+		return
+	}
+	body, err := json.Marshal(le)
+	if err != nil {
+		logger.Errorf("Error encoding event for webhooks: %s", err.Error())
+		return
+	}
+	for _, wh := range config.Config.Webhooks {
+		if !webhookWants(wh, url_type) {
+			continue
+		}
+		queueWebhook(&webhookDelivery{ wh: wh, body: body })
+	}
+}
+
+func queueWebhook(d *webhookDelivery) bool {
+	webhookWorkersOnce.Do(startWebhookWorkers)
+	select {
+		case webhookQueue <- d:
+			return true
+		default:
+			logger.Errorf("Too many webhook deliveries waiting, dropping event for %s", d.wh.URL)
+			return false
+	}
+}
+
+func webhookWants(wh config.WebhookConf, url_type string) bool {
+	if len(wh.Types) == 0 {
+		return true
+	}
+	for _, t := range wh.Types {
+		if t == url_type {
+			return true
+		}
+	}
+	return false
+}
+
+func deliverWebhook(wh config.WebhookConf, body []byte) {
+	delay := time.Second
+	var err error
+	for attempt := 0; attempt <= config.Config.WebhookRetries; attempt++ {
+		if attempt > 0 {
+			time.Sleep(delay)
+			delay *= 2
+		}
+		if err = postWebhook(wh, body); err == nil {
+			return
+		}
+		logger.Debugf("Webhook delivery to %s failed (attempt %d): %s", wh.URL, attempt + 1, err.Error())
+	}
+	logger.Errorf("Giving up delivering webhook to %s: %s", wh.URL, err.Error())
+}
+
+func postWebhook(wh config.WebhookConf, body []byte) error {
+	req, err := http.NewRequest("POST", wh.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if wh.Secret != "" {
+		req.Header.Set("X-Goiardi-Signature", "sha256=" + webhookSignature(wh.Secret, body))
+	}
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		err := fmt.Errorf("got status %s", resp.Status)
+		return err
+	}
+	return nil
+}
+
+/* The hex encoded HMAC-SHA256 of the body, keyed with the webhook's secret. */
+func webhookSignature(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
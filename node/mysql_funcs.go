@@ -19,70 +19,12 @@ package node
 import (
 	"github.com/ctdk/goiardi/data_store"
 	"fmt"
-	"log"
 	"database/sql"
 )
 
-func checkForNodeMySQL(dbhandle data_store.Dbhandle, name string) (bool, error) {
-	_, err := data_store.CheckForOne(data_store.Dbh, "nodes", name)
-	if err == nil {
-		return true, nil
-	} else {
-		if err != sql.ErrNoRows {
-			return false, err
-		} else {
-			return false, nil
-		}
-	}
-}
-
-// Fill in a node from a row returned from the SQL server. Useful for the case
-// down the road where an array of objects is needed, but building it with
-// a call to GetList(), then repeated calls to Get() sucks with a real db even
-// if it's marginally acceptable in in-memory mode.
-//
-// NB: This does require the query to look like the one in Get().
-func (n *Node) fillNodeFromSQL(row data_store.ResRow) error {
-	var (
-		rl []byte
-		aa []byte
-		na []byte
-		da []byte
-		oa []byte
-	)
-	err := row.Scan(&n.Name, &n.ChefEnvironment, &rl, &aa, &na, &da, &oa)
-	if err != nil {
-		return err
-	}
-	n.ChefType = "node"
-	n.JsonClass = "Chef::Node"
-	err = data_store.DecodeBlob(rl, &n.RunList)
-	if err != nil {
-		return err
-	}
-	err = data_store.DecodeBlob(aa, &n.Automatic)
-	if err != nil {
-		return err
-	}
-	err = data_store.DecodeBlob(na, &n.Normal)
-	if err != nil {
-		return err
-	}
-	err = data_store.DecodeBlob(da, &n.Default)
-	if err != nil {
-		return err
-	}
-	err = data_store.DecodeBlob(oa, &n.Override)
-	if err != nil {
-		return err
-	}
-	data_store.ChkNilArray(n)
-	return nil
-}
-
 func getMySQL(node_name string) (*Node, error){
 	node := new(Node)
-	stmt, err := data_store.Dbh.Prepare("select n.name, chef_environment, n.run_list, n.automatic_attr, n.normal_attr, n.default_attr, n.override_attr from nodes n where n.name = ?")
+	stmt, err := data_store.Dbh.Prepare("select n.name, chef_environment, n.run_list, n.automatic_attr, n.normal_attr, n.default_attr, n.override_attr, n.created_at from nodes n where n.name = ?")
 	if err != nil {
 		return nil, err
 	}
@@ -141,7 +83,7 @@ func (n *Node) saveMySQL() error {
 			tx.Rollback()
 			return err
 		}
-		_, err = tx.Exec("INSERT INTO nodes (name, chef_environment, run_list, automatic_attr, normal_attr, default_attr, override_attr, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?, ?, NOW(), NOW())", n.Name, n.ChefEnvironment, rlb, aab, nab, dab, oab)
+		_, err = tx.Exec("INSERT INTO nodes (name, chef_environment, run_list, automatic_attr, normal_attr, default_attr, override_attr, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, NOW())", n.Name, n.ChefEnvironment, rlb, aab, nab, dab, oab, n.CreatedAt)
 		if err != nil {
 			tx.Rollback()
 			return err
@@ -168,34 +110,20 @@ func (n *Node) deleteMySQL() error {
 	return err
 }
 
-func getListMySQL() []string {
-	node_list := make([]string, 0)
-	rows, err := data_store.Dbh.Query("SELECT name FROM nodes")
+func getAllNodesMySQL() ([]*Node, error) {
+	rows, err := data_store.Dbh.Query("SELECT n.name, chef_environment, n.run_list, n.automatic_attr, n.normal_attr, n.default_attr, n.override_attr, n.created_at FROM nodes n ORDER BY n.name")
 	if err != nil {
-		if err != sql.ErrNoRows {
-			log.Fatal(err)
-		}
-		rows.Close()
-		return node_list
-	}
-	for rows.Next() {
-		var node_name string
-		err = rows.Scan(&node_name)
-		if err != nil {
-			log.Fatal(err)
+		if err == sql.ErrNoRows {
+			return make([]*Node, 0), nil
 		}
-		node_list = append(node_list, node_name)
-	}
-	rows.Close()
-	if err = rows.Err(); err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
-	return node_list
+	return nodesFromRows(rows)
 }
 
 func getNodesInEnvMySQL(env_name string) ([]*Node, error) {
 	nodes := make([]*Node, 0)
-	stmt, err := data_store.Dbh.Prepare("SELECT n.name, chef_environment, n.run_list, n.automatic_attr, n.normal_attr, n.default_attr, n.override_attr FROM nodes n WHERE n.chef_environment = ?")
+	stmt, err := data_store.Dbh.Prepare("SELECT n.name, chef_environment, n.run_list, n.automatic_attr, n.normal_attr, n.default_attr, n.override_attr, n.created_at FROM nodes n WHERE n.chef_environment = ?")
 	if err != nil {
 		return nil, err
 	}
@@ -27,6 +27,10 @@ import (
 	"fmt"
 	"net/http"
 	"database/sql"
+	"encoding/json"
+	"sort"
+	"time"
+	"git.tideland.biz/goas/logger"
 )
 
 type Node struct {
@@ -39,15 +43,20 @@ type Node struct {
 	Normal map[string]interface{} `json:"normal"`
 	Default map[string]interface{} `json:"default"`
 	Override map[string]interface{} `json:"override"`
+	CreatedAt time.Time `json:"created_at"`
 }
 
+/* The format created_at is indexed in. It sorts properly as a string and has no
+ * colons, so it can be used in range searches without escaping. */
+const createdAtIndexFormat = "20060102T150405Z"
+
 func New(name string) (*Node, util.Gerror) {
 	/* check for an existing node with this name */
 	var found bool
-	if config.Config.UseMySQL {
+	if config.Config.UseSQL {
 		// will need redone if orgs ever get implemented
 		var err error
-		found, err = checkForNodeMySQL(data_store.Dbh, name)
+		found, err = checkForNodeSQL(data_store.Dbh, name)
 		if err != nil {
 			gerr := util.Errorf(err.Error())
 			gerr.SetStatus(http.StatusInternalServerError)
@@ -77,6 +86,7 @@ func New(name string) (*Node, util.Gerror) {
 		Normal: map[string]interface{}{},
 		Default: map[string]interface{}{},
 		Override: map[string]interface{}{},
+		CreatedAt: time.Now().UTC(),
 	}
 	return node, nil
 }
@@ -101,9 +111,9 @@ func NewFromJson(json_node map[string]interface{}) (*Node, util.Gerror){
 func Get(node_name string) (*Node, error) {
 	var node *Node
 	var found bool
-	if config.Config.UseMySQL {
+	if config.Config.UseSQL {
 		var err error
-		node, err = getMySQL(node_name)
+		node, err = getSQL(node_name)
 		if err != nil {
 			if err == sql.ErrNoRows {
 				found = false
@@ -147,7 +157,9 @@ func (n *Node) UpdateFromJson(json_node map[string]interface{}) util.Gerror {
 	/* Look for invalid top level elements. *We* don't have to worry about
 	 * them, but chef-pedant cares (probably because Chef <=10 stores
  	 * json objects directly, dunno about Chef 11). */
-	valid_elements := []string{ "name", "json_class", "chef_type", "chef_environment", "run_list", "override", "normal", "default", "automatic" }
+	/* created_at is allowed so nodes can be sent back as they were
+	 * received, but it's never changed. */
+	valid_elements := []string{ "name", "json_class", "chef_type", "chef_environment", "run_list", "override", "normal", "default", "automatic", "created_at" }
 	ValidElem:
 	for k, _ := range json_node {
 		for _, i := range valid_elements {
@@ -228,8 +240,13 @@ func (n *Node) UpdateFromJson(json_node map[string]interface{}) util.Gerror {
 }
 
 func (n *Node) Save() error {
-	if config.Config.UseMySQL {
-		if err := n.saveMySQL(); err != nil {
+	/* Nodes from before creation times were kept get one the first time
+	 * they're saved. */
+	if n.CreatedAt.IsZero() {
+		n.CreatedAt = time.Now().UTC()
+	}
+	if config.Config.UseSQL {
+		if err := n.saveSQL(); err != nil {
 			return err
 		}
 	} else {
@@ -242,8 +259,8 @@ func (n *Node) Save() error {
 }
 
 func (n *Node) Delete() error {
-	if config.Config.UseMySQL {
-		if err := n.deleteMySQL(); err != nil {
+	if config.Config.UseSQL {
+		if err := n.deleteSQL(); err != nil {
 			return err
 		}
 	} else {
@@ -257,8 +274,8 @@ func (n *Node) Delete() error {
 // Get a list of the nodes on this server.
 func GetList() []string {
 	var node_list []string
-	if config.Config.UseMySQL {
-		node_list = getListMySQL()
+	if config.Config.UseSQL {
+		node_list = getListSQL()
 	} else {
 		ds := data_store.New()
 		node_list = ds.GetList("node")
@@ -266,9 +283,41 @@ func GetList() []string {
 	return node_list
 }
 
+// Returns part of the list of nodes, sorted by name, starting at offset and
+// holding at most limit of them, along with the total number of nodes. A
+// negative limit returns everything after the offset.
+func GetListPage(offset int, limit int) ([]string, int) {
+	if config.Config.UseSQL {
+		return getListPageSQL(offset, limit)
+	}
+	node_list := GetList()
+	return data_store.PageList(node_list, offset, limit), len(node_list)
+}
+
+// Returns every node on the server, sorted by name. In SQL mode they're all
+// fetched with one query, rather than one for each node.
+func AllNodes() ([]*Node, error) {
+	if config.Config.UseSQL {
+		return getAllNodesSQL()
+	}
+	node_list := GetList()
+	sort.Strings(node_list)
+	nodes := make([]*Node, 0, len(node_list))
+	for _, name := range node_list {
+		/* Skip any node that's gone missing since the list was
+		 * made. */
+		n, _ := Get(name)
+		if n == nil {
+			continue
+		}
+		nodes = append(nodes, n)
+	}
+	return nodes, nil
+}
+
 func GetFromEnv(env_name string) ([]*Node, error) {
-	if config.Config.UseMySQL {
-		return getNodesInEnvMySQL(env_name)
+	if config.Config.UseSQL {
+		return getNodesInEnvSQL(env_name)
 	}
 	env_nodes := make([]*Node, 0)
 	node_list := GetList()
@@ -284,6 +333,68 @@ func GetFromEnv(env_name string) ([]*Node, error) {
 	return env_nodes, nil
 }
 
+// Merges the node's default, normal, override, and automatic attributes, in
+// that order of precedence, the way chef-client sees them.
+func (n *Node) MergedAttributes() map[string]interface{} {
+	merged := make(map[string]interface{})
+	for _, l := range []map[string]interface{}{ n.Default, n.Normal, n.Override, n.Automatic } {
+		merged = util.MergeAttributes(merged, l)
+	}
+	return merged
+}
+
+// Returns when the node last checked in, going by the ohai_time automatic
+// attribute chef-client sets on every run. The second return value is false if
+// the node has never reported an ohai_time.
+func (n *Node) LastCheckIn() (time.Time, bool) {
+	var secs float64
+	switch t := n.Automatic["ohai_time"].(type) {
+		case float64:
+			secs = t
+		case int:
+			secs = float64(t)
+		case json.Number:
+			f, err := t.Float64()
+			if err != nil {
+				return time.Time{}, false
+			}
+			secs = f
+		default:
+			return time.Time{}, false
+	}
+	return time.Unix(int64(secs), 0).UTC(), true
+}
+
+// Returns the nodes, sorted by name, that haven't checked in for at least the
+// given amount of time. Nodes that have never checked in at all aren't
+// included, since there's no telling how old they are.
+func StaleNodes(age time.Duration) []*Node {
+	cutoff := time.Now().Add(-age)
+	stale := make([]*Node, 0)
+	all_nodes, err := AllNodes()
+	if err != nil {
+		logger.Errorf("Error getting nodes to check for stale ones: %s", err.Error())
+		return stale
+	}
+	for _, n := range all_nodes {
+		if n.staleSince(cutoff) {
+			stale = append(stale, n)
+		}
+	}
+	return stale
+}
+
+// Whether the node hasn't checked in for at least the given amount of time.
+// Like StaleNodes, a node that has never checked in isn't stale.
+func (n *Node) IsStale(age time.Duration) bool {
+	return n.staleSince(time.Now().Add(-age))
+}
+
+func (n *Node) staleSince(cutoff time.Time) bool {
+	last, ok := n.LastCheckIn()
+	return ok && last.Before(cutoff)
+}
+
 func (n *Node) GetName() string {
 	return n.Name
 }
@@ -304,6 +415,7 @@ func (n *Node) Index() string {
 
 func (n *Node) Flatten() []string {
 	flatten := util.FlattenObj(n)
+	flatten["created_at"] = n.CreatedAt.UTC().Format(createdAtIndexFormat)
 	indexified := util.Indexify(flatten)
 	return indexified
 }
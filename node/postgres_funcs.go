@@ -0,0 +1,136 @@
+/*
+ * Copyright (c) 2013-2014, Jeremy Bingham (<jbingham@gmail.com>)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package node
+
+import (
+	"github.com/ctdk/goiardi/data_store"
+	"fmt"
+	"database/sql"
+)
+
+/* PostgreSQL hands timestamps back as time.Time values, but fillNodeFromSQL
+ * expects the MySQL format, so the creation time is formatted to match. */
+const nodeQueryColumns = "n.name, chef_environment, n.run_list, n.automatic_attr, n.normal_attr, n.default_attr, n.override_attr, to_char(n.created_at AT TIME ZONE 'UTC', 'YYYY-MM-DD HH24:MI:SS')"
+
+func getPostgreSQL(node_name string) (*Node, error){
+	node := new(Node)
+	stmt, err := data_store.Dbh.Prepare("SELECT " + nodeQueryColumns + " FROM nodes n WHERE n.name = $1")
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+	row := stmt.QueryRow(node_name)
+	err = node.fillNodeFromSQL(row)
+
+	if err != nil {
+		return nil, err
+	}
+	return node, nil
+}
+
+func (n *Node) savePostgreSQL() error {
+	rlb, rlerr := data_store.EncodeBlob(&n.RunList)
+	if rlerr != nil {
+		return rlerr
+	}
+	aab, aaerr := data_store.EncodeBlob(&n.Automatic)
+	if aaerr != nil {
+		return aaerr
+	}
+	nab, naerr := data_store.EncodeBlob(&n.Normal)
+	if naerr != nil {
+		return naerr
+	}
+	dab, daerr := data_store.EncodeBlob(&n.Default)
+	if daerr != nil {
+		return daerr
+	}
+	oab, oaerr := data_store.EncodeBlob(&n.Override)
+	if oaerr != nil {
+		return oaerr
+	}
+
+	tx, err := data_store.Dbh.Begin()
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("INSERT INTO nodes (name, chef_environment, run_list, automatic_attr, normal_attr, default_attr, override_attr, created_at, updated_at) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, NOW()) ON CONFLICT (name) DO UPDATE SET chef_environment = EXCLUDED.chef_environment, run_list = EXCLUDED.run_list, automatic_attr = EXCLUDED.automatic_attr, normal_attr = EXCLUDED.normal_attr, default_attr = EXCLUDED.default_attr, override_attr = EXCLUDED.override_attr, updated_at = NOW()", n.Name, n.ChefEnvironment, rlb, aab, nab, dab, oab, n.CreatedAt)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	tx.Commit()
+	return nil
+}
+
+func (n *Node) deletePostgreSQL() error {
+	tx, err := data_store.Dbh.Begin()
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("DELETE FROM nodes WHERE name = $1", n.Name)
+	if err != nil {
+		terr := tx.Rollback()
+		if terr != nil {
+			err = fmt.Errorf("deleting node %s had an error '%s', and then rolling back the transaction gave another error '%s'", n.Name, err.Error(), terr.Error())
+		}
+		return err
+	}
+	tx.Commit()
+	return err
+}
+
+func getAllNodesPostgreSQL() ([]*Node, error) {
+	rows, err := data_store.Dbh.Query("SELECT " + nodeQueryColumns + " FROM nodes n ORDER BY n.name")
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return make([]*Node, 0), nil
+		}
+		return nil, err
+	}
+	return nodesFromRows(rows)
+}
+
+func getNodesInEnvPostgreSQL(env_name string) ([]*Node, error) {
+	nodes := make([]*Node, 0)
+	stmt, err := data_store.Dbh.Prepare("SELECT " + nodeQueryColumns + " FROM nodes n WHERE n.chef_environment = $1")
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+	rows, qerr := stmt.Query(env_name)
+	if qerr != nil {
+		if qerr == sql.ErrNoRows {
+			return nodes, nil
+		}
+		return nil, qerr
+	}
+	for rows.Next() {
+		n := new(Node)
+		err = n.fillNodeFromSQL(rows)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		nodes = append(nodes, n)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return nodes, nil
+}
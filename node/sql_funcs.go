@@ -0,0 +1,173 @@
+/*
+ * Copyright (c) 2013-2014, Jeremy Bingham (<jbingham@gmail.com>)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package node
+
+import (
+	"github.com/ctdk/goiardi/config"
+	"github.com/ctdk/goiardi/data_store"
+	"log"
+	"database/sql"
+	"time"
+)
+
+func checkForNodeSQL(dbhandle data_store.Dbhandle, name string) (bool, error) {
+	_, err := data_store.CheckForOne(data_store.Dbh, "nodes", name)
+	if err == nil {
+		return true, nil
+	} else {
+		if err != sql.ErrNoRows {
+			return false, err
+		} else {
+			return false, nil
+		}
+	}
+}
+
+// Fill in a node from a row returned from the SQL server. Useful for the case
+// down the road where an array of objects is needed, but building it with
+// a call to GetList(), then repeated calls to Get() sucks with a real db even
+// if it's marginally acceptable in in-memory mode.
+//
+// NB: This does require the query to look like the one in Get().
+func (n *Node) fillNodeFromSQL(row data_store.ResRow) error {
+	var (
+		rl []byte
+		aa []byte
+		na []byte
+		da []byte
+		oa []byte
+		ca []byte
+	)
+	err := row.Scan(&n.Name, &n.ChefEnvironment, &rl, &aa, &na, &da, &oa, &ca)
+	if err != nil {
+		return err
+	}
+	n.CreatedAt, err = time.Parse(data_store.MySQLTimeFormat, string(ca))
+	if err != nil {
+		return err
+	}
+	n.ChefType = "node"
+	n.JsonClass = "Chef::Node"
+	err = data_store.DecodeBlob(rl, &n.RunList)
+	if err != nil {
+		return err
+	}
+	err = data_store.DecodeBlob(aa, &n.Automatic)
+	if err != nil {
+		return err
+	}
+	err = data_store.DecodeBlob(na, &n.Normal)
+	if err != nil {
+		return err
+	}
+	err = data_store.DecodeBlob(da, &n.Default)
+	if err != nil {
+		return err
+	}
+	err = data_store.DecodeBlob(oa, &n.Override)
+	if err != nil {
+		return err
+	}
+	data_store.ChkNilArray(n)
+	return nil
+}
+
+func getListSQL() []string {
+	node_list := make([]string, 0)
+	rows, err := data_store.Dbh.Query("SELECT name FROM nodes")
+	if err != nil {
+		if err != sql.ErrNoRows {
+			log.Fatal(err)
+		}
+		rows.Close()
+		return node_list
+	}
+	for rows.Next() {
+		var node_name string
+		err = rows.Scan(&node_name)
+		if err != nil {
+			log.Fatal(err)
+		}
+		node_list = append(node_list, node_name)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		log.Fatal(err)
+	}
+	return node_list
+}
+
+func getListPageSQL(offset int, limit int) ([]string, int) {
+	node_list, total, err := data_store.GetNameListPage(data_store.Dbh, "nodes", offset, limit)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return node_list, total
+}
+
+func getSQL(node_name string) (*Node, error) {
+	if config.Config.UseMySQL {
+		return getMySQL(node_name)
+	}
+	return getPostgreSQL(node_name)
+}
+
+func (n *Node) saveSQL() error {
+	if config.Config.UseMySQL {
+		return n.saveMySQL()
+	}
+	return n.savePostgreSQL()
+}
+
+func (n *Node) deleteSQL() error {
+	if config.Config.UseMySQL {
+		return n.deleteMySQL()
+	}
+	return n.deletePostgreSQL()
+}
+
+func getAllNodesSQL() ([]*Node, error) {
+	if config.Config.UseMySQL {
+		return getAllNodesMySQL()
+	}
+	return getAllNodesPostgreSQL()
+}
+
+/* Fill in nodes from each of the rows, which are closed afterwards. */
+func nodesFromRows(rows *sql.Rows) ([]*Node, error) {
+	nodes := make([]*Node, 0)
+	for rows.Next() {
+		n := new(Node)
+		if err := n.fillNodeFromSQL(rows); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		nodes = append(nodes, n)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return nodes, nil
+}
+
+func getNodesInEnvSQL(env_name string) ([]*Node, error) {
+	if config.Config.UseMySQL {
+		return getNodesInEnvMySQL(env_name)
+	}
+	return getNodesInEnvPostgreSQL(env_name)
+}
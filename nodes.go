@@ -21,11 +21,18 @@ package main
 import (
 	"net/http"
 	"encoding/json"
+	"strconv"
 	"github.com/ctdk/goiardi/node"
+	"github.com/ctdk/goiardi/report"
 	"github.com/ctdk/goiardi/util"
 	"github.com/ctdk/goiardi/actor"
 	"github.com/ctdk/goiardi/client"
 	"github.com/ctdk/goiardi/log_info"
+	"github.com/ctdk/goiardi/role"
+	"github.com/ctdk/goiardi/environment"
+	"github.com/ctdk/goiardi/cookbook"
+	"github.com/ctdk/goiardi/config"
+	"git.tideland.biz/goas/logger"
 )
 
 func node_handler(w http.ResponseWriter, r *http.Request){
@@ -35,7 +42,31 @@ func node_handler(w http.ResponseWriter, r *http.Request){
 
 	opUser, oerr := actor.GetReqUser(r.Header.Get("X-OPS-USERID"))
 	if oerr != nil {
-		JsonErrorReport(w, r, oerr.Error(), oerr.Status())
+		GerrorReport(w, r, oerr)
+		return
+	}
+
+	path_array := SplitPath(r.URL.Path)
+	if len(path_array) == 2 && path_array[1] == "_stale" {
+		if !adminIPAllowed(w, r) {
+			return
+		}
+		node_stale(w, r, opUser)
+		return
+	} else if len(path_array) == 3 && path_array[2] == "_expanded_run_list" {
+		node_expanded_run_list(w, r, path_array[1], opUser)
+		return
+	} else if len(path_array) == 3 && path_array[2] == "_effective" {
+		node_effective(w, r, path_array[1], opUser)
+		return
+	} else if len(path_array) == 3 && path_array[2] == "_resolved_cookbooks" {
+		node_resolved_cookbooks(w, r, path_array[1], opUser)
+		return
+	} else if len(path_array) == 3 && path_array[2] == "_runs" {
+		node_runs(w, r, path_array[1], opUser)
+		return
+	} else if len(path_array) == 3 && path_array[2] == "_preview" {
+		node_preview(w, r, path_array[1], opUser)
 		return
 	}
 
@@ -51,6 +82,16 @@ func node_handler(w http.ResponseWriter, r *http.Request){
 				JsonErrorReport(w, r, err.Error(), http.StatusNotFound)
 				return
 			}
+			/* Just the merged attributes, flattened for
+			 * systems that can't cope with nesting. */
+			if flatten, _ := strconv.ParseBool(r.FormValue("flatten")); flatten && r.Method == "GET" {
+				flat_attrs := util.FlattenAttributes(chef_node.MergedAttributes())
+				enc := json.NewEncoder(w)
+				if err = enc.Encode(&flat_attrs); err != nil {
+					JsonErrorReport(w, r, err.Error(), http.StatusInternalServerError)
+				}
+				return
+			}
 			enc := json.NewEncoder(w)
 			if err = enc.Encode(&chef_node); err != nil {
 				JsonErrorReport(w, r, err.Error(), http.StatusInternalServerError)
@@ -78,9 +119,19 @@ func node_handler(w http.ResponseWriter, r *http.Request){
 				return
 			}
 			chef_node, err := node.Get(node_name)
+			created := false
 			if err != nil {
-				JsonErrorReport(w, r, err.Error(), http.StatusNotFound)
-				return
+				if !config.Config.PutCreates {
+					JsonErrorReport(w, r, err.Error(), http.StatusNotFound)
+					return
+				}
+				var nerr util.Gerror
+				chef_node, nerr = node.New(node_name)
+				if nerr != nil {
+					GerrorReport(w, r, nerr)
+					return
+				}
+				created = true
 			}
 			/* If node_name and node_data["name"] don't match, we
 			 * need to make a new node. Make sure that node doesn't
@@ -102,19 +153,26 @@ func node_handler(w http.ResponseWriter, r *http.Request){
 				}
 				nerr := chef_node.UpdateFromJson(node_data)
 				if nerr != nil {
-					JsonErrorReport(w, r, nerr.Error(), nerr.Status())
+					GerrorReport(w, r, nerr)
 					return
 				}
 			}
+			if eerr := autoCreateNodeEnv(opUser, chef_node.ChefEnvironment); eerr != nil {
+				GerrorReport(w, r, eerr)
+				return
+			}
 			err = chef_node.Save()
 			if err != nil {
 				JsonErrorReport(w, r, err.Error(), http.StatusInternalServerError)
 				return
 			}
-			if lerr := log_info.LogEvent(opUser, chef_node, "modify"); lerr != nil {
+			if lerr := log_info.LogEvent(opUser, chef_node, putAction(created)); lerr != nil {
 				JsonErrorReport(w, r, lerr.Error(), http.StatusInternalServerError)
 				return
 			}
+			if created {
+				w.WriteHeader(http.StatusCreated)
+			}
 			enc := json.NewEncoder(w)
 			if err = enc.Encode(&chef_node); err != nil {
 				JsonErrorReport(w, r, err.Error(), http.StatusInternalServerError)
@@ -123,3 +181,326 @@ func node_handler(w http.ResponseWriter, r *http.Request){
 			JsonErrorReport(w, r, "Unrecognized method!", http.StatusMethodNotAllowed)
 	}
 }
+
+/* Expand a node's run list for an environment, so the recipes that will
+ * actually be run on it can be seen all at once. The environment defaults to
+ * the node's environment. */
+func node_expanded_run_list(w http.ResponseWriter, r *http.Request, node_name string, opUser actor.Actor) {
+	if r.Method != "GET" {
+		JsonErrorReport(w, r, "Unrecognized method!", http.StatusMethodNotAllowed)
+		return
+	}
+	if opUser.IsValidator() {
+		JsonErrorReport(w, r, "You are not allowed to perform this action", http.StatusForbidden)
+		return
+	}
+	chef_node, err := node.Get(node_name)
+	if err != nil {
+		JsonErrorReport(w, r, err.Error(), http.StatusNotFound)
+		return
+	}
+	env_name := r.FormValue("env")
+	if env_name == "" {
+		env_name = chef_node.ChefEnvironment
+	}
+	if _, eerr := environment.Get(env_name); eerr != nil {
+		GerrorReport(w, r, eerr)
+		return
+	}
+	recipes, roles, err := role.ExpandRunList(chef_node.RunList, env_name)
+	if err != nil {
+		JsonErrorReport(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+	expanded := map[string]interface{}{
+		"environment": env_name,
+		"roles": roles,
+		"recipes": recipes,
+	}
+	enc := json.NewEncoder(w)
+	if err = enc.Encode(&expanded); err != nil {
+		JsonErrorReport(w, r, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+/* Resolve the cookbook versions the node's run list would get in its
+ * environment, or the one given with the env query parameter, and send back
+ * just the names and versions. Comparing these across nodes shows where
+ * they've drifted apart. If the run list can't be resolved, the 412 carries the
+ * run list items that failed and why, as worked out by
+ * DependsCookbooksEach. */
+func node_resolved_cookbooks(w http.ResponseWriter, r *http.Request, node_name string, opUser actor.Actor) {
+	if r.Method != "GET" {
+		JsonErrorReport(w, r, "Unrecognized method!", http.StatusMethodNotAllowed)
+		return
+	}
+	if opUser.IsValidator() {
+		JsonErrorReport(w, r, "You are not allowed to perform this action", http.StatusForbidden)
+		return
+	}
+	chef_node, err := node.Get(node_name)
+	if err != nil {
+		JsonErrorReport(w, r, err.Error(), http.StatusNotFound)
+		return
+	}
+	env_name := r.FormValue("env")
+	if env_name == "" {
+		env_name = chef_node.ChefEnvironment
+	}
+	env, eerr := environment.Get(env_name)
+	if eerr != nil {
+		GerrorReport(w, r, eerr)
+		return
+	}
+	recipes, _, err := role.ExpandRunList(chef_node.RunList, env_name)
+	if err != nil {
+		JsonErrorReport(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+	constraints, cerr := env.EffectiveCookbookVersions()
+	if cerr != nil {
+		GerrorReport(w, r, cerr)
+		return
+	}
+	deps, derr := cookbook.DependsCookbooks(recipes, constraints)
+	if derr != nil {
+		if derr.Status() != http.StatusPreconditionFailed {
+			GerrorReport(w, r, derr)
+			return
+		}
+		/* The whole run list has already failed, so only
+		 * resolve the items one at a time. */
+		_, failures := cookbook.DependsCookbooksEach(recipes, constraints)
+		logger.Infof(derr.Error())
+		unsatisfiable := map[string]interface{}{
+			"error": []string{ derr.Error() },
+			"failures": failures,
+		}
+		w.WriteHeader(http.StatusPreconditionFailed)
+		enc := json.NewEncoder(w)
+		if err = enc.Encode(&unsatisfiable); err != nil {
+			logger.Errorf(err.Error())
+		}
+		return
+	}
+	if hidden := hiddenCookbook(opUser, deps); hidden != "" {
+		cookbookForbidden(w, r, hidden)
+		return
+	}
+	resolved := make(map[string]interface{}, len(deps))
+	for cb_name, cbv := range deps {
+		resolved[cb_name] = cbv.(map[string]interface{})["version"]
+	}
+	enc := json.NewEncoder(w)
+	if err = enc.Encode(&resolved); err != nil {
+		JsonErrorReport(w, r, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+/* The order attributes are merged in for _effective, from lowest to highest
+ * precedence. As in chef, role defaults beat environment defaults, but
+ * environment overrides beat role overrides. Within each role level, roles
+ * later in the expanded run list win. Cookbook attribute files aren't
+ * included, since goiardi can't evaluate them. */
+var effectivePrecedence = []string{ "node default", "environment default", "role default", "node normal", "node override", "role override", "environment override", "node automatic" }
+
+/* Work out the attributes and recipes a node would converge with, merging its
+ * attributes with those of its roles and environment the way chef-client does
+ * at the start of a run. Like _expanded_run_list, the environment defaults to
+ * the node's environment, but can be given with the env query parameter. */
+func node_effective(w http.ResponseWriter, r *http.Request, node_name string, opUser actor.Actor) {
+	if r.Method != "GET" {
+		JsonErrorReport(w, r, "Unrecognized method!", http.StatusMethodNotAllowed)
+		return
+	}
+	if opUser.IsValidator() {
+		JsonErrorReport(w, r, "You are not allowed to perform this action", http.StatusForbidden)
+		return
+	}
+	chef_node, err := node.Get(node_name)
+	if err != nil {
+		JsonErrorReport(w, r, err.Error(), http.StatusNotFound)
+		return
+	}
+	env_name := r.FormValue("env")
+	if env_name == "" {
+		env_name = chef_node.ChefEnvironment
+	}
+	env, eerr := environment.Get(env_name)
+	if eerr != nil {
+		GerrorReport(w, r, eerr)
+		return
+	}
+	effective, gerr := nodeEffective(chef_node, env)
+	if gerr != nil {
+		GerrorReport(w, r, gerr)
+		return
+	}
+	enc := json.NewEncoder(w)
+	if err = enc.Encode(&effective); err != nil {
+		JsonErrorReport(w, r, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+/* Does the actual merging for _effective and _preview. */
+func nodeEffective(chef_node *node.Node, env *environment.ChefEnvironment) (map[string]interface{}, util.Gerror) {
+	recipes, roles, err := role.ExpandRunList(chef_node.RunList, env.Name)
+	if err != nil {
+		return nil, util.Errorf(err.Error())
+	}
+	role_default := make(map[string]interface{})
+	role_override := make(map[string]interface{})
+	for _, rn := range roles {
+		rl, rerr := role.Get(rn)
+		if rerr != nil {
+			return nil, util.Errorf(rerr.Error())
+		}
+		role_default = util.MergeAttributes(role_default, rl.Default)
+		role_override = util.MergeAttributes(role_override, rl.Override)
+	}
+
+	/* Same order as effectivePrecedence */
+	levels := []map[string]interface{}{ chef_node.Default, env.Default, role_default, chef_node.Normal, chef_node.Override, role_override, env.Override, chef_node.Automatic }
+	attributes := make(map[string]interface{})
+	for _, l := range levels {
+		attributes = util.MergeAttributes(attributes, l)
+	}
+
+	effective := map[string]interface{}{
+		"name": chef_node.Name,
+		"environment": env.Name,
+		"run_list": chef_node.RunList,
+		"roles": roles,
+		"recipes": recipes,
+		"attributes": attributes,
+		"precedence": effectivePrecedence,
+	}
+	return effective, nil
+}
+
+/* Show what saving a node with the given JSON would do without saving it: the
+ * node as a GET would return it afterwards, and the merged attributes and
+ * expanded run list _effective would give for it. Nothing gets written, not
+ * even an automatically created environment; if the node's new environment
+ * doesn't exist yet but would be created, it's merged as an empty one. */
+func node_preview(w http.ResponseWriter, r *http.Request, node_name string, opUser actor.Actor) {
+	if r.Method != "POST" {
+		JsonErrorReport(w, r, "Unrecognized method!", http.StatusMethodNotAllowed)
+		return
+	}
+	if !opUser.IsAdmin() && !(opUser.IsClient() && opUser.(*client.Client).NodeName == node_name) {
+		JsonErrorReport(w, r, "You are not allowed to perform this action", http.StatusForbidden)
+		return
+	}
+	node_data, jerr := ParseObjJson(r.Body)
+	if jerr != nil {
+		JsonErrorReport(w, r, jerr.Error(), http.StatusBadRequest)
+		return
+	}
+	chef_node, err := node.Get(node_name)
+	if err != nil {
+		JsonErrorReport(w, r, err.Error(), http.StatusNotFound)
+		return
+	}
+	if _, found := node_data["name"]; !found {
+		node_data["name"] = node_name
+	}
+	/* The in-memory data store hands back the stored node itself, so work
+	 * on a copy. UpdateFromJson replaces the attribute maps and run list
+	 * rather than changing them, so a shallow copy is enough. */
+	candidate := *chef_node
+	if nerr := candidate.UpdateFromJson(node_data); nerr != nil {
+		GerrorReport(w, r, nerr)
+		return
+	}
+	env, eerr := environment.Get(candidate.ChefEnvironment)
+	if eerr != nil {
+		if !config.Config.AutoCreateEnvironments {
+			GerrorReport(w, r, eerr)
+			return
+		}
+		env, eerr = environment.New(candidate.ChefEnvironment)
+		if eerr != nil {
+			GerrorReport(w, r, eerr)
+			return
+		}
+	}
+	effective, gerr := nodeEffective(&candidate, env)
+	if gerr != nil {
+		GerrorReport(w, r, gerr)
+		return
+	}
+	preview := map[string]interface{}{
+		"node": &candidate,
+		"effective": effective,
+	}
+	enc := json.NewEncoder(w)
+	if err = enc.Encode(&preview); err != nil {
+		JsonErrorReport(w, r, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+/* If configured to, create an empty environment for a node that's been put in
+ * an environment that doesn't exist yet. */
+func autoCreateNodeEnv(opUser actor.Actor, env_name string) util.Gerror {
+	if !config.Config.AutoCreateEnvironments || env_name == "_default" {
+		return nil
+	}
+	if env, _ := environment.Get(env_name); env != nil {
+		return nil
+	}
+	env, err := environment.New(env_name)
+	if err != nil {
+		return err
+	}
+	if err = env.Save(); err != nil {
+		return err
+	}
+	logger.Infof("Automatically created environment %s for a node", env_name)
+	if lerr := log_info.LogEvent(opUser, env, "create"); lerr != nil {
+		err = util.CastErr(lerr)
+		err.SetStatus(http.StatusInternalServerError)
+		return err
+	}
+	return nil
+}
+
+/* A summary of the node's recent chef-client runs from the reporting data,
+ * newest first. Like the reports endpoints, this needs an admin. */
+func node_runs(w http.ResponseWriter, r *http.Request, node_name string, opUser actor.Actor) {
+	if r.Method != "GET" {
+		JsonErrorReport(w, r, "Unrecognized method!", http.StatusMethodNotAllowed)
+		return
+	}
+	if !opUser.IsAdmin() {
+		JsonErrorReport(w, r, "You are not allowed to perform this action", http.StatusForbidden)
+		return
+	}
+	if _, err := node.Get(node_name); err != nil {
+		JsonErrorReport(w, r, err.Error(), http.StatusNotFound)
+		return
+	}
+	offset, limit, _, perr := listPageParams(r)
+	if perr != nil {
+		JsonErrorReport(w, r, perr.Error(), http.StatusBadRequest)
+		return
+	}
+	/* Don't send back every run the node's ever had unless asked. */
+	if limit < 0 {
+		limit = 10
+	}
+	runs, total, err := report.NodeRuns(node_name, offset, limit)
+	if err != nil {
+		JsonErrorReport(w, r, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	setListTotal(w, total)
+	summaries := make([]map[string]interface{}, len(runs))
+	for i, run := range runs {
+		summaries[i] = run.Summary()
+	}
+	enc := json.NewEncoder(w)
+	if err := enc.Encode(&summaries); err != nil {
+		JsonErrorReport(w, r, err.Error(), http.StatusInternalServerError)
+	}
+}
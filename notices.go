@@ -0,0 +1,106 @@
+/*
+ * Copyright (c) 2013-2014, Jeremy Bingham (<jbingham@gmail.com>)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"net/http"
+	"encoding/json"
+	"github.com/ctdk/goiardi/actor"
+	"github.com/ctdk/goiardi/util"
+	"sync"
+)
+
+/* An informational notice, like a planned maintenance message, that gets sent
+ * along with every response in the X-Goiardi-Notice header. It only lives in
+ * memory, and doesn't block anything. */
+type serverNotice struct {
+	m sync.RWMutex
+	msg string
+}
+
+var notice = &serverNotice{}
+
+func (n *serverNotice) get() string {
+	n.m.RLock()
+	defer n.m.RUnlock()
+	return n.msg
+}
+
+func (n *serverNotice) set(msg string) {
+	n.m.Lock()
+	defer n.m.Unlock()
+	n.msg = msg
+}
+
+func notice_handler(w http.ResponseWriter, r *http.Request){
+	w.Header().Set("Content-Type", "application/json")
+	opUser, oerr := actor.GetReqUser(r.Header.Get("X-OPS-USERID"))
+	if oerr != nil {
+		GerrorReport(w, r, oerr)
+		return
+	}
+
+	switch r.Method {
+		case "GET":
+			/* anyone can see the notice, they get it in the
+			 * headers anyway. */
+		case "PUT":
+			if !adminIPAllowed(w, r) {
+				return
+			}
+			if !opUser.IsAdmin() {
+				JsonErrorReport(w, r, "You are not allowed to perform that action.", http.StatusForbidden)
+				return
+			}
+			notice_data, jerr := ParseObjJson(r.Body)
+			if jerr != nil {
+				JsonErrorReport(w, r, jerr.Error(), http.StatusBadRequest)
+				return
+			}
+			msg, verr := util.ValidateAsString(notice_data["notice"])
+			if verr != nil {
+				JsonErrorReport(w, r, "Field 'notice' missing or invalid", http.StatusBadRequest)
+				return
+			}
+			notice.set(msg)
+		case "DELETE":
+			if !adminIPAllowed(w, r) {
+				return
+			}
+			if !opUser.IsAdmin() {
+				JsonErrorReport(w, r, "You are not allowed to perform that action.", http.StatusForbidden)
+				return
+			}
+			notice.set("")
+		default:
+			JsonErrorReport(w, r, "Unrecognized method", http.StatusMethodNotAllowed)
+			return
+	}
+
+	/* The header for this response was set before the notice changed, so
+	 * fix it up here. */
+	notice_response := map[string]string{ "notice": notice.get() }
+	if notice_response["notice"] != "" {
+		w.Header().Set("X-Goiardi-Notice", notice_response["notice"])
+	} else {
+		w.Header().Del("X-Goiardi-Notice")
+	}
+	enc := json.NewEncoder(w)
+	if err := enc.Encode(&notice_response); err != nil {
+		JsonErrorReport(w, r, err.Error(), http.StatusInternalServerError)
+	}
+}
@@ -26,7 +26,12 @@ import (
 
 func principal_handler(w http.ResponseWriter, r *http.Request){
 	w.Header().Set("Content-Type", "application/json")
-	principal_name := r.URL.Path[12:]
+	path_array := SplitPath(r.URL.Path)
+	if len(path_array) != 2 {
+		JsonErrorReport(w, r, "Bad request.", http.StatusBadRequest)
+		return
+	}
+	principal_name := path_array[1]
 	switch r.Method {
 		case "GET":
 			chef_actor, err := actor.GetReqUser(principal_name)
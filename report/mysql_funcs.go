@@ -19,9 +19,7 @@ package report
 import (
 	"github.com/ctdk/goiardi/data_store"
 	"database/sql"
-	"github.com/go-sql-driver/mysql"
 	"fmt"
-	"log"
 	"time"
 )
 
@@ -47,29 +45,6 @@ func checkForReportMySQL(dbhandle data_store.Dbhandle, runId string) (bool, erro
 	}
 }
 
-func (r *Report)fillReportFromMySQL(row data_store.ResRow) error{
-	var res, dat []byte
-	var st, et mysql.NullTime
-	err := row.Scan(&r.RunId, &st, &et, &r.TotalResCount, &r.Status, &r.RunList, &res, &dat, &r.NodeName)
-	if err != nil {
-		return err
-	}
-	if err = data_store.DecodeBlob(res, &r.Resources); err != nil {
-		return err
-	}
-	if err = data_store.DecodeBlob(dat, &r.Data); err != nil {
-		return err
-	}
-	if st.Valid {
-		r.StartTime = st.Time
-	} 
-	if et.Valid {
-		r.EndTime = et.Time
-	}
-
-	return nil
-}
-
 func getReportMySQL(runId string) (*Report, error) {
 	r := new(Report)
 	stmt, err := data_store.Dbh.Prepare("SELECT run_id, start_time, end_time, total_res_count, status, run_list, resources, data, node_name FROM reports WHERE run_id = ?")
@@ -78,7 +53,7 @@ func getReportMySQL(runId string) (*Report, error) {
 	}
 	defer stmt.Close()
 	row := stmt.QueryRow(runId)
-	err = r.fillReportFromMySQL(row)
+	err = r.fillReportFromSQL(row)
 	if err != nil {
 		return nil, err
 	}
@@ -130,31 +105,6 @@ func (r *Report)deleteMySQL() error {
 	return nil
 }
 
-func getListMySQL() []string {
-	reportList := make([]string, 0)
-	rows, err := data_store.Dbh.Query("SELECT run_id FROM reports")
-	if err != nil {
-		if err != sql.ErrNoRows {
-			log.Fatal(err)
-		}
-		rows.Close()
-		return reportList
-	}
-	for rows.Next() {
-		var runId string
-		err = rows.Scan(&runId)
-		if err != nil {
-			log.Fatal(err)
-		}
-		reportList = append(reportList, runId)
-	}
-	rows.Close()
-	if err = rows.Err(); err != nil {
-		log.Fatal(err)
-	}
-	return reportList
-}
-
 func getReportListMySQL(from, until time.Time, retrows int) ([]*Report, error) {
 	reports := make([]*Report, 0)
 	stmt, err := data_store.Dbh.Prepare("SELECT run_id, start_time, end_time, total_res_count, status, run_list, resources, data, node_name FROM reports WHERE start_time >= ? AND start_time <= ? LIMIT ?")
@@ -171,7 +121,7 @@ func getReportListMySQL(from, until time.Time, retrows int) ([]*Report, error) {
 	}
 	for rows.Next() {
 		r := new(Report)
-		err = r.fillReportFromMySQL(rows)
+		err = r.fillReportFromSQL(rows)
 		if err != nil {
 			rows.Close()
 			return nil, err
@@ -201,7 +151,7 @@ func getNodeListMySQL(nodeName string, from, until time.Time, retrows int) ([]*R
 	}
 	for rows.Next() {
 		r := new(Report)
-		err = r.fillReportFromMySQL(rows)
+		err = r.fillReportFromSQL(rows)
 		if err != nil {
 			rows.Close()
 			return nil, err
@@ -214,3 +164,36 @@ func getNodeListMySQL(nodeName string, from, until time.Time, retrows int) ([]*R
 	}
 	return reports, nil
 }
+
+func nodeRunsMySQL(nodeName string, offset int, limit int) ([]*Report, int, error) {
+	var total int
+	if err := data_store.Dbh.QueryRow("SELECT COUNT(*) FROM reports WHERE node_name = ?", nodeName).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+	var retrows int64 = (1 << 63) - 1
+	if limit >= 0 {
+		retrows = int64(limit)
+	}
+	reports := make([]*Report, 0)
+	rows, err := data_store.Dbh.Query("SELECT run_id, start_time, end_time, total_res_count, status, run_list, resources, data, node_name FROM reports WHERE node_name = ? ORDER BY start_time DESC LIMIT ?, ?", nodeName, offset, retrows)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return reports, total, nil
+		}
+		return nil, 0, err
+	}
+	for rows.Next() {
+		r := new(Report)
+		err = r.fillReportFromSQL(rows)
+		if err != nil {
+			rows.Close()
+			return nil, 0, err
+		}
+		reports = append(reports, r)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return nil, 0, err
+	}
+	return reports, total, nil
+}
@@ -0,0 +1,193 @@
+/*
+ * Copyright (c) 2013-2014, Jeremy Bingham (<jbingham@gmail.com>)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package report
+
+import (
+	"github.com/ctdk/goiardi/data_store"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+func checkForReportPostgreSQL(dbhandle data_store.Dbhandle, runId string) (bool, error) {
+	var f int
+	stmt, err := dbhandle.Prepare("SELECT count(*) AS c FROM reports WHERE run_id = $1")
+	if err != nil {
+		return false, err
+	}
+	defer stmt.Close()
+	err = stmt.QueryRow(runId).Scan(&f)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return false, nil
+		} else {
+			return false, err
+		}
+	}
+	if f > 0 {
+		return true, nil
+	} else {
+		return false, nil
+	}
+}
+
+func getReportPostgreSQL(runId string) (*Report, error) {
+	r := new(Report)
+	stmt, err := data_store.Dbh.Prepare("SELECT run_id, start_time, end_time, total_res_count, status, run_list, resources, data, node_name FROM reports WHERE run_id = $1")
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+	row := stmt.QueryRow(runId)
+	err = r.fillReportFromSQL(row)
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *Report)savePostgreSQL() error {
+	res, reserr := data_store.EncodeBlob(&r.Resources)
+	if reserr != nil {
+		return reserr
+	}
+	dat, daterr := data_store.EncodeBlob(&r.Data)
+	if daterr != nil {
+		return daterr
+	}
+	tx, err := data_store.Dbh.Begin()
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("INSERT INTO reports (run_id, node_name, start_time, end_time, total_res_count, status, run_list, resources, data, created_at, updated_at) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, NOW(), NOW()) ON CONFLICT (run_id) DO UPDATE SET start_time = EXCLUDED.start_time, end_time = EXCLUDED.end_time, total_res_count = EXCLUDED.total_res_count, status = EXCLUDED.status, run_list = EXCLUDED.run_list, resources = EXCLUDED.resources, data = EXCLUDED.data, updated_at = NOW()", r.RunId, r.NodeName, r.StartTime, r.EndTime, r.TotalResCount, r.Status, r.RunList, res, dat)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	tx.Commit()
+	return nil
+}
+
+func (r *Report)deletePostgreSQL() error {
+	tx, err := data_store.Dbh.Begin()
+	if err != nil {
+		return nil
+	}
+	_, err = tx.Exec("DELETE FROM reports WHERE run_id = $1", r.RunId)
+	if err != nil {
+		terr := tx.Rollback()
+		if terr != nil {
+			err = fmt.Errorf("deleting report %s had an error '%s', and then rolling back the transaction gave another error '%s'", r.RunId, err.Error(), terr.Error())
+		}
+		return err
+	}
+	tx.Commit()
+	return nil
+}
+
+func getReportListPostgreSQL(from, until time.Time, retrows int) ([]*Report, error) {
+	reports := make([]*Report, 0)
+	stmt, err := data_store.Dbh.Prepare("SELECT run_id, start_time, end_time, total_res_count, status, run_list, resources, data, node_name FROM reports WHERE start_time >= $1 AND start_time <= $2 LIMIT $3")
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+	rows, rerr := stmt.Query(from, until, retrows)
+	if rerr != nil {
+		if rerr == sql.ErrNoRows {
+			return reports, nil
+		}
+		return nil, rerr
+	}
+	for rows.Next() {
+		r := new(Report)
+		err = r.fillReportFromSQL(rows)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		reports = append(reports, r)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return reports, nil
+}
+
+func getNodeListPostgreSQL(nodeName string, from, until time.Time, retrows int) ([]*Report, error) {
+	reports := make([]*Report, 0)
+	stmt, err := data_store.Dbh.Prepare("SELECT run_id, start_time, end_time, total_res_count, status, run_list, resources, data, node_name FROM reports WHERE node_name = $1 AND start_time >= $2 AND start_time <= $3 LIMIT $4")
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+	rows, rerr := stmt.Query(nodeName, from, until, retrows)
+	if rerr != nil {
+		if rerr == sql.ErrNoRows {
+			return reports, nil
+		}
+		return nil, rerr
+	}
+	for rows.Next() {
+		r := new(Report)
+		err = r.fillReportFromSQL(rows)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		reports = append(reports, r)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return reports, nil
+}
+
+func nodeRunsPostgreSQL(nodeName string, offset int, limit int) ([]*Report, int, error) {
+	var total int
+	if err := data_store.Dbh.QueryRow("SELECT COUNT(*) FROM reports WHERE node_name = $1", nodeName).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+	var retrows int64 = (1 << 63) - 1
+	if limit >= 0 {
+		retrows = int64(limit)
+	}
+	reports := make([]*Report, 0)
+	rows, err := data_store.Dbh.Query("SELECT run_id, start_time, end_time, total_res_count, status, run_list, resources, data, node_name FROM reports WHERE node_name = $1 ORDER BY start_time DESC OFFSET $2 LIMIT $3", nodeName, offset, retrows)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return reports, total, nil
+		}
+		return nil, 0, err
+	}
+	for rows.Next() {
+		r := new(Report)
+		err = r.fillReportFromSQL(rows)
+		if err != nil {
+			rows.Close()
+			return nil, 0, err
+		}
+		reports = append(reports, r)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return nil, 0, err
+	}
+	return reports, total, nil
+}
@@ -27,6 +27,7 @@ import (
 	"net/http"
 	"strconv"
 	"database/sql"
+	"sort"
 	"github.com/codeskyblue/go-uuid"
 )
 
@@ -62,9 +63,9 @@ type privReport struct {
 
 func New(runId string, nodeName string) (*Report, util.Gerror) {
 	var found bool
-	if config.Config.UseMySQL {
+	if config.Config.UseSQL {
 		var err error
-		found, err = checkForReportMySQL(data_store.Dbh, runId)
+		found, err = checkForReportSQL(data_store.Dbh, runId)
 		if err != nil {
 			gerr := util.CastErr(err)
 			gerr.SetStatus(http.StatusInternalServerError)
@@ -95,9 +96,9 @@ func New(runId string, nodeName string) (*Report, util.Gerror) {
 func Get(runId string) (*Report, util.Gerror) {
 	var report *Report
 	var found bool
-	if config.Config.UseMySQL {
+	if config.Config.UseSQL {
 		var err error
-		report, err = getReportMySQL(runId)
+		report, err = getReportSQL(runId)
 		if err != nil {
 			if err == sql.ErrNoRows {
 				found = false
@@ -126,8 +127,8 @@ func Get(runId string) (*Report, util.Gerror) {
 }
 
 func (r *Report)Save() error {
-	if config.Config.UseMySQL {
-		return r.saveMySQL()
+	if config.Config.UseSQL {
+		return r.saveSQL()
 	} else {
 		ds := data_store.New()
 		ds.Set("report", r.RunId, r)
@@ -136,8 +137,8 @@ func (r *Report)Save() error {
 }
 
 func (r *Report)Delete() error {
-	if config.Config.UseMySQL {
-		return r.deleteMySQL()
+	if config.Config.UseSQL {
+		return r.deleteSQL()
 	} else {
 		ds := data_store.New()
 		ds.Delete("report", r.RunId)
@@ -251,7 +252,7 @@ func (r *Report)UpdateFromJson(json_report map[string]interface{}) util.Gerror {
 
 func GetList() []string {
 	var report_list []string
-	if config.Config.UseMySQL {
+	if config.Config.UseSQL {
 
 	} else {
 		ds := data_store.New()
@@ -261,8 +262,8 @@ func GetList() []string {
 }
 
 func GetReportList(from, until time.Time, rows int) ([]*Report, error) {
-	if config.Config.UseMySQL {
-		return getReportListMySQL(from, until, rows)
+	if config.Config.UseSQL {
+		return getReportListSQL(from, until, rows)
 	} else {
 		reports := make([]*Report, 0)
 		report_list := GetList()
@@ -286,8 +287,8 @@ func (r *Report)checkTimeRange(from, until time.Time) bool {
 }
 
 func GetNodeList(nodeName string, from, until time.Time, rows int) ([]*Report, error) {
-	if config.Config.UseMySQL {
-		return getNodeListMySQL(nodeName, from, until, rows)
+	if config.Config.UseSQL {
+		return getNodeListSQL(nodeName, from, until, rows)
 	} else {
 		// Really really not the most efficient way, but deliberately
 		// not doing it in a better manner for now. If reporting
@@ -304,6 +305,55 @@ func GetNodeList(nodeName string, from, until time.Time, rows int) ([]*Report, e
 	}
 }
 
+// Gets a page of the given node's runs, newest first, along with how many runs
+// the node has in all. A negative limit gets all of the runs after the offset.
+func NodeRuns(nodeName string, offset int, limit int) ([]*Report, int, error) {
+	if config.Config.UseSQL {
+		return nodeRunsSQL(nodeName, offset, limit)
+	}
+	runs := make([]*Report, 0)
+	for _, r := range GetList() {
+		rp, _ := Get(r)
+		if rp != nil && rp.NodeName == nodeName {
+			runs = append(runs, rp)
+		}
+	}
+	sort.Sort(runsByStart(runs))
+	total := len(runs)
+	if offset > total {
+		offset = total
+	}
+	end := total
+	if limit >= 0 && offset + limit < total {
+		end = offset + limit
+	}
+	return runs[offset:end], total, nil
+}
+
+// A short summary of a run: its id, status, when it started and ended, how
+// many resources it had, and how many of those were updated. The end time is
+// empty if the run hasn't finished.
+func (r *Report)Summary() map[string]interface{} {
+	var end_time string
+	if !r.EndTime.IsZero() {
+		end_time = r.EndTime.Format(ReportTimeFormat)
+	}
+	return map[string]interface{}{
+		"run_id": r.RunId,
+		"status": r.Status,
+		"start_time": r.StartTime.Format(ReportTimeFormat),
+		"end_time": end_time,
+		"total_res_count": r.TotalResCount,
+		"updated_res_count": len(r.Resources),
+	}
+}
+
+type runsByStart []*Report
+
+func (r runsByStart) Len() int { return len(r) }
+func (r runsByStart) Swap(i, j int) { r[i], r[j] = r[j], r[i] }
+func (r runsByStart) Less(i, j int) bool { return r[i].StartTime.After(r[j].StartTime) }
+
 func (r *Report) export() *privReport {
 	return &privReport{ RunId: &r.RunId, StartTime: &r.StartTime, EndTime: &r.EndTime, TotalResCount: &r.TotalResCount, Status: &r.Status, Resources: &r.Resources, Data: &r.Data, NodeName: &r.NodeName, OrganizationId: &r.organizationId }
 }
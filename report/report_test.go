@@ -88,3 +88,31 @@ func TestReportListing(t *testing.T){
 		t.Errorf("expected 2 items from node 'node2', got %d", len(ns))
 	}
 }
+
+func TestNodeRuns(t *testing.T){
+	ids := []string{ "3d3bcc3c-2b7e-4a3b-9e8c-5a0c7c6cb0a1", "3d3bcc3c-2b7e-4a3b-9e8c-5a0c7c6cb0a2", "3d3bcc3c-2b7e-4a3b-9e8c-5a0c7c6cb0a3" }
+	start := time.Date(2014, 6, 1, 12, 0, 0, 0, time.UTC)
+	for i, id := range ids {
+		r, _ := New(id, "runs_node")
+		r.StartTime = start.Add(time.Duration(i) * time.Hour)
+		r.Save()
+		defer r.Delete()
+	}
+	runs, total, err := NodeRuns("runs_node", 0, 2)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if total != 3 || len(runs) != 2 {
+		t.Fatalf("Expected 2 of 3 runs, got %d of %d", len(runs), total)
+	}
+	if runs[0].RunId != ids[2] || runs[1].RunId != ids[1] {
+		t.Errorf("Runs were not newest first: %s, %s", runs[0].RunId, runs[1].RunId)
+	}
+	runs, _, _ = NodeRuns("runs_node", 2, -1)
+	if len(runs) != 1 || runs[0].RunId != ids[0] {
+		t.Errorf("Expected only the oldest run past the offset, got %v", runs)
+	}
+	if s := runs[0].Summary(); s["end_time"] != "" || s["status"] != "started" {
+		t.Errorf("Unexpected summary for an unfinished run: %v", s)
+	}
+}
@@ -0,0 +1,123 @@
+/*
+ * Copyright (c) 2013-2014, Jeremy Bingham (<jbingham@gmail.com>)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package report
+
+import (
+	"github.com/ctdk/goiardi/config"
+	"github.com/ctdk/goiardi/data_store"
+	"database/sql"
+	"github.com/go-sql-driver/mysql"
+	"log"
+	"time"
+)
+
+func (r *Report)fillReportFromSQL(row data_store.ResRow) error{
+	var res, dat []byte
+	var st, et mysql.NullTime
+	err := row.Scan(&r.RunId, &st, &et, &r.TotalResCount, &r.Status, &r.RunList, &res, &dat, &r.NodeName)
+	if err != nil {
+		return err
+	}
+	if err = data_store.DecodeBlob(res, &r.Resources); err != nil {
+		return err
+	}
+	if err = data_store.DecodeBlob(dat, &r.Data); err != nil {
+		return err
+	}
+	if st.Valid {
+		r.StartTime = st.Time
+	} 
+	if et.Valid {
+		r.EndTime = et.Time
+	}
+
+	return nil
+}
+
+func getListSQL() []string {
+	reportList := make([]string, 0)
+	rows, err := data_store.Dbh.Query("SELECT run_id FROM reports")
+	if err != nil {
+		if err != sql.ErrNoRows {
+			log.Fatal(err)
+		}
+		rows.Close()
+		return reportList
+	}
+	for rows.Next() {
+		var runId string
+		err = rows.Scan(&runId)
+		if err != nil {
+			log.Fatal(err)
+		}
+		reportList = append(reportList, runId)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		log.Fatal(err)
+	}
+	return reportList
+}
+
+func checkForReportSQL(dbhandle data_store.Dbhandle, runId string) (bool, error) {
+	if config.Config.UseMySQL {
+		return checkForReportMySQL(dbhandle, runId)
+	}
+	return checkForReportPostgreSQL(dbhandle, runId)
+}
+
+func getReportSQL(runId string) (*Report, error) {
+	if config.Config.UseMySQL {
+		return getReportMySQL(runId)
+	}
+	return getReportPostgreSQL(runId)
+}
+
+func (r *Report)saveSQL() error {
+	if config.Config.UseMySQL {
+		return r.saveMySQL()
+	}
+	return r.savePostgreSQL()
+}
+
+func (r *Report)deleteSQL() error {
+	if config.Config.UseMySQL {
+		return r.deleteMySQL()
+	}
+	return r.deletePostgreSQL()
+}
+
+func getReportListSQL(from, until time.Time, retrows int) ([]*Report, error) {
+	if config.Config.UseMySQL {
+		return getReportListMySQL(from, until, retrows)
+	}
+	return getReportListPostgreSQL(from, until, retrows)
+}
+
+func getNodeListSQL(nodeName string, from, until time.Time, retrows int) ([]*Report, error) {
+	if config.Config.UseMySQL {
+		return getNodeListMySQL(nodeName, from, until, retrows)
+	}
+	return getNodeListPostgreSQL(nodeName, from, until, retrows)
+}
+
+func nodeRunsSQL(nodeName string, offset int, limit int) ([]*Report, int, error) {
+	if config.Config.UseMySQL {
+		return nodeRunsMySQL(nodeName, offset, limit)
+	}
+	return nodeRunsPostgreSQL(nodeName, offset, limit)
+}
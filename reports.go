@@ -39,7 +39,7 @@ func report_handler(w http.ResponseWriter, r *http.Request) {
 
 	opUser, oerr := actor.GetReqUser(r.Header.Get("X-OPS-USERID"))
 	if oerr != nil {
-		JsonErrorReport(w, r, oerr.Error(), oerr.Status())
+		GerrorReport(w, r, oerr)
 		return
 	}
 
@@ -131,7 +131,7 @@ func report_handler(w http.ResponseWriter, r *http.Request) {
 					runId := path_array[3]
 					run, err := report.Get(runId)
 					if err != nil {
-						JsonErrorReport(w, r, err.Error(), err.Status())
+						GerrorReport(w, r, err)
 						return
 					}
 					report_response = format_run_show(run)
@@ -166,7 +166,7 @@ func report_handler(w http.ResponseWriter, r *http.Request) {
 			if path_array_len == 4 {
 				rep, err := report.NewFromJson(nodeName, json_report)
 				if err != nil {
-					JsonErrorReport(w, r, err.Error(), err.Status())
+					GerrorReport(w, r, err)
 					return
 				}
 				// what's the expected response?
@@ -180,12 +180,12 @@ func report_handler(w http.ResponseWriter, r *http.Request) {
 				run_id := path_array[4]
 				rep, err := report.Get(run_id)
 				if err != nil {
-					JsonErrorReport(w, r, err.Error(), err.Status())
+					GerrorReport(w, r, err)
 					return
 				}
 				err = rep.UpdateFromJson(json_report)
 				if err != nil {
-					JsonErrorReport(w, r, err.Error(), err.Status())
+					GerrorReport(w, r, err)
 					return
 				}
 				serr := rep.Save()
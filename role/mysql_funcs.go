@@ -19,57 +19,9 @@ package role
 import (
 	"github.com/ctdk/goiardi/data_store"
 	"fmt"
-	"log"
 	"database/sql"
 )
 
-func checkForRoleMySQL(dbhandle data_store.Dbhandle, name string) (bool, error) {
-	_, err := data_store.CheckForOne(dbhandle, "roles", name)
-	if err == nil {
-		return true, nil
-	} else {
-		if err != sql.ErrNoRows {
-			return false, err
-		} else {
-			return false, nil
-		}
-	}
-}
-
-func (r *Role)fillRoleFromSQL(row *sql.Row) error {
-	var (
-		rl []byte
-		er []byte
-		da []byte
-		oa []byte
-	)
-	err := row.Scan(&r.Name, &r.Description, &rl, &er, &da, &oa)
-	if err != nil {
-		return err
-	}
-	r.ChefType = "role"
-	r.JsonClass = "Chef::Role"
-	err = data_store.DecodeBlob(rl, &r.RunList)
-	if err != nil {
-		return err
-	}
-	err = data_store.DecodeBlob(er, &r.EnvRunLists)
-	if err != nil {
-		return err
-	}
-	err = data_store.DecodeBlob(da, &r.Default)
-	if err != nil {
-		return err
-	}
-	err = data_store.DecodeBlob(oa, &r.Override)
-	if err != nil {
-		return err
-	}
-	data_store.ChkNilArray(r)
-
-	return nil
-}
-
 func getMySQL(role_name string) (*Role, error) {
 	role := new(Role)
 	stmt, err := data_store.Dbh.Prepare("SELECT name, description, run_list, env_run_lists, default_attr, override_attr FROM roles WHERE name = ?")
@@ -146,27 +98,3 @@ func (r *Role) deleteMySQL() error {
 	return nil
 }
 
-func getListMySQL() []string {
-	role_list := make([]string, 0)
-	rows, err := data_store.Dbh.Query("SELECT name FROM roles")
-	if err != nil {
-		rows.Close()
-		if err != sql.ErrNoRows {
-			log.Fatal(err)
-		}
-		return role_list
-	}
-	for rows.Next() {
-		var role_name string
-		err = rows.Scan(&role_name)
-		if err != nil {
-			log.Fatal(err)
-		}
-		role_list = append(role_list, role_name)
-	}
-	rows.Close()
-	if err = rows.Err(); err != nil {
-		log.Fatal(err)
-	}
-	return role_list
-}
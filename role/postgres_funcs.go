@@ -0,0 +1,84 @@
+/*
+ * Copyright (c) 2013-2014, Jeremy Bingham (<jbingham@gmail.com>)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package role
+
+import (
+	"github.com/ctdk/goiardi/data_store"
+	"fmt"
+)
+
+func getPostgreSQL(role_name string) (*Role, error) {
+	role := new(Role)
+	stmt, err := data_store.Dbh.Prepare("SELECT name, description, run_list, env_run_lists, default_attr, override_attr FROM roles WHERE name = $1")
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+	row := stmt.QueryRow(role_name)
+	err = role.fillRoleFromSQL(row)
+	if err != nil {
+		return nil, err
+	}
+	return role, nil
+}
+
+func (r *Role)savePostgreSQL() error {
+	rlb, rlerr := data_store.EncodeBlob(&r.RunList)
+	if rlerr != nil {
+		return rlerr
+	}
+	erb, ererr := data_store.EncodeBlob(&r.EnvRunLists)
+	if ererr != nil {
+		return ererr
+	}
+	dab, daerr := data_store.EncodeBlob(&r.Default)
+	if daerr != nil {
+		return daerr
+	}
+	oab, oaerr := data_store.EncodeBlob(&r.Override)
+	if oaerr != nil {
+		return oaerr
+	}
+	tx, err := data_store.Dbh.Begin()
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("INSERT INTO roles (name, description, run_list, env_run_lists, default_attr, override_attr, created_at, updated_at) VALUES ($1, $2, $3, $4, $5, $6, NOW(), NOW()) ON CONFLICT (name) DO UPDATE SET description = EXCLUDED.description, run_list = EXCLUDED.run_list, env_run_lists = EXCLUDED.env_run_lists, default_attr = EXCLUDED.default_attr, override_attr = EXCLUDED.override_attr, updated_at = NOW()", r.Name, r.Description, rlb, erb, dab, oab)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	tx.Commit()
+	return nil
+}
+
+func (r *Role) deletePostgreSQL() error {
+	tx, err := data_store.Dbh.Begin()
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("DELETE FROM roles WHERE name = $1", r.Name)
+	if err != nil {
+		terr := tx.Rollback()
+		if terr != nil {
+			err = fmt.Errorf("deleting role %s had an error '%s', and then rolling back the transaction gave another error '%s'", r.Name, err.Error(), terr.Error())
+		}
+		return err
+	}
+	tx.Commit()
+	return nil
+}
@@ -22,12 +22,15 @@ package role
 
 import (
 	"github.com/ctdk/goiardi/config"
+	"github.com/ctdk/goiardi/cookbook"
 	"github.com/ctdk/goiardi/data_store"
 	"github.com/ctdk/goiardi/util"
 	"github.com/ctdk/goiardi/indexer"
+	"github.com/ctdk/goiardi/node"
 	"fmt"
 	"net/http"
 	"database/sql"
+	"strings"
 )
 
 /* Need env_run_lists?!!? */
@@ -45,9 +48,9 @@ type Role struct {
 
 func New(name string) (*Role, util.Gerror){
 	var found bool
-	if config.Config.UseMySQL {
+	if config.Config.UseSQL {
 		var err error
-		found, err = checkForRoleMySQL(data_store.Dbh, name)
+		found, err = checkForRoleSQL(data_store.Dbh, name)
 		if err != nil {
 			gerr := util.Errorf(err.Error())
 			gerr.SetStatus(http.StatusInternalServerError)
@@ -133,6 +136,17 @@ func (r *Role) UpdateFromJson(json_role map[string]interface{}) util.Gerror {
 		json_role["env_run_lists"] = make(map[string][]string)
 	}
 
+	if config.Config.StrictRoleRunLists {
+		run_lists := [][]string{ json_role["run_list"].([]string) }
+		for _, v := range json_role["env_run_lists"].(map[string][]string) {
+			run_lists = append(run_lists, v)
+		}
+		if dangling := danglingRunListRefs(r.Name, run_lists...); len(dangling) > 0 {
+			verr = util.Errorf("Run list references to nonexistent recipes or roles: %s", strings.Join(dangling, ", "))
+			return verr
+		}
+	}
+
 	attrs := []string{ "default_attributes", "override_attributes" }
 	for _, a := range attrs {
 		json_role[a], verr = util.ValidateAttributes(a, json_role[a])
@@ -186,9 +200,9 @@ func (r *Role) UpdateFromJson(json_role map[string]interface{}) util.Gerror {
 func Get(role_name string) (*Role, error){
 	var role *Role
 	var found bool
-	if config.Config.UseMySQL {
+	if config.Config.UseSQL {
 		var err error
-		role, err = getMySQL(role_name)
+		role, err = getSQL(role_name)
 		if err != nil {
 			if err == sql.ErrNoRows {
 				found = false
@@ -214,8 +228,8 @@ func Get(role_name string) (*Role, error){
 }
 
 func (r *Role) Save() error {
-	if config.Config.UseMySQL {
-		if err := r.saveMySQL(); err != nil {
+	if config.Config.UseSQL {
+		if err := r.saveSQL(); err != nil {
 			return nil
 		}
 	} else {
@@ -227,8 +241,8 @@ func (r *Role) Save() error {
 }
 
 func (r *Role) Delete() error {
-	if config.Config.UseMySQL {
-		if err := r.deleteMySQL(); err != nil {
+	if config.Config.UseSQL {
+		if err := r.deleteSQL(); err != nil {
 			return err
 		}
 	} else {
@@ -242,8 +256,8 @@ func (r *Role) Delete() error {
 // Get a list of the roles on this server.
 func GetList() []string {
 	var role_list []string
-	if config.Config.UseMySQL {
-		role_list = getListMySQL()
+	if config.Config.UseSQL {
+		role_list = getListSQL()
 	} else {
 		ds := data_store.New()
 		role_list = ds.GetList("role")
@@ -251,6 +265,210 @@ func GetList() []string {
 	return role_list
 }
 
+// Returns part of the list of roles, sorted by name, starting at offset and
+// holding at most limit of them, along with the total number of roles. A
+// negative limit returns everything after the offset.
+func GetListPage(offset int, limit int) ([]string, int) {
+	if config.Config.UseSQL {
+		return getListPageSQL(offset, limit)
+	}
+	role_list := GetList()
+	return data_store.PageList(role_list, offset, limit), len(role_list)
+}
+
+// Returns the role's run list for the given environment. If the role has a
+// run list specific to that environment it's used, otherwise the role's
+// default run list is returned.
+func (r *Role) EnvRunList(env_name string) []string {
+	if env_name != "_default" {
+		if rl, found := r.EnvRunLists[env_name]; found {
+			return rl
+		}
+	}
+	return r.RunList
+}
+
+// Expands the given run list for an environment, recursively replacing roles
+// with their environment specific run lists. Returns the recipes in the order
+// they would run, with duplicates removed, along with the roles that were
+// expanded. A role that includes itself, directly or otherwise, is an error.
+func ExpandRunList(run_list []string, env_name string) ([]string, []string, error) {
+	recipes := make([]string, 0)
+	roles := make([]string, 0)
+	seen := make(map[string]bool)
+	err := expandRunList(run_list, env_name, []string{}, seen, &recipes, &roles)
+	if err != nil {
+		return nil, nil, err
+	}
+	return recipes, roles, nil
+}
+
+func expandRunList(run_list []string, env_name string, path []string, seen map[string]bool, recipes *[]string, roles *[]string) error {
+	for _, item := range run_list {
+		rlType, rlName := splitRunListItem(item)
+		if rlType == "recipe" {
+			if !seen["recipe:" + rlName] {
+				seen["recipe:" + rlName] = true
+				*recipes = append(*recipes, rlName)
+			}
+			continue
+		}
+		for _, p := range path {
+			if p == rlName {
+				cpath := append(path[:len(path):len(path)], rlName)
+				err := fmt.Errorf("circular role dependency: %s", strings.Join(cpath, " -> "))
+				return err
+			}
+		}
+		/* A role already expanded elsewhere in the run list doesn't
+		 * need to be expanded again. */
+		if seen["role:" + rlName] {
+			continue
+		}
+		seen["role:" + rlName] = true
+		*roles = append(*roles, rlName)
+		r, err := Get(rlName)
+		if err != nil {
+			return err
+		}
+		err = expandRunList(r.EnvRunList(env_name), env_name, append(path[:len(path):len(path)], rlName), seen, recipes, roles)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Returns the names, sorted, of the nodes whose run lists include this role.
+// With transitive set, nodes that only get the role through another role in
+// their run list, expanded for the node's environment, are included too. A
+// node whose run list can't be expanded, say because a role in it is missing,
+// is only checked for direct references.
+func (r *Role) Nodes(transitive bool) ([]string, error) {
+	role_item := fmt.Sprintf("role[%s]", r.Name)
+	all_nodes, err := node.AllNodes()
+	if err != nil {
+		return nil, err
+	}
+	nodes := make([]string, 0)
+	/* Lots of nodes tend to share the same run list and environment, so
+	 * each run list is only expanded once for each environment. */
+	expanded := make(map[string][]string)
+	for _, n := range all_nodes {
+		if hasRole(n.RunList, role_item, r.Name, n.ChefEnvironment, transitive, expanded) {
+			nodes = append(nodes, n.Name)
+		}
+	}
+	return nodes, nil
+}
+
+func hasRole(run_list []string, role_item string, role_name string, env_name string, transitive bool, expanded map[string][]string) bool {
+	for _, item := range run_list {
+		if item == role_item {
+			return true
+		}
+	}
+	if !transitive {
+		return false
+	}
+	key := env_name + "\x00" + strings.Join(run_list, ",")
+	roles, found := expanded[key]
+	if !found {
+		var err error
+		_, roles, err = ExpandRunList(run_list, env_name)
+		if err != nil {
+			roles = nil
+		}
+		expanded[key] = roles
+	}
+	for _, rn := range roles {
+		if rn == role_name {
+			return true
+		}
+	}
+	return false
+}
+
+/* Find the items in the given run lists that refer to recipes or roles that
+ * don't exist. Recipes are looked for in the version of the cookbook the item
+ * specifies, or the latest version if it doesn't specify one. A role may
+ * refer to itself here; that's caught as a circular dependency later. */
+func danglingRunListRefs(role_name string, run_lists ...[]string) []string {
+	dangling := make([]string, 0)
+	seen := make(map[string]bool)
+	for _, rl := range run_lists {
+		for _, item := range rl {
+			if seen[item] {
+				continue
+			}
+			seen[item] = true
+			rlType, rlName := splitRunListItem(item)
+			if rlType == "role" {
+				if rlName == role_name {
+					continue
+				}
+				if _, err := Get(rlName); err != nil {
+					dangling = append(dangling, item)
+				}
+				continue
+			}
+			if !recipeExists(rlName) {
+				dangling = append(dangling, item)
+			}
+		}
+	}
+	return dangling
+}
+
+/* Does the recipe, like "foo::bar" or "foo::bar@1.0.0", exist? */
+func recipeExists(recipe string) bool {
+	var version string
+	if at := strings.Index(recipe, "@"); at != -1 {
+		version = recipe[at+1:]
+		recipe = recipe[:at]
+	}
+	recipe = strings.TrimSuffix(recipe, "::default")
+	cb_name := strings.SplitN(recipe, "::", 2)[0]
+	cb, err := cookbook.Get(cb_name)
+	if err != nil {
+		return false
+	}
+	var cbv *cookbook.CookbookVersion
+	if version != "" {
+		cbv, err = cb.GetVersion(version)
+		if err != nil {
+			return false
+		}
+	} else {
+		cbv = cb.LatestVersion()
+	}
+	if cbv == nil {
+		return false
+	}
+	recipes, rerr := cbv.RecipeList()
+	if rerr != nil {
+		return false
+	}
+	for _, rcp := range recipes {
+		if rcp == recipe {
+			return true
+		}
+	}
+	return false
+}
+
+/* Split a run list item like "role[foo]" into its type and name. Bare items
+ * are treated as recipes. */
+func splitRunListItem(item string) (string, string) {
+	if strings.HasPrefix(item, "role[") && strings.HasSuffix(item, "]") {
+		return "role", item[5:len(item) - 1]
+	}
+	if strings.HasPrefix(item, "recipe[") && strings.HasSuffix(item, "]") {
+		return "recipe", item[7:len(item) - 1]
+	}
+	return "recipe", item
+}
+
 func (r *Role) GetName() string {
 	return r.Name
 }
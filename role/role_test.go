@@ -0,0 +1,152 @@
+/*
+ * Copyright (c) 2013-2014, Jeremy Bingham (<jbingham@gmail.com>)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package role
+
+import (
+	"testing"
+	"reflect"
+	"strings"
+	"github.com/ctdk/goiardi/config"
+	"github.com/ctdk/goiardi/cookbook"
+	"github.com/ctdk/goiardi/node"
+)
+
+/* Make a role with the given run lists and stick it in the data store. */
+func makeRole(name string, run_list []string, env_run_lists map[string][]string) *Role {
+	r, _ := New(name)
+	r.RunList = run_list
+	if env_run_lists != nil {
+		r.EnvRunLists = env_run_lists
+	}
+	r.Save()
+	return r
+}
+
+func TestExpandRunList(t *testing.T){
+	makeRole("exp_base", []string{ "recipe[base]", "ntp" }, nil)
+	makeRole("exp_web", []string{ "role[exp_base]", "recipe[nginx]" }, map[string][]string{ "prod": { "role[exp_base]", "recipe[nginx::ssl]" } })
+	makeRole("exp_app", []string{ "role[exp_base]", "recipe[app]", "recipe[base]" }, nil)
+
+	run_list := []string{ "role[exp_web]", "role[exp_app]", "recipe[monitor]" }
+	recipes, roles, err := ExpandRunList(run_list, "_default")
+	if err != nil {
+		t.Fatalf("Expanding the run list failed: %s", err.Error())
+	}
+	exp_recipes := []string{ "base", "ntp", "nginx", "app", "monitor" }
+	exp_roles := []string{ "exp_web", "exp_base", "exp_app" }
+	if !reflect.DeepEqual(recipes, exp_recipes) {
+		t.Errorf("Expanded recipes were %v, expected %v", recipes, exp_recipes)
+	}
+	if !reflect.DeepEqual(roles, exp_roles) {
+		t.Errorf("Expanded roles were %v, expected %v", roles, exp_roles)
+	}
+
+	/* An environment with its own run list for a role uses it. */
+	recipes, _, err = ExpandRunList(run_list, "prod")
+	if err != nil {
+		t.Fatalf("Expanding the run list for prod failed: %s", err.Error())
+	}
+	exp_recipes = []string{ "base", "ntp", "nginx::ssl", "app", "monitor" }
+	if !reflect.DeepEqual(recipes, exp_recipes) {
+		t.Errorf("Expanded recipes for prod were %v, expected %v", recipes, exp_recipes)
+	}
+
+	if _, _, err := ExpandRunList([]string{ "role[exp_missing]" }, "_default"); err == nil {
+		t.Errorf("Expanding a run list with a missing role should have failed")
+	}
+}
+
+func TestExpandRunListCycle(t *testing.T){
+	makeRole("cyc_a", []string{ "role[cyc_b]" }, nil)
+	makeRole("cyc_b", []string{ "recipe[b]", "role[cyc_c]" }, nil)
+	makeRole("cyc_c", []string{ "role[cyc_a]" }, nil)
+	_, _, err := ExpandRunList([]string{ "role[cyc_a]" }, "_default")
+	if err == nil {
+		t.Fatalf("Expanding a run list with a role cycle should have failed")
+	}
+	if !strings.Contains(err.Error(), "cyc_a -> cyc_b -> cyc_c -> cyc_a") {
+		t.Errorf("The cycle error didn't give the path around the cycle: %s", err.Error())
+	}
+}
+
+func TestStrictRoleRunLists(t *testing.T){
+	recipes := []map[string]interface{}{ { "name": "default.rb", "path": "recipes/default.rb" }, { "name": "server.rb", "path": "recipes/server.rb" } }
+	cb := &cookbook.Cookbook{ Name: "strictcb", Versions: make(map[string]*cookbook.CookbookVersion) }
+	cb.Versions["1.0.0"] = &cookbook.CookbookVersion{ CookbookName: "strictcb", Version: "1.0.0", Name: "strictcb-1.0.0", Recipes: recipes }
+	cb.Save()
+	defer cb.Delete()
+	makeRole("strict_base", []string{}, nil)
+
+	/* Run lists come out of ParseObjJson as string slices. */
+	roleJson := func(name string, run_list []string, env_run_lists map[string][]string) map[string]interface{} {
+		return map[string]interface{}{ "name": name, "run_list": run_list, "env_run_lists": env_run_lists }
+	}
+	good := roleJson("strict_good", []string{ "recipe[strictcb]", "recipe[strictcb::server@1.0.0]", "role[strict_base]", "role[strict_good]" }, map[string][]string{ "prod": { "recipe[strictcb::default]" } })
+	bad := roleJson("strict_bad", []string{ "recipe[strictcb::client]", "role[strict_missing]", "recipe[strictcb::server@2.0.0]" }, map[string][]string{ "prod": { "recipe[nocb]" } })
+
+	/* Without strict-role-run-lists, anything goes. */
+	if _, err := NewFromJson(bad); err != nil {
+		t.Errorf("A role with dangling references was rejected without strict-role-run-lists set: %s", err.Error())
+	}
+
+	config.Config.StrictRoleRunLists = true
+	defer func() { config.Config.StrictRoleRunLists = false }()
+	if _, err := NewFromJson(good); err != nil {
+		t.Errorf("A role with only good references was rejected: %s", err.Error())
+	}
+	bad["name"] = "strict_bad2"
+	_, err := NewFromJson(bad)
+	if err == nil {
+		t.Fatalf("A role with dangling references should have been rejected")
+	}
+	for _, item := range []string{ "recipe[strictcb::client]", "role[strict_missing]", "recipe[strictcb::server@2.0.0]", "recipe[nocb]" } {
+		if !strings.Contains(err.Error(), item) {
+			t.Errorf("The error didn't mention %s: %s", item, err.Error())
+		}
+	}
+}
+
+func TestRoleNodes(t *testing.T){
+	web, _ := New("rn_web")
+	web.Save()
+	base, _ := New("rn_base")
+	base.RunList = []string{ "role[rn_web]" }
+	base.Save()
+	unused, _ := New("rn_unused")
+	unused.Save()
+
+	direct, _ := node.New("rn_direct")
+	direct.RunList = []string{ "role[rn_web]" }
+	direct.Save()
+	nested, _ := node.New("rn_nested")
+	nested.RunList = []string{ "role[rn_base]" }
+	nested.Save()
+	/* The same run list as rn_nested, which is only expanded once. */
+	nested2, _ := node.New("rn_nested2")
+	nested2.RunList = []string{ "role[rn_base]" }
+	nested2.Save()
+
+	if n, _ := web.Nodes(false); len(n) != 1 || n[0] != "rn_direct" {
+		t.Errorf("Expected only rn_direct to use rn_web directly, got %v", n)
+	}
+	if n, _ := web.Nodes(true); len(n) != 3 || n[0] != "rn_direct" || n[1] != "rn_nested" || n[2] != "rn_nested2" {
+		t.Errorf("Expected rn_direct, rn_nested, and rn_nested2 to use rn_web, got %v", n)
+	}
+	if n, _ := unused.Nodes(true); len(n) != 0 {
+		t.Errorf("Expected no nodes to use rn_unused, got %v", n)
+	}
+}
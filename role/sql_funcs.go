@@ -0,0 +1,125 @@
+/*
+ * Copyright (c) 2013-2014, Jeremy Bingham (<jbingham@gmail.com>)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package role
+
+import (
+	"github.com/ctdk/goiardi/config"
+	"github.com/ctdk/goiardi/data_store"
+	"log"
+	"database/sql"
+)
+
+func checkForRoleSQL(dbhandle data_store.Dbhandle, name string) (bool, error) {
+	_, err := data_store.CheckForOne(dbhandle, "roles", name)
+	if err == nil {
+		return true, nil
+	} else {
+		if err != sql.ErrNoRows {
+			return false, err
+		} else {
+			return false, nil
+		}
+	}
+}
+
+func (r *Role)fillRoleFromSQL(row *sql.Row) error {
+	var (
+		rl []byte
+		er []byte
+		da []byte
+		oa []byte
+	)
+	err := row.Scan(&r.Name, &r.Description, &rl, &er, &da, &oa)
+	if err != nil {
+		return err
+	}
+	r.ChefType = "role"
+	r.JsonClass = "Chef::Role"
+	err = data_store.DecodeBlob(rl, &r.RunList)
+	if err != nil {
+		return err
+	}
+	err = data_store.DecodeBlob(er, &r.EnvRunLists)
+	if err != nil {
+		return err
+	}
+	err = data_store.DecodeBlob(da, &r.Default)
+	if err != nil {
+		return err
+	}
+	err = data_store.DecodeBlob(oa, &r.Override)
+	if err != nil {
+		return err
+	}
+	data_store.ChkNilArray(r)
+
+	return nil
+}
+
+func getSQL(role_name string) (*Role, error) {
+	if config.Config.UseMySQL {
+		return getMySQL(role_name)
+	}
+	return getPostgreSQL(role_name)
+}
+
+func (r *Role) saveSQL() error {
+	if config.Config.UseMySQL {
+		return r.saveMySQL()
+	}
+	return r.savePostgreSQL()
+}
+
+func (r *Role) deleteSQL() error {
+	if config.Config.UseMySQL {
+		return r.deleteMySQL()
+	}
+	return r.deletePostgreSQL()
+}
+
+func getListSQL() []string {
+	role_list := make([]string, 0)
+	rows, err := data_store.Dbh.Query("SELECT name FROM roles")
+	if err != nil {
+		rows.Close()
+		if err != sql.ErrNoRows {
+			log.Fatal(err)
+		}
+		return role_list
+	}
+	for rows.Next() {
+		var role_name string
+		err = rows.Scan(&role_name)
+		if err != nil {
+			log.Fatal(err)
+		}
+		role_list = append(role_list, role_name)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		log.Fatal(err)
+	}
+	return role_list
+}
+
+func getListPageSQL(offset int, limit int) ([]string, int) {
+	role_list, total, err := data_store.GetNameListPage(data_store.Dbh, "roles", offset, limit)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return role_list, total
+}
@@ -22,10 +22,12 @@ import (
 	"net/http"
 	"github.com/ctdk/goiardi/role"
 	"github.com/ctdk/goiardi/util"
+	"github.com/ctdk/goiardi/config"
 	"github.com/ctdk/goiardi/environment"
 	"encoding/json"
 	"github.com/ctdk/goiardi/actor"
 	"github.com/ctdk/goiardi/log_info"
+	"strconv"
 )
 
 func role_handler(w http.ResponseWriter, r *http.Request){
@@ -33,7 +35,7 @@ func role_handler(w http.ResponseWriter, r *http.Request){
 	
 	opUser, oerr := actor.GetReqUser(r.Header.Get("X-OPS-USERID"))
 	if oerr != nil {
-		JsonErrorReport(w, r, oerr.Error(), oerr.Status())
+		GerrorReport(w, r, oerr)
 		return
 	}
 
@@ -45,8 +47,42 @@ func role_handler(w http.ResponseWriter, r *http.Request){
 	role_name := path_array[1]
 
 	chef_role, err := role.Get(role_name)
+	created := false
 	if err != nil {
-		JsonErrorReport(w, r, err.Error(), http.StatusNotFound)
+		if !config.Config.PutCreates || r.Method != "PUT" || len(path_array) != 2 {
+			JsonErrorReport(w, r, err.Error(), http.StatusNotFound)
+			return
+		}
+		var nerr util.Gerror
+		chef_role, nerr = role.New(role_name)
+		if nerr != nil {
+			GerrorReport(w, r, nerr)
+			return
+		}
+		created = true
+	}
+
+	/* The nodes using this role, so it's clear what changing it will
+	 * affect. */
+	if len(path_array) == 3 && path_array[2] == "_nodes" {
+		if r.Method != "GET" {
+			JsonErrorReport(w, r, "Unrecognized method!", http.StatusMethodNotAllowed)
+			return
+		}
+		if opUser.IsValidator() {
+			JsonErrorReport(w, r, "You are not allowed to perform this action", http.StatusForbidden)
+			return
+		}
+		transitive, _ := strconv.ParseBool(r.FormValue("transitive"))
+		role_nodes, err := chef_role.Nodes(transitive)
+		if err != nil {
+			JsonErrorReport(w, r, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		enc := json.NewEncoder(w)
+		if err = enc.Encode(&role_nodes); err != nil {
+			JsonErrorReport(w, r, err.Error(), http.StatusInternalServerError)
+		}
 		return
 	}
 
@@ -89,7 +125,7 @@ func role_handler(w http.ResponseWriter, r *http.Request){
 				}
 				json_name, sterr := util.ValidateAsString(role_data["name"])
 				if sterr != nil {
-					JsonErrorReport(w, r, sterr.Error(), sterr.Status())
+					GerrorReport(w, r, sterr)
 					return
 				}
 				if role_name != role_data["name"].(string) {
@@ -101,7 +137,7 @@ func role_handler(w http.ResponseWriter, r *http.Request){
 					}
 					nerr := chef_role.UpdateFromJson(role_data)
 					if nerr != nil {
-						JsonErrorReport(w, r, nerr.Error(), nerr.Status())
+						GerrorReport(w, r, nerr)
 						return
 					}
 				}
@@ -111,10 +147,13 @@ func role_handler(w http.ResponseWriter, r *http.Request){
 					JsonErrorReport(w, r, err.Error(), http.StatusInternalServerError)
 					return
 				}
-				if lerr := log_info.LogEvent(opUser, chef_role, "modify"); lerr != nil {
+				if lerr := log_info.LogEvent(opUser, chef_role, putAction(created)); lerr != nil {
 					JsonErrorReport(w, r, lerr.Error(), http.StatusInternalServerError)
 					return
 				}
+				if created {
+					w.WriteHeader(http.StatusCreated)
+				}
 				enc := json.NewEncoder(w)
 				if err = enc.Encode(&chef_role); err != nil {
 					JsonErrorReport(w, r, err.Error(), http.StatusInternalServerError)
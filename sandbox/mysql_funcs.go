@@ -19,29 +19,9 @@ package sandbox
 import (
 	"database/sql"
 	"fmt"
-	"log"
 	"github.com/ctdk/goiardi/data_store"
-	"time"
 )
 
-func (s *Sandbox)fillSandboxFromSQL(row *sql.Row) error {
-	var csb []byte
-	var tb []byte
-	err := row.Scan(&s.Id, &tb, &csb, &s.Completed)
-	if err != nil {
-		return err
-	}
-	err = data_store.DecodeBlob(csb, &s.Checksums)
-	if err != nil {
-		return err
-	}
-	s.CreationTime, err = time.Parse(data_store.MySQLTimeFormat, string(tb))
-	if err != nil {
-		return err
-	}
-	return nil
-}
-
 func getMySQL(sandbox_id string) (*Sandbox, error) {
 	sandbox := new(Sandbox)
 	stmt, err := data_store.Dbh.Prepare("SELECT sbox_id, creation_time, checksums, completed FROM sandboxes WHERE sbox_id = ?")
@@ -105,28 +85,3 @@ func (s *Sandbox) deleteMySQL() error {
 	tx.Commit()
 	return nil
 }
-
-func getListMySQL() []string {
-	sandbox_list := make([]string, 0)
-	rows, err := data_store.Dbh.Query("SELECT sbox_id FROM sandboxes")
-	if err != nil {
-		if err != sql.ErrNoRows {
-			log.Fatal(err)
-		}
-		rows.Close()
-		return sandbox_list
-	}
-	for rows.Next() {
-		var sbox_id string
-		err = rows.Scan(&sbox_id)
-		if err != nil {
-			log.Fatal(err)
-		}
-		sandbox_list = append(sandbox_list, sbox_id)
-	}
-	rows.Close()
-	if err = rows.Err(); err != nil {
-		log.Fatal(err)
-	}
-	return sandbox_list
-}
@@ -0,0 +1,73 @@
+/*
+ * Copyright (c) 2013-2014, Jeremy Bingham (<jbingham@gmail.com>)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package sandbox
+
+import (
+	"github.com/ctdk/goiardi/data_store"
+	"fmt"
+)
+
+func getPostgreSQL(sandbox_id string) (*Sandbox, error) {
+	sandbox := new(Sandbox)
+	/* fillSandboxFromSQL expects the creation time in the MySQL format. */
+	stmt, err := data_store.Dbh.Prepare("SELECT sbox_id, to_char(creation_time AT TIME ZONE 'UTC', 'YYYY-MM-DD HH24:MI:SS'), checksums, completed FROM sandboxes WHERE sbox_id = $1")
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+	row := stmt.QueryRow(sandbox_id)
+	err = sandbox.fillSandboxFromSQL(row)
+	if err != nil {
+		return nil, err
+	}
+	return sandbox, nil
+}
+
+func (s *Sandbox) savePostgreSQL() error {
+	ckb, ckerr := data_store.EncodeBlob(&s.Checksums)
+	if ckerr != nil {
+		return ckerr
+	}
+	tx, err := data_store.Dbh.Begin()
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("INSERT INTO sandboxes (sbox_id, creation_time, checksums, completed) VALUES ($1, $2, $3, $4) ON CONFLICT (sbox_id) DO UPDATE SET checksums = EXCLUDED.checksums, completed = EXCLUDED.completed", s.Id, s.CreationTime.UTC(), ckb, s.Completed)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	tx.Commit()
+	return nil
+}
+
+func (s *Sandbox) deletePostgreSQL() error {
+	tx, err := data_store.Dbh.Begin()
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("DELETE FROM sandboxes WHERE sbox_id = $1", s.Id)
+	if err != nil {
+		terr := tx.Rollback()
+		if terr != nil {
+			err = fmt.Errorf("deleting sandbox %s had an error '%s', and then rolling back the transaction gave another error '%s'", s.Id, err.Error(), terr.Error())
+		}
+		return err
+	}
+	tx.Commit()
+	return nil
+}
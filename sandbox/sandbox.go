@@ -30,6 +30,7 @@ import (
 	"crypto/md5"
 	"crypto/rand"
 	"io"
+	"strings"
 	"time"
 	"database/sql"
 	"git.tideland.biz/goas/logger"
@@ -115,9 +116,9 @@ func Get(sandbox_id string) (*Sandbox, error){
 	var sandbox *Sandbox
 	var found bool
 
-	if config.Config.UseMySQL {
+	if config.Config.UseSQL {
 		var err error
-		sandbox, err = getMySQL(sandbox_id)
+		sandbox, err = getSQL(sandbox_id)
 		if err != nil {
 			if err == sql.ErrNoRows {
 				found = false
@@ -144,8 +145,8 @@ func Get(sandbox_id string) (*Sandbox, error){
 }
 
 func (s *Sandbox) Save() error {
-	if config.Config.UseMySQL {
-		if err := s.saveMySQL(); err != nil {
+	if config.Config.UseSQL {
+		if err := s.saveSQL(); err != nil {
 			return err
 		}
 	} else {
@@ -156,8 +157,8 @@ func (s *Sandbox) Save() error {
 }
 
 func (s *Sandbox) Delete() error {
-	if config.Config.UseMySQL {
-		if err := s.deleteMySQL(); err != nil {
+	if config.Config.UseSQL {
+		if err := s.deleteSQL(); err != nil {
 			return nil
 		}
 	} else {
@@ -169,8 +170,8 @@ func (s *Sandbox) Delete() error {
 
 func GetList() []string {
 	var sandbox_list []string
-	if config.Config.UseMySQL {
-		sandbox_list = getListMySQL()
+	if config.Config.UseSQL {
+		sandbox_list = getListSQL()
 	} else {
 		ds := data_store.New()
 		sandbox_list = ds.GetList("sandbox")
@@ -198,16 +199,36 @@ func (s *Sandbox) UploadChkList() map[string]map[string]interface{} {
 	return chksum_stats
 }
 
-// Is the sandbox complete?
+// Is the sandbox complete? Every file in the sandbox must have been uploaded,
+// and the uploaded file's contents must match its checksum. If not, the error
+// lists every checksum that hasn't been uploaded or doesn't match.
 func (s *Sandbox) IsComplete() error {
+	missing := make([]string, 0)
+	mismatched := make([]string, 0)
 	for _, chk := range s.Checksums {
 		k, _ := filestore.Get(chk)
 		if k == nil {
-			err := fmt.Errorf("Checksum %s not uploaded yet, %s not complete, cannot commit yet.", chk, s.Id)
-			return err
+			missing = append(missing, chk)
+			continue
+		}
+		/* The file was checked when it was uploaded, but check again
+		 * in case it's been changed on disk or in S3 since. */
+		if k.Data != nil && fmt.Sprintf("%x", md5.Sum(*k.Data)) != chk {
+			mismatched = append(mismatched, chk)
 		}
 	}
-	return nil
+	if len(missing) == 0 && len(mismatched) == 0 {
+		return nil
+	}
+	problems := make([]string, 0, 2)
+	if len(missing) > 0 {
+		problems = append(problems, fmt.Sprintf("checksums not uploaded yet: %s", strings.Join(missing, ", ")))
+	}
+	if len(mismatched) > 0 {
+		problems = append(problems, fmt.Sprintf("checksums whose content does not match: %s", strings.Join(mismatched, ", ")))
+	}
+	err := fmt.Errorf("Sandbox %s not complete, cannot commit yet. Found %s.", s.Id, strings.Join(problems, "; "))
+	return err
 }
 
 func (s *Sandbox) GetName() string {
@@ -0,0 +1,89 @@
+/*
+ * Copyright (c) 2013-2014, Jeremy Bingham (<jbingham@gmail.com>)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package sandbox
+
+import (
+	"github.com/ctdk/goiardi/config"
+	"github.com/ctdk/goiardi/data_store"
+	"database/sql"
+	"log"
+	"time"
+)
+
+func (s *Sandbox)fillSandboxFromSQL(row *sql.Row) error {
+	var csb []byte
+	var tb []byte
+	err := row.Scan(&s.Id, &tb, &csb, &s.Completed)
+	if err != nil {
+		return err
+	}
+	err = data_store.DecodeBlob(csb, &s.Checksums)
+	if err != nil {
+		return err
+	}
+	s.CreationTime, err = time.Parse(data_store.MySQLTimeFormat, string(tb))
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func getListSQL() []string {
+	sandbox_list := make([]string, 0)
+	rows, err := data_store.Dbh.Query("SELECT sbox_id FROM sandboxes")
+	if err != nil {
+		if err != sql.ErrNoRows {
+			log.Fatal(err)
+		}
+		rows.Close()
+		return sandbox_list
+	}
+	for rows.Next() {
+		var sbox_id string
+		err = rows.Scan(&sbox_id)
+		if err != nil {
+			log.Fatal(err)
+		}
+		sandbox_list = append(sandbox_list, sbox_id)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		log.Fatal(err)
+	}
+	return sandbox_list
+}
+
+func getSQL(sandbox_id string) (*Sandbox, error) {
+	if config.Config.UseMySQL {
+		return getMySQL(sandbox_id)
+	}
+	return getPostgreSQL(sandbox_id)
+}
+
+func (s *Sandbox) saveSQL() error {
+	if config.Config.UseMySQL {
+		return s.saveMySQL()
+	}
+	return s.savePostgreSQL()
+}
+
+func (s *Sandbox) deleteSQL() error {
+	if config.Config.UseMySQL {
+		return s.deleteMySQL()
+	}
+	return s.deletePostgreSQL()
+}
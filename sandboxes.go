@@ -32,7 +32,7 @@ func sandbox_handler(w http.ResponseWriter, r *http.Request){
 	sbox_response := make(map[string]interface{})
 	opUser, oerr := actor.GetReqUser(r.Header.Get("X-OPS-USERID"))
 	if oerr != nil {
-		JsonErrorReport(w, r, oerr.Error(), oerr.Status())
+		GerrorReport(w, r, oerr)
 		return
 	}
 
@@ -0,0 +1,70 @@
+/*
+ * Copyright (c) 2013-2014, Jeremy Bingham (<jbingham@gmail.com>)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package saved_search
+
+import (
+	"github.com/ctdk/goiardi/data_store"
+	"fmt"
+)
+
+func getMySQL(name string) (*SavedSearch, error) {
+	ss := new(SavedSearch)
+	stmt, err := data_store.Dbh.Prepare("SELECT name, index_name, query, partial FROM saved_searches WHERE name = ?")
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+	row := stmt.QueryRow(name)
+	err = ss.fillSavedSearchFromSQL(row)
+	if err != nil {
+		return nil, err
+	}
+	return ss, nil
+}
+
+func (ss *SavedSearch) saveMySQL() error {
+	pb, perr := data_store.EncodeBlob(&ss.Partial)
+	if perr != nil {
+		return perr
+	}
+	tx, err := data_store.Dbh.Begin()
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("INSERT INTO saved_searches (name, index_name, query, partial, created_at, updated_at) VALUES (?, ?, ?, ?, NOW(), NOW()) ON DUPLICATE KEY UPDATE index_name = VALUES(index_name), query = VALUES(query), partial = VALUES(partial), updated_at = NOW()", ss.Name, ss.Index, ss.Query, pb)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func (ss *SavedSearch) deleteMySQL() error {
+	tx, err := data_store.Dbh.Begin()
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("DELETE FROM saved_searches WHERE name = ?", ss.Name)
+	if err != nil {
+		terr := tx.Rollback()
+		if terr != nil {
+			err = fmt.Errorf("deleting saved search %s had an error '%s', and then rolling back the transaction gave another error '%s'", ss.Name, err.Error(), terr.Error())
+		}
+		return err
+	}
+	return tx.Commit()
+}
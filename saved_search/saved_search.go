@@ -0,0 +1,211 @@
+/* Saved searches, so often used queries can be run by name. */
+
+/*
+ * Copyright (c) 2013-2014, Jeremy Bingham (<jbingham@gmail.com>)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package saved_search keeps named searches, made up of an index, a query, and
+// optionally the keys for a partial search, so they can be run again by name
+// instead of copying the query around.
+package saved_search
+
+import (
+	"github.com/ctdk/goiardi/config"
+	"github.com/ctdk/goiardi/data_store"
+	"github.com/ctdk/goiardi/util"
+	"net/http"
+	"database/sql"
+)
+
+type SavedSearch struct {
+	Name string `json:"name"`
+	Index string `json:"index"`
+	Query string `json:"query"`
+	Partial map[string]interface{} `json:"partial,omitempty"`
+}
+
+func New(name string) (*SavedSearch, util.Gerror) {
+	var found bool
+	if config.Config.UseSQL {
+		var err error
+		found, err = checkForSavedSearchSQL(data_store.Dbh, name)
+		if err != nil {
+			gerr := util.CastErr(err)
+			gerr.SetStatus(http.StatusInternalServerError)
+			return nil, gerr
+		}
+	} else {
+		ds := data_store.New()
+		_, found = ds.Get("saved_search", name)
+	}
+	if found {
+		err := util.Errorf("Saved search %s already exists", name)
+		err.SetStatus(http.StatusConflict)
+		return nil, err
+	}
+	if !util.ValidateDBagName(name) {
+		err := util.Errorf("Field 'name' invalid")
+		err.SetStatus(http.StatusBadRequest)
+		return nil, err
+	}
+	ss := &SavedSearch{
+		Name: name,
+		Query: "*:*",
+	}
+	return ss, nil
+}
+
+// Create a new saved search from the uploaded JSON.
+func NewFromJson(json_ss map[string]interface{}) (*SavedSearch, util.Gerror) {
+	name, nerr := util.ValidateAsString(json_ss["name"])
+	if nerr != nil {
+		return nil, nerr
+	}
+	ss, err := New(name)
+	if err != nil {
+		return nil, err
+	}
+	if err = ss.UpdateFromJson(json_ss); err != nil {
+		return nil, err
+	}
+	return ss, nil
+}
+
+// Update a saved search with the uploaded JSON. The index is required, and the
+// query defaults to "*:*". The partial search keys, if given, are in the same
+// form as the body of a partial search, like { "ip": [ "ipaddress" ] }.
+func (ss *SavedSearch) UpdateFromJson(json_ss map[string]interface{}) util.Gerror {
+	if ss.Name != json_ss["name"] {
+		err := util.Errorf("Saved search name %s and %v from JSON do not match.", ss.Name, json_ss["name"])
+		return err
+	}
+	valid_elements := []string{ "name", "index", "query", "partial" }
+	ValidElem:
+	for k := range json_ss {
+		for _, i := range valid_elements {
+			if k == i {
+				continue ValidElem
+			}
+		}
+		err := util.Errorf("Invalid key %s in request body", k)
+		return err
+	}
+
+	index, verr := util.ValidateAsFieldString(json_ss["index"])
+	if verr != nil || index == "" {
+		verr = util.Errorf("Field 'index' missing")
+		return verr
+	}
+	query := "*:*"
+	if q, found := json_ss["query"]; found {
+		if query, verr = util.ValidateAsFieldString(q); verr != nil {
+			verr = util.Errorf("Field 'query' invalid")
+			return verr
+		}
+	}
+	var partial map[string]interface{}
+	switch p := json_ss["partial"].(type) {
+		case nil:
+		case map[string]interface{}:
+			for k, v := range p {
+				path, ok := v.([]interface{})
+				if !ok {
+					verr = util.Errorf("Partial search key %s must be a list of strings", k)
+					return verr
+				}
+				for _, e := range path {
+					if _, ok := e.(string); !ok {
+						verr = util.Errorf("Partial search key %s must be a list of strings", k)
+						return verr
+					}
+				}
+			}
+			partial = p
+		default:
+			verr = util.Errorf("Field 'partial' invalid")
+			return verr
+	}
+
+	ss.Index = index
+	ss.Query = query
+	ss.Partial = partial
+	return nil
+}
+
+func Get(name string) (*SavedSearch, util.Gerror) {
+	var ss *SavedSearch
+	var found bool
+	if config.Config.UseSQL {
+		var err error
+		ss, err = getSQL(name)
+		if err != nil {
+			if err != sql.ErrNoRows {
+				gerr := util.CastErr(err)
+				gerr.SetStatus(http.StatusInternalServerError)
+				return nil, gerr
+			}
+		} else {
+			found = true
+		}
+	} else {
+		ds := data_store.New()
+		var s interface{}
+		s, found = ds.Get("saved_search", name)
+		if s != nil {
+			ss = s.(*SavedSearch)
+		}
+	}
+	if !found {
+		err := util.Errorf("Cannot load saved search %s", name)
+		err.SetStatus(http.StatusNotFound)
+		return nil, err
+	}
+	return ss, nil
+}
+
+func (ss *SavedSearch) Save() error {
+	if config.Config.UseSQL {
+		return ss.saveSQL()
+	}
+	ds := data_store.New()
+	ds.Set("saved_search", ss.Name, ss)
+	return nil
+}
+
+func (ss *SavedSearch) Delete() error {
+	if config.Config.UseSQL {
+		return ss.deleteSQL()
+	}
+	ds := data_store.New()
+	ds.Delete("saved_search", ss.Name)
+	return nil
+}
+
+// Get a list of the saved searches on this server.
+func GetList() []string {
+	if config.Config.UseSQL {
+		return getListSQL()
+	}
+	ds := data_store.New()
+	return ds.GetList("saved_search")
+}
+
+func (ss *SavedSearch) GetName() string {
+	return ss.Name
+}
+
+func (ss *SavedSearch) URLType() string {
+	return "search/_saved"
+}
@@ -0,0 +1,55 @@
+/*
+ * Copyright (c) 2013-2014, Jeremy Bingham (<jbingham@gmail.com>)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package saved_search
+
+import (
+	"testing"
+)
+
+func TestSavedSearch(t *testing.T){
+	ss, err := NewFromJson(map[string]interface{}{ "name": "web", "index": "node", "query": "role:web", "partial": map[string]interface{}{ "ip": []interface{}{ "ipaddress" } } })
+	if err != nil {
+		t.Fatalf("Creating a saved search failed: %s", err.Error())
+	}
+	ss.Save()
+	defer ss.Delete()
+	got, gerr := Get("web")
+	if gerr != nil {
+		t.Fatalf("Getting the saved search failed: %s", gerr.Error())
+	}
+	if got.Index != "node" || got.Query != "role:web" || got.Partial["ip"] == nil {
+		t.Errorf("Saved search came back wrong: %+v", got)
+	}
+	if _, err = New("web"); err == nil {
+		t.Errorf("Making a saved search with the same name should have failed")
+	}
+	if err = got.UpdateFromJson(map[string]interface{}{ "name": "web", "index": "role" }); err != nil || got.Query != "*:*" {
+		t.Errorf("Updating without a query should have set it to *:*, got %s (%v)", got.Query, err)
+	}
+
+	bad := []map[string]interface{}{
+		{ "name": "bad" },
+		{ "name": "bad", "index": "node", "query": 5 },
+		{ "name": "bad", "index": "node", "partial": map[string]interface{}{ "ip": "ipaddress" } },
+		{ "name": "bad", "index": "node", "frequency": "daily" },
+	}
+	for _, b := range bad {
+		if _, err = NewFromJson(b); err == nil {
+			t.Errorf("Creating a saved search from %v should have failed", b)
+		}
+	}
+}
@@ -0,0 +1,91 @@
+/*
+ * Copyright (c) 2013-2014, Jeremy Bingham (<jbingham@gmail.com>)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package saved_search
+
+import (
+	"github.com/ctdk/goiardi/config"
+	"github.com/ctdk/goiardi/data_store"
+	"log"
+	"database/sql"
+)
+
+func checkForSavedSearchSQL(dbhandle data_store.Dbhandle, name string) (bool, error) {
+	_, err := data_store.CheckForOne(dbhandle, "saved_searches", name)
+	if err == nil {
+		return true, nil
+	} else {
+		if err != sql.ErrNoRows {
+			return false, err
+		} else {
+			return false, nil
+		}
+	}
+}
+
+func (ss *SavedSearch) fillSavedSearchFromSQL(row *sql.Row) error {
+	var pb []byte
+	err := row.Scan(&ss.Name, &ss.Index, &ss.Query, &pb)
+	if err != nil {
+		return err
+	}
+	return data_store.DecodeBlob(pb, &ss.Partial)
+}
+
+func getSQL(name string) (*SavedSearch, error) {
+	if config.Config.UseMySQL {
+		return getMySQL(name)
+	}
+	return getPostgreSQL(name)
+}
+
+func (ss *SavedSearch) saveSQL() error {
+	if config.Config.UseMySQL {
+		return ss.saveMySQL()
+	}
+	return ss.savePostgreSQL()
+}
+
+func (ss *SavedSearch) deleteSQL() error {
+	if config.Config.UseMySQL {
+		return ss.deleteMySQL()
+	}
+	return ss.deletePostgreSQL()
+}
+
+func getListSQL() []string {
+	ss_list := make([]string, 0)
+	rows, err := data_store.Dbh.Query("SELECT name FROM saved_searches ORDER BY name")
+	if err != nil {
+		if err != sql.ErrNoRows {
+			log.Fatal(err)
+		}
+		return ss_list
+	}
+	for rows.Next() {
+		var name string
+		err = rows.Scan(&name)
+		if err != nil {
+			log.Fatal(err)
+		}
+		ss_list = append(ss_list, name)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		log.Fatal(err)
+	}
+	return ss_list
+}
@@ -0,0 +1,122 @@
+/* Saved searches */
+
+/*
+ * Copyright (c) 2013-2014, Jeremy Bingham (<jbingham@gmail.com>)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"github.com/ctdk/goiardi/actor"
+	"github.com/ctdk/goiardi/log_info"
+	"github.com/ctdk/goiardi/saved_search"
+	"encoding/json"
+	"net/http"
+)
+
+/* Store, run, and delete saved searches. A GET runs the saved search through
+ * the normal search path, as a partial search if it has partial search keys,
+ * and takes the same sort, start, and rows parameters as any other search. */
+func saved_search_handler(w http.ResponseWriter, r *http.Request, name string, opUser actor.Actor, sortOrder string, start int, paramsRows int) {
+	var response interface{}
+	switch r.Method {
+		case "GET":
+			if opUser.IsValidator() {
+				JsonErrorReport(w, r, "You are not allowed to perform this action", http.StatusForbidden)
+				return
+			}
+			ss, err := saved_search.Get(name)
+			if err != nil {
+				GerrorReport(w, r, err)
+				return
+			}
+			search_response := make(map[string]interface{})
+			if !runSearch(w, r, ss.Index, ss.Query, ss.Partial, sortOrder, start, paramsRows, search_response) {
+				return
+			}
+			response = search_response
+		case "PUT":
+			if !opUser.IsAdmin() {
+				JsonErrorReport(w, r, "You are not allowed to perform this action", http.StatusForbidden)
+				return
+			}
+			ss_data, jerr := ParseObjJson(r.Body)
+			if jerr != nil {
+				JsonErrorReport(w, r, jerr.Error(), http.StatusBadRequest)
+				return
+			}
+			if _, found := ss_data["name"]; !found {
+				ss_data["name"] = name
+			}
+			if ss_data["name"] != name {
+				JsonErrorReport(w, r, "Saved search name mismatch.", http.StatusBadRequest)
+				return
+			}
+			action := "modify"
+			ss, err := saved_search.Get(name)
+			if err != nil {
+				if err.Status() != http.StatusNotFound {
+					GerrorReport(w, r, err)
+					return
+				}
+				action = "create"
+				ss, err = saved_search.NewFromJson(ss_data)
+			} else {
+				err = ss.UpdateFromJson(ss_data)
+			}
+			if err != nil {
+				GerrorReport(w, r, err)
+				return
+			}
+			if serr := ss.Save(); serr != nil {
+				JsonErrorReport(w, r, serr.Error(), http.StatusInternalServerError)
+				return
+			}
+			if lerr := log_info.LogEvent(opUser, ss, action); lerr != nil {
+				JsonErrorReport(w, r, lerr.Error(), http.StatusInternalServerError)
+				return
+			}
+			if action == "create" {
+				w.WriteHeader(http.StatusCreated)
+			}
+			response = ss
+		case "DELETE":
+			if !opUser.IsAdmin() {
+				JsonErrorReport(w, r, "You are not allowed to perform this action", http.StatusForbidden)
+				return
+			}
+			ss, err := saved_search.Get(name)
+			if err != nil {
+				GerrorReport(w, r, err)
+				return
+			}
+			if derr := ss.Delete(); derr != nil {
+				JsonErrorReport(w, r, derr.Error(), http.StatusInternalServerError)
+				return
+			}
+			if lerr := log_info.LogEvent(opUser, ss, "delete"); lerr != nil {
+				JsonErrorReport(w, r, lerr.Error(), http.StatusInternalServerError)
+				return
+			}
+			response = ss
+		default:
+			JsonErrorReport(w, r, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+	}
+	enc := json.NewEncoder(w)
+	if err := enc.Encode(&response); err != nil {
+		JsonErrorReport(w, r, err.Error(), http.StatusInternalServerError)
+	}
+}
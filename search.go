@@ -22,15 +22,18 @@ import (
 	"github.com/ctdk/goiardi/util"
 	"github.com/ctdk/goiardi/search"
 	"github.com/ctdk/goiardi/data_bag"
+	"github.com/ctdk/goiardi/saved_search"
 	"github.com/ctdk/goiardi/actor"
 	"github.com/ctdk/goiardi/client"
 	"github.com/ctdk/goiardi/indexer"
 	"github.com/ctdk/goiardi/data_store"
+	"github.com/ctdk/goiardi/config"
 	"net/http"
 	"encoding/json"
 	"fmt"
 	"strconv"
 	"regexp"
+	"strings"
 	"git.tideland.biz/goas/logger"
 )
 
@@ -44,7 +47,7 @@ func search_handler(w http.ResponseWriter, r *http.Request){
 
 	opUser, oerr := actor.GetReqUser(r.Header.Get("X-OPS-USERID"))
 	if oerr != nil {
-		JsonErrorReport(w, r, oerr.Error(), oerr.Status())
+		GerrorReport(w, r, oerr)
 		return
 	}
 
@@ -73,15 +76,12 @@ func search_handler(w http.ResponseWriter, r *http.Request){
 	} else {
 		paramsRows = 1000
 	}
-	// TODO: get a default sort in, once sorting is sorted out.
+	/* Results are left in index order unless a sort is given. */
 	if s, found := r.Form["sort"]; found {
 		if len(s) > 0 {
 			sortOrder = s[0]
-		} else {
-			sortOrder = "id ASC"
 		}
 	}
-	_ = sortOrder
 	if st, found := r.Form["start"]; found {
 		if len(st) > 0 {
 			start, _ = strconv.Atoi(st[0])
@@ -106,6 +106,17 @@ func search_handler(w http.ResponseWriter, r *http.Request){
 				JsonErrorReport(w, r, "Method not allowed", http.StatusMethodNotAllowed)
 				return
 		}
+	} else if path_array_len == 2 && path_array[1] == "_saved" && r.Method == "GET" {
+		if opUser.IsValidator() {
+			JsonErrorReport(w, r, "You are not allowed to perform this action", http.StatusForbidden)
+			return
+		}
+		for _, s := range saved_search.GetList() {
+			search_response[s] = util.CustomURL(fmt.Sprintf("/search/_saved/%s", s))
+		}
+	} else if path_array_len == 3 && path_array[1] == "_saved" {
+		saved_search_handler(w, r, path_array[2], opUser, sortOrder, start, paramsRows)
+		return
 	} else if path_array_len == 2 {
 		switch r.Method {
 			case "GET", "POST":
@@ -126,68 +137,9 @@ func search_handler(w http.ResponseWriter, r *http.Request){
 					}
 				}
 
-				idx := path_array[1]
-				rObjs, err := search.Search(idx, paramQuery)
-
-				if err != nil {
-					statusCode := http.StatusBadRequest
-					re := regexp.MustCompile(`^I don't know how to search for .*? data objects.`)
-					if re.MatchString(err.Error()) {
-						statusCode = http.StatusNotFound
-					}
-					JsonErrorReport(w, r, err.Error(), statusCode)
+				if !runSearch(w, r, path_array[1], paramQuery, partial_data, sortOrder, start, paramsRows, search_response) {
 					return
 				}
-
-				res := make([]map[string]interface{}, len(rObjs))
-				for i, r := range rObjs {
-					switch r := r.(type) {
-						case *client.Client:
-							jc := map[string]interface{}{
-								"name": r.Name,
-								"chef_type": r.ChefType,
-								"json_class": r.JsonClass,
-								"admin": r.Admin,
-								"public_key": r.PublicKey(),
-								"validator": r.Validator,
-							}
-							res[i] = jc
-						default:
-							res[i] = util.MapifyObject(r)
-					}
-				}
-
-				/* If we're doing partial search, tease out the
-				 * fields we want. */
-				if r.Method == "POST" {
-					res, err = partialSearchFormat(res, partial_data)
-					if err != nil {
-						JsonErrorReport(w, r, err.Error(), http.StatusBadRequest)
-						return
-					}
-					for x, z := range res {
-						tmpRes := make(map[string]interface{})
-						switch ro := rObjs[x].(type) {
-							case *data_bag.DataBagItem:
-								dbi_url := fmt.Sprintf("/data/%s/%s", ro.DataBagName, ro.RawData["id"].(string))
-								tmpRes["url"] = util.CustomURL(dbi_url)
-							default:
-								tmpRes["url"] = util.ObjURL(rObjs[x].(util.GoiardiObj))
-							}
-						tmpRes["data"] = z
-
-						res[x] = tmpRes
-					}
-				}
-				
-				end := start + paramsRows
-				if end > len(res) {
-					end = len(res)
-				}
-				res = res[start:end]
-				search_response["total"] = len(res)
-				search_response["start"] = start
-				search_response["rows"] = res
 			default:
 				JsonErrorReport(w, r, "Method not allowed", http.StatusMethodNotAllowed)
 				return
@@ -204,12 +156,128 @@ func search_handler(w http.ResponseWriter, r *http.Request){
 	}
 }
 
+/* Run a search and put the results in search_response, the same way for
+ * ordinary and saved searches. partial_data holds the keys to pull out of the
+ * results for a partial search, and is nil otherwise. Returns false if there's
+ * nothing more to send, because an error's been reported or the results have
+ * already been sent as NDJSON. */
+func runSearch(w http.ResponseWriter, r *http.Request, idx string, paramQuery string, partial_data map[string]interface{}, sortOrder string, start int, paramsRows int, search_response map[string]interface{}) bool {
+	/* While the index is being rebuilt, searches would only find some of
+	 * what they should. Unless told to go ahead anyway, tell the client to
+	 * come back later instead. */
+	if indexer.Rebuilding() {
+		if config.Config.SearchWhileReindexing != "serve" {
+			w.Header().Set("Retry-After", "5")
+			JsonErrorReport(w, r, "The search index is being rebuilt. Try again later.", http.StatusServiceUnavailable)
+			return false
+		}
+		w.Header().Set("X-Goiardi-Search-Incomplete", "true")
+	}
+
+	rObjs, err := search.Search(idx, paramQuery)
+
+	if err != nil {
+		statusCode := http.StatusBadRequest
+		re := regexp.MustCompile(`^I don't know how to search for .*? data objects.`)
+		if re.MatchString(err.Error()) {
+			statusCode = http.StatusNotFound
+		}
+		JsonErrorReport(w, r, err.Error(), statusCode)
+		return false
+	}
+
+	/* The total is how many results the query found, not how many are
+	 * on this page. A start past the end gives an empty page. Only the
+	 * results on the page get turned into maps and partial formatted,
+	 * unless they all need to be sorted first. */
+	total := len(rObjs)
+	first := start
+	if first < 0 {
+		first = 0
+	}
+	if first > total {
+		first = total
+	}
+	end := first + paramsRows
+	if paramsRows < 0 || end > total {
+		end = total
+	}
+
+	var res []map[string]interface{}
+	if sortOrder != "" {
+		res = mapifyResults(rObjs)
+		if serr := search.SortResults(res, rObjs, sortOrder); serr != nil {
+			JsonErrorReport(w, r, serr.Error(), http.StatusBadRequest)
+			return false
+		}
+		res = res[first:end]
+		rObjs = rObjs[first:end]
+	} else {
+		rObjs = rObjs[first:end]
+		res = mapifyResults(rObjs)
+	}
+
+	/* If we're doing partial search, tease out the fields we want. */
+	if partial_data != nil {
+		res, err = partialSearchFormat(res, partial_data)
+		if err != nil {
+			JsonErrorReport(w, r, err.Error(), http.StatusBadRequest)
+			return false
+		}
+		for x, z := range res {
+			tmpRes := make(map[string]interface{})
+			switch ro := rObjs[x].(type) {
+				case *data_bag.DataBagItem:
+					dbi_url := fmt.Sprintf("/data/%s/%s", ro.DataBagName, ro.RawData["id"].(string))
+					tmpRes["url"] = util.CustomURL(dbi_url)
+				default:
+					tmpRes["url"] = util.ObjURL(rObjs[x].(util.GoiardiObj))
+				}
+			tmpRes["data"] = z
+
+			res[x] = tmpRes
+		}
+	}
+
+	if wantsNDJSON(r) {
+		writeNDJSON(w, res)
+		return false
+	}
+	search_response["total"] = total
+	search_response["start"] = start
+	search_response["rows"] = res
+	return true
+}
+
+/* Turn search results into maps to send back. Clients are done by hand,
+ * since their public key isn't an exported field. */
+func mapifyResults(rObjs []indexer.Indexable) []map[string]interface{} {
+	res := make([]map[string]interface{}, len(rObjs))
+	for i, r := range rObjs {
+		switch r := r.(type) {
+			case *client.Client:
+				jc := map[string]interface{}{
+					"name": r.Name,
+					"chef_type": r.ChefType,
+					"json_class": r.JsonClass,
+					"admin": r.Admin,
+					"public_key": r.PublicKey(),
+					"validator": r.Validator,
+				}
+				res[i] = jc
+			default:
+				res[i] = util.MapifyObject(r)
+		}
+	}
+	return res
+}
+
 func reindexHandler(w http.ResponseWriter, r *http.Request){
 	w.Header().Set("Content-Type", "application/json")
 	reindex_response := make(map[string]interface{})
 	opUser, oerr := actor.GetReqUser(r.Header.Get("X-OPS-USERID"))
 	if oerr != nil {
-		JsonErrorReport(w, r, oerr.Error(), oerr.Status())
+		GerrorReport(w, r, oerr)
 		return
 	}
 	switch r.Method {
@@ -219,6 +287,8 @@ func reindexHandler(w http.ResponseWriter, r *http.Request){
 				return
 			}
 			reindexObjs := make([]indexer.Indexable, 0)
+			indexer.SetRebuilding(true)
+			defer indexer.SetRebuilding(false)
 			// We clear the index, *then* do the fetch because if
 			// something comes in between the time we fetch the
 			// objects to reindex and when it gets done, they'll
@@ -290,6 +360,11 @@ func partialSearchFormat(results []map[string]interface{}, partialFormat map[str
 				for i, j := range v {
 					psearchKeys[k][i] = j
 				}
+			case string:
+				/* A nested key can also be given as one
+				 * string, separated by periods, like
+				 * "kernel.machine". */
+				psearchKeys[k] = strings.Split(v, ".")
 			default:
 				err := fmt.Errorf("Partial search key %s badly formatted: %T %v", k, v, v)
 				return nil, err
@@ -356,14 +431,53 @@ func walk (v interface{}, keys []string) (interface{}) {
 				return nil
 			}
 		case map[string]string:
-			return v[keys[0]]
+			if s, found := v[keys[0]]; found && len(keys) == 1 {
+				return s
+			}
+			return nil
 		case map[string][]string:
-			return v[keys[0]]
+			if s, found := v[keys[0]]; found && len(keys) == 1 {
+				return s
+			}
+			return nil
 		default:
-			if len(keys) == 1 {
-				return v
-			} else {
-				return nil
+			/* There's nothing further down to walk into, so
+			 * the key isn't there. */
+			return nil
+	}
+}
+
+/* Does the client want search results as newline-delimited JSON, either with
+ * the format query parameter or the Accept header? */
+func wantsNDJSON(r *http.Request) bool {
+	if r.FormValue("format") == "ndjson" {
+		return true
+	}
+	for _, at := range r.Header["Accept"] {
+		for _, a := range strings.Split(at, ",") {
+			if strings.TrimSpace(strings.Split(a, ";")[0]) == "application/x-ndjson" {
+				return true
 			}
+		}
+	}
+	return false
+}
+
+/* Send search results back one JSON object per line, rather than wrapped up
+ * in one big object, flushing as we go so clients can start working on the
+ * results right away. Once the first row's been written there's no way to
+ * report an error to the client, so encoding errors are just logged. */
+func writeNDJSON(w http.ResponseWriter, res []map[string]interface{}) {
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	enc := json.NewEncoder(w)
+	flusher, canFlush := w.(http.Flusher)
+	for _, row := range res {
+		if err := enc.Encode(&row); err != nil {
+			logger.Errorf("Error encoding search result: %s", err.Error())
+			return
+		}
+		if canFlush {
+			flusher.Flush()
+		}
 	}
 }
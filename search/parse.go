@@ -61,10 +61,12 @@ type Token struct {
 	Latest Queryable
 }
 
-// An individual query term and its operator.
+// An individual query term and its operator. In a grouped query, op joins the
+// term to the one after it.
 type QueryTerm struct {
 	term Term
 	mod Op
+	op Op
 	fuzzboost Op
 	fuzzparam string
 }
@@ -79,10 +81,11 @@ type BasicQuery struct {
 	complete bool
 }
 
-// Query grouped results.
+// Query grouped results. A mod of NOT (or -) negates the whole group.
 type GroupedQuery struct {
 	field Field
 	terms []QueryTerm
+	mod Op
 	op Op
 	next Queryable
 	complete bool
@@ -94,16 +97,19 @@ type RangeQuery struct {
 	start RangeTerm
 	end RangeTerm
 	inclusive bool
+	mod Op
 	op Op
 	next Queryable
 	complete bool
 }
 
 // Subquery's really just a marker in the chain of queries. Later it will be 
-// processed by itself though.
+// processed by itself though. A mod of NOT on the start marker negates the
+// whole subquery.
 type SubQuery struct {
 	start bool
 	end bool
+	mod Op
 	op Op
 	complete bool
 	next Queryable
@@ -144,10 +150,7 @@ type GroupQueryHolder struct {
 }
 
 func (q *BasicQuery) SearchIndex(idxName string) (map[string]*indexer.IdxDoc, error) {
-	notop := false
-	if (q.term.mod == OpUnaryNot) || (q.term.mod == OpUnaryPro) {
-		notop = true
-	}
+	notop := negated(q.term.mod)
 	if q.field == "" {
 		res, err := indexer.SearchText(idxName, string(q.term.term), notop)
 		return res, err
@@ -203,7 +206,13 @@ func (q *BasicQuery) AddFuzzParam(s string){
 	q.term.fuzzparam = s
 }
 
+/* While the group's still being filled in, operators join its terms. After
+ * that, they join the group to the next query. */
 func (q *GroupedQuery) AddOp(o Op) {
+	if q.IsIncomplete() && len(q.terms) > 0 {
+		q.terms[len(q.terms) - 1].op = o
+		return
+	}
 	q.op = o
 }
 
@@ -308,52 +317,55 @@ func (q *RangeQuery) AddFuzzParam(s string) {
 }
 
 func (q *GroupedQuery) SearchIndex(idxName string) (map[string]*indexer.IdxDoc, error) {
-	tmpRes := make([]GroupQueryHolder, len(q.terms))
+	results := make([]map[string]*indexer.IdxDoc, len(q.terms))
+	mods := make([]Op, len(q.terms))
+	ops := make([]Op, len(q.terms))
+	reqOp := false
 	for i, v := range q.terms {
-		tmpRes[i].op = v.mod
-		notop := false
-		if v.mod == OpUnaryNot || v.mod == OpUnaryPro {
-			notop = true
-		}
 		searchTerm := fmt.Sprintf("%s:%s", q.field, v.term)
-		r, err := indexer.SearchIndex(idxName, searchTerm, notop)
+		r, err := indexer.SearchIndex(idxName, searchTerm, negated(v.mod))
 		if err != nil {
 			return nil, err
 		}
-		tmpRes[i].res = r
+		results[i] = r
+		mods[i] = v.mod
+		ops[i] = v.op
+		if v.mod == OpUnaryReq {
+			reqOp = true
+		}
 	}
-	reqOp := false
-	res := make(map[string]*indexer.IdxDoc)
-	var req map[string]*indexer.IdxDoc
 
-	// Merge the results, taking into account any + operators lurking about
-	for _, t := range tmpRes {
-		if t.op == OpUnaryReq {
-			reqOp = true
-			if req == nil {
-				req = t.res
-			} else {
-				for k := range req {
-					if _, found := t.res[k]; !found {
-						delete(req, k)
-					}
-				}
-			}
-		} else if !reqOp{
-			for k, v := range t.res {
-				res[k] = v
+	/* If any terms are required with +, only they (and any prohibited
+	 * terms) count, and they all have to match. */
+	if reqOp {
+		req_results := make([]map[string]*indexer.IdxDoc, 0, len(results))
+		req_mods := make([]Op, 0, len(results))
+		for i, r := range results {
+			if mods[i] == OpUnaryReq || mods[i] == OpUnaryPro {
+				req_results = append(req_results, r)
+				req_mods = append(req_mods, mods[i])
 			}
 		}
+		results = req_results
+		mods = req_mods
+		ops = make([]Op, len(results))
+		for i := range ops {
+			ops[i] = OpBinAnd
+		}
 	}
-	if reqOp {
-		req = res
+	res := combineResults(results, mods, ops)
+	if negated(q.mod) {
+		return negateResults(idxName, res)
 	}
 	return res, nil
 }
 
 func (q *RangeQuery) SearchIndex(idxName string) (map[string]*indexer.IdxDoc, error) {
 	res, err := indexer.SearchRange(idxName, string(q.field), string(q.start), string(q.end), q.inclusive)
-	return res, err
+	if err != nil || !negated(q.mod) {
+		return res, err
+	}
+	return negateResults(idxName, res)
 }
 
 func (q *SubQuery) SearchIndex(idxName string) (map[string]*indexer.IdxDoc, error) { 
@@ -444,8 +456,34 @@ func (z *Token) StartBasic() {
 	}
 }
 
+/* NOT, !, and - are parsed before whatever they apply to, so they start a basic
+ * query to hold the operator. If it turns out they apply to a group, range,
+ * or subquery instead, that placeholder is taken back out of the chain and its
+ * operator returned, so it can be given to the right query. */
+func (z *Token) takePendingMod() Op {
+	bq, ok := z.Latest.(*BasicQuery)
+	if !ok || !bq.IsIncomplete() || bq.field != "" || bq.term.term != "" || bq.term.mod == OpNotAnOp {
+		return OpNotAnOp
+	}
+	mod := bq.term.mod
+	if z.QueryChain == z.Latest {
+		z.QueryChain = nil
+		z.Latest = nil
+		return mod
+	}
+	for q := z.QueryChain; q != nil; q = q.Next() {
+		if q.Next() == z.Latest {
+			q.SetNext(nil)
+			z.Latest = q
+			break
+		}
+	}
+	return mod
+}
+
 func (z *Token) StartRange(inclusive bool) {
 	rn := new(RangeQuery)
+	rn.mod = z.takePendingMod()
 	rn.op = OpBinOr
 	rn.inclusive = inclusive
 	if z.QueryChain == nil {
@@ -458,8 +496,10 @@ func (z *Token) StartRange(inclusive bool) {
 }
 
 func (z *Token) StartGrouped() {
+	mod := z.takePendingMod()
 	if z.Latest == nil || (z.Latest != nil && !z.Latest.IsIncomplete()) {
 		gn := new(GroupedQuery)
+		gn.mod = mod
 		gn.op = OpBinOr
 		gn.terms = make([]QueryTerm, 0)
 		if z.QueryChain == nil {
@@ -478,11 +518,17 @@ func (z *Token) SetCompleted() {
 
 
 func (z *Token) StartSubQuery(){
+	mod := z.takePendingMod()
 	// we don't want to start a subquery if we're in a field group query
 	if z.Latest == nil || (z.Latest != nil && !z.Latest.IsIncomplete()){
 		sq := new(SubQuery)
 		sq.start = true
+		sq.mod = mod
+		sq.op = OpBinOr
 		sq.complete = true
+		if z.QueryChain == nil {
+			z.QueryChain = sq
+		}
 		if z.Latest != nil {
 			z.Latest.SetNext(sq)
 		}
@@ -495,7 +541,11 @@ func (z *Token) EndSubQuery(){
 	if z.Latest == nil || (z.Latest != nil && !z.Latest.IsIncomplete()){
 		sq := new(SubQuery)
 		sq.end = true
+		sq.op = OpBinOr
 		sq.complete = true
+		if z.QueryChain == nil {
+			z.QueryChain = sq
+		}
 		if z.Latest != nil {
 			z.Latest.SetNext(sq)
 		}
@@ -36,9 +36,22 @@ type SolrQuery struct {
 	docs map[string]*indexer.IdxDoc
 }
 
+/* The indexes that can always be searched, even if nothing has been indexed in
+ * them yet. Data bags are checked for separately. */
+var builtinIndexes = []string{ "node", "role", "environment", "client" }
+
 // Parse the given query string and search the given index for any matching
-// results.
+// results. Searching an index that isn't one of the built in indexes or an
+// existing data bag is an error, but searching a valid index that just doesn't
+// have anything in it yet returns no results.
 func Search(idx string, q string) ([]indexer.Indexable, error) {
+	if !ValidIndex(idx) {
+		err := fmt.Errorf("I don't know how to search for %s data objects.", idx)
+		return nil, err
+	}
+	if !hasCollection(idx) {
+		return make([]indexer.Indexable, 0), nil
+	}
 	/* Eventually we'll want more prep. To start, look right in the index */
 	query, qerr := url.QueryUnescape(q)
 	if qerr != nil {
@@ -65,49 +78,112 @@ func Search(idx string, q string) ([]indexer.Indexable, error) {
 
 func (sq *SolrQuery) execute() (map[string]*indexer.IdxDoc, error) {
 	s := sq.queryChain
-	curOp := OpNotAnOp
+	results := make([]map[string]*indexer.IdxDoc, 0)
+	mods := make([]Op, 0)
+	ops := make([]Op, 0)
 	for s != nil {
 		var r map[string]*indexer.IdxDoc
 		var err error
+		mod := OpNotAnOp
 		switch c := s.(type){
 			case *SubQuery:
-				_ = c
 				newq, nend, nerr := extractSubQuery(s)
 				if nerr != nil {
-					return nil, err
+					return nil, nerr
 				}
 				s = nend
 				d := make(map[string]*indexer.IdxDoc)
 				nsq := &SolrQuery{ queryChain: newq, idxName: sq.idxName, docs: d }
 				r, err = nsq.execute()
+				if err == nil && negated(c.mod) {
+					r, err = negateResults(sq.idxName, r)
+				}
+				mod = c.mod
+			case *BasicQuery:
+				r, err = s.SearchIndex(sq.idxName)
+				mod = c.term.mod
+			case *GroupedQuery:
+				r, err = s.SearchIndex(sq.idxName)
+				mod = c.mod
+			case *RangeQuery:
+				r, err = s.SearchIndex(sq.idxName)
+				mod = c.mod
 			default:
 				r, err = s.SearchIndex(sq.idxName)
 		}
 		if err != nil {
 			return nil, err
 		}
-		if len(sq.docs) == 0 { // nothing in place yet
-			sq.docs = r
-		} else if curOp == OpBinOr {
-			for k, v := range r {
-				sq.docs[k] = v
-			}
-		} else if curOp == OpBinAnd {
-			newRes := make(map[string]*indexer.IdxDoc, len(sq.docs) + len(r))
-			for k, v := range sq.docs {
-				if _, found := r[k]; found {
-					newRes[k] = v
-				}
-			}
-			sq.docs = newRes
+		results = append(results, r)
+		mods = append(mods, mod)
+		ops = append(ops, s.Op())
+		s = s.Next()
+	}
+	sq.docs = combineResults(results, mods, ops)
+	return sq.docs, nil
+}
+
+/* Combine the results of a chain of queries, where ops[i] is the operator
+ * joining results[i] to results[i + 1]. Like Solr, AND binds more tightly than
+ * OR, so runs of results joined by AND are intersected first and then the runs
+ * are merged together. Results for prohibited (-) queries have already been
+ * negated, and are always ANDed in since nothing matching them may be
+ * returned. The result maps may belong to the index, so they're never
+ * modified. */
+func combineResults(results []map[string]*indexer.IdxDoc, mods []Op, ops []Op) map[string]*indexer.IdxDoc {
+	combined := make(map[string]*indexer.IdxDoc)
+	var run map[string]*indexer.IdxDoc
+	for i, r := range results {
+		if i == 0 {
+			run = r
+			continue
+		}
+		op := ops[i - 1]
+		if mods[i] == OpUnaryPro {
+			op = OpBinAnd
+		}
+		if op == OpBinAnd {
+			run = intersectResults(run, r)
 		} else {
-			logger.Debugf("Somehow we got to what should have been an impossible state with search")
+			for k, v := range run {
+				combined[k] = v
+			}
+			run = r
 		}
+	}
+	for k, v := range run {
+		combined[k] = v
+	}
+	return combined
+}
 
-		curOp = s.Op()
-		s = s.Next()
+func intersectResults(a map[string]*indexer.IdxDoc, b map[string]*indexer.IdxDoc) map[string]*indexer.IdxDoc {
+	res := make(map[string]*indexer.IdxDoc)
+	for k, v := range a {
+		if _, found := b[k]; found {
+			res[k] = v
+		}
 	}
-	return sq.docs, nil
+	return res
+}
+
+/* Everything in the index that isn't in the given results. */
+func negateResults(idxName string, res map[string]*indexer.IdxDoc) (map[string]*indexer.IdxDoc, error) {
+	all, err := indexer.SearchIndex(idxName, "*:*", false)
+	if err != nil {
+		return nil, err
+	}
+	negated := make(map[string]*indexer.IdxDoc)
+	for k, v := range all {
+		if _, found := res[k]; !found {
+			negated[k] = v
+		}
+	}
+	return negated, nil
+}
+
+func negated(mod Op) bool {
+	return mod == OpUnaryNot || mod == OpUnaryPro
 }
 
 func extractSubQuery(s Queryable) (Queryable, Queryable, error) {
@@ -155,6 +231,30 @@ func GetEndpoints() []string {
 	return endpoints
 }
 
+// Is the given index something that can be searched? Valid indexes are the
+// built in node, role, environment, and client indexes, plus the names of any
+// existing data bags.
+func ValidIndex(idx string) bool {
+	for _, b := range builtinIndexes {
+		if idx == b {
+			return true
+		}
+	}
+	if dbag, _ := data_bag.Get(idx); dbag != nil {
+		return true
+	}
+	return false
+}
+
+func hasCollection(idx string) bool {
+	for _, e := range indexer.Endpoints() {
+		if e == idx {
+			return true
+		}
+	}
+	return false
+}
+
 func getResults(variety string, toGet []string) []indexer.Indexable {
 	results := make([]indexer.Indexable, 0)
 	switch variety {
@@ -23,7 +23,12 @@ import (
 	"github.com/ctdk/goiardi/environment"
 	"github.com/ctdk/goiardi/client"
 	"github.com/ctdk/goiardi/data_bag"
+	"github.com/ctdk/goiardi/indexer"
+	"github.com/ctdk/goiardi/util"
 	"fmt"
+	"sort"
+	"strings"
+	"time"
 )
 
 // Most search testing can be handled fine with chef-pedant, but that's no
@@ -179,3 +184,223 @@ func TestSearchDbagAll(t *testing.T){
 		t.Errorf("Incorrect number of items returned, expected 1, got %d", len(d))
 	}
 }
+
+func TestSearchUnknownIndex(t *testing.T){
+	_, err := Search("not_an_index", "*:*")
+	if err == nil {
+		t.Errorf("Searching an unknown index should have failed, but didn't")
+	}
+}
+
+func TestSearchEmptyIndex(t *testing.T){
+	/* A data bag that has never had any items indexed should give back
+	 * empty results, not an error. */
+	dbag, _ := data_bag.New("empty_search_bag")
+	dbag.Save()
+	indexer.DeleteCollection("empty_search_bag")
+	d, err := Search("empty_search_bag", "*:*")
+	if err != nil {
+		t.Errorf("Searching an empty index failed: %s", err.Error())
+	}
+	if len(d) != 0 {
+		t.Errorf("Searching an empty index should have returned no results, but returned %d", len(d))
+	}
+}
+
+func TestSortResults(t *testing.T){
+	res := []map[string]interface{}{
+		{ "name": "bravo", "automatic": map[string]interface{}{ "cpus": 8 } },
+		{ "name": "alpha", "automatic": map[string]interface{}{ "cpus": 16 } },
+		{ "name": "charlie", "automatic": map[string]interface{}{ "cpus": 2 } },
+		{ "name": "delta" },
+	}
+	chkOrder := func(sortOrder string, expected string) {
+		if err := SortResults(res, nil, sortOrder); err != nil {
+			t.Fatalf("Sorting by '%s' failed: %s", sortOrder, err.Error())
+		}
+		got := make([]string, len(res))
+		for i, r := range res {
+			got[i] = fmt.Sprintf("%v", r["name"])
+		}
+		if g := fmt.Sprintf("%v", got); g != expected {
+			t.Errorf("Sorting by '%s' gave %s, expected %s", sortOrder, g, expected)
+		}
+	}
+	chkOrder("name:asc", "[alpha bravo charlie delta]")
+	chkOrder("name desc", "[delta charlie bravo alpha]")
+	/* numeric, not lexical, and missing values go last */
+	chkOrder("automatic.cpus:asc", "[charlie bravo alpha delta]")
+	chkOrder("automatic.cpus:DESC", "[alpha bravo charlie delta]")
+
+	if err := SortResults(res, nil, "name sideways"); err == nil {
+		t.Errorf("Sorting with an invalid direction should have failed, but didn't")
+	}
+}
+
+func TestSortNodesByAttribute(t *testing.T){
+	weights := map[string]interface{}{ "sortnode1": 9, "sortnode2": 100, "sortnode3": 10, "sortnode4": nil, "sortnode5": 10 }
+	objs := make([]indexer.Indexable, 0, len(weights))
+	for name, w := range weights {
+		n, _ := node.New(name)
+		n.Normal["sorttest"] = "yes"
+		if w != nil {
+			n.Normal["weight"] = w
+		}
+		n.Save()
+		objs = append(objs, n)
+	}
+	indexer.ReIndex(objs)
+
+	chkSort := func(sortOrder string, expected string) {
+		found, err := Search("node", "sorttest:yes")
+		if err != nil {
+			t.Fatalf("Searching for sorttest:yes failed: %s", err.Error())
+		}
+		res := make([]map[string]interface{}, len(found))
+		for i, f := range found {
+			res[i] = util.MapifyObject(f)
+		}
+		if err := SortResults(res, found, sortOrder); err != nil {
+			t.Fatalf("Sorting by '%s' failed: %s", sortOrder, err.Error())
+		}
+		names := make([]string, len(found))
+		for i, f := range found {
+			names[i] = f.(*node.Node).Name
+			if res[i]["name"] != names[i] {
+				t.Errorf("Sorting by '%s' put result %v with object %s", sortOrder, res[i]["name"], names[i])
+			}
+		}
+		if got := strings.Join(names, ","); got != expected {
+			t.Errorf("Sorting by '%s' gave %s, expected %s", sortOrder, got, expected)
+		}
+	}
+	/* Numbers sort numerically, ties are broken by name, and nodes
+	 * without the attribute come last. */
+	chkSort("weight asc", "sortnode1,sortnode3,sortnode5,sortnode2,sortnode4")
+	chkSort("weight desc", "sortnode2,sortnode3,sortnode5,sortnode1,sortnode4")
+	chkSort("normal.weight:asc", "sortnode1,sortnode3,sortnode5,sortnode2,sortnode4")
+}
+
+func TestRangeSearch(t *testing.T){
+	uptimes := map[string]int{ "rangenode1": 500, "rangenode2": 3600, "rangenode3": 40000, "rangenode4": 86400, "rangenode5": 100000 }
+	objs := make([]indexer.Indexable, 0, len(uptimes))
+	for name, u := range uptimes {
+		n, _ := node.New(name)
+		n.Normal["rangetest"] = "yes"
+		n.Normal["uptime_seconds"] = u
+		n.Save()
+		objs = append(objs, n)
+	}
+	indexer.ReIndex(objs)
+
+	chkSearch := func(query string, expected string) {
+		res, err := Search("node", query)
+		if err != nil {
+			t.Errorf("Searching for '%s' failed: %s", query, err.Error())
+			return
+		}
+		names := make([]string, 0)
+		for _, r := range res {
+			if name := r.(*node.Node).Name; strings.HasPrefix(name, "rangenode") {
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names)
+		if got := strings.Join(names, ","); got != expected {
+			t.Errorf("Searching for '%s' found '%s', expected '%s'", query, got, expected)
+		}
+	}
+	/* numeric ranges compare as numbers, not strings */
+	chkSearch("uptime_seconds:[3600 TO 86400]", "rangenode2,rangenode3,rangenode4")
+	chkSearch("uptime_seconds:{3600 TO 86400}", "rangenode3")
+	chkSearch("uptime_seconds:[* TO 3600]", "rangenode1,rangenode2")
+	chkSearch("uptime_seconds:{40000 TO *}", "rangenode4,rangenode5")
+	chkSearch("uptime_seconds:[* TO *]", "rangenode1,rangenode2,rangenode3,rangenode4,rangenode5")
+	chkSearch("rangetest:yes AND uptime_seconds:[1000 TO 50000]", "rangenode2,rangenode3")
+	/* strings compare lexically */
+	chkSearch("name:[rangenode2 TO rangenode4]", "rangenode2,rangenode3,rangenode4")
+	chkSearch("name:{rangenode2 TO rangenode4}", "rangenode3")
+	chkSearch("name:[rangenode4 TO *]", "rangenode4,rangenode5")
+}
+
+func TestBooleanSearch(t *testing.T){
+	tiers := map[string]string{ "boolnode1": "web", "boolnode2": "api", "boolnode3": "web", "boolnode4": "db" }
+	objs := make([]indexer.Indexable, 0, len(tiers))
+	for name, tier := range tiers {
+		n, _ := node.New(name)
+		n.Normal["tier"] = tier
+		if name == "boolnode3" {
+			n.Normal["tags"] = []interface{}{ "decommissioned" }
+		}
+		n.Save()
+		objs = append(objs, n)
+	}
+	/* Node saves index in the background; index them right away too. */
+	indexer.ReIndex(objs)
+
+	chkSearch := func(query string, expected string) {
+		res, err := Search("node", query)
+		if err != nil {
+			t.Errorf("Searching for '%s' failed: %s", query, err.Error())
+			return
+		}
+		names := make([]string, 0)
+		for _, r := range res {
+			if name := r.(*node.Node).Name; strings.HasPrefix(name, "boolnode") {
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names)
+		if got := strings.Join(names, ","); got != expected {
+			t.Errorf("Searching for '%s' found '%s', expected '%s'", query, got, expected)
+		}
+	}
+	chkSearch("(tier:web OR tier:api) AND NOT tags:decommissioned", "boolnode1,boolnode2")
+	/* AND binds more tightly than OR */
+	chkSearch("tier:db OR tier:web AND tags:decommissioned", "boolnode3,boolnode4")
+	chkSearch("tier:web AND tags:decommissioned OR tier:db", "boolnode3,boolnode4")
+	chkSearch("NOT (tier:web OR tier:db)", "boolnode2")
+	chkSearch("!(tier:web OR tier:db)", "boolnode2")
+	chkSearch("tier:web AND (tags:decommissioned OR (tier:api OR tier:db))", "boolnode3")
+	chkSearch("(tier:api OR (tier:web AND NOT tags:decommissioned)) AND NOT tier:db", "boolnode1,boolnode2")
+	chkSearch("NOT tier:(web OR db)", "boolnode2")
+	chkSearch("tier:web -tags:decommissioned", "boolnode1")
+	/* An AND whose left side matches nothing matches nothing. */
+	chkSearch("tier:nothing AND tier:web", "")
+}
+
+func TestNodeCreatedAt(t *testing.T){
+	n, _ := node.New("creatednode")
+	created := n.CreatedAt
+	if created.IsZero() {
+		t.Fatalf("New node had no creation time")
+	}
+	n.Save()
+
+	/* Updating the node, even with a different created_at in the JSON,
+	 * must leave the creation time alone. */
+	time.Sleep(time.Millisecond)
+	json_node := map[string]interface{}{
+		"name": "creatednode",
+		"normal": map[string]interface{}{ "updated": "yes" },
+		"created_at": "2001-01-01T00:00:00Z",
+	}
+	if err := n.UpdateFromJson(json_node); err != nil {
+		t.Fatalf("Updating node failed: %s", err.Error())
+	}
+	n.Save()
+	n2, _ := node.Get("creatednode")
+	if !n2.CreatedAt.Equal(created) {
+		t.Errorf("Node creation time changed from %s to %s on update", created, n2.CreatedAt)
+	}
+
+	indexer.ReIndex([]indexer.Indexable{ n2 })
+	since := created.Add(-24 * time.Hour).UTC().Format("20060102T150405Z")
+	res, err := Search("node", fmt.Sprintf("name:creatednode AND created_at:[%s TO *]", since))
+	if err != nil {
+		t.Fatalf("Searching by creation time failed: %s", err.Error())
+	}
+	if len(res) != 1 {
+		t.Errorf("Expected to find the node created in the last day, found %d nodes", len(res))
+	}
+}
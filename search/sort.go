@@ -0,0 +1,176 @@
+/*
+ * Copyright (c) 2013-2014, Jeremy Bingham (<jbingham@gmail.com>)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package search
+
+import (
+	"github.com/ctdk/goiardi/indexer"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+/* Sorts search results, and the objects they came from, together. */
+type resultSorter struct {
+	res []map[string]interface{}
+	objs []indexer.Indexable
+	vals []interface{}
+	ids []string
+	desc bool
+}
+
+// Sort search results by the value of a field. sortOrder is given like Solr's
+// sort parameter, "field asc" or "field desc", or as "field:asc". Nested
+// fields are separated by periods, like "automatic.platform_version". A field
+// that isn't at the top level is looked for in node, role, and environment
+// attributes, highest precedence first, and in data bag items' data, so
+// "sort=uptime asc" works the way it would in a search query. Values are
+// compared numerically if they're both numbers, and as strings otherwise.
+// Results without the field always come last. If objs is not nil, it's sorted
+// along with res, and results with the same value (or no value) are ordered by
+// their objects' ids so the order doesn't depend on the index.
+func SortResults(res []map[string]interface{}, objs []indexer.Indexable, sortOrder string) error {
+	re := regexp.MustCompile(`^\s*([^\s:]+)(?:\s+|:)(?i:(asc|desc))\s*$`)
+	m := re.FindStringSubmatch(sortOrder)
+	if m == nil {
+		err := fmt.Errorf("Invalid sort order '%s'", sortOrder)
+		return err
+	}
+	field := strings.Split(m[1], ".")
+	rs := &resultSorter{ res: res, objs: objs, desc: strings.ToLower(m[2]) == "desc" }
+	rs.vals = make([]interface{}, len(res))
+	rs.ids = make([]string, len(res))
+	for i, r := range res {
+		rs.vals[i] = fieldValue(r, field)
+		if objs != nil {
+			rs.ids[i] = objs[i].DocId()
+		}
+	}
+	sort.Stable(rs)
+	return nil
+}
+
+/* Where to look for a field that isn't at the top level of a result, highest
+ * attribute precedence first. */
+var attrLevels = []string{ "automatic", "override", "override_attributes", "normal", "default", "default_attributes", "raw_data" }
+
+func fieldValue(r map[string]interface{}, field []string) interface{} {
+	if v := walkField(r, field); v != nil {
+		return v
+	}
+	for _, l := range attrLevels {
+		if v := walkField(r[l], field); v != nil {
+			return v
+		}
+	}
+	return nil
+}
+
+func walkField(r interface{}, field []string) interface{} {
+	cur := r
+	for _, f := range field {
+		m, ok := cur.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		if cur, ok = m[f]; !ok {
+			return nil
+		}
+	}
+	return cur
+}
+
+func (rs *resultSorter) Len() int {
+	return len(rs.res)
+}
+
+func (rs *resultSorter) Swap(i, j int) {
+	rs.res[i], rs.res[j] = rs.res[j], rs.res[i]
+	rs.vals[i], rs.vals[j] = rs.vals[j], rs.vals[i]
+	rs.ids[i], rs.ids[j] = rs.ids[j], rs.ids[i]
+	if rs.objs != nil {
+		rs.objs[i], rs.objs[j] = rs.objs[j], rs.objs[i]
+	}
+}
+
+func (rs *resultSorter) Less(i, j int) bool {
+	if c := compareValues(rs.vals[i], rs.vals[j]); c != 0 {
+		/* missing values go last either way */
+		if rs.desc && rs.vals[i] != nil && rs.vals[j] != nil {
+			return c > 0
+		}
+		return c < 0
+	}
+	return rs.ids[i] < rs.ids[j]
+}
+
+/* Compare two field values, returning -1, 0, or 1. Missing values come after
+ * everything else. */
+func compareValues(a, b interface{}) int {
+	if a == nil || b == nil {
+		switch {
+			case a == nil && b == nil:
+				return 0
+			case a == nil:
+				return 1
+			default:
+				return -1
+		}
+	}
+	fa, aok := numericValue(a)
+	fb, bok := numericValue(b)
+	if aok && bok {
+		switch {
+			case fa < fb:
+				return -1
+			case fa > fb:
+				return 1
+		}
+		return 0
+	}
+	sa, sb := fmt.Sprintf("%v", a), fmt.Sprintf("%v", b)
+	switch {
+		case sa < sb:
+			return -1
+		case sa > sb:
+			return 1
+	}
+	return 0
+}
+
+func numericValue(v interface{}) (float64, bool) {
+	switch v := v.(type) {
+		case int:
+			return float64(v), true
+		case int32:
+			return float64(v), true
+		case int64:
+			return float64(v), true
+		case float32:
+			return float64(v), true
+		case float64:
+			return v, true
+		case string:
+			f, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				return 0, false
+			}
+			return f, true
+	}
+	return 0, false
+}
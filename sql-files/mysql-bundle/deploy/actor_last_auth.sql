@@ -0,0 +1,8 @@
+-- Deploy actor_last_auth
+
+BEGIN;
+
+ALTER TABLE clients ADD COLUMN last_auth datetime NULL DEFAULT NULL;
+ALTER TABLE users ADD COLUMN last_auth datetime NULL DEFAULT NULL;
+
+COMMIT;
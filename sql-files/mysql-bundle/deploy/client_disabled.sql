@@ -0,0 +1,7 @@
+-- Deploy client_disabled
+
+BEGIN;
+
+ALTER TABLE clients ADD COLUMN disabled tinyint(4) NOT NULL default 0;
+
+COMMIT;
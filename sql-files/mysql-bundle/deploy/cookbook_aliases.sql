@@ -0,0 +1,7 @@
+-- Deploy cookbook_aliases
+
+BEGIN;
+
+ALTER TABLE cookbooks ADD COLUMN aliases blob;
+
+COMMIT;
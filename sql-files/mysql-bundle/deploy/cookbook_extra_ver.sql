@@ -0,0 +1,11 @@
+-- Deploy cookbook_extra_ver
+
+BEGIN;
+
+-- Any numbers after the third in a cookbook version, like the '3' in 2.1.0.3,
+-- joined with '.'. They're part of what makes a version unique.
+ALTER TABLE cookbook_versions ADD COLUMN extra_ver varchar(100) not null default '' AFTER patch_ver,
+	ADD UNIQUE KEY cookbook_full_version (cookbook_id, major_ver, minor_ver, patch_ver, extra_ver, prerelease_ver, build_ver),
+	DROP INDEX cookbook_version;
+
+COMMIT;
@@ -0,0 +1,12 @@
+-- Deploy cookbook_prerelease
+
+BEGIN;
+
+-- SemVer pre-release and build parts of cookbook versions, without the
+-- leading '-' or '+'. They're part of what makes a version unique.
+ALTER TABLE cookbook_versions ADD COLUMN prerelease_ver varchar(100) not null default '',
+	ADD COLUMN build_ver varchar(100) not null default '',
+	ADD UNIQUE KEY cookbook_version (cookbook_id, major_ver, minor_ver, patch_ver, prerelease_ver, build_ver),
+	DROP INDEX cookbook_id;
+
+COMMIT;
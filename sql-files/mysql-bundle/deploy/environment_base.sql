@@ -0,0 +1,7 @@
+-- Deploy environment_base
+
+BEGIN;
+
+ALTER TABLE environments ADD COLUMN base_environment varchar(255) NOT NULL default '';
+
+COMMIT;
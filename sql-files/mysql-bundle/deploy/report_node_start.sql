@@ -0,0 +1,7 @@
+-- Deploy report_node_start
+
+BEGIN;
+
+CREATE INDEX node_start ON reports (node_name, start_time);
+
+COMMIT;
@@ -0,0 +1,8 @@
+-- Revert actor_last_auth
+
+BEGIN;
+
+ALTER TABLE clients DROP COLUMN last_auth;
+ALTER TABLE users DROP COLUMN last_auth;
+
+COMMIT;
@@ -0,0 +1,7 @@
+-- Revert client_disabled
+
+BEGIN;
+
+ALTER TABLE clients DROP COLUMN disabled;
+
+COMMIT;
@@ -0,0 +1,7 @@
+-- Revert cookbook_aliases
+
+BEGIN;
+
+ALTER TABLE cookbooks DROP COLUMN aliases;
+
+COMMIT;
@@ -0,0 +1,11 @@
+-- Revert cookbook_extra_ver
+
+BEGIN;
+
+-- This will fail if there are any versions that only differ in the numbers
+-- after the third; delete those first.
+ALTER TABLE cookbook_versions ADD UNIQUE KEY cookbook_version (cookbook_id, major_ver, minor_ver, patch_ver, prerelease_ver, build_ver),
+	DROP INDEX cookbook_full_version,
+	DROP COLUMN extra_ver;
+
+COMMIT;
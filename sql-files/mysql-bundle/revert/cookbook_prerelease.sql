@@ -0,0 +1,12 @@
+-- Revert cookbook_prerelease
+
+BEGIN;
+
+-- This will fail if there are any versions that only differ in their
+-- pre-release or build parts; delete those first.
+ALTER TABLE cookbook_versions ADD UNIQUE KEY cookbook_id (cookbook_id, major_ver, minor_ver, patch_ver),
+	DROP INDEX cookbook_version,
+	DROP COLUMN prerelease_ver,
+	DROP COLUMN build_ver;
+
+COMMIT;
@@ -0,0 +1,7 @@
+-- Revert environment_base
+
+BEGIN;
+
+ALTER TABLE environments DROP COLUMN base_environment;
+
+COMMIT;
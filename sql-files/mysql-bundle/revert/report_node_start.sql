@@ -0,0 +1,7 @@
+-- Revert report_node_start
+
+BEGIN;
+
+DROP INDEX node_start ON reports;
+
+COMMIT;
@@ -0,0 +1,7 @@
+-- Revert saved_searches
+
+BEGIN;
+
+DROP TABLE saved_searches;
+
+COMMIT;
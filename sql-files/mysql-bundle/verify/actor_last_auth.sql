@@ -0,0 +1,8 @@
+-- Verify actor_last_auth
+
+BEGIN;
+
+SELECT last_auth FROM clients WHERE 0;
+SELECT last_auth FROM users WHERE 0;
+
+ROLLBACK;
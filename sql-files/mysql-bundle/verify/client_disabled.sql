@@ -0,0 +1,7 @@
+-- Verify client_disabled
+
+BEGIN;
+
+SELECT disabled FROM clients WHERE 0;
+
+ROLLBACK;
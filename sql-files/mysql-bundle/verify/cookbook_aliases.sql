@@ -0,0 +1,7 @@
+-- Verify cookbook_aliases
+
+BEGIN;
+
+SELECT aliases FROM cookbooks WHERE 0;
+
+ROLLBACK;
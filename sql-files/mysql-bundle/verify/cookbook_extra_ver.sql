@@ -0,0 +1,7 @@
+-- Verify cookbook_extra_ver
+
+BEGIN;
+
+SELECT extra_ver FROM cookbook_versions WHERE 0;
+
+ROLLBACK;
@@ -0,0 +1,7 @@
+-- Verify cookbook_prerelease
+
+BEGIN;
+
+SELECT prerelease_ver, build_ver FROM cookbook_versions WHERE 0;
+
+ROLLBACK;
@@ -0,0 +1,7 @@
+-- Verify environment_base
+
+BEGIN;
+
+SELECT base_environment FROM environments WHERE 0;
+
+ROLLBACK;
@@ -0,0 +1,7 @@
+-- Verify report_node_start
+
+BEGIN;
+
+SELECT run_id FROM reports FORCE INDEX (node_start) WHERE 0;
+
+ROLLBACK;
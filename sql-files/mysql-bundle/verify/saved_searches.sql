@@ -0,0 +1,7 @@
+-- Verify saved_searches
+
+BEGIN;
+
+SELECT id, name, index_name, query, partial, created_at, updated_at FROM saved_searches WHERE 0;
+
+ROLLBACK;
@@ -0,0 +1,21 @@
+-- Deploy clients
+
+BEGIN;
+
+CREATE TABLE clients (
+	id serial primary key,
+	name varchar(2048) not null,
+	nodename varchar(2048),
+	validator boolean default FALSE,
+	admin boolean default FALSE,
+	organization_id int not null default 1,
+	public_key text,
+	certificate text,
+	disabled boolean default FALSE,
+	last_auth timestamp with time zone NULL DEFAULT NULL,
+	created_at timestamp with time zone not null,
+	updated_at timestamp with time zone not null,
+	unique (organization_id, name)
+);
+
+COMMIT;
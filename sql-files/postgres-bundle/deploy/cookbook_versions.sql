@@ -0,0 +1,32 @@
+-- Deploy cookbook_versions
+
+BEGIN;
+
+CREATE TABLE cookbook_versions (
+	id serial primary key,
+	cookbook_id int not null REFERENCES cookbooks(id) ON DELETE RESTRICT,
+	major_ver bigint not null,
+	minor_ver bigint not null,
+	patch_ver bigint not null default 0,
+	extra_ver varchar(100) not null default '',
+	prerelease_ver varchar(100) not null default '',
+	build_ver varchar(100) not null default '',
+	frozen boolean default FALSE,
+	metadata bytea,
+	definitions bytea,
+	libraries bytea,
+	attributes bytea,
+	recipes bytea,
+	providers bytea,
+	resources bytea,
+	templates bytea,
+	root_files bytea,
+	files bytea,
+	created_at timestamp with time zone not null,
+	updated_at timestamp with time zone not null,
+	unique (cookbook_id, major_ver, minor_ver, patch_ver, extra_ver, prerelease_ver, build_ver)
+);
+
+CREATE INDEX cookbook_versions_frozen ON cookbook_versions (frozen);
+
+COMMIT;
@@ -0,0 +1,13 @@
+-- Deploy cookbooks
+
+BEGIN;
+
+CREATE TABLE cookbooks (
+	id serial primary key,
+	name varchar(255) not null unique,
+	aliases bytea,
+	created_at timestamp with time zone not null,
+	updated_at timestamp with time zone not null
+);
+
+COMMIT;
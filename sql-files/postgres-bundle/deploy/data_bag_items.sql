@@ -0,0 +1,17 @@
+-- Deploy data_bag_items
+
+BEGIN;
+
+CREATE TABLE data_bag_items (
+	id serial primary key,
+	name varchar(255) not null,
+	orig_name varchar(255) not null,
+	data_bag_id int not null REFERENCES data_bags(id) ON DELETE RESTRICT,
+	raw_data bytea,
+	created_at timestamp with time zone not null,
+	updated_at timestamp with time zone not null,
+	unique (data_bag_id, name),
+	unique (data_bag_id, orig_name)
+);
+
+COMMIT;
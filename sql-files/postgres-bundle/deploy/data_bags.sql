@@ -0,0 +1,12 @@
+-- Deploy data_bags
+
+BEGIN;
+
+CREATE TABLE data_bags (
+	id serial primary key,
+	name varchar(255) not null unique,
+	created_at timestamp with time zone not null,
+	updated_at timestamp with time zone not null
+);
+
+COMMIT;
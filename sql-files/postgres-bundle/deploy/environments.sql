@@ -0,0 +1,19 @@
+-- Deploy environments
+
+BEGIN;
+
+CREATE TABLE environments (
+	id serial primary key,
+	name varchar(255) not null unique,
+	description text,
+	default_attr bytea,
+	override_attr bytea,
+	cookbook_vers bytea,
+	base_environment varchar(255) not null default '',
+	created_at timestamp with time zone not null,
+	updated_at timestamp with time zone not null
+);
+
+INSERT INTO environments (name, description, created_at, updated_at) VALUES ('_default', 'The default Chef environment', NOW(), NOW());
+
+COMMIT;
@@ -0,0 +1,12 @@
+-- Deploy file_checksums
+
+BEGIN;
+
+CREATE TABLE file_checksums (
+	id serial primary key,
+	org_id int not null default 0,
+	checksum varchar(32),
+	unique (org_id, checksum)
+);
+
+COMMIT;
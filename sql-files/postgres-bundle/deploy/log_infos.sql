@@ -0,0 +1,26 @@
+-- Deploy log_infos
+
+BEGIN;
+
+CREATE TYPE log_action AS ENUM ( 'create', 'delete', 'modify' );
+CREATE TYPE log_actor AS ENUM ( 'user', 'client' );
+
+CREATE TABLE log_infos (
+	id bigserial primary key,
+	actor_id bigint not null default 0,
+	actor_info text,
+	actor_type log_actor NOT NULL,
+	organization_id bigint not null default 1,
+	time timestamp with time zone default current_timestamp,
+	action log_action not null,
+	object_type varchar(100) not null,
+	object_name varchar(255) not null,
+	extended_info text
+);
+
+CREATE INDEX log_infos_actor ON log_infos (actor_id);
+CREATE INDEX log_infos_action ON log_infos (action);
+CREATE INDEX log_infos_obj ON log_infos (object_type, object_name);
+CREATE INDEX log_infos_time ON log_infos (time);
+
+COMMIT;
@@ -0,0 +1,20 @@
+-- Deploy nodes
+
+BEGIN;
+
+CREATE TABLE nodes (
+	id serial primary key,
+	name varchar(255) not null unique,
+	chef_environment varchar(255) not null default '_default',
+	run_list bytea,
+	automatic_attr bytea,
+	normal_attr bytea,
+	default_attr bytea,
+	override_attr bytea,
+	created_at timestamp with time zone not null,
+	updated_at timestamp with time zone not null
+);
+
+CREATE INDEX nodes_chef_env ON nodes (chef_environment);
+
+COMMIT;
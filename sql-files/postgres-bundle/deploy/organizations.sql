@@ -0,0 +1,15 @@
+-- Deploy organizations
+
+BEGIN;
+
+CREATE TABLE organizations (
+	id serial primary key,
+	name varchar(255) not null unique,
+	description text,
+	created_at timestamp with time zone not null,
+	updated_at timestamp with time zone not null
+);
+
+INSERT INTO organizations (name, created_at, updated_at) VALUES ('default', NOW(), NOW());
+
+COMMIT;
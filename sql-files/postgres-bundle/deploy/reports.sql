@@ -0,0 +1,27 @@
+-- Deploy reports
+
+BEGIN;
+
+CREATE TYPE report_status AS ENUM ( 'started', 'success', 'failure' );
+
+CREATE TABLE reports (
+	id serial primary key,
+	run_id uuid not null unique,
+	node_name varchar(255),
+	organization_id int not null default 1,
+	start_time timestamp with time zone,
+	end_time timestamp with time zone,
+	total_res_count int default 0,
+	status report_status,
+	run_list text,
+	resources bytea,
+	data bytea,
+	created_at timestamp with time zone not null,
+	updated_at timestamp with time zone not null
+);
+
+CREATE INDEX reports_organization_id ON reports (organization_id);
+CREATE INDEX reports_node_organization_id ON reports (node_name, organization_id);
+CREATE INDEX reports_node_start ON reports (node_name, start_time);
+
+COMMIT;
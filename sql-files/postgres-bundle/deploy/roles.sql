@@ -0,0 +1,17 @@
+-- Deploy roles
+
+BEGIN;
+
+CREATE TABLE roles (
+	id serial primary key,
+	name varchar(255) not null unique,
+	description text,
+	run_list bytea,
+	env_run_lists bytea,
+	default_attr bytea,
+	override_attr bytea,
+	created_at timestamp with time zone not null,
+	updated_at timestamp with time zone not null
+);
+
+COMMIT;
@@ -0,0 +1,13 @@
+-- Deploy sandboxes
+
+BEGIN;
+
+CREATE TABLE sandboxes (
+	id serial primary key,
+	sbox_id varchar(32) not null unique,
+	creation_time timestamp with time zone not null,
+	checksums bytea,
+	completed boolean default FALSE
+);
+
+COMMIT;
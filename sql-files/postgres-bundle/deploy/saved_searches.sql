@@ -0,0 +1,15 @@
+-- Deploy saved_searches
+
+BEGIN;
+
+CREATE TABLE saved_searches (
+	id serial primary key,
+	name varchar(255) not null unique,
+	index_name varchar(255) not null,
+	query text not null,
+	partial bytea,
+	created_at timestamp with time zone not null,
+	updated_at timestamp with time zone not null
+);
+
+COMMIT;
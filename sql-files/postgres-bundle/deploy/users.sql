@@ -0,0 +1,19 @@
+-- Deploy users
+
+BEGIN;
+
+CREATE TABLE users (
+	id serial primary key,
+	name varchar(255) not null unique,
+	displayname varchar(1024),
+	email varchar(255) unique,
+	admin boolean default FALSE,
+	public_key text,
+	passwd varchar(128),
+	salt bytea,
+	last_auth timestamp with time zone NULL DEFAULT NULL,
+	created_at timestamp with time zone not null,
+	updated_at timestamp with time zone not null
+);
+
+COMMIT;
@@ -0,0 +1,7 @@
+-- Revert clients
+
+BEGIN;
+
+DROP TABLE clients;
+
+COMMIT;
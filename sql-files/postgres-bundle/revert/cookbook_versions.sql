@@ -0,0 +1,7 @@
+-- Revert cookbook_versions
+
+BEGIN;
+
+DROP TABLE cookbook_versions;
+
+COMMIT;
@@ -0,0 +1,7 @@
+-- Revert cookbooks
+
+BEGIN;
+
+DROP TABLE cookbooks;
+
+COMMIT;
@@ -0,0 +1,7 @@
+-- Revert data_bag_items
+
+BEGIN;
+
+DROP TABLE data_bag_items;
+
+COMMIT;
@@ -0,0 +1,7 @@
+-- Revert data_bags
+
+BEGIN;
+
+DROP TABLE data_bags;
+
+COMMIT;
@@ -0,0 +1,7 @@
+-- Revert file_checksums
+
+BEGIN;
+
+DROP TABLE file_checksums;
+
+COMMIT;
@@ -0,0 +1,9 @@
+-- Revert log_infos
+
+BEGIN;
+
+DROP TABLE log_infos;
+DROP TYPE log_action;
+DROP TYPE log_actor;
+
+COMMIT;
@@ -0,0 +1,7 @@
+-- Revert nodes
+
+BEGIN;
+
+DROP TABLE nodes;
+
+COMMIT;
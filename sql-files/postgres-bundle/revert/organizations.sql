@@ -0,0 +1,7 @@
+-- Revert organizations
+
+BEGIN;
+
+DROP TABLE organizations;
+
+COMMIT;
@@ -0,0 +1,8 @@
+-- Revert reports
+
+BEGIN;
+
+DROP TABLE reports;
+DROP TYPE report_status;
+
+COMMIT;
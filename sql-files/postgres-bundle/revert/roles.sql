@@ -0,0 +1,7 @@
+-- Revert roles
+
+BEGIN;
+
+DROP TABLE roles;
+
+COMMIT;
@@ -0,0 +1,7 @@
+-- Revert sandboxes
+
+BEGIN;
+
+DROP TABLE sandboxes;
+
+COMMIT;
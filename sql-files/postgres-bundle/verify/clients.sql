@@ -0,0 +1,7 @@
+-- Verify clients
+
+BEGIN;
+
+SELECT id, name, nodename, validator, admin, organization_id, public_key, certificate, disabled, last_auth, created_at, updated_at FROM clients WHERE FALSE;
+
+ROLLBACK;
@@ -0,0 +1,7 @@
+-- Verify cookbooks
+
+BEGIN;
+
+SELECT id, name, aliases, created_at, updated_at FROM cookbooks WHERE FALSE;
+
+ROLLBACK;
@@ -0,0 +1,7 @@
+-- Verify data_bag_items
+
+BEGIN;
+
+SELECT id, name, orig_name, data_bag_id, raw_data, created_at, updated_at FROM data_bag_items WHERE FALSE;
+
+ROLLBACK;
@@ -0,0 +1,7 @@
+-- Verify data_bags
+
+BEGIN;
+
+SELECT id, name, created_at, updated_at FROM data_bags WHERE FALSE;
+
+ROLLBACK;
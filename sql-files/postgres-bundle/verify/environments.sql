@@ -0,0 +1,7 @@
+-- Verify environments
+
+BEGIN;
+
+SELECT id, name, description, default_attr, override_attr, cookbook_vers, base_environment, created_at, updated_at FROM environments WHERE FALSE;
+
+ROLLBACK;
@@ -0,0 +1,7 @@
+-- Verify file_checksums
+
+BEGIN;
+
+SELECT id, org_id, checksum FROM file_checksums WHERE FALSE;
+
+ROLLBACK;
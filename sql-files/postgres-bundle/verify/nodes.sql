@@ -0,0 +1,7 @@
+-- Verify nodes
+
+BEGIN;
+
+SELECT id, name, chef_environment, run_list, automatic_attr, normal_attr, default_attr, override_attr, created_at, updated_at FROM nodes WHERE FALSE;
+
+ROLLBACK;
@@ -0,0 +1,7 @@
+-- Verify organizations
+
+BEGIN;
+
+SELECT id, name, description, created_at, updated_at FROM organizations WHERE FALSE;
+
+ROLLBACK;
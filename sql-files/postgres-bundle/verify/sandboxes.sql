@@ -0,0 +1,7 @@
+-- Verify sandboxes
+
+BEGIN;
+
+SELECT id, sbox_id, creation_time, checksums, completed FROM sandboxes WHERE FALSE;
+
+ROLLBACK;
@@ -0,0 +1,7 @@
+-- Verify users
+
+BEGIN;
+
+SELECT id, name, displayname, email, admin, public_key, passwd, salt, last_auth, created_at, updated_at FROM users WHERE FALSE;
+
+ROLLBACK;
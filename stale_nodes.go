@@ -0,0 +1,126 @@
+/*
+ * Copyright (c) 2013-2014, Jeremy Bingham (<jbingham@gmail.com>)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Find nodes that have stopped checking in, and optionally clean them up.
+
+package main
+
+import (
+	"net/http"
+	"encoding/json"
+	"time"
+	"github.com/ctdk/goiardi/node"
+	"github.com/ctdk/goiardi/actor"
+	"github.com/ctdk/goiardi/client"
+	"github.com/ctdk/goiardi/log_info"
+	"github.com/ctdk/goiardi/config"
+	"github.com/ctdk/goiardi/util"
+	"git.tideland.biz/goas/logger"
+)
+
+/* List the nodes that haven't checked in for the "age" given in the query
+ * string, or for --node-stale-delete-after if there's no age, so you can see
+ * what would be deleted before turning deletion on. */
+func node_stale(w http.ResponseWriter, r *http.Request, opUser actor.Actor) {
+	if r.Method != "GET" {
+		JsonErrorReport(w, r, "Unrecognized method", http.StatusMethodNotAllowed)
+		return
+	}
+	if !opUser.IsAdmin() {
+		JsonErrorReport(w, r, "You are not allowed to perform this action", http.StatusForbidden)
+		return
+	}
+	age := config.Config.NodeStaleDeleteAfterDur
+	if a := r.FormValue("age"); a != "" {
+		var err error
+		age, err = time.ParseDuration(a)
+		if err != nil || age <= 0 {
+			JsonErrorReport(w, r, "invalid age", http.StatusBadRequest)
+			return
+		}
+	}
+	if age == 0 {
+		JsonErrorReport(w, r, "No age given, and node-stale-delete-after is not set", http.StatusBadRequest)
+		return
+	}
+	stale_response := make(map[string]interface{})
+	for _, n := range node.StaleNodes(age) {
+		last, _ := n.LastCheckIn()
+		stale_response[n.Name] = map[string]interface{}{ "url": util.ObjURL(n), "last_check_in": last.Format(time.RFC3339) }
+	}
+	enc := json.NewEncoder(w)
+	if err := enc.Encode(&stale_response); err != nil {
+		JsonErrorReport(w, r, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func setStaleNodeTicker() {
+	if config.Config.NodeStaleDeleteAfterDur != 0 {
+		ticker := time.NewTicker(time.Hour)
+		go func() {
+			for _ = range ticker.C {
+				removeStaleNodes()
+			}
+		}()
+	}
+}
+
+/* The deletions are logged as being done by the chef-webui client, since
+ * there's no user making the request. */
+func removeStaleNodes() {
+	stale := node.StaleNodes(config.Config.NodeStaleDeleteAfterDur)
+	if len(stale) == 0 {
+		return
+	}
+	if config.Config.NodeStaleDryRun {
+		for _, n := range stale {
+			last, _ := n.LastCheckIn()
+			logger.Infof("Dry run: would delete stale node %s, last checked in at %s", n.Name, last.Format(time.RFC3339))
+		}
+		return
+	}
+	doer, err := client.Get("chef-webui")
+	if err != nil {
+		logger.Errorf("Not deleting stale nodes: %s", err.Error())
+		return
+	}
+	for _, n := range stale {
+		deleteStaleNode(n.Name, config.Config.NodeStaleDeleteAfterDur, doer)
+	}
+}
+
+/* Finding all the stale nodes can take a while, and a node may check in after
+ * it was found, so it's fetched again and only deleted if it's still stale. */
+func deleteStaleNode(node_name string, age time.Duration, doer actor.Actor) bool {
+	n, err := node.Get(node_name)
+	if err != nil {
+		return false
+	}
+	last, _ := n.LastCheckIn()
+	if !n.IsStale(age) {
+		logger.Infof("Not deleting node %s, which checked in again at %s", n.Name, last.Format(time.RFC3339))
+		return false
+	}
+	if err := n.Delete(); err != nil {
+		logger.Errorf("Error deleting stale node %s: %s", n.Name, err.Error())
+		return false
+	}
+	logger.Infof("Deleted stale node %s, last checked in at %s", n.Name, last.Format(time.RFC3339))
+	if lerr := log_info.LogEvent(doer, n, "delete"); lerr != nil {
+		logger.Errorf(lerr.Error())
+	}
+	return true
+}
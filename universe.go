@@ -0,0 +1,85 @@
+/* The cookbook universe, for Berkshelf */
+
+/*
+ * Copyright (c) 2013-2014, Jeremy Bingham (<jbingham@gmail.com>)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"github.com/ctdk/goiardi/actor"
+	"github.com/ctdk/goiardi/cookbook"
+)
+
+/* Every version of every cookbook, with where to get it and its dependencies,
+ * in one document so Berkshelf can resolve against goiardi directly. Takes
+ * "versions" to only include each cookbook's newest N versions, and
+ * "frozen_only" to only include frozen versions. */
+func universe_handler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	opUser, oerr := actor.GetReqUser(r.Header.Get("X-OPS-USERID"))
+	if oerr != nil {
+		GerrorReport(w, r, oerr)
+		return
+	}
+	if r.Method != "GET" {
+		JsonErrorReport(w, r, "Unrecognized method", http.StatusMethodNotAllowed)
+		return
+	}
+	if opUser.IsValidator() {
+		JsonErrorReport(w, r, "You are not allowed to perform this action", http.StatusForbidden)
+		return
+	}
+	var num_versions int
+	if v := r.FormValue("versions"); v != "" {
+		var err error
+		num_versions, err = strconv.Atoi(v)
+		if err != nil || num_versions < 1 {
+			JsonErrorReport(w, r, "invalid versions", http.StatusBadRequest)
+			return
+		}
+	}
+	var filter func(*cookbook.CookbookVersion) bool
+	if fo := r.FormValue("frozen_only"); fo != "" {
+		frozen_only, err := strconv.ParseBool(fo)
+		if err != nil {
+			JsonErrorReport(w, r, "invalid value for frozen_only", http.StatusBadRequest)
+			return
+		}
+		if frozen_only {
+			filter = func(cbv *cookbook.CookbookVersion) bool { return cbv.IsFrozen }
+		}
+	}
+
+	universe := make(map[string]interface{})
+	for _, cb := range cookbook.AllCookbooks() {
+		if !cookbookVisible(opUser, cb.Name) {
+			continue
+		}
+		entry := cb.UniverseEntry(num_versions, filter)
+		/* Filtering can leave a cookbook with nothing to offer. */
+		if len(entry) == 0 {
+			continue
+		}
+		universe[cb.Name] = entry
+	}
+	enc := json.NewEncoder(w)
+	if err := enc.Encode(&universe); err != nil {
+		JsonErrorReport(w, r, err.Error(), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,85 @@
+/*
+ * Copyright (c) 2013-2014, Jeremy Bingham (<jbingham@gmail.com>)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Limit how many cookbook and file uploads are handled at once.
+
+package main
+
+import (
+	"net/http"
+	"strings"
+	"time"
+	"github.com/ctdk/goiardi/config"
+	"git.tideland.biz/goas/logger"
+)
+
+/* Counting semaphore for uploads, separate from requestSem so a burst of
+ * cookbook publishes can't tie up every request slot. Nil if there's no
+ * limit. Like requestSem, it's guarded by semLock. */
+var uploadSem chan bool
+
+/* Wait for an upload slot, for up to upload-queue-timeout. If no slot frees up
+ * in time, a 503 is sent and false returned. The semaphore the slot was taken
+ * from is returned too, and must be passed to releaseUploadSlot when the
+ * upload's done. */
+func acquireUploadSlot(w http.ResponseWriter, r *http.Request) (chan bool, bool) {
+	semLock.RLock()
+	sem := uploadSem
+	semLock.RUnlock()
+	if sem == nil {
+		return nil, true
+	}
+	_, timeout := config.UploadLimits()
+	select {
+		case sem <- true:
+			return sem, true
+		case <-time.After(timeout):
+			logger.Warningf("Too many concurrent uploads, rejecting %s %s from %s", r.Method, r.URL.Path, requestClientIP(r))
+			JsonErrorReport(w, r, "Too many uploads in progress, try again later", http.StatusServiceUnavailable)
+			return nil, false
+	}
+}
+
+func releaseUploadSlot(sem chan bool) {
+	if sem != nil {
+		<-sem
+	}
+}
+
+/* Cap how much of a cookbook import's body will be read at max-import-size.
+ * A body that says it's bigger than that gets a 413 right away, and one that
+ * doesn't say how big it is stops being read at the limit. Returns false if a
+ * 413 has been sent. */
+func importBodyLimit(w http.ResponseWriter, r *http.Request) bool {
+	limit := int64(config.Config.MaxImportSize)
+	if limit < 0 {
+		return true
+	}
+	if r.ContentLength > limit {
+		http.Error(w, "Content-length too long!", http.StatusRequestEntityTooLarge)
+		return false
+	}
+	if r.Body != nil {
+		r.Body = http.MaxBytesReader(w, r.Body, limit)
+	}
+	return true
+}
+
+/* Did reading a body limited by importBodyLimit fail because it was too
+ * long? */
+func bodyTooLarge(err error) bool {
+	return err != nil && strings.Contains(err.Error(), "request body too large")
+}
@@ -25,22 +25,9 @@ import (
 	"net/http"
 )
 
-func checkForUserMySQL(dbhandle data_store.Dbhandle, name string) (bool, error) {
-	_, err := data_store.CheckForOne(dbhandle, "users", name)
-	if err == nil {
-		return true, nil
-	} else {
-		if err != sql.ErrNoRows {
-			return false, err
-		} else {
-			return false, nil
-		}
-	}
-}
-
 func getUserMySQL(name string) (*User, error) {
 	user := new(User)
-	stmt, err := data_store.Dbh.Prepare("select name, displayname, admin, public_key, email, passwd, salt FROM users WHERE name = ?")
+	stmt, err := data_store.Dbh.Prepare("select name, displayname, admin, public_key, email, passwd, salt, last_auth FROM users WHERE name = ?")
 	if err != nil {
 		return nil, err
 	}
@@ -53,20 +40,6 @@ func getUserMySQL(name string) (*User, error) {
 	return user, nil
 }
 
-func (u *User) fillUserFromSQL(row *sql.Row) error {
-	var email sql.NullString
-	err := row.Scan(&u.Username, &u.Name, &u.Admin, &u.pubKey, &email, &u.passwd, &u.salt)
-	if err != nil {
-		return err
-	}
-	if !email.Valid {
-		u.Email = ""
-	} else {
-		u.Email = email.String
-	}
-	return nil
-}
-
 func (u *User) saveMySQL() util.Gerror {
 	tx, err := data_store.Dbh.Begin()
 	var user_id int32
@@ -108,6 +81,11 @@ func (u *User) saveMySQL() util.Gerror {
 	return nil
 }
 
+func (u *User) updateLastAuthMySQL() error {
+	_, err := data_store.Dbh.Exec("UPDATE users SET last_auth = ? WHERE name = ?", u.LastAuth, u.Username)
+	return err
+}
+
 func (u *User) deleteMySQL() error {
 	tx, err := data_store.Dbh.Begin()
 	if err != nil {
@@ -133,7 +111,7 @@ func (u *User) renameMySQL(new_name string) util.Gerror {
 		gerr := util.Errorf(err.Error())
 		return gerr
 	}
-	found, err := checkForUserMySQL(data_store.Dbh, new_name)
+	found, err := checkForUserSQL(data_store.Dbh, new_name)
 	if found || err != nil {
 		tx.Rollback()
 		if found && err == nil {
@@ -192,29 +170,3 @@ func numAdminsMySQL() int {
 	}
 	return numAdmins
 }
-
-func getListMySQL() []string {
-	var user_list []string
-	rows, err := data_store.Dbh.Query("SELECT name FROM users")
-	if err != nil {
-		if err != sql.ErrNoRows {
-			log.Fatal(err)
-		}
-		rows.Close()
-		return user_list
-	}
-	user_list = make([]string, 0)
-	for rows.Next() {
-		var user_name string
-		err = rows.Scan(&user_name)
-		if err != nil {
-			log.Fatal(err)
-		}
-		user_list = append(user_list, user_name)
-	}
-	rows.Close()
-	if err = rows.Err(); err != nil {
-		log.Fatal(err)
-	}
-	return user_list
-}
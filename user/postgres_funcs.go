@@ -0,0 +1,148 @@
+/*
+ * Copyright (c) 2013-2014, Jeremy Bingham (<jbingham@gmail.com>)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package user
+
+import (
+	"github.com/ctdk/goiardi/data_store"
+	"github.com/ctdk/goiardi/util"
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+)
+
+func getUserPostgreSQL(name string) (*User, error) {
+	user := new(User)
+	stmt, err := data_store.Dbh.Prepare("select name, displayname, admin, public_key, email, passwd, salt, last_auth FROM users WHERE name = $1")
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+	row := stmt.QueryRow(name)
+	err = user.fillUserFromSQL(row)
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+func (u *User) savePostgreSQL() util.Gerror {
+	tx, err := data_store.Dbh.Begin()
+	if err != nil {
+		gerr := util.Errorf(err.Error())
+		return gerr
+	}
+	// check for a client with this name first. If orgs are ever
+	// implemented, it will only need to check for a client
+	// in with this organization
+	err = chkForClientPostgreSQL(tx, u.Username)
+	if err != nil {
+		tx.Rollback()
+		gerr := util.Errorf(err.Error())
+		gerr.SetStatus(http.StatusConflict)
+		return gerr
+	}
+	_, err = tx.Exec("INSERT INTO users (name, displayname, admin, public_key, passwd, salt, created_at, updated_at) VALUES ($1, $2, $3, $4, $5, $6, NOW(), NOW()) ON CONFLICT (name) DO UPDATE SET displayname = EXCLUDED.displayname, admin = EXCLUDED.admin, public_key = EXCLUDED.public_key, passwd = EXCLUDED.passwd, salt = EXCLUDED.salt, updated_at = NOW()", u.Username, u.Name, u.Admin, u.pubKey, u.passwd, u.salt)
+	if err != nil {
+		tx.Rollback()
+		gerr := util.Errorf(err.Error())
+		return gerr
+	}
+	tx.Commit()
+	return nil
+}
+
+func (u *User) updateLastAuthPostgreSQL() error {
+	_, err := data_store.Dbh.Exec("UPDATE users SET last_auth = $1 WHERE name = $2", u.LastAuth, u.Username)
+	return err
+}
+
+func (u *User) deletePostgreSQL() error {
+	tx, err := data_store.Dbh.Begin()
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("DELETE FROM users WHERE name = $1", u.Username)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	tx.Commit()
+	return nil
+}
+
+func (u *User) renamePostgreSQL(new_name string) util.Gerror {
+	tx, err := data_store.Dbh.Begin()
+	if err != nil {
+		gerr := util.Errorf(err.Error())
+		return gerr
+	}
+	if err = chkForClientPostgreSQL(tx, new_name); err != nil {
+		tx.Rollback()
+		gerr := util.Errorf(err.Error())
+		return gerr
+	}
+	found, err := checkForUserSQL(tx, new_name)
+	if found || err != nil {
+		tx.Rollback()
+		if found && err == nil {
+			gerr := util.Errorf("User %s already exists, cannot rename %s", new_name, u.Username)
+			gerr.SetStatus(http.StatusConflict)
+			return gerr
+		} else {
+			gerr := util.Errorf(err.Error())
+			gerr.SetStatus(http.StatusInternalServerError)
+			return gerr
+		}
+	}
+	_, err = tx.Exec("UPDATE users SET name = $1 WHERE name = $2", new_name, u.Username)
+	if err != nil {
+		tx.Rollback()
+		gerr := util.Errorf(err.Error())
+		gerr.SetStatus(http.StatusInternalServerError)
+		return gerr
+	}
+	tx.Commit()
+	return nil
+}
+
+func chkForClientPostgreSQL(handle data_store.Dbhandle, name string) error {
+	var user_id int32
+	err := handle.QueryRow("SELECT id FROM clients WHERE name = $1", name).Scan(&user_id)
+	if err != sql.ErrNoRows {
+		if err == nil {
+			err = fmt.Errorf("a client with id %d named %s was found that would conflict with this user", user_id, name)
+		}
+	} else {
+		err = nil
+	}
+	return err 
+}
+
+func numAdminsPostgreSQL() int {
+	var numAdmins int
+	stmt, err := data_store.Dbh.Prepare("SELECT count(*) FROM users WHERE admin = TRUE")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer stmt.Close()
+	err = stmt.QueryRow().Scan(&numAdmins)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return numAdmins
+}
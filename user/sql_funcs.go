@@ -0,0 +1,157 @@
+/*
+ * Copyright (c) 2013-2014, Jeremy Bingham (<jbingham@gmail.com>)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package user
+
+import (
+	"github.com/ctdk/goiardi/config"
+	"github.com/ctdk/goiardi/data_store"
+	"github.com/ctdk/goiardi/util"
+	"database/sql"
+	"github.com/go-sql-driver/mysql"
+	"log"
+)
+
+func checkForUserSQL(dbhandle data_store.Dbhandle, name string) (bool, error) {
+	_, err := data_store.CheckForOne(dbhandle, "users", name)
+	if err == nil {
+		return true, nil
+	} else {
+		if err != sql.ErrNoRows {
+			return false, err
+		} else {
+			return false, nil
+		}
+	}
+}
+
+func (u *User) fillUserFromSQL(row *sql.Row) error {
+	var email sql.NullString
+	var la mysql.NullTime
+	err := row.Scan(&u.Username, &u.Name, &u.Admin, &u.pubKey, &email, &u.passwd, &u.salt, &la)
+	if err != nil {
+		return err
+	}
+	if la.Valid {
+		u.LastAuth = la.Time
+	}
+	if !email.Valid {
+		u.Email = ""
+	} else {
+		u.Email = email.String
+	}
+	return nil
+}
+
+func getListSQL() []string {
+	var user_list []string
+	rows, err := data_store.Dbh.Query("SELECT name FROM users")
+	if err != nil {
+		if err != sql.ErrNoRows {
+			log.Fatal(err)
+		}
+		rows.Close()
+		return user_list
+	}
+	user_list = make([]string, 0)
+	for rows.Next() {
+		var user_name string
+		err = rows.Scan(&user_name)
+		if err != nil {
+			log.Fatal(err)
+		}
+		user_list = append(user_list, user_name)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		log.Fatal(err)
+	}
+	return user_list
+}
+
+func getNeverUsedListSQL() []string {
+	user_list := make([]string, 0)
+	rows, err := data_store.Dbh.Query("SELECT name FROM users WHERE last_auth IS NULL ORDER BY name")
+	if err != nil {
+		if err != sql.ErrNoRows {
+			log.Fatal(err)
+		}
+		return user_list
+	}
+	for rows.Next() {
+		var user_name string
+		err = rows.Scan(&user_name)
+		if err != nil {
+			log.Fatal(err)
+		}
+		user_list = append(user_list, user_name)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		log.Fatal(err)
+	}
+	return user_list
+}
+
+func getListPageSQL(offset int, limit int, desc bool) ([]string, int) {
+	user_list, total, err := data_store.GetSortedNameListPage(data_store.Dbh, "users", offset, limit, desc)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return user_list, total
+}
+
+func getUserSQL(name string) (*User, error) {
+	if config.Config.UseMySQL {
+		return getUserMySQL(name)
+	}
+	return getUserPostgreSQL(name)
+}
+
+func (u *User) saveSQL() util.Gerror {
+	if config.Config.UseMySQL {
+		return u.saveMySQL()
+	}
+	return u.savePostgreSQL()
+}
+
+func (u *User) updateLastAuthSQL() error {
+	if config.Config.UseMySQL {
+		return u.updateLastAuthMySQL()
+	}
+	return u.updateLastAuthPostgreSQL()
+}
+
+func (u *User) deleteSQL() error {
+	if config.Config.UseMySQL {
+		return u.deleteMySQL()
+	}
+	return u.deletePostgreSQL()
+}
+
+func (u *User) renameSQL(new_name string) util.Gerror {
+	if config.Config.UseMySQL {
+		return u.renameMySQL(new_name)
+	}
+	return u.renamePostgreSQL(new_name)
+}
+
+func numAdminsSQL() int {
+	if config.Config.UseMySQL {
+		return numAdminsMySQL()
+	}
+	return numAdminsPostgreSQL()
+}
@@ -35,8 +35,16 @@ import (
 	"encoding/gob"
 	"bytes"
 	"database/sql"
+	"sync"
+	"time"
 )
 
+/* Successful authentications update the user they authenticated as in place,
+ * while other requests may be reading or deleting the same user. stateLock
+ * covers the last authentication time of in-memory users, and is held while
+ * deleting one so an update can't put it back afterwards. */
+var stateLock sync.RWMutex
+
 type User struct {
 	Username string `json:"username"`
 	Name string `json:"name"`
@@ -45,6 +53,7 @@ type User struct {
 	pubKey string `json:"public_key"`
 	passwd string
 	salt []byte
+	LastAuth time.Time `json:"last_auth"`
 }
 
 type privUser struct {
@@ -55,15 +64,16 @@ type privUser struct {
 	PublicKey *string `json:"public_key"`
 	Passwd *string `json:"public_key"`
 	Salt *[]byte `json:"salt"`
+	LastAuth *time.Time `json:"last_auth"`
 }
 
 // Create a new API user.
 func New(name string) (*User, util.Gerror) {
 	var found bool
 	var err util.Gerror
-	if config.Config.UseMySQL {
+	if config.Config.UseSQL {
 		var uerr error
-		found, uerr = checkForUserMySQL(data_store.Dbh, name)
+		found, uerr = checkForUserSQL(data_store.Dbh, name)
 		if uerr != nil {
 			err = util.Errorf(uerr.Error())
 			err.SetStatus(http.StatusInternalServerError)
@@ -102,9 +112,9 @@ func New(name string) (*User, util.Gerror) {
 // Gets a user.
 func Get(name string) (*User, util.Gerror){
 	var user *User
-	if config.Config.UseMySQL {
+	if config.Config.UseSQL {
 		var err error
-		user, err = getUserMySQL(name)
+		user, err = getUserSQL(name)
 		if err != nil {
 			var gerr util.Gerror
 			if err != sql.ErrNoRows {
@@ -132,8 +142,8 @@ func Get(name string) (*User, util.Gerror){
 
 // Save the user's current state.
 func (u *User) Save() util.Gerror {
-	if config.Config.UseMySQL {
-		err := u.saveMySQL()
+	if config.Config.UseSQL {
+		err := u.saveSQL()
 		if err != nil {
 			return err
 		}
@@ -156,14 +166,16 @@ func (u *User) Delete() util.Gerror {
 		err := util.Errorf("Cannot delete the last admin")
 		return err
 	}
-	if config.Config.UseMySQL {
-		err := u.deleteMySQL()
+	if config.Config.UseSQL {
+		err := u.deleteSQL()
 		if err != nil {
 			return nil
 		}
 	} else {
+		stateLock.Lock()
 		ds := data_store.New()
 		ds.Delete("user", u.Username)
+		stateLock.Unlock()
 	}
 	return nil
 }
@@ -179,8 +191,8 @@ func (u *User) Rename(new_name string) util.Gerror {
 		err.SetStatus(http.StatusForbidden)
 		return err
 	}
-	if config.Config.UseMySQL {
-		if err := u.renameMySQL(new_name); err != nil {
+	if config.Config.UseSQL {
+		if err := u.renameSQL(new_name); err != nil {
 			return err
 		}
 	} else {
@@ -237,7 +249,7 @@ func (u *User)UpdateFromJson(json_user map[string]interface{}) util.Gerror {
 
 	/* Validations. */
 	/* Invalid top level elements */
-	valid_elements := []string{ "username", "name", "org_name", "public_key", "private_key", "admin", "password" }
+	valid_elements := []string{ "username", "name", "org_name", "public_key", "private_key", "admin", "password", "last_auth" }
 	ValidElem:
 	for k, _ := range json_user {
 		for _, i := range valid_elements {
@@ -286,8 +298,8 @@ func (u *User)UpdateFromJson(json_user map[string]interface{}) util.Gerror {
 // Returns a list of users.
 func GetList() []string {
 	var user_list []string
-	if config.Config.UseMySQL {
-		user_list = getListMySQL()
+	if config.Config.UseSQL {
+		user_list = getListSQL()
 	} else {
 		ds := data_store.New()
 		user_list = ds.GetList("user")
@@ -295,6 +307,67 @@ func GetList() []string {
 	return user_list
 }
 
+// Returns part of the list of users, sorted by name, starting at offset and
+// holding at most limit of them, along with the total number of users. A
+// negative limit returns everything after the offset.
+func GetListPage(offset int, limit int) ([]string, int) {
+	return GetSortedListPage(offset, limit, false)
+}
+
+// Like GetListPage, but the users can be sorted by name in descending order
+// instead.
+func GetSortedListPage(offset int, limit int, desc bool) ([]string, int) {
+	if config.Config.UseSQL {
+		return getListPageSQL(offset, limit, desc)
+	}
+	user_list := data_store.SortList(GetList(), desc)
+	return data_store.PageList(user_list, offset, limit), len(user_list)
+}
+
+// Returns a list of users that have never successfully authenticated with the
+// server.
+func GetNeverUsedList() []string {
+	var user_list []string
+	if config.Config.UseSQL {
+		user_list = getNeverUsedListSQL()
+	} else {
+		user_list = make([]string, 0)
+		for _, un := range GetList() {
+			u, _ := Get(un)
+			if u != nil && u.lastAuth().IsZero() {
+				user_list = append(user_list, un)
+			}
+		}
+	}
+	return user_list
+}
+
+// Record that the user has just successfully authenticated. Unlike Save(),
+// this only updates the last authentication time.
+func (u *User) UpdateLastAuth() error {
+	now := time.Now().UTC()
+	if config.Config.UseSQL {
+		u.LastAuth = now
+		return u.updateLastAuthSQL()
+	}
+	/* Update the stored user in place rather than setting u again, so a
+	 * user deleted since it was fetched stays deleted. */
+	stateLock.Lock()
+	defer stateLock.Unlock()
+	u.LastAuth = now
+	ds := data_store.New()
+	if stored, found := ds.Get("user", u.Username); found {
+		stored.(*User).LastAuth = now
+	}
+	return nil
+}
+
+func (u *User) lastAuth() time.Time {
+	stateLock.RLock()
+	defer stateLock.RUnlock()
+	return u.LastAuth
+}
+
 // Convert the user to a JSON object, massaging it as needed to keep the chef
 // client happy (be it knife, chef-pedant, etc.) NOTE: There may be a more
 // idiomatic way to do this.
@@ -303,6 +376,11 @@ func (u *User) ToJson() map[string]interface{} {
 	toJson["name"] = u.Name
 	toJson["admin"] = u.Admin
 	toJson["public_key"] = u.PublicKey()
+	if la := u.lastAuth(); la.IsZero() {
+		toJson["last_auth"] = nil
+	} else {
+		toJson["last_auth"] = la.UTC().Format(time.RFC3339)
+	}
 
 	return toJson
 }
@@ -310,8 +388,8 @@ func (u *User) ToJson() map[string]interface{} {
 func (u *User) isLastAdmin() bool {
 	if u.Admin {
 		numAdmins := 0
-		if config.Config.UseMySQL {
-			numAdmins = numAdminsMySQL()
+		if config.Config.UseSQL {
+			numAdmins = numAdminsSQL()
 		} else {		
 			user_list := GetList()
 			for _, u := range user_list {
@@ -467,10 +545,12 @@ func (u *User) URLType() string {
 }
 
 func (u *User) export() *privUser {
-	return &privUser{ Name: &u.Name, Username: &u.Username, PublicKey: &u.pubKey, Admin: &u.Admin, Email: &u.Email, Passwd: &u.passwd, Salt: &u.salt }
+	return &privUser{ Name: &u.Name, Username: &u.Username, PublicKey: &u.pubKey, Admin: &u.Admin, Email: &u.Email, Passwd: &u.passwd, Salt: &u.salt, LastAuth: &u.LastAuth }
 }
 
 func (u *User) GobEncode() ([]byte, error) {
+	stateLock.RLock()
+	defer stateLock.RUnlock()
 	prv := u.export()
 	buf := new(bytes.Buffer)
 	decoder := gob.NewEncoder(buf)
@@ -20,9 +20,11 @@ package user
 
 import (
 	"testing"
+	"github.com/ctdk/goiardi/data_store"
 	"bytes"
 	"fmt"
 	"encoding/gob"
+	"sync"
 )
 
 func TestNewUser(t *testing.T) {
@@ -81,3 +83,33 @@ func TestGobEncodeDecode(t *testing.T){
 		t.Errorf("saved user doesn't seem to be equal to original: %v vs %v", c2, c)
 	}
 }
+
+func TestConcurrentAuthDelete(t *testing.T){
+	for i := 0; i < 20; i++ {
+		name := fmt.Sprintf("authdel%d", i)
+		u, _ := New(name)
+		/* Store it directly; indexing in the background isn't
+		 * what's being tested here. */
+		data_store.New().Set("user", name, u)
+		var wg sync.WaitGroup
+		for j := 0; j < 4; j++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if u2, _ := Get(name); u2 != nil {
+					u2.UpdateLastAuth()
+					u2.ToJson()
+				}
+			}()
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			u.Delete()
+		}()
+		wg.Wait()
+		if u2, _ := Get(name); u2 != nil {
+			t.Errorf("User %s came back after being deleted", name)
+		}
+	}
+}
@@ -33,7 +33,7 @@ func user_handler(w http.ResponseWriter, r *http.Request){
 	user_name := path[1]
 	opUser, oerr := actor.GetReqUser(r.Header.Get("X-OPS-USERID"))
 	if oerr != nil {
-		JsonErrorReport(w, r, oerr.Error(), oerr.Status())
+		GerrorReport(w, r, oerr)
 		return
 	}
 
@@ -106,7 +106,7 @@ func user_handler(w http.ResponseWriter, r *http.Request){
 			/* Makes chef-pedant happy. I suppose it is, after all,
 			 * pedantic. */
 			if averr := util.CheckAdminPlusValidator(user_data); averr != nil {
-				JsonErrorReport(w, r, averr.Error(), averr.Status())
+				GerrorReport(w, r, averr)
 				return
 			}
 
@@ -117,7 +117,7 @@ func user_handler(w http.ResponseWriter, r *http.Request){
 			if !opUser.IsAdmin() {
 				aerr := opUser.CheckPermEdit(user_data, "admin")
 				if aerr != nil {
-					JsonErrorReport(w, r, aerr.Error(), aerr.Status())
+					GerrorReport(w, r, aerr)
 					return
 				}
 			}
@@ -136,14 +136,14 @@ func user_handler(w http.ResponseWriter, r *http.Request){
 			if user_name != json_name {
 				err := chef_user.Rename(json_name)
 				if err != nil {
-					JsonErrorReport(w, r, err.Error(), err.Status())
+					GerrorReport(w, r, err)
 					return
 				} else {
 					w.WriteHeader(http.StatusCreated)
 				}
 			} 
 			if uerr := chef_user.UpdateFromJson(user_data); uerr != nil {
-				JsonErrorReport(w, r, uerr.Error(), uerr.Status())
+				GerrorReport(w, r, uerr)
 				return
 			}
 
@@ -187,7 +187,7 @@ func user_handler(w http.ResponseWriter, r *http.Request){
 
 			serr := chef_user.Save()
 			if serr != nil {
-				JsonErrorReport(w, r, serr.Error(), serr.Status())
+				GerrorReport(w, r, serr)
 				return
 			}
 			if lerr := log_info.LogEvent(opUser, chef_user, "modify"); lerr != nil {
@@ -31,6 +31,7 @@ import (
 	"regexp"
 	"sort"
 	"strings"
+	"time"
 )
 
 // Anything that implements these functions is a goiardi/chef object, like a
@@ -43,15 +44,22 @@ type GoiardiObj interface {
 type gerror struct {
 	msg string
 	status int
+	code string
+	field string
 }
 
 // An error type that includes an http status code (defaults to 
-// http.BadRequest).
+// http.BadRequest), a machine readable error code, and the field of the
+// request the error was about, if any.
 type Gerror interface {
 	String() string
 	Error() string
 	Status() int
 	SetStatus(int)
+	Code() string
+	SetCode(string)
+	Field() string
+	SetField(string)
 }
 
 func New(text string) Gerror {
@@ -65,6 +73,15 @@ func Errorf(format string, a ...interface{}) Gerror {
 	return New(fmt.Sprintf(format, a...))
 }
 
+// Create a new Gerror about a particular field of a request, with a formatted
+// error string. Its code is "invalid_field".
+func FieldErrorf(field string, format string, a ...interface{}) Gerror {
+	err := Errorf(format, a...)
+	err.SetField(field)
+	err.SetCode("invalid_field")
+	return err
+}
+
 // Easily cast a different kind of error to a Gerror
 func CastErr(err error) Gerror {
 	return Errorf(err.Error())
@@ -89,6 +106,81 @@ func (e *gerror) Status() int {
 	return e.status
 }
 
+// Returns the Gerror's error code. If one hasn't been set, it's made from the
+// HTTP status code, like "not_found".
+func (e *gerror) Code() string {
+	if e.code != "" {
+		return e.code
+	}
+	return StatusCode(e.status)
+}
+
+// Set the Gerror's error code.
+func (e *gerror) SetCode(c string) {
+	e.code = c
+}
+
+// Returns the field of the request the Gerror is about, or the empty string if
+// it isn't about one field.
+func (e *gerror) Field() string {
+	return e.field
+}
+
+// Set the field of the request the Gerror is about.
+func (e *gerror) SetField(f string) {
+	e.field = f
+}
+
+// Makes an error code from an HTTP status code, like "not_found" for 404.
+func StatusCode(status int) string {
+	text := http.StatusText(status)
+	if text == "" {
+		return "error"
+	}
+	text = strings.Replace(strings.ToLower(text), "'", "", -1)
+	return strings.Replace(strings.Replace(text, " ", "_", -1), "-", "_", -1)
+}
+
+// Parse a duration like time.ParseDuration does, but also accept a whole
+// number of days like "7d", since hours get unwieldy for longer spans of time.
+func ParseDuration(s string) (time.Duration, error) {
+	if strings.HasSuffix(s, "d") {
+		days, err := strconv.Atoi(strings.TrimSuffix(s, "d"))
+		if err != nil || days < 0 {
+			return 0, fmt.Errorf("invalid duration %s", s)
+		}
+		return time.Duration(days) * 24 * time.Hour, nil
+	}
+	return time.ParseDuration(s)
+}
+
+// A count of things that fell in a period of time starting at BucketStart.
+type TimeBucket struct {
+	BucketStart time.Time `json:"bucket_start"`
+	Count int `json:"count"`
+}
+
+// Turn bucket numbers (seconds since the epoch divided by the interval, in
+// seconds) and their counts into a list of buckets, oldest first.
+func TimeBuckets(counts map[int64]int, secs int64) []*TimeBucket {
+	bucket_nums := make([]int64, 0, len(counts))
+	for b := range counts {
+		bucket_nums = append(bucket_nums, b)
+	}
+	sort.Sort(int64Sorter(bucket_nums))
+	buckets := make([]*TimeBucket, len(bucket_nums))
+	for i, b := range bucket_nums {
+		buckets[i] = &TimeBucket{ BucketStart: time.Unix(b * secs, 0).UTC(), Count: counts[b] }
+	}
+	return buckets
+}
+
+type int64Sorter []int64
+
+func (s int64Sorter) Len() int { return len(s) }
+func (s int64Sorter) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s int64Sorter) Less(i, j int) bool { return s[i] < s[j] }
+
 // Craft a URL
 func ObjURL(obj GoiardiObj) string {
 	base_url := config.ServerBaseURL()
@@ -188,6 +280,62 @@ func escapeStr(s string) string {
 	return s
 }
 
+// Merge attribute hashes the way chef does when combining attributes of
+// different precedence. Values in src override values in dst, except that if
+// both values are hashes they're merged together key by key. Neither dst nor
+// src is modified; the merged hash is returned.
+func MergeAttributes(dst map[string]interface{}, src map[string]interface{}) map[string]interface{} {
+	merged := make(map[string]interface{}, len(dst))
+	for k, v := range dst {
+		merged[k] = v
+	}
+	for k, v := range src {
+		sm, sok := v.(map[string]interface{})
+		dm, dok := merged[k].(map[string]interface{})
+		if sok && dok {
+			merged[k] = MergeAttributes(dm, sm)
+		} else if sok {
+			merged[k] = MergeAttributes(map[string]interface{}{}, sm)
+		} else {
+			merged[k] = v
+		}
+	}
+	return merged
+}
+
+// Flatten nested attributes into a single level hash, with each key being the
+// path to a value with its parts joined by periods. Array elements are named by
+// their index, so network.interfaces.eth0.addresses.0 is the first address.
+// Empty hashes and arrays are kept as they are, so their keys don't vanish.
+func FlattenAttributes(attrs map[string]interface{}) map[string]interface{} {
+	flattened := make(map[string]interface{})
+	for k, v := range attrs {
+		flattenAttr(k, v, flattened)
+	}
+	return flattened
+}
+
+func flattenAttr(key string, v interface{}, flattened map[string]interface{}) {
+	switch v := v.(type) {
+		case map[string]interface{}:
+			if len(v) == 0 {
+				flattened[key] = v
+			}
+			for k, u := range v {
+				flattenAttr(fmt.Sprintf("%s.%s", key, k), u, flattened)
+			}
+		case []interface{}:
+			if len(v) == 0 {
+				flattened[key] = v
+			}
+			for i, u := range v {
+				flattenAttr(fmt.Sprintf("%s.%d", key, i), u, flattened)
+			}
+		default:
+			flattened[key] = v
+	}
+}
+
 // Merge disparate data structures into a flat hash.
 func DeepMerge(key string, source interface{}) map[string]interface{} {
 	merger := make(map[string]interface{})
@@ -19,6 +19,7 @@ package util
 import (
 	"testing"
 	"net/http"
+	"time"
 )
 
 type testObj struct {
@@ -238,3 +239,130 @@ func TestValidateAsVersion(t *testing.T){
 		t.Errorf("Should have come back as 0.0.0, but it came back as %v", v)
 	}
 }
+
+func TestValidatePrereleaseVersion(t *testing.T){
+	for _, v := range []string{ "1.2.3-alpha", "1.2.3-rc.1", "1.2.3+build5", "1.2.3-beta.2+exp.sha.5114f85", "1.2.3-x-y-z.0" } {
+		if _, err := ValidateAsVersion(v); err != nil {
+			t.Errorf("%s should have passed version validation, but didn't", v)
+		}
+	}
+	for _, v := range []string{ "1.2-alpha", "1.2.3-", "1.2.3-alpha..1", "1.2.3-01", "1.2.3+" } {
+		if _, err := ValidateAsVersion(v); err == nil {
+			t.Errorf("%s should not have passed version validation, but did", v)
+		}
+	}
+}
+
+func TestValidateFourPartVersion(t *testing.T){
+	for _, v := range []string{ "2.1.0.3", "2.1.0.3.1", "2.1.0.3-rc.1" } {
+		if _, err := ValidateAsVersion(v); err != nil {
+			t.Errorf("%s should have passed version validation, but didn't", v)
+		}
+	}
+	for _, v := range []string{ "2.1.0.", "2..1", "2.1.0.x" } {
+		if _, err := ValidateAsVersion(v); err == nil {
+			t.Errorf("%s should not have passed version validation, but did", v)
+		}
+	}
+}
+
+func TestMergeAttributes(t *testing.T){
+	low := map[string]interface{}{ "a": "low", "b": map[string]interface{}{ "c": 1, "d": 2 } }
+	high := map[string]interface{}{ "a": "high", "b": map[string]interface{}{ "d": 3, "e": 4 } }
+	m := MergeAttributes(low, high)
+	if m["a"] != "high" {
+		t.Errorf("Merged attribute 'a' should have been 'high', got %v", m["a"])
+	}
+	b := m["b"].(map[string]interface{})
+	if b["c"] != 1 || b["d"] != 3 || b["e"] != 4 {
+		t.Errorf("Nested attributes were not merged correctly, got %v", b)
+	}
+	if low["b"].(map[string]interface{})["d"] != 2 {
+		t.Errorf("MergeAttributes modified its arguments")
+	}
+}
+
+func TestFlattenAttributes(t *testing.T) {
+	attrs := map[string]interface{}{
+		"network": map[string]interface{}{ "interfaces": map[string]interface{}{ "eth0": map[string]interface{}{ "addresses": []interface{}{ "10.0.0.1", "10.0.0.2" } } } },
+		"platform": "debian",
+		"empty": map[string]interface{}{},
+	}
+	f := FlattenAttributes(attrs)
+	if f["network.interfaces.eth0.addresses.0"] != "10.0.0.1" || f["network.interfaces.eth0.addresses.1"] != "10.0.0.2" {
+		t.Errorf("Array elements were not flattened with their indices, got %v", f)
+	}
+	if f["platform"] != "debian" {
+		t.Errorf("Top level value was not kept, got %v", f["platform"])
+	}
+	if _, ok := f["empty"]; !ok || len(f) != 4 {
+		t.Errorf("Expected 4 flattened keys including the empty hash, got %v", f)
+	}
+}
+
+func TestGerrorCodes(t *testing.T) {
+	err := Errorf("no such thing")
+	err.SetStatus(http.StatusNotFound)
+	if err.Code() != "not_found" {
+		t.Errorf("Expected code not_found from a 404, got %s", err.Code())
+	}
+	err.SetCode("missing_thing")
+	if err.Code() != "missing_thing" {
+		t.Errorf("Expected the code that was set, missing_thing, got %s", err.Code())
+	}
+	if c := StatusCode(http.StatusMethodNotAllowed); c != "method_not_allowed" {
+		t.Errorf("Expected code method_not_allowed from a 405, got %s", c)
+	}
+
+	_, verr := ValidateRunList([]string{ "recipe[" })
+	if verr == nil {
+		t.Fatalf("A bad run list passed validation")
+	}
+	if verr.Field() != "run_list" || verr.Code() != "invalid_field" || verr.Status() != http.StatusBadRequest {
+		t.Errorf("Expected an invalid_field error for run_list with status 400, got %s for '%s' with status %d", verr.Code(), verr.Field(), verr.Status())
+	}
+}
+
+func TestValidateAsConstraint(t *testing.T){
+	for _, c := range []string{ "= 1.0.0", ">= 1.2", "~> 1.2.3", "!= 1.4.2" } {
+		if _, err := ValidateAsConstraint(c); err != nil {
+			t.Errorf("%s should have passed constraint validation, but didn't", c)
+		}
+	}
+	for _, c := range []string{ "1.0.0", "!! 1.0.0", "!= foo" } {
+		if _, err := ValidateAsConstraint(c); err == nil {
+			t.Errorf("%s should not have passed constraint validation, but did", c)
+		}
+	}
+}
+
+func TestParseDuration(t *testing.T){
+	durs := map[string]time.Duration{ "1d": 24 * time.Hour, "7d": 168 * time.Hour, "90m": 90 * time.Minute }
+	for s, want := range durs {
+		if d, err := ParseDuration(s); err != nil || d != want {
+			t.Errorf("Expected %s to parse as %v, got %v (%v)", s, want, d, err)
+		}
+	}
+	for _, s := range []string{ "d", "1.5d", "-1d", "tomorrow" } {
+		if _, err := ParseDuration(s); err == nil {
+			t.Errorf("Duration %s should not have parsed", s)
+		}
+	}
+}
+
+func TestTimeBuckets(t *testing.T){
+	counts := map[int64]int{ 5: 2, 1: 7, 3: 1 }
+	buckets := TimeBuckets(counts, 3600)
+	if len(buckets) != 3 {
+		t.Fatalf("Expected 3 buckets, got %d", len(buckets))
+	}
+	for i, b := range []int64{ 1, 3, 5 } {
+		start := time.Unix(b * 3600, 0).UTC()
+		if !buckets[i].BucketStart.Equal(start) || buckets[i].Count != counts[b] {
+			t.Errorf("Bucket %d was %v with %d, expected %v with %d", i, buckets[i].BucketStart, buckets[i].Count, start, counts[b])
+		}
+	}
+	if len(TimeBuckets(map[int64]int{}, 60)) != 0 {
+		t.Errorf("Empty counts should have given no buckets")
+	}
+}
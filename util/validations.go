@@ -22,6 +22,7 @@ import (
 	"strings"
 	"strconv"
 	"github.com/ctdk/goiardi/filestore"
+	"github.com/ctdk/goiardi/config"
 	"net/http"
 )
 
@@ -86,31 +87,36 @@ func ValidateAsFieldString(str interface{}) (string, Gerror){
 func ValidateAsVersion(ver interface{}) (string, Gerror){
 	switch ver := ver.(type) {
 		case string:
-			valid_ver := regexp.MustCompile(`^(\d+)\.(\d+)(\.?)(\d+)?$`)
+			/* Versions have two or more numbers, like 1.2, 1.2.3,
+			 * or 2.1.0.3. SemVer pre-release and build parts are
+			 * allowed too, but only after at least x.y.z. */
+			valid_ver := regexp.MustCompile(`^(\d+(?:\.\d+)+)(?:-([0-9A-Za-z-]+(?:\.[0-9A-Za-z-]+)*))?(?:\+([0-9A-Za-z-]+(?:\.[0-9A-Za-z-]+)*))?$`)
 			inspect_ver := valid_ver.FindStringSubmatch(ver)
-
-			if inspect_ver != nil && ver != "0.0"{
-				nums := []int{ 1, 2, 4 }
-				for _, n := range nums {
-					/* #4 might not exist, but 1 and 2 must.
-					 * The regexp doesn't match if they
-					 * don't. */
-					if n > len(inspect_ver) || inspect_ver[n] == "" && n == 4 {
-						break
-					}
-					if v, err := strconv.ParseInt(inspect_ver[n], 10, 64); err != nil {
-						verr := Errorf(err.Error())
+			if inspect_ver == nil {
+				verr := Errorf("Invalid version number")
+				return "", verr
+			}
+			nums := strings.Split(inspect_ver[1], ".")
+			if len(nums) < 3 && (inspect_ver[2] != "" || inspect_ver[3] != "") {
+				verr := Errorf("Invalid version number")
+				return "", verr
+			}
+			/* Numeric pre-release identifiers can't have leading
+			 * zeros. */
+			if inspect_ver[2] != "" {
+				for _, p := range strings.Split(inspect_ver[2], ".") {
+					if len(p) > 1 && p[0] == '0' && strings.Trim(p, "0123456789") == "" {
+						verr := Errorf("Invalid version number")
 						return "", verr
-					} else {
-						if v < 0 {
-							verr := Errorf("Invalid version number")
-							return "", verr
-						}
 					}
 				}
-			} else {
-				verr := Errorf("Invalid version number")
-				return "", verr
+			}
+			/* Every number has to fit in an int64. */
+			for _, n := range nums {
+				if _, err := strconv.ParseInt(n, 10, 64); err != nil {
+					verr := Errorf(err.Error())
+					return "", verr
+				}
 			}
 
 			return ver, nil
@@ -125,17 +131,62 @@ func ValidateAsVersion(ver interface{}) (string, Gerror){
 func ValidateAttributes(key string, attrs interface{}) (map[string]interface{}, Gerror){
 	switch attrs := attrs.(type) {
 		case map[string]interface{}:
+			if max := config.Config.MaxAttributeDepth; max > 0 && attrDepth(attrs, max + 1) > max {
+				err := FieldErrorf(key, "Field '%s' is nested more than %d levels deep", key, max)
+				return nil, err
+			}
 			return attrs, nil
 		case nil:
 			/* Separate to do more validations above */
 			nil_attrs := make(map[string]interface{})
 			return nil_attrs, nil
 		default:
-			err := Errorf("Field '%s' is not a hash", key)
+			err := FieldErrorf(key, "Field '%s' is not a hash", key)
 			return nil, err
 	}
 }
 
+func metadataEmpty(v interface{}) bool {
+	switch v := v.(type) {
+		case nil:
+			return true
+		case string:
+			return strings.TrimSpace(v) == ""
+		case map[string]interface{}:
+			return len(v) == 0
+		case []interface{}:
+			return len(v) == 0
+	}
+	return false
+}
+
+/* How deeply nested an attribute value is, counting each hash or array as a
+ * level. Stops looking once it gets to limit, so absurdly deep values don't
+ * take forever to check. */
+func attrDepth(v interface{}, limit int) int {
+	if limit <= 0 {
+		return 0
+	}
+	max := 0
+	switch v := v.(type) {
+		case map[string]interface{}:
+			for _, i := range v {
+				if d := attrDepth(i, limit - 1); d > max {
+					max = d
+				}
+			}
+		case []interface{}:
+			for _, i := range v {
+				if d := attrDepth(i, limit - 1); d > max {
+					max = d
+				}
+			}
+		default:
+			return 0
+	}
+	return max + 1
+}
+
 func ValidateCookbookDivision(dname string, div interface{}) ([]map[string]interface{}, Gerror) {
 	switch div := div.(type) {
 		case []interface{}:
@@ -177,7 +228,7 @@ func ValidateCookbookDivision(dname string, div interface{}) ([]map[string]inter
 			// d := make([]map[string]interface{}, 0)
 			return nil, nil
 		default:
-			err := Errorf("Field '%s' invalid", dname)
+			err := FieldErrorf(dname, "Field '%s' invalid", dname)
 			return nil, err
 	}
 }
@@ -221,7 +272,7 @@ func ValidateCookbookMetadata(mdata interface{}) (map[string]interface{}, Gerror
 				 * give it the error message it wants first
 				 * however. */
 				
-				err := Errorf("Field 'metadata.version' missing")
+				err := FieldErrorf("metadata.version", "Field 'metadata.version' missing")
 
 				return nil, err
 			}
@@ -235,17 +286,17 @@ func ValidateCookbookMetadata(mdata interface{}) (map[string]interface{}, Gerror
 				switch mv := mv.(type) {
 					case string:
 						if _, merr := ValidateAsVersion(mv); merr != nil {
-						merr := Errorf("Field 'metadata.version' invalid")
+						merr := FieldErrorf("metadata.version", "Field 'metadata.version' invalid")
 						return nil, merr
 						}
 					case nil:
 						;
 					default:
-						err := Errorf("Field 'metadata.version' invalid")
+						err := FieldErrorf("metadata.version", "Field 'metadata.version' invalid")
 						return nil, err
 				}
 			} else {
-				err := Errorf("Field 'metadata.version' missing")
+				err := FieldErrorf("metadata.version", "Field 'metadata.version' missing")
 				return nil, err
 			}
 
@@ -253,7 +304,7 @@ func ValidateCookbookMetadata(mdata interface{}) (map[string]interface{}, Gerror
 			 * elsewhere. */
 			strchk := []string{ "maintainer", "name", "description", "maintainer_email", "long_description", "license" }
 			for _, v := range strchk {
-				err := Errorf("Field 'metadata.%s' invalid", v)
+				err := FieldErrorf("metadata." + v, "Field 'metadata.%s' invalid", v)
 				switch sv := mdata[v].(type) {
 					case string:
 						if v == "name" && !ValidateEnvName(sv) {
@@ -271,7 +322,7 @@ func ValidateCookbookMetadata(mdata interface{}) (map[string]interface{}, Gerror
 			/* hash checks */
 			hashchk := []string{ "platforms", "dependencies", "recommendations", "suggestions", "conflicting", "replacing", "groupings" }
 			for _, v := range hashchk {
-				err := Errorf("Field 'metadata.%s' invalid", v)
+				err := FieldErrorf("metadata." + v, "Field 'metadata.%s' invalid", v)
 				switch hv := mdata[v].(type) {
 					case map[string]interface{}:
 						for _, j := range hv {
@@ -304,6 +355,18 @@ func ValidateCookbookMetadata(mdata interface{}) (map[string]interface{}, Gerror
 				}
 			}
 
+			/* Fields this server insists on, if any. */
+			missing := make([]string, 0)
+			for _, v := range config.Config.RequiredMetadata {
+				if metadataEmpty(mdata[v]) {
+					missing = append(missing, v)
+				}
+			}
+			if len(missing) > 0 {
+				err := Errorf("Required metadata fields missing or empty: %s", strings.Join(missing, ", "))
+				return nil, err
+			}
+
 			return mdata, nil
 		default:
 			err := Errorf("bad metadata: chng msg")
@@ -315,7 +378,7 @@ func ValidateAsConstraint(t interface{}) (bool, Gerror) {
 	err := Errorf("Invalid constraint")
 	switch t := t.(type) {
 		case string:
-			cr := regexp.MustCompile(`^([<>=~]{1,2}) (.*)`)
+			cr := regexp.MustCompile(`^([<>=~]{1,2}|!=) (.*)`)
 			c_item := cr.FindStringSubmatch(t)
 			if c_item != nil {
 				ver := c_item[2]
@@ -339,7 +402,7 @@ func ValidateRunList(rl interface{}) ([]string, Gerror) {
 					return nil, err
 				} else {
 					if j == "" {
-						err := Errorf("Field 'run_list' is not a valid run list")
+						err := FieldErrorf("run_list", "Field 'run_list' is not a valid run list")
 						return nil, err
 					} 
 					rl[i] = j
@@ -369,7 +432,7 @@ func ValidateRunList(rl interface{}) ([]string, Gerror) {
 
 func validateRLItem(item string) (string, Gerror){
 	/* There's a few places this might be used. */
-	err := Errorf("Field 'run_list' is not a valid run list")
+	err := FieldErrorf("run_list", "Field 'run_list' is not a valid run list")
 
 	if item == "" {
 		return "", err
@@ -429,7 +492,7 @@ func validateRecipeName(name string) bool {
 		h := strings.Split(name, "@")
 		name = h[0]
 		version := h[1]
-		valid_ver := regexp.MustCompile(`^\d+\.\d+(\.\d+)?$`)
+		valid_ver := regexp.MustCompile(`^\d+(\.\d+)+$`)
 		if !valid_ver.MatchString(version) {
 			return false
 		}
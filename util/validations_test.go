@@ -19,6 +19,7 @@ package util
 
 import (
 	"testing"
+	"github.com/ctdk/goiardi/config"
 )
 
 func TestValidateRunList(t *testing.T) {
@@ -26,6 +27,7 @@ func TestValidateRunList(t *testing.T) {
 		"recipe[qualified_recipe_name]",
 		"recipe[versioned_qualified_recipe_name@1.0.0]",
 		"recipe[versioned_qualified_recipe_name2@1.0]",
+		"recipe[versioned_qualified_recipe_name3@2.1.0.3]",
 		"recipe[qualified_recipe_name::include.period]",
 		"recipe[versioned_qualified_recipe_name::include.period@1.0.0]",
 		"role[qualified_role_name]",
@@ -42,7 +44,7 @@ func TestValidateRunList(t *testing.T) {
 		"Recipe[recipe_name]",
 		"roles[role_name]",
 		"recipe[invalid_version@1]",
-		"recipe[invalid_version@1.2.3.4]",
+		"recipe[invalid_version@1.2.]",
 		"recipe[invalid_version@abc]",
 	}
 	for _, falseFriend := range falseFriends {
@@ -51,3 +53,39 @@ func TestValidateRunList(t *testing.T) {
 		}
 	}
 }
+
+func TestValidateAttributesDepth(t *testing.T) {
+	config.Config.MaxAttributeDepth = 3
+	defer func() { config.Config.MaxAttributeDepth = 0 }()
+	ok := map[string]interface{}{ "a": map[string]interface{}{ "b": []interface{}{ "c" } } }
+	if _, err := ValidateAttributes("default", ok); err != nil {
+		t.Errorf("Attributes nested 3 levels deep should have passed, but got %s", err.Error())
+	}
+	deep := map[string]interface{}{ "a": map[string]interface{}{ "b": []interface{}{ map[string]interface{}{ "c": "d" } } } }
+	if _, err := ValidateAttributes("default", deep); err == nil {
+		t.Errorf("Attributes nested 4 levels deep should have failed, but didn't")
+	}
+}
+
+func TestRequiredMetadata(t *testing.T) {
+	config.Config.RequiredMetadata = []string{ "maintainer", "license", "description" }
+	defer func() { config.Config.RequiredMetadata = nil }()
+	full := func() map[string]interface{} {
+		return map[string]interface{}{ "name": "foo", "version": "1.0.0", "maintainer": "Someone", "license": "Apache 2.0", "description": "Does foo" }
+	}
+	if _, err := ValidateCookbookMetadata(full()); err != nil {
+		t.Errorf("Metadata with all required fields should have passed, but got %s", err.Error())
+	}
+	for _, f := range config.Config.RequiredMetadata {
+		m := full()
+		delete(m, f)
+		if _, err := ValidateCookbookMetadata(m); err == nil || err.Error() != "Required metadata fields missing or empty: " + f {
+			t.Errorf("Metadata missing %s should have failed, but didn't", f)
+		}
+		m = full()
+		m[f] = "  "
+		if _, err := ValidateCookbookMetadata(m); err == nil {
+			t.Errorf("Metadata with an empty %s should have failed, but didn't", f)
+		}
+	}
+}